@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	linux "netwatchd/netstat"
+)
+
+// AccountedClient is a client whose usage is reported by the AP or RADIUS
+// server itself, so it can be compared with what the capture saw
+type AccountedClient struct {
+	MAC      string
+	User     string
+	IP       string
+	Source   string // "hostapd" or "radius"
+	InBytes  uint64 // from the client
+	OutBytes uint64 // to the client
+	Captured int64  // bytes seen for the client's IP in the capture
+	baseIn   uint64
+	baseOut  uint64
+	seen     bool
+}
+
+// Accounting collects authoritative per-client usage. It is guarded by the MonitoringData mutex.
+type Accounting struct {
+	clients map[string]*AccountedClient
+}
+
+func NewAccounting() *Accounting {
+	return &Accounting{clients: make(map[string]*AccountedClient)}
+}
+
+// Recording cumulative session counters. The first value seen for a client
+// is the baseline unless the session started during the window.
+func (a *Accounting) update(source, mac, user, ip string, in, out uint64, newSession bool) {
+	mac = strings.ToLower(strings.ReplaceAll(mac, "-", ":"))
+	c, ok := a.clients[mac]
+	if !ok {
+		c = &AccountedClient{MAC: mac, Source: source}
+		a.clients[mac] = c
+	}
+	if user != "" {
+		c.User = user
+	}
+	if ip != "" {
+		c.IP = ip
+	}
+	if !c.seen {
+		c.seen = true
+		if !newSession {
+			c.baseIn, c.baseOut = in, out
+		}
+	}
+	c.InBytes = counterDelta(c.baseIn, in)
+	c.OutBytes = counterDelta(c.baseOut, out)
+}
+
+// Polling hostapd control sockets at start and end of the window
+func collectHostapdAccounting(ctx context.Context, data *MonitoringData, dir string) {
+	poll := func(newSession bool) {
+		sockets, err := linux.GetHostapdSockets(dir)
+		if err != nil {
+			logger.Error("failed to list hostapd sockets", "dir", dir, "err", err)
+			return
+		}
+		for _, socket := range sockets {
+			stations, err := linux.GetHostapdStations(socket)
+			if err != nil {
+				logger.Warn("failed to query hostapd", "socket", socket, "err", err)
+				continue
+			}
+			data.mu.Lock()
+			for _, st := range stations {
+				// hostapd rx is traffic from the client
+				data.accounting.update("hostapd", st.MAC, st.User, "", st.RxBytes, st.TxBytes, newSession)
+			}
+			data.mu.Unlock()
+		}
+	}
+
+	poll(false)
+	<-ctx.Done()
+	// Clients that associated during the window count from zero
+	poll(true)
+}
+
+// RADIUS codes and attributes used for accounting (RFC 2866)
+const (
+	radiusAccountingRequest  = 4
+	radiusAccountingResponse = 5
+
+	radiusUserName            = 1
+	radiusFramedIP            = 8
+	radiusCallingStationID    = 31
+	radiusAcctStatusType      = 40
+	radiusAcctInputOctets     = 42
+	radiusAcctOutputOctets    = 43
+	radiusAcctInputGigawords  = 52
+	radiusAcctOutputGigawords = 53
+
+	radiusStatusStart = 1
+)
+
+// Receiving RADIUS accounting records, as a secondary accounting server the NAS also reports to
+func listenRADIUSAccounting(ctx context.Context, data *MonitoringData, addr, secret string) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		logger.Error("failed to listen for RADIUS accounting", "addr", addr, "err", err)
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 4096)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		reply, err := handleRADIUSAccounting(data, buf[:n], secret)
+		if err != nil {
+			logger.Warn("invalid RADIUS accounting request", "from", from, "err", err)
+			continue
+		}
+		conn.WriteTo(reply, from)
+	}
+}
+
+func handleRADIUSAccounting(data *MonitoringData, pkt []byte, secret string) ([]byte, error) {
+	if len(pkt) < 20 || pkt[0] != radiusAccountingRequest {
+		return nil, fmt.Errorf("not an Accounting-Request")
+	}
+	length := int(binary.BigEndian.Uint16(pkt[2:4]))
+	if length < 20 || length > len(pkt) {
+		return nil, fmt.Errorf("bad length %d", length)
+	}
+	pkt = pkt[:length]
+
+	// The request authenticator is MD5 over the packet with a zero authenticator plus the secret
+	h := md5.New()
+	h.Write(pkt[:4])
+	h.Write(make([]byte, 16))
+	h.Write(pkt[20:])
+	h.Write([]byte(secret))
+	if !bytes.Equal(h.Sum(nil), pkt[4:20]) {
+		return nil, fmt.Errorf("bad authenticator, check -radius-secret")
+	}
+
+	var user, mac, ip string
+	var status uint32
+	var in, out, inGiga, outGiga uint64
+	for attrs := pkt[20:]; len(attrs) >= 2; {
+		t, l := attrs[0], int(attrs[1])
+		if l < 2 || l > len(attrs) {
+			return nil, fmt.Errorf("bad attribute length")
+		}
+		v := attrs[2:l]
+		attrs = attrs[l:]
+		switch t {
+		case radiusUserName:
+			user = string(v)
+		case radiusCallingStationID:
+			mac = string(v)
+		case radiusFramedIP:
+			if len(v) == 4 {
+				ip = net.IP(v).String()
+			}
+		}
+		if len(v) != 4 {
+			continue
+		}
+		n := binary.BigEndian.Uint32(v)
+		switch t {
+		case radiusAcctStatusType:
+			status = n
+		case radiusAcctInputOctets:
+			in = uint64(n)
+		case radiusAcctOutputOctets:
+			out = uint64(n)
+		case radiusAcctInputGigawords:
+			inGiga = uint64(n)
+		case radiusAcctOutputGigawords:
+			outGiga = uint64(n)
+		}
+	}
+
+	if mac != "" {
+		data.mu.Lock()
+		// Input is from the client, gigawords carry the upper 32 bits
+		data.accounting.update("radius", mac, user, ip, inGiga<<32|in, outGiga<<32|out, status == radiusStatusStart)
+		data.mu.Unlock()
+	}
+
+	// Accounting-Response authenticator covers the request authenticator and the secret
+	reply := make([]byte, 20)
+	reply[0] = radiusAccountingResponse
+	reply[1] = pkt[1]
+	binary.BigEndian.PutUint16(reply[2:4], 20)
+	h = md5.New()
+	h.Write(reply[:4])
+	h.Write(pkt[4:20])
+	h.Write([]byte(secret))
+	copy(reply[4:20], h.Sum(nil))
+	return reply, nil
+}
+
+// Clients sorted by accounted bytes, with capture bytes filled in. Callers hold data.mu.
+func (data *MonitoringData) accountedClients() []AccountedClient {
+	hosts := make(map[string]Host)
+	for _, h := range data.inventory.Hosts() {
+		hosts[h.IP] = h
+		if h.MAC != "" {
+			hosts[strings.ToLower(h.MAC)] = h
+		}
+	}
+
+	var clients []AccountedClient
+	for _, c := range data.accounting.clients {
+		cc := *c
+		h, ok := hosts[cc.IP]
+		if !ok {
+			h, ok = hosts[cc.MAC]
+		}
+		if ok {
+			cc.Captured = h.Bytes
+			if cc.IP == "" {
+				cc.IP = h.IP
+			}
+		}
+		clients = append(clients, cc)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].InBytes+clients[i].OutBytes > clients[j].InBytes+clients[j].OutBytes
+	})
+	return clients
+}
+
+func printAccountingReport(data *MonitoringData) {
+	if data.accounting == nil || len(data.accounting.clients) == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("CLIENT ACCOUNTING")
+	for _, c := range data.accountedClients() {
+		who := c.MAC
+		if c.User != "" {
+			who += " " + c.User
+		}
+		if c.IP != "" {
+			who += " (" + c.IP + ")"
+		}
+		fmt.Printf("%-45s up %.2f MB | down %.2f MB | captured %.2f MB [%s]\n", who,
+			float64(c.InBytes)/(1024*1024), float64(c.OutBytes)/(1024*1024), float64(c.Captured)/(1024*1024), c.Source)
+	}
+}
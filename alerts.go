@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Alert is a notable event raised while monitoring
+type Alert struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	Severity string    `json:"severity"`
+	Message  string    `json:"message"`
+	Key      string    `json:"key"`                // groups an alert with its resolution
+	Resolved bool      `json:"resolved,omitempty"` // the condition behind Key has cleared
+	Silenced string    `json:"silenced,omitempty"` // maintenance window or silence that muted it
+	// Remote addresses behind the alert, what -block-kinds blocks
+	Offenders []string `json:"offenders,omitempty"`
+}
+
+// Recording an alert and printing it right away. Callers hold data.mu.
+func (data *MonitoringData) addAlert(kind, message string, at time.Time) {
+	data.raiseAlert(kind, "", message, at)
+}
+
+// Raising an alert that names the addresses causing it. Callers hold data.mu.
+func (data *MonitoringData) addOffenderAlert(kind, message string, offenders []string, at time.Time) {
+	sum := sha1.Sum([]byte(message))
+	data.recordAlert(Alert{Time: at, Kind: kind, Severity: severityFor(kind), Message: message,
+		Key: kind + "/" + hex.EncodeToString(sum[:6]), Offenders: offenders})
+}
+
+// Raising an alert that a later resolveAlert with the same key clears.
+// Without a key every distinct message is its own alert. Callers hold data.mu.
+func (data *MonitoringData) raiseAlert(kind, key, message string, at time.Time) {
+	if key == "" {
+		sum := sha1.Sum([]byte(message))
+		key = hex.EncodeToString(sum[:6])
+	}
+	data.recordAlert(Alert{Time: at, Kind: kind, Severity: severityFor(kind), Message: message, Key: kind + "/" + key})
+}
+
+// Recording that the alert raised with key has cleared. Callers hold data.mu.
+func (data *MonitoringData) resolveAlert(kind, key, message string, at time.Time) {
+	data.recordAlert(Alert{Time: at, Kind: kind, Severity: severityFor(kind), Message: message, Key: kind + "/" + key, Resolved: true})
+}
+
+func (data *MonitoringData) recordAlert(a Alert) {
+	// Silenced alerts are still recorded, they just don't notify
+	a.Silenced = data.silencer.Silenced(a)
+	data.alerts = append(data.alerts, a)
+	data.router.Notify(a)
+	data.journal.Alert(a)
+	switch {
+	case a.Silenced != "":
+		fmt.Printf("alert [%s] silenced by %s: %s\n", a.Kind, a.Silenced, a.Message)
+	case a.Resolved:
+		fmt.Printf("RESOLVED [%s]: %s\n", a.Kind, a.Message)
+	default:
+		fmt.Printf("ALERT [%s]: %s\n", a.Kind, a.Message)
+	}
+	data.blocker.Handle(a)
+}
+
+func printAlertReport(alerts []Alert) {
+	if len(alerts) == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("ALERTS")
+	silenced := 0
+	for _, a := range alerts {
+		note := ""
+		if a.Resolved {
+			note = " (resolved)"
+		}
+		if a.Silenced != "" {
+			note += " (silenced by " + a.Silenced + ")"
+			silenced++
+		}
+		fmt.Printf("%s [%s] %s%s\n", a.Time.Format("15:04:05"), a.Kind, a.Message, note)
+	}
+	if silenced > 0 {
+		fmt.Printf("%d of %d alerts were silenced\n", silenced, len(alerts))
+	}
+}
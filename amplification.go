@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// UDP services that answer a small request with a much larger response
+var amplificationPorts = map[int]string{
+	19:    "chargen",
+	53:    "DNS",
+	123:   "NTP",
+	161:   "SNMP",
+	389:   "CLDAP",
+	1900:  "SSDP",
+	3702:  "WS-Discovery",
+	11211: "memcached",
+}
+
+const (
+	// Responses at least this many times larger than the requests are amplification
+	amplificationFactor = 10
+	// A destination port hit by this many sources, with few packets each, is a spoofed flood
+	floodMinSources   = 1000
+	floodMinPackets   = 10000
+	floodMaxPerSource = 3
+	// Bounding memory when the flood itself is the traffic
+	floodMaxTargets = 4096
+	floodMaxSources = 2 * floodMinSources
+)
+
+// AmplificationFinding is one reflection or flood seen in a bucket
+type AmplificationFinding struct {
+	Time       time.Time `json:"time"`
+	Kind       string    `json:"kind"` // amplification, reflection or flood
+	Service    string    `json:"service"`
+	Target     string    `json:"target"`
+	Sources    int       `json:"sources"` // reflectors, or flood sources
+	Packets    int       `json:"packets"`
+	Bytes      int64     `json:"bytes"`
+	Requests   int64     `json:"request_bytes,omitempty"`
+	LocalHosts []string  `json:"local_reflectors,omitempty"`
+	Reflectors []string  `json:"reflectors,omitempty"` // remote reflectors of an unsolicited reflection
+}
+
+// AmplificationDetector looks for small UDP requests to amplification ports
+// answered by large responses, responses nobody asked for, and UDP floods from
+// many sources. It works per bucket and is guarded by the MonitoringData mutex.
+type AmplificationDetector struct {
+	minBytes int64
+	flows    map[ampKey]*ampFlow
+	targets  map[string]*floodTarget // keyed by destination ip:port
+	Findings []AmplificationFinding
+}
+
+// The victim is the address the responses go to, the requests claim to come from it
+type ampKey struct {
+	service   string
+	reflector string
+	victim    string
+}
+
+type ampFlow struct {
+	reqBytes    int64
+	respPackets int
+	respBytes   int64
+}
+
+type floodTarget struct {
+	sources map[string]int
+	packets int
+	bytes   int64
+}
+
+func NewAmplificationDetector(minBytes int64) *AmplificationDetector {
+	return &AmplificationDetector{minBytes: minBytes, flows: make(map[ampKey]*ampFlow), targets: make(map[string]*floodTarget)}
+}
+
+func (d *AmplificationDetector) Observe(p *netwatch.Packet) {
+	if d == nil || p.Transport != "udp" || p.SrcIP == "" {
+		return
+	}
+	if service := amplificationPorts[p.SrcPort]; service != "" {
+		f := d.flow(ampKey{service, p.SrcIP, p.DstIP})
+		f.respPackets++
+		f.respBytes += int64(p.Length)
+	} else if service := amplificationPorts[p.DstPort]; service != "" {
+		d.flow(ampKey{service, p.DstIP, p.SrcIP}).reqBytes += int64(p.Length)
+	}
+
+	key := fmt.Sprintf("%s:%d", p.DstIP, p.DstPort)
+	t := d.targets[key]
+	if t == nil {
+		if len(d.targets) >= floodMaxTargets {
+			return
+		}
+		t = &floodTarget{sources: make(map[string]int)}
+		d.targets[key] = t
+	}
+	t.packets++
+	t.bytes += int64(p.Length)
+	if _, ok := t.sources[p.SrcIP]; ok || len(t.sources) < floodMaxSources {
+		t.sources[p.SrcIP]++
+	}
+}
+
+func (d *AmplificationDetector) flow(k ampKey) *ampFlow {
+	f := d.flows[k]
+	if f == nil {
+		f = &ampFlow{}
+		d.flows[k] = f
+	}
+	return f
+}
+
+// Closing the bucket ending at now, returning what it found
+func (d *AmplificationDetector) Check(now time.Time) []AmplificationFinding {
+	if d == nil {
+		return nil
+	}
+	var found []AmplificationFinding
+
+	// Summing the reflectors per service and victim
+	type victim struct{ service, addr string }
+	sums := make(map[victim]*AmplificationFinding)
+	for k, f := range d.flows {
+		if f.respBytes == 0 {
+			continue
+		}
+		v := victim{k.service, k.victim}
+		s := sums[v]
+		if s == nil {
+			s = &AmplificationFinding{Time: now, Service: k.service, Target: k.victim}
+			sums[v] = s
+		}
+		s.Sources++
+		s.Packets += f.respPackets
+		s.Bytes += f.respBytes
+		s.Requests += f.reqBytes
+		if isLocalIP(k.reflector) {
+			s.LocalHosts = append(s.LocalHosts, k.reflector)
+		} else {
+			s.Reflectors = append(s.Reflectors, k.reflector)
+		}
+	}
+	for _, s := range sums {
+		switch {
+		case s.Bytes < d.minBytes:
+			continue
+		case s.Requests == 0:
+			s.Kind = "reflection"
+			sort.Strings(s.Reflectors)
+		case s.Bytes >= amplificationFactor*s.Requests:
+			s.Kind = "amplification"
+			// The victim asked for these, the reflectors did nothing wrong
+			s.Reflectors = nil
+		default:
+			continue
+		}
+		sort.Strings(s.LocalHosts)
+		found = append(found, *s)
+	}
+
+	for key, t := range d.targets {
+		if t.packets < floodMinPackets || len(t.sources) < floodMinSources || t.packets > floodMaxPerSource*len(t.sources) {
+			continue
+		}
+		found = append(found, AmplificationFinding{Time: now, Kind: "flood", Service: "UDP", Target: key,
+			Sources: len(t.sources), Packets: t.packets, Bytes: t.bytes})
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].Bytes > found[j].Bytes })
+	d.Findings = append(d.Findings, found...)
+	d.flows = make(map[ampKey]*ampFlow)
+	d.targets = make(map[string]*floodTarget)
+	return found
+}
+
+func (f AmplificationFinding) String() string {
+	var msg string
+	switch f.Kind {
+	case "amplification":
+		msg = fmt.Sprintf("%s amplification towards %s: %d reflector(s) answered %.1f KB of requests with %.2f MB (%.0fx)",
+			f.Service, f.Target, f.Sources, float64(f.Requests)/1024, float64(f.Bytes)/(1024*1024), float64(f.Bytes)/float64(f.Requests))
+	case "reflection":
+		msg = fmt.Sprintf("%s reflection at %s: %d reflector(s) sent %.2f MB of responses to requests never seen, their source was likely spoofed",
+			f.Service, f.Target, f.Sources, float64(f.Bytes)/(1024*1024))
+	default:
+		return fmt.Sprintf("UDP flood on %s: %d packets | %.2f MB from %d sources, %.1f packets each, sources are likely spoofed",
+			f.Target, f.Packets, float64(f.Bytes)/(1024*1024), f.Sources, float64(f.Packets)/float64(f.Sources))
+	}
+	if len(f.LocalHosts) > 0 {
+		msg += fmt.Sprintf("; reflectors on this network: %s", strings.Join(f.LocalHosts, ", "))
+	}
+	return msg
+}
+
+func (d *AmplificationDetector) reset() {
+	if d == nil {
+		return
+	}
+	d.Findings = nil
+}
+
+func printAmplificationReport(d *AmplificationDetector, bucket time.Duration) {
+	if d == nil || len(d.Findings) == 0 {
+		return
+	}
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("AMPLIFICATION AND FLOODS")
+	for _, f := range d.Findings {
+		fmt.Printf("%s ending %s: %s\n", bucketUnit(bucket), f.Time.Format("15:04:05"), f)
+	}
+}
+
+func (d *AmplificationDetector) findings() []AmplificationFinding {
+	if d == nil {
+		return nil
+	}
+	return d.Findings
+}
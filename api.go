@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiDefaultLimit = 100
+	apiMaxLimit     = 1000
+)
+
+// apiQuery is the paging, field selection and filters of the list endpoints:
+// limit, cursor, fields=a,b, ip (an address or CIDR), protocol, from and to
+type apiQuery struct {
+	limit    int
+	cursor   string // key of the last item on the previous page
+	fields   []string
+	ip       *net.IPNet
+	protocol string
+	from, to time.Time
+	values   url.Values
+}
+
+func parseAPIQuery(q url.Values) (*apiQuery, error) {
+	a := &apiQuery{limit: apiDefaultLimit, values: q}
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > apiMaxLimit {
+			return nil, fmt.Errorf("invalid limit %q, use 1 to %d", s, apiMaxLimit)
+		}
+		a.limit = n
+	}
+	if s := q.Get("cursor"); s != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor %q", s)
+		}
+		a.cursor = string(raw)
+	}
+	if s := q.Get("fields"); s != "" {
+		a.fields = strings.Split(s, ",")
+	}
+	if s := q.Get("ip"); s != "" {
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ip %q, use an address or CIDR", q.Get("ip"))
+		}
+		a.ip = n
+	}
+	a.protocol = strings.ToLower(q.Get("protocol"))
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &a.from}, {"to", &a.to}} {
+		if s := q.Get(p.name); s != "" {
+			t, err := parseExportTime(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", p.name, err)
+			}
+			*p.t = t
+		}
+	}
+	if !a.from.IsZero() && !a.to.IsZero() && !a.to.After(a.from) {
+		return nil, fmt.Errorf("to must be after from")
+	}
+	return a, nil
+}
+
+// Refusing the filters an endpoint has no data for
+func (a *apiQuery) only(endpoint string, filters ...string) error {
+	for _, f := range []string{"ip", "protocol", "from", "to", "kind", "severity"} {
+		if a.values.Get(f) != "" && !slices.Contains(filters, f) {
+			return fmt.Errorf("%s does not support the %s filter", endpoint, f)
+		}
+	}
+	return nil
+}
+
+func (a *apiQuery) matchIP(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && a.ip.Contains(ip)
+}
+
+// Whether [start, end] overlaps the from/to range
+func (a *apiQuery) inRange(start, end time.Time) bool {
+	return (a.from.IsZero() || !end.Before(a.from)) && (a.to.IsZero() || start.Before(a.to))
+}
+
+// apiPage is one page of a list endpoint. Total counts every item matching the
+// filters; next_cursor fetches the page after this one.
+type apiPage struct {
+	Items      []map[string]any `json:"items"`
+	Total      int              `json:"total"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// Paging items sorted by their unique keys. Items are turned into their JSON
+// objects so fields can pick from them.
+func (a *apiQuery) page(keys []string, items []any) (*apiPage, error) {
+	p := &apiPage{Items: []map[string]any{}, Total: len(items)}
+	start := 0
+	if a.cursor != "" {
+		start = sort.Search(len(keys), func(i int) bool { return keys[i] > a.cursor })
+	}
+	end := min(start+a.limit, len(items))
+	for _, item := range items[start:end] {
+		raw, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		var m map[string]any
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return nil, err
+		}
+		if len(a.fields) > 0 {
+			picked := make(map[string]any, len(a.fields))
+			for _, f := range a.fields {
+				if v, ok := m[f]; ok {
+					picked[f] = v
+				}
+			}
+			m = picked
+		}
+		p.Items = append(p.Items, m)
+	}
+	if end < len(items) {
+		p.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(keys[end-1]))
+	}
+	return p, nil
+}
+
+// A list endpoint over the running capture. list is called with data.mu held
+// and returns the matching items sorted by key.
+func apiHandler(data *MonitoringData, list func(*apiQuery) ([]string, []any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		q, err := parseAPIQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data.mu.Lock()
+		keys, items, err := list(q)
+		var page *apiPage
+		if err == nil {
+			page, err = q.page(keys, items)
+		}
+		data.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
+
+// Hosts of the inventory by address, filtered by ip and by being seen
+// between from and to
+func (data *MonitoringData) apiDevices(q *apiQuery) ([]string, []any, error) {
+	if err := q.only("devices", "ip", "from", "to"); err != nil {
+		return nil, nil, err
+	}
+	hosts := data.inventory.Hosts()
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].IP < hosts[j].IP })
+	var keys []string
+	var items []any
+	for _, h := range hosts {
+		if (q.ip != nil && !q.matchIP(h.IP)) || !q.inRange(h.FirstSeen, h.LastSeen) {
+			continue
+		}
+		keys = append(keys, h.IP)
+		items = append(items, h)
+	}
+	return keys, items, nil
+}
+
+// Alerts of the period in time order, filtered by an offender or address in
+// the message, time, kind and severity
+func (data *MonitoringData) apiAlerts(q *apiQuery) ([]string, []any, error) {
+	if err := q.only("alerts", "ip", "from", "to", "kind", "severity"); err != nil {
+		return nil, nil, err
+	}
+	kind, severity := q.values.Get("kind"), q.values.Get("severity")
+	var keys []string
+	var items []any
+	for i, a := range data.alerts {
+		if !q.inRange(a.Time, a.Time) || (kind != "" && a.Kind != kind) || (severity != "" && a.Severity != severity) {
+			continue
+		}
+		if q.ip != nil && !slices.ContainsFunc(a.Offenders, q.matchIP) && !strings.Contains(a.Message, q.values.Get("ip")) {
+			continue
+		}
+		keys = append(keys, fmt.Sprintf("%s/%08d", a.Time.UTC().Format("2006-01-02T15:04:05.000000000Z"), i))
+		items = append(items, a)
+	}
+	return keys, items, nil
+}
+
+// apiFlow is a traffic matrix cell, the traffic from one subnet to another
+type apiFlow struct {
+	Src       string         `json:"src"`
+	Dst       string         `json:"dst"`
+	Packets   int            `json:"packets"`
+	Bytes     int64          `json:"bytes"`
+	Protocols map[string]int `json:"protocols,omitempty"`
+}
+
+// Subnet pairs of the traffic matrix, filtered by a subnet overlapping ip
+// and by protocol
+func (data *MonitoringData) apiFlows(q *apiQuery) ([]string, []any, error) {
+	if err := q.only("flows", "ip", "protocol"); err != nil {
+		return nil, nil, err
+	}
+	if data.matrix == nil {
+		return nil, nil, nil
+	}
+	overlaps := func(subnet string) bool {
+		_, n, err := net.ParseCIDR(subnet)
+		return err == nil && (n.Contains(q.ip.IP) || q.ip.Contains(n.IP))
+	}
+	cells := data.matrix.Cells()
+	sort.Slice(cells, func(i, j int) bool { return cells[i].Src+" "+cells[i].Dst < cells[j].Src+" "+cells[j].Dst })
+	var keys []string
+	var items []any
+	for _, c := range cells {
+		if q.ip != nil && !overlaps(c.Src) && !overlaps(c.Dst) {
+			continue
+		}
+		if q.protocol != "" && c.Protocols[q.protocol] == 0 {
+			continue
+		}
+		keys = append(keys, c.Src+" "+c.Dst)
+		items = append(items, apiFlow{c.Src, c.Dst, c.Packets, c.Bytes, c.Protocols})
+	}
+	return keys, items, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+
+	"netwatchd/darwin"
+	linux "netwatchd/netstat"
+	"netwatchd/netwatch"
+	"netwatchd/pdh"
+)
+
+// statsProvider is the platform source of adapter byte counters. Both follow
+// the pdh lifecycle: Initialize, NewCounter, CollectData before each read,
+// GetValue, then Close and Cleanup.
+type statsProvider interface {
+	Initialize() error
+	Cleanup()
+	GetNetworkAdapters() ([]string, error)
+	NewCounter(adapterName, counterName string) (byteCounter, error)
+	CollectData() error
+}
+
+// pdhProvider holds its own query, so its counters are sampled on its
+// monitor's interval alone
+type pdhProvider struct {
+	query *pdh.Query
+}
+
+func (p *pdhProvider) Initialize() error {
+	q, err := pdh.OpenQuery()
+	if err != nil {
+		return err
+	}
+	p.query = q
+	return nil
+}
+
+func (p *pdhProvider) Cleanup()                            { p.query.Close() }
+func (*pdhProvider) GetNetworkAdapters() ([]string, error) { return pdh.GetNetworkAdapters() }
+func (p *pdhProvider) CollectData() error                  { return p.query.Collect() }
+
+func (p *pdhProvider) NewCounter(adapterName, counterName string) (byteCounter, error) {
+	c, err := p.query.AddAdapterCounter(adapterName, counterName)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// netstatProvider reads /proc/net/dev, its counters return the bytes since the previous read
+type netstatProvider struct{}
+
+func (netstatProvider) Initialize() error                     { return linux.Initialize() }
+func (netstatProvider) Cleanup()                              { linux.Cleanup() }
+func (netstatProvider) GetNetworkAdapters() ([]string, error) { return linux.GetNetworkAdapters() }
+func (netstatProvider) CollectData() error                    { return linux.CollectData() }
+
+func (netstatProvider) NewCounter(adapterName, counterName string) (byteCounter, error) {
+	c, err := linux.NewCounter(adapterName, counterName)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// darwinProvider reads the macOS if_data counters, like netstat they return the bytes since the previous read
+type darwinProvider struct{}
+
+func (darwinProvider) Initialize() error                     { return darwin.Initialize() }
+func (darwinProvider) Cleanup()                              { darwin.Cleanup() }
+func (darwinProvider) GetNetworkAdapters() ([]string, error) { return darwin.GetNetworkAdapters() }
+func (darwinProvider) CollectData() error                    { return darwin.CollectData() }
+
+func (darwinProvider) NewCounter(adapterName, counterName string) (byteCounter, error) {
+	c, err := darwin.NewCounter(adapterName, counterName)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Picking the byte counter backend for this OS, nil when there is none
+func newStatsProvider() statsProvider {
+	switch runtime.GOOS {
+	case "windows":
+		return &pdhProvider{}
+	case "linux":
+		return netstatProvider{}
+	case "darwin":
+		return darwinProvider{}
+	}
+	return nil
+}
+
+// Falling back to captured frame lengths for the bucket bytes. Live
+// captures tell sent from received by this host's addresses.
+func (data *MonitoringData) useCaptureBandwidth(live bool) {
+	local := make(map[string]bool)
+	if live {
+		addrs, _ := net.InterfaceAddrs()
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok {
+				local[ipnet.IP.String()] = true
+			}
+		}
+	}
+	data.mu.Lock()
+	data.captureBandwidth = true
+	data.localAddrs = local
+	data.mu.Unlock()
+}
+
+// Adding a packet to the bucket bytes, only what the capture filter lets
+// through is counted. Callers hold data.mu.
+func (data *MonitoringData) countCapturedBandwidth(p *netwatch.Packet) {
+	n := float64(p.Length)
+	data.currentBandwidth += n
+	switch {
+	case data.localAddrs[p.SrcIP]:
+		data.currentSent += n
+	case data.localAddrs[p.DstIP]:
+		data.currentRecv += n
+	}
+}
+
+// Describing the sent and received split of a bucket for the report, empty
+// without bandwidth monitoring
+func directionNote(sent, recv float64) string {
+	if sent == 0 && recv == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (↑ %.2f MB / ↓ %.2f MB)", sent/(1024*1024), recv/(1024*1024))
+}
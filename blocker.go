@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Block is one address blocked, or one that would have been in a dry run
+type Block struct {
+	IP     string    `json:"ip"`
+	Kind   string    `json:"kind"`
+	Reason string    `json:"reason"`
+	Start  time.Time `json:"start"`
+	Until  time.Time `json:"until"`
+	DryRun bool      `json:"dry_run,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// firewallBackend adds and removes blocks in the local firewall
+type firewallBackend interface {
+	setup() error
+	block(ip string, ttl time.Duration) error
+	unblock(ip string) error
+	teardown() error
+}
+
+// Blocker turns alerts naming an offending address into firewall blocks that
+// expire after a TTL. Allowlisted and own addresses are never blocked and the
+// number of active blocks is capped. Firewall commands run on its own
+// goroutine, so alerts raised with data.mu held never wait on them.
+type Blocker struct {
+	kinds   []string
+	allow   []*net.IPNet
+	own     map[string]bool
+	ttl     time.Duration
+	max     int
+	dryRun  bool
+	backend firewallBackend
+	queue   chan Block
+
+	mu      sync.Mutex
+	active  map[string]*Block
+	History []*Block
+}
+
+func NewBlocker(kinds, allow []string, ttl time.Duration, max int, dryRun bool) (*Blocker, error) {
+	b := &Blocker{kinds: kinds, ttl: ttl, max: max, dryRun: dryRun, own: make(map[string]bool),
+		queue: make(chan Block, 64), active: make(map[string]*Block)}
+	for _, s := range allow {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		n, err := parseAllowEntry(s)
+		if err != nil {
+			return nil, err
+		}
+		b.allow = append(b.allow, n)
+	}
+	// Never locking this host out of itself
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok {
+			b.own[n.IP.String()] = true
+		}
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		b.backend = nftBackend{}
+	case "windows":
+		b.backend = netshBackend{}
+	default:
+		if !dryRun {
+			return nil, fmt.Errorf("automatic blocking is not supported on %s, use -block-dry-run", runtime.GOOS)
+		}
+	}
+	return b, nil
+}
+
+// An allowlist entry is a CIDR or a single address
+func parseAllowEntry(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 128
+		if ip.To4() != nil {
+			bits = 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not an address or CIDR", s)
+	}
+	return n, nil
+}
+
+// Why ip must not be blocked, empty when it may be
+func (b *Blocker) protected(ip string) string {
+	addr := net.ParseIP(ip)
+	switch {
+	case addr == nil:
+		return "not an address"
+	case addr.IsLoopback(), addr.IsUnspecified(), addr.IsMulticast():
+		return "loopback, multicast or unspecified"
+	case b.own[addr.String()]:
+		return "an address of this host"
+	}
+	for _, n := range b.allow {
+		if n.Contains(addr) {
+			return "allowlisted by " + n.String()
+		}
+	}
+	return ""
+}
+
+// Handle queues a block for every offender of a matching alert. Safe to call with data.mu held.
+func (b *Blocker) Handle(a Alert) {
+	if b == nil || a.Resolved || a.Silenced != "" || len(a.Offenders) == 0 || !matchesAlert(b.kinds, "", a) {
+		return
+	}
+	for _, ip := range a.Offenders {
+		if why := b.protected(ip); why != "" {
+			logger.Warn("not blocking", "ip", ip, "reason", why)
+			continue
+		}
+		select {
+		case b.queue <- Block{IP: ip, Kind: a.Kind, Reason: a.Message, Start: a.Time}:
+		default:
+			logger.Warn("block queue full, not blocking", "ip", ip)
+		}
+	}
+}
+
+// Run applies queued blocks and lifts expired ones until ctx ends, then lifts the rest
+func (b *Blocker) Run(ctx context.Context) {
+	if !b.dryRun {
+		if err := b.backend.setup(); err != nil {
+			logger.Error("failed to set up the firewall for blocking, falling back to a dry run", "err", err)
+			b.dryRun = true
+		}
+	}
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			b.unblockAll()
+			return
+		case blk := <-b.queue:
+			b.apply(blk)
+		case now := <-ticker.C:
+			b.expire(now)
+		}
+	}
+}
+
+func (b *Blocker) apply(blk Block) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	blk.Until = time.Now().Add(b.ttl)
+	blk.DryRun = b.dryRun
+	if cur, ok := b.active[blk.IP]; ok {
+		// Another alert for a blocked address extends the block
+		cur.Until = blk.Until
+		if !b.dryRun {
+			b.backend.block(blk.IP, b.ttl)
+		}
+		return
+	}
+	if len(b.active) >= b.max {
+		logger.Warn("not blocking, -block-max active blocks reached", "ip", blk.IP, "max", b.max)
+		return
+	}
+	b.History = append(b.History, &blk)
+	if b.dryRun {
+		logger.Info("dry run, would block", "ip", blk.IP, "for", b.ttl, "alert", blk.Kind)
+	} else if err := b.backend.block(blk.IP, b.ttl); err != nil {
+		logger.Error("failed to block", "ip", blk.IP, "err", err)
+		blk.Error = err.Error()
+		return
+	} else {
+		logger.Info("blocked", "ip", blk.IP, "for", b.ttl, "alert", blk.Kind)
+	}
+	b.active[blk.IP] = &blk
+}
+
+func (b *Blocker) expire(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ip, blk := range b.active {
+		if now.Before(blk.Until) {
+			continue
+		}
+		b.lift(ip)
+		logger.Info("block expired", "ip", ip)
+	}
+}
+
+func (b *Blocker) unblockAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ip := range b.active {
+		b.lift(ip)
+	}
+	if !b.dryRun {
+		if err := b.backend.teardown(); err != nil {
+			logger.Error("failed to clean up the firewall", "err", err)
+		}
+	}
+}
+
+// Callers hold b.mu
+func (b *Blocker) lift(ip string) {
+	if !b.dryRun {
+		if err := b.backend.unblock(ip); err != nil {
+			logger.Error("failed to unblock", "ip", ip, "err", err)
+		}
+	}
+	delete(b.active, ip)
+}
+
+func (b *Blocker) blocks() []Block {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var blocks []Block
+	for _, blk := range b.History {
+		blocks = append(blocks, *blk)
+	}
+	return blocks
+}
+
+func printBlockReport(b *Blocker) {
+	if b == nil {
+		return
+	}
+	blocks := b.blocks()
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("AUTOMATIC BLOCKS")
+	if len(blocks) == 0 {
+		fmt.Println("No address was blocked")
+		return
+	}
+	for _, blk := range blocks {
+		note := ""
+		switch {
+		case blk.Error != "":
+			note = " (failed: " + blk.Error + ")"
+		case blk.DryRun:
+			note = " (dry run)"
+		}
+		fmt.Printf("%s %-40s [%s] until %s%s: %s\n", blk.Start.Format("15:04:05"), blk.IP, blk.Kind, blk.Until.Format("15:04:05"), note, truncate(blk.Reason, 80))
+	}
+}
+
+func runFirewallCommand(name string, args ...string) error {
+	return runFirewallInput("", name, args...)
+}
+
+func runFirewallInput(stdin, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return errors.New(msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// nftBackend keeps blocked addresses in timeout sets of an own table, so blocks
+// expire in the kernel even if netwatchd dies before lifting them
+type nftBackend struct{}
+
+const nftTable = `table inet netwatchd {
+	set blocked4 { type ipv4_addr; flags timeout; }
+	set blocked6 { type ipv6_addr; flags timeout; }
+	chain input { type filter hook input priority -10; policy accept; ip saddr @blocked4 drop; ip6 saddr @blocked6 drop; }
+	chain forward { type filter hook forward priority -10; policy accept; ip saddr @blocked4 drop; ip6 saddr @blocked6 drop; }
+}`
+
+func nftSet(ip string) string {
+	if addr := net.ParseIP(ip); addr != nil && addr.To4() == nil {
+		return "blocked6"
+	}
+	return "blocked4"
+}
+
+func (nftBackend) setup() error {
+	// A table left behind by an earlier run is replaced
+	runFirewallCommand("nft", "delete", "table", "inet", "netwatchd")
+	return runFirewallInput(nftTable, "nft", "-f", "-")
+}
+
+func (nftBackend) block(ip string, ttl time.Duration) error {
+	// Re-adding an element doesn't refresh its timeout
+	runFirewallCommand("nft", "delete", "element", "inet", "netwatchd", nftSet(ip), "{", ip, "}")
+	return runFirewallCommand("nft", "add", "element", "inet", "netwatchd", nftSet(ip), "{", ip, "timeout", fmt.Sprintf("%ds", int(ttl.Seconds())), "}")
+}
+
+func (nftBackend) unblock(ip string) error {
+	return runFirewallCommand("nft", "delete", "element", "inet", "netwatchd", nftSet(ip), "{", ip, "}")
+}
+
+func (nftBackend) teardown() error {
+	return runFirewallCommand("nft", "delete", "table", "inet", "netwatchd")
+}
+
+// netshBackend adds one inbound block rule per address to Windows Firewall.
+// The rules have no timeout, so rules left by a run that died are removed
+// on the next setup and teardown.
+type netshBackend struct{}
+
+const netshRulePrefix = "netwatchd-block-"
+
+func netshRule(ip string) string {
+	return "name=" + netshRulePrefix + ip
+}
+
+// netsh can't delete rules by a name pattern
+func netshDeleteRules() error {
+	return runFirewallCommand("powershell", "-NoProfile", "-Command",
+		fmt.Sprintf("Get-NetFirewallRule -DisplayName '%s*' -ErrorAction SilentlyContinue | Remove-NetFirewallRule", netshRulePrefix))
+}
+
+func (netshBackend) setup() error {
+	if err := runFirewallCommand("netsh", "advfirewall", "show", "currentprofile"); err != nil {
+		return err
+	}
+	return netshDeleteRules()
+}
+
+func (n netshBackend) block(ip string, ttl time.Duration) error {
+	// Extending a block replaces the rule instead of adding a second one
+	n.unblock(ip)
+	return runFirewallCommand("netsh", "advfirewall", "firewall", "add", "rule", netshRule(ip), "dir=in", "action=block", "remoteip="+ip)
+}
+
+func (netshBackend) unblock(ip string) error {
+	return runFirewallCommand("netsh", "advfirewall", "firewall", "delete", "rule", netshRule(ip))
+}
+
+func (netshBackend) teardown() error { return netshDeleteRules() }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	linux "netwatchd/netstat"
+)
+
+// BondEvent is a change in bond or member state seen between two samples
+type BondEvent struct {
+	Time    time.Time
+	Bond    string
+	Message string
+	Failure bool
+}
+
+// Sampling /proc/net/bonding and recording state changes as they happen
+func monitorBonds(ctx context.Context, data *MonitoringData) {
+	names, err := linux.GetBonds()
+	if err != nil || len(names) == 0 {
+		return
+	}
+
+	previous := make(map[string]*linux.BondStatus)
+	for _, name := range names {
+		if bond, err := linux.ReadBond(name); err == nil {
+			previous[name] = bond
+		}
+	}
+	data.mu.Lock()
+	for _, bond := range previous {
+		data.bonds = append(data.bonds, *bond)
+	}
+	data.mu.Unlock()
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, name := range names {
+				bond, err := linux.ReadBond(name)
+				if err != nil {
+					continue
+				}
+				events := diffBond(previous[name], bond, now)
+				previous[name] = bond
+
+				data.mu.Lock()
+				for i, b := range data.bonds {
+					if b.Name == name {
+						data.bonds[i] = *bond
+					}
+				}
+				for _, e := range events {
+					data.bondEvents = append(data.bondEvents, e)
+					if e.Failure {
+						data.addAlert("bond", fmt.Sprintf("%s: %s", e.Bond, e.Message), e.Time)
+					}
+				}
+				data.mu.Unlock()
+			}
+		}
+	}
+}
+
+func diffBond(old, cur *linux.BondStatus, now time.Time) []BondEvent {
+	var events []BondEvent
+	add := func(failure bool, format string, args ...interface{}) {
+		events = append(events, BondEvent{now, cur.Name, fmt.Sprintf(format, args...), failure})
+	}
+	if old == nil {
+		return nil
+	}
+
+	if old.MIIStatus != cur.MIIStatus {
+		add(cur.MIIStatus != "up", "bond link %s -> %s", old.MIIStatus, cur.MIIStatus)
+	}
+	if old.ActiveSlave != cur.ActiveSlave {
+		add(true, "failover: active slave %s -> %s", old.ActiveSlave, cur.ActiveSlave)
+	}
+	if old.PartnerMAC != cur.PartnerMAC {
+		add(false, "LACP partner changed %s -> %s", old.PartnerMAC, cur.PartnerMAC)
+	}
+
+	oldSlaves := make(map[string]linux.BondSlave)
+	for _, s := range old.Slaves {
+		oldSlaves[s.Name] = s
+	}
+	for _, s := range cur.Slaves {
+		o, ok := oldSlaves[s.Name]
+		if !ok {
+			add(false, "member %s added", s.Name)
+			continue
+		}
+		delete(oldSlaves, s.Name)
+		if o.MIIStatus != s.MIIStatus {
+			add(s.MIIStatus != "up", "member %s link %s -> %s", s.Name, o.MIIStatus, s.MIIStatus)
+		} else if s.LinkFailures > o.LinkFailures {
+			add(true, "member %s had %d link failures", s.Name, s.LinkFailures-o.LinkFailures)
+		}
+		if o.AggregatorID != s.AggregatorID {
+			add(false, "member %s moved to aggregator %s", s.Name, s.AggregatorID)
+		}
+		if o.PartnerState != s.PartnerState {
+			add(false, "member %s LACP partner state %s -> %s", s.Name, o.PartnerState, s.PartnerState)
+		}
+	}
+	for name := range oldSlaves {
+		add(true, "member %s removed", name)
+	}
+	return events
+}
+
+func printBondReport(bonds []linux.BondStatus, events []BondEvent) {
+	if len(bonds) == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("BONDING")
+	for _, b := range bonds {
+		fmt.Printf("%s: %s, link %s, active %s\n", b.Name, b.Mode, b.MIIStatus, b.ActiveSlave)
+		for _, s := range b.Slaves {
+			fmt.Printf("  %s: link %s, %d link failures", s.Name, s.MIIStatus, s.LinkFailures)
+			if s.PartnerMAC != "" {
+				fmt.Printf(", aggregator %s, partner %s key %s", s.AggregatorID, s.PartnerMAC, s.PartnerKey)
+			}
+			fmt.Println()
+		}
+	}
+	for _, e := range events {
+		fmt.Printf("%s %s: %s\n", e.Time.Format("15:04:05"), e.Bond, e.Message)
+	}
+}
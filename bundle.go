@@ -0,0 +1,541 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Files written to an -evidence directory during a capture
+const (
+	evidenceCapture  = "capture.pcapng"
+	evidenceReport   = "report.txt"
+	evidenceAlerts   = "alerts.json"
+	evidenceMetadata = "metadata.json"
+)
+
+// The capture of one interface when several are captured at once
+func evidenceInterfaceCapture(iface string) string {
+	return "capture-" + interfaceFileName(iface) + ".pcapng"
+}
+
+// An interface name usable in a file name, Npcap names are device paths
+func interfaceFileName(iface string) string {
+	return strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(iface)
+}
+
+// Metadata describes the host and capture an evidence directory came from
+type Metadata struct {
+	Hostname   string            `json:"hostname"`
+	OS         string            `json:"os"`
+	Arch       string            `json:"arch"`
+	Interface  string            `json:"interface"`
+	Filter     string            `json:"filter,omitempty"`
+	Labels     Labels            `json:"labels,omitempty"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Tshark     string            `json:"tshark,omitempty"`
+	Args       []string          `json:"args"`
+	Cloud      string            `json:"cloud,omitempty"`
+	Interfaces map[string]string `json:"interfaces,omitempty"`
+}
+
+// Manifest lists the SHA-256 of every file in a bundle and is what gets signed
+type Manifest struct {
+	Created time.Time         `json:"created"`
+	Files   map[string]string `json:"files"`
+}
+
+// Copying everything written to stdout into a file. Returns a func that stops the copy.
+func teeStdout(file string) (func(), error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %v", file, err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to create pipe: %v", err)
+	}
+
+	orig := os.Stdout
+	os.Stdout = w
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.MultiWriter(orig, f), r)
+		close(done)
+	}()
+
+	return func() {
+		w.Close()
+		<-done
+		os.Stdout = orig
+		f.Close()
+	}, nil
+}
+
+// Writing the alert history and run metadata next to the capture
+func writeEvidence(dir string, data *MonitoringData, filter string) error {
+	data.mu.Lock()
+	alerts := append([]Alert(nil), data.alerts...)
+	meta := Metadata{
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Interface: data.captureInterface,
+		Filter:    filter,
+		Labels:    data.labels,
+		Start:     data.startTime,
+		End:       time.Now(),
+		Args:      os.Args,
+	}
+	if data.cloud != nil {
+		meta.Cloud = data.cloud.String()
+	}
+	data.mu.Unlock()
+
+	meta.Hostname, _ = os.Hostname()
+	if out, err := exec.Command("tshark", "--version").Output(); err == nil {
+		meta.Tshark, _, _ = strings.Cut(string(out), "\n")
+	}
+	if ifaces, err := net.Interfaces(); err == nil {
+		meta.Interfaces = make(map[string]string)
+		for _, iface := range ifaces {
+			var addrs []string
+			if as, err := iface.Addrs(); err == nil {
+				for _, a := range as {
+					addrs = append(addrs, a.String())
+				}
+			}
+			meta.Interfaces[iface.Name] = strings.Join(addrs, " ")
+		}
+	}
+
+	if err := writeJSON(filepath.Join(dir, evidenceAlerts), alerts); err != nil {
+		return err
+	}
+	return writeJSON(filepath.Join(dir, evidenceMetadata), meta)
+}
+
+func writeJSON(file string, v any) error {
+	if err := diskGuardrail.Check(file); err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", file, err)
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// Handling "netwatchd bundle" and "netwatchd bundle verify"
+func runBundleCommand(args []string) int {
+	if len(args) > 0 && args[0] == "verify" {
+		return runBundleVerify(args[1:])
+	}
+
+	fs := flag.NewFlagSet("bundle", flag.ExitOnError)
+	outFlag := fs.String("o", "", "Bundle file to write (default netwatchd-evidence-<time>.tar.gz)")
+	keyFlag := fs.String("key", "netwatchd-signing.pem", "Ed25519 private key used for signing, created if missing")
+	windowFlag := fs.Duration("window", 30*time.Second, "Capture kept before and after each alert")
+	fs.Usage = func() {
+		fmt.Println("Usage: netwatchd bundle [-o file] [-key file] [-window 30s] <evidence dir>")
+		fmt.Println("       netwatchd bundle verify [-pub file] <bundle>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	dir := fs.Arg(0)
+
+	key, err := loadSigningKey(*keyFlag)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	out := *outFlag
+	if out == "" {
+		out = fmt.Sprintf("netwatchd-evidence-%s.tar.gz", time.Now().Format("20060102-150405"))
+	}
+
+	files, cleanup, err := collectEvidence(dir, *windowFlag)
+	defer cleanup()
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if err := writeBundle(out, files, key); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	fmt.Printf("Bundle with %d files written to %s\n", len(files), out)
+	fmt.Printf("Signed by %s\n", keyFingerprint(key.Public().(ed25519.PublicKey)))
+	return 0
+}
+
+// Picking the files that go in the bundle, keyed by their name inside it
+func collectEvidence(dir string, window time.Duration) (map[string]string, func(), error) {
+	files := make(map[string]string)
+	cleanup := func() {}
+
+	for _, name := range []string{evidenceReport, evidenceAlerts, evidenceMetadata} {
+		file := filepath.Join(dir, name)
+		if _, err := os.Stat(file); err == nil {
+			files[name] = file
+		}
+	}
+	if len(files) == 0 {
+		return nil, cleanup, fmt.Errorf("%s does not look like an -evidence directory", dir)
+	}
+
+	// capture.pcapng, or one capture-<interface>.pcapng per interface
+	captures, _ := filepath.Glob(filepath.Join(dir, "capture*.pcapng"))
+	if len(captures) == 0 {
+		return files, cleanup, nil
+	}
+
+	var alerts []Alert
+	if raw, err := os.ReadFile(filepath.Join(dir, evidenceAlerts)); err == nil {
+		json.Unmarshal(raw, &alerts)
+	}
+
+	tmp, err := os.MkdirTemp("", "netwatchd-bundle")
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("failed to create temp dir: %v", err)
+	}
+	cleanup = func() { os.RemoveAll(tmp) }
+
+	for _, capture := range captures {
+		name := filepath.Base(capture)
+		prefix, outDir := "pcap/", tmp
+		if name != evidenceCapture {
+			prefix = "pcap/" + strings.TrimSuffix(name, ".pcapng") + "/"
+			outDir = filepath.Join(tmp, strings.TrimSuffix(name, ".pcapng"))
+			if err := os.Mkdir(outDir, 0755); err != nil {
+				return nil, cleanup, fmt.Errorf("failed to create temp dir: %v", err)
+			}
+		}
+		slices, err := slicePcap(capture, outDir, alerts, window)
+		if err != nil {
+			// Without editcap the whole capture is the best evidence we have
+			fmt.Printf("Including full capture %s: %v\n", name, err)
+			files[name] = capture
+			continue
+		}
+		for _, s := range slices {
+			files[prefix+filepath.Base(s)] = s
+		}
+	}
+	return files, cleanup, nil
+}
+
+// Cutting the capture down to the windows around alerts with editcap
+func slicePcap(capture, outDir string, alerts []Alert, window time.Duration) ([]string, error) {
+	if len(alerts) == 0 {
+		return nil, errors.New("no alerts to slice around")
+	}
+	if _, err := exec.LookPath("editcap"); err != nil {
+		return nil, errors.New("editcap not found in PATH")
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].Time.Before(alerts[j].Time)
+	})
+
+	// Overlapping windows are merged into one slice
+	type span struct{ from, to time.Time }
+	var spans []span
+	for _, a := range alerts {
+		from, to := a.Time.Add(-window), a.Time.Add(window)
+		if n := len(spans); n > 0 && !from.After(spans[n-1].to) {
+			spans[n-1].to = to
+			continue
+		}
+		spans = append(spans, span{from, to})
+	}
+
+	var out []string
+	for i, s := range spans {
+		file := filepath.Join(outDir, fmt.Sprintf("slice-%02d-%s.pcapng", i+1, s.from.Format("150405")))
+		// editcap takes local time in this layout
+		cmd := exec.Command("editcap",
+			"-A", s.from.Format("2006-01-02 15:04:05"),
+			"-B", s.to.Format("2006-01-02 15:04:05"),
+			capture, file)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("editcap failed: %v: %s", err, strings.TrimSpace(string(output)))
+		}
+		out = append(out, file)
+	}
+	return out, nil
+}
+
+func writeBundle(out string, files map[string]string, key ed25519.PrivateKey) error {
+	manifest := Manifest{Created: time.Now().UTC(), Files: make(map[string]string)}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		sum, err := sha256File(files[name])
+		if err != nil {
+			return err
+		}
+		manifest.Files[name] = sum
+	}
+	rawManifest, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	sig := ed25519.Sign(key, rawManifest)
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return fmt.Errorf("failed to encode public key: %v", err)
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", out, err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	root := strings.TrimSuffix(filepath.Base(out), ".tar.gz")
+	for _, name := range names {
+		if err := addFileToTar(tw, path.Join(root, name), files[name]); err != nil {
+			return err
+		}
+	}
+	extras := []struct {
+		name string
+		body []byte
+	}{
+		{"manifest.json", rawManifest},
+		{"manifest.sig", []byte(base64.StdEncoding.EncodeToString(sig) + "\n")},
+		{"signer.pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})},
+	}
+	for _, e := range extras {
+		hdr := &tar.Header{Name: path.Join(root, e.name), Mode: 0644, Size: int64(len(e.body)), ModTime: manifest.Created}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write %s: %v", e.name, err)
+		}
+		tw.Write(e.body)
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", out, err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", out, err)
+	}
+	return nil
+}
+
+func addFileToTar(tw *tar.Writer, name, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", file, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %v", file, err)
+	}
+
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: info.Size(), ModTime: info.ModTime()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return nil
+}
+
+// Checking a bundle's signature and that every file matches the manifest
+func runBundleVerify(args []string) int {
+	fs := flag.NewFlagSet("bundle verify", flag.ExitOnError)
+	pubFlag := fs.String("pub", "", "Require the bundle to be signed by this public key")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Println("Usage: netwatchd bundle verify [-pub file] <bundle>")
+		return 2
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Printf("Failed to open bundle: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		fmt.Printf("Not a gzip file: %v\n", err)
+		return 1
+	}
+
+	sums := make(map[string]string)
+	var rawManifest, rawSig, rawPub []byte
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Printf("Corrupt bundle: %v\n", err)
+			return 1
+		}
+		_, name, _ := strings.Cut(hdr.Name, "/")
+		switch name {
+		case "manifest.json":
+			rawManifest, _ = io.ReadAll(tr)
+		case "manifest.sig":
+			rawSig, _ = io.ReadAll(tr)
+		case "signer.pub":
+			rawPub, _ = io.ReadAll(tr)
+		default:
+			h := sha256.New()
+			io.Copy(h, tr)
+			sums[name] = hex.EncodeToString(h.Sum(nil))
+		}
+	}
+
+	if *pubFlag != "" {
+		if rawPub, err = os.ReadFile(*pubFlag); err != nil {
+			fmt.Printf("Failed to read public key: %v\n", err)
+			return 1
+		}
+	}
+	pub, err := parsePublicKey(rawPub)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(rawSig)))
+	if err != nil || !ed25519.Verify(pub, rawManifest, sig) {
+		fmt.Println("FAIL: manifest signature does not verify")
+		return 1
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(rawManifest, &manifest); err != nil {
+		fmt.Printf("FAIL: bad manifest: %v\n", err)
+		return 1
+	}
+	ok := len(sums) == len(manifest.Files)
+	for name, want := range manifest.Files {
+		if sums[name] != want {
+			fmt.Printf("FAIL: %s does not match the manifest\n", name)
+			ok = false
+		}
+	}
+	if !ok {
+		return 1
+	}
+
+	fmt.Printf("OK: %d files signed by %s on %s\n", len(sums), keyFingerprint(pub), manifest.Created.Format(time.RFC3339))
+	return 0
+}
+
+// Reading a PKCS#8 Ed25519 key, generating one the first time
+func loadSigningKey(file string) (ed25519.PrivateKey, error) {
+	raw, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %v", err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode signing key: %v", err)
+		}
+		if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+			return nil, fmt.Errorf("failed to save signing key: %v", err)
+		}
+		fmt.Printf("Generated new signing key %s\n", file)
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %v", err)
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", file)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %v", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 key", file)
+	}
+	return key, nil
+}
+
+func parsePublicKey(raw []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("missing or invalid signer public key")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %v", err)
+	}
+	pub, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("signer key is not Ed25519")
+	}
+	return pub, nil
+}
+
+// Short SHA-256 fingerprint for comparing keys out of band
+func keyFingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+func sha256File(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %v", file, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", file, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
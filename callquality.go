@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// Conferencing services by their media ports, either side of the stream
+var mediaServices = []struct {
+	name     string
+	from, to int
+}{
+	{"Zoom", 8801, 8810},
+	{"Microsoft Teams", 3478, 3481},
+	{"Google Meet", 19302, 19309},
+	{"Webex", 9000, 9000},
+	{"Webex", 5004, 5004},
+}
+
+func mediaService(ports ...int) string {
+	for _, port := range ports {
+		for _, s := range mediaServices {
+			if port >= s.from && port <= s.to {
+				return s.name
+			}
+		}
+	}
+	return ""
+}
+
+// RTP clock rates of the static payload types, dynamic ones are estimated
+var rtpClockRates = map[int]float64{0: 8000, 3: 8000, 4: 8000, 8: 8000, 9: 8000, 18: 8000, 26: 90000, 31: 90000, 32: 90000, 34: 90000}
+
+var commonClockRates = []float64{8000, 16000, 24000, 32000, 44100, 48000, 90000}
+
+// CallMinute is one minute of a media stream
+type CallMinute struct {
+	Start       time.Time `json:"start"`
+	Packets     int       `json:"packets"`
+	Lost        int       `json:"lost"`
+	LossPercent float64   `json:"loss_percent"`
+	JitterMs    float64   `json:"jitter_ms"` // peak
+	MOS         float64   `json:"mos"`
+	Rating      string    `json:"rating"`
+}
+
+// CallQuality is the loss, jitter and estimated MOS of one RTP or SRTP
+// stream, as seen where the capture runs
+type CallQuality struct {
+	Service     string       `json:"service,omitempty"`
+	Protocol    string       `json:"protocol"` // RTP or SRTP
+	Source      string       `json:"source"`
+	Destination string       `json:"destination"`
+	SSRC        string       `json:"ssrc"`
+	PayloadType int          `json:"payload_type"`
+	Start       time.Time    `json:"start"`
+	End         time.Time    `json:"end"`
+	Packets     int          `json:"packets"`
+	Bytes       int64        `json:"bytes"`
+	Lost        int          `json:"lost"`
+	LossPercent float64      `json:"loss_percent"`
+	JitterMs    float64      `json:"jitter_ms"` // mean of the RFC 3550 estimate
+	MaxJitterMs float64      `json:"max_jitter_ms"`
+	MOS         float64      `json:"mos"`
+	Rating      string       `json:"rating"` // good, fair or poor
+	Minutes     []CallMinute `json:"minutes,omitempty"`
+}
+
+type callMinute struct {
+	start     time.Time
+	packets   int
+	startExt  int64 // highest extended sequence number before the minute
+	endExt    int64
+	jitterMax float64
+}
+
+type rtpStream struct {
+	q          CallQuality
+	secure     bool
+	sequential int // packets a little ahead of the previous one, to weed out false positives
+	baseSeq    int64
+	maxExt     int64 // highest extended sequence number
+	cycles     int64
+	clockRate  float64
+	firstTS    uint32
+	firstTime  time.Time
+	transit    float64 // seconds, for the RFC 3550 jitter estimate
+	hasTransit bool
+	jitter     float64
+	jitterSum  float64
+	jitterN    int
+	minutes    []*callMinute
+}
+
+// CallQualityTracker follows RTP streams by SSRC and estimates their loss
+// and jitter from sequence numbers and timestamps, minute by minute, so a
+// bad call can be lined up with the rest of the report. SRTP leaves the RTP
+// header in clear; a stream counts as SRTP when its flow carried the STUN or
+// DTLS of a WebRTC call or it runs on a conferencing service's ports. It is
+// guarded by the MonitoringData mutex.
+type CallQualityTracker struct {
+	streams map[string]*rtpStream
+	secure  map[string]bool // flows that carried STUN or DTLS
+}
+
+// Streams shorter than this are left out, they are usually not RTP at all
+const minCallPackets = 50
+
+func NewCallQualityTracker() *CallQualityTracker {
+	return &CallQualityTracker{streams: make(map[string]*rtpStream), secure: make(map[string]bool)}
+}
+
+func (t *CallQualityTracker) Observe(p *netwatch.Packet) {
+	if p.Transport != "udp" || p.SrcIP == "" {
+		return
+	}
+	if p.HasLayer("stun") || p.HasLayer("dtls") {
+		t.secure[flowKey(p)] = true
+		return
+	}
+	h := p.RTP
+	if h == nil {
+		return
+	}
+
+	src, dst := fmt.Sprintf("%s:%d", p.SrcIP, p.SrcPort), fmt.Sprintf("%s:%d", p.DstIP, p.DstPort)
+	key := fmt.Sprintf("%s %s %08x", src, dst, h.SSRC)
+	s, ok := t.streams[key]
+	if !ok {
+		s = &rtpStream{
+			q: CallQuality{Service: mediaService(p.DstPort, p.SrcPort), Source: src, Destination: dst,
+				SSRC: fmt.Sprintf("0x%08X", h.SSRC), PayloadType: h.PayloadType, Start: p.Time},
+			baseSeq:   int64(h.Seq),
+			maxExt:    int64(h.Seq) - 1,
+			clockRate: rtpClockRates[h.PayloadType],
+			firstTS:   h.Timestamp,
+			firstTime: p.Time,
+		}
+		t.streams[key] = s
+	}
+	s.secure = s.secure || t.secure[flowKey(p)] || s.q.Service != ""
+	s.q.Packets++
+	s.q.Bytes += int64(p.Length)
+	s.q.End = p.Time
+
+	minute := p.Time.Truncate(time.Minute)
+	if n := len(s.minutes); n == 0 || s.minutes[n-1].start != minute {
+		s.minutes = append(s.minutes, &callMinute{start: minute, startExt: s.maxExt, endExt: s.maxExt})
+	}
+	m := s.minutes[len(s.minutes)-1]
+	m.packets++
+
+	// Extending the 16 bit sequence number as RFC 3550 appendix A.1 does
+	if ok {
+		delta := h.Seq - uint16(s.maxExt)
+		switch {
+		case delta == 0 || delta > 65536-100:
+			// Duplicate or late, still counted as received
+		case delta < 3000:
+			s.sequential++
+			if h.Seq < uint16(s.maxExt) {
+				s.cycles += 65536
+			}
+			s.maxExt = s.cycles + int64(h.Seq)
+		default:
+			// The sender restarted its sequence, the jump is not loss
+			s.cycles = 0
+			s.baseSeq = int64(h.Seq) - (s.maxExt - s.baseSeq + 1)
+			m.startExt += int64(h.Seq) - s.maxExt - 1
+			s.maxExt = int64(h.Seq)
+		}
+	} else {
+		s.maxExt = int64(h.Seq)
+	}
+	m.endExt = s.maxExt
+
+	// Dynamic payload types get the common clock rate nearest to what their
+	// timestamps advanced over the first two seconds
+	elapsed := p.Time.Sub(s.firstTime).Seconds()
+	if s.clockRate == 0 && elapsed >= 2 {
+		rate := float64(h.Timestamp-s.firstTS) / elapsed
+		best := commonClockRates[0]
+		for _, r := range commonClockRates {
+			if math.Abs(math.Log(rate/r)) < math.Abs(math.Log(rate/best)) {
+				best = r
+			}
+		}
+		s.clockRate = best
+	}
+	if s.clockRate == 0 {
+		return
+	}
+	transit := elapsed - float64(h.Timestamp-s.firstTS)/s.clockRate
+	if s.hasTransit {
+		s.jitter += (math.Abs(transit-s.transit) - s.jitter) / 16
+		s.jitterSum += s.jitter
+		s.jitterN++
+		m.jitterMax = math.Max(m.jitterMax, s.jitter)
+		s.q.MaxJitterMs = math.Max(s.q.MaxJitterMs, s.jitter*1000)
+	}
+	s.transit, s.hasTransit = transit, true
+}
+
+// Estimating a MOS with the simplified E-model, counting jitter twice as
+// latency since the one way delay isn't known
+func estimateMOS(lossPercent, jitterMs float64) float64 {
+	latency := 2*jitterMs + 10
+	r := 93.2 - latency/40
+	if latency >= 160 {
+		r = 93.2 - (latency-120)/10
+	}
+	r -= 2.5 * lossPercent
+	r = math.Max(0, math.Min(100, r))
+	return 1 + 0.035*r + 0.000007*r*(r-60)*(100-r)
+}
+
+func mosRating(mos float64) string {
+	switch {
+	case mos >= 4.0:
+		return "good"
+	case mos >= 3.6:
+		return "fair"
+	}
+	return "poor"
+}
+
+func lossPercent(lost, received int) float64 {
+	if lost+received == 0 {
+		return 0
+	}
+	return 100 * float64(lost) / float64(lost+received)
+}
+
+// Streams with their quality, the worst first
+func (t *CallQualityTracker) Calls() []CallQuality {
+	if t == nil {
+		return nil
+	}
+	var calls []CallQuality
+	for _, s := range t.streams {
+		if s.q.Packets < minCallPackets || s.sequential*5 < s.q.Packets*4 {
+			continue
+		}
+		q := s.q
+		q.Protocol = "RTP"
+		if s.secure {
+			q.Protocol = "SRTP"
+		}
+		q.Lost = max(0, int(s.maxExt-s.baseSeq+1)-q.Packets)
+		q.LossPercent = lossPercent(q.Lost, q.Packets)
+		if s.jitterN > 0 {
+			q.JitterMs = s.jitterSum / float64(s.jitterN) * 1000
+		}
+		q.MOS = estimateMOS(q.LossPercent, q.JitterMs)
+		q.Rating = mosRating(q.MOS)
+		for _, m := range s.minutes {
+			cm := CallMinute{Start: m.start, Packets: m.packets, Lost: max(0, int(m.endExt-m.startExt)-m.packets), JitterMs: m.jitterMax * 1000}
+			cm.LossPercent = lossPercent(cm.Lost, cm.Packets)
+			cm.MOS = estimateMOS(cm.LossPercent, cm.JitterMs)
+			cm.Rating = mosRating(cm.MOS)
+			q.Minutes = append(q.Minutes, cm)
+		}
+		calls = append(calls, q)
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		if calls[i].MOS != calls[j].MOS {
+			return calls[i].MOS < calls[j].MOS
+		}
+		return calls[i].Source+calls[i].SSRC < calls[j].Source+calls[j].SSRC
+	})
+	return calls
+}
+
+// Starting a new period, flows known to be secure are kept
+func (t *CallQualityTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.streams = make(map[string]*rtpStream)
+}
+
+func printCallQualityReport(t *CallQualityTracker) {
+	calls := t.Calls()
+	if len(calls) == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("CALL QUALITY")
+	fmt.Println("Loss and jitter as seen here, streams this host sends have not crossed the network yet")
+	for _, c := range calls {
+		name := c.Protocol
+		if c.Service != "" {
+			name = c.Service + " " + c.Protocol
+		}
+		fmt.Printf("%s %s -> %s (SSRC %s, PT %d) %s-%s\n", name, c.Source, c.Destination, c.SSRC, c.PayloadType,
+			c.Start.Format("15:04:05"), c.End.Format("15:04:05"))
+		fmt.Printf("  %d packets | %d lost (%.2f%%) | jitter %.1f ms, peak %.1f ms | MOS %.1f, %s\n",
+			c.Packets, c.Lost, c.LossPercent, c.JitterMs, c.MaxJitterMs, c.MOS, c.Rating)
+		var bad []string
+		for _, m := range c.Minutes {
+			if m.Rating == "poor" {
+				bad = append(bad, fmt.Sprintf("%s (%.1f%% loss, %.0f ms jitter)", m.Start.Format("15:04"), m.LossPercent, m.JitterMs))
+			}
+		}
+		if len(bad) > 5 {
+			bad = append(bad[:5], fmt.Sprintf("%d more", len(bad)-5))
+		}
+		if len(bad) > 0 {
+			fmt.Printf("  Poor minutes: %s\n", strings.Join(bad, ", "))
+		}
+	}
+}
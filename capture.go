@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+func capturePackets(data *MonitoringData, iface string, packets <-chan *netwatch.Packet) {
+	for pkt := range packets {
+		pkt.Interface = iface
+		data.mu.Lock()
+		data.handlePacket(pkt, time.Now())
+		data.mu.Unlock()
+	}
+}
+
+// Counting a packet and feeding it to every tracker. Callers hold data.mu.
+func (data *MonitoringData) handlePacket(pkt *netwatch.Packet, now time.Time) {
+	if data.paused {
+		return
+	}
+	data.countInterface(pkt)
+	if data.dedup.Duplicate(pkt) {
+		return
+	}
+	analyze := data.limits.Analyze()
+	if !data.quietPackets && analyze {
+		fmt.Println(pkt) // Show packet in real-time
+	}
+	data.buckets.Add(1, int64(pkt.Length))
+	data.capturedPackets++
+	data.capturedBytes += int64(pkt.Length)
+	if data.captureBandwidth {
+		data.countCapturedBandwidth(pkt)
+	}
+	data.countSecond(pkt.Time)
+	data.lastPacketTime = now
+	if !analyze {
+		return
+	}
+	// Encrypted DNS gets a layer of its own, so it isn't counted as HTTPS
+	if layer := data.encryptedDNS.Observe(pkt); layer != "" {
+		pkt.Protocols = append(pkt.Protocols, layer)
+	}
+	data.inventory.Observe(pkt)
+	data.matrix.Observe(pkt)
+	data.patterns.Observe(pkt)
+	data.protocols.Observe(pkt)
+	data.ports.Observe(pkt)
+	data.flows.Observe(pkt)
+	data.watchdog.Observe(pkt)
+	data.exposure.Observe(pkt)
+	data.weakProtocols.Observe(pkt)
+	data.fileServices.Observe(pkt)
+	data.callQuality.Observe(pkt)
+	data.sipCalls.Observe(pkt)
+	data.games.Observe(pkt)
+	data.p2p.Observe(pkt)
+	data.cloudRanges.Observe(pkt)
+	data.services.Observe(pkt)
+	data.updates.Observe(pkt)
+	data.dns.Observe(pkt)
+	data.amplification.Observe(pkt)
+	data.rates.Observe(pkt)
+	if msg := data.segmentation.Observe(pkt); msg != "" {
+		data.addOffenderAlert("segmentation", msg, []string{pkt.SrcIP}, pkt.Time)
+	}
+	if msg := data.routerAdverts.Observe(pkt); msg != "" {
+		data.addOffenderAlert("rogue-ra", msg, []string{pkt.SrcIP}, pkt.Time)
+	}
+	if msg := data.spanningTree.Observe(pkt); msg != "" {
+		data.addAlert("stp-root", msg, pkt.Time)
+	}
+	if msg := data.certs.Observe(pkt); msg != "" {
+		data.addAlert("tls-cert", msg, pkt.Time)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// Checkpoints older than this are a different session, not a crash to recover from
+const checkpointMaxGap = 24 * time.Hour
+
+// Checkpoint is the accumulated state saved by -checkpoint. Trackers built from
+// single packets (exposure, certificates, STP, segmentation) start over on resume.
+type Checkpoint struct {
+	Saved            time.Time           `json:"saved"`
+	Interface        string              `json:"interface"`
+	Filter           string              `json:"filter,omitempty"`
+	Start            time.Time           `json:"start"`
+	NextBucket       time.Time           `json:"next_bucket"`
+	BucketSeconds    float64             `json:"bucket_seconds"`
+	RequestedSeconds float64             `json:"requested_seconds,omitempty"`
+	Packets          []int               `json:"packets"`
+	Bandwidth        []float64           `json:"bandwidth"`
+	Sent             []float64           `json:"sent"`
+	Recv             []float64           `json:"recv"`
+	Paused           []time.Duration     `json:"paused"`
+	RouteChurn       []int               `json:"route_churn"`
+	CurrentPackets   int                 `json:"current_packets"`
+	CurrentBandwidth float64             `json:"current_bandwidth"`
+	CurrentSent      float64             `json:"current_sent"`
+	CurrentRecv      float64             `json:"current_recv"`
+	CapturedPackets  int64               `json:"captured_packets"`
+	CapturedBytes    int64               `json:"captured_bytes"`
+	Alerts           []Alert             `json:"alerts,omitempty"`
+	Hosts            []Host              `json:"hosts,omitempty"`
+	Accounting       []checkpointAccount `json:"accounting,omitempty"`
+	Interfaces       []*CaptureInterface `json:"interfaces,omitempty"`
+}
+
+// checkpointAccount keeps the session baselines usage is counted from
+type checkpointAccount struct {
+	AccountedClient
+	BaseIn  uint64 `json:"base_in"`
+	BaseOut uint64 `json:"base_out"`
+}
+
+// Snapshot of the state worth resuming. Callers hold data.mu.
+func (data *MonitoringData) checkpoint(now time.Time) *Checkpoint {
+	cp := &Checkpoint{
+		Saved:            now,
+		Interface:        data.captureInterface,
+		Filter:           data.captureFilter,
+		Start:            data.startTime,
+		NextBucket:       data.buckets.End(),
+		BucketSeconds:    data.bucket.Seconds(),
+		RequestedSeconds: time.Duration(data.requestedDuration).Seconds(),
+		Packets:          data.buckets.PacketCounts(),
+		Bandwidth:        data.bandwidthBuckets,
+		Sent:             data.sentBuckets,
+		Recv:             data.recvBuckets,
+		Paused:           data.pausedBuckets,
+		RouteChurn:       data.routeChurnBuckets,
+		CurrentPackets:   data.buckets.Current.Packets,
+		CurrentBandwidth: data.currentBandwidth,
+		CurrentSent:      data.currentSent,
+		CurrentRecv:      data.currentRecv,
+		CapturedPackets:  data.capturedPackets,
+		CapturedBytes:    data.capturedBytes,
+		Alerts:           data.alerts,
+		Hosts:            data.inventory.Hosts(),
+		Interfaces:       data.interfaces,
+	}
+	if data.accounting != nil {
+		for _, c := range data.accounting.clients {
+			cp.Accounting = append(cp.Accounting, checkpointAccount{*c, c.baseIn, c.baseOut})
+		}
+	}
+	return cp
+}
+
+// Continuing from a checkpoint of the same capture. The time between the
+// checkpoint and now was not monitored and is recorded as paused.
+func (data *MonitoringData) resume(cp *Checkpoint, now time.Time) {
+	data.startTime = cp.Start
+	data.buckets = netwatch.NewBuckets(cp.NextBucket.Add(-data.bucket), data.bucket)
+	for i, n := range cp.Packets {
+		start := cp.Start.Add(time.Duration(i) * data.bucket)
+		data.buckets.Closed = append(data.buckets.Closed, netwatch.Bucket{Start: start, Seconds: data.bucket.Seconds(), Packets: n})
+	}
+	data.buckets.Add(cp.CurrentPackets, 0)
+	data.bandwidthBuckets = cp.Bandwidth
+	data.sentBuckets = cp.Sent
+	data.recvBuckets = cp.Recv
+	data.pausedBuckets = cp.Paused
+	data.routeChurnBuckets = cp.RouteChurn
+	data.currentBandwidth = cp.CurrentBandwidth
+	data.currentSent = cp.CurrentSent
+	data.currentRecv = cp.CurrentRecv
+	data.capturedPackets = cp.CapturedPackets
+	data.capturedBytes = cp.CapturedBytes
+	data.alerts = cp.Alerts
+	data.resumeInterfaces(cp.Interfaces)
+	for i := range cp.Hosts {
+		h := cp.Hosts[i]
+		data.inventory.hosts[h.IP] = &h
+	}
+	if len(cp.Accounting) > 0 {
+		data.accounting = NewAccounting()
+		for _, a := range cp.Accounting {
+			c := a.AccountedClient
+			c.baseIn, c.baseOut, c.seen = a.BaseIn, a.BaseOut, true
+			data.accounting.clients[c.MAC] = &c
+		}
+	}
+
+	data.paused, data.pausedSince = true, cp.Saved
+	for data.buckets.Due(now) {
+		data.rotateBucket()
+	}
+	data.currentPaused += now.Sub(data.pausedSince)
+	data.paused = false
+	data.lastPacketTime = now
+}
+
+func loadCheckpoint(path string) (*Checkpoint, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(raw, &cp); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return &cp, nil
+}
+
+// Resuming from path when it holds an unfinished capture of the same interface
+// and filter. Returns whether the state was restored. Callers hold data.mu.
+func (data *MonitoringData) resumeCheckpoint(path string, now time.Time) bool {
+	cp, err := loadCheckpoint(path)
+	if os.IsNotExist(err) {
+		return false
+	}
+	if err != nil {
+		logger.Warn("ignoring checkpoint", "path", path, "err", err)
+		return false
+	}
+	requested := time.Duration(cp.RequestedSeconds * float64(time.Second))
+	switch {
+	case cp.Interface != data.captureInterface || cp.Filter != data.captureFilter:
+		fmt.Printf("Ignoring checkpoint of %s (%q), capturing on %s (%q)\n", cp.Interface, cp.Filter, data.captureInterface, data.captureFilter)
+	case cp.BucketSeconds != data.bucket.Seconds():
+		fmt.Printf("Ignoring checkpoint with %gs buckets, -bucket is %s\n", cp.BucketSeconds, data.bucket)
+	case now.Sub(cp.Saved) > checkpointMaxGap || now.Before(cp.Saved):
+		fmt.Printf("Ignoring checkpoint saved %s\n", cp.Saved.Format(time.RFC3339))
+	case requested > 0 && cp.Saved.Sub(cp.Start) >= requested:
+		fmt.Println("Ignoring checkpoint of a finished capture")
+	default:
+		data.resume(cp, now)
+		fmt.Printf("Resumed capture started %s, %s unmonitored since the checkpoint\n",
+			cp.Start.Format(time.RFC3339), now.Sub(cp.Saved).Round(time.Second))
+		return true
+	}
+	return false
+}
+
+func saveCheckpoint(path string, data *MonitoringData) error {
+	data.mu.Lock()
+	b, err := json.Marshal(data.checkpoint(time.Now()))
+	data.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := diskGuardrail.Check(path); err != nil {
+		return err
+	}
+	// Written next to the old one and renamed so a crash never leaves half a file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Saving a checkpoint every interval and once more when the capture ends
+func runCheckpoints(ctx context.Context, data *MonitoringData, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	save := func() {
+		if err := saveCheckpoint(path, data); err != nil {
+			logger.Error("failed to save checkpoint", "path", path, "err", err)
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			save()
+			return
+		case <-ticker.C:
+			save()
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package cloudmeta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const metadataHost = "http://169.254.169.254"
+
+type Instance struct {
+	Provider     string `json:"provider"`
+	InstanceID   string `json:"instance_id"`
+	Region       string `json:"region,omitempty"`
+	Zone         string `json:"zone,omitempty"`
+	InstanceType string `json:"instance_type,omitempty"`
+}
+
+func (i *Instance) String() string {
+	s := fmt.Sprintf("%s %s", i.Provider, i.InstanceID)
+	if i.InstanceType != "" {
+		s += " (" + i.InstanceType + ")"
+	}
+	if i.Zone != "" {
+		s += " " + i.Zone
+	} else if i.Region != "" {
+		s += " " + i.Region
+	}
+	return s
+}
+
+var client = &http.Client{Timeout: 2 * time.Second}
+
+// Detecting the cloud provider and reading the instance identity.
+// On Linux the DMI vendor avoids probing the metadata address on bare metal.
+func Detect(ctx context.Context) (*Instance, error) {
+	providers := []string{"aws", "azure", "gcp"}
+	if runtime.GOOS == "linux" {
+		vendor := dmiVendor()
+		if vendor == "" {
+			return nil, fmt.Errorf("not running in a known cloud")
+		}
+		providers = []string{vendor}
+	}
+
+	for _, p := range providers {
+		var inst *Instance
+		var err error
+		switch p {
+		case "aws":
+			inst, err = detectAWS(ctx)
+		case "azure":
+			inst, err = detectAzure(ctx)
+		case "gcp":
+			inst, err = detectGCP(ctx)
+		}
+		if err == nil {
+			return inst, nil
+		}
+	}
+	return nil, fmt.Errorf("no instance metadata service reachable")
+}
+
+func dmiVendor() string {
+	read := func(name string) string {
+		raw, _ := os.ReadFile("/sys/class/dmi/id/" + name)
+		return strings.ToLower(strings.TrimSpace(string(raw)))
+	}
+	switch {
+	case strings.Contains(read("sys_vendor"), "amazon"), strings.Contains(read("bios_version"), "amazon"):
+		return "aws"
+	case strings.Contains(read("sys_vendor"), "google"):
+		return "gcp"
+	// Azure marks its VMs with a fixed chassis asset tag, plain Hyper-V does not
+	case read("chassis_asset_tag") == "7783-7084-3265-9085-8269-3286-77":
+		return "azure"
+	}
+	return ""
+}
+
+func get(ctx context.Context, url string, header map[string]string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("metadata request to %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// AWS IMDSv2 needs a session token first
+func detectAWS(ctx context.Context) (*Instance, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, metadataHost+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	token, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("IMDSv2 token request returned %s", resp.Status)
+	}
+
+	var doc struct {
+		InstanceID       string `json:"instanceId"`
+		Region           string `json:"region"`
+		AvailabilityZone string `json:"availabilityZone"`
+		InstanceType     string `json:"instanceType"`
+	}
+	err = get(ctx, metadataHost+"/latest/dynamic/instance-identity/document",
+		map[string]string{"X-aws-ec2-metadata-token": string(token)}, &doc)
+	if err != nil {
+		return nil, err
+	}
+	return &Instance{"aws", doc.InstanceID, doc.Region, doc.AvailabilityZone, doc.InstanceType}, nil
+}
+
+func detectAzure(ctx context.Context) (*Instance, error) {
+	var compute struct {
+		VMID     string `json:"vmId"`
+		Location string `json:"location"`
+		Zone     string `json:"zone"`
+		VMSize   string `json:"vmSize"`
+	}
+	err := get(ctx, metadataHost+"/metadata/instance/compute?api-version=2021-02-01",
+		map[string]string{"Metadata": "true"}, &compute)
+	if err != nil {
+		return nil, err
+	}
+	return &Instance{"azure", compute.VMID, compute.Location, compute.Zone, compute.VMSize}, nil
+}
+
+func detectGCP(ctx context.Context) (*Instance, error) {
+	var inst struct {
+		ID          json.Number `json:"id"`
+		Zone        string      `json:"zone"`
+		MachineType string      `json:"machineType"`
+	}
+	err := get(ctx, metadataHost+"/computeMetadata/v1/instance/?recursive=true",
+		map[string]string{"Metadata-Flavor": "Google"}, &inst)
+	if err != nil {
+		return nil, err
+	}
+
+	// Both come back as "projects/<n>/zones/us-central1-a" style paths
+	zone := inst.Zone[strings.LastIndex(inst.Zone, "/")+1:]
+	region := zone
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		region = zone[:i]
+	}
+	machineType := inst.MachineType[strings.LastIndex(inst.MachineType, "/")+1:]
+	return &Instance{"gcp", inst.ID.String(), region, zone, machineType}, nil
+}
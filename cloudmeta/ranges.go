@@ -0,0 +1,271 @@
+package cloudmeta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Published IP range feeds. Azure's file is renamed every week, its
+// current URL is read from the download page.
+var (
+	awsRangesURL  = "https://ip-ranges.amazonaws.com/ip-ranges.json"
+	gcpRangesURL  = "https://www.gstatic.com/ipranges/cloud.json"
+	azurePageURL  = "https://www.microsoft.com/en-us/download/confirmation.aspx?id=56519"
+	azureFileLink = regexp.MustCompile(`https://download\.microsoft\.com/download/[^"']*/ServiceTags_Public_\d+\.json`)
+)
+
+var rangeClient = &http.Client{Timeout: time.Minute}
+
+// Range is the provider, service and region a published prefix belongs to
+type Range struct {
+	Provider string `json:"provider"` // aws, azure or gcp like Instance
+	Service  string `json:"service"`
+	Region   string `json:"region,omitempty"`
+}
+
+var providerNames = map[string]string{"aws": "AWS", "azure": "Azure", "gcp": "GCP"}
+
+func (r Range) String() string {
+	s := providerNames[r.Provider] + " " + r.Service
+	if r.Region != "" {
+		s += " " + r.Region
+	}
+	return s
+}
+
+// Ranges maps addresses to the most specific published range holding them
+type Ranges struct {
+	byLength map[int]map[netip.Prefix]rankedRange
+	lengths  []int // longest first
+	count    int
+}
+
+type rankedRange struct {
+	Range
+	rank int
+}
+
+func newRanges() *Ranges {
+	return &Ranges{byLength: make(map[int]map[netip.Prefix]rankedRange)}
+}
+
+// Feeds list broad entries like AWS's AMAZON or Azure's AzureCloud next to
+// the specific service for the same prefix, and global tags next to
+// regional ones. The most specific one is kept.
+func specificity(rg Range, generic bool) int {
+	rank := 0
+	if !generic {
+		rank += 2
+	}
+	if rg.Region != "" {
+		rank++
+	}
+	return rank
+}
+
+func (r *Ranges) add(prefix string, rg Range, generic bool) {
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return
+	}
+	p = p.Masked()
+	m := r.byLength[p.Bits()]
+	if m == nil {
+		m = make(map[netip.Prefix]rankedRange)
+		r.byLength[p.Bits()] = m
+		r.lengths = append(r.lengths, p.Bits())
+		sort.Sort(sort.Reverse(sort.IntSlice(r.lengths)))
+	}
+	rank := specificity(rg, generic)
+	old, ok := m[p]
+	if ok && old.rank > rank {
+		return
+	}
+	if !ok {
+		r.count++
+	}
+	m[p] = rankedRange{rg, rank}
+}
+
+// Len is the number of prefixes
+func (r *Ranges) Len() int {
+	if r == nil {
+		return 0
+	}
+	return r.count
+}
+
+// Lookup returns the range of the longest prefix holding addr
+func (r *Ranges) Lookup(addr netip.Addr) (Range, bool) {
+	if r == nil {
+		return Range{}, false
+	}
+	addr = addr.Unmap()
+	for _, bits := range r.lengths {
+		if bits > addr.BitLen() {
+			continue
+		}
+		p, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if rg, ok := r.byLength[bits][p]; ok {
+			return rg.Range, true
+		}
+	}
+	return Range{}, false
+}
+
+// FetchRanges downloads the AWS, Azure and GCP feeds. A feed that fails is
+// left out and reported in the error, the others are still returned.
+func FetchRanges(ctx context.Context) (*Ranges, error) {
+	r := newRanges()
+	var failed []string
+	for _, f := range []struct {
+		name  string
+		fetch func(context.Context, *Ranges) error
+	}{{"aws", fetchAWS}, {"azure", fetchAzure}, {"gcp", fetchGCP}} {
+		if err := f.fetch(ctx, r); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", f.name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return r, fmt.Errorf("cloud range feeds failed: %s", strings.Join(failed, "; "))
+	}
+	return r, nil
+}
+
+func fetchFeed(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := rangeClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func decodeFeed(ctx context.Context, url string, out interface{}) error {
+	body, err := fetchFeed(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(out); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", url, err)
+	}
+	return nil
+}
+
+func fetchAWS(ctx context.Context, r *Ranges) error {
+	var feed struct {
+		Prefixes []struct {
+			Prefix  string `json:"ip_prefix"`
+			Region  string `json:"region"`
+			Service string `json:"service"`
+		} `json:"prefixes"`
+		IPv6Prefixes []struct {
+			Prefix  string `json:"ipv6_prefix"`
+			Region  string `json:"region"`
+			Service string `json:"service"`
+		} `json:"ipv6_prefixes"`
+	}
+	if err := decodeFeed(ctx, awsRangesURL, &feed); err != nil {
+		return err
+	}
+	add := func(prefix, region, service string) {
+		if region == "GLOBAL" {
+			region = ""
+		}
+		r.add(prefix, Range{"aws", service, region}, service == "AMAZON")
+	}
+	for _, p := range feed.Prefixes {
+		add(p.Prefix, p.Region, p.Service)
+	}
+	for _, p := range feed.IPv6Prefixes {
+		add(p.Prefix, p.Region, p.Service)
+	}
+	return nil
+}
+
+func fetchGCP(ctx context.Context, r *Ranges) error {
+	var feed struct {
+		Prefixes []struct {
+			IPv4    string `json:"ipv4Prefix"`
+			IPv6    string `json:"ipv6Prefix"`
+			Service string `json:"service"`
+			Scope   string `json:"scope"`
+		} `json:"prefixes"`
+	}
+	if err := decodeFeed(ctx, gcpRangesURL, &feed); err != nil {
+		return err
+	}
+	for _, p := range feed.Prefixes {
+		rg := Range{"gcp", p.Service, p.Scope}
+		if rg.Region == "global" {
+			rg.Region = ""
+		}
+		for _, prefix := range []string{p.IPv4, p.IPv6} {
+			if prefix != "" {
+				r.add(prefix, rg, false)
+			}
+		}
+	}
+	return nil
+}
+
+func fetchAzure(ctx context.Context, r *Ranges) error {
+	page, err := fetchFeed(ctx, azurePageURL)
+	if err != nil {
+		return err
+	}
+	html, err := io.ReadAll(io.LimitReader(page, 4<<20))
+	page.Close()
+	if err != nil {
+		return err
+	}
+	url := azureFileLink.Find(html)
+	if url == nil {
+		return fmt.Errorf("no service tags link on %s", azurePageURL)
+	}
+
+	var feed struct {
+		Values []struct {
+			Name       string `json:"name"`
+			Properties struct {
+				Region          string   `json:"region"`
+				SystemService   string   `json:"systemService"`
+				AddressPrefixes []string `json:"addressPrefixes"`
+			} `json:"properties"`
+		} `json:"values"`
+	}
+	if err := decodeFeed(ctx, string(url), &feed); err != nil {
+		return err
+	}
+	for _, v := range feed.Values {
+		// AzureCloud.eastus covers every service of a region
+		service, generic := v.Properties.SystemService, false
+		if service == "" {
+			service, _, _ = strings.Cut(v.Name, ".")
+			generic = true
+		}
+		for _, prefix := range v.Properties.AddressPrefixes {
+			r.add(prefix, Range{"azure", service, v.Properties.Region}, generic)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"netwatchd/cloudmeta"
+	"netwatchd/netwatch"
+)
+
+const (
+	// The feeds change a few times a week
+	cloudRangesRefresh = 24 * time.Hour
+	// Remote addresses remembered with their range, the cache starts over past this
+	cloudCacheMax = 50000
+)
+
+// CloudDestination is the traffic between internal hosts and one service
+// and region of a cloud provider
+type CloudDestination struct {
+	Name     string `json:"name"` // e.g. AWS S3 us-east-1
+	Provider string `json:"provider"`
+	Service  string `json:"service"`
+	Region   string `json:"region,omitempty"`
+	Packets  int    `json:"packets"`
+	BytesOut int64  `json:"bytes_out"` // uploaded by internal hosts
+	BytesIn  int64  `json:"bytes_in"`
+	Hosts    int    `json:"hosts"` // internal hosts taking part
+}
+
+type cloudDest struct {
+	CloudDestination
+	hosts map[string]bool
+}
+
+// CloudRangeTracker attributes traffic with public addresses to the cloud
+// provider, service and region whose published IP ranges hold them. The
+// ranges are fetched in the background, until then nothing is counted. It
+// is guarded by the MonitoringData mutex.
+type CloudRangeTracker struct {
+	ranges *cloudmeta.Ranges
+	cache  map[string]*cloudDest // by remote address, nil when outside every range
+	dests  map[cloudmeta.Range]*cloudDest
+}
+
+func NewCloudRangeTracker() *CloudRangeTracker {
+	return &CloudRangeTracker{cache: make(map[string]*cloudDest), dests: make(map[cloudmeta.Range]*cloudDest)}
+}
+
+// Replacing the ranges after a refresh. Callers hold data.mu.
+func (t *CloudRangeTracker) setRanges(r *cloudmeta.Ranges) {
+	t.ranges = r
+	t.cache = make(map[string]*cloudDest)
+}
+
+func (t *CloudRangeTracker) lookup(remote string) *cloudDest {
+	if d, ok := t.cache[remote]; ok {
+		return d
+	}
+	var d *cloudDest
+	if addr, err := netip.ParseAddr(remote); err == nil {
+		if rg, ok := t.ranges.Lookup(addr); ok {
+			if d = t.dests[rg]; d == nil {
+				d = &cloudDest{CloudDestination: CloudDestination{Name: rg.String(), Provider: rg.Provider, Service: rg.Service, Region: rg.Region},
+					hosts: make(map[string]bool)}
+				t.dests[rg] = d
+			}
+		}
+	}
+	if len(t.cache) >= cloudCacheMax {
+		t.cache = make(map[string]*cloudDest)
+	}
+	t.cache[remote] = d
+	return d
+}
+
+func (t *CloudRangeTracker) Observe(p *netwatch.Packet) {
+	if t == nil || t.ranges == nil || p.SrcIP == "" {
+		return
+	}
+	switch {
+	case isLocalIP(p.SrcIP) && isPublicIP(p.DstIP):
+		if d := t.lookup(p.DstIP); d != nil {
+			d.Packets++
+			d.BytesOut += int64(p.Length)
+			d.hosts[p.SrcIP] = true
+		}
+	case isPublicIP(p.SrcIP) && isLocalIP(p.DstIP):
+		if d := t.lookup(p.SrcIP); d != nil {
+			d.Packets++
+			d.BytesIn += int64(p.Length)
+			d.hosts[p.DstIP] = true
+		}
+	}
+}
+
+// Destinations by volume, the biggest upload first
+func (t *CloudRangeTracker) Destinations() []CloudDestination {
+	if t == nil {
+		return nil
+	}
+	var dests []CloudDestination
+	for _, d := range t.dests {
+		if d.Packets == 0 {
+			continue
+		}
+		c := d.CloudDestination
+		c.Hosts = len(d.hosts)
+		dests = append(dests, c)
+	}
+	sort.Slice(dests, func(i, j int) bool {
+		if dests[i].BytesOut != dests[j].BytesOut {
+			return dests[i].BytesOut > dests[j].BytesOut
+		}
+		if dests[i].BytesIn != dests[j].BytesIn {
+			return dests[i].BytesIn > dests[j].BytesIn
+		}
+		return dests[i].Name < dests[j].Name
+	})
+	return dests
+}
+
+// Starting a new period, the ranges and cache are kept
+func (t *CloudRangeTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.dests = make(map[cloudmeta.Range]*cloudDest)
+	t.cache = make(map[string]*cloudDest)
+}
+
+// Fetching the cloud IP range feeds now and every day. A failed refresh
+// keeps the ranges fetched before.
+func refreshCloudRanges(ctx context.Context, data *MonitoringData) {
+	ticker := time.NewTicker(cloudRangesRefresh)
+	defer ticker.Stop()
+
+	for {
+		r, err := cloudmeta.FetchRanges(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Warn("failed to fetch cloud IP ranges", "err", err)
+		}
+		if r.Len() > 0 {
+			data.mu.Lock()
+			if err == nil || data.cloudRanges.ranges == nil {
+				data.cloudRanges.setRanges(r)
+			}
+			data.mu.Unlock()
+			logger.Debug("cloud IP ranges loaded", "prefixes", r.Len())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func printCloudRangeReport(t *CloudRangeTracker) {
+	dests := t.Destinations()
+	if len(dests) == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("CLOUD DESTINATIONS")
+	for i, d := range dests {
+		if i == 15 {
+			fmt.Printf("... %d more\n", len(dests)-i)
+			break
+		}
+		fmt.Printf("%s: %.2f MB up | %.2f MB down | %d hosts\n", d.Name,
+			float64(d.BytesOut)/(1024*1024), float64(d.BytesIn)/(1024*1024), d.Hosts)
+	}
+}
@@ -0,0 +1,627 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed netwatchd.schema.json
+var configSchema []byte
+
+// Config mirrors the command line flags. Keep netwatchd.schema.json in sync.
+type Config struct {
+	Interface          string              `json:"interface,omitempty"`
+	Duration           *captureDuration    `json:"duration,omitempty"`
+	Bucket             string              `json:"bucket,omitempty"`
+	Daemon             *bool               `json:"daemon,omitempty"`
+	Period             string              `json:"period,omitempty"`
+	User               string              `json:"user,omitempty"`
+	MaxCPUPercent      float64             `json:"max_cpu_percent,omitempty"`
+	MaxMemory          string              `json:"max_memory,omitempty"`
+	MinFreeDisk        string              `json:"min_free_disk,omitempty"`
+	Twamp              string              `json:"twamp,omitempty"`
+	TwampInterval      string              `json:"twamp_interval,omitempty"`
+	TwampReflect       string              `json:"twamp_reflect,omitempty"`
+	TUI                *bool               `json:"tui,omitempty"`
+	Quiet              *bool               `json:"quiet,omitempty"`
+	Debug              *bool               `json:"debug,omitempty"`
+	Web                string              `json:"web,omitempty"`
+	Webhook            string              `json:"webhook,omitempty"`
+	History            []string            `json:"history,omitempty"`
+	BlockKinds         []string            `json:"block_kinds,omitempty"`
+	BlockAllow         []string            `json:"block_allow,omitempty"`
+	BlockTTL           string              `json:"block_ttl,omitempty"`
+	BlockMax           *int                `json:"block_max,omitempty"`
+	BlockDryRun        *bool               `json:"block_dry_run,omitempty"`
+	Shape              []string            `json:"shape,omitempty"`
+	ShapeHours         []string            `json:"shape_hours,omitempty"`
+	ShapeApply         *bool               `json:"shape_apply,omitempty"`
+	AmpMinBytes        string              `json:"amp_min_bytes,omitempty"`
+	RecommendLimits    *bool               `json:"recommend_limits,omitempty"`
+	Filter             string              `json:"filter,omitempty"`
+	Capture            string              `json:"capture,omitempty"`
+	Bandwidth          *bool               `json:"bandwidth,omitempty"`
+	Adapter            string              `json:"adapter,omitempty"`
+	Journal            *bool               `json:"journal,omitempty"`
+	PerfCounters       *bool               `json:"perf_counters,omitempty"`
+	Inventory          string              `json:"inventory,omitempty"`
+	SegmentationReport string              `json:"segmentation_report,omitempty"`
+	Evidence           string              `json:"evidence,omitempty"`
+	Write              string              `json:"write,omitempty"`
+	Read               string              `json:"read,omitempty"`
+	Output             string              `json:"output,omitempty"`
+	ReportEvery        string              `json:"report_every,omitempty"`
+	ReportFile         string              `json:"report_file,omitempty"`
+	DB                 string              `json:"db,omitempty"`
+	Digest             string              `json:"digest,omitempty"`
+	DigestEmail        string              `json:"digest_email,omitempty"`
+	CSVFile            string              `json:"csv_file,omitempty"`
+	Checkpoint         string              `json:"checkpoint,omitempty"`
+	CheckpointInterval string              `json:"checkpoint_interval,omitempty"`
+	Dedup              *bool               `json:"dedup,omitempty"`
+	DedupWindow        string              `json:"dedup_window,omitempty"`
+	Labels             Labels              `json:"labels,omitempty"`
+	Matrix             string              `json:"matrix,omitempty"`
+	MatrixPrefix       *int                `json:"matrix_prefix,omitempty"`
+	MatrixPrefix6      *int                `json:"matrix_prefix6,omitempty"`
+	TopPorts           *int                `json:"top_ports,omitempty"`
+	DNSLog             string              `json:"dns_log,omitempty"`
+	PricePerGB         *float64            `json:"price_per_gb,omitempty"`
+	PricePerMbps       *float64            `json:"price_per_mbps,omitempty"`
+	Currency           string              `json:"currency,omitempty"`
+	CostAlert          *float64            `json:"cost_alert,omitempty"`
+	Hostapd            string              `json:"hostapd,omitempty"`
+	RadiusAcct         string              `json:"radius_acct,omitempty"`
+	RadiusSecret       string              `json:"radius_secret,omitempty"`
+	PayloadPatterns    []PayloadPattern    `json:"payload_patterns,omitempty"`
+	Thresholds         []ThresholdRule     `json:"thresholds,omitempty"`
+	Expected           []ExpectedFlow      `json:"expected,omitempty"`
+	Maintenance        []MaintenanceWindow `json:"maintenance,omitempty"`
+	SilenceFile        string              `json:"silence_file,omitempty"`
+	Sinks              []SinkConfig        `json:"sinks,omitempty"`
+	Routes             []Route             `json:"routes,omitempty"`
+	RARouters          []string            `json:"ra_routers,omitempty"`
+	ConntrackAlert     *float64            `json:"conntrack_alert,omitempty"`
+	CloudMetadata      *bool               `json:"cloud_metadata,omitempty"`
+	CloudRanges        *bool               `json:"cloud_ranges,omitempty"`
+	Probe              *string             `json:"probe,omitempty"`
+	OutageAfter        *int                `json:"outage_after,omitempty"`
+	HealthInterval     *int                `json:"health_interval,omitempty"`
+	DNSCheckName       string              `json:"dns_check_name,omitempty"`
+	WAN                []string            `json:"wan,omitempty"`
+	CertWarnDays       *int                `json:"cert_warn_days,omitempty"`
+	StartAt            string              `json:"start_at,omitempty"`
+	StartDelay         string              `json:"start_delay,omitempty"`
+	CheckInterval      *int                `json:"check_interval,omitempty"`
+	Checks             []CheckSpec         `json:"checks,omitempty"`
+	Segmentation       *SegmentationPolicy `json:"segmentation,omitempty"`
+}
+
+// Reading a JSON, YAML or TOML config file, rejecting unknown keys so typos
+// don't go unnoticed
+func loadConfig(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+	if raw, err = configJSON(path, raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	var cfg Config
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &cfg, nil
+}
+
+// YAML and TOML files are turned into JSON by their extension, so every
+// format is decoded and checked the same way
+func configJSON(path string, raw []byte) ([]byte, error) {
+	var v any
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		v, err = parseYAML(raw)
+	case ".toml":
+		v, err = parseTOML(raw)
+	default:
+		return raw, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		v = map[string]any{}
+	}
+	return json.Marshal(v)
+}
+
+// Checking the constraints declared in the schema, returning every problem found
+func (c *Config) Validate() []error {
+	var errs []error
+	if c.Bucket != "" {
+		if d, err := time.ParseDuration(c.Bucket); err != nil || d < time.Second {
+			errs = append(errs, fmt.Errorf("bucket: %q is not a duration of at least 1s", c.Bucket))
+		}
+	}
+	if c.Period != "" {
+		if d, err := time.ParseDuration(c.Period); err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("period: %q is not a positive duration", c.Period))
+		}
+	}
+	if c.ReportEvery != "" {
+		if d, err := time.ParseDuration(c.ReportEvery); err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("report_every: %q is not a positive duration", c.ReportEvery))
+		}
+	}
+	if c.MaxCPUPercent < 0 {
+		errs = append(errs, fmt.Errorf("max_cpu_percent: must not be negative"))
+	}
+	if c.MaxMemory != "" {
+		if _, err := parseBytes(c.MaxMemory); err != nil {
+			errs = append(errs, fmt.Errorf("max_memory: %v", err))
+		}
+	}
+	if c.MinFreeDisk != "" {
+		if _, err := parseBytes(c.MinFreeDisk); err != nil {
+			errs = append(errs, fmt.Errorf("min_free_disk: %v", err))
+		}
+	}
+	if c.AmpMinBytes != "" {
+		if _, err := parseBytes(c.AmpMinBytes); err != nil {
+			errs = append(errs, fmt.Errorf("amp_min_bytes: %v", err))
+		}
+	}
+	if c.BlockTTL != "" {
+		if d, err := time.ParseDuration(c.BlockTTL); err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("block_ttl: %q is not a positive duration", c.BlockTTL))
+		}
+	}
+	for i, a := range c.BlockAllow {
+		if _, err := parseAllowEntry(a); err != nil {
+			errs = append(errs, fmt.Errorf("block_allow[%d]: %v", i, err))
+		}
+	}
+	for i, s := range c.Shape {
+		if _, err := parseShapeRule(s); err != nil {
+			errs = append(errs, fmt.Errorf("shape[%d]: %v", i, err))
+		}
+	}
+	for i, h := range c.ShapeHours {
+		if _, err := parseDailyWindow(h); err != nil {
+			errs = append(errs, fmt.Errorf("shape_hours[%d]: %v", i, err))
+		}
+	}
+	if c.TwampInterval != "" {
+		if d, err := time.ParseDuration(c.TwampInterval); err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("twamp_interval: %q is not a positive duration", c.TwampInterval))
+		}
+	}
+	if c.DedupWindow != "" {
+		if d, err := time.ParseDuration(c.DedupWindow); err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("dedup_window: %q is not a positive duration", c.DedupWindow))
+		}
+	}
+	if c.StartDelay != "" {
+		if d, err := time.ParseDuration(c.StartDelay); err != nil || d < 0 {
+			errs = append(errs, fmt.Errorf("start_delay: %q is not a duration", c.StartDelay))
+		}
+	}
+	if c.StartAt != "" {
+		if _, err := parseStartAt(c.StartAt, time.Now()); err != nil {
+			errs = append(errs, fmt.Errorf("start_at: %v", err))
+		}
+		if c.StartDelay != "" {
+			errs = append(errs, errors.New("start_at: cannot be combined with start_delay"))
+		}
+	}
+	if c.MatrixPrefix != nil && (*c.MatrixPrefix < 0 || *c.MatrixPrefix > 32) {
+		errs = append(errs, errors.New("matrix_prefix: must be between 0 and 32"))
+	}
+	if c.MatrixPrefix6 != nil && (*c.MatrixPrefix6 < 0 || *c.MatrixPrefix6 > 128) {
+		errs = append(errs, errors.New("matrix_prefix6: must be between 0 and 128"))
+	}
+	if c.TopPorts != nil && *c.TopPorts < 0 {
+		errs = append(errs, errors.New("top_ports: must not be negative"))
+	}
+	for name, v := range map[string]*float64{"price_per_gb": c.PricePerGB, "price_per_mbps": c.PricePerMbps, "cost_alert": c.CostAlert} {
+		if v != nil && *v < 0 {
+			errs = append(errs, fmt.Errorf("%s: must not be negative", name))
+		}
+	}
+	if c.Output != "" && c.Output != "text" && c.Output != "json" && c.Output != "csv" {
+		errs = append(errs, errors.New("output: must be text, json or csv"))
+	}
+	if c.CheckpointInterval != "" {
+		if d, err := time.ParseDuration(c.CheckpointInterval); err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("checkpoint_interval: %q is not a positive duration", c.CheckpointInterval))
+		}
+	}
+	if c.RadiusAcct != "" && c.RadiusSecret == "" {
+		errs = append(errs, errors.New("radius_secret: required with radius_acct"))
+	}
+	for i, p := range c.PayloadPatterns {
+		if _, err := p.compile(); err != nil {
+			errs = append(errs, fmt.Errorf("payload_patterns[%d]: %v", i, err))
+		}
+	}
+	for i, r := range c.Thresholds {
+		if _, err := r.compile(time.Minute); err != nil {
+			errs = append(errs, fmt.Errorf("thresholds[%d]: %v", i, err))
+		}
+	}
+	for i, e := range c.Expected {
+		if err := e.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("expected[%d]: %v", i, err))
+		}
+	}
+	for i, m := range c.Maintenance {
+		if err := m.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("maintenance[%d]: %v", i, err))
+		}
+	}
+	for i, s := range c.Sinks {
+		if err := s.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("sinks[%d]: %v", i, err))
+		}
+	}
+	for i, r := range c.Routes {
+		if err := r.validate(c.Sinks); err != nil {
+			errs = append(errs, fmt.Errorf("routes[%d]: %v", i, err))
+		}
+	}
+	for k := range c.Labels {
+		if err := validLabelKey(k); err != nil {
+			errs = append(errs, fmt.Errorf("labels: %v", err))
+		}
+	}
+	if c.Inventory != "" {
+		if ext := strings.ToLower(filepath.Ext(c.Inventory)); ext != ".csv" && ext != ".json" {
+			errs = append(errs, fmt.Errorf("inventory: %q must end in .csv or .json", c.Inventory))
+		}
+	}
+	for i, r := range c.RARouters {
+		if net.ParseIP(r) == nil {
+			if _, err := net.ParseMAC(r); err != nil {
+				errs = append(errs, fmt.Errorf("ra_routers[%d]: %q is not an IP or MAC address", i, r))
+			}
+		}
+	}
+	if c.ConntrackAlert != nil && (*c.ConntrackAlert <= 0 || *c.ConntrackAlert > 100) {
+		errs = append(errs, errors.New("conntrack_alert: must be above 0 and at most 100"))
+	}
+	if c.Probe != nil && *c.Probe != "" {
+		if _, _, err := net.SplitHostPort(*c.Probe); err != nil {
+			errs = append(errs, fmt.Errorf("probe: %v", err))
+		}
+	}
+	if c.OutageAfter != nil && *c.OutageAfter < 1 {
+		errs = append(errs, errors.New("outage_after: must be at least 1 second"))
+	}
+	for i, check := range c.Checks {
+		if err := check.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("checks[%d]: %v", i, err))
+		}
+	}
+	if c.Segmentation != nil {
+		if err := c.Segmentation.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("segmentation: %v", err))
+		}
+	}
+	if c.CheckInterval != nil && *c.CheckInterval < 1 {
+		errs = append(errs, errors.New("check_interval: must be at least 1 second"))
+	}
+	if c.HealthInterval != nil && *c.HealthInterval < 0 {
+		errs = append(errs, errors.New("health_interval: must not be negative"))
+	}
+	return errs
+}
+
+// Flag values set by the config, keyed by flag name
+func (c *Config) flagValues() map[string]string {
+	v := make(map[string]string)
+	if c.Interface != "" {
+		v["i"] = c.Interface
+	}
+	if c.Duration != nil {
+		v["d"] = c.Duration.String()
+	}
+	if c.Bucket != "" {
+		v["bucket"] = c.Bucket
+	}
+	if c.Daemon != nil {
+		v["daemon"] = strconv.FormatBool(*c.Daemon)
+	}
+	if c.Period != "" {
+		v["period"] = c.Period
+	}
+	if c.User != "" {
+		v["user"] = c.User
+	}
+	if c.MaxCPUPercent > 0 {
+		v["max-cpu-percent"] = strconv.FormatFloat(c.MaxCPUPercent, 'f', -1, 64)
+	}
+	if c.MaxMemory != "" {
+		v["max-memory"] = c.MaxMemory
+	}
+	if c.MinFreeDisk != "" {
+		v["min-free-disk"] = c.MinFreeDisk
+	}
+	if c.Twamp != "" {
+		v["twamp"] = c.Twamp
+	}
+	if c.TwampInterval != "" {
+		v["twamp-interval"] = c.TwampInterval
+	}
+	if c.TwampReflect != "" {
+		v["twamp-reflect"] = c.TwampReflect
+	}
+	if c.Filter != "" {
+		v["f"] = c.Filter
+	}
+	if c.Bandwidth != nil {
+		v["b"] = strconv.FormatBool(*c.Bandwidth)
+	}
+	if c.Adapter != "" {
+		v["a"] = c.Adapter
+	}
+	if c.Inventory != "" {
+		v["inventory"] = c.Inventory
+	}
+	if c.Dedup != nil {
+		v["dedup"] = strconv.FormatBool(*c.Dedup)
+	}
+	if c.DedupWindow != "" {
+		v["dedup-window"] = c.DedupWindow
+	}
+	if c.MatrixPrefix != nil {
+		v["matrix-prefix"] = strconv.Itoa(*c.MatrixPrefix)
+	}
+	if c.TopPorts != nil {
+		v["top-ports"] = strconv.Itoa(*c.TopPorts)
+	}
+	if c.DNSLog != "" {
+		v["dns-log"] = c.DNSLog
+	}
+	if c.MatrixPrefix6 != nil {
+		v["matrix-prefix6"] = strconv.Itoa(*c.MatrixPrefix6)
+	}
+	if c.Matrix != "" {
+		v["matrix"] = c.Matrix
+	}
+	if c.PricePerGB != nil {
+		v["price-per-gb"] = strconv.FormatFloat(*c.PricePerGB, 'f', -1, 64)
+	}
+	if c.PricePerMbps != nil {
+		v["price-per-mbps"] = strconv.FormatFloat(*c.PricePerMbps, 'f', -1, 64)
+	}
+	if c.Currency != "" {
+		v["currency"] = c.Currency
+	}
+	if c.CostAlert != nil {
+		v["cost-alert"] = strconv.FormatFloat(*c.CostAlert, 'f', -1, 64)
+	}
+	if c.Hostapd != "" {
+		v["hostapd"] = c.Hostapd
+	}
+	if c.RadiusAcct != "" {
+		v["radius-acct"] = c.RadiusAcct
+	}
+	if c.RadiusSecret != "" {
+		v["radius-secret"] = c.RadiusSecret
+	}
+	if c.SilenceFile != "" {
+		v["silences"] = c.SilenceFile
+	}
+	if c.Capture != "" {
+		v["capture"] = c.Capture
+	}
+	if c.Journal != nil {
+		v["journal"] = strconv.FormatBool(*c.Journal)
+	}
+	if c.AmpMinBytes != "" {
+		v["amp-min-bytes"] = c.AmpMinBytes
+	}
+	if c.RecommendLimits != nil {
+		v["recommend-limits"] = strconv.FormatBool(*c.RecommendLimits)
+	}
+	if len(c.History) > 0 {
+		v["history"] = strings.Join(c.History, ",")
+	}
+	if c.Webhook != "" {
+		v["webhook"] = c.Webhook
+	}
+	if c.Web != "" {
+		v["web"] = c.Web
+	}
+	if len(c.BlockKinds) > 0 {
+		v["block-kinds"] = strings.Join(c.BlockKinds, ",")
+	}
+	if len(c.BlockAllow) > 0 {
+		v["block-allow"] = strings.Join(c.BlockAllow, ",")
+	}
+	if c.BlockTTL != "" {
+		v["block-ttl"] = c.BlockTTL
+	}
+	if c.BlockMax != nil {
+		v["block-max"] = strconv.Itoa(*c.BlockMax)
+	}
+	if c.BlockDryRun != nil {
+		v["block-dry-run"] = strconv.FormatBool(*c.BlockDryRun)
+	}
+	if len(c.Shape) > 0 {
+		v["shape"] = strings.Join(c.Shape, ",")
+	}
+	if len(c.ShapeHours) > 0 {
+		v["shape-hours"] = strings.Join(c.ShapeHours, ",")
+	}
+	if c.ShapeApply != nil {
+		v["shape-apply"] = strconv.FormatBool(*c.ShapeApply)
+	}
+	if c.TUI != nil {
+		v["tui"] = strconv.FormatBool(*c.TUI)
+	}
+	if c.Quiet != nil {
+		v["quiet"] = strconv.FormatBool(*c.Quiet)
+	}
+	if c.Debug != nil {
+		v["debug"] = strconv.FormatBool(*c.Debug)
+	}
+	if c.PerfCounters != nil {
+		v["perf-counters"] = strconv.FormatBool(*c.PerfCounters)
+	}
+	if c.Output != "" {
+		v["o"] = c.Output
+	}
+	if c.Checkpoint != "" {
+		v["checkpoint"] = c.Checkpoint
+	}
+	if c.CheckpointInterval != "" {
+		v["checkpoint-interval"] = c.CheckpointInterval
+	}
+	if c.CSVFile != "" {
+		v["csv-file"] = c.CSVFile
+	}
+	if c.ReportEvery != "" {
+		v["report-every"] = c.ReportEvery
+	}
+	if c.ReportFile != "" {
+		v["report-file"] = c.ReportFile
+	}
+	if c.DB != "" {
+		v["db"] = c.DB
+	}
+	if c.Digest != "" {
+		v["digest"] = c.Digest
+	}
+	if c.DigestEmail != "" {
+		v["digest-email"] = c.DigestEmail
+	}
+	if c.Evidence != "" {
+		v["evidence"] = c.Evidence
+	}
+	if c.Write != "" {
+		v["write"] = c.Write
+	}
+	if c.Read != "" {
+		v["read"] = c.Read
+	}
+	if c.SegmentationReport != "" {
+		v["segmentation-report"] = c.SegmentationReport
+	}
+	if len(c.RARouters) > 0 {
+		v["ra-routers"] = strings.Join(c.RARouters, ",")
+	}
+	if c.ConntrackAlert != nil {
+		v["conntrack-alert"] = strconv.FormatFloat(*c.ConntrackAlert, 'f', -1, 64)
+	}
+	if c.CloudMetadata != nil {
+		v["cloud-metadata"] = strconv.FormatBool(*c.CloudMetadata)
+	}
+	if c.CloudRanges != nil {
+		v["cloud-ranges"] = strconv.FormatBool(*c.CloudRanges)
+	}
+	if c.Probe != nil {
+		v["probe"] = *c.Probe
+	}
+	if c.OutageAfter != nil {
+		v["outage-after"] = strconv.Itoa(*c.OutageAfter)
+	}
+	if c.HealthInterval != nil {
+		v["health-interval"] = strconv.Itoa(*c.HealthInterval)
+	}
+	if c.DNSCheckName != "" {
+		v["dns-check-name"] = c.DNSCheckName
+	}
+	if len(c.WAN) > 0 {
+		v["wan"] = strings.Join(c.WAN, ",")
+	}
+	if c.StartAt != "" {
+		v["start-at"] = c.StartAt
+	}
+	if c.StartDelay != "" {
+		v["start-delay"] = c.StartDelay
+	}
+	if c.CertWarnDays != nil {
+		v["cert-warn-days"] = strconv.Itoa(*c.CertWarnDays)
+	}
+	if c.CheckInterval != nil {
+		v["check-interval"] = strconv.Itoa(*c.CheckInterval)
+	}
+	return v
+}
+
+// Applying config values to every flag not given on the command line.
+// Settings without a single flag equivalent are read from the returned config.
+func applyConfig(path string) (*Config, error) {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if errs := cfg.Validate(); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for name, value := range cfg.flagValues() {
+		if set[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return nil, fmt.Errorf("config value for -%s: %v", name, err)
+		}
+	}
+	return cfg, nil
+}
+
+// netwatchd config validate <file> | netwatchd config schema
+func runConfigCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Println("Usage: netwatchd config validate <file> | netwatchd config schema")
+		return 2
+	}
+
+	switch args[0] {
+	case "schema":
+		os.Stdout.Write(configSchema)
+		return 0
+	case "validate":
+		if len(args) != 2 {
+			fmt.Println("Usage: netwatchd config validate <file>")
+			return 2
+		}
+		cfg, err := loadConfig(args[1])
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		errs := cfg.Validate()
+		for _, e := range errs {
+			fmt.Printf("%s: %v\n", args[1], e)
+		}
+		if len(errs) > 0 {
+			return 1
+		}
+		fmt.Printf("%s: OK\n", args[1])
+		return 0
+	default:
+		fmt.Printf("Unknown config command %q\n", args[0])
+		return 2
+	}
+}
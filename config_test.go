@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	want := &Config{Interface: "eth0", Bucket: "5s", Labels: Labels{"site": "lab", "rack": "7", "u": "1.5", "spare": "true"}}
+	tests := []struct {
+		name, file, content string
+	}{
+		{"json", "c.json", `{"interface": "eth0", "bucket": "5s", "labels": {"site": "lab", "rack": 7, "u": 1.5, "spare": true}}`},
+		{"yaml", "c.yaml", "# capture\ninterface: eth0\nbucket: '5s'\nlabels:\n  site: lab # comment\n  rack: 7\n  u: 1.5\n  spare: true\n"},
+		{"toml", "c.toml", "interface = \"eth0\"\nbucket = '5s'\n\n[labels]\nsite = \"lab\"\nrack = 7 # comment\nu = 1.5\nspare = true\n"},
+		{"toml inline", "c.toml", "interface = \"eth0\"\nbucket = \"5s\"\nlabels = { site = \"lab\", rack = 7, u = 1.5, spare = true }\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadConfig(writeConfig(t, tt.file, tt.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cfg, want) {
+				t.Errorf("got %+v, want %+v", cfg, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name, file, content string
+	}{
+		{"unknown key", "c.yaml", "interfaces: eth0\n"},
+		{"label list", "c.yaml", "labels:\n  rack: [1, 2]\n"},
+		{"label table", "c.toml", "[labels.rack]\nrow = 1\n"},
+		{"labels not a map", "c.json", `{"labels": ["a"]}`},
+		{"bad yaml", "c.yml", "interface: 'eth0\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if cfg, err := loadConfig(writeConfig(t, tt.file, tt.content)); err == nil {
+				t.Errorf("loaded %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	saved := flag.CommandLine
+	defer func() { flag.CommandLine = saved }()
+
+	tests := []struct {
+		name string
+		args []string
+		want map[string]string
+	}{
+		{"config only", nil, map[string]string{"i": "eth0", "bucket": "5s", "f": "tcp"}},
+		{"flags win", []string{"-i", "wlan0", "-f", ""}, map[string]string{"i": "wlan0", "bucket": "5s", "f": ""}},
+		{"flag at its default", []string{"-bucket", "1s"}, map[string]string{"i": "eth0", "bucket": "1s", "f": "tcp"}},
+	}
+	path := writeConfig(t, "c.yaml", "interface: eth0\nbucket: 5s\nfilter: tcp\n")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flag.CommandLine = flag.NewFlagSet("netwatchd", flag.ContinueOnError)
+			flag.String("i", "", "")
+			flag.String("bucket", "1s", "")
+			flag.String("f", "", "")
+			if err := flag.CommandLine.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := applyConfig(path); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.want {
+				if got := flag.Lookup(name).Value.String(); got != want {
+					t.Errorf("-%s is %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	linux "netwatchd/netstat"
+)
+
+// ConntrackReport summarizes netfilter connection tracking over the run
+type ConntrackReport struct {
+	Max          uint64
+	Current      uint64
+	Peak         uint64
+	NewTotal     uint64
+	PeakNewRate  float64
+	InsertFailed uint64
+	Dropped      uint64
+	elapsed      time.Duration
+}
+
+// Sampling conntrack usage and alerting when the table nears exhaustion
+func monitorConntrack(ctx context.Context, data *MonitoringData, alertPercent float64) {
+	first, err := linux.GetConntrack()
+	if err != nil {
+		return
+	}
+
+	report := &ConntrackReport{Max: first.Max, Current: first.Count, Peak: first.Count}
+	data.mu.Lock()
+	data.conntrack = report
+	data.mu.Unlock()
+
+	const interval = 5 * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := first
+	alerted := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			cur, err := linux.GetConntrack()
+			if err != nil {
+				continue
+			}
+
+			data.mu.Lock()
+			report.Max = cur.Max
+			report.Current = cur.Count
+			if cur.Count > report.Peak {
+				report.Peak = cur.Count
+			}
+			if cur.Inserted >= last.Inserted {
+				added := cur.Inserted - last.Inserted
+				report.NewTotal += added
+				if rate := float64(added) / interval.Seconds(); rate > report.PeakNewRate {
+					report.PeakNewRate = rate
+				}
+			}
+			report.InsertFailed = cur.InsertFailed - first.InsertFailed
+			report.Dropped = (cur.Dropped + cur.EarlyDropped) - (first.Dropped + first.EarlyDropped)
+			report.elapsed += interval
+
+			usage := float64(cur.Count) / float64(cur.Max) * 100
+			if cur.Max > 0 && usage >= alertPercent && !alerted {
+				alerted = true
+				data.addAlert("conntrack", fmt.Sprintf("conntrack table %.0f%% full (%d of %d entries)", usage, cur.Count, cur.Max), now)
+			} else if usage < alertPercent*0.9 {
+				alerted = false
+			}
+			data.mu.Unlock()
+			last = cur
+		}
+	}
+}
+
+func printConntrackReport(r *ConntrackReport) {
+	if r == nil || r.Max == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("CONNTRACK")
+	fmt.Printf("Entries: %d of %d (%.1f%%), peak %d (%.1f%%)\n",
+		r.Current, r.Max, float64(r.Current)/float64(r.Max)*100,
+		r.Peak, float64(r.Peak)/float64(r.Max)*100)
+	if r.elapsed > 0 {
+		fmt.Printf("New connections: %d (avg %.1f/s, peak %.1f/s)\n", r.NewTotal, float64(r.NewTotal)/r.elapsed.Seconds(), r.PeakNewRate)
+	}
+	if r.InsertFailed > 0 || r.Dropped > 0 {
+		fmt.Printf("WARNING: %d insert failures and %d drops, new connections are being refused\n", r.InsertFailed, r.Dropped)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Billing periods are treated as 30 days
+const billingMonth = 30 * 24 * time.Hour
+
+// Pricing turns measured usage into money. Either rate may be zero.
+type Pricing struct {
+	PerGB        float64 // per GiB transferred
+	PerMbps95    float64 // per Mbps of 95th percentile per month
+	Currency     string
+	MonthlyAlert float64 // alert when the projected month exceeds this
+	alerted      bool
+}
+
+func (p *Pricing) enabled() bool {
+	return p != nil && (p.PerGB > 0 || p.PerMbps95 > 0)
+}
+
+func (p *Pricing) money(v float64) string {
+	return fmt.Sprintf("%s%.2f", p.Currency, v)
+}
+
+// Cost so far and projected for a 30 day month at the same rate
+func (p *Pricing) estimate(bytes float64, elapsed time.Duration, p95 float64) (sofar, month float64) {
+	if elapsed <= 0 {
+		return 0, 0
+	}
+	gb := bytes / (1024 * 1024 * 1024)
+	sofar = gb * p.PerGB
+	month = sofar * float64(billingMonth) / float64(elapsed)
+	// 95th percentile billing is a monthly charge on the peak rate
+	commit := p95 / 1e6 * p.PerMbps95
+	return sofar + commit*float64(elapsed)/float64(billingMonth), month + commit
+}
+
+// Alerting once when the projection passes the budget. Callers hold data.mu.
+func (data *MonitoringData) checkCostBudget(at time.Time) {
+	p := data.pricing
+	if !p.enabled() || p.MonthlyAlert <= 0 || p.alerted {
+		return
+	}
+	buckets := reportBuckets(data, at)
+	var bytes float64
+	for _, b := range buckets {
+		bytes += b.Bytes
+	}
+	p95, _ := percentile95(buckets)
+	_, month := p.estimate(bytes, at.Sub(data.startTime), p95)
+	if month > p.MonthlyAlert {
+		p.alerted = true
+		data.addAlert("cost", fmt.Sprintf("projected %s this month at current rate, budget is %s",
+			p.money(month), p.money(p.MonthlyAlert)), at)
+	}
+}
+
+func printCostReport(p *Pricing, buckets []ReportBucket, elapsed time.Duration) {
+	if !p.enabled() {
+		return
+	}
+	var bytes float64
+	for _, b := range buckets {
+		bytes += b.Bytes
+	}
+	p95, _ := percentile95(buckets)
+	sofar, month := p.estimate(bytes, elapsed, p95)
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("COST ESTIMATE")
+	if p.PerGB > 0 {
+		fmt.Printf("Transfer: %.3f GB at %s/GB\n", bytes/(1024*1024*1024), p.money(p.PerGB))
+	}
+	if p.PerMbps95 > 0 {
+		fmt.Printf("95th percentile: %s at %s/Mbps per month\n", formatBitrate(p95), p.money(p.PerMbps95))
+	}
+	fmt.Printf("This window: %s | projected %s this month at current rate\n", p.money(sofar), p.money(month))
+	if p.MonthlyAlert > 0 && month > p.MonthlyAlert {
+		fmt.Printf("WARNING: projection exceeds the %s budget\n", p.money(p.MonthlyAlert))
+	}
+}
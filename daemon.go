@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// periodReports closes a report every period in daemon mode and starts the
+// next one empty, so memory stays bounded however long netwatchd runs
+type periodReports struct {
+	every      time.Duration
+	format     string
+	out        io.Writer
+	reportFile string
+	db         string
+}
+
+// Reporting and resetting once the current period is complete. Runs right
+// after a bucket rotation, callers hold data.mu.
+func (data *MonitoringData) flushPeriod() {
+	p := data.periods
+	if p == nil {
+		return
+	}
+	end := data.buckets.Current.Start
+	if end.Sub(data.startTime) < p.every {
+		return
+	}
+
+	r := buildReport(data, end)
+	if p.format == "text" {
+		printReport(data, end)
+	} else if err := writeReportAs(p.format, p.out, r); err != nil {
+		logger.Error("failed to print period report", "err", err)
+	}
+	if p.reportFile != "" {
+		path := periodFileName(p.reportFile, data.startTime)
+		if err := writeReport(path, r); err != nil {
+			fmt.Println(err)
+		} else {
+			fmt.Printf("Report written to %s\n", path)
+		}
+	}
+	if p.db != "" {
+		storeSession(p.db, r)
+	}
+	data.digest.check(end)
+	data.resetPeriod(end)
+}
+
+// Reporting the buckets closed so far without stopping the capture or
+// resetting anything, on SIGUSR1 or every -report-every. The bucket being
+// filled is left for the next report. Callers hold data.mu.
+func (data *MonitoringData) interimReport(format string, out io.Writer, reportFile string) {
+	end := data.buckets.Current.Start
+	if len(data.buckets.Closed) == 0 {
+		fmt.Printf("-- no %s closed yet, nothing to report --\n", bucketUnit(data.bucket))
+		return
+	}
+	fmt.Printf("-- interim report up to %s, capture continues --\n", end.Format("15:04:05"))
+	r := buildReport(data, end)
+	if format == "text" {
+		printReport(data, end)
+	} else if err := writeReportAs(format, out, r); err != nil {
+		logger.Error("failed to print interim report", "err", err)
+	}
+	if reportFile != "" {
+		if data.periods != nil {
+			reportFile = periodFileName(reportFile, data.startTime)
+		}
+		if err := writeReport(reportFile, r); err != nil {
+			fmt.Println(err)
+		} else {
+			fmt.Printf("Report written to %s\n", reportFile)
+		}
+	}
+}
+
+// report.json becomes report-20261014T150000.json for the period starting then
+func periodFileName(path string, start time.Time) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + start.Format("20060102T150405") + ext
+}
+
+// Glob matching every periodFileName of path, they sort oldest first
+func periodFilePattern(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-*" + ext
+}
+
+// Dropping the series, events and per flow state of the reported period, so
+// a long running daemon stays within bounded memory. Hosts, the traffic
+// matrix and the router and spanning tree trackers, which only grow with the
+// network, keep their state.
+func (data *MonitoringData) resetPeriod(start time.Time) {
+	data.startTime = start
+	data.inventory.StartPeriod(start)
+	data.buckets.Closed = nil
+	data.bandwidthBuckets = nil
+	data.sentBuckets = nil
+	data.recvBuckets = nil
+	data.resetInterfaces()
+	data.pausedBuckets = nil
+	data.routeChurnBuckets = nil
+	data.perSecond = nil
+	data.alerts = nil
+	data.outages = nil
+	data.wanEvents = nil
+	data.bondEvents = nil
+	data.routeAdds, data.routeDels = 0, 0
+	for _, c := range data.syntheticChecks {
+		c.Buckets = nil
+	}
+	if data.patterns != nil {
+		data.patterns.buckets = nil
+	}
+	data.protocols.reset()
+	data.encryptedDNS.reset()
+	data.fileServices.reset()
+	data.callQuality.reset()
+	data.sipCalls.reset()
+	data.games.reset()
+	data.p2p.reset()
+	data.cloudRanges.reset()
+	data.services.reset()
+	data.updates.reset()
+	data.ports.reset()
+	data.flows.reset()
+	data.dns.reset()
+	data.linkHealth.reset()
+	data.amplification.reset()
+	data.rates.reset()
+	data.exposure.reset()
+	data.weakProtocols.reset()
+	data.certs.reset()
+	data.segmentation.reset()
+	if data.twamp != nil {
+		data.twamp.Buckets = nil
+	}
+	if data.pricing != nil {
+		data.pricing.alerted = false
+	}
+	if data.limits != nil {
+		data.limits.Skipped = 0
+	}
+}
@@ -0,0 +1,127 @@
+//go:build darwin
+
+// Package darwin reads macOS interface byte counters from the routing socket
+// sysctl (NET_RT_IFLIST), following the shape of the pdh and netstat packages.
+package darwin
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+type Counter struct {
+	interfaceName string
+	counterType   string // "rx" or "tx"
+	last          uint32
+	primed        bool
+}
+
+type ifCounters struct {
+	name   string
+	up     bool
+	rx, tx uint32
+}
+
+// Reading the if_data of every interface. The counters are 32 bit, deltas
+// are taken modulo 2^32 so a single wrap between reads is harmless.
+func readInterfaces() ([]ifCounters, error) {
+	rib, err := syscall.RouteRIB(syscall.NET_RT_IFLIST, 0)
+	if err != nil {
+		return nil, fmt.Errorf("sysctl NET_RT_IFLIST: %v", err)
+	}
+	msgs, err := syscall.ParseRoutingMessage(rib)
+	if err != nil {
+		return nil, fmt.Errorf("parse NET_RT_IFLIST: %v", err)
+	}
+	var out []ifCounters
+	for _, m := range msgs {
+		im, ok := m.(*syscall.InterfaceMessage)
+		if !ok {
+			continue
+		}
+		ifi, err := net.InterfaceByIndex(int(im.Header.Index))
+		if err != nil {
+			continue
+		}
+		out = append(out, ifCounters{
+			name: ifi.Name,
+			up:   im.Header.Flags&syscall.IFF_UP != 0,
+			rx:   im.Header.Data.Ibytes,
+			tx:   im.Header.Data.Obytes,
+		})
+	}
+	return out, nil
+}
+
+func Initialize() error {
+	_, err := readInterfaces()
+	return err
+}
+
+func Cleanup() {
+}
+
+// Interfaces that are up and have moved traffic, loopback is only left
+// out of auto-selection
+func GetNetworkAdapters() ([]string, error) {
+	ifs, err := readInterfaces()
+	if err != nil {
+		return nil, err
+	}
+	var adapters []string
+	for _, i := range ifs {
+		if i.name != "lo0" && i.up && i.rx+i.tx > 0 {
+			adapters = append(adapters, i.name)
+		}
+	}
+	return adapters, nil
+}
+
+func NewCounter(adapterName, counterType string) (*Counter, error) {
+	if _, err := net.InterfaceByName(adapterName); err != nil {
+		return nil, fmt.Errorf("network adapter '%s' not found", adapterName)
+	}
+	var cType string
+	switch counterType {
+	case "Bytes Sent/sec":
+		cType = "tx"
+	case "Bytes Received/sec":
+		cType = "rx"
+	default:
+		return nil, fmt.Errorf("unsupported counter type: %s", counterType)
+	}
+	return &Counter{interfaceName: adapterName, counterType: cType}, nil
+}
+
+func CollectData() error {
+	return nil
+}
+
+// Returning the bytes since the previous read, the first read only records the baseline
+func (c *Counter) GetValue() (float64, error) {
+	ifs, err := readInterfaces()
+	if err != nil {
+		return 0, err
+	}
+	for _, i := range ifs {
+		if i.name != c.interfaceName {
+			continue
+		}
+		v := i.rx
+		if c.counterType == "tx" {
+			v = i.tx
+		}
+		if !c.primed {
+			c.last, c.primed = v, true
+			return 0, nil
+		}
+		delta := v - c.last
+		c.last = v
+		return float64(delta), nil
+	}
+	return 0, fmt.Errorf("interface %s not found", c.interfaceName)
+}
+
+func (c *Counter) Close() {
+}
@@ -0,0 +1,35 @@
+//go:build !darwin
+
+package darwin
+
+import "errors"
+
+var errNotDarwin = errors.New("interface counters through NET_RT_IFLIST are only available on macOS")
+
+type Counter struct{}
+
+func Initialize() error {
+	return errNotDarwin
+}
+
+func Cleanup() {
+}
+
+func GetNetworkAdapters() ([]string, error) {
+	return nil, errNotDarwin
+}
+
+func NewCounter(adapterName, counterType string) (*Counter, error) {
+	return nil, errNotDarwin
+}
+
+func CollectData() error {
+	return errNotDarwin
+}
+
+func (c *Counter) GetValue() (float64, error) {
+	return 0, errNotDarwin
+}
+
+func (c *Counter) Close() {
+}
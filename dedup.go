@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// Deduper drops copies of the same packet seen on more than one interface,
+// e.g. a bond and its members or a bridge and its ports under -i any.
+// It is guarded by the MonitoringData mutex.
+type Deduper struct {
+	window    time.Duration
+	seen      map[string]time.Time
+	lastPrune time.Time
+	Dropped   int
+}
+
+func NewDeduper(window time.Duration) *Deduper {
+	return &Deduper{window: window, seen: make(map[string]time.Time)}
+}
+
+// Packets are identical when the flow, IP ID, TCP sequence and length all
+// match. Without an IP ID or sequence number there is nothing to tell a copy
+// from a genuine repeat, so those packets are always counted.
+func dedupKey(p *netwatch.Packet) string {
+	if p.IPID == "" && p.TCPSeq == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s %s:%d %s:%d %s %s %d", p.Transport, p.SrcIP, p.SrcPort, p.DstIP, p.DstPort, p.IPID, p.TCPSeq, p.Length)
+}
+
+// Duplicate reports whether p is a copy of a packet seen within the window
+func (d *Deduper) Duplicate(p *netwatch.Packet) bool {
+	if d == nil {
+		return false
+	}
+	key := dedupKey(p)
+	if key == "" {
+		return false
+	}
+
+	if p.Time.Sub(d.lastPrune) > time.Second {
+		for k, t := range d.seen {
+			if p.Time.Sub(t) > d.window {
+				delete(d.seen, k)
+			}
+		}
+		d.lastPrune = p.Time
+	}
+
+	if t, ok := d.seen[key]; ok && absDuration(p.Time.Sub(t)) <= d.window {
+		d.Dropped++
+		return true
+	}
+	d.seen[key] = p.Time
+	return false
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
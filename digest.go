@@ -0,0 +1,610 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Digest sums up a week of reports and compares it with the week before
+type Digest struct {
+	Hosts    []string
+	From, To time.Time
+	digestTotals
+	Prev    *digestTotals // nil without reports of the week before
+	Days    []digestTotals
+	Peaks   []digestTotals // busiest hours
+	Talkers []digestTalker
+	// Local hosts no earlier report saw, nil when no earlier report lists hosts
+	NewHosts []Host
+	Alerts   []digestAlerts
+	Changes  []string
+}
+
+type digestTotals struct {
+	Start   time.Time
+	Seconds float64 // covered by buckets
+	Packets int
+	Bytes   float64
+}
+
+type digestTalker struct {
+	IP        string
+	Name      string
+	Bytes     int64
+	PrevBytes int64
+}
+
+type digestAlerts struct {
+	Kind  string
+	Count int
+	Prev  int
+}
+
+// Monday 00:00 of the week t is in
+func weekStart(t time.Time) time.Time {
+	y, m, d := t.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
+
+// Building the digest of the week starting at from out of reports of any time
+func buildDigest(reports []*Report, from time.Time) *Digest {
+	to := from.AddDate(0, 0, 7)
+	prevFrom := from.AddDate(0, 0, -7)
+	d := &Digest{From: from, To: to, digestTotals: digestTotals{Start: from}}
+
+	// The same bucket may be in a period report and a copy of it
+	type bucketKey struct {
+		host, iface string
+		start       time.Time
+	}
+	seen := make(map[bucketKey]bool)
+	days := make(map[time.Time]*digestTotals)
+	hours := make(map[time.Time]*digestTotals)
+	talkers := make(map[string]*digestTalker)
+	alerts := make(map[string]*digestAlerts)
+	earlier := make(map[string]bool)
+	knownHosts := false
+	var prev digestTotals
+	hosts := make(map[string]bool)
+
+	for _, r := range reports {
+		inWeek, inPrev := false, false
+		for _, b := range r.Buckets {
+			k := bucketKey{r.Host, r.Interface, b.Start}
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			switch {
+			case !b.Start.Before(from) && b.Start.Before(to):
+				inWeek = true
+				d.Packets += b.Packets
+				d.Bytes += b.Bytes
+				d.Seconds += b.Seconds
+				y, m, dd := b.Start.Date()
+				for _, t := range []struct {
+					m     map[time.Time]*digestTotals
+					start time.Time
+				}{{days, time.Date(y, m, dd, 0, 0, 0, 0, b.Start.Location())}, {hours, b.Start.Truncate(time.Hour)}} {
+					if t.m[t.start] == nil {
+						t.m[t.start] = &digestTotals{Start: t.start}
+					}
+					t.m[t.start].Packets += b.Packets
+					t.m[t.start].Bytes += b.Bytes
+				}
+			case !b.Start.Before(prevFrom) && b.Start.Before(from):
+				inPrev = true
+				prev.Packets += b.Packets
+				prev.Bytes += b.Bytes
+				prev.Seconds += b.Seconds
+			}
+		}
+		if inWeek {
+			hosts[r.Host] = true
+		}
+
+		// Hosts and alerts go by the report they are in
+		if r.Start.Before(from) && len(r.Hosts) > 0 {
+			knownHosts = true
+			for _, h := range r.Hosts {
+				earlier[h.IP] = true
+			}
+		}
+		for _, h := range r.Hosts {
+			if !inWeek && !inPrev {
+				break
+			}
+			t := talkers[h.IP]
+			if t == nil {
+				t = &digestTalker{IP: h.IP}
+				talkers[h.IP] = t
+			}
+			if len(h.Hostnames) > 0 {
+				t.Name = h.Hostnames[0]
+			}
+			if inWeek {
+				t.Bytes += h.Bytes
+			} else {
+				t.PrevBytes += h.Bytes
+			}
+		}
+		for _, a := range r.Alerts {
+			if a.Resolved || a.Time.Before(prevFrom) || !a.Time.Before(to) {
+				continue
+			}
+			c := alerts[a.Kind]
+			if c == nil {
+				c = &digestAlerts{Kind: a.Kind}
+				alerts[a.Kind] = c
+			}
+			if a.Time.Before(from) {
+				c.Prev++
+			} else {
+				c.Count++
+			}
+		}
+	}
+	if prev.Packets > 0 || prev.Bytes > 0 {
+		prev.Start = prevFrom
+		d.Prev = &prev
+	}
+	for h := range hosts {
+		d.Hosts = append(d.Hosts, h)
+	}
+	sort.Strings(d.Hosts)
+
+	for _, t := range days {
+		d.Days = append(d.Days, *t)
+	}
+	sort.Slice(d.Days, func(i, j int) bool { return d.Days[i].Start.Before(d.Days[j].Start) })
+	for _, t := range hours {
+		d.Peaks = append(d.Peaks, *t)
+	}
+	sort.Slice(d.Peaks, func(i, j int) bool {
+		if d.Peaks[i].Bytes != d.Peaks[j].Bytes {
+			return d.Peaks[i].Bytes > d.Peaks[j].Bytes
+		}
+		return d.Peaks[i].Start.Before(d.Peaks[j].Start)
+	})
+	d.Peaks = d.Peaks[:min(len(d.Peaks), 5)]
+
+	for _, t := range talkers {
+		if t.Bytes > 0 {
+			d.Talkers = append(d.Talkers, *t)
+		}
+	}
+	sort.Slice(d.Talkers, func(i, j int) bool {
+		if d.Talkers[i].Bytes != d.Talkers[j].Bytes {
+			return d.Talkers[i].Bytes > d.Talkers[j].Bytes
+		}
+		return d.Talkers[i].IP < d.Talkers[j].IP
+	})
+	d.Talkers = d.Talkers[:min(len(d.Talkers), 10)]
+
+	if knownHosts {
+		d.NewHosts = []Host{}
+		added := make(map[string]bool)
+		for _, r := range reports {
+			if r.Start.Before(from) || !r.Start.Before(to) {
+				continue
+			}
+			for _, h := range r.Hosts {
+				if isLocalIP(h.IP) && !earlier[h.IP] && !added[h.IP] {
+					added[h.IP] = true
+					d.NewHosts = append(d.NewHosts, h)
+				}
+			}
+		}
+		sort.Slice(d.NewHosts, func(i, j int) bool { return d.NewHosts[i].FirstSeen.Before(d.NewHosts[j].FirstSeen) })
+	}
+
+	for _, a := range alerts {
+		d.Alerts = append(d.Alerts, *a)
+	}
+	sort.Slice(d.Alerts, func(i, j int) bool {
+		if d.Alerts[i].Count != d.Alerts[j].Count {
+			return d.Alerts[i].Count > d.Alerts[j].Count
+		}
+		return d.Alerts[i].Kind < d.Alerts[j].Kind
+	})
+	d.Changes = d.changes()
+	return d
+}
+
+// What stands out against the week before
+func (d *Digest) changes() []string {
+	var notes []string
+	if week := d.To.Sub(d.From).Seconds(); d.Seconds < 0.9*week {
+		notes = append(notes, fmt.Sprintf("Only %.1f of 7 days were monitored, totals are lower than the real traffic", d.Seconds/86400))
+	}
+	if n := len(d.NewHosts); n > 0 {
+		notes = append(notes, fmt.Sprintf("%d new device(s) on the network", n))
+	}
+	if d.Prev == nil {
+		return notes
+	}
+
+	if change := (d.Bytes - d.Prev.Bytes) / d.Prev.Bytes; d.Prev.Bytes > 0 && math.Abs(change) >= 0.2 {
+		dir := "up"
+		if change < 0 {
+			dir = "down"
+		}
+		notes = append(notes, fmt.Sprintf("Traffic %s %.0f%% to %s (%s the week before)", dir, math.Abs(change)*100, formatBytes(d.Bytes), formatBytes(d.Prev.Bytes)))
+	}
+	for _, t := range d.Talkers {
+		switch {
+		case t.PrevBytes == 0:
+			notes = append(notes, fmt.Sprintf("%s is new among the top talkers with %s", t.label(), formatBytes(float64(t.Bytes))))
+		case t.Bytes >= 2*t.PrevBytes && float64(t.Bytes) >= 0.05*d.Bytes:
+			notes = append(notes, fmt.Sprintf("%s moved %s, %.1fx the week before", t.label(), formatBytes(float64(t.Bytes)), float64(t.Bytes)/float64(t.PrevBytes)))
+		}
+	}
+	for _, a := range d.Alerts {
+		switch {
+		case a.Count > 0 && a.Prev == 0:
+			notes = append(notes, fmt.Sprintf("%d %s alert(s), none the week before", a.Count, a.Kind))
+		case a.Count >= 2*a.Prev && a.Count-a.Prev >= 5:
+			notes = append(notes, fmt.Sprintf("%d %s alerts, up from %d", a.Count, a.Kind, a.Prev))
+		case a.Count == 0 && a.Prev >= 5:
+			notes = append(notes, fmt.Sprintf("No %s alerts, %d the week before", a.Kind, a.Prev))
+		}
+	}
+	return notes
+}
+
+func formatBytes(b float64) string {
+	switch {
+	case b >= 1<<30:
+		return fmt.Sprintf("%.2f GB", b/(1<<30))
+	case b >= 1<<20:
+		return fmt.Sprintf("%.2f MB", b/(1<<20))
+	default:
+		return fmt.Sprintf("%.1f KB", b/(1<<10))
+	}
+}
+
+func (t digestTalker) label() string {
+	if t.Name != "" {
+		return t.Name + " (" + t.IP + ")"
+	}
+	return t.IP
+}
+
+// A section of the digest, rendered as text, Markdown or HTML. Sections with
+// a single column are lists.
+type digestTable struct {
+	title  string
+	header []string
+	rows   [][]string
+	empty  string
+}
+
+func (d *Digest) title() string {
+	title := "Weekly digest " + d.From.Format("Mon 2 Jan") + " to " + d.To.AddDate(0, 0, -1).Format("Mon 2 Jan 2006")
+	if len(d.Hosts) > 0 {
+		title += " for " + strings.Join(d.Hosts, ", ")
+	}
+	return title
+}
+
+func (d *Digest) tables() []digestTable {
+	summary := digestTable{title: "Summary", header: []string{"", "This week", "Week before"}}
+	prevBytes, prevPackets, prevDays := "-", "-", "-"
+	if d.Prev != nil {
+		prevBytes, prevPackets, prevDays = formatBytes(d.Prev.Bytes), fmt.Sprint(d.Prev.Packets), fmt.Sprintf("%.1f days", d.Prev.Seconds/86400)
+	}
+	summary.rows = [][]string{
+		{"Traffic", formatBytes(d.Bytes), prevBytes},
+		{"Packets", fmt.Sprint(d.Packets), prevPackets},
+		{"Monitored", fmt.Sprintf("%.1f days", d.Seconds/86400), prevDays},
+	}
+
+	changes := digestTable{title: "Notable changes", header: []string{"Change"}, empty: "Nothing stands out against the week before"}
+	for _, c := range d.Changes {
+		changes.rows = append(changes.rows, []string{c})
+	}
+
+	days := digestTable{title: "Daily usage", header: []string{"Day", "Traffic", "Packets"}, empty: "No buckets this week"}
+	for _, t := range d.Days {
+		days.rows = append(days.rows, []string{t.Start.Format("Mon 2 Jan"), formatBytes(t.Bytes), fmt.Sprint(t.Packets)})
+	}
+	peaks := digestTable{title: "Peak hours", header: []string{"Hour", "Traffic", "Average rate"}, empty: "No buckets this week"}
+	for _, t := range d.Peaks {
+		peaks.rows = append(peaks.rows, []string{t.Start.Format("Mon 2 Jan 15:04"), formatBytes(t.Bytes), formatBitrate(t.Bytes * 8 / 3600)})
+	}
+
+	talkers := digestTable{title: "Top talkers", header: []string{"Host", "Traffic", "Week before"}, empty: "The reports list no hosts"}
+	for _, t := range d.Talkers {
+		before := "-"
+		if t.PrevBytes > 0 {
+			before = formatBytes(float64(t.PrevBytes))
+		}
+		talkers.rows = append(talkers.rows, []string{t.label(), formatBytes(float64(t.Bytes)), before})
+	}
+
+	devices := digestTable{title: "New devices", header: []string{"Host", "MAC", "Vendor", "First seen"}, empty: "No new devices"}
+	if d.NewHosts == nil {
+		devices.empty = "No earlier reports list hosts to compare with"
+	}
+	for _, h := range d.NewHosts {
+		name := h.IP
+		if len(h.Hostnames) > 0 {
+			name = h.Hostnames[0] + " (" + h.IP + ")"
+		}
+		devices.rows = append(devices.rows, []string{name, h.MAC, h.Vendor, h.FirstSeen.Format("Mon 2 Jan 15:04")})
+	}
+
+	alerts := digestTable{title: "Alerts fired", header: []string{"Kind", "This week", "Week before"}, empty: "No alerts"}
+	for _, a := range d.Alerts {
+		alerts.rows = append(alerts.rows, []string{a.Kind, fmt.Sprint(a.Count), fmt.Sprint(a.Prev)})
+	}
+	return []digestTable{summary, changes, days, peaks, talkers, devices, alerts}
+}
+
+// Rendering the digest as text, markdown or html
+func (d *Digest) render(format string) string {
+	var b strings.Builder
+	switch format {
+	case "markdown":
+		fmt.Fprintf(&b, "# %s\n", d.title())
+		for _, t := range d.tables() {
+			fmt.Fprintf(&b, "\n## %s\n\n", t.title)
+			if len(t.rows) == 0 {
+				fmt.Fprintf(&b, "%s\n", t.empty)
+				continue
+			}
+			if len(t.header) == 1 {
+				for _, row := range t.rows {
+					fmt.Fprintf(&b, "- %s\n", row[0])
+				}
+				continue
+			}
+			fmt.Fprintf(&b, "| %s |\n|%s\n", strings.Join(t.header, " | "), strings.Repeat(" --- |", len(t.header)))
+			for _, row := range t.rows {
+				for i := range row {
+					row[i] = strings.ReplaceAll(row[i], "|", "\\|")
+				}
+				fmt.Fprintf(&b, "| %s |\n", strings.Join(row, " | "))
+			}
+		}
+	case "html":
+		fmt.Fprintf(&b, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>%s</title></head>\n", html.EscapeString(d.title()))
+		b.WriteString("<body style=\"font: 14px system-ui, sans-serif; color: #222;\">\n")
+		fmt.Fprintf(&b, "<h1 style=\"font-size: 1.3em;\">%s</h1>\n", html.EscapeString(d.title()))
+		for _, t := range d.tables() {
+			fmt.Fprintf(&b, "<h2 style=\"font-size: 1.1em; margin-top: 1.5em;\">%s</h2>\n", html.EscapeString(t.title))
+			if len(t.rows) == 0 {
+				fmt.Fprintf(&b, "<p>%s</p>\n", html.EscapeString(t.empty))
+				continue
+			}
+			if len(t.header) == 1 {
+				b.WriteString("<ul>\n")
+				for _, row := range t.rows {
+					fmt.Fprintf(&b, "<li>%s</li>\n", html.EscapeString(row[0]))
+				}
+				b.WriteString("</ul>\n")
+				continue
+			}
+			b.WriteString("<table style=\"border-collapse: collapse;\">\n<tr>")
+			for _, h := range t.header {
+				fmt.Fprintf(&b, "<th style=\"text-align: left; padding: 2px 12px 2px 0; border-bottom: 1px solid #ccc;\">%s</th>", html.EscapeString(h))
+			}
+			b.WriteString("</tr>\n")
+			for _, row := range t.rows {
+				b.WriteString("<tr>")
+				for _, c := range row {
+					fmt.Fprintf(&b, "<td style=\"padding: 2px 12px 2px 0;\">%s</td>", html.EscapeString(c))
+				}
+				b.WriteString("</tr>\n")
+			}
+			b.WriteString("</table>\n")
+		}
+		b.WriteString("</body></html>\n")
+	default:
+		fmt.Fprintf(&b, "%s\n%s\n%s\n", strings.Repeat("=", 60), strings.ToUpper(d.title()), strings.Repeat("=", 60))
+		for _, t := range d.tables() {
+			fmt.Fprintf(&b, "%s\n%s\n", strings.Repeat("-", 60), strings.ToUpper(t.title))
+			if len(t.rows) == 0 {
+				fmt.Fprintf(&b, "%s\n", t.empty)
+				continue
+			}
+			if len(t.header) == 1 {
+				for _, row := range t.rows {
+					fmt.Fprintf(&b, "- %s\n", row[0])
+				}
+				continue
+			}
+			widths := make([]int, len(t.header))
+			for _, row := range append([][]string{t.header}, t.rows...) {
+				for i, c := range row {
+					widths[i] = max(widths[i], len(c))
+				}
+			}
+			for _, row := range append([][]string{t.header}, t.rows...) {
+				var cells []string
+				for i, c := range row {
+					cells = append(cells, fmt.Sprintf("%-*s", widths[i], c))
+				}
+				fmt.Fprintln(&b, strings.TrimRight(strings.Join(cells, "  "), " "))
+			}
+		}
+	}
+	return b.String()
+}
+
+// Digest formats by file extension, text for anything else
+func digestFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		return "html"
+	case ".md", ".markdown":
+		return "markdown"
+	}
+	return "text"
+}
+
+// Writing the digest to path, with the week in its name, and mailing it through email if set
+func writeDigest(d *Digest, path string, email *SinkConfig) {
+	format := digestFormat(path)
+	out := d.render(format)
+	path = periodFileName(path, d.From)
+	if err := diskGuardrail.Check(path); err != nil {
+		fmt.Println(err)
+	} else if err := os.WriteFile(path, []byte(out), 0o644); err != nil {
+		logger.Error("failed to write digest", "path", path, "err", err)
+	} else {
+		fmt.Printf("Weekly digest written to %s\n", path)
+	}
+	if email != nil {
+		mailDigest(d, *email, format, out)
+	}
+}
+
+func mailDigest(d *Digest, c SinkConfig, format, out string) {
+	contentType := ""
+	if format == "html" {
+		contentType = "text/html; charset=utf-8"
+	}
+	if err := sendMail(c, "netwatchd "+strings.ToLower(d.title()[:1])+d.title()[1:], contentType, out, time.Now()); err != nil {
+		logger.Error("failed to mail digest", "to", strings.Join(c.To, ", "), "err", err)
+	} else {
+		fmt.Printf("Weekly digest mailed to %s\n", strings.Join(c.To, ", "))
+	}
+}
+
+// The email sink a digest is mailed through
+func digestSink(sinks []SinkConfig, name string) (*SinkConfig, error) {
+	for _, s := range sinks {
+		if s.Name != name {
+			continue
+		}
+		if s.Type != "email" {
+			return nil, fmt.Errorf("sink %s is a %s sink, the digest needs an email sink", name, s.Type)
+		}
+		return &s, nil
+	}
+	return nil, fmt.Errorf("no sink named %s in the config", name)
+}
+
+// digestSchedule writes the digest of every week that ends while netwatchd
+// runs in daemon mode, from the period reports
+type digestSchedule struct {
+	path    string
+	email   *SinkConfig
+	sources []string
+	next    time.Time // end of the week the next digest covers
+}
+
+// Checking after each period report whether a week has ended. The digest is
+// built on its own goroutine, callers hold data.mu.
+func (s *digestSchedule) check(end time.Time) {
+	if s == nil {
+		return
+	}
+	for !end.Before(s.next) {
+		from := s.next.AddDate(0, 0, -7)
+		s.next = s.next.AddDate(0, 0, 7)
+		go func() {
+			writeDigest(buildDigest(loadReports(s.sources), from), s.path, s.email)
+		}()
+	}
+}
+
+// Every report the sources name, ones that fail to load are skipped
+func loadReports(sources []string) []*Report {
+	var reports []*Report
+	for _, p := range (&weekHistory{sources: sources}).paths() {
+		if r, err := loadReport(p); err == nil {
+			reports = append(reports, r)
+		}
+	}
+	return reports
+}
+
+// netwatchd digest [-week date] [-o file] [-config file -email sink] <report.json|dir> ...
+func runDigestCommand(args []string) int {
+	fs := flag.NewFlagSet("digest", flag.ExitOnError)
+	weekFlag := fs.String("week", "", "Any day (YYYY-MM-DD) of the week to sum up, defaults to last week")
+	formatFlag := fs.String("format", "", "text, markdown or html, defaults to the -o extension or text")
+	outFlag := fs.String("o", "", "Write the digest to this file instead of stdout")
+	configFlag := fs.String("config", "", "Config file with the sink for -email")
+	emailFlag := fs.String("email", "", "Mail the digest through this email sink of the config")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Println("Usage: netwatchd digest [-week YYYY-MM-DD] [-format text|markdown|html] [-o file] [-config file -email sink] <report.json|dir> ...")
+		return 2
+	}
+
+	from := weekStart(time.Now()).AddDate(0, 0, -7)
+	if *weekFlag != "" {
+		day, err := time.ParseInLocation("2006-01-02", *weekFlag, time.Local)
+		if err != nil {
+			fmt.Printf("Invalid -week %q, use YYYY-MM-DD\n", *weekFlag)
+			return 2
+		}
+		from = weekStart(day)
+	}
+	format := *formatFlag
+	if format == "" {
+		format = digestFormat(*outFlag)
+	}
+	if format != "text" && format != "markdown" && format != "html" {
+		fmt.Printf("Invalid -format %q, expected text, markdown or html\n", format)
+		return 2
+	}
+	var email *SinkConfig
+	if *emailFlag != "" {
+		if *configFlag == "" {
+			fmt.Println("-email needs -config with the sink")
+			return 2
+		}
+		cfg, err := loadConfig(*configFlag)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		if email, err = digestSink(cfg.Sinks, *emailFlag); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+
+	paths, err := reportPaths(fs.Args())
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	var reports []*Report
+	for _, p := range paths {
+		r, err := loadReport(p)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		reports = append(reports, r)
+	}
+
+	d := buildDigest(reports, from)
+	out := d.render(format)
+	if *outFlag != "" {
+		if err := os.WriteFile(*outFlag, []byte(out), 0o644); err != nil {
+			fmt.Printf("Failed to write digest: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Weekly digest written to %s\n", *outFlag)
+	} else {
+		fmt.Print(out)
+	}
+	if email != nil {
+		mailDigest(d, *email, format, out)
+	}
+	return 0
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// diskGuard refuses output writes while a filesystem is below -min-free-disk,
+// after first deleting old period reports to make room
+type diskGuard struct {
+	minFree uint64
+	prune   []string // glob patterns of outputs that may go, oldest sort first
+	mu      sync.Mutex
+	low     map[string]bool
+}
+
+// Set from -min-free-disk, nil leaves every write alone
+var diskGuardrail *diskGuard
+
+func newDiskGuard(minFree uint64, prune ...string) *diskGuard {
+	return &diskGuard{minFree: minFree, prune: prune, low: make(map[string]bool)}
+}
+
+// Check returns an error when writing next to path would take the disk below
+// the minimum. Filesystems whose free space can't be read are not blocked.
+func (g *diskGuard) Check(path string) error {
+	if g == nil {
+		return nil
+	}
+	dir := filepath.Dir(path)
+	free, err := freeDiskSpace(dir)
+	if err != nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if free < g.minFree {
+		free = g.pruneOld(dir, free)
+	}
+	if free >= g.minFree {
+		if g.low[dir] {
+			fmt.Printf("Disk space on %s back to %s, writing resumes\n", dir, formatDiskSize(free))
+			delete(g.low, dir)
+		}
+		return nil
+	}
+	if !g.low[dir] {
+		fmt.Printf("Disk space on %s down to %s, below -min-free-disk %s\n", dir, formatDiskSize(free), formatDiskSize(g.minFree))
+		g.low[dir] = true
+	}
+	return fmt.Errorf("not writing %s: only %s free", path, formatDiskSize(free))
+}
+
+// Deleting the oldest matching files in dir until there is room again. The
+// newest match of each pattern is kept.
+func (g *diskGuard) pruneOld(dir string, free uint64) uint64 {
+	for _, pattern := range g.prune {
+		if filepath.Dir(pattern) != dir {
+			continue
+		}
+		matches, _ := filepath.Glob(pattern)
+		sort.Strings(matches)
+		for i := 0; i < len(matches)-1 && free < g.minFree; i++ {
+			if err := os.Remove(matches[i]); err != nil {
+				continue
+			}
+			fmt.Printf("Deleted %s to free disk space\n", matches[i])
+			if f, err := freeDiskSpace(dir); err == nil {
+				free = f
+			}
+		}
+	}
+	return free
+}
+
+func formatDiskSize(n uint64) string {
+	return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// Response codes by number, RFC 1035 and 2136
+var dnsRcodes = []string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED", "YXDOMAIN", "YXRRSET", "NXRRSET", "NOTAUTH", "NOTZONE"}
+
+const dnsNXDomain = 3
+
+// Query types worth a name, others are logged as TYPE<n>
+var dnsTypes = map[uint16]string{1: "A", 2: "NS", 5: "CNAME", 6: "SOA", 12: "PTR", 15: "MX", 16: "TXT", 28: "AAAA",
+	33: "SRV", 35: "NAPTR", 43: "DS", 48: "DNSKEY", 64: "SVCB", 65: "HTTPS", 255: "ANY"}
+
+func dnsRcodeName(rcode int) string {
+	if rcode >= 0 && rcode < len(dnsRcodes) {
+		return dnsRcodes[rcode]
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+func dnsTypeName(typ uint16) string {
+	if name, ok := dnsTypes[typ]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", typ)
+}
+
+// Names and clients counted, later ones are not tracked
+const dnsMaxNames = 50000
+
+// DNSLogEntry is one line of the -dns-log file
+type DNSLogEntry struct {
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client"`
+	Server   string    `json:"server"`
+	ID       uint16    `json:"id"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Response bool      `json:"response"`
+	Rcode    string    `json:"rcode,omitempty"` // responses only
+	Answers  int       `json:"answers,omitempty"`
+	Addrs    []string  `json:"addrs,omitempty"`
+}
+
+// DNSName is a queried name with its queries and failed answers
+type DNSName struct {
+	Name     string `json:"name"`
+	Queries  int    `json:"queries"`
+	NXDomain int    `json:"nxdomain"`
+	Clients  int    `json:"clients"`
+}
+
+// DNSDomain is a domain whose names got NXDOMAIN answers. Many different
+// names under one domain failing is typical of generated domains.
+type DNSDomain struct {
+	Domain   string `json:"domain"`
+	Names    int    `json:"names"` // distinct names answered NXDOMAIN
+	NXDomain int    `json:"nxdomain"`
+}
+
+// The last two labels of name, without a public suffix list
+func dnsDomain(name string) string {
+	labels := strings.Split(name, ".")
+	if len(labels) <= 2 {
+		return name
+	}
+	return strings.Join(labels[len(labels)-2:], ".")
+}
+
+// DNSClient is a host with the queries it sent and the NXDOMAIN answers it got
+type DNSClient struct {
+	Client    string  `json:"client"`
+	Queries   int     `json:"queries"`
+	Responses int     `json:"responses"`
+	NXDomain  int     `json:"nxdomain"`
+	Percent   float64 `json:"nxdomain_percent"` // of its responses
+}
+
+// DNSSummary is the DNS section of the report
+type DNSSummary struct {
+	Queries   int            `json:"queries"`
+	Responses int            `json:"responses"`
+	NXDomain  int            `json:"nxdomain"`
+	Percent   float64        `json:"nxdomain_percent"` // of the responses
+	Rcodes    map[string]int `json:"rcodes,omitempty"`
+	Names     []DNSName      `json:"names,omitempty"`           // the most queried
+	Failing   []DNSDomain    `json:"failing_domains,omitempty"` // the most names answered NXDOMAIN
+	Clients   []DNSClient    `json:"clients,omitempty"`         // the most NXDOMAIN answers
+}
+
+type dnsName struct {
+	DNSName
+	clients map[string]bool
+}
+
+// DNSTracker counts DNS queries per name and client and the response codes
+// answered, and with -dns-log writes every query and response as a JSON
+// line. Beaconing malware often shows as many random looking names
+// answered NXDOMAIN. It is guarded by the MonitoringData mutex.
+type DNSTracker struct {
+	log     *bufio.Writer
+	file    *os.File
+	enc     *json.Encoder
+	names   map[string]*dnsName
+	clients map[string]*DNSClient
+	rcodes  map[int]int
+
+	queries, responses, nxdomain int
+}
+
+func NewDNSTracker() *DNSTracker {
+	t := &DNSTracker{}
+	t.reset()
+	return t
+}
+
+// Appending the log to path
+func (t *DNSTracker) openLog(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open DNS log: %v", err)
+	}
+	t.file, t.log = f, bufio.NewWriter(f)
+	t.enc = json.NewEncoder(t.log)
+	return nil
+}
+
+// Writing the buffered lines. Callers hold data.mu.
+func (t *DNSTracker) flush() {
+	if t == nil || t.log == nil {
+		return
+	}
+	if err := t.log.Flush(); err != nil {
+		logger.Warn("failed to write the DNS log", "err", err)
+	}
+}
+
+func (t *DNSTracker) close() {
+	if t == nil || t.file == nil {
+		return
+	}
+	t.flush()
+	t.file.Close()
+	t.file, t.log, t.enc = nil, nil, nil
+}
+
+func (t *DNSTracker) client(addr string) *DNSClient {
+	c := t.clients[addr]
+	if c == nil && len(t.clients) < dnsMaxNames {
+		c = &DNSClient{Client: addr}
+		t.clients[addr] = c
+	}
+	return c
+}
+
+func (t *DNSTracker) Observe(p *netwatch.Packet) {
+	if t == nil || p.DNS == nil || p.DNS.Name == "" {
+		return
+	}
+	m := p.DNS
+	name := strings.ToLower(strings.TrimSuffix(m.Name, "."))
+	client, server := p.SrcIP, p.DstIP
+	if m.Response {
+		client, server = p.DstIP, p.SrcIP
+	}
+
+	if t.enc != nil {
+		e := DNSLogEntry{Time: p.Time, Client: client, Server: server, ID: m.ID, Name: name, Type: dnsTypeName(m.Type), Response: m.Response}
+		if m.Response {
+			e.Rcode, e.Answers, e.Addrs = dnsRcodeName(m.Rcode), m.Answers, p.DNSAddrs
+		}
+		if err := t.enc.Encode(e); err != nil {
+			logger.Warn("failed to write the DNS log", "err", err)
+		}
+	}
+
+	n := t.names[name]
+	if n == nil && len(t.names) < dnsMaxNames {
+		n = &dnsName{DNSName: DNSName{Name: name}, clients: make(map[string]bool)}
+		t.names[name] = n
+	}
+	c := t.client(client)
+	if !m.Response {
+		t.queries++
+		if n != nil {
+			n.Queries++
+			n.clients[client] = true
+		}
+		if c != nil {
+			c.Queries++
+		}
+		return
+	}
+	t.responses++
+	t.rcodes[m.Rcode]++
+	if c != nil {
+		c.Responses++
+	}
+	if m.Rcode == dnsNXDomain {
+		t.nxdomain++
+		if n != nil {
+			n.NXDomain++
+		}
+		if c != nil {
+			c.NXDomain++
+		}
+	}
+}
+
+// The summary with at most top names, failing domains and clients
+func (t *DNSTracker) Summary(top int) *DNSSummary {
+	if t == nil || t.queries+t.responses == 0 {
+		return nil
+	}
+	s := &DNSSummary{Queries: t.queries, Responses: t.responses, NXDomain: t.nxdomain, Rcodes: make(map[string]int)}
+	if t.responses > 0 {
+		s.Percent = float64(t.nxdomain) / float64(t.responses) * 100
+	}
+	for rcode, n := range t.rcodes {
+		s.Rcodes[dnsRcodeName(rcode)] = n
+	}
+	failing := make(map[string]*DNSDomain)
+	for _, n := range t.names {
+		if n.NXDomain > 0 {
+			domain := dnsDomain(n.Name)
+			f := failing[domain]
+			if f == nil {
+				f = &DNSDomain{Domain: domain}
+				failing[domain] = f
+			}
+			f.Names++
+			f.NXDomain += n.NXDomain
+		}
+		if n.Queries+n.NXDomain == 0 {
+			continue
+		}
+		d := n.DNSName
+		d.Clients = len(n.clients)
+		s.Names = append(s.Names, d)
+	}
+	sort.Slice(s.Names, func(i, j int) bool {
+		if s.Names[i].Queries != s.Names[j].Queries {
+			return s.Names[i].Queries > s.Names[j].Queries
+		}
+		return s.Names[i].Name < s.Names[j].Name
+	})
+	s.Names = s.Names[:min(len(s.Names), top)]
+	for _, f := range failing {
+		s.Failing = append(s.Failing, *f)
+	}
+	sort.Slice(s.Failing, func(i, j int) bool {
+		if s.Failing[i].Names != s.Failing[j].Names {
+			return s.Failing[i].Names > s.Failing[j].Names
+		}
+		return s.Failing[i].Domain < s.Failing[j].Domain
+	})
+	s.Failing = s.Failing[:min(len(s.Failing), top)]
+	for _, c := range t.clients {
+		if c.NXDomain == 0 {
+			continue
+		}
+		d := *c
+		d.Percent = float64(c.NXDomain) / float64(c.Responses) * 100
+		s.Clients = append(s.Clients, d)
+	}
+	sort.Slice(s.Clients, func(i, j int) bool {
+		if s.Clients[i].NXDomain != s.Clients[j].NXDomain {
+			return s.Clients[i].NXDomain > s.Clients[j].NXDomain
+		}
+		return s.Clients[i].Client < s.Clients[j].Client
+	})
+	s.Clients = s.Clients[:min(len(s.Clients), top)]
+	return s
+}
+
+// Starting a new period, the log stays open
+func (t *DNSTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.names = make(map[string]*dnsName)
+	t.clients = make(map[string]*DNSClient)
+	t.rcodes = make(map[int]int)
+	t.queries, t.responses, t.nxdomain = 0, 0, 0
+}
+
+func printDNSReport(t *DNSTracker) {
+	s := t.Summary(10)
+	if s == nil {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("DNS")
+	fmt.Printf("%d queries | %d responses | %d NXDOMAIN (%.1f%% of responses)\n", s.Queries, s.Responses, s.NXDomain, s.Percent)
+	var rcodes []string
+	for name, n := range s.Rcodes {
+		if name != "NOERROR" && name != "NXDOMAIN" {
+			rcodes = append(rcodes, fmt.Sprintf("%s %d", name, n))
+		}
+	}
+	if len(rcodes) > 0 {
+		sort.Strings(rcodes)
+		fmt.Printf("Other errors: %s\n", strings.Join(rcodes, ", "))
+	}
+	if len(s.Names) > 0 {
+		fmt.Println("Most queried:")
+		for _, n := range s.Names {
+			line := fmt.Sprintf("  %s: %d queries from %d hosts", n.Name, n.Queries, n.Clients)
+			if n.NXDomain > 0 {
+				line += fmt.Sprintf(", %d NXDOMAIN", n.NXDomain)
+			}
+			fmt.Println(line)
+		}
+	}
+	if len(s.Failing) > 0 {
+		fmt.Println("Domains with the most names answered NXDOMAIN:")
+		for _, f := range s.Failing {
+			fmt.Printf("  %s: %d names, %d NXDOMAIN\n", f.Domain, f.Names, f.NXDomain)
+		}
+	}
+	if len(s.Clients) > 0 {
+		fmt.Println("Most NXDOMAIN answers:")
+		for _, c := range s.Clients {
+			fmt.Printf("  %s: %d of %d responses (%.1f%%), %d queries\n", c.Client, c.NXDomain, c.Responses, c.Percent, c.Queries)
+		}
+	}
+}
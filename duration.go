@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// captureDuration is the -d value. Zero means run until stopped.
+type captureDuration time.Duration
+
+func parseCaptureDuration(s string) (captureDuration, error) {
+	s = strings.TrimSpace(s)
+	if s == "inf" || s == "0" {
+		return 0, nil
+	}
+	// A bare number keeps meaning seconds, as -d always did
+	if secs, err := strconv.Atoi(s); err == nil {
+		if secs < 0 {
+			return 0, errors.New("duration must not be negative")
+		}
+		return captureDuration(time.Duration(secs) * time.Second), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, use e.g. 30s, 15m, 2h, 0 or inf", s)
+	}
+	if d < 0 {
+		return 0, errors.New("duration must not be negative")
+	}
+	return captureDuration(d), nil
+}
+
+func (d *captureDuration) Set(s string) error {
+	v, err := parseCaptureDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+func (d captureDuration) String() string {
+	if d == 0 {
+		return "inf"
+	}
+	return time.Duration(d).String()
+}
+
+// Config files may give seconds as a number or a duration string
+func (d *captureDuration) UnmarshalJSON(b []byte) error {
+	var secs int
+	if err := json.Unmarshal(b, &secs); err == nil {
+		return d.Set(strconv.Itoa(secs))
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.New("duration must be seconds or a duration string")
+	}
+	return d.Set(s)
+}
+
+// Describing the requested and actual capture length for the report
+func durationNote(requested captureDuration, actual time.Duration) string {
+	actual = actual.Round(time.Second)
+	if requested == 0 {
+		return fmt.Sprintf("Duration: %s (unlimited requested)", actual)
+	}
+	return fmt.Sprintf("Duration: %s of %s requested", actual, time.Duration(requested))
+}
+
+// Naming bucket i of the report, "minute 3" for the default one minute buckets
+// and e.g. "10s bucket 3" otherwise
+func bucketLabel(i int, size time.Duration) string {
+	return fmt.Sprintf("%s %d", bucketUnit(size), i+1)
+}
+
+// "minute" or e.g. "10s bucket", for per bucket series
+func bucketUnit(size time.Duration) string {
+	if size == time.Minute {
+		return "minute"
+	}
+	// 5m instead of 5m0s
+	s := size.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s + " bucket"
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"netwatchd/netwatch"
+)
+
+// Public resolvers offering DNS over HTTPS, by address
+var dohResolvers = map[string]string{
+	"1.1.1.1":              "cloudflare-dns.com",
+	"1.0.0.1":              "cloudflare-dns.com",
+	"2606:4700:4700::1111": "cloudflare-dns.com",
+	"2606:4700:4700::1001": "cloudflare-dns.com",
+	"8.8.8.8":              "dns.google",
+	"8.8.4.4":              "dns.google",
+	"2001:4860:4860::8888": "dns.google",
+	"2001:4860:4860::8844": "dns.google",
+	"9.9.9.9":              "dns.quad9.net",
+	"149.112.112.112":      "dns.quad9.net",
+	"2620:fe::fe":          "dns.quad9.net",
+	"2620:fe::9":           "dns.quad9.net",
+	"208.67.222.222":       "doh.opendns.com",
+	"208.67.220.220":       "doh.opendns.com",
+	"2620:119:35::35":      "doh.opendns.com",
+	"94.140.14.14":         "dns.adguard-dns.com",
+	"94.140.15.15":         "dns.adguard-dns.com",
+	"185.228.168.9":        "doh.cleanbrowsing.org",
+	"194.242.2.2":          "dns.mullvad.net",
+}
+
+// DNS over HTTPS hostnames, matched with their subdomains
+var dohHostnames = []string{
+	"cloudflare-dns.com", "one.one.one.one", "dns.google", "dns.google.com", "dns.quad9.net",
+	"doh.opendns.com", "dns.nextdns.io", "dns.adguard.com", "dns.adguard-dns.com",
+	"doh.cleanbrowsing.org", "dns.mullvad.net", "doh.mullvad.net", "freedns.controld.com",
+	"doh.dns.apple.com", "doh.dns.sb", "doh.libredns.gr",
+}
+
+// Whether a TLS server name or DNS query names a DoH endpoint: a known one,
+// or any name whose first label is doh
+func dohHostname(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if name == "" {
+		return false
+	}
+	for _, h := range dohHostnames {
+		if name == h || strings.HasSuffix(name, "."+h) {
+			return true
+		}
+	}
+	return strings.HasPrefix(name, "doh.")
+}
+
+// EncryptedDNSUsage is encrypted DNS traffic between a client and a resolver
+type EncryptedDNSUsage struct {
+	Method   string `json:"method"` // DoH, DoT or DoQ
+	Client   string `json:"client"`
+	Resolver string `json:"resolver"`
+	Name     string `json:"name,omitempty"` // hostname the resolver is known by
+	Packets  int    `json:"packets"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// EncryptedDNSTracker tells DNS over TLS and QUIC on port 853 and DNS over
+// HTTPS apart from plain DNS and other HTTPS. DoH is recognised by well known
+// resolver addresses, by a DoH server name in a TLS ClientHello, and by the
+// addresses plain DNS resolved DoH hostnames to. It is guarded by the
+// MonitoringData mutex.
+type EncryptedDNSTracker struct {
+	resolvers map[string]string // addresses learned from SNI and DNS answers
+	usage     map[string]*EncryptedDNSUsage
+	plain     int // plain DNS packets, for comparison
+}
+
+func NewEncryptedDNSTracker() *EncryptedDNSTracker {
+	return &EncryptedDNSTracker{resolvers: make(map[string]string), usage: make(map[string]*EncryptedDNSUsage)}
+}
+
+// Classifying a packet and counting it, returning its layer name (doh, dot or
+// doq) when it is encrypted DNS
+func (t *EncryptedDNSTracker) Observe(p *netwatch.Packet) string {
+	if p.HasLayer("dns") {
+		t.plain++
+		if dohHostname(p.DNSName) {
+			for _, addr := range p.DNSAddrs {
+				t.resolvers[addr] = strings.TrimSuffix(p.DNSName, ".")
+			}
+		}
+		return ""
+	}
+	if p.SrcIP == "" || p.Transport == "" {
+		return ""
+	}
+
+	client, server, port := p.SrcIP, p.DstIP, p.DstPort
+	if p.SrcPort == 853 || p.SrcPort == 443 {
+		client, server, port = p.DstIP, p.SrcIP, p.SrcPort
+	}
+	method := ""
+	switch {
+	case port == 853 && p.Transport == "tcp":
+		method = "DoT"
+	case port == 853:
+		method = "DoQ"
+	case port != 443:
+		return ""
+	case p.SNI != "" && dohHostname(p.SNI):
+		t.resolvers[server] = strings.ToLower(p.SNI)
+		method = "DoH"
+	case dohResolvers[server] != "" || t.resolvers[server] != "":
+		method = "DoH"
+	default:
+		return ""
+	}
+
+	key := method + " " + client + " " + server
+	u, ok := t.usage[key]
+	if !ok {
+		u = &EncryptedDNSUsage{Method: method, Client: client, Resolver: server}
+		t.usage[key] = u
+	}
+	if name := t.name(server); name != "" {
+		u.Name = name
+	}
+	u.Packets++
+	u.Bytes += int64(p.Length)
+	return strings.ToLower(method)
+}
+
+func (t *EncryptedDNSTracker) name(addr string) string {
+	if name := t.resolvers[addr]; name != "" {
+		return name
+	}
+	return dohResolvers[addr]
+}
+
+// Client and resolver pairs by method, busiest first
+func (t *EncryptedDNSTracker) Usage() []EncryptedDNSUsage {
+	if t == nil || len(t.usage) == 0 {
+		return nil
+	}
+	var usage []EncryptedDNSUsage
+	for _, u := range t.usage {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Method != usage[j].Method {
+			return usage[i].Method < usage[j].Method
+		}
+		if usage[i].Bytes != usage[j].Bytes {
+			return usage[i].Bytes > usage[j].Bytes
+		}
+		return usage[i].Client+usage[i].Resolver < usage[j].Client+usage[j].Resolver
+	})
+	return usage
+}
+
+// Starting a new period, learned resolvers are kept
+func (t *EncryptedDNSTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.usage = make(map[string]*EncryptedDNSUsage)
+	t.plain = 0
+}
+
+func printEncryptedDNSReport(t *EncryptedDNSTracker) {
+	usage := t.Usage()
+	if len(usage) == 0 {
+		return
+	}
+	encrypted := 0
+	for _, u := range usage {
+		encrypted += u.Packets
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("ENCRYPTED DNS")
+	fmt.Printf("Plain DNS: %d packets | encrypted DNS: %d packets (%.1f%% of DNS)\n", t.plain, encrypted,
+		100*float64(encrypted)/float64(encrypted+t.plain))
+	last := ""
+	for _, u := range usage {
+		if u.Method != last {
+			fmt.Printf("%s:\n", u.Method)
+			last = u.Method
+		}
+		resolver := u.Resolver
+		if u.Name != "" {
+			resolver += " (" + u.Name + ")"
+		}
+		fmt.Printf("  %s -> %s: %d packets | %.2f MB\n", u.Client, resolver, u.Packets, float64(u.Bytes)/(1024*1024))
+	}
+}
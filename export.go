@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"netwatchd/parquet"
+	"netwatchd/sqlite"
+)
+
+// A column of a -db table as declared in its CREATE TABLE
+type exportColumn struct {
+	name string
+	kind string // INTEGER, REAL or TEXT
+	key  bool   // the INTEGER PRIMARY KEY, stored as the rowid
+}
+
+// The columns of one of the sessionTables statements
+func tableColumns(sql string) []exportColumn {
+	inner := sql[strings.IndexByte(sql, '(')+1 : strings.LastIndexByte(sql, ')')]
+	var cols []exportColumn
+	for _, def := range strings.Split(inner, ", ") {
+		f := strings.Fields(def)
+		cols = append(cols, exportColumn{name: f[0], kind: f[1], key: strings.Contains(def, "PRIMARY KEY")})
+	}
+	return cols
+}
+
+// Times are text in SQLite, exports and queries read them as times
+func (c exportColumn) time() bool {
+	return strings.HasSuffix(c.name, "time") || strings.HasSuffix(c.name, "_seen")
+}
+
+// exportSlice is the rows of one table within a time range
+type exportSlice struct {
+	Table   string
+	Columns []exportColumn
+	Rows    [][]any
+	IDs     []int64 // rowids of Rows
+	Next    string  // cursor of the next page, empty on the last
+}
+
+// Parsing from and to as RFC 3339, or a local date and time, or a date
+func parseExportTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use RFC 3339 or YYYY-MM-DD", s)
+}
+
+// Selecting the rows of table from [from, to), a zero time leaves that end
+// open. Sessions and hosts are picked when they overlap the range, buckets by
+// their start and alerts by their time. The other tables also get the host
+// and interface of their session.
+func exportRows(db *sqlite.Database, table string, from, to time.Time) (*exportSlice, error) {
+	var sql string
+	for _, t := range sessionTables {
+		if t.name == table {
+			sql = t.sql
+		}
+	}
+	if sql == "" {
+		return nil, fmt.Errorf("unknown table %q, use sessions, buckets, alerts, hosts or flows", table)
+	}
+	cols := tableColumns(sql)
+	index := make(map[string]int)
+	for i, c := range cols {
+		index[c.name] = i
+	}
+
+	// Times are stored as fixed width UTC text, so they compare as strings
+	lo, hi := "", "\xff"
+	if !from.IsZero() {
+		lo = sqliteTime(from)
+	}
+	if !to.IsZero() {
+		hi = sqliteTime(to)
+	}
+	inRange := func(values []any) bool {
+		text := func(col string) string {
+			if i := index[col]; i < len(values) {
+				s, _ := values[i].(string)
+				return s
+			}
+			return ""
+		}
+		switch table {
+		case "sessions":
+			end := text("end_time")
+			return text("start_time") < hi && (end == "" || end >= lo)
+		case "buckets":
+			t := text("start_time")
+			return t >= lo && t < hi
+		case "hosts":
+			return text("first_seen") < hi && text("last_seen") >= lo
+		default:
+			t := text("time")
+			return t >= lo && t < hi
+		}
+	}
+
+	slice := &exportSlice{Table: table, Columns: cols}
+	joined := table != "sessions"
+	var sessions map[int64][2]any
+	if joined {
+		slice.Columns = append(append(cols[:1:1], exportColumn{name: "host", kind: "TEXT"}, exportColumn{name: "interface", kind: "TEXT"}), cols[1:]...)
+		sessions = make(map[int64][2]any)
+		if t := db.Table("sessions"); t != nil {
+			for _, row := range t.Rows {
+				if len(row.Values) >= 3 {
+					sessions[row.ID] = [2]any{row.Values[1], row.Values[2]}
+				}
+			}
+		}
+	}
+	t := db.Table(table)
+	if t == nil {
+		return slice, nil
+	}
+	for _, row := range t.Rows {
+		if !inRange(row.Values) {
+			continue
+		}
+		// Rows of older versions lack the columns added since
+		out := make([]any, len(cols))
+		copy(out, row.Values)
+		for i, c := range cols {
+			if c.key {
+				out[i] = row.ID
+			}
+		}
+		if joined {
+			id, _ := out[0].(int64)
+			s := sessions[id]
+			out = append(append(out[:1:1], s[0], s[1]), out[1:]...)
+		}
+		slice.Rows = append(slice.Rows, out)
+		slice.IDs = append(slice.IDs, row.ID)
+	}
+	return slice, nil
+}
+
+// Cutting the rows down to the page after q's cursor, when a limit is given,
+// and to the columns of fields. Rows are in rowid order, so the cursor is the
+// last rowid.
+func (s *exportSlice) page(q *apiQuery) error {
+	if q.values.Get("limit") != "" {
+		var after int64
+		if q.cursor != "" {
+			n, err := strconv.ParseInt(q.cursor, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid cursor for %s", s.Table)
+			}
+			after = n
+		}
+		start := sort.Search(len(s.IDs), func(i int) bool { return s.IDs[i] > after })
+		end := min(start+q.limit, len(s.Rows))
+		if end < len(s.Rows) {
+			s.Next = base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(s.IDs[end-1], 10)))
+		}
+		s.Rows, s.IDs = s.Rows[start:end], s.IDs[start:end]
+	}
+	if len(q.fields) == 0 {
+		return nil
+	}
+	var picked []int
+	for _, f := range q.fields {
+		i := slices.IndexFunc(s.Columns, func(c exportColumn) bool { return c.name == f })
+		if i < 0 {
+			return fmt.Errorf("unknown field %q for %s", f, s.Table)
+		}
+		picked = append(picked, i)
+	}
+	columns := make([]exportColumn, len(picked))
+	for j, i := range picked {
+		columns[j] = s.Columns[i]
+	}
+	for r, row := range s.Rows {
+		out := make([]any, len(picked))
+		for j, i := range picked {
+			out[j] = row[i]
+		}
+		s.Rows[r] = out
+	}
+	s.Columns = columns
+	return nil
+}
+
+func (s *exportSlice) JSON() ([]byte, error) {
+	rows := make([]map[string]any, len(s.Rows))
+	for i, row := range s.Rows {
+		rows[i] = make(map[string]any, len(row))
+		for j, c := range s.Columns {
+			rows[i][c.name] = row[j]
+		}
+	}
+	return json.MarshalIndent(rows, "", "  ")
+}
+
+func (s *exportSlice) CSV() ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	header := make([]string, len(s.Columns))
+	for i, c := range s.Columns {
+		header[i] = c.name
+	}
+	w.Write(header)
+	for _, row := range s.Rows {
+		record := make([]string, len(row))
+		for i, v := range row {
+			switch x := v.(type) {
+			case nil:
+			case float64:
+				record[i] = strconv.FormatFloat(x, 'f', -1, 64)
+			default:
+				record[i] = fmt.Sprint(x)
+			}
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+// Times become timestamp columns. Values that do not fit the declared type
+// of their column, which SQLite allows, are written as nulls.
+func (s *exportSlice) Parquet() ([]byte, error) {
+	columns := make([]parquet.Column, len(s.Columns))
+	for i, c := range s.Columns {
+		col := parquet.Column{Name: c.name, Values: make([]any, len(s.Rows))}
+		switch {
+		case c.time():
+			col.Type = parquet.Timestamp
+		case c.kind == "INTEGER":
+			col.Type = parquet.Int64
+		case c.kind == "REAL":
+			col.Type = parquet.Double
+		default:
+			col.Type = parquet.String
+		}
+		for j, row := range s.Rows {
+			col.Values[j] = parquetValue(col.Type, row[i])
+		}
+		columns[i] = col
+	}
+	var b bytes.Buffer
+	if err := parquet.Write(&b, columns); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func parquetValue(t parquet.Type, v any) any {
+	switch x := v.(type) {
+	case int64:
+		switch t {
+		case parquet.Int64:
+			return x
+		case parquet.Double:
+			return float64(x)
+		}
+	case float64:
+		switch t {
+		case parquet.Double:
+			return x
+		case parquet.Int64:
+			if x == float64(int64(x)) {
+				return int64(x)
+			}
+		}
+	case string:
+		switch t {
+		case parquet.String:
+			return x
+		case parquet.Timestamp:
+			if ts, err := time.Parse(time.RFC3339, x); err == nil {
+				return ts
+			}
+		}
+	}
+	return nil
+}
+
+var exportFormats = map[string]struct {
+	contentType string
+	encode      func(*exportSlice) ([]byte, error)
+}{
+	"json":    {"application/json", (*exportSlice).JSON},
+	"csv":     {"text/csv; charset=utf-8", (*exportSlice).CSV},
+	"parquet": {"application/vnd.apache.parquet", (*exportSlice).Parquet},
+}
+
+// Serving GET /api/v1/export?from=&to=&format=csv|json|parquet&table= from
+// the -db database, with limit, cursor and fields as on the other endpoints.
+// The next page's cursor comes in the X-Next-Cursor header. The file is read afresh on every request, it is only
+// ever replaced whole.
+func exportHandler(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		aq, err := parseAPIQuery(q)
+		if err == nil {
+			err = aq.only("export", "from", "to")
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format := q.Get("format")
+		if format == "" {
+			format = "json"
+		}
+		f, ok := exportFormats[format]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown format %q, use csv, json or parquet", format), http.StatusBadRequest)
+			return
+		}
+		table := q.Get("table")
+		if table == "" {
+			table = "buckets"
+		}
+
+		db, err := sqlite.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Nothing saved yet
+			db, err = &sqlite.Database{}, nil
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slice, err := exportRows(db, table, aq.from, aq.to)
+		if err == nil {
+			err = slice.page(aq)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if slice.Next != "" {
+			w.Header().Set("X-Next-Cursor", slice.Next)
+		}
+		body, err := f.encode(slice)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", f.contentType)
+		if format != "json" {
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="netwatch-%s.%s"`, table, format))
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// ExposedService is a LAN host port that received connections from the internet
+type ExposedService struct {
+	IP          string
+	Port        int
+	Transport   string
+	Sources     map[string]int
+	Connections int
+	FirstSeen   time.Time
+	LastSeen    time.Time
+}
+
+// ExposureTracker records new flows that were opened from outside the LAN.
+// It is guarded by the MonitoringData mutex.
+type ExposureTracker struct {
+	flows    map[string]bool
+	services map[string]*ExposedService
+}
+
+func NewExposureTracker() *ExposureTracker {
+	return &ExposureTracker{
+		flows:    make(map[string]bool),
+		services: make(map[string]*ExposedService),
+	}
+}
+
+func (t *ExposureTracker) Observe(p *netwatch.Packet) {
+	if p.Transport == "" {
+		return
+	}
+
+	switch p.Transport {
+	case "tcp":
+		// Only a bare SYN tells us who opened the connection
+		if !p.SYN || p.ACK {
+			return
+		}
+	case "udp":
+		// The first packet of a UDP flow decides its direction
+		key := flowKey(p)
+		if t.flows[key] {
+			return
+		}
+		t.flows[key] = true
+		// Traffic to ephemeral ports is almost always a reply to an outbound request
+		if p.DstPort >= 32768 {
+			return
+		}
+	}
+
+	if !isPublicIP(p.SrcIP) || !isLocalIP(p.DstIP) {
+		return
+	}
+
+	key := fmt.Sprintf("%s/%s/%d", p.DstIP, p.Transport, p.DstPort)
+	svc, ok := t.services[key]
+	if !ok {
+		svc = &ExposedService{
+			IP:        p.DstIP,
+			Port:      p.DstPort,
+			Transport: p.Transport,
+			Sources:   make(map[string]int),
+			FirstSeen: p.Time,
+		}
+		t.services[key] = svc
+	}
+	svc.Sources[p.SrcIP]++
+	svc.Connections++
+	svc.LastSeen = p.Time
+}
+
+// Starting a new period, UDP flows opened before it are forgotten too
+func (t *ExposureTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.flows = make(map[string]bool)
+	t.services = make(map[string]*ExposedService)
+}
+
+// Services returns the exposed services ordered by number of external sources
+func (t *ExposureTracker) Services() []*ExposedService {
+	services := make([]*ExposedService, 0, len(t.services))
+	for _, svc := range t.services {
+		services = append(services, svc)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if len(services[i].Sources) != len(services[j].Sources) {
+			return len(services[i].Sources) > len(services[j].Sources)
+		}
+		return services[i].Connections > services[j].Connections
+	})
+	return services
+}
+
+// Direction independent key for a transport flow
+func flowKey(p *netwatch.Packet) string {
+	a := fmt.Sprintf("%s:%d", p.SrcIP, p.SrcPort)
+	b := fmt.Sprintf("%s:%d", p.DstIP, p.DstPort)
+	if a > b {
+		a, b = b, a
+	}
+	return p.Transport + " " + a + " " + b
+}
+
+func printExposureReport(t *ExposureTracker) {
+	services := t.Services()
+	if len(services) == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("INBOUND CONNECTIONS FROM THE INTERNET")
+	for _, svc := range services {
+		fmt.Printf("%s:%d/%s: %d connections from %d external sources (first %s, last %s)\n",
+			svc.IP, svc.Port, svc.Transport, svc.Connections, len(svc.Sources),
+			svc.FirstSeen.Format("15:04:05"), svc.LastSeen.Format("15:04:05"))
+	}
+}
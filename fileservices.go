@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"netwatchd/netwatch"
+)
+
+// FileServiceUsage is SMB or NFS traffic between a client and a server, per
+// SMB share once its tree connect was seen. Reads are the bytes the server
+// sent, writes the bytes the client sent, headers included.
+type FileServiceUsage struct {
+	Protocol   string         `json:"protocol"` // SMB or NFS
+	Client     string         `json:"client"`
+	Server     string         `json:"server"`
+	Share      string         `json:"share,omitempty"`
+	Packets    int            `json:"packets"`
+	ReadBytes  int64          `json:"read_bytes"`
+	WriteBytes int64          `json:"write_bytes"`
+	Ops        map[string]int `json:"ops,omitempty"` // requests by command
+}
+
+// FileServiceTracker attributes SMB (ports 445 and 139) and NFS (2049)
+// traffic to shares and servers. SMB2 tree ids are mapped to the share their
+// tree connect named, and segments without an SMB header, such as the data
+// of a large read, go to the share last used on their connection. It is
+// guarded by the MonitoringData mutex.
+type FileServiceTracker struct {
+	usage     map[string]*FileServiceUsage
+	pending   map[string]string // share of a tree connect request, by connection and message id
+	trees     map[string]string // share by server and tree id
+	lastShare map[string]string // by connection
+}
+
+var fileServicePorts = map[int]string{139: "SMB", 445: "SMB", 2049: "NFS"}
+
+func NewFileServiceTracker() *FileServiceTracker {
+	return &FileServiceTracker{
+		usage:     make(map[string]*FileServiceUsage),
+		pending:   make(map[string]string),
+		trees:     make(map[string]string),
+		lastShare: make(map[string]string),
+	}
+}
+
+func (t *FileServiceTracker) Observe(p *netwatch.Packet) {
+	if p.SrcIP == "" || p.Transport == "" {
+		return
+	}
+	proto := fileServicePorts[p.DstPort]
+	toServer := proto != ""
+	if !toServer {
+		proto = fileServicePorts[p.SrcPort]
+	}
+	if proto == "" || (proto == "SMB" && p.Transport != "tcp") {
+		return
+	}
+	client, server, clientPort := p.SrcIP, p.DstIP, p.SrcPort
+	if !toServer {
+		client, server, clientPort = p.DstIP, p.SrcIP, p.DstPort
+	}
+	conn := fmt.Sprintf("%s:%d %s", client, clientPort, server)
+
+	share := ""
+	if proto == "SMB" {
+		share = t.lastShare[conn]
+		if op := p.FileOp; op != nil && op.Protocol == "smb2" {
+			pendingKey := fmt.Sprintf("%s %d", conn, op.ID)
+			if op.Command == "TREE_CONNECT" && !op.Response && op.Share != "" {
+				t.pending[pendingKey] = op.Share
+			} else if op.Command == "TREE_CONNECT" && op.Response {
+				if s, ok := t.pending[pendingKey]; ok {
+					t.trees[fmt.Sprintf("%s %d", server, op.Tree)] = s
+					delete(t.pending, pendingKey)
+				}
+			}
+			if s := t.trees[fmt.Sprintf("%s %d", server, op.Tree)]; s != "" && op.Tree != 0 {
+				share = s
+			} else if op.Share != "" && op.Command != "TREE_CONNECT" {
+				share = op.Share
+			}
+			t.lastShare[conn] = share
+		}
+	}
+
+	key := proto + " " + client + " " + server + " " + share
+	u, ok := t.usage[key]
+	if !ok {
+		u = &FileServiceUsage{Protocol: proto, Client: client, Server: server, Share: share, Ops: make(map[string]int)}
+		t.usage[key] = u
+	}
+	u.Packets++
+	if toServer {
+		u.WriteBytes += int64(p.Length)
+	} else {
+		u.ReadBytes += int64(p.Length)
+	}
+	if op := p.FileOp; op != nil && !op.Response && op.Command != "" {
+		u.Ops[op.Command]++
+	}
+}
+
+// Client, server and share triples, the busiest first
+func (t *FileServiceTracker) Usage() []FileServiceUsage {
+	if t == nil || len(t.usage) == 0 {
+		return nil
+	}
+	var usage []FileServiceUsage
+	for _, u := range t.usage {
+		c := *u
+		if len(c.Ops) == 0 {
+			c.Ops = nil
+		}
+		usage = append(usage, c)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		ti, tj := usage[i].ReadBytes+usage[i].WriteBytes, usage[j].ReadBytes+usage[j].WriteBytes
+		if ti != tj {
+			return ti > tj
+		}
+		return usage[i].Protocol+usage[i].Client+usage[i].Server+usage[i].Share < usage[j].Protocol+usage[j].Client+usage[j].Server+usage[j].Share
+	})
+	return usage
+}
+
+// Starting a new period, the share of known trees and connections is kept
+func (t *FileServiceTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.usage = make(map[string]*FileServiceUsage)
+}
+
+func printFileServiceReport(t *FileServiceTracker) {
+	usage := t.Usage()
+	if len(usage) == 0 {
+		return
+	}
+
+	// Volume per share, or per server for NFS and shares never connected to
+	type target struct {
+		name        string
+		read, write int64
+		clients     map[string]bool
+	}
+	targets := make(map[string]*target)
+	for _, u := range usage {
+		name := u.Protocol + " " + u.Server
+		if u.Share != "" {
+			name = u.Protocol + " " + u.Share + " on " + u.Server
+		}
+		tg := targets[name]
+		if tg == nil {
+			tg = &target{name: name, clients: make(map[string]bool)}
+			targets[name] = tg
+		}
+		tg.read += u.ReadBytes
+		tg.write += u.WriteBytes
+		tg.clients[u.Client] = true
+	}
+	var list []*target
+	for _, tg := range targets {
+		list = append(list, tg)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].read+list[i].write != list[j].read+list[j].write {
+			return list[i].read+list[i].write > list[j].read+list[j].write
+		}
+		return list[i].name < list[j].name
+	})
+
+	mb := func(b int64) float64 { return float64(b) / (1024 * 1024) }
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("FILE SERVICES")
+	for _, tg := range list {
+		fmt.Printf("%s: %.2f MB read | %.2f MB written | %d clients\n", tg.name, mb(tg.read), mb(tg.write), len(tg.clients))
+	}
+	fmt.Println("Top consumers:")
+	for i, u := range usage {
+		if i == 10 {
+			break
+		}
+		where := u.Server
+		if u.Share != "" {
+			where = u.Share
+		}
+		var ops []string
+		for name, n := range u.Ops {
+			ops = append(ops, fmt.Sprintf("%s %d", name, n))
+		}
+		sort.Strings(ops)
+		line := fmt.Sprintf("  %s %s -> %s: %.2f MB read | %.2f MB written", u.Protocol, u.Client, where, mb(u.ReadBytes), mb(u.WriteBytes))
+		if len(ops) > 0 {
+			line += " (" + strings.Join(ops, ", ") + ")"
+		}
+		fmt.Println(line)
+	}
+}
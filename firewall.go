@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	linux "netwatchd/netstat"
+	"netwatchd/pdh"
+)
+
+// Collecting how much traffic the local firewall dropped during the run
+func collectFirewallDrops(ctx context.Context, data *MonitoringData) {
+	switch runtime.GOOS {
+	case "linux":
+		collectLinuxFirewallDrops(ctx, data)
+	case "windows":
+		collectWFPDrops(ctx, data)
+	}
+}
+
+func collectLinuxFirewallDrops(ctx context.Context, data *MonitoringData) {
+	before, err := linux.GetFirewallCounters()
+	if err != nil {
+		return
+	}
+
+	<-ctx.Done()
+
+	after, err := linux.GetFirewallCounters()
+	if err != nil {
+		return
+	}
+
+	start := make(map[string]linux.FirewallCounter)
+	for _, c := range before {
+		start[c.Key()] = c
+	}
+	var drops []linux.FirewallCounter
+	for _, c := range after {
+		old, ok := start[c.Key()]
+		if !ok || c.Packets <= old.Packets {
+			continue
+		}
+		c.Packets -= old.Packets
+		c.Bytes -= old.Bytes
+		drops = append(drops, c)
+	}
+	sort.Slice(drops, func(i, j int) bool {
+		return drops[i].Packets > drops[j].Packets
+	})
+
+	data.mu.Lock()
+	data.firewallDrops = drops
+	data.firewallChecked = true
+	data.mu.Unlock()
+}
+
+// The Windows Filtering Platform only exposes discard rates, sum them per second
+func collectWFPDrops(ctx context.Context, data *MonitoringData) {
+	query, err := pdh.OpenQuery()
+	if err != nil {
+		logger.Debug("no WFP drop counters", "err", err)
+		return
+	}
+	defer query.Close()
+
+	var counters []*pdh.Counter
+	var names []string
+	for _, object := range []string{"WFPv4", "WFPv6"} {
+		c, err := query.AddCounter("\\" + object + "\\Packets Discarded/sec")
+		if err != nil {
+			logger.Debug("no WFP drop counter", "object", object, "err", err)
+			continue
+		}
+		defer c.Close()
+		counters = append(counters, c)
+		names = append(names, object)
+	}
+	if len(counters) == 0 {
+		return
+	}
+
+	totals := make([]float64, len(counters))
+	query.Collect()
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			var drops []linux.FirewallCounter
+			for i, total := range totals {
+				if total >= 1 {
+					drops = append(drops, linux.FirewallCounter{Table: "WFP", Chain: names[i], Rule: "all filters", Action: "discard", Packets: uint64(total)})
+				}
+			}
+			data.mu.Lock()
+			data.firewallDrops = drops
+			data.firewallChecked = true
+			data.mu.Unlock()
+			return
+		case <-ticker.C:
+			if err := query.Collect(); err != nil {
+				logger.Debug("WFP drop sample failed", "err", err)
+				continue
+			}
+			for i, c := range counters {
+				v, err := c.GetValue()
+				if err != nil {
+					logger.Debug("WFP drop counter read failed", "object", names[i], "err", err)
+					continue
+				}
+				totals[i] += v
+			}
+		}
+	}
+}
+
+func printFirewallReport(checked bool, drops []linux.FirewallCounter) {
+	if !checked {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("FIREWALL DROPS")
+	if len(drops) == 0 {
+		fmt.Println("No packets dropped by the local firewall")
+		return
+	}
+
+	var total uint64
+	for _, d := range drops {
+		total += d.Packets
+		fmt.Printf("%s %s %s [%s]: %d packets", d.Table, d.Chain, d.Rule, d.Action, d.Packets)
+		if d.Bytes > 0 {
+			fmt.Printf(" | %.2f MB", float64(d.Bytes)/(1024*1024))
+		}
+		fmt.Println()
+	}
+	fmt.Printf("Total dropped locally: %d packets\n", total)
+}
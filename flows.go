@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// Flow records kept per period, later flows of the period are not stored
+const flowMaxRecords = 100000
+
+// FlowRecord is the traffic of one direction of a flow in one bucket, as
+// stored in the flows table of -db
+type FlowRecord struct {
+	Start   time.Time
+	Seconds float64
+	SrcIP   string
+	DstIP   string
+	SrcPort int
+	DstPort int
+	Proto   string // tcp, udp, or the protocol of other IP traffic, e.g. icmp
+	Packets int
+	Bytes   int64
+}
+
+type flowRecordKey struct {
+	src, dst         string
+	srcPort, dstPort int
+	proto            string
+}
+
+// FlowTracker counts packets and bytes per flow and bucket for the -db
+// flows table, so queries can break traffic down by address, port and
+// protocol. It only runs with -db and is guarded by the MonitoringData
+// mutex.
+type FlowTracker struct {
+	current map[flowRecordKey]*FlowRecord
+	records []FlowRecord
+	dropped int // packets of flows past flowMaxRecords
+}
+
+func NewFlowTracker() *FlowTracker {
+	return &FlowTracker{current: make(map[flowRecordKey]*FlowRecord)}
+}
+
+func (t *FlowTracker) Observe(p *netwatch.Packet) {
+	if t == nil || p.SrcIP == "" || p.DstIP == "" {
+		return
+	}
+	proto := p.Transport
+	if proto == "" {
+		proto = strings.ToLower(p.Protocol)
+	}
+	k := flowRecordKey{p.SrcIP, p.DstIP, p.SrcPort, p.DstPort, proto}
+	f := t.current[k]
+	if f == nil {
+		if len(t.records)+len(t.current) >= flowMaxRecords {
+			if t.dropped == 0 {
+				logger.Warn("too many flows, later flows of the period are not stored", "limit", flowMaxRecords)
+			}
+			t.dropped++
+			return
+		}
+		f = &FlowRecord{SrcIP: p.SrcIP, DstIP: p.DstIP, SrcPort: p.SrcPort, DstPort: p.DstPort, Proto: proto}
+		t.current[k] = f
+	}
+	f.Packets++
+	f.Bytes += int64(p.Length)
+}
+
+// Closing the bucket that started at start and lasted seconds. Callers hold data.mu.
+func (t *FlowTracker) rotate(start time.Time, seconds float64) {
+	if t == nil {
+		return
+	}
+	first := len(t.records)
+	for _, f := range t.current {
+		f.Start, f.Seconds = start, seconds
+		t.records = append(t.records, *f)
+	}
+	// The busiest flows of a bucket first
+	bucket := t.records[first:]
+	sort.Slice(bucket, func(i, j int) bool {
+		if bucket[i].Bytes != bucket[j].Bytes {
+			return bucket[i].Bytes > bucket[j].Bytes
+		}
+		a, b := bucket[i], bucket[j]
+		return fmt.Sprint(a.SrcIP, a.SrcPort, a.DstIP, a.DstPort, a.Proto) < fmt.Sprint(b.SrcIP, b.SrcPort, b.DstIP, b.DstPort, b.Proto)
+	})
+	t.current = make(map[flowRecordKey]*FlowRecord)
+}
+
+// Flow records of the closed buckets
+func (t *FlowTracker) Records() []FlowRecord {
+	if t == nil {
+		return nil
+	}
+	return t.records
+}
+
+func (t *FlowTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.records = nil
+	t.dropped = 0
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parsing a link speed like 100Mbps, 1Gbps or 500k, plain numbers are Mbps
+func parseBitrate(s string) (float64, error) {
+	s = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "bps")
+	mult := 1e6
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult, s = 1e3, strings.TrimSuffix(s, "k")
+	case strings.HasSuffix(s, "m"):
+		mult, s = 1e6, strings.TrimSuffix(s, "m")
+	case strings.HasSuffix(s, "g"):
+		mult, s = 1e9, strings.TrimSuffix(s, "g")
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid bit rate %q, use e.g. 100Mbps or 1Gbps", s)
+	}
+	return v * mult, nil
+}
+
+func formatBitrate(bps float64) string {
+	switch {
+	case bps >= 1e9:
+		return fmt.Sprintf("%.2f Gbps", bps/1e9)
+	case bps >= 1e6:
+		return fmt.Sprintf("%.2f Mbps", bps/1e6)
+	default:
+		return fmt.Sprintf("%.0f kbps", bps/1e3)
+	}
+}
+
+// Report files named on the command line, directories are searched for *.json
+func reportPaths(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		matches, _ := filepath.Glob(filepath.Join(arg, "*.json"))
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// Highest bucket rate of each day across all reports, in bits per second
+func dailyPeaks(reports []*Report) map[time.Time]float64 {
+	peaks := make(map[time.Time]float64)
+	for _, r := range reports {
+		for _, b := range r.Buckets {
+			if b.Seconds <= 0 {
+				continue
+			}
+			y, m, d := b.Start.Date()
+			day := time.Date(y, m, d, 0, 0, 0, 0, b.Start.Location())
+			peaks[day] = max(peaks[day], b.Bytes*8/b.Seconds)
+		}
+	}
+	return peaks
+}
+
+// linearFit is a least squares line through the daily peaks
+type linearFit struct {
+	slope, intercept float64 // bps per day, bps at day 0
+	stderr           float64 // residual standard error
+	n                int
+	meanX, sxx       float64
+}
+
+func fitLine(xs, ys []float64) (linearFit, error) {
+	n := float64(len(xs))
+	if len(xs) < 3 {
+		return linearFit{}, errors.New("need at least 3 days of history")
+	}
+	var mx, my float64
+	for i := range xs {
+		mx += xs[i]
+		my += ys[i]
+	}
+	mx /= n
+	my /= n
+	var sxx, sxy float64
+	for i := range xs {
+		sxx += (xs[i] - mx) * (xs[i] - mx)
+		sxy += (xs[i] - mx) * (ys[i] - my)
+	}
+	if sxx == 0 {
+		return linearFit{}, errors.New("history covers a single day")
+	}
+	f := linearFit{slope: sxy / sxx, n: len(xs), meanX: mx, sxx: sxx}
+	f.intercept = my - f.slope*mx
+	var rss float64
+	for i := range xs {
+		r := ys[i] - (f.intercept + f.slope*xs[i])
+		rss += r * r
+	}
+	f.stderr = math.Sqrt(rss / (n - 2))
+	return f, nil
+}
+
+// Half width of the ~95% prediction band at day x
+func (f linearFit) band(x float64) float64 {
+	return 2 * f.stderr * math.Sqrt(1+1/float64(f.n)+(x-f.meanX)*(x-f.meanX)/f.sxx)
+}
+
+// First day at or after from where fn crosses target, searching up to ten years
+func crossing(from float64, fn func(float64) float64, target float64) (float64, bool) {
+	for x := from; x <= from+3650; x++ {
+		if fn(x) >= target {
+			return x, true
+		}
+	}
+	return 0, false
+}
+
+// Handling "netwatchd forecast"
+func runForecastCommand(args []string) int {
+	fs := flag.NewFlagSet("forecast", flag.ExitOnError)
+	capacityFlag := fs.String("capacity", "", "Link capacity, e.g. 100Mbps or 1Gbps (required)")
+	thresholdsFlag := fs.String("thresholds", "80,95", "Comma separated utilization percentages to forecast")
+	fs.Parse(args)
+	if *capacityFlag == "" || fs.NArg() == 0 {
+		fmt.Println("Usage: netwatchd forecast -capacity 1Gbps [-thresholds 80,95] <report.json|dir> ...")
+		return 2
+	}
+
+	capacity, err := parseBitrate(*capacityFlag)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	var thresholds []float64
+	for _, t := range strings.Split(*thresholdsFlag, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		if err != nil || v <= 0 {
+			fmt.Printf("Invalid threshold %q\n", t)
+			return 2
+		}
+		thresholds = append(thresholds, v)
+	}
+
+	paths, err := reportPaths(fs.Args())
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	var reports []*Report
+	for _, p := range paths {
+		r, err := loadReport(p)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		reports = append(reports, r)
+	}
+
+	peaks := dailyPeaks(reports)
+	var days []time.Time
+	for d := range peaks {
+		days = append(days, d)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	var xs, ys []float64
+	for _, d := range days {
+		xs = append(xs, d.Sub(days[0]).Hours()/24)
+		ys = append(ys, peaks[d])
+	}
+	fit, err := fitLine(xs, ys)
+	if err != nil {
+		fmt.Printf("Cannot forecast: %v (have %d days from %d reports)\n", err, len(days), len(reports))
+		return 1
+	}
+
+	fmt.Println(strings.Repeat("=", 60))
+	fmt.Println("CAPACITY FORECAST")
+	fmt.Println(strings.Repeat("=", 60))
+	fmt.Printf("Capacity: %s | history: %d days (%s to %s)\n", formatBitrate(capacity), len(days),
+		days[0].Format("2006-01-02"), days[len(days)-1].Format("2006-01-02"))
+	last := xs[len(xs)-1]
+	fmt.Printf("Latest daily peak: %s (%.1f%%)\n", formatBitrate(ys[len(ys)-1]), ys[len(ys)-1]/capacity*100)
+	sign := "+"
+	if fit.slope < 0 {
+		sign = "-"
+	}
+	fmt.Printf("Trend: %s%s per day\n", sign, formatBitrate(math.Abs(fit.slope)))
+
+	fmt.Println(strings.Repeat("-", 60))
+	for _, t := range thresholds {
+		target := capacity * t / 100
+		label := fmt.Sprintf("%.0f%% (%s)", t, formatBitrate(target))
+		// The band's upper edge crosses first, its lower edge last
+		early, okEarly := crossing(last, func(x float64) float64 { return fit.intercept + fit.slope*x + fit.band(x) }, target)
+		mid, okMid := crossing(last, func(x float64) float64 { return fit.intercept + fit.slope*x }, target)
+		late, okLate := crossing(last, func(x float64) float64 { return fit.intercept + fit.slope*x - fit.band(x) }, target)
+		dayOf := func(x float64) string {
+			return days[0].AddDate(0, 0, int(x)).Format("2006-01-02")
+		}
+		switch {
+		case !okEarly:
+			fmt.Printf("%s: not reached within 10 years\n", label)
+		case !okMid:
+			fmt.Printf("%s: unlikely, earliest %s\n", label, dayOf(early))
+		case !okLate:
+			fmt.Printf("%s: around %s (earliest %s)\n", label, dayOf(mid), dayOf(early))
+		default:
+			fmt.Printf("%s: around %s (between %s and %s)\n", label, dayOf(mid), dayOf(early), dayOf(late))
+		}
+	}
+	return 0
+}
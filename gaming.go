@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// Online games by their server ports, transport empty for both
+var gamePorts = []struct {
+	name      string
+	transport string
+	from, to  int
+}{
+	{"Steam", "udp", 27000, 27050},
+	{"Xbox Live", "", 3074, 3074},
+	{"PlayStation Network", "", 3658, 3658},
+	{"League of Legends", "udp", 5000, 5500},
+	{"Riot Games", "udp", 7000, 8000},
+	{"Minecraft", "tcp", 25565, 25565},
+	{"Minecraft Bedrock", "udp", 19132, 19133},
+	{"Battle.net", "", 1119, 1119},
+	{"World of Warcraft", "tcp", 3724, 3724},
+	{"Apex Legends", "udp", 37005, 38515},
+}
+
+// UDP ports that are never game traffic however small and steady
+var notGamePorts = map[int]bool{53: true, 67: true, 68: true, 123: true, 137: true, 138: true, 161: true, 162: true,
+	443: true, 500: true, 514: true, 1900: true, 4500: true, 5353: true, 5355: true}
+
+const (
+	// A UDP flow of small packets coming at least this often in both
+	// directions looks like a game even on an unknown port
+	gameHeuristicPps  = 10
+	gameHeuristicSize = 250
+	// Flows averaging this many bits per second over a bucket count as bulk
+	bulkFlowBps = 1e6
+	// Bounding memory on scans, later flows are not tracked
+	gameMaxFlows     = 5000
+	gameMaxIntervals = 5000
+)
+
+func gamePort(transport string, port int) string {
+	for _, g := range gamePorts {
+		if port >= g.from && port <= g.to && (g.transport == "" || g.transport == transport) {
+			return g.name
+		}
+	}
+	return ""
+}
+
+// GameFlow is one game session between a client and a game server
+type GameFlow struct {
+	Game        string  `json:"game"`
+	Transport   string  `json:"transport"`
+	Client      string  `json:"client"`
+	Server      string  `json:"server"`
+	Packets     int     `json:"packets"`
+	Bytes       int64   `json:"bytes"`
+	TickRate    float64 `json:"tick_rate,omitempty"` // server packets per second
+	JitterMs    float64 `json:"jitter_ms"`
+	LossPercent float64 `json:"loss_percent"`        // server packets estimated missing from gaps in the tick
+	RTTMs       float64 `json:"rtt_ms,omitempty"`    // TCP handshake
+	Heuristic   bool    `json:"heuristic,omitempty"` // recognised by its traffic, not its port
+}
+
+// GameBucket is game traffic against bulk traffic in one bucket
+type GameBucket struct {
+	Start       time.Time `json:"start"`
+	Flows       int       `json:"flows"`
+	JitterMs    float64   `json:"jitter_ms"`
+	LossPercent float64   `json:"loss_percent"`
+	BulkBytes   int64     `json:"bulk_bytes"` // flows averaging at least 1 Mbit/s
+	Degraded    bool      `json:"degraded,omitempty"`
+}
+
+type gameFlow struct {
+	GameFlow
+	up, down       int       // current bucket packets by direction
+	bytes          int64     // current bucket
+	lastDown       time.Time // last server packet
+	intervals      []float64 // between server packets in the current bucket, seconds
+	ticks, missing int       // over the period
+	jitterSum      float64   // weighted by ticks
+	tickSum        float64
+	buckets        int
+	syn            time.Time
+}
+
+// GameTracker recognises game traffic by port, or by small packets at a
+// steady rate in both directions, and estimates each session's jitter and
+// loss from the tick of the server's packets. Game servers send at a fixed
+// rate, so the spread of the gaps between their packets is the jitter and
+// gaps of several ticks are missing packets. Per bucket the result is
+// weighed against bulk transfers to show when they hurt the game. It is
+// guarded by the MonitoringData mutex.
+type GameTracker struct {
+	flows   map[string]*gameFlow
+	bulk    map[string]int64 // bytes of other flows in the current bucket
+	buckets []GameBucket
+}
+
+func NewGameTracker() *GameTracker {
+	return &GameTracker{flows: make(map[string]*gameFlow), bulk: make(map[string]int64)}
+}
+
+func (t *GameTracker) Observe(p *netwatch.Packet) {
+	if t == nil || p.SrcIP == "" || p.Transport == "" {
+		return
+	}
+	key := flowKey(p)
+	f := t.flows[key]
+	if f == nil {
+		// DNS, NTP and the like are never the game, also when their clients
+		// happen to use a port in a game's range
+		excluded := p.HasLayer("dns") || notGamePorts[p.SrcPort] || notGamePorts[p.DstPort]
+		game, serverSrc := "", false
+		// A game port facing a well known port is a client of that service
+		if g := gamePort(p.Transport, p.DstPort); g != "" && !excluded && p.SrcPort >= 1024 {
+			game = g
+		} else if g := gamePort(p.Transport, p.SrcPort); g != "" && !excluded && p.DstPort >= 1024 {
+			game, serverSrc = g, true
+		}
+		candidate := !excluded && p.Transport == "udp" && p.RTP == nil && p.SIP == nil && !p.HasLayer("stun") &&
+			!p.HasLayer("dtls") && p2pProtocol(p) == ""
+		if (game == "" && !candidate) || len(t.flows) >= gameMaxFlows {
+			t.bulk[key] += int64(p.Length)
+			return
+		}
+		// With no known port the server is the side on the lower port
+		if game == "" {
+			serverSrc = p.SrcPort < p.DstPort
+		}
+		f = &gameFlow{GameFlow: GameFlow{Game: game, Transport: p.Transport,
+			Client: fmt.Sprintf("%s:%d", p.DstIP, p.DstPort), Server: fmt.Sprintf("%s:%d", p.SrcIP, p.SrcPort)}}
+		if !serverSrc {
+			f.Client, f.Server = f.Server, f.Client
+		}
+		t.flows[key] = f
+	}
+
+	f.bytes += int64(p.Length)
+	if fmt.Sprintf("%s:%d", p.SrcIP, p.SrcPort) != f.Server {
+		f.up++
+		if p.SYN && !p.ACK {
+			f.syn = p.Time
+		}
+		return
+	}
+	f.down++
+	if p.SYN && p.ACK && !f.syn.IsZero() && f.RTTMs == 0 {
+		f.RTTMs = float64(p.Time.Sub(f.syn).Microseconds()) / 1000
+	}
+	// Several packets of one tick arrive back to back, only the gaps between ticks count
+	if gap := p.Time.Sub(f.lastDown).Seconds(); !f.lastDown.IsZero() && gap >= 0.0005 && len(f.intervals) < gameMaxIntervals {
+		f.intervals = append(f.intervals, gap)
+	}
+	f.lastDown = p.Time
+}
+
+// Spread and gaps of the server's tick in one bucket: the median gap is the
+// tick, the mean distance from it the jitter, and a gap of n ticks n-1
+// missing packets
+func tickStats(intervals []float64) (tick, jitter float64, missing int) {
+	sorted := append([]float64(nil), intervals...)
+	sort.Float64s(sorted)
+	tick = sorted[len(sorted)/2]
+	for _, iv := range intervals {
+		jitter += math.Abs(iv - tick)
+		if iv > 1.5*tick {
+			missing += int(math.Round(iv/tick)) - 1
+		}
+	}
+	return tick, jitter / float64(len(intervals)), missing
+}
+
+// Closing a bucket that started at start. Callers hold data.mu.
+func (t *GameTracker) rotate(start time.Time, seconds float64) {
+	if t == nil || seconds <= 0 {
+		return
+	}
+	b := GameBucket{Start: start}
+	var jitterSum float64
+	ticks, missing := 0, 0
+	for key, f := range t.flows {
+		if f.Game == "" && f.up+f.down > 0 {
+			pps := float64(f.down) / seconds
+			if pps >= gameHeuristicPps && float64(f.up)/seconds >= gameHeuristicPps/2 && f.bytes/int64(f.up+f.down) < gameHeuristicSize {
+				f.Game, f.Heuristic = "Unknown game", true
+			}
+		}
+		if f.Game == "" {
+			// Not a game after all, its bytes may still be bulk
+			t.bulk[key] += f.bytes
+			delete(t.flows, key)
+			continue
+		}
+		f.Packets += f.up + f.down
+		f.Bytes += f.bytes
+		if len(f.intervals) >= 10 {
+			tick, jitter, lost := tickStats(f.intervals)
+			n := len(f.intervals)
+			f.jitterSum += jitter * float64(n)
+			f.tickSum += 1 / tick
+			f.buckets++
+			f.ticks += n
+			f.missing += lost
+			jitterSum += jitter * float64(n)
+			ticks += n
+			missing += lost
+			b.Flows++
+		}
+		f.up, f.down, f.bytes, f.intervals = 0, 0, 0, nil
+	}
+	for _, bytes := range t.bulk {
+		if float64(bytes)*8/seconds >= bulkFlowBps {
+			b.BulkBytes += bytes
+		}
+	}
+	t.bulk = make(map[string]int64)
+	if ticks > 0 {
+		b.JitterMs = jitterSum / float64(ticks) * 1000
+		b.LossPercent = lossPercent(missing, ticks)
+	}
+	t.buckets = append(t.buckets, b)
+}
+
+// Game sessions, the busiest first
+func (t *GameTracker) Flows() []GameFlow {
+	if t == nil {
+		return nil
+	}
+	var flows []GameFlow
+	for _, f := range t.flows {
+		if f.Game == "" || f.Packets == 0 {
+			continue
+		}
+		g := f.GameFlow
+		if f.ticks > 0 {
+			g.JitterMs = f.jitterSum / float64(f.ticks) * 1000
+			g.LossPercent = lossPercent(f.missing, f.ticks)
+			g.TickRate = f.tickSum / float64(f.buckets)
+		}
+		flows = append(flows, g)
+	}
+	sort.Slice(flows, func(i, j int) bool {
+		if flows[i].Packets != flows[j].Packets {
+			return flows[i].Packets > flows[j].Packets
+		}
+		return flows[i].Client+flows[i].Server < flows[j].Client+flows[j].Server
+	})
+	return flows
+}
+
+// Buckets with game traffic, marked degraded when the game's jitter or loss
+// rose while bulk transfers ran harder than usual
+func (t *GameTracker) Buckets() []GameBucket {
+	if t == nil {
+		return nil
+	}
+	var buckets []GameBucket
+	var jitters, bulks []float64
+	for _, b := range t.buckets {
+		if b.Flows > 0 {
+			buckets = append(buckets, b)
+			jitters = append(jitters, b.JitterMs)
+			bulks = append(bulks, float64(b.BulkBytes))
+		}
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+	sort.Float64s(jitters)
+	sort.Float64s(bulks)
+	baseJitter, baseBulk := jitters[len(jitters)/2], bulks[len(bulks)/2]
+	for i := range buckets {
+		b := &buckets[i]
+		worse := (b.JitterMs >= 5 && b.JitterMs > 2*baseJitter) || b.LossPercent >= 1
+		b.Degraded = worse && b.BulkBytes > 0 && float64(b.BulkBytes) >= baseBulk
+	}
+	return buckets
+}
+
+// Starting a new period, sessions are kept with their counts reset
+func (t *GameTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.buckets = nil
+	for _, f := range t.flows {
+		f.Packets, f.Bytes, f.ticks, f.missing, f.jitterSum, f.tickSum, f.buckets = 0, 0, 0, 0, 0, 0, 0
+	}
+}
+
+func printGameReport(t *GameTracker, bucket time.Duration) {
+	flows := t.Flows()
+	if len(flows) == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("GAMING")
+	for i, f := range flows {
+		if i == 10 {
+			fmt.Printf("... %d more sessions\n", len(flows)-i)
+			break
+		}
+		name := f.Game
+		if f.Heuristic {
+			name += " (by traffic pattern)"
+		}
+		line := fmt.Sprintf("%s %s %s -> %s: %d packets | %.2f MB", name, strings.ToUpper(f.Transport), f.Client, f.Server, f.Packets, float64(f.Bytes)/(1024*1024))
+		if f.TickRate > 0 {
+			line += fmt.Sprintf(" | %.0f ticks/s | jitter %.1f ms | loss %.2f%%", f.TickRate, f.JitterMs, f.LossPercent)
+		}
+		if f.RTTMs > 0 {
+			line += fmt.Sprintf(" | RTT %.1f ms", f.RTTMs)
+		}
+		fmt.Println(line)
+	}
+
+	var degraded []string
+	for _, b := range t.Buckets() {
+		if b.Degraded {
+			degraded = append(degraded, fmt.Sprintf("%s: jitter %.1f ms, loss %.2f%% with %.1f MB of bulk transfers",
+				b.Start.Format("15:04:05"), b.JitterMs, b.LossPercent, float64(b.BulkBytes)/(1024*1024)))
+		}
+	}
+	if len(degraded) > 0 {
+		fmt.Printf("%ss where bulk traffic likely hurt gaming:\n", bucketUnit(bucket))
+		for _, d := range degraded {
+			fmt.Println("  " + d)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const week = 7 * 24 * time.Hour
+
+// weekHistory finds what earlier reports saw at the same time a week before.
+// Sources are report files, directories of them or globs.
+type weekHistory struct {
+	sources []string
+}
+
+// ReportLastWeek holds the traffic a week before each bucket, null where the
+// earlier reports don't cover it
+type ReportLastWeek struct {
+	Buckets []*ReportBucket `json:"buckets"`
+}
+
+func (h *weekHistory) paths() []string {
+	seen := make(map[string]bool)
+	var paths []string
+	for _, s := range h.sources {
+		var matches []string
+		if info, err := os.Stat(s); err == nil && info.IsDir() {
+			matches, _ = filepath.Glob(filepath.Join(s, "*.json"))
+		} else {
+			matches, _ = filepath.Glob(s)
+		}
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				paths = append(paths, m)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Buckets of earlier reports overlapping from-to
+func (h *weekHistory) buckets(from, to time.Time) []ReportBucket {
+	var found []ReportBucket
+	for _, path := range h.paths() {
+		r, err := loadReport(path)
+		if err != nil || !r.Start.Before(to) || !r.End.After(from) {
+			continue
+		}
+		for _, b := range r.Buckets {
+			end := b.Start.Add(time.Duration(b.Seconds * float64(time.Second)))
+			if b.Start.Before(to) && end.After(from) && b.Seconds > 0 {
+				found = append(found, b)
+			}
+		}
+	}
+	return found
+}
+
+// The same windows a week before, a bucket is only compared when at least
+// nine tenths of it is covered
+func (h *weekHistory) compare(buckets []ReportBucket) *ReportLastWeek {
+	if h == nil || len(buckets) == 0 {
+		return nil
+	}
+	first := buckets[0].Start.Add(-week)
+	last := buckets[len(buckets)-1]
+	history := h.buckets(first, last.Start.Add(time.Duration(last.Seconds*float64(time.Second))).Add(-week))
+	if len(history) == 0 {
+		return nil
+	}
+
+	lw := &ReportLastWeek{Buckets: make([]*ReportBucket, len(buckets))}
+	compared := false
+	for i, b := range buckets {
+		from := b.Start.Add(-week)
+		to := from.Add(time.Duration(b.Seconds * float64(time.Second)))
+		prev := ReportBucket{Start: from, Seconds: b.Seconds}
+		covered := 0.0
+		var packets float64
+		for _, old := range history {
+			oldEnd := old.Start.Add(time.Duration(old.Seconds * float64(time.Second)))
+			overlap := minTime(to, oldEnd).Sub(maxTime(from, old.Start)).Seconds()
+			if overlap <= 0 {
+				continue
+			}
+			// Spreading an old bucket evenly over its length
+			share := overlap / old.Seconds
+			covered += overlap
+			packets += float64(old.Packets) * share
+			prev.Bytes += old.Bytes * share
+			prev.BytesSent += old.BytesSent * share
+			prev.BytesReceived += old.BytesReceived * share
+		}
+		if covered < 0.9*b.Seconds {
+			continue
+		}
+		prev.Packets = int(packets + 0.5)
+		lw.Buckets[i] = &prev
+		compared = true
+	}
+	if !compared {
+		return nil
+	}
+	return lw
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// "+35%", or "new" when there was nothing before
+func percentChange(now, before float64) string {
+	switch {
+	case before == 0 && now == 0:
+		return "+0%"
+	case before == 0:
+		return "new"
+	}
+	return fmt.Sprintf("%+.0f%%", (now-before)/before*100)
+}
+
+// Annotation for one bucket line, empty without a comparison
+func (lw *ReportLastWeek) note(i int, b ReportBucket) string {
+	if lw == nil || i >= len(lw.Buckets) || lw.Buckets[i] == nil {
+		return ""
+	}
+	prev := lw.Buckets[i]
+	return fmt.Sprintf(" (%s packets, %s MB vs last %s)", percentChange(float64(b.Packets), float64(prev.Packets)),
+		percentChange(b.Bytes, prev.Bytes), prev.Start.Weekday())
+}
+
+func printLastWeekTotal(lw *ReportLastWeek, buckets []ReportBucket) {
+	if lw == nil {
+		return
+	}
+	var packets, prevPackets int
+	var bytes, prevBytes float64
+	n := 0
+	var day time.Weekday
+	for i, prev := range lw.Buckets {
+		if prev == nil || i >= len(buckets) {
+			continue
+		}
+		n++
+		day = prev.Start.Weekday()
+		packets += buckets[i].Packets
+		bytes += buckets[i].Bytes
+		prevPackets += prev.Packets
+		prevBytes += prev.Bytes
+	}
+	fmt.Printf("vs last %s: %d packets (%s) | %.2f MB (%s), %d of %d buckets compared\n", day, prevPackets,
+		percentChange(float64(packets), float64(prevPackets)), prevBytes/(1024*1024), percentChange(bytes, prevBytes), n, len(buckets))
+}
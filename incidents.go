@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	pagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+	opsgenieURL  = "https://api.opsgenie.com/v2/alerts"
+)
+
+// Default mapping from netwatchd severities, overridden with severity_map
+var (
+	pagerDutySeverity = map[string]string{"critical": "critical", "warning": "warning", "info": "info"}
+	opsgeniePriority  = map[string]string{"critical": "P1", "warning": "P3", "info": "P5"}
+)
+
+func mapSeverity(defaults, overrides map[string]string, severity string) string {
+	if v, ok := overrides[severity]; ok {
+		return v
+	}
+	return defaults[severity]
+}
+
+// pagerDutySink triggers and resolves incidents through the Events API v2.
+// The alert key is the dedup key, so repeats update the same incident.
+type pagerDutySink struct{ c SinkConfig }
+
+func (s pagerDutySink) Send(ev AlertEvent, text string) error {
+	event := map[string]any{
+		"routing_key":  s.c.Key,
+		"event_action": "trigger",
+		"dedup_key":    ev.Key,
+	}
+	if ev.Resolved {
+		event["event_action"] = "resolve"
+	} else {
+		event["payload"] = map[string]any{
+			"summary":        truncate(text, 1024),
+			"source":         ev.Host,
+			"severity":       mapSeverity(pagerDutySeverity, s.c.SeverityMap, ev.Severity),
+			"timestamp":      ev.Time.Format("2006-01-02T15:04:05.000Z07:00"),
+			"component":      ev.Kind,
+			"custom_details": map[string]any{"message": ev.Message, "labels": ev.Labels},
+		}
+	}
+	u := s.c.URL
+	if u == "" {
+		u = pagerDutyURL
+	}
+	return postJSON(u, event)
+}
+
+// opsgenieSink creates alerts with the alert key as alias and closes them on resolution
+type opsgenieSink struct{ c SinkConfig }
+
+func (s opsgenieSink) Send(ev AlertEvent, text string) error {
+	base := s.c.URL
+	if base == "" {
+		base = opsgenieURL
+	}
+	if ev.Resolved {
+		target := fmt.Sprintf("%s/%s/close?identifierType=alias", strings.TrimSuffix(base, "/"), url.PathEscape(ev.Key))
+		return s.post(target, map[string]any{"source": ev.Host, "note": ev.Message})
+	}
+	tags := []string{"netwatchd", ev.Kind}
+	for k, v := range ev.Labels {
+		tags = append(tags, k+":"+v)
+	}
+	return s.post(base, map[string]any{
+		"message":     truncate(text, 130),
+		"alias":       ev.Key,
+		"description": ev.Message,
+		"priority":    mapSeverity(opsgeniePriority, s.c.SeverityMap, ev.Severity),
+		"source":      ev.Host,
+		"entity":      ev.Host,
+		"tags":        tags,
+	})
+}
+
+func (s opsgenieSink) post(target string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "GenieKey "+s.c.Key)
+	resp, err := sinkClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("opsgenie returned %s", resp.Status)
+	}
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
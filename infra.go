@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"netwatchd/iphlpapi"
+	linux "netwatchd/netstat"
+)
+
+// HealthCheck accumulates results of one periodic infrastructure check
+type HealthCheck struct {
+	Name     string
+	Target   string
+	Checks   int
+	Failures int
+	Total    time.Duration
+	Max      time.Duration
+	failRun  int
+	server   string // DNS server address, empty for the system resolver
+}
+
+func (h *HealthCheck) record(ok bool, latency time.Duration) {
+	h.Checks++
+	if !ok {
+		h.Failures++
+		h.failRun++
+		return
+	}
+	h.failRun = 0
+	h.Total += latency
+	if latency > h.Max {
+		h.Max = latency
+	}
+}
+
+var pingTime = regexp.MustCompile(`time[=<]([0-9.]+) ?ms`)
+
+// Pinging the gateway once with the system ping, returning the round trip time
+func pingOnce(host string) (time.Duration, bool) {
+	args := []string{"-c", "1", "-W", "1", host}
+	if runtime.GOOS == "windows" {
+		args = []string{"-n", "1", "-w", "1000", host}
+	}
+	out, err := exec.Command("ping", args...).Output()
+	if err != nil {
+		return 0, false
+	}
+	m := pingTime.FindStringSubmatch(string(out))
+	if m == nil {
+		return 0, false
+	}
+	ms, _ := strconv.ParseFloat(m[1], 64)
+	return time.Duration(ms * float64(time.Millisecond)), true
+}
+
+// Timing a lookup against one DNS server, or the system resolver when server is empty
+func resolveOnce(ctx context.Context, server, name string) (time.Duration, bool) {
+	r := net.DefaultResolver
+	if server != "" {
+		r = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				d := net.Dialer{Timeout: 2 * time.Second}
+				return d.DialContext(ctx, network, net.JoinHostPort(server, "53"))
+			},
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	_, err := r.LookupHost(ctx, name)
+	return time.Since(start), err == nil
+}
+
+func defaultGateways() []string {
+	var gateways []string
+	switch runtime.GOOS {
+	case "linux":
+		gateways, _ = linux.GetDefaultGateways()
+	case "windows":
+		gateways, _ = iphlpapi.GetDefaultGateways()
+	}
+	return gateways
+}
+
+// Checking gateway reachability and DNS latency on an interval
+func monitorInfrastructure(ctx context.Context, data *MonitoringData, interval time.Duration, lookupName string) {
+	var checks []*HealthCheck
+	for _, gw := range defaultGateways() {
+		checks = append(checks, &HealthCheck{Name: "gateway", Target: gw})
+	}
+	servers, _ := linux.GetNameservers()
+	if runtime.GOOS != "linux" || len(servers) == 0 {
+		servers = []string{""}
+	}
+	for _, s := range servers {
+		target := s
+		if target == "" {
+			target = "system resolver"
+		}
+		checks = append(checks, &HealthCheck{Name: "dns", Target: target, server: s})
+	}
+
+	data.mu.Lock()
+	data.infraChecks = checks
+	data.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, c := range checks {
+				var latency time.Duration
+				var ok bool
+				if c.Name == "gateway" {
+					latency, ok = pingOnce(c.Target)
+				} else {
+					latency, ok = resolveOnce(ctx, c.server, lookupName)
+				}
+				if ctx.Err() != nil {
+					return
+				}
+
+				data.mu.Lock()
+				wasFailing := c.failRun >= 3
+				c.record(ok, latency)
+				// Three misses in a row rules out a single lost probe
+				if c.failRun == 3 {
+					data.raiseAlert(c.Name, c.Target, fmt.Sprintf("%s %s failed 3 consecutive checks", c.Name, c.Target), now)
+				}
+				if ok && wasFailing {
+					data.resolveAlert(c.Name, c.Target, fmt.Sprintf("%s %s is answering again", c.Name, c.Target), now)
+				}
+				data.mu.Unlock()
+			}
+		}
+	}
+}
+
+func printInfrastructureReport(checks []*HealthCheck) {
+	if len(checks) == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("INFRASTRUCTURE HEALTH")
+	for _, c := range checks {
+		if c.Checks == 0 {
+			continue
+		}
+		fmt.Printf("%s %s: %d checks, %d failed (%.1f%%)", c.Name, c.Target, c.Checks, c.Failures, float64(c.Failures)/float64(c.Checks)*100)
+		if ok := c.Checks - c.Failures; ok > 0 {
+			fmt.Printf(" | avg %s, max %s", (c.Total / time.Duration(ok)).Round(100*time.Microsecond), c.Max.Round(100*time.Microsecond))
+		}
+		fmt.Println()
+	}
+}
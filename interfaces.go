@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// ifaceList is -i, repeatable and comma separated
+type ifaceList []string
+
+func (l *ifaceList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *ifaceList) Set(v string) error {
+	for _, name := range strings.Split(v, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			*l = append(*l, name)
+		}
+	}
+	return nil
+}
+
+// CaptureInterface is one of several -i interfaces with its own packet
+// series. Hosts, trackers and alerts stay shared, the aggregate buckets of
+// MonitoringData are the sum of every interface less the duplicates -dedup drops.
+type CaptureInterface struct {
+	Name           string  `json:"name"`
+	Packets        []int   `json:"packets"`
+	Bytes          []int64 `json:"bytes"` // captured frame lengths
+	CurrentPackets int     `json:"current_packets"`
+	CurrentBytes   int64   `json:"current_bytes"`
+}
+
+// ReportInterface is the packet series of one capture interface
+type ReportInterface struct {
+	Name         string                  `json:"name"`
+	Buckets      []ReportInterfaceBucket `json:"buckets"`
+	TotalPackets int                     `json:"total_packets"`
+	TotalBytes   int64                   `json:"total_captured_bytes"`
+}
+
+// ReportInterfaceBucket is one bucket of an interface series
+type ReportInterfaceBucket struct {
+	Start         time.Time `json:"start"`
+	Packets       int       `json:"packets"`
+	CapturedBytes int64     `json:"captured_bytes"`
+}
+
+// Setting up one series per interface, only when there are several
+func (data *MonitoringData) setInterfaces(names []string) {
+	data.captureInterface = strings.Join(names, ",")
+	data.interfaces = nil
+	if len(names) < 2 {
+		return
+	}
+	for _, name := range names {
+		data.interfaces = append(data.interfaces, &CaptureInterface{Name: name})
+	}
+}
+
+// The first -i interface, for the settings that name a single device
+func (data *MonitoringData) primaryInterface() string {
+	name, _, _ := strings.Cut(data.captureInterface, ",")
+	return name
+}
+
+// Counting a packet on the interface it was captured on. Copies seen on
+// several interfaces count on each. Callers hold data.mu.
+func (data *MonitoringData) countInterface(pkt *netwatch.Packet) {
+	for _, c := range data.interfaces {
+		if c.Name == pkt.Interface {
+			c.CurrentPackets++
+			c.CurrentBytes += int64(pkt.Length)
+			return
+		}
+	}
+}
+
+// Closing the current bucket of every interface. Callers hold data.mu.
+func (data *MonitoringData) rotateInterfaces() {
+	for _, c := range data.interfaces {
+		c.Packets = append(c.Packets, c.CurrentPackets)
+		c.Bytes = append(c.Bytes, c.CurrentBytes)
+		c.CurrentPackets, c.CurrentBytes = 0, 0
+	}
+}
+
+func (data *MonitoringData) resetInterfaces() {
+	for _, c := range data.interfaces {
+		c.Packets, c.Bytes = nil, nil
+	}
+}
+
+// Taking over the series of the same interfaces from a checkpoint
+func (data *MonitoringData) resumeInterfaces(saved []*CaptureInterface) {
+	for _, c := range data.interfaces {
+		for _, s := range saved {
+			if s.Name == c.Name {
+				*c = *s
+			}
+		}
+	}
+}
+
+// Per-interface series of the closed buckets. Callers hold data.mu.
+func reportInterfaces(data *MonitoringData) []ReportInterface {
+	var out []ReportInterface
+	for _, c := range data.interfaces {
+		r := ReportInterface{Name: c.Name, Buckets: []ReportInterfaceBucket{}}
+		for i, packets := range c.Packets {
+			b := ReportInterfaceBucket{Start: data.startTime.Add(time.Duration(i) * data.bucket), Packets: packets}
+			if i < len(c.Bytes) {
+				b.CapturedBytes = c.Bytes[i]
+			}
+			r.Buckets = append(r.Buckets, b)
+			r.TotalPackets += b.Packets
+			r.TotalBytes += b.CapturedBytes
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func printInterfaceReport(interfaces []ReportInterface, bucket time.Duration) {
+	if len(interfaces) == 0 {
+		return
+	}
+	total := 0
+	for _, r := range interfaces {
+		total += r.TotalPackets
+	}
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("PER-INTERFACE TRAFFIC")
+	for _, r := range interfaces {
+		share := 0.0
+		if total > 0 {
+			share = 100 * float64(r.TotalPackets) / float64(total)
+		}
+		fmt.Printf("%s: %d packets (%.1f%%) | %.2f MB captured\n", r.Name, r.TotalPackets, share, float64(r.TotalBytes)/(1024*1024))
+		for i, b := range r.Buckets {
+			fmt.Printf("  %s: %d packets | %.2f MB\n", bucketLabel(i, bucket), b.Packets, float64(b.CapturedBytes)/(1024*1024))
+		}
+	}
+}
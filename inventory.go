@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// Host is one endpoint observed during the capture window
+type Host struct {
+	IP        string    `json:"ip"`
+	MAC       string    `json:"mac,omitempty"`
+	Vendor    string    `json:"vendor,omitempty"`
+	OSGuess   string    `json:"os_guess,omitempty"`
+	Hostnames []string  `json:"hostnames,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Packets   int       `json:"packets"`
+	Bytes     int64     `json:"bytes"`
+	ttl       int
+}
+
+// Inventory is a passive asset list built from captured packets.
+// It is guarded by the MonitoringData mutex.
+type Inventory struct {
+	hosts    map[string]*Host
+	macNames map[string]string
+	dnsNames map[string]string
+	// The report period and each host's packets and bytes when it started
+	since time.Time
+	base  map[string][2]int64
+}
+
+func NewInventory() *Inventory {
+	return &Inventory{
+		hosts:    make(map[string]*Host),
+		macNames: make(map[string]string),
+		dnsNames: make(map[string]string),
+	}
+}
+
+func (inv *Inventory) Observe(p *netwatch.Packet) {
+	if p.SrcIP != "" {
+		h := inv.host(p.SrcIP, p.Time)
+		h.Packets++
+		h.Bytes += int64(p.Length)
+		// MAC addresses only identify hosts on the local segment
+		if isLocalIP(p.SrcIP) && p.SrcMAC != "" {
+			h.MAC = p.SrcMAC
+			if p.SrcVendor != "" {
+				h.Vendor = p.SrcVendor
+			}
+		}
+		if p.TTL > 0 && p.TTL > h.ttl {
+			h.ttl = p.TTL
+			h.OSGuess = guessOS(p.TTL)
+		}
+		if p.Hostname != "" {
+			h.addHostname(p.Hostname)
+		}
+	}
+	if p.DstIP != "" {
+		h := inv.host(p.DstIP, p.Time)
+		h.Bytes += int64(p.Length)
+	}
+
+	// DHCP requests are often sent before the client has an address
+	if p.Hostname != "" && p.SrcMAC != "" {
+		inv.macNames[p.SrcMAC] = p.Hostname
+	}
+
+	// DNS answers name hosts we may only talk to later
+	if p.DNSName != "" {
+		for _, addr := range p.DNSAddrs {
+			inv.dnsNames[addr] = p.DNSName
+		}
+	}
+}
+
+func (inv *Inventory) host(ip string, seen time.Time) *Host {
+	h, ok := inv.hosts[ip]
+	if !ok {
+		h = &Host{IP: ip, FirstSeen: seen}
+		inv.hosts[ip] = h
+	}
+	if seen.Before(h.FirstSeen) {
+		h.FirstSeen = seen
+	}
+	if seen.After(h.LastSeen) {
+		h.LastSeen = seen
+	}
+	return h
+}
+
+func (h *Host) addHostname(name string) {
+	for _, n := range h.Hostnames {
+		if strings.EqualFold(n, name) {
+			return
+		}
+	}
+	h.Hostnames = append(h.Hostnames, name)
+}
+
+// DescribeMAC names a local host by its MAC, as "hostname (ip)" when known
+func (inv *Inventory) DescribeMAC(mac string) string {
+	name := inv.macNames[mac]
+	for _, h := range inv.hosts {
+		if !strings.EqualFold(h.MAC, mac) {
+			continue
+		}
+		if name == "" && len(h.Hostnames) > 0 {
+			name = h.Hostnames[0]
+		}
+		if name == "" {
+			return h.IP
+		}
+		return name + " (" + h.IP + ")"
+	}
+	return name
+}
+
+// Len returns the number of hosts seen so far
+func (inv *Inventory) Len() int {
+	return len(inv.hosts)
+}
+
+// Hosts returns a copy of the inventory sorted by total bytes
+func (inv *Inventory) Hosts() []Host {
+	hosts := make([]Host, 0, len(inv.hosts))
+	for _, h := range inv.hosts {
+		c := *h
+		c.Hostnames = append([]string(nil), h.Hostnames...)
+		if name, ok := inv.macNames[h.MAC]; ok && h.MAC != "" {
+			c.addHostname(name)
+		}
+		if name, ok := inv.dnsNames[h.IP]; ok {
+			c.addHostname(name)
+		}
+		hosts = append(hosts, c)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if hosts[i].Bytes != hosts[j].Bytes {
+			return hosts[i].Bytes > hosts[j].Bytes
+		}
+		return hosts[i].IP < hosts[j].IP
+	})
+	return hosts
+}
+
+// StartPeriod starts counting PeriodHosts anew at start
+func (inv *Inventory) StartPeriod(start time.Time) {
+	inv.since = start
+	inv.base = make(map[string][2]int64, len(inv.hosts))
+	for ip, h := range inv.hosts {
+		inv.base[ip] = [2]int64{int64(h.Packets), h.Bytes}
+	}
+}
+
+// PeriodHosts returns the hosts seen in the current report period with the
+// packets and bytes of the period, sorted by bytes
+func (inv *Inventory) PeriodHosts() []Host {
+	var hosts []Host
+	for _, h := range inv.Hosts() {
+		if h.LastSeen.Before(inv.since) {
+			continue
+		}
+		b := inv.base[h.IP]
+		h.Packets -= int(b[0])
+		h.Bytes -= b[1]
+		hosts = append(hosts, h)
+	}
+	sort.SliceStable(hosts, func(i, j int) bool { return hosts[i].Bytes > hosts[j].Bytes })
+	return hosts
+}
+
+// Guessing the sender OS from the initial TTL it most likely started with
+func guessOS(ttl int) string {
+	switch {
+	case ttl <= 32:
+		return "Windows (legacy)"
+	case ttl <= 64:
+		return "Linux/Unix/macOS"
+	case ttl <= 128:
+		return "Windows"
+	default:
+		return "Network device"
+	}
+}
+
+// Writing the inventory as JSON or CSV depending on the file extension
+func exportInventory(path string, hosts []Host) error {
+	if err := diskGuardrail.Check(path); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(hosts)
+	}
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"ip", "mac", "vendor", "os_guess", "hostnames", "first_seen", "last_seen", "packets", "bytes"})
+	for _, h := range hosts {
+		w.Write([]string{
+			h.IP,
+			h.MAC,
+			h.Vendor,
+			h.OSGuess,
+			strings.Join(h.Hostnames, ";"),
+			h.FirstSeen.Format(time.RFC3339),
+			h.LastSeen.Format(time.RFC3339),
+			strconv.Itoa(h.Packets),
+			strconv.FormatInt(h.Bytes, 10),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// Private, link-local or loopback addresses are treated as local hosts
+func isLocalIP(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	return ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLoopback()
+}
+
+// Globally routable unicast addresses are treated as internet hosts
+func isPublicIP(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
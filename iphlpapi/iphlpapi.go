@@ -0,0 +1,100 @@
+//go:build windows
+
+package iphlpapi
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	iphlpapi           = syscall.NewLazyDLL("iphlpapi.dll")
+	getTcpStatisticsEx = iphlpapi.NewProc("GetTcpStatisticsEx")
+)
+
+// Reading system wide TCP statistics for one address family
+func GetTcpStatistics(family uint32) (*MIB_TCPSTATS, error) {
+	var stats MIB_TCPSTATS
+	ret, _, _ := getTcpStatisticsEx.Call(
+		uintptr(unsafe.Pointer(&stats)),
+		uintptr(family),
+	)
+	if ret != 0 {
+		return nil, fmt.Errorf("GetTcpStatisticsEx failed with code 0x%X", ret)
+	}
+	return &stats, nil
+}
+
+var getIpForwardTable = iphlpapi.NewProc("GetIpForwardTable")
+
+const ERROR_INSUFFICIENT_BUFFER = 122
+
+// Reading the IPv4 routing table
+func GetIpForwardTable() ([]MIB_IPFORWARDROW, error) {
+	var size uint32
+	ret, _, _ := getIpForwardTable.Call(0, uintptr(unsafe.Pointer(&size)), 0)
+	if ret != ERROR_INSUFFICIENT_BUFFER && ret != 0 {
+		return nil, fmt.Errorf("GetIpForwardTable failed with code 0x%X", ret)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size)
+	ret, _, _ = getIpForwardTable.Call(uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)), 1)
+	if ret != 0 {
+		return nil, fmt.Errorf("GetIpForwardTable failed with code 0x%X", ret)
+	}
+
+	// MIB_IPFORWARDTABLE is a DWORD count followed by the rows
+	count := *(*uint32)(unsafe.Pointer(&buf[0]))
+	rows := make([]MIB_IPFORWARDROW, count)
+	rowSize := unsafe.Sizeof(MIB_IPFORWARDROW{})
+	for i := range rows {
+		rows[i] = *(*MIB_IPFORWARDROW)(unsafe.Pointer(&buf[4+uintptr(i)*rowSize]))
+	}
+	return rows, nil
+}
+
+// Listing default routes with the friendly name of their interface
+func GetDefaultRoutes() ([]Route, error) {
+	rows, err := GetIpForwardTable()
+	if err != nil {
+		return nil, err
+	}
+	var routes []Route
+	for _, r := range rows {
+		if r.ForwardDest != 0 || r.ForwardMask != 0 {
+			continue
+		}
+		name := fmt.Sprintf("if%d", r.ForwardIfIndex)
+		if iface, err := net.InterfaceByIndex(int(r.ForwardIfIndex)); err == nil {
+			name = iface.Name
+		}
+		// Addresses are stored in network byte order
+		ip := (*[4]byte)(unsafe.Pointer(&r.ForwardNextHop))
+		routes = append(routes, Route{
+			Iface:   name,
+			Gateway: fmt.Sprintf("%d.%d.%d.%d", ip[0], ip[1], ip[2], ip[3]),
+			Metric:  int(r.ForwardMetric1),
+		})
+	}
+	return routes, nil
+}
+
+// Listing the next hops of all default routes
+func GetDefaultGateways() ([]string, error) {
+	routes, err := GetDefaultRoutes()
+	if err != nil {
+		return nil, err
+	}
+	var gateways []string
+	for _, r := range routes {
+		if r.Gateway != "0.0.0.0" {
+			gateways = append(gateways, r.Gateway)
+		}
+	}
+	return gateways, nil
+}
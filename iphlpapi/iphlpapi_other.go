@@ -0,0 +1,23 @@
+//go:build !windows
+
+package iphlpapi
+
+import "errors"
+
+var errNotWindows = errors.New("iphlpapi is only available on Windows")
+
+func GetTcpStatistics(family uint32) (*MIB_TCPSTATS, error) {
+	return nil, errNotWindows
+}
+
+func GetIpForwardTable() ([]MIB_IPFORWARDROW, error) {
+	return nil, errNotWindows
+}
+
+func GetDefaultRoutes() ([]Route, error) {
+	return nil, errNotWindows
+}
+
+func GetDefaultGateways() ([]string, error) {
+	return nil, errNotWindows
+}
@@ -0,0 +1,47 @@
+package iphlpapi
+
+const (
+	AF_INET  = 2
+	AF_INET6 = 23
+)
+
+type MIB_TCPSTATS struct {
+	RtoAlgorithm uint32
+	RtoMin       uint32
+	RtoMax       uint32
+	MaxConn      uint32
+	ActiveOpens  uint32
+	PassiveOpens uint32
+	AttemptFails uint32
+	EstabResets  uint32
+	CurrEstab    uint32
+	InSegs       uint32
+	OutSegs      uint32
+	RetransSegs  uint32
+	InErrs       uint32
+	OutRsts      uint32
+	NumConns     uint32
+}
+
+type MIB_IPFORWARDROW struct {
+	ForwardDest      uint32
+	ForwardMask      uint32
+	ForwardPolicy    uint32
+	ForwardNextHop   uint32
+	ForwardIfIndex   uint32
+	ForwardType      uint32
+	ForwardProto     uint32
+	ForwardAge       uint32
+	ForwardNextHopAS uint32
+	ForwardMetric1   uint32
+	ForwardMetric2   uint32
+	ForwardMetric3   uint32
+	ForwardMetric4   uint32
+	ForwardMetric5   uint32
+}
+
+type Route struct {
+	Iface   string
+	Gateway string
+	Metric  int
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const journalSocket = "/run/systemd/journal/socket"
+
+// Message IDs, documented in netwatchd.catalog
+const (
+	journalStarted  = "0711d6c4a88146039bcafe0f3efd04b2"
+	journalBucket   = "1487e3dfe4234283a995652132f20530"
+	journalAlert    = "0d48ab83b09a47868eac0b567198f7e3"
+	journalResolved = "ecfb6c4ad8ac48c786272ec8d2d25139"
+)
+
+//go:embed netwatchd.catalog
+var journalCatalog []byte
+
+// Syslog priorities of the alert severities
+var journalPriority = map[string]int{"info": 6, "warning": 4, "critical": 2}
+
+// Journal writes structured entries over the native journald protocol.
+// A nil Journal drops everything.
+type Journal struct {
+	conn   *net.UnixConn
+	common map[string]string
+}
+
+// Connecting to journald. Every entry carries the interface and the labels as LABEL_<KEY>.
+func OpenJournal(iface string, labels Labels) (*Journal, error) {
+	if runtime.GOOS != "linux" {
+		return nil, errors.New("the systemd journal is only available on Linux")
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to journald: %v", err)
+	}
+	j := &Journal{conn: conn, common: map[string]string{"SYSLOG_IDENTIFIER": "netwatchd", "INTERFACE": iface}}
+	for k, v := range labels {
+		if name := journalField("LABEL_" + k); name != "" {
+			j.common[name] = v
+		}
+	}
+	return j, nil
+}
+
+// Field names are upper case letters, digits and underscores, not starting with an underscore
+func journalField(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+	name = strings.TrimLeft(name, "_0123456789")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// Sending one entry. Values with newlines use the length prefixed form of the protocol.
+func (j *Journal) Send(priority int, messageID, message string, fields map[string]string) error {
+	if j == nil {
+		return nil
+	}
+	all := map[string]string{"PRIORITY": strconv.Itoa(priority), "MESSAGE": message}
+	if messageID != "" {
+		all["MESSAGE_ID"] = messageID
+	}
+	for k, v := range j.common {
+		all[k] = v
+	}
+	for k, v := range fields {
+		all[k] = v
+	}
+	names := make([]string, 0, len(all))
+	for k := range all {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, k := range names {
+		v := all[k]
+		if strings.Contains(v, "\n") {
+			buf.WriteString(k + "\n")
+			binary.Write(&buf, binary.LittleEndian, uint64(len(v)))
+			buf.WriteString(v + "\n")
+		} else {
+			buf.WriteString(k + "=" + v + "\n")
+		}
+	}
+	_, err := j.conn.Write(buf.Bytes())
+	return err
+}
+
+func (j *Journal) Started(filter string) {
+	j.Send(6, journalStarted, "Capturing on "+j.iface(), map[string]string{"FILTER": filter})
+}
+
+// Logging a closed bucket with its average packet rate
+func (j *Journal) Bucket(start time.Time, seconds float64, packets int, bytes float64) {
+	if j == nil || seconds <= 0 {
+		return
+	}
+	pps := float64(packets) / seconds
+	j.Send(6, journalBucket, fmt.Sprintf("%s: %d packets, %.2f MB, %.1f packets/s", j.iface(), packets, bytes/(1024*1024), pps), map[string]string{
+		"BUCKET_START":   start.UTC().Format(time.RFC3339),
+		"BUCKET_SECONDS": strconv.FormatFloat(seconds, 'f', 0, 64),
+		"PACKETS":        strconv.Itoa(packets),
+		"BYTES":          strconv.FormatFloat(bytes, 'f', 0, 64),
+		"PPS":            strconv.FormatFloat(pps, 'f', 1, 64),
+	})
+}
+
+func (j *Journal) Alert(a Alert) {
+	if j == nil {
+		return
+	}
+	fields := map[string]string{"ALERT": a.Kind, "ALERT_KEY": a.Key, "SEVERITY": a.Severity}
+	if a.Silenced != "" {
+		fields["SILENCED"] = a.Silenced
+	}
+	id, priority := journalAlert, journalPriority[a.Severity]
+	if a.Resolved {
+		id, priority = journalResolved, 5
+	}
+	if a.Silenced != "" && !a.Resolved {
+		priority = 6
+	}
+	j.Send(priority, id, a.Message, fields)
+}
+
+func (j *Journal) iface() string {
+	return j.common["INTERFACE"]
+}
+
+func (j *Journal) Close() {
+	if j != nil {
+		j.conn.Close()
+	}
+}
+
+// netwatchd journal install|catalog
+func runJournalCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Println("Usage: netwatchd journal install | catalog")
+		return 2
+	}
+	switch args[0] {
+	case "catalog":
+		os.Stdout.Write(journalCatalog)
+		return 0
+	case "install":
+		const path = "/usr/lib/systemd/catalog/netwatchd.catalog"
+		if err := os.WriteFile(path, journalCatalog, 0644); err != nil {
+			fmt.Printf("Failed to write %s: %v\n", path, err)
+			return 1
+		}
+		if out, err := exec.Command("journalctl", "--update-catalog").CombinedOutput(); err != nil {
+			fmt.Printf("journalctl --update-catalog failed: %v\n%s", err, out)
+			return 1
+		}
+		fmt.Printf("Catalog installed to %s\n", path)
+		return 0
+	}
+	fmt.Printf("Unknown journal command %q\n", args[0])
+	return 2
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sort orders for the live talker table, cycled with 's'
+var talkerSorts = []string{"bytes", "packets", "last seen"}
+
+const keyboardHelp = "Keys: p pause/resume | e toggle packet echo | t toggle talker table | s change sort | q quit with report"
+
+// Reading single key presses from the terminal until the capture ends.
+// Returns a func restoring the terminal, or nil when stdin isn't a terminal.
+func startKeyboard(ctx context.Context, cancel context.CancelFunc, data *MonitoringData) func() {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	restore, err := makeRaw()
+	if err != nil {
+		return nil
+	}
+	fmt.Println(keyboardHelp)
+
+	// The blocking read can't be interrupted, so this goroutine isn't waited on
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if n, err := os.Stdin.Read(buf); err != nil || n == 0 {
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			handleKey(buf[0], cancel, data)
+		}
+	}()
+
+	go showTalkers(ctx, data)
+
+	return restore
+}
+
+func handleKey(key byte, cancel context.CancelFunc, data *MonitoringData) {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	switch key {
+	case 'p', 'P', ' ':
+		data.setPaused(!data.paused, time.Now())
+	case 'e', 'E':
+		data.quietPackets = !data.quietPackets
+	case 't', 'T':
+		data.showTalkers = !data.showTalkers
+	case 's', 'S':
+		data.talkerSort = (data.talkerSort + 1) % len(talkerSorts)
+		fmt.Printf("-- talkers sorted by %s --\n", talkerSorts[data.talkerSort])
+	case 'q', 'Q':
+		fmt.Println("-- stopping --")
+		cancel()
+	case 'h', 'H', '?':
+		fmt.Println(keyboardHelp)
+	}
+}
+
+// Printing the live top talker table every few seconds while enabled
+func showTalkers(ctx context.Context, data *MonitoringData) {
+	ticker := time.NewTicker(3 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			data.mu.Lock()
+			if data.showTalkers {
+				printTalkerTable(data.inventory.Hosts(), data.talkerSort, 10)
+			}
+			data.mu.Unlock()
+		}
+	}
+}
+
+func printTalkerTable(hosts []Host, order, limit int) {
+	switch talkerSorts[order] {
+	case "packets":
+		sort.SliceStable(hosts, func(i, j int) bool { return hosts[i].Packets > hosts[j].Packets })
+	case "last seen":
+		sort.SliceStable(hosts, func(i, j int) bool { return hosts[i].LastSeen.After(hosts[j].LastSeen) })
+	}
+	if len(hosts) > limit {
+		hosts = hosts[:limit]
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Printf("TOP TALKERS (by %s)\n", talkerSorts[order])
+	for _, h := range hosts {
+		name := ""
+		if len(h.Hostnames) > 0 {
+			name = h.Hostnames[0]
+		}
+		fmt.Printf("%-40s %8d pkts %10.2f MB  %s  %s\n", h.IP, h.Packets, float64(h.Bytes)/(1024*1024), h.LastSeen.Format("15:04:05"), name)
+	}
+	fmt.Println(strings.Repeat("-", 60))
+}
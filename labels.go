@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Labels are key=value tags attached to every output of a session
+type Labels map[string]string
+
+var labelKeyRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Keys follow Prometheus label rules so they can be used anywhere
+func validLabelKey(k string) error {
+	if !labelKeyRe.MatchString(k) {
+		return fmt.Errorf("label key %q must be letters, digits and underscores", k)
+	}
+	return nil
+}
+
+// labelList collects repeated -label flags
+type labelList Labels
+
+func (l *labelList) String() string {
+	return Labels(*l).String()
+}
+
+func (l *labelList) Set(v string) error {
+	k, val, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("label %q must be key=value", v)
+	}
+	if err := validLabelKey(k); err != nil {
+		return err
+	}
+	if *l == nil {
+		*l = make(labelList)
+	}
+	(*l)[k] = val
+	return nil
+}
+
+// Decoding the labels of a config file, where rack: 7 or rack = 7 is the
+// label rack=7
+func (l *Labels) UnmarshalJSON(b []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return errors.New("labels must be a map of keys to values")
+	}
+	if raw == nil {
+		*l = nil
+		return nil
+	}
+	labels := make(Labels, len(raw))
+	for k, v := range raw {
+		var value any
+		if err := json.Unmarshal(v, &value); err != nil {
+			return err
+		}
+		switch x := value.(type) {
+		case string:
+			labels[k] = x
+		case float64, bool:
+			labels[k] = string(v)
+		default:
+			return fmt.Errorf("label %q must be a string, number or boolean", k)
+		}
+	}
+	*l = labels
+	return nil
+}
+
+// String renders labels sorted by key as k=v pairs
+func (l Labels) String() string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+l[k])
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+const maxSampleEvery = 1024
+
+// resourceLimits keeps netwatchd from competing with the workloads it watches.
+// Packets are always counted, but over a limit only every sampleEvery-th one
+// goes through the trackers, and the rate backs off until usage recovers.
+type resourceLimits struct {
+	maxCPU      float64 // percent of one core
+	maxMemory   int64
+	sampleEvery int
+	seen        int
+	Skipped     int
+}
+
+func newResourceLimits(maxCPU float64, maxMemory int64) *resourceLimits {
+	if maxMemory > 0 {
+		// Makes the GC work harder before the limit is reached
+		debug.SetMemoryLimit(maxMemory)
+	}
+	return &resourceLimits{maxCPU: maxCPU, maxMemory: maxMemory, sampleEvery: 1}
+}
+
+// Analyze reports whether this packet should be fed to the trackers. Callers hold data.mu.
+func (l *resourceLimits) Analyze() bool {
+	if l == nil || l.sampleEvery <= 1 {
+		return true
+	}
+	l.seen++
+	if l.seen%l.sampleEvery == 0 {
+		return true
+	}
+	l.Skipped++
+	return false
+}
+
+// Memory the process holds from the OS, not counting what the GC handed back
+func processMemory() int64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return int64(m.Sys - m.HeapReleased)
+}
+
+func enforceLimits(ctx context.Context, data *MonitoringData, l *resourceLimits) {
+	const every = 2 * time.Second
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	lastCPU, lastWall := processCPUTime(), time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			cpu := processCPUTime()
+			percent := float64(cpu-lastCPU) / float64(now.Sub(lastWall)) * 100
+			lastCPU, lastWall = cpu, now
+			mem := processMemory()
+
+			var reason string
+			if l.maxCPU > 0 && percent > l.maxCPU {
+				reason = fmt.Sprintf("CPU %.0f%% over %.0f%%", percent, l.maxCPU)
+			} else if l.maxMemory > 0 && mem > l.maxMemory {
+				reason = fmt.Sprintf("memory %.1f MB over %.1f MB", float64(mem)/(1024*1024), float64(l.maxMemory)/(1024*1024))
+			}
+			// Only speed back up with some headroom, otherwise the rate flaps
+			relaxed := (l.maxCPU <= 0 || percent < l.maxCPU*0.8) && (l.maxMemory <= 0 || float64(mem) < float64(l.maxMemory)*0.8)
+
+			data.mu.Lock()
+			switch {
+			case reason != "" && l.sampleEvery < maxSampleEvery:
+				l.sampleEvery *= 2
+				fmt.Printf("Resource limit: %s, analyzing 1 in %d packets\n", reason, l.sampleEvery)
+			case relaxed && l.sampleEvery > 1:
+				l.sampleEvery /= 2
+				if l.sampleEvery == 1 {
+					fmt.Println("Back under resource limits, analyzing every packet")
+				} else {
+					fmt.Printf("Resource usage down, analyzing 1 in %d packets\n", l.sampleEvery)
+				}
+			}
+			data.mu.Unlock()
+		}
+	}
+}
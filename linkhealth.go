@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	linux "netwatchd/netstat"
+	"netwatchd/pdh"
+)
+
+// LinkHealth is the capture adapter's packet, error and discard counts over
+// the report period, as the OS counts them
+type LinkHealth struct {
+	Adapter          string  `json:"adapter"`
+	PacketsSent      uint64  `json:"packets_sent"`
+	PacketsReceived  uint64  `json:"packets_received"`
+	ReceiveErrors    uint64  `json:"receive_errors"`
+	OutboundErrors   uint64  `json:"outbound_errors"`
+	ReceiveDiscards  uint64  `json:"receive_discards"`
+	OutboundDiscards uint64  `json:"outbound_discards"`
+	UnknownProtocol  uint64  `json:"unknown_protocol"`            // received for a protocol the host does not run
+	PeakOutputQueue  float64 `json:"peak_output_queue,omitempty"` // packets
+	Samples          int     `json:"samples"`                     // one a second
+}
+
+const (
+	linkRate  = iota // per second, summed over the samples
+	linkCount        // raw count, the period is the growth
+	linkGauge        // level, the period keeps the peak
+)
+
+var linkCounterKinds = []struct {
+	name string
+	kind int
+}{
+	{pdh.PacketsSent, linkRate},
+	{pdh.PacketsReceived, linkRate},
+	{pdh.PacketsReceivedErrors, linkCount},
+	{pdh.PacketsOutboundErrors, linkCount},
+	{pdh.PacketsReceivedDiscarded, linkCount},
+	{pdh.PacketsOutboundDiscarded, linkCount},
+	{pdh.PacketsReceivedUnknown, linkCount},
+	{pdh.OutputQueueLength, linkGauge},
+}
+
+type linkCounter struct {
+	name    string
+	kind    int
+	counter byteCounter // nil when read from a /proc/net/dev snapshot
+	base    float64     // count at the start of the period
+	last    float64
+	total   float64 // summed rate or peak level
+	primed  bool
+}
+
+// LinkHealthMonitor reads the adapter's error, discard and queue counters
+// next to the byte counters monitorBandwidth samples, from PDH on Windows
+// and from one /proc/net/dev read a second on Linux. Samples and resets are
+// guarded by the MonitoringData mutex.
+type LinkHealthMonitor struct {
+	adapter  string
+	counters []*linkCounter
+	snapshot bool // Linux, every counter comes from one InterfaceSnapshot
+	samples  int
+}
+
+// Adding the link counters the adapter has, nil when it has none
+func newLinkHealthMonitor(stats statsProvider, adapter string) *LinkHealthMonitor {
+	m := &LinkHealthMonitor{adapter: adapter}
+	switch stats.(type) {
+	case *pdhProvider:
+	case netstatProvider:
+		// The kernel keeps counts, packets included, and has no queue length here
+		s, err := linux.GetInterfaceSnapshot(adapter)
+		if err != nil {
+			logger.Debug("no link health counters", "adapter", adapter, "err", err)
+			return nil
+		}
+		m.snapshot = true
+		for _, k := range linkCounterKinds {
+			if _, ok := s.Value(k.name); ok {
+				m.counters = append(m.counters, &linkCounter{name: k.name, kind: linkCount})
+			}
+		}
+		return m
+	default:
+		return nil
+	}
+	for _, k := range linkCounterKinds {
+		c, err := stats.NewCounter(adapter, k.name)
+		if err != nil {
+			// Hyper-V adapter objects name their counters differently
+			logger.Debug("no link health counter", "adapter", adapter, "counter", k.name, "err", err)
+			continue
+		}
+		m.counters = append(m.counters, &linkCounter{name: k.name, kind: k.kind, counter: c})
+	}
+	if len(m.counters) == 0 {
+		return nil
+	}
+	return m
+}
+
+func (m *LinkHealthMonitor) close() {
+	if m == nil {
+		return
+	}
+	for _, c := range m.counters {
+		if c.counter != nil {
+			c.counter.Close()
+		}
+	}
+}
+
+// Reading the counters after CollectData and adding them to the period
+func (m *LinkHealthMonitor) sample(data *MonitoringData) {
+	if m == nil {
+		return
+	}
+	values := make([]float64, len(m.counters))
+	ok := make([]bool, len(m.counters))
+	var snap *linux.InterfaceSnapshot
+	if m.snapshot {
+		var err error
+		if snap, err = linux.GetInterfaceSnapshot(m.adapter); err != nil {
+			logger.Debug("link health snapshot failed", "adapter", m.adapter, "err", err)
+			return
+		}
+	}
+	for i, c := range m.counters {
+		if snap != nil {
+			n, _ := snap.Value(c.name)
+			values[i], ok[i] = float64(n), true
+			continue
+		}
+		v, err := c.counter.GetValue()
+		if err != nil {
+			logger.Debug("link health counter read failed", "adapter", m.adapter, "counter", c.name, "err", err)
+			continue
+		}
+		values[i], ok[i] = v, true
+	}
+
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	m.samples++
+	for i, c := range m.counters {
+		if !ok[i] {
+			continue
+		}
+		v := values[i]
+		switch c.kind {
+		case linkRate:
+			c.total += v
+		case linkGauge:
+			if v > c.total {
+				c.total = v
+			}
+		case linkCount:
+			if !c.primed {
+				c.base, c.primed = v, true
+			} else if v < c.last {
+				// The 32 bit PDH counts wrap, and counts restart with the adapter
+				c.base -= c.last
+			}
+			c.last = v
+			c.total = v - c.base
+		}
+	}
+}
+
+// Starting a new period from the current counts
+func (m *LinkHealthMonitor) reset() {
+	if m == nil {
+		return
+	}
+	m.samples = 0
+	for _, c := range m.counters {
+		c.base, c.total = c.last, 0
+	}
+}
+
+func (m *LinkHealthMonitor) Health() *LinkHealth {
+	if m == nil || m.samples == 0 {
+		return nil
+	}
+	h := &LinkHealth{Adapter: m.adapter, Samples: m.samples}
+	for _, c := range m.counters {
+		n := uint64(c.total + 0.5)
+		switch c.name {
+		case pdh.PacketsSent:
+			h.PacketsSent = n
+		case pdh.PacketsReceived:
+			h.PacketsReceived = n
+		case pdh.PacketsReceivedErrors:
+			h.ReceiveErrors = n
+		case pdh.PacketsOutboundErrors:
+			h.OutboundErrors = n
+		case pdh.PacketsReceivedDiscarded:
+			h.ReceiveDiscards = n
+		case pdh.PacketsOutboundDiscarded:
+			h.OutboundDiscards = n
+		case pdh.PacketsReceivedUnknown:
+			h.UnknownProtocol = n
+		case pdh.OutputQueueLength:
+			h.PeakOutputQueue = c.total
+		}
+	}
+	return h
+}
+
+// Share of packets in one direction, for the warnings
+func linkShare(n, packets uint64) string {
+	return fmt.Sprintf(" (%.3f%% of packets)", 100*float64(n)/float64(packets+n))
+}
+
+func printLinkHealthReport(m *LinkHealthMonitor) {
+	h := m.Health()
+	if h == nil {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("LINK HEALTH")
+	fmt.Printf("Adapter: %s\n", h.Adapter)
+	fmt.Printf("Packets: %d sent | %d received\n", h.PacketsSent, h.PacketsReceived)
+	fmt.Printf("Receive: %d errors | %d discarded | %d unknown protocol\n", h.ReceiveErrors, h.ReceiveDiscards, h.UnknownProtocol)
+	fmt.Printf("Outbound: %d errors | %d discarded\n", h.OutboundErrors, h.OutboundDiscards)
+	if h.PeakOutputQueue > 0 {
+		fmt.Printf("Peak output queue: %.0f packets\n", h.PeakOutputQueue)
+	}
+
+	if h.ReceiveErrors > 0 {
+		fmt.Printf("WARNING: %d packets received with errors%s, check the cable, duplex and the NIC driver\n", h.ReceiveErrors, linkShare(h.ReceiveErrors, h.PacketsReceived))
+	}
+	if h.OutboundErrors > 0 {
+		fmt.Printf("WARNING: %d packets failed to send%s\n", h.OutboundErrors, linkShare(h.OutboundErrors, h.PacketsSent))
+	}
+	if h.ReceiveDiscards > 0 {
+		fmt.Printf("WARNING: %d received packets discarded%s, the host could not keep up or ran out of buffers\n", h.ReceiveDiscards, linkShare(h.ReceiveDiscards, h.PacketsReceived))
+	}
+	if h.OutboundDiscards > 0 {
+		fmt.Printf("WARNING: %d outbound packets discarded%s\n", h.OutboundDiscards, linkShare(h.OutboundDiscards, h.PacketsSent))
+	}
+	// Most drivers report 0, a standing queue means the link is saturated
+	if h.PeakOutputQueue >= 2 {
+		fmt.Printf("WARNING: output queue reached %.0f packets, the link is sending as fast as it can\n", h.PeakOutputQueue)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// logLevel is Info by default and Debug with -debug or -v
+var logLevel = new(slog.LevelVar)
+
+// logger writes diagnostics from the backends and background monitors as
+// leveled key=value lines. Packet lines and the report stay plain output.
+var logger = slog.New(slog.NewTextHandler(consoleWriter{}, &slog.HandlerOptions{Level: logLevel}))
+
+// consoleWriter follows os.Stdout, which -o json and csv point at stderr and
+// -evidence tees into its console log
+type consoleWriter struct{}
+
+func (consoleWriter) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
@@ -1,93 +1,1155 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
+	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
-	"netwatchd/pdh"
+	"netwatchd/cloudmeta"
+	"netwatchd/netwatch"
+	linux "netwatchd/netstat"
 )
 
 type MonitoringData struct {
 	mu 					sync.Mutex
-	packetBuckets		[]int 
 	bandwidthBuckets	[]float64 
-	currentPackets		int
+	sentBuckets		[]float64
+	recvBuckets		[]float64
 	currentBandwidth	float64
+	currentSent		float64
+	currentRecv		float64
 	startTime			time.Time 
-	nextBucketTime		time.Time
+	buckets				*netwatch.Buckets	// packets per bucket and the bucket schedule
+	bucket				time.Duration
+	inventory			*Inventory
+	exposure			*ExposureTracker
+	routerAdverts		*RouterAdvertTracker
+	spanningTree		*SpanningTreeTracker
+	certs				*CertTracker
+	weakProtocols		*WeakProtocolTracker
+	encryptedDNS		*EncryptedDNSTracker
+	fileServices		*FileServiceTracker
+	callQuality			*CallQualityTracker
+	sipCalls			*SIPCallTracker
+	games				*GameTracker
+	p2p					*P2PTracker
+	cloudRanges			*CloudRangeTracker
+	services			*ServiceTracker
+	updates				*UpdateTracker
+	ports				*PortTracker
+	flows				*FlowTracker	// only with -db
+	dns					*DNSTracker
+	amplification		*AmplificationDetector
+	rates				*RateTracker
+	blocker				*Blocker
+	shaper				*Shaper
+	thresholds			*ThresholdEvaluator
+	history				*weekHistory
+	digest				*digestSchedule
+	segmentation		*SegmentationAuditor
+	bonds				[]linux.BondStatus
+	bondEvents			[]BondEvent
+	nic					*NICReport
+	linkHealth			*LinkHealthMonitor
+	osHealth			[]HealthCounter
+	conntrack			*ConntrackReport
+	firewallDrops		[]linux.FirewallCounter
+	firewallChecked		bool
+	captureInterface	string
+	interfaces			[]*CaptureInterface
+	virtualPorts		[]VirtualPort
+	cloud				*cloudmeta.Instance
+	alerts				[]Alert
+	lastPacketTime		time.Time
+	outages				[]Outage
+	probeTarget			string
+	infraChecks			[]*HealthCheck
+	wanLinks			[]*WANLink
+	wanEvents			[]WANEvent
+	currentRouteChurn	int
+	routeChurnBuckets	[]int
+	routeAdds			int
+	routeDels			int
+	syntheticChecks		[]*SyntheticCheck
+	twamp				*TwampSession
+	twampReflector		*TwampReflector
+	paused				bool
+	pausedSince			time.Time
+	currentPaused		time.Duration
+	pausedBuckets		[]time.Duration
+	requestedDuration	captureDuration
+	captureFilter		string
+	perSecond			[]int
+	dedup				*Deduper
+	limits				*resourceLimits
+	labels				Labels
+	matrix				*TrafficMatrix
+	pricing				*Pricing
+	ssids				[]SSIDUsage
+	accounting			*Accounting
+	patterns			*PatternCounter
+	protocols			*ProtocolBreakdown
+	watchdog			*Watchdog
+	silencer			*Silencer
+	router				*AlertRouter
+	capturedPackets		int64
+	capturedBytes		int64
+	captureBandwidth	bool			// bucket bytes estimated from captured frame lengths
+	localAddrs			map[string]bool	// this host's addresses, telling sent from received then
+	journal				*Journal
+	periods				*periodReports
+	quietPackets		bool
+	showTalkers			bool
+	talkerSort			int
 }
 
 func main() {
-	interfaceFlag := flag.String("i", "", "Interface to capture on (leave empty to list all)")
-	durationFlag := flag.Int("d", 10, "Capture duration in seconds")
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bundle" {
+		os.Exit(runBundleCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "merge" {
+		os.Exit(runMergeCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "digest" {
+		os.Exit(runDigestCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "query" {
+		os.Exit(runQueryCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "forecast" {
+		os.Exit(runForecastCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "silence" {
+		os.Exit(runSilenceCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "self-update" {
+		os.Exit(runSelfUpdateCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "journal" {
+		os.Exit(runJournalCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "perf" {
+		os.Exit(runPerfCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "gen" {
+		os.Exit(runGenCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplayCommand(os.Args[2:]))
+	}
+
+	configFlag := flag.String("config", "", "Read settings from a JSON, YAML (.yaml, .yml) or TOML (.toml) config file (flags take precedence)")
+	var interfaceFlag ifaceList
+	flag.Var(&interfaceFlag, "i", "Interface to capture on, comma separated or repeated for several at once (leave empty to list all)")
+	durationFlag := captureDuration(10 * time.Second)
+	daemonFlag := flag.Bool("daemon", false, "Run until SIGINT or SIGTERM without packet output or keyboard controls, reporting every -period")
+	periodFlag := flag.Duration("period", 24*time.Hour, "In daemon mode, print the report and start over this often, e.g. 1h")
+	maxCPUFlag := flag.Float64("max-cpu-percent", 0, "Sample packet analysis while netwatchd uses more than this much of one CPU core, e.g. 50")
+	maxMemoryFlag := flag.String("max-memory", "", "Sample packet analysis while netwatchd holds more than this much memory, e.g. 256MB")
+	blockKindsFlag := flag.String("block-kinds", "", "Block the offending addresses of these alert kinds in the local firewall, comma separated (e.g. segmentation,amplification)")
+	blockAllowFlag := flag.String("block-allow", "", "Comma separated addresses or CIDRs that are never blocked")
+	blockTTLFlag := flag.Duration("block-ttl", time.Hour, "How long an automatic block lasts")
+	blockMaxFlag := flag.Int("block-max", 100, "Most addresses blocked at the same time")
+	blockDryRunFlag := flag.Bool("block-dry-run", false, "Only log the blocks -block-kinds would add")
+	shapeFlag := flag.String("shape", "", "Throttle traffic categories to a rate with tc (Linux) or QoS policies (Windows), comma separated category=rate (e.g. updates=20Mbps,p2p=5Mbps)")
+	shapeHoursFlag := flag.String("shape-hours", "", "Comma separated local times of day -shape applies in, e.g. 09:00-17:00, empty for the whole run")
+	shapeApplyFlag := flag.Bool("shape-apply", false, "Install the -shape rules, otherwise only print the commands")
+	recommendLimitsFlag := flag.Bool("recommend-limits", false, "Suggest nftables/tc or QoS/netsh rules for the busiest sources and ports after the run")
+	ampMinBytesFlag := flag.String("amp-min-bytes", "1MB", "Response volume per bucket and victim before UDP amplification or reflection is reported")
+	minFreeDiskFlag := flag.String("min-free-disk", "", "Pause pcap writing and skip output files while less than this is free, deleting old -daemon period reports first, e.g. 1GB")
+	userFlag := flag.String("user", "", "After the capture has started, switch to this unprivileged user (Linux, needs root). Output files must be writable by it, and with tshark its dumpcap needs capture rights for it, e.g. the wireshark group")
+	bucketFlag := flag.Duration("bucket", time.Minute, "Length of the report buckets, e.g. 10s or 5m")
+	flag.Var(&durationFlag, "d", "Capture duration, e.g. 30s, 15m or 2h (0 or inf runs until stopped)")
 	filterFlag := flag.String("f", "", "BPF filter (e.g., 'tcp port 80')")
-	enableBandwidth := flag.Bool("b", true, "Enable bandwidth monitoring (Windows only)")
+	captureFlag := flag.String("capture", "auto", "Capture backend: native (AF_PACKET or Npcap), tshark, or auto to fall back to tshark")
+	enableBandwidth := flag.Bool("b", true, "Read the adapter byte counters (Windows, Linux and macOS), otherwise bytes are estimated from the captured frame lengths")
+	journalFlag := flag.Bool("journal", false, "Also log buckets and alerts to the systemd journal with structured fields (Linux only)")
+	perfFlag := flag.Bool("perf-counters", false, "Publish packets/sec, captured bits/sec and alert counts as performance counters (Windows only, see netwatchd perf install)")
 	adapterFlag := flag.String("a", "", "Network adapter for bandwidth monitoring (leave empty for auto-select)")
+	inventoryFlag := flag.String("inventory", "", "Export observed hosts to this file (.csv or .json)")
+	conntrackAlertFlag := flag.Float64("conntrack-alert", 90, "Alert when the conntrack table is this percent full (Linux only)")
+	cloudFlag := flag.Bool("cloud-metadata", true, "Tag the report with cloud instance metadata when running in AWS, Azure or GCP")
+	cloudRangesFlag := flag.Bool("cloud-ranges", false, "Fetch the published AWS, Azure and GCP IP ranges daily and attribute traffic to provider, service and region")
+	probeFlag := flag.String("probe", "1.1.1.1:443", "host:port probed over TCP to confirm outages (empty to disable)")
+	outageAfterFlag := flag.Int("outage-after", 15, "Seconds without traffic before a failing probe counts as an outage")
+	healthIntervalFlag := flag.Int("health-interval", 10, "Seconds between gateway and DNS health checks (0 to disable)")
+	dnsCheckFlag := flag.String("dns-check-name", "example.com", "Name resolved by the DNS health check")
+	wanFlag := flag.String("wan", "", "Comma separated uplink interfaces to track usage and failover for")
+	var checksFlag checkList
+	flag.Var(&checksFlag, "check", "Synthetic check, repeatable: http:<url>, tcp:<host:port> or dns:<name>[@server]")
+	checkIntervalFlag := flag.Int("check-interval", 30, "Default seconds between synthetic checks")
+	twampFlag := flag.String("twamp", "", "Measure latency, jitter and loss to a TWAMP-light reflector, e.g. another netwatchd's -twamp-reflect (host[:port], default port 862)")
+	twampIntervalFlag := flag.Duration("twamp-interval", 100*time.Millisecond, "Time between TWAMP-light test packets")
+	twampReflectFlag := flag.String("twamp-reflect", "", "Answer TWAMP-light test packets on this address, e.g. :862")
+	certWarnFlag := flag.Int("cert-warn-days", 30, "Warn about observed TLS certificates expiring within this many days")
+	segmentationFlag := flag.String("segmentation-report", "", "Write segmentation policy violations to this CSV file")
+	outputFlag := flag.String("o", "text", "Report format: text, or json or csv to print the report on stdout (other output goes to stderr)")
+	csvFileFlag := flag.String("csv-file", "", "Also write one CSV row per bucket (timestamp, packets, bytes sent and received) to this file")
+	reportEveryFlag := flag.Duration("report-every", 0, "Print an interim report of the buckets closed so far this often while capturing, e.g. 5m (also on SIGUSR1)")
+	reportFileFlag := flag.String("report-file", "", "Also write the report as JSON to this file, e.g. for netwatchd merge")
+	digestFlag := flag.String("digest", "", "In daemon mode write a weekly digest from the -report-file period reports to this file (.html, .md or text), named by week")
+	digestEmailFlag := flag.String("digest-email", "", "Also mail the weekly digest through this email sink of the config")
+	dbFlag := flag.String("db", "", "Also add the session, its buckets, alerts, hosts and flows to this SQLite database, e.g. netwatch.db, created if missing, for netwatchd query. With -web they can be pulled from /api/v1/export?from=&to=&format=csv|json|parquet&table=sessions|buckets|alerts|hosts|flows")
+	checkpointFlag := flag.String("checkpoint", "", "Save buckets, alerts, hosts and accounting to this file and resume from it after a crash or reboot")
+	checkpointEveryFlag := flag.Duration("checkpoint-interval", time.Minute, "How often -checkpoint is saved")
+	readFlag := flag.String("read", "", "Analyse this pcap or pcapng file instead of capturing live, bucketing by the packet timestamps")
+	writeFlag := flag.String("write", "", "Also save the captured packets to this pcapng file to open in Wireshark, one file per interface with several -i (e.g. capture-eth0.pcapng)")
+	evidenceFlag := flag.String("evidence", "", "Save the capture, console output, alerts and metadata to this directory for netwatchd bundle")
+	startAtFlag := flag.String("start-at", "", "Start capturing at this local time (HH:MM:SS) or RFC 3339 timestamp, to line up captures on several hosts")
+	startDelayFlag := flag.Duration("start-delay", 0, "Wait this long before capturing, e.g. 10s")
+	dedupFlag := flag.Bool("dedup", true, "Drop copies of the same packet captured on several interfaces (e.g. -i any on a bond)")
+	dedupWindowFlag := flag.Duration("dedup-window", 50*time.Millisecond, "How far apart two copies of a packet may be captured")
+	matrixPrefixFlag := flag.Int("matrix-prefix", 24, "IPv4 prefix length subnets are grouped by in the traffic matrix")
+	matrixPrefix6Flag := flag.Int("matrix-prefix6", 64, "IPv6 prefix length subnets are grouped by in the traffic matrix")
+	dnsLogFlag := flag.String("dns-log", "", "Append every DNS query and response with its response code to this JSON lines file")
+	topPortsFlag := flag.Int("top-ports", 10, "Ports listed in the report by traffic, 0 to leave them out")
+	matrixFlag := flag.String("matrix", "", "Export the subnet traffic matrix to this CSV file")
+	pricePerGBFlag := flag.Float64("price-per-gb", 0, "Price per GB transferred, for the cost estimate")
+	pricePerMbpsFlag := flag.Float64("price-per-mbps", 0, "Monthly price per Mbps of 95th percentile, for the cost estimate")
+	currencyFlag := flag.String("currency", "$", "Currency symbol used in cost estimates")
+	costAlertFlag := flag.Float64("cost-alert", 0, "Alert when the projected monthly cost exceeds this amount")
+	hostapdFlag := flag.String("hostapd", "", "hostapd control socket directory to read per-client usage from, e.g. /var/run/hostapd")
+	radiusFlag := flag.String("radius-acct", "", "Listen for RADIUS accounting records on this address, e.g. :1813")
+	radiusSecretFlag := flag.String("radius-secret", "", "Shared secret for -radius-acct")
+	silenceFileFlag := flag.String("silences", defaultSilenceFile, "Silence file written by netwatchd silence, re-read while capturing")
+	var patternsFlag patternList
+	flag.Var(&patternsFlag, "match", "Count payload matches per bucket, repeatable: [name=]str:<text>, re:<regex> or hex:<bytes>")
+	var thresholdsFlag thresholdList
+	flag.Var(&thresholdsFlag, "threshold", "Alert when a bucket metric crosses a value, repeatable: [name=]<bandwidth|captured|packets|pps> >|< <value>[ for <buckets>], e.g. \"bandwidth > 50MB/min\"")
+	historyFlag := flag.String("history", "", "Comma separated report files, directories or globs to compare each bucket with a week before, defaults to the -report-file period reports")
+	webhookFlag := flag.String("webhook", "", "POST every alert as JSON to this URL, in addition to the configured sinks")
+	var labelsFlag labelList
+	flag.Var(&labelsFlag, "label", "Tag every output with key=value, repeatable (e.g. -label site=berlin)")
+	keysFlag := flag.Bool("keys", true, "Enable keyboard controls when running in a terminal")
+	webFlag := flag.String("web", "", "Serve a live dashboard of the packet and bandwidth buckets on this address, e.g. :8080, and /api/v1/devices, /api/v1/alerts and /api/v1/flows with limit, cursor, fields and ip, protocol, from and to filters")
+	quietFlag := flag.Bool("quiet", false, "Keep counting and analysing packets without printing a line for each")
+	debugFlag := flag.Bool("debug", false, "Also log backend diagnostics, e.g. PDH error codes of failed samples")
+	flag.BoolVar(debugFlag, "v", false, "Same as -debug")
+	tuiFlag := flag.Bool("tui", false, "Show a live dashboard with packet and bandwidth sparklines instead of packet lines")
+	raRoutersFlag := flag.String("ra-routers", "", "Comma separated IPv6 addresses or MACs allowed to send router advertisements")
 	flag.Parse()
 
-	if *interfaceFlag == "" {
+	var policy *SegmentationPolicy
+	var expected []ExpectedFlow
+	var maintenance []MaintenanceWindow
+	var sinks []SinkConfig
+	var routes []Route
+	labels := make(Labels)
+	if *configFlag != "" {
+		cfg, err := applyConfig(*configFlag)
+		if err != nil {
+			fmt.Printf("Invalid config: %v\n", err)
+			os.Exit(1)
+		}
+		if len(checksFlag) == 0 {
+			checksFlag = cfg.Checks
+		}
+		policy = cfg.Segmentation
+		expected = cfg.Expected
+		maintenance = cfg.Maintenance
+		sinks, routes = cfg.Sinks, cfg.Routes
+		if len(patternsFlag) == 0 {
+			patternsFlag = cfg.PayloadPatterns
+		}
+		if len(thresholdsFlag) == 0 {
+			thresholdsFlag = cfg.Thresholds
+		}
+		for k, v := range cfg.Labels {
+			labels[k] = v
+		}
+	}
+	// -label overrides the same key from the config file
+	for k, v := range labelsFlag {
+		labels[k] = v
+	}
+	if *debugFlag {
+		logLevel.Set(slog.LevelDebug)
+	}
+
+	// -o json and csv keep stdout for the report, everything else goes to stderr
+	var reportOut *os.File
+	switch *outputFlag {
+	case "text":
+	case "json", "csv":
+		reportOut, os.Stdout = os.Stdout, os.Stderr
+	default:
+		fmt.Printf("Invalid output format %q, expected text, json or csv\n", *outputFlag)
+		os.Exit(1)
+	}
+
+	// Offline analysis replaces the interfaces, and the live-only monitors are left out
+	if *readFlag != "" {
+		if len(interfaceFlag) > 0 || *daemonFlag || *checkpointFlag != "" || *writeFlag != "" || *evidenceFlag != "" {
+			fmt.Println("-read can't be combined with -i, -daemon, -checkpoint, -write or -evidence")
+			os.Exit(1)
+		}
+		durationFlag = 0
+		*keysFlag, *enableBandwidth, *cloudFlag = false, false, false
+		*probeFlag, *healthIntervalFlag = "", 0
+	} else if len(interfaceFlag) == 0 {
 		listInterfaces()
 		return
 	}
 
+	// Synchronized start across hosts
+	start, err := scheduledStart(*startAtFlag, *startDelayFlag, time.Now())
+	if err != nil {
+		fmt.Printf("Invalid start time: %v\n", err)
+		os.Exit(1)
+	}
+	if !start.IsZero() && !waitForStart(start) {
+		return
+	}
+
+	startTime := time.Now()
+	var firstPacket *netwatch.Packet
+	var filePackets <-chan *netwatch.Packet
+	if *readFlag != "" {
+		if firstPacket, filePackets, err = openCaptureFile(*readFlag, *filterFlag, len(patternsFlag) > 0); err != nil {
+			fmt.Printf("Error reading capture: %v\n", err)
+			os.Exit(1)
+		}
+		startTime = firstPacket.Time
+	}
+
 	// Initialize data monitoring
-	data := &MonitoringData{
-		startTime:		time.Now(),
-		nextBucketTime: time.Now().Add(1 * time.Minute),
+	data := newMonitoringData(startTime, strings.Split(*raRoutersFlag, ","), *certWarnFlag)
+	data.probeTarget = *probeFlag
+	var ifaces []captureIface
+	var interfaceNames []string
+	for _, spec := range interfaceFlag {
+		if name := resolveInterfaceName(spec); !slices.Contains(interfaceNames, name) {
+			ifaces = append(ifaces, captureIface{name: name, spec: spec})
+			interfaceNames = append(interfaceNames, name)
+		}
+	}
+	data.setInterfaces(interfaceNames)
+	if *readFlag != "" {
+		data.captureInterface = *readFlag
+	}
+	data.captureFilter = *filterFlag
+	data.labels = labels
+	data.quietPackets = *quietFlag
+	if *bucketFlag < time.Second {
+		fmt.Println("Invalid -bucket, must be at least 1s")
+		os.Exit(1)
+	}
+	data.bucket = *bucketFlag
+	data.buckets = netwatch.NewBuckets(data.startTime, data.bucket)
+	watchStart := data.startTime
+	if *checkpointFlag != "" && data.resumeCheckpoint(*checkpointFlag, watchStart) {
+		// Expected traffic can't be judged for the time before the restart
+		watchStart = time.Now()
+	}
+	if *matrixPrefixFlag < 0 || *matrixPrefixFlag > 32 || *matrixPrefix6Flag < 0 || *matrixPrefix6Flag > 128 {
+		fmt.Println("Invalid traffic matrix prefix length")
+		os.Exit(1)
+	}
+	data.matrix = NewTrafficMatrix(*matrixPrefixFlag, *matrixPrefix6Flag)
+	if *topPortsFlag < 0 {
+		fmt.Println("Invalid -top-ports, must not be negative")
+		os.Exit(1)
+	}
+	data.ports.top = *topPortsFlag
+	if *dbFlag != "" {
+		data.flows = NewFlowTracker()
+	}
+	if len(patternsFlag) > 0 {
+		if data.patterns, err = NewPatternCounter(patternsFlag); err != nil {
+			fmt.Printf("Invalid payload pattern: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if data.silencer, err = NewSilencer(maintenance, *silenceFileFlag); err != nil {
+		fmt.Printf("Invalid maintenance window: %v\n", err)
+		os.Exit(1)
+	}
+	if *webhookFlag != "" {
+		sinks = append(sinks, SinkConfig{Name: "webhook-flag", Type: "webhook", URL: *webhookFlag})
+		// Routes only name the configured sinks, without one the flag's sink wouldn't be used
+		if len(routes) > 0 {
+			routes = append(routes, Route{Name: "webhook-flag", Sinks: []string{"webhook-flag"}})
+		}
+	}
+	if len(sinks) > 0 {
+		if data.router, err = NewAlertRouter(sinks, routes, data.labels); err != nil {
+			fmt.Printf("Invalid alert routing: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if len(expected) > 0 {
+		if data.watchdog, err = NewWatchdog(expected, watchStart); err != nil {
+			fmt.Printf("Invalid expected traffic: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	data.pricing = &Pricing{PerGB: *pricePerGBFlag, PerMbps95: *pricePerMbpsFlag, Currency: *currencyFlag, MonthlyAlert: *costAlertFlag}
+	if *dedupFlag {
+		data.dedup = NewDeduper(*dedupWindowFlag)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(*durationFlag)*time.Second)
-	defer cancel()
+	if policy != nil {
+		auditor, err := NewSegmentationAuditor(policy)
+		if err != nil {
+			fmt.Printf("Invalid segmentation policy: %v\n", err)
+			os.Exit(1)
+		}
+		data.segmentation = auditor
+	}
 
-	var wg sync.WaitGroup
+	pcapFile := *writeFlag
+	if *evidenceFlag != "" && *writeFlag != "" {
+		fmt.Println("-evidence already saves the capture, use either -evidence or -write")
+		os.Exit(1)
+	}
+	if *evidenceFlag != "" {
+		if err := os.MkdirAll(*evidenceFlag, 0755); err != nil {
+			fmt.Printf("Failed to create evidence directory: %v\n", err)
+			os.Exit(1)
+		}
+		stopTee, err := teeStdout(filepath.Join(*evidenceFlag, evidenceReport))
+		if err != nil {
+			fmt.Printf("Failed to record console output: %v\n", err)
+			os.Exit(1)
+		}
+		defer stopTee()
+		pcapFile = filepath.Join(*evidenceFlag, evidenceCapture)
+	}
+
+	// One engine per interface, each writing its own capture file
+	var captureFiles []string
+	var engines []netwatch.CaptureEngine
+	var runAs *netwatch.Credential
+	if *userFlag != "" {
+		if runAs, err = tsharkCredential(*userFlag); err != nil {
+			fmt.Printf("Failed to drop privileges: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	for _, iface := range ifaces {
+		name := iface.name
+		opts := netwatch.CaptureOptions{Interface: name, Filter: *filterFlag, PcapFile: pcapFile, Payload: data.patterns != nil,
+			DiskCheck: func(path string) error { return diskGuardrail.Check(path) },
+			Logf: func(format string, args ...any) { fmt.Printf(format, args...) }, RunAs: runAs}
+		if pcapFile != "" && len(ifaces) > 1 {
+			if *writeFlag != "" {
+				ext := filepath.Ext(*writeFlag)
+				opts.PcapFile = strings.TrimSuffix(*writeFlag, ext) + "-" + interfaceFileName(name) + ext
+			} else {
+				opts.PcapFile = filepath.Join(*evidenceFlag, evidenceInterfaceCapture(name))
+			}
+		}
+		if opts.PcapFile != "" {
+			captureFiles = append(captureFiles, opts.PcapFile)
+		}
+		engine, err := netwatch.NewCaptureEngine(*captureFlag, opts)
+		if err != nil {
+			fmt.Printf("Error starting capture on %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		// tshark numbers its interfaces itself
+		if t, ok := engine.(*netwatch.TsharkEngine); ok {
+			t.Interface = iface.spec
+		}
+		engines = append(engines, engine)
+	}
+
+	if *dnsLogFlag != "" {
+		if err := data.dns.openLog(*dnsLogFlag); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer func() {
+			data.mu.Lock()
+			data.dns.close()
+			data.mu.Unlock()
+		}()
+	}
+
+	if *journalFlag {
+		if data.journal, err = OpenJournal(data.captureInterface, data.labels); err != nil {
+			fmt.Println(err)
+		} else {
+			defer data.journal.Close()
+			data.journal.Started(*filterFlag)
+		}
+	}
 
-	//Start packet capture
-	wg.Add(1)
+	if *daemonFlag {
+		if *periodFlag < data.bucket {
+			fmt.Println("Invalid -period, must be at least one -bucket")
+			os.Exit(1)
+		}
+		durationFlag = 0
+		*keysFlag = false
+		data.quietPackets = true
+		data.periods = &periodReports{every: *periodFlag, format: *outputFlag, out: reportOut, reportFile: *reportFileFlag, db: *dbFlag}
+	}
+	if *digestFlag != "" {
+		if data.periods == nil || *reportFileFlag == "" {
+			fmt.Println("-digest needs -daemon and -report-file")
+			os.Exit(1)
+		}
+		data.digest = &digestSchedule{path: *digestFlag, sources: []string{periodFilePattern(*reportFileFlag)},
+			next: weekStart(data.startTime).AddDate(0, 0, 7)}
+		if *digestEmailFlag != "" {
+			if data.digest.email, err = digestSink(sinks, *digestEmailFlag); err != nil {
+				fmt.Printf("Invalid -digest-email: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+	if *tuiFlag && *daemonFlag {
+		fmt.Println("Note: -tui is ignored with -daemon")
+		*tuiFlag = false
+	}
+	if *tuiFlag {
+		data.quietPackets = true
+	}
+
+	ampMinBytes, err := parseBytes(*ampMinBytesFlag)
+	if err != nil || ampMinBytes <= 0 {
+		fmt.Println("Invalid -amp-min-bytes, use e.g. 1MB")
+		os.Exit(1)
+	}
+	data.amplification = NewAmplificationDetector(ampMinBytes)
+	switch {
+	case *historyFlag != "":
+		data.history = &weekHistory{sources: strings.Split(*historyFlag, ",")}
+	case *reportFileFlag != "":
+		data.history = &weekHistory{sources: []string{periodFilePattern(*reportFileFlag), *reportFileFlag}}
+	}
+	if len(thresholdsFlag) > 0 {
+		if data.thresholds, err = NewThresholdEvaluator(thresholdsFlag, data.bucket); err != nil {
+			fmt.Printf("Invalid -threshold: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *recommendLimitsFlag {
+		data.rates = NewRateTracker()
+	}
+	if *blockKindsFlag != "" {
+		if *blockTTLFlag <= 0 || *blockMaxFlag <= 0 {
+			fmt.Println("Invalid -block-ttl or -block-max, must be positive")
+			os.Exit(1)
+		}
+		// Every block and unblock runs nft, not only the setup
+		if *userFlag != "" && !*blockDryRunFlag {
+			fmt.Println("Invalid -block-kinds with -user: the firewall can't be changed after dropping root, add -block-dry-run")
+			os.Exit(1)
+		}
+		if data.blocker, err = NewBlocker(strings.Split(*blockKindsFlag, ","), strings.Split(*blockAllowFlag, ","), *blockTTLFlag, *blockMaxFlag, *blockDryRunFlag); err != nil {
+			fmt.Printf("Invalid blocking setup: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *shapeFlag != "" {
+		if *readFlag != "" {
+			fmt.Println("Invalid -shape: shaping needs a live capture")
+			os.Exit(1)
+		}
+		// tc runs for every new target and to lift the shaping, not only to set up
+		if *userFlag != "" && *shapeApplyFlag {
+			fmt.Println("Invalid -shape-apply with -user: shaping can't be changed after dropping root, leave out -shape-apply for advice")
+			os.Exit(1)
+		}
+		if data.shaper, err = NewShaper(strings.Split(*shapeFlag, ","), strings.Split(*shapeHoursFlag, ","), data.primaryInterface(), *shapeApplyFlag); err != nil {
+			fmt.Printf("Invalid shaping setup: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *minFreeDiskFlag != "" {
+		minFree, err := parseBytes(*minFreeDiskFlag)
+		if err != nil {
+			fmt.Printf("Invalid -min-free-disk: %v\n", err)
+			os.Exit(1)
+		}
+		var prune []string
+		if data.periods != nil && *reportFileFlag != "" {
+			prune = append(prune, periodFilePattern(*reportFileFlag))
+		}
+		diskGuardrail = newDiskGuard(uint64(minFree), prune...)
+		if pcapFile != "" {
+			if _, ok := engines[0].(*netwatch.TsharkEngine); ok {
+				fmt.Println("Note: -min-free-disk can't pause tshark writing the pcap, use -capture native for that")
+			}
+		}
+	}
+
+	data.requestedDuration = durationFlag
+	// Ctrl-C and SIGTERM end any capture early, the report covers what was captured so far
+	interrupted, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if durationFlag > 0 {
+		ctx, cancel = context.WithTimeout(interrupted, time.Duration(durationFlag))
+	} else {
+		// Unlimited captures end with q, Ctrl-C or SIGTERM from the service manager
+		ctx, cancel = context.WithCancel(interrupted)
+		switch {
+		case *readFlag != "":
+		case *daemonFlag:
+			fmt.Printf("Running as a daemon, reporting every %s until stopped\n", *periodFlag)
+		default:
+			fmt.Println("Capturing until stopped, press q or Ctrl-C to finish")
+		}
+	}
+	defer cancel()
+
+	// Once stopping, a second Ctrl-C quits without the report
 	go func() {
-		defer wg.Done()
-		capturePackets(ctx, data, *interfaceFlag, *filterFlag)
+		<-ctx.Done()
+		if interrupted.Err() != nil && durationFlag > 0 {
+			fmt.Println("-- interrupted, reporting what was captured --")
+		}
+		stopSignals()
 	}()
 
-	// Start bandwidth monitoring for windows
-	if *enableBandwidth && runtime.GOOS == "windows" {
+	// Bound before -user drops the right to ports below 1024
+	if *twampReflectFlag != "" {
+		data.twampReflector = NewTwampReflector(*twampReflectFlag)
+		if err := data.twampReflector.listen(); err != nil {
+			fmt.Printf("Failed to start TWAMP reflector on %s: %v\n", data.twampReflector.Addr, err)
+			os.Exit(1)
+		}
+	}
+
+	var wg sync.WaitGroup
+
+	// A file is read in moments, the cloud ranges are needed before it starts
+	if *readFlag != "" && *cloudRangesFlag {
+		ranges, err := cloudmeta.FetchRanges(ctx)
+		if err != nil {
+			fmt.Println(err)
+		}
+		data.mu.Lock()
+		data.cloudRanges.setRanges(ranges)
+		data.mu.Unlock()
+		*cloudRangesFlag = false
+	}
+
+	// The file is read as fast as it can be analysed, finishing the run at its end
+	var readEnd time.Time
+	if *readFlag != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cancel()
+			readEnd = readCapture(ctx, data, firstPacket, filePackets)
+		}()
+	}
+
+	//Start packet capture, the only step that needs root or CAP_NET_RAW
+	for i, engine := range engines {
+		packets, err := engine.Start(ctx)
+		if err != nil {
+			logger.Error("failed to start capture", "interface", ifaces[i].name, "err", err)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			capturePackets(data, ifaces[i].name, packets)
+		}()
+	}
+	if *userFlag != "" {
+		if err := dropPrivileges(*userFlag); err != nil {
+			fmt.Printf("Failed to drop privileges: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Start bandwidth monitoring from pdh on Windows, /proc/net/dev on Linux or if_data on macOS
+	if *enableBandwidth && newStatsProvider() != nil {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			monitorBandwidth(ctx, data, *adapterFlag)
 		}()
+	} else {
+		data.useCaptureBandwidth(*readFlag == "")
+	}
+
+	if *maxCPUFlag > 0 || *maxMemoryFlag != "" {
+		var maxMemory int64
+		if *maxMemoryFlag != "" {
+			if maxMemory, err = parseBytes(*maxMemoryFlag); err != nil {
+				fmt.Printf("Invalid -max-memory: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		data.mu.Lock()
+		data.limits = newResourceLimits(*maxCPUFlag, maxMemory)
+		data.mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			enforceLimits(ctx, data, data.limits)
+		}()
+	}
+
+	if *checkpointFlag != "" {
+		if *checkpointEveryFlag <= 0 {
+			fmt.Println("Invalid -checkpoint-interval")
+			os.Exit(1)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runCheckpoints(ctx, data, *checkpointFlag, *checkpointEveryFlag)
+		}()
+	}
+
+	if *perfFlag {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			publishPerfCounters(ctx, data)
+		}()
+	}
+
+	// Cloud instance identity for the report header
+	if *cloudFlag {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if inst, err := cloudmeta.Detect(ctx); err == nil {
+				data.mu.Lock()
+				data.cloud = inst
+				data.mu.Unlock()
+			}
+		}()
+	}
+
+	// Cloud provider ranges for the destinations section
+	if *cloudRangesFlag {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			refreshCloudRanges(ctx, data)
+		}()
+	}
+
+	// Connectivity outages
+	if *probeFlag != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			monitorOutages(ctx, data, *probeFlag, time.Duration(*outageAfterFlag)*time.Second)
+		}()
+	}
+
+	// Gateway and DNS health
+	if *healthIntervalFlag > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			monitorInfrastructure(ctx, data, time.Duration(*healthIntervalFlag)*time.Second, *dnsCheckFlag)
+		}()
+	}
+
+	// Per-client accounting from the AP or RADIUS
+	if *radiusFlag != "" && *radiusSecretFlag == "" {
+		fmt.Println("-radius-acct needs -radius-secret")
+		os.Exit(1)
+	}
+	if (*hostapdFlag != "" || *radiusFlag != "") && data.accounting == nil {
+		data.accounting = NewAccounting()
+	}
+	if *hostapdFlag != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			collectHostapdAccounting(ctx, data, *hostapdFlag)
+		}()
+	}
+	if *radiusFlag != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			listenRADIUSAccounting(ctx, data, *radiusFlag, *radiusSecretFlag)
+		}()
+	}
+
+	// Multi-WAN usage and failover
+	if *wanFlag != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			monitorWANs(ctx, data, strings.Split(*wanFlag, ","))
+		}()
+	}
+
+	// Synthetic transactions
+	for _, spec := range checksFlag {
+		check := &SyntheticCheck{Spec: spec}
+		data.syntheticChecks = append(data.syntheticChecks, check)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			monitorSyntheticCheck(ctx, data, check, time.Duration(*checkIntervalFlag)*time.Second)
+		}()
+	}
+
+	// TWAMP-light sender and reflector
+	if *twampFlag != "" {
+		if *twampIntervalFlag <= 0 {
+			fmt.Println("Invalid -twamp-interval")
+			os.Exit(1)
+		}
+		data.twamp = NewTwampSession(*twampFlag)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runTwampSender(ctx, data, data.twamp, *twampIntervalFlag)
+		}()
+	}
+	if data.twampReflector != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runTwampReflector(ctx, data, data.twampReflector)
+		}()
+	}
+
+	// OS network stack counters and local firewall drop counters
+	if *readFlag == "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			collectOSHealth(ctx, data)
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			collectFirewallDrops(ctx, data)
+		}()
+	}
+
+	// Linux only: virtual interface peers, bond state, NIC, conntrack and wireless stats
+	if runtime.GOOS == "linux" && *readFlag == "" {
+		for _, iface := range ifaces {
+			data.virtualPorts = append(data.virtualPorts, resolveVirtualPorts(iface.name)...)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			monitorBonds(ctx, data)
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			collectNICStats(ctx, data, data.primaryInterface())
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			monitorConntrack(ctx, data, *conntrackAlertFlag)
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			monitorRouteChurn(ctx, data)
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			collectSSIDUsage(ctx, data)
+		}()
 	}
 
-	// Bucket management goroutine
-	wg.Add(1)
+	// Bucket management goroutine, -read rotates by packet time instead
+	if *readFlag == "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			manageBuckets(ctx, data)
+		}()
+	}
+
+	// Pause/resume from another process
+	pauseSignals := make(chan os.Signal, 1)
+	notifyPause(pauseSignals)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-pauseSignals:
+				data.mu.Lock()
+				data.setPaused(!data.paused, time.Now())
+				data.mu.Unlock()
+			}
+		}
+	}()
+
+	// Interim reports on SIGUSR1 and every -report-every
+	reportSignals := make(chan os.Signal, 1)
+	notifyReport(reportSignals)
+	var reportTicks <-chan time.Time
+	if *reportEveryFlag > 0 {
+		ticker := time.NewTicker(*reportEveryFlag)
+		defer ticker.Stop()
+		reportTicks = ticker.C
+	}
 	go func() {
-		defer wg.Done()
-		manageBuckets(ctx, data)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-reportSignals:
+			case <-reportTicks:
+			}
+			data.mu.Lock()
+			data.interimReport(*outputFlag, reportOut, *reportFileFlag)
+			data.mu.Unlock()
+		}
 	}()
 
-	wg.Wait()
-	generateReport(data)
+	if data.blocker != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data.blocker.Run(ctx)
+		}()
+	}
+
+	if data.shaper != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data.shaper.Run(ctx, func(category string) []shapeTarget {
+				data.mu.Lock()
+				defer data.mu.Unlock()
+				return data.shapeTargets(category)
+			})
+		}()
+	}
+
+	if *webFlag != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runWebDashboard(ctx, data, *webFlag, *dbFlag)
+		}()
+	}
+
+	// The dashboard leaves the alternate screen before the report is printed
+	if *tuiFlag {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runTUI(ctx, data)
+		}()
+	}
+
+	if *keysFlag {
+		if restore := startKeyboard(ctx, cancel, data); restore != nil {
+			defer restore()
+		}
+	}
+
+	waitDrained(ctx, &wg, 10*time.Second)
+	end := time.Now()
+	if *readFlag != "" {
+		end = readEnd
+		data.requestedDuration = captureDuration(end.Sub(data.startTime).Round(time.Second))
+	}
+	if reportOut != nil {
+		if err := printReportAs(*outputFlag, reportOut, data, end); err != nil {
+			fmt.Printf("Failed to print report: %v\n", err)
+		}
+	} else {
+		generateReport(data, end)
+	}
+	data.router.Close(15 * time.Second)
+
+	if *writeFlag != "" {
+		fmt.Printf("Capture written to %s\n", strings.Join(captureFiles, ", "))
+	}
+
+	if *reportFileFlag != "" {
+		data.mu.Lock()
+		report := buildReport(data, end)
+		path := *reportFileFlag
+		if data.periods != nil {
+			path = periodFileName(path, data.startTime)
+		}
+		data.mu.Unlock()
+		if err := writeReport(path, report); err != nil {
+			fmt.Println(err)
+		} else {
+			fmt.Printf("Report written to %s\n", path)
+		}
+	}
+
+	if *dbFlag != "" {
+		data.mu.Lock()
+		report := buildReport(data, end)
+		data.mu.Unlock()
+		storeSession(*dbFlag, report)
+	}
+
+	if *csvFileFlag != "" {
+		data.mu.Lock()
+		buckets := reportBuckets(data, end)
+		data.mu.Unlock()
+		if err := exportBucketCSV(*csvFileFlag, buckets); err != nil {
+			fmt.Println(err)
+		} else {
+			fmt.Printf("Buckets written to %s\n", *csvFileFlag)
+		}
+	}
+
+	if *inventoryFlag != "" {
+		data.mu.Lock()
+		hosts := data.inventory.Hosts()
+		data.mu.Unlock()
+		if err := exportInventory(*inventoryFlag, hosts); err != nil {
+			fmt.Printf("Failed to export inventory: %v\n", err)
+		} else {
+			fmt.Printf("Inventory of %d hosts written to %s\n", len(hosts), *inventoryFlag)
+		}
+	}
+
+	if *segmentationFlag != "" && data.segmentation != nil {
+		data.mu.Lock()
+		violations := data.segmentation.Violations()
+		data.mu.Unlock()
+		if err := exportViolations(*segmentationFlag, violations); err != nil {
+			fmt.Printf("Failed to export segmentation violations: %v\n", err)
+		} else {
+			fmt.Printf("%d segmentation violations written to %s\n", len(violations), *segmentationFlag)
+		}
+	}
+
+	if *matrixFlag != "" {
+		data.mu.Lock()
+		cells := data.matrix.Cells()
+		data.mu.Unlock()
+		if err := exportMatrix(*matrixFlag, cells); err != nil {
+			fmt.Printf("Failed to export traffic matrix: %v\n", err)
+		} else {
+			fmt.Printf("Traffic matrix of %d subnet pairs written to %s\n", len(cells), *matrixFlag)
+		}
+	}
+
+	if *evidenceFlag != "" {
+		if err := writeEvidence(*evidenceFlag, data, *filterFlag); err != nil {
+			fmt.Printf("Failed to write evidence: %v\n", err)
+		} else {
+			fmt.Printf("Evidence saved to %s, package it with: netwatchd bundle %s\n", *evidenceFlag, *evidenceFlag)
+		}
+	}
+}
+
+// Creating monitoring state for a window starting at start
+func newMonitoringData(start time.Time, raRouters []string, certWarnDays int) *MonitoringData {
+	return &MonitoringData{
+		startTime:		start,
+		buckets:		netwatch.NewBuckets(start, time.Minute),
+		bucket:			time.Minute,
+		lastPacketTime:	start,
+		inventory:		NewInventory(),
+		exposure:		NewExposureTracker(),
+		routerAdverts:	NewRouterAdvertTracker(raRouters),
+		spanningTree:	NewSpanningTreeTracker(),
+		certs:			NewCertTracker(certWarnDays),
+		weakProtocols:	NewWeakProtocolTracker(),
+		encryptedDNS:	NewEncryptedDNSTracker(),
+		fileServices:	NewFileServiceTracker(),
+		callQuality:	NewCallQualityTracker(),
+		sipCalls:		NewSIPCallTracker(),
+		games:			NewGameTracker(),
+		p2p:			NewP2PTracker(),
+		cloudRanges:	NewCloudRangeTracker(),
+		services:		NewServiceTracker(),
+		updates:		NewUpdateTracker(),
+		ports:			NewPortTracker(),
+		dns:			NewDNSTracker(),
+		amplification:	NewAmplificationDetector(1024 * 1024),
+		protocols:		NewProtocolBreakdown(),
+	}
 }
 
 func listInterfaces() {
-	cmd := exec.Command("tshark", "-D")
-	output, err := cmd.Output()
+	output, err := interfaceList()
 	if err != nil {
 		fmt.Printf("Error listing interfaces: %v\n", err)
-		fmt.Println("Make sure tshark is installed and in your PATH")
 		return
 	}
 
 	fmt.Println("Available network interfaces:")
-	fmt.Println(string(output))
-	fmt.Println("\nUsage: go run main.go -i <interface_number> -d <seconds> -f '<filter>' -b -a '<adapter>'")
-	fmt.Println("Example: go run main.go -i 1 -d 30 -f 'tcp port 443' -b")
+	fmt.Println(output)
+	fmt.Println("\nUsage: go run main.go -i <interface_number> -d <duration> -f '<filter>' -b -a '<adapter>'")
+	fmt.Println("Example: go run main.go -i 1 -d 30s -f 'tcp port 443' -b")
+}
+
+// Numbered interfaces from tshark -D, or from the native backend without tshark
+func interfaceList() (string, error) {
+	output, err := exec.Command("tshark", "-D").Output()
+	if err == nil {
+		return strings.TrimRight(string(output), "\n"), nil
+	}
+	lines, nativeErr := netwatch.ListInterfaces()
+	if nativeErr != nil {
+		return "", fmt.Errorf("tshark: %v, native: %v", err, nativeErr)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// captureIface is an interface to capture on by its resolved name and the
+// -i value it was given as, an interface number for tshark
+type captureIface struct {
+	name string
+	spec string
+}
+
+// Mapping an interface number to its name using the interface list
+func resolveInterfaceName(iface string) string {
+	if _, err := strconv.Atoi(iface); err != nil {
+		return iface
+	}
+
+	output, err := interfaceList()
+	if err != nil {
+		return iface
+	}
+	for _, line := range strings.Split(output, "\n") {
+		num, rest, ok := strings.Cut(strings.TrimSpace(line), ". ")
+		if !ok || num != iface {
+			continue
+		}
+		if name, _, ok := strings.Cut(rest, " ("); ok {
+			return name
+		}
+		return rest
+	}
+	return iface
 }
 
 func manageBuckets(ctx context.Context, data *MonitoringData) {
@@ -100,75 +1162,79 @@ func manageBuckets(ctx context.Context, data *MonitoringData) {
 			return
 		case now := <-ticker.C:
 			data.mu.Lock()
-			if now.After(data.nextBucketTime) {
-				// Move to next bucket
-				data.packetBuckets = append(data.packetBuckets, data.currentPackets)
-				data.bandwidthBuckets = append(data.bandwidthBuckets, data.currentBandwidth)
-				data.currentPackets = 0
-				data.currentBandwidth = 0
-				data.nextBucketTime = data.nextBucketTime.Add(1 * time.Minute)
+			if data.buckets.Due(now) {
+				data.rotateBucket()
+				data.flushPeriod()
 			}
 			data.mu.Unlock()
 		}
 	}
 }
 
-func capturePackets(ctx context.Context, data *MonitoringData, iface, filter string) {
-	args := []string{
-		"-i", iface,
-		"-l",
-	}
+// Moving to the next bucket. Callers hold data.mu.
+func (data *MonitoringData) rotateBucket() {
+	end := data.buckets.End()
+	data.finishBucket(data.buckets.Rotate(), end)
+}
 
-	if filter != "" {
-		args = append(args, "-f", filter)
+// Ending the bucket b that was just closed at end in every tracker, for both
+// rotateBucket and closeBuckets. Callers hold data.mu.
+func (data *MonitoringData) finishBucket(b netwatch.Bucket, end time.Time) {
+	data.journal.Bucket(b.Start, b.Seconds, b.Packets, data.currentBandwidth)
+	data.bandwidthBuckets = append(data.bandwidthBuckets, data.currentBandwidth)
+	data.sentBuckets = append(data.sentBuckets, data.currentSent)
+	data.recvBuckets = append(data.recvBuckets, data.currentRecv)
+	data.rotateInterfaces()
+	data.closePausedBucket(end)
+	data.routeChurnBuckets = append(data.routeChurnBuckets, data.currentRouteChurn)
+	data.currentRouteChurn = 0
+	data.rotateSyntheticChecks()
+	data.twamp.rotate(end)
+	data.patterns.rotate()
+	data.protocols.rotate()
+	data.ports.rotate(b.Start)
+	data.flows.rotate(b.Start, b.Seconds)
+	data.dns.flush()
+	data.rates.rotate(b.Seconds)
+	data.games.rotate(b.Start, b.Seconds)
+	if u := data.updates.rotate(b.Start); u != nil && u.Storm {
+		data.addAlert("update-storm", fmt.Sprintf("%.1f MB of software updates to %d hosts, %.0f%% of traffic",
+			float64(u.Bytes)/(1024*1024), u.Hosts, u.Percent), end)
 	}
-
-	fmt.Printf("Starting packet capture on interface %s...\n", iface)
-	if filter != "" {
-		fmt.Printf("Filter: %s\n", filter)
+	data.checkThresholds(b, data.currentBandwidth, end)
+	data.currentBandwidth = 0
+	data.currentSent = 0
+	data.currentRecv = 0
+	data.checkCostBudget(end)
+	for _, msg := range data.watchdog.Check(end) {
+		data.addAlert("expected-traffic", msg, end)
 	}
-	fmt.Println("---")
-
-	cmd := exec.CommandContext(ctx, "tshark", args...)
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		fmt.Printf("Error setting up pipe: %v\n", err)
-		return
+	for _, f := range data.amplification.Check(end) {
+		data.addOffenderAlert("amplification", f.String(), f.Reflectors, end)
 	}
-
-	if err := cmd.Start(); err != nil {
-		fmt.Printf("Error starting tshark: %v\n", err)
-		return
-	}
-
-	scanner := bufio.NewScanner(stdout)
-	for scanner.Scan() {
-		line := scanner.Text()
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			fmt.Println(line) // Show packet in real-time
-			data.mu.Lock()
-			data.currentPackets++
-			data.mu.Unlock()
-		}
-	}
-	cmd.Wait()
 }
 
 func monitorBandwidth(ctx context.Context, data *MonitoringData, adapterName string) {
-	if err := pdh.Initialize(); err != nil {
-		fmt.Printf("Failed to initialize PDH: %v\n", err)
+	// Without adapter counters the bytes are counted from the capture
+	counting := false
+	defer func() {
+		if !counting {
+			data.useCaptureBandwidth(true)
+		}
+	}()
+
+	stats := newStatsProvider()
+	if err := stats.Initialize(); err != nil {
+		logger.Error("failed to initialize network statistics", "err", err)
 		return
 	}
-	defer pdh.Cleanup()
+	defer stats.Cleanup()
 
 	// Get adapter if not specified
 	if adapterName == "" {
-		adapters, err := pdh.GetNetworkAdapters()
+		adapters, err := stats.GetNetworkAdapters()
 		if err != nil || len(adapters) == 0 {
-			fmt.Printf("Failed to get network adapters: %v\n", err)
+			logger.Error("failed to get network adapters", "err", err)
 			return
 		}
 		adapterName = adapters[0]
@@ -176,22 +1242,33 @@ func monitorBandwidth(ctx context.Context, data *MonitoringData, adapterName str
 
 	// Bandwidth monitoring running silently in background
 
-	sentCounter, err := pdh.NewCounter(adapterName, "Bytes Sent/sec")
+	sentCounter, err := stats.NewCounter(adapterName, "Bytes Sent/sec")
 	if err != nil {
-		fmt.Printf("Failed to create sent counter: %v\n", err)
+		logger.Error("failed to create sent counter", "adapter", adapterName, "err", err)
 		return
 	}
 	defer sentCounter.Close()
 
-	recvCounter, err := pdh.NewCounter(adapterName, "Bytes Received/sec")
+	recvCounter, err := stats.NewCounter(adapterName, "Bytes Received/sec")
 	if err != nil {
-		fmt.Printf("failed to create received counter: %v\n", err)
+		logger.Error("failed to create received counter", "adapter", adapterName, "err", err)
 		return
 	}
 	defer recvCounter.Close()
+	counting = true
+
+	link := newLinkHealthMonitor(stats, adapterName)
+	defer link.close()
+	data.mu.Lock()
+	data.linkHealth = link
+	data.mu.Unlock()
 
-	// Initial collection
-	pdh.CollectData()
+	// Initial collection, the first /proc/net/dev or if_data read only records the baseline
+	stats.CollectData()
+	if _, ok := stats.(*pdhProvider); !ok {
+		sentCounter.GetValue()
+		recvCounter.GetValue()
+	}
 	time.Sleep(1 * time.Second)
 
 	ticker := time.NewTicker(1 * time.Second)
@@ -202,68 +1279,196 @@ func monitorBandwidth(ctx context.Context, data *MonitoringData, adapterName str
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			if err := pdh.CollectData(); err != nil {
+			// PDH returns e.g. PDH_NO_DATA for a sample now and then, only worth a debug line
+			if err := stats.CollectData(); err != nil {
+				logger.Debug("bandwidth sample failed", "adapter", adapterName, "err", err)
 				continue
 			}
 
 			sentBytes, err1 := sentCounter.GetValue()
 			recvBytes, err2 := recvCounter.GetValue()
+			if err := errors.Join(err1, err2); err != nil {
+				logger.Debug("bandwidth counter read failed", "adapter", adapterName, "err", err)
+			}
+
+			link.sample(data)
 
 			if err1 == nil && err2 == nil {
 				totalBytes := sentBytes + recvBytes
 				data.mu.Lock()
-				data.currentBandwidth += totalBytes
+				if !data.paused {
+					data.currentBandwidth += totalBytes
+					data.currentSent += sentBytes
+					data.currentRecv += recvBytes
+				}
 				data.mu.Unlock()
 			}
 		}
 	}
 }
 
-func generateReport(data *MonitoringData) {
+// Waiting for the capture and monitors to stop once ctx is done, reporting
+// anyway when one of them hangs
+func waitDrained(ctx context.Context, wg *sync.WaitGroup, grace time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	select {
+	case <-done:
+	case <-time.After(grace):
+		logger.Warn("monitors still running, reporting without them", "after", grace)
+	}
+}
+
+// Closing the open bucket at the end of the capture. Callers hold data.mu.
+func (data *MonitoringData) closeBuckets(end time.Time) {
+	data.finishBucket(data.buckets.Close(end), end)
+}
+
+// Printing the report for the window ending at end
+func generateReport(data *MonitoringData, end time.Time) {
 	data.mu.Lock()
 	defer data.mu.Unlock()
-	data.packetBuckets = append(data.packetBuckets, data.currentPackets)
-	data.bandwidthBuckets = append(data.bandwidthBuckets, data.currentBandwidth)
+	data.closeBuckets(end)
+	printReport(data, end)
+}
 
-	elapsed := time.Since(data.startTime)
+// Printing the text report from closed buckets. Callers hold data.mu.
+func printReport(data *MonitoringData, end time.Time) {
+	elapsed := end.Sub(data.startTime)
 	fmt.Println("\n" + strings.Repeat("=", 60))
 	fmt.Println("MONITORING REPORT")
+	if data.cloud != nil {
+		fmt.Printf("Cloud instance: %s\n", data.cloud)
+	}
+	if len(data.labels) > 0 {
+		fmt.Printf("Labels: %s\n", data.labels)
+	}
+	fmt.Println(durationNote(data.requestedDuration, elapsed))
 	fmt.Println(strings.Repeat("=", 60))
 
 	totalPackets := 0
-	totalBandwidth := 0.0
+	totalBandwidth, totalSent, totalRecv := 0.0, 0.0, 0.0
+	var totalPaused time.Duration
+	buckets := reportBuckets(data, end)
+	lastWeek := data.history.compare(buckets)
 
-	for i := 0; i < len(data.packetBuckets); i++ {
-		packets := data.packetBuckets[i]
+	for i := 0; i < len(data.buckets.Closed); i++ {
+		packets := data.buckets.Closed[i].Packets
 		var bandwidth float64
 		if i < len(data.bandwidthBuckets) {
 			bandwidth = data.bandwidthBuckets[i]
 		}
 
+		var paused time.Duration
+		if i < len(data.pausedBuckets) {
+			paused = data.pausedBuckets[i]
+		}
+
 		totalPackets += packets
 		totalBandwidth += bandwidth
+		totalSent += buckets[i].BytesSent
+		totalRecv += buckets[i].BytesReceived
+		totalPaused += paused
+		direction := directionNote(buckets[i].BytesSent, buckets[i].BytesReceived)
 
-		if i == len(data.packetBuckets)-1 {
-			remainingSeconds := int(elapsed.Seconds()) - i*60
-			if remainingSeconds < 60 {
+		if i == len(data.buckets.Closed)-1 {
+			remainingSeconds := int((elapsed - time.Duration(i)*data.bucket).Seconds())
+			if remainingSeconds < int(data.bucket.Seconds()) {
 				bandwidthMB := bandwidth / (1024 * 1024) 
-				fmt.Printf("last %d seconds: %d packets | %.2f MB\n", remainingSeconds, packets, bandwidthMB)
+				fmt.Printf("last %d seconds: %d packets | %.2f MB%s%s%s\n", remainingSeconds, packets, bandwidthMB, direction,
+					pausedNote(paused, time.Duration(remainingSeconds)*time.Second), lastWeek.note(i, buckets[i]))
 				break
 			}
 		}
 
+		if isFullyPaused(paused, data.bucket) {
+			fmt.Printf("%s: paused\n", bucketLabel(i, data.bucket))
+			continue
+		}
 		bandwidthMB := bandwidth / (1024 * 1024)
-		fmt.Printf("minute %d: %d packets | %.2f MB\n", i+1, packets, bandwidthMB)
+		fmt.Printf("%s: %d packets | %.2f MB%s%s%s\n", bucketLabel(i, data.bucket), packets, bandwidthMB, direction, pausedNote(paused, data.bucket), lastWeek.note(i, buckets[i]))
 	}
 
 	fmt.Println(strings.Repeat("-", 60))
 	totalBandwidthMB := totalBandwidth / (1024 * 1024)
-	fmt.Printf("TOTAL: %d packets | %.2f MB\n", totalPackets, totalBandwidthMB)
+	fmt.Printf("TOTAL: %d packets | %.2f MB%s\n", totalPackets, totalBandwidthMB, directionNote(totalSent, totalRecv))
+	if data.captureBandwidth {
+		fmt.Println("MB estimated from captured frame lengths, no adapter counters were read")
+	}
+	if totalSent+totalRecv > 0 {
+		fmt.Printf("Upload share: %.1f%% sent, %.1f%% received\n", 100*totalSent/(totalSent+totalRecv), 100*totalRecv/(totalSent+totalRecv))
+	}
+	printLastWeekTotal(lastWeek, buckets)
+	if data.dedup != nil && data.dedup.Dropped > 0 {
+		fmt.Printf("Duplicates dropped: %d packets seen on more than one interface\n", data.dedup.Dropped)
+	}
+	if totalPaused > 0 {
+		fmt.Printf("Paused: %s of %s\n", totalPaused.Round(time.Second), elapsed.Round(time.Second))
+	}
+	if data.limits != nil && data.limits.Skipped > 0 {
+		fmt.Printf("Resource limits: %d packets counted but not analyzed, host and flow figures are sampled\n", data.limits.Skipped)
+	}
 
 	if totalPackets > 0 {
 		avgBytesPerPacket := totalBandwidth / float64(totalPackets)
 		fmt.Printf("Average bytes per packet: %.2f\n", avgBytesPerPacket)
 	}
+	printPercentileReport(buckets)
+	fmt.Printf("Hosts observed: %d\n", data.inventory.Len())
+	printInterfaceReport(reportInterfaces(data), data.bucket)
+	printPortReport(data.ports, data.bucket)
+	printProtocolReport(data.protocols, data.bucket)
+
+	printCostReport(data.pricing, reportBuckets(data, end), elapsed)
+	printOutageReport(data.outages, data.startTime, end, data.probeTarget)
+	printInfrastructureReport(data.infraChecks)
+	printSyntheticReport(data.syntheticChecks, data.bucket)
+	printTwampReport(data.twamp, data.twampReflector, data.bucket)
+	printWANReport(data.wanLinks, data.wanEvents)
+	printRouteChurnReport(data.routeAdds, data.routeDels, data.routeChurnBuckets, data.buckets.PacketCounts(), data.bandwidthBuckets, data.bucket)
+	printMatrixReport(data.matrix)
+	printPatternReport(data.patterns, data.bucket)
+	printWatchdogReport(data.watchdog, end)
+	printExposureReport(data.exposure)
+	printRouterAdvertReport(data.routerAdverts)
+	printSpanningTreeReport(data.spanningTree)
+	printCertReport(data.certs)
+	printWeakProtocolReport(data.weakProtocols)
+	printEncryptedDNSReport(data.encryptedDNS)
+	printFileServiceReport(data.fileServices)
+	printCallQualityReport(data.callQuality)
+	printSIPCallReport(data.sipCalls, data.callQuality.Calls())
+	printGameReport(data.games, data.bucket)
+	printP2PReport(data.p2p)
+	printCloudRangeReport(data.cloudRanges)
+	printServiceReport(data.services)
+	printUpdateReport(data.updates, data.bucket)
+	printDNSReport(data.dns)
+	printAmplificationReport(data.amplification, data.bucket)
+	printBlockReport(data.blocker)
+	printShapeReport(data.shaper)
+	if data.rates != nil {
+		printRateLimitReport(data.rates.Advice(runtime.GOOS, data.primaryInterface()), runtime.GOOS, data.primaryInterface())
+	}
+	printSegmentationReport(data.segmentation)
+	printVirtualPortReport(data.captureInterface, data.virtualPorts)
+	printBondReport(data.bonds, data.bondEvents)
+	printSSIDReport(data.ssids)
+	printAccountingReport(data)
+	printLinkHealthReport(data.linkHealth)
+	printNICReport(data.nic)
+	printOSHealthReport(data.osHealth)
+	printConntrackReport(data.conntrack)
+	printFirewallReport(data.firewallChecked, data.firewallDrops)
+	printAlertReport(data.alerts)
 
 	fmt.Println(strings.Repeat("=", 60))
 }
\ No newline at end of file
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"netwatchd/netwatch"
+)
+
+// matrixCell is the traffic from one subnet to another
+type matrixCell struct {
+	Src     string
+	Dst     string
+	Packets int
+	Bytes   int64
+	// Packets by transport, or the top layer without one, e.g. tcp or icmp
+	Protocols map[string]int
+}
+
+// TrafficMatrix aggregates packets into a subnet by subnet matrix.
+// It is guarded by the MonitoringData mutex.
+type TrafficMatrix struct {
+	prefix4 int
+	prefix6 int
+	cells   map[[2]string]*matrixCell
+}
+
+func NewTrafficMatrix(prefix4, prefix6 int) *TrafficMatrix {
+	return &TrafficMatrix{prefix4: prefix4, prefix6: prefix6, cells: make(map[[2]string]*matrixCell)}
+}
+
+// Truncating an address to the configured prefix length
+func (m *TrafficMatrix) subnet(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(m.prefix4, 32)), Mask: net.CIDRMask(m.prefix4, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(m.prefix6, 128)), Mask: net.CIDRMask(m.prefix6, 128)}).String()
+}
+
+func (m *TrafficMatrix) Observe(p *netwatch.Packet) {
+	if m == nil || p.SrcIP == "" || p.DstIP == "" {
+		return
+	}
+	src, dst := m.subnet(p.SrcIP), m.subnet(p.DstIP)
+	if src == "" || dst == "" {
+		return
+	}
+	key := [2]string{src, dst}
+	c, ok := m.cells[key]
+	if !ok {
+		c = &matrixCell{Src: src, Dst: dst, Protocols: make(map[string]int)}
+		m.cells[key] = c
+	}
+	proto := p.Transport
+	if proto == "" && len(p.Protocols) > 0 {
+		proto = p.Protocols[len(p.Protocols)-1]
+	}
+	if proto != "" {
+		c.Protocols[strings.ToLower(proto)]++
+	}
+	c.Packets++
+	c.Bytes += int64(p.Length)
+}
+
+// Cells returns the matrix entries ordered by bytes
+func (m *TrafficMatrix) Cells() []matrixCell {
+	cells := make([]matrixCell, 0, len(m.cells))
+	for _, c := range m.cells {
+		cells = append(cells, *c)
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].Bytes != cells[j].Bytes {
+			return cells[i].Bytes > cells[j].Bytes
+		}
+		return cells[i].Src+cells[i].Dst < cells[j].Src+cells[j].Dst
+	})
+	return cells
+}
+
+func printMatrixReport(m *TrafficMatrix) {
+	if m == nil || len(m.cells) == 0 {
+		return
+	}
+	cells := m.Cells()
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Printf("TRAFFIC MATRIX (/%d, /%d)\n", m.prefix4, m.prefix6)
+	for i, c := range cells {
+		if i == 15 {
+			fmt.Printf("... %d more subnet pairs\n", len(cells)-i)
+			break
+		}
+		fmt.Printf("%-20s -> %-20s %8d packets | %.2f MB\n", c.Src, c.Dst, c.Packets, float64(c.Bytes)/(1024*1024))
+	}
+	printMatrixHeatmap(cells)
+}
+
+// Shading the busiest subnets against each other, darker is more bytes
+func printMatrixHeatmap(cells []matrixCell) {
+	totals := make(map[string]int64)
+	for _, c := range cells {
+		totals[c.Src] += c.Bytes
+		totals[c.Dst] += c.Bytes
+	}
+	var subnets []string
+	for s := range totals {
+		subnets = append(subnets, s)
+	}
+	if len(subnets) < 2 {
+		return
+	}
+	sort.Slice(subnets, func(i, j int) bool { return totals[subnets[i]] > totals[subnets[j]] })
+	if len(subnets) > 8 {
+		subnets = subnets[:8]
+	}
+
+	bytes := make(map[[2]string]int64)
+	var peak int64
+	for _, c := range cells {
+		bytes[[2]string{c.Src, c.Dst}] = c.Bytes
+		peak = max(peak, c.Bytes)
+	}
+
+	shades := []string{" .", " :", " *", " #"}
+	fmt.Println()
+	fmt.Println("Heatmap (rows send to columns):")
+	header := fmt.Sprintf("%-20s", "")
+	for i := range subnets {
+		header += fmt.Sprintf(" %d", i+1)
+	}
+	fmt.Println(header)
+	for i, src := range subnets {
+		row := fmt.Sprintf("%d %-18s", i+1, src)
+		for _, dst := range subnets {
+			b := bytes[[2]string{src, dst}]
+			if b == 0 {
+				row += "  "
+				continue
+			}
+			level := int(float64(b) / float64(peak) * float64(len(shades)-1))
+			row += shades[level]
+		}
+		fmt.Println(row)
+	}
+}
+
+// Writing the full matrix as CSV
+func exportMatrix(path string, cells []matrixCell) error {
+	if err := diskGuardrail.Check(path); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"src_subnet", "dst_subnet", "packets", "bytes"})
+	for _, c := range cells {
+		w.Write([]string{c.Src, c.Dst, strconv.Itoa(c.Packets), strconv.FormatInt(c.Bytes, 10)})
+	}
+	w.Flush()
+	return w.Error()
+}
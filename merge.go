@@ -0,0 +1,193 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Handling "netwatchd merge": lining up reports from several hosts
+func runMergeCommand(args []string) int {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	maxLagFlag := fs.Duration("max-offset", 30*time.Second, "Largest clock offset searched for between hosts")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fmt.Println("Usage: netwatchd merge [-max-offset 30s] <report.json> <report.json> ...")
+		return 2
+	}
+
+	var reports []*Report
+	var labels []string
+	hosts := make(map[string]int)
+	for _, path := range fs.Args() {
+		r, err := loadReport(path)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		reports = append(reports, r)
+		labels = append(labels, r.Host)
+		hosts[r.Host]++
+	}
+	// Reports from the same host are told apart by file name
+	for i, path := range fs.Args() {
+		if labels[i] == "" || hosts[labels[i]] > 1 {
+			labels[i] = filepath.Base(path)
+		}
+	}
+
+	offsets := make([]time.Duration, len(reports))
+	fmt.Println(strings.Repeat("=", 60))
+	fmt.Println("MERGED REPORT")
+	fmt.Println(strings.Repeat("=", 60))
+	for i, r := range reports {
+		fmt.Printf("%s: %s, %s to %s\n", labels[i], r.Interface, r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339))
+		if r.Version != "" {
+			fmt.Printf("  netwatchd %s, report schema %d\n", r.Version, r.Schema)
+		}
+		if len(r.Labels) > 0 {
+			fmt.Printf("  labels: %s\n", r.Labels)
+		}
+		for _, note := range r.compatibility() {
+			fmt.Printf("  warning: %s\n", note)
+		}
+		if i == 0 {
+			continue
+		}
+		if !r.hasFeature("per_second") || !reports[0].hasFeature("per_second") {
+			fmt.Printf("  clock offset vs %s: no per-second series, assuming synchronized clocks\n", labels[0])
+			continue
+		}
+		lag, corr, ok := estimateClockOffset(reports[0], r, int(maxLagFlag.Seconds()))
+		if !ok {
+			fmt.Printf("  clock offset vs %s: not detectable, assuming synchronized clocks\n", labels[0])
+			continue
+		}
+		offsets[i] = time.Duration(lag) * time.Second
+		fmt.Printf("  clock offset vs %s: %+ds (traffic correlation %.2f)\n", labels[0], lag, corr)
+	}
+
+	printMergedBuckets(reports, labels, offsets)
+	return 0
+}
+
+// Estimating how far b's clock runs ahead of a's by correlating their
+// per-second packet counts. Only trusted when traffic clearly lines up.
+func estimateClockOffset(a, b *Report, maxLag int) (int, float64, bool) {
+	if len(a.PacketsPerSecond) < 30 || len(b.PacketsPerSecond) < 30 {
+		return 0, 0, false
+	}
+	// b's second j happened at a's second j + shift - lag
+	shift := int(b.Start.Sub(a.Start).Round(time.Second) / time.Second)
+
+	best, bestCorr := 0, -1.0
+	for lag := -maxLag; lag <= maxLag; lag++ {
+		var xs, ys []float64
+		for j, n := range b.PacketsPerSecond {
+			i := j + shift - lag
+			if i >= 0 && i < len(a.PacketsPerSecond) {
+				xs = append(xs, float64(a.PacketsPerSecond[i]))
+				ys = append(ys, float64(n))
+			}
+		}
+		if len(xs) < 30 {
+			continue
+		}
+		if c := correlation(xs, ys); c > bestCorr {
+			best, bestCorr = lag, c
+		}
+	}
+	return best, bestCorr, bestCorr >= 0.6
+}
+
+// Pearson correlation, zero when either series is flat
+func correlation(xs, ys []float64) float64 {
+	n := float64(len(xs))
+	var mx, my float64
+	for i := range xs {
+		mx += xs[i]
+		my += ys[i]
+	}
+	mx /= n
+	my /= n
+	var cov, vx, vy float64
+	for i := range xs {
+		dx, dy := xs[i]-mx, ys[i]-my
+		cov += dx * dy
+		vx += dx * dx
+		vy += dy * dy
+	}
+	if vx == 0 || vy == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(vx*vy)
+}
+
+// Placing every host's buckets on the first report's bucket grid
+func printMergedBuckets(reports []*Report, labels []string, offsets []time.Duration) {
+	// Hosts may use different -bucket intervals, the coarsest one fits them all
+	step := time.Duration(reports[0].BucketSeconds) * time.Second
+	for _, r := range reports[1:] {
+		if s := time.Duration(r.BucketSeconds) * time.Second; s != step {
+			fmt.Printf("Bucket intervals differ, merging into %v buckets\n", max(s, step))
+			step = max(s, step)
+		}
+	}
+	origin := reports[0].Start
+
+	type cell struct {
+		packets int
+		bytes   float64
+		seen    bool
+	}
+	grid := make(map[int][]cell)
+	lo, hi := 0, 0
+	for i, r := range reports {
+		for _, b := range r.Buckets {
+			mid := b.Start.Add(-offsets[i]).Add(time.Duration(b.Seconds*float64(time.Second)) / 2)
+			slot := int(math.Floor(float64(mid.Sub(origin)) / float64(step)))
+			if grid[slot] == nil {
+				grid[slot] = make([]cell, len(reports))
+			}
+			c := &grid[slot][i]
+			c.packets += b.Packets
+			c.bytes += b.Bytes
+			c.seen = true
+			lo, hi = min(lo, slot), max(hi, slot)
+		}
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	header := fmt.Sprintf("%-8s", "time")
+	for i := range reports {
+		header += fmt.Sprintf(" | %-24s", labels[i])
+	}
+	fmt.Println(header)
+	for slot := lo; slot <= hi; slot++ {
+		row := fmt.Sprintf("%-8s", origin.Add(time.Duration(slot)*step).Format("15:04:05"))
+		cells := grid[slot]
+		for i := range reports {
+			if cells == nil || !cells[i].seen {
+				row += fmt.Sprintf(" | %-24s", "-")
+				continue
+			}
+			row += fmt.Sprintf(" | %-24s", fmt.Sprintf("%d pkts %.2f MB", cells[i].packets, cells[i].bytes/(1024*1024)))
+		}
+		fmt.Println(row)
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	ref := reports[0]
+	for i, r := range reports {
+		line := fmt.Sprintf("TOTAL %s: %d packets | %.2f MB", labels[i], r.TotalPackets, r.TotalBytes/(1024*1024))
+		// Sender vs receiver view: the gap is traffic seen on one side only
+		if i > 0 && ref.TotalPackets > 0 {
+			diff := float64(r.TotalPackets-ref.TotalPackets) / float64(ref.TotalPackets) * 100
+			line += fmt.Sprintf(" (%+.1f%% packets vs %s)", diff, labels[0])
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,121 @@
+package linux
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const bondingDir = "/proc/net/bonding"
+
+type BondStatus struct {
+	Name        string
+	Mode        string
+	MIIStatus   string
+	ActiveSlave string
+	PartnerMAC  string
+	Slaves      []BondSlave
+}
+
+type BondSlave struct {
+	Name         string
+	MIIStatus    string
+	LinkFailures int
+	AggregatorID string
+	PartnerMAC   string
+	PartnerKey   string
+	ActorState   string
+	PartnerState string
+}
+
+// Listing bond devices known to the bonding driver
+func GetBonds() ([]string, error) {
+	entries, err := os.ReadDir(bondingDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %v", bondingDir, err)
+	}
+
+	var bonds []string
+	for _, e := range entries {
+		bonds = append(bonds, e.Name())
+	}
+	return bonds, nil
+}
+
+// Parsing /proc/net/bonding/<name>
+func ReadBond(name string) (*BondStatus, error) {
+	file, err := os.Open(filepath.Join(bondingDir, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bond %s: %v", name, err)
+	}
+	defer file.Close()
+
+	bond := &BondStatus{Name: name}
+	var slave *BondSlave
+	// LACP details come in "actor" and "partner" blocks per slave
+	section := ""
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		switch {
+		case key == "Slave Interface":
+			bond.Slaves = append(bond.Slaves, BondSlave{Name: value})
+			slave = &bond.Slaves[len(bond.Slaves)-1]
+			section = ""
+		case strings.HasPrefix(key, "details actor"):
+			section = "actor"
+		case strings.HasPrefix(key, "details partner"):
+			section = "partner"
+		case slave == nil:
+			switch key {
+			case "Bonding Mode":
+				bond.Mode = value
+			case "MII Status":
+				bond.MIIStatus = value
+			case "Currently Active Slave":
+				bond.ActiveSlave = value
+			case "Partner Mac Address":
+				bond.PartnerMAC = value
+			}
+		default:
+			switch key {
+			case "MII Status":
+				slave.MIIStatus = value
+			case "Link Failure Count":
+				slave.LinkFailures, _ = strconv.Atoi(value)
+			case "Aggregator ID":
+				slave.AggregatorID = value
+			case "system mac address":
+				if section == "partner" {
+					slave.PartnerMAC = value
+				}
+			case "oper key":
+				if section == "partner" {
+					slave.PartnerKey = value
+				}
+			case "port state":
+				if section == "actor" {
+					slave.ActorState = value
+				} else if section == "partner" {
+					slave.PartnerState = value
+				}
+			}
+		}
+	}
+
+	return bond, scanner.Err()
+}
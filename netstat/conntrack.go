@@ -0,0 +1,73 @@
+package linux
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type ConntrackStats struct {
+	Count        uint64
+	Max          uint64
+	Inserted     uint64
+	InsertFailed uint64
+	Dropped      uint64
+	EarlyDropped uint64
+}
+
+// Reading nf_conntrack table usage and the per-CPU statistics summed up
+func GetConntrack() (*ConntrackStats, error) {
+	count, err := readUint("/proc/sys/net/netfilter/nf_conntrack_count")
+	if err != nil {
+		return nil, err
+	}
+	max, err := readUint("/proc/sys/net/netfilter/nf_conntrack_max")
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &ConntrackStats{Count: count, Max: max}
+
+	file, err := os.Open("/proc/net/stat/nf_conntrack")
+	if err != nil {
+		// Table usage alone is still useful
+		return stats, nil
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return stats, nil
+	}
+	header := strings.Fields(scanner.Text())
+	sums := make(map[string]uint64)
+	for scanner.Scan() {
+		values := strings.Fields(scanner.Text())
+		for i := 0; i < len(header) && i < len(values); i++ {
+			n, err := strconv.ParseUint(values[i], 16, 64)
+			if err == nil {
+				sums[header[i]] += n
+			}
+		}
+	}
+
+	// Newer kernels stopped updating "new", "insert" counts the same thing
+	stats.Inserted = sums["insert"]
+	if sums["new"] > stats.Inserted {
+		stats.Inserted = sums["new"]
+	}
+	stats.InsertFailed = sums["insert_failed"]
+	stats.Dropped = sums["drop"]
+	stats.EarlyDropped = sums["early_drop"]
+	return stats, scanner.Err()
+}
+
+func readUint(path string) (uint64, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(raw)), 10, 64)
+}
@@ -0,0 +1,90 @@
+package linux
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Reading driver statistics with ethtool -S, falling back to the
+// generic sysfs counters when ethtool is missing or unsupported
+func GetNICStats(iface string) (map[string]uint64, error) {
+	out, err := exec.Command("ethtool", "-S", iface).Output()
+	if err == nil {
+		stats := parseEthtoolStats(out)
+		if len(stats) > 0 {
+			return stats, nil
+		}
+	}
+	return sysfsStats(iface)
+}
+
+func parseEthtoolStats(out []byte) map[string]uint64 {
+	stats := make(map[string]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			continue
+		}
+		stats[strings.TrimSpace(key)] = n
+	}
+	return stats
+}
+
+func sysfsStats(iface string) (map[string]uint64, error) {
+	dir := filepath.Join("/sys/class/net", iface, "statistics")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", dir, err)
+	}
+
+	stats := make(map[string]uint64)
+	for _, e := range entries {
+		raw, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		if n, err := strconv.ParseUint(strings.TrimSpace(string(raw)), 10, 64); err == nil {
+			stats[e.Name()] = n
+		}
+	}
+	return stats, nil
+}
+
+// Reading offload settings with ethtool -k
+func GetOffloads(iface string) (map[string]string, error) {
+	out, err := exec.Command("ethtool", "-k", iface).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ethtool -k %s failed: %v", iface, err)
+	}
+
+	offloads := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Nested entries are indented, only keep the top level features
+		if strings.HasPrefix(line, "\t") || strings.HasPrefix(line, " ") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		offloads[strings.TrimSpace(key)] = strings.Fields(value)[0]
+	}
+	return offloads, scanner.Err()
+}
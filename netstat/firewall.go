@@ -0,0 +1,156 @@
+package linux
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// FirewallCounter is the packet and byte counter of one drop or reject rule
+type FirewallCounter struct {
+	Table   string
+	Chain   string
+	Rule    string
+	Action  string
+	Packets uint64
+	Bytes   uint64
+}
+
+// Key identifies the rule across two samples
+func (c FirewallCounter) Key() string {
+	return c.Table + "/" + c.Chain + "/" + c.Rule
+}
+
+// Reading drop/reject counters from nftables, falling back to iptables
+func GetFirewallCounters() ([]FirewallCounter, error) {
+	if counters, err := nftCounters(); err == nil && len(counters) > 0 {
+		return counters, nil
+	}
+
+	var counters []FirewallCounter
+	var lastErr error
+	for _, tool := range []string{"iptables", "ip6tables"} {
+		c, err := iptablesCounters(tool)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		counters = append(counters, c...)
+	}
+	if counters == nil && lastErr != nil {
+		return nil, lastErr
+	}
+	return counters, nil
+}
+
+func nftCounters() ([]FirewallCounter, error) {
+	out, err := exec.Command("nft", "-j", "list", "ruleset").Output()
+	if err != nil {
+		return nil, fmt.Errorf("nft list ruleset failed: %v", err)
+	}
+
+	var ruleset struct {
+		Nftables []struct {
+			Rule *struct {
+				Family  string                       `json:"family"`
+				Table   string                       `json:"table"`
+				Chain   string                       `json:"chain"`
+				Handle  int                          `json:"handle"`
+				Comment string                       `json:"comment"`
+				Expr    []map[string]json.RawMessage `json:"expr"`
+			} `json:"rule"`
+		} `json:"nftables"`
+	}
+	if err := json.Unmarshal(out, &ruleset); err != nil {
+		return nil, fmt.Errorf("failed to parse nft ruleset: %v", err)
+	}
+
+	var counters []FirewallCounter
+	for _, item := range ruleset.Nftables {
+		r := item.Rule
+		if r == nil {
+			continue
+		}
+
+		var counter *struct {
+			Packets uint64 `json:"packets"`
+			Bytes   uint64 `json:"bytes"`
+		}
+		action := ""
+		for _, expr := range r.Expr {
+			if raw, ok := expr["counter"]; ok {
+				json.Unmarshal(raw, &counter)
+			}
+			if _, ok := expr["drop"]; ok {
+				action = "drop"
+			}
+			if _, ok := expr["reject"]; ok {
+				action = "reject"
+			}
+		}
+		if action == "" || counter == nil {
+			continue
+		}
+
+		rule := "handle " + strconv.Itoa(r.Handle)
+		if r.Comment != "" {
+			rule += " (" + r.Comment + ")"
+		}
+		counters = append(counters, FirewallCounter{
+			Table:   r.Family + " " + r.Table,
+			Chain:   r.Chain,
+			Rule:    rule,
+			Action:  action,
+			Packets: counter.Packets,
+			Bytes:   counter.Bytes,
+		})
+	}
+	return counters, nil
+}
+
+// Parsing iptables -L -v -x -n --line-numbers for the filter table
+func iptablesCounters(tool string) ([]FirewallCounter, error) {
+	out, err := exec.Command(tool, "-L", "-v", "-x", "-n", "--line-numbers").Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s -L failed: %v", tool, err)
+	}
+
+	var counters []FirewallCounter
+	chain := ""
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// Chain INPUT (policy DROP 12 packets, 720 bytes)
+		if fields[0] == "Chain" && len(fields) >= 2 {
+			chain = fields[1]
+			if len(fields) >= 8 && fields[2] == "(policy" && (fields[3] == "DROP" || fields[3] == "REJECT") {
+				packets, _ := strconv.ParseUint(fields[4], 10, 64)
+				byteCount, _ := strconv.ParseUint(fields[6], 10, 64)
+				counters = append(counters, FirewallCounter{tool + " filter", chain, "policy", strings.ToLower(fields[3]), packets, byteCount})
+			}
+			continue
+		}
+
+		// num pkts bytes target prot opt in out source destination [match...]
+		if len(fields) < 9 || (fields[3] != "DROP" && fields[3] != "REJECT") {
+			continue
+		}
+		packets, err1 := strconv.ParseUint(fields[1], 10, 64)
+		byteCount, err2 := strconv.ParseUint(fields[2], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		rule := "#" + fields[0] + " " + strings.Join(fields[4:], " ")
+		counters = append(counters, FirewallCounter{tool + " filter", chain, rule, strings.ToLower(fields[3]), packets, byteCount})
+	}
+	return counters, scanner.Err()
+}
@@ -0,0 +1,111 @@
+package linux
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HostapdStation is a client as reported by a hostapd control socket
+type HostapdStation struct {
+	MAC     string
+	User    string // 802.1X identity, empty for PSK networks
+	RxBytes uint64
+	TxBytes uint64
+}
+
+// Listing hostapd control sockets, one per interface, in dir (usually /var/run/hostapd)
+func GetHostapdSockets(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var sockets []string
+	for _, e := range entries {
+		if e.Type()&os.ModeSocket != 0 {
+			sockets = append(sockets, filepath.Join(dir, e.Name()))
+		}
+	}
+	return sockets, nil
+}
+
+// Walking the station table with STA-FIRST / STA-NEXT
+func GetHostapdStations(socket string) ([]HostapdStation, error) {
+	local := filepath.Join(os.TempDir(), fmt.Sprintf("netwatchd-hostapd-%d-%d", os.Getpid(), time.Now().UnixNano()))
+	conn, err := net.DialUnix("unixgram",
+		&net.UnixAddr{Name: local, Net: "unixgram"},
+		&net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", socket, err)
+	}
+	defer os.Remove(local)
+	defer conn.Close()
+
+	var stations []HostapdStation
+	cmd := "STA-FIRST"
+	for range 4096 {
+		reply, err := hostapdRequest(conn, cmd)
+		if err != nil {
+			return stations, err
+		}
+		st, ok := parseHostapdStation(reply)
+		if !ok {
+			break
+		}
+		stations = append(stations, st)
+		cmd = "STA-NEXT " + st.MAC
+	}
+	return stations, nil
+}
+
+func hostapdRequest(conn *net.UnixConn, cmd string) (string, error) {
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		return "", fmt.Errorf("hostapd %s: %v", cmd, err)
+	}
+	buf := make([]byte, 8192)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return "", fmt.Errorf("hostapd %s: %v", cmd, err)
+		}
+		// Unsolicited event messages start with <level>
+		if n > 0 && buf[0] == '<' {
+			continue
+		}
+		return string(buf[:n]), nil
+	}
+}
+
+// A station block is its MAC on the first line followed by key=value lines
+func parseHostapdStation(reply string) (HostapdStation, bool) {
+	lines := strings.Split(strings.TrimSpace(reply), "\n")
+	if len(lines) == 0 {
+		return HostapdStation{}, false
+	}
+	mac := strings.ToLower(strings.TrimSpace(lines[0]))
+	if _, err := net.ParseMAC(mac); err != nil {
+		return HostapdStation{}, false
+	}
+
+	st := HostapdStation{MAC: mac}
+	for _, line := range lines[1:] {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "rx_bytes":
+			st.RxBytes, _ = strconv.ParseUint(value, 10, 64)
+		case "tx_bytes":
+			st.TxBytes, _ = strconv.ParseUint(value, 10, 64)
+		case "dot1xAuthSessionUserName":
+			st.User = value
+		}
+	}
+	return st, true
+}
@@ -9,20 +9,47 @@ import (
 )
 
 type NetstatMonitor struct {
-	interfaces map[string]*InterfaceStats
+	interfaces map[string]*InterfaceSnapshot // previous read
 }
 
-type InterfaceStats struct {
-	Name     string
-	RxBytes  uint64
-	TxBytes  uint64
-	LastRx   uint64
-	LastTx   uint64
+// InterfaceSnapshot is one interface's line of /proc/net/dev, the counts
+// since the interface came up
+type InterfaceSnapshot struct {
+	Name      string
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	RxDropped uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+	TxDropped uint64
+}
+
+// Counter types by their PDH names, so callers can ask every backend the same
+var counterTypes = map[string]func(s *InterfaceSnapshot) uint64{
+	"Bytes Sent/sec":             func(s *InterfaceSnapshot) uint64 { return s.TxBytes },
+	"Bytes Received/sec":         func(s *InterfaceSnapshot) uint64 { return s.RxBytes },
+	"Packets Sent/sec":           func(s *InterfaceSnapshot) uint64 { return s.TxPackets },
+	"Packets Received/sec":       func(s *InterfaceSnapshot) uint64 { return s.RxPackets },
+	"Packets Outbound Errors":    func(s *InterfaceSnapshot) uint64 { return s.TxErrors },
+	"Packets Received Errors":    func(s *InterfaceSnapshot) uint64 { return s.RxErrors },
+	"Packets Outbound Discarded": func(s *InterfaceSnapshot) uint64 { return s.TxDropped },
+	"Packets Received Discarded": func(s *InterfaceSnapshot) uint64 { return s.RxDropped },
+}
+
+// Value returns the count behind a counter type, false for an unknown type
+func (s *InterfaceSnapshot) Value(counterType string) (uint64, bool) {
+	f, ok := counterTypes[counterType]
+	if !ok {
+		return 0, false
+	}
+	return f(s), true
 }
 
 type Counter struct {
 	interfaceName string
-	counterType   string // "rx" or "tx"
+	counterType   string // a key of counterTypes
 	monitor       *NetstatMonitor
 }
 
@@ -71,7 +98,7 @@ func GetNetworkAdapters() ([]string, error) {
 
 func NewMonitor() *NetstatMonitor {
 	return &NetstatMonitor{
-		interfaces: make(map[string]*InterfaceStats),
+		interfaces: make(map[string]*InterfaceSnapshot),
 	}
 }
 
@@ -84,7 +111,8 @@ func NewCounter(adapterName, counterType string) (*Counter, error) {
 		return nil, err
 	}
 	
-	found := false
+	// Loopback is only left out of auto-selection
+	found := adapterName == "lo"
 	for _, adapter := range adapters {
 		if adapter == adapterName {
 			found = true
@@ -96,19 +124,13 @@ func NewCounter(adapterName, counterType string) (*Counter, error) {
 		return nil, fmt.Errorf("network adapter '%s' not found", adapterName)
 	}
 
-	var cType string
-	switch counterType {
-	case "Bytes Sent/sec":
-		cType = "tx"
-	case "Bytes Received/sec":
-		cType = "rx"
-	default:
+	if _, ok := counterTypes[counterType]; !ok {
 		return nil, fmt.Errorf("unsupported counter type: %s", counterType)
 	}
 
 	return &Counter{
 		interfaceName: adapterName,
-		counterType:   cType,
+		counterType:   counterType,
 		monitor:       monitor,
 	}, nil
 }
@@ -117,73 +139,91 @@ func CollectData() error {
 	return nil
 }
 
-func (c *Counter) GetValue() (float64, error) {
+// Reading every interface's counters from /proc/net/dev in one pass
+func GetInterfaceSnapshots() (map[string]*InterfaceSnapshot, error) {
 	file, err := os.Open("/proc/net/dev")
 	if err != nil {
-		return 0, fmt.Errorf("failed to open /proc/net/dev: %v", err)
+		return nil, fmt.Errorf("failed to open /proc/net/dev: %v", err)
 	}
 	defer file.Close()
 
+	snapshots := make(map[string]*InterfaceSnapshot)
 	scanner := bufio.NewScanner(file)
-	
+
 	// Skip header lines
 	scanner.Scan()
 	scanner.Scan()
 
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
-		}
-
-		parts := strings.Fields(line)
-		if len(parts) < 17 {
-			continue
-		}
-
-		interfaceName := strings.TrimSuffix(parts[0], ":")
-		if interfaceName != c.interfaceName {
+		// Old kernels print wide counters right after the colon
+		name, rest, ok := strings.Cut(scanner.Text(), ":")
+		parts := strings.Fields(rest)
+		if !ok || len(parts) < 16 {
 			continue
 		}
+		name = strings.TrimSpace(name)
 
-		// Parse RX and TX bytes (col 1 & 9)
-		rxBytes, err1 := strconv.ParseUint(parts[1], 10, 64)
-		txBytes, err2 := strconv.ParseUint(parts[9], 10, 64)
-
-		if err1 != nil || err2 != nil {
-			return 0, fmt.Errorf("failed to parse network stats for %s", interfaceName)
-		}
-
-		// Get or create interface stats
-		stats, exists := c.monitor.interfaces[interfaceName]
-		if !exists {
-			stats = &InterfaceStats{
-				Name:   interfaceName,
-				LastRx: rxBytes,
-				LastTx: txBytes,
+		// Receive columns come first, transmit from column 8, both start with bytes, packets, errs, drop
+		var v [16]uint64
+		for i := range v {
+			if v[i], err = strconv.ParseUint(parts[i], 10, 64); err != nil {
+				return nil, fmt.Errorf("failed to parse network stats for %s", name)
 			}
-			c.monitor.interfaces[interfaceName] = stats
-			return 0, nil
 		}
-
-		// Calculate bytes per second since last reading
-		var bytesPerSec float64
-		if c.counterType == "rx" {
-			bytesPerSec = float64(rxBytes - stats.LastRx)
-			stats.LastRx = rxBytes
-		} else {
-			bytesPerSec = float64(txBytes - stats.LastTx)
-			stats.LastTx = txBytes
+		snapshots[name] = &InterfaceSnapshot{
+			Name:      name,
+			RxBytes:   v[0],
+			RxPackets: v[1],
+			RxErrors:  v[2],
+			RxDropped: v[3],
+			TxBytes:   v[8],
+			TxPackets: v[9],
+			TxErrors:  v[10],
+			TxDropped: v[11],
 		}
+	}
+	return snapshots, scanner.Err()
+}
 
-		stats.RxBytes = rxBytes
-		stats.TxBytes = txBytes
+// Reading all counters of one interface
+func GetInterfaceSnapshot(iface string) (*InterfaceSnapshot, error) {
+	snapshots, err := GetInterfaceSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	s, ok := snapshots[iface]
+	if !ok {
+		return nil, fmt.Errorf("interface %s not found in /proc/net/dev", iface)
+	}
+	return s, nil
+}
+
+// Returning the growth of the counter since the previous read, the first read only records the baseline
+func (c *Counter) GetValue() (float64, error) {
+	if c.monitor == nil {
+		return 0, fmt.Errorf("counter for %s closed", c.interfaceName)
+	}
+	current, err := GetInterfaceSnapshot(c.interfaceName)
+	if err != nil {
+		return 0, err
+	}
 
-		return bytesPerSec, nil
+	last, exists := c.monitor.interfaces[c.interfaceName]
+	c.monitor.interfaces[c.interfaceName] = current
+	if !exists {
+		return 0, nil
 	}
 
-	return 0, fmt.Errorf("interface %s not found in /proc/net/dev", c.interfaceName)
+	now, _ := current.Value(c.counterType)
+	before, _ := last.Value(c.counterType)
+	// The counts restart when the interface is recreated
+	if now < before {
+		return 0, nil
+	}
+	return float64(now - before), nil
 }
 
+// Dropping the previous read, later reads fail
 func (c *Counter) Close() {
+	c.monitor = nil
 }
@@ -0,0 +1,157 @@
+package linux
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// Listing the ports attached to a Linux bridge
+func GetBridgePorts(bridge string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join("/sys/class/net", bridge, "brif"))
+	if err != nil {
+		return nil, err
+	}
+	var ports []string
+	for _, e := range entries {
+		ports = append(ports, e.Name())
+	}
+	return ports, nil
+}
+
+// Describing the workload on the other end of a veth or tap interface,
+// e.g. "container web (3f2a1b2c3d4e)" or "vm db01". Empty when unknown.
+func ResolvePeer(iface string) string {
+	if name := libvirtDomain(iface); name != "" {
+		return "vm " + name
+	}
+
+	ifindex, err1 := readUint(filepath.Join("/sys/class/net", iface, "ifindex"))
+	iflink, err2 := readUint(filepath.Join("/sys/class/net", iface, "iflink"))
+	if err1 != nil || err2 != nil || ifindex == iflink {
+		return ""
+	}
+
+	pid := findNetnsWithIndex(iflink)
+	if pid == "" {
+		return ""
+	}
+	return describeProcess(pid)
+}
+
+// libvirt keeps the live XML of running guests, the target dev names the tap
+func libvirtDomain(iface string) string {
+	for _, dir := range []string{"/run/libvirt/qemu", "/var/run/libvirt/qemu"} {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.xml"))
+		for _, path := range files {
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			xml := string(raw)
+			if strings.Contains(xml, "dev='"+iface+"'") || strings.Contains(xml, `dev="`+iface+`"`) {
+				return strings.TrimSuffix(filepath.Base(path), ".xml")
+			}
+		}
+	}
+	return ""
+}
+
+// Finding a process in another network namespace that has an interface
+// with the given index, using per-namespace files under /proc/<pid>/net
+func findNetnsWithIndex(index uint64) string {
+	self, _ := os.Readlink("/proc/self/ns/net")
+	seen := map[string]bool{self: true}
+
+	procs, _ := os.ReadDir("/proc")
+	for _, p := range procs {
+		pid := p.Name()
+		if _, err := strconv.Atoi(pid); err != nil {
+			continue
+		}
+		ns, err := os.Readlink(filepath.Join("/proc", pid, "ns/net"))
+		if err != nil || seen[ns] {
+			continue
+		}
+		seen[ns] = true
+
+		if netnsHasIndex(pid, index) {
+			return pid
+		}
+	}
+	return ""
+}
+
+func netnsHasIndex(pid string, index uint64) bool {
+	// /proc/<pid>/net/igmp: "2	eth0      :     1      V3"
+	if file, err := os.Open(filepath.Join("/proc", pid, "net/igmp")); err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) > 0 {
+				if n, err := strconv.ParseUint(fields[0], 10, 64); err == nil && n == index {
+					return true
+				}
+			}
+		}
+	}
+
+	// /proc/<pid>/net/if_inet6: address, ifindex (hex), prefix, scope, flags, name
+	if file, err := os.Open(filepath.Join("/proc", pid, "net/if_inet6")); err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) > 1 {
+				if n, err := strconv.ParseUint(fields[1], 16, 64); err == nil && n == index {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func describeProcess(pid string) string {
+	comm, _ := os.ReadFile(filepath.Join("/proc", pid, "comm"))
+	name := strings.TrimSpace(string(comm))
+
+	cgroup, _ := os.ReadFile(filepath.Join("/proc", pid, "cgroup"))
+	id := containerIDPattern.FindString(string(cgroup))
+	if id == "" {
+		return fmt.Sprintf("netns of pid %s (%s)", pid, name)
+	}
+
+	if docker := dockerContainerName(id); docker != "" {
+		name = docker
+	} else if hostname, err := os.ReadFile(filepath.Join("/proc", pid, "root/etc/hostname")); err == nil {
+		// Kubernetes sets the pod name as hostname
+		name = strings.TrimSpace(string(hostname))
+	}
+	if strings.Contains(string(cgroup), "kubepods") {
+		return fmt.Sprintf("pod %s (%s)", name, id[:12])
+	}
+	return fmt.Sprintf("container %s (%s)", name, id[:12])
+}
+
+func dockerContainerName(id string) string {
+	raw, err := os.ReadFile(filepath.Join("/var/lib/docker/containers", id, "config.v2.json"))
+	if err != nil {
+		return ""
+	}
+	var config struct {
+		Name string `json:"Name"`
+	}
+	if json.Unmarshal(raw, &config) != nil {
+		return ""
+	}
+	return strings.TrimPrefix(config.Name, "/")
+}
@@ -0,0 +1,87 @@
+package linux
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type Route struct {
+	Iface   string
+	Gateway string
+	Metric  int
+}
+
+// Reading default routes from /proc/net/route
+func GetDefaultRoutes() ([]Route, error) {
+	file, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open /proc/net/route: %v", err)
+	}
+	defer file.Close()
+
+	var routes []Route
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // header
+
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		metric, _ := strconv.Atoi(fields[6])
+		routes = append(routes, Route{Iface: fields[0], Gateway: hexToIP(fields[2]), Metric: metric})
+	}
+	return routes, scanner.Err()
+}
+
+// Listing the next hops of all default routes
+func GetDefaultGateways() ([]string, error) {
+	routes, err := GetDefaultRoutes()
+	if err != nil {
+		return nil, err
+	}
+	var gateways []string
+	for _, r := range routes {
+		if r.Gateway != "" && r.Gateway != "0.0.0.0" {
+			gateways = append(gateways, r.Gateway)
+		}
+	}
+	return gateways, nil
+}
+
+// The kernel prints addresses as little endian hex
+func hexToIP(s string) string {
+	raw, err := hex.DecodeString(s)
+	if err != nil || len(raw) != 4 {
+		return ""
+	}
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(raw))
+	return ip.String()
+}
+
+// Reading configured DNS servers from /etc/resolv.conf
+func GetNameservers() ([]string, error) {
+	file, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open /etc/resolv.conf: %v", err)
+	}
+	defer file.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers, scanner.Err()
+}
@@ -0,0 +1,98 @@
+//go:build linux
+
+package linux
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Multicast groups from linux/rtnetlink.h, not exported by syscall
+const (
+	rtmgrpIPv4Route = 0x40
+	rtmgrpIPv6Route = 0x400
+)
+
+type RouteEvent struct {
+	Time   time.Time
+	Added  bool
+	Prefix string
+}
+
+// Subscribing to kernel route updates over netlink. Routing daemons like
+// FRR and BIRD install their routes here, so this sees BGP churn as well.
+func WatchRoutes(ctx context.Context, events func(RouteEvent)) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("failed to open netlink socket: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	addr := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpIPv4Route | rtmgrpIPv6Route,
+	}
+	if err := syscall.Bind(fd, addr); err != nil {
+		return fmt.Errorf("failed to bind netlink socket: %v", err)
+	}
+
+	// A receive timeout lets the loop notice cancellation
+	tv := syscall.Timeval{Sec: 1}
+	syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+
+	buf := make([]byte, 1<<16)
+	for ctx.Err() == nil {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			return fmt.Errorf("netlink receive failed: %v", err)
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		now := time.Now()
+		for _, m := range msgs {
+			if m.Header.Type != syscall.RTM_NEWROUTE && m.Header.Type != syscall.RTM_DELROUTE {
+				continue
+			}
+			if len(m.Data) < syscall.SizeofRtMsg {
+				continue
+			}
+			rt := (*syscall.RtMsg)(unsafe.Pointer(&m.Data[0]))
+			// Local and broadcast routes follow address changes, not routing
+			if rt.Table == syscall.RT_TABLE_LOCAL {
+				continue
+			}
+			events(RouteEvent{
+				Time:   now,
+				Added:  m.Header.Type == syscall.RTM_NEWROUTE,
+				Prefix: routePrefix(&m, rt),
+			})
+		}
+	}
+	return nil
+}
+
+func routePrefix(m *syscall.NetlinkMessage, rt *syscall.RtMsg) string {
+	attrs, err := syscall.ParseNetlinkRouteAttr(m)
+	if err != nil {
+		return ""
+	}
+	for _, a := range attrs {
+		if a.Attr.Type == syscall.RTA_DST {
+			return fmt.Sprintf("%s/%d", net.IP(a.Value), rt.Dst_len)
+		}
+	}
+	if rt.Family == syscall.AF_INET6 {
+		return "::/0"
+	}
+	return "0.0.0.0/0"
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package linux
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type RouteEvent struct {
+	Time   time.Time
+	Added  bool
+	Prefix string
+}
+
+func WatchRoutes(ctx context.Context, events func(RouteEvent)) error {
+	return fmt.Errorf("route monitoring needs netlink")
+}
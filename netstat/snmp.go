@@ -0,0 +1,53 @@
+package linux
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Reading the kernel protocol counters from /proc/net/snmp and /proc/net/netstat.
+// Keys are "<Group>.<Name>", e.g. "TcpExt.ListenOverflows" or "Tcp.RetransSegs".
+func GetProtocolCounters() (map[string]uint64, error) {
+	counters := make(map[string]uint64)
+	for _, path := range []string{"/proc/net/snmp", "/proc/net/netstat"} {
+		if err := readCounterPairs(path, counters); err != nil {
+			return nil, err
+		}
+	}
+	return counters, nil
+}
+
+// Both files alternate a header line and a value line per group
+func readCounterPairs(path string, counters map[string]uint64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		header := strings.Fields(scanner.Text())
+		if !scanner.Scan() {
+			break
+		}
+		values := strings.Fields(scanner.Text())
+		if len(header) == 0 || len(header) != len(values) || header[0] != values[0] {
+			continue
+		}
+
+		group := strings.TrimSuffix(header[0], ":")
+		for i := 1; i < len(header); i++ {
+			// Tcp.MaxConn is -1 on Linux, skip anything that isn't a counter
+			n, err := strconv.ParseUint(values[i], 10, 64)
+			if err != nil {
+				continue
+			}
+			counters[group+"."+header[i]] = n
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,125 @@
+package linux
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// WirelessInterface is an access point or station interface and the bridge it sits on
+type WirelessInterface struct {
+	Name   string
+	SSID   string
+	Bridge string
+}
+
+// Station is an associated wireless client. Bytes are as seen by the AP.
+type Station struct {
+	MAC     string
+	RxBytes uint64
+	TxBytes uint64
+	Signal  string
+}
+
+// Listing wireless interfaces with iw, falling back to sysfs without SSIDs
+func GetWirelessInterfaces() ([]WirelessInterface, error) {
+	var ifaces []WirelessInterface
+	if out, err := exec.Command("iw", "dev").Output(); err == nil {
+		ifaces = parseIwDev(out)
+	} else {
+		entries, err := os.ReadDir("/sys/class/net")
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if _, err := os.Stat(filepath.Join("/sys/class/net", e.Name(), "phy80211")); err == nil {
+				ifaces = append(ifaces, WirelessInterface{Name: e.Name()})
+			}
+		}
+	}
+
+	for i := range ifaces {
+		if master, err := os.Readlink(filepath.Join("/sys/class/net", ifaces[i].Name, "master")); err == nil {
+			ifaces[i].Bridge = filepath.Base(master)
+		}
+	}
+	return ifaces, nil
+}
+
+func parseIwDev(out []byte) []WirelessInterface {
+	var ifaces []WirelessInterface
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "Interface":
+			ifaces = append(ifaces, WirelessInterface{Name: fields[1]})
+		case "ssid":
+			if len(ifaces) > 0 {
+				_, ssid, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+				ifaces[len(ifaces)-1].SSID = ssid
+			}
+		}
+	}
+	return ifaces
+}
+
+// Reading per client counters with iw station dump
+func GetStations(iface string) (map[string]Station, error) {
+	out, err := exec.Command("iw", "dev", iface, "station", "dump").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseStationDump(out), nil
+}
+
+func parseStationDump(out []byte) map[string]Station {
+	stations := make(map[string]Station)
+	var current *Station
+	flush := func() {
+		if current != nil {
+			stations[current.MAC] = *current
+		}
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "Station ") {
+			flush()
+			fields := strings.Fields(line)
+			current = &Station{MAC: strings.ToLower(fields[1])}
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || current == nil {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "rx bytes":
+			current.RxBytes, _ = strconv.ParseUint(value, 10, 64)
+		case "tx bytes":
+			current.TxBytes, _ = strconv.ParseUint(value, 10, 64)
+		case "signal":
+			current.Signal = value
+		}
+	}
+	flush()
+	return stations
+}
+
+// Reading the kernel's received and transmitted byte counters for an interface
+func InterfaceBytes(iface string) (rx, tx uint64, err error) {
+	stats, err := sysfsStats(iface)
+	if err != nil {
+		return 0, 0, err
+	}
+	return stats["rx_bytes"], stats["tx_bytes"], nil
+}
@@ -0,0 +1,70 @@
+package netwatch
+
+import "time"
+
+// Bucket is the traffic of one bucket interval
+type Bucket struct {
+	Start   time.Time `json:"start"`
+	Seconds float64   `json:"seconds"`
+	Packets int       `json:"packets"`
+	Bytes   int64     `json:"bytes"`
+}
+
+// Buckets counts packets and bytes per fixed interval, the bucket schedule
+// of both Monitor and netwatchd. Callers serialize access.
+type Buckets struct {
+	Size    time.Duration
+	Current Bucket   // the open bucket
+	Closed  []Bucket // oldest first
+	Packets int      // of every bucket, the open one included
+	Bytes   int64
+}
+
+func NewBuckets(start time.Time, size time.Duration) *Buckets {
+	return &Buckets{Size: size, Current: Bucket{Start: start}}
+}
+
+// Add counts packets totalling bytes into the open bucket
+func (b *Buckets) Add(packets int, bytes int64) {
+	b.Current.Packets += packets
+	b.Current.Bytes += bytes
+	b.Packets += packets
+	b.Bytes += bytes
+}
+
+// End returns when the open bucket ends
+func (b *Buckets) End() time.Time {
+	return b.Current.Start.Add(b.Size)
+}
+
+// Due reports whether the open bucket is over at now
+func (b *Buckets) Due(now time.Time) bool {
+	return !now.Before(b.End())
+}
+
+// Rotate closes the open bucket at its full length and opens the next one
+func (b *Buckets) Rotate() Bucket {
+	closed := b.Current
+	closed.Seconds = b.Size.Seconds()
+	b.Closed = append(b.Closed, closed)
+	b.Current = Bucket{Start: b.End()}
+	return closed
+}
+
+// Close closes the open bucket early at end, when the capture stops there
+func (b *Buckets) Close(end time.Time) Bucket {
+	closed := b.Current
+	closed.Seconds = end.Sub(closed.Start).Seconds()
+	b.Closed = append(b.Closed, closed)
+	b.Current = Bucket{Start: end}
+	return closed
+}
+
+// PacketCounts returns the packets of each closed bucket
+func (b *Buckets) PacketCounts() []int {
+	counts := make([]int, len(b.Closed))
+	for i, c := range b.Closed {
+		counts[i] = c.Packets
+	}
+	return counts
+}
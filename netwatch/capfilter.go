@@ -0,0 +1,224 @@
+package netwatch
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// packetFilter is a capture filter evaluated on decoded packets, for native
+// backends that can't hand a BPF expression to the kernel
+type packetFilter func(p *Packet) bool
+
+// Compiling the commonly used subset of pcap filter syntax: host, net, port and
+// portrange with optional src/dst, protocol names, less/greater, and/or/not and
+// parentheses, e.g. "tcp port 443 and not host 10.0.0.1"
+func parsePacketFilter(expr string) (packetFilter, error) {
+	tokens := filterTokens(expr)
+	if len(tokens) == 0 {
+		return func(*Packet) bool { return true }, nil
+	}
+	fp := &filterParser{tokens: tokens}
+	f, err := fp.or()
+	if err != nil {
+		return nil, err
+	}
+	if fp.pos < len(fp.tokens) {
+		return nil, fmt.Errorf("unexpected %q in filter", fp.tokens[fp.pos])
+	}
+	return f, nil
+}
+
+func filterTokens(expr string) []string {
+	expr = strings.NewReplacer("(", " ( ", ")", " ) ", "&&", " and ", "||", " or ", "!", " not ").Replace(expr)
+	return strings.Fields(expr)
+}
+
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (fp *filterParser) peek() string {
+	if fp.pos < len(fp.tokens) {
+		return fp.tokens[fp.pos]
+	}
+	return ""
+}
+
+func (fp *filterParser) next() string {
+	t := fp.peek()
+	fp.pos++
+	return t
+}
+
+func (fp *filterParser) or() (packetFilter, error) {
+	left, err := fp.and()
+	if err != nil {
+		return nil, err
+	}
+	for fp.peek() == "or" {
+		fp.next()
+		right, err := fp.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(p *Packet) bool { return l(p) || right(p) }
+	}
+	return left, nil
+}
+
+func (fp *filterParser) and() (packetFilter, error) {
+	left, err := fp.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		// Like pcap, "tcp port 80" is read as "tcp and port 80"
+		if t := fp.peek(); t == "and" {
+			fp.next()
+		} else if t == "" || t == "or" || t == ")" {
+			return left, nil
+		}
+		right, err := fp.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(p *Packet) bool { return l(p) && right(p) }
+	}
+}
+
+func (fp *filterParser) unary() (packetFilter, error) {
+	switch fp.peek() {
+	case "not":
+		fp.next()
+		f, err := fp.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(p *Packet) bool { return !f(p) }, nil
+	case "(":
+		fp.next()
+		f, err := fp.or()
+		if err != nil {
+			return nil, err
+		}
+		if fp.next() != ")" {
+			return nil, fmt.Errorf("missing ) in filter")
+		}
+		return f, nil
+	}
+	return fp.primitive()
+}
+
+func (fp *filterParser) primitive() (packetFilter, error) {
+	t := fp.next()
+	switch t {
+	case "":
+		return nil, fmt.Errorf("filter ends unexpectedly")
+	case "tcp", "udp":
+		return func(p *Packet) bool { return p.Transport == t }, nil
+	case "icmp", "icmp6", "arp", "stp", "vlan":
+		layer := strings.Replace(t, "icmp6", "icmpv6", 1)
+		return func(p *Packet) bool { return p.HasLayer(layer) }, nil
+	case "ip", "ip6":
+		layer := strings.Replace(t, "ip6", "ipv6", 1)
+		return func(p *Packet) bool { return p.HasLayer(layer) }, nil
+	case "less", "greater":
+		n, err := strconv.Atoi(fp.next())
+		if err != nil {
+			return nil, fmt.Errorf("%s needs a length", t)
+		}
+		if t == "less" {
+			return func(p *Packet) bool { return p.Length <= n }, nil
+		}
+		return func(p *Packet) bool { return p.Length >= n }, nil
+	}
+
+	dir := ""
+	if t == "src" || t == "dst" {
+		dir, t = t, fp.next()
+		// "src or dst" and "src and dst" qualifiers
+		if (t == "or" || t == "and") && (fp.peek() == "src" || fp.peek() == "dst") {
+			dir = "src " + t + " dst"
+			fp.next()
+			t = fp.next()
+		}
+	}
+	switch t {
+	case "host", "net":
+		return addressFilter(dir, fp.next())
+	case "port", "portrange":
+		return portFilter(dir, fp.next())
+	}
+	// The host keyword may be left out after a direction
+	if dir != "" {
+		return addressFilter(dir, t)
+	}
+	return nil, fmt.Errorf("unsupported filter primitive %q", t)
+}
+
+func addressFilter(dir, value string) (packetFilter, error) {
+	n, err := MatchNet(value)
+	if err != nil || n == nil {
+		return nil, fmt.Errorf("invalid address %q in filter", value)
+	}
+	return directional(dir, func(p *Packet, src bool) bool {
+		if src {
+			return n.Contains(net.ParseIP(p.SrcIP))
+		}
+		return n.Contains(net.ParseIP(p.DstIP))
+	}), nil
+}
+
+func portFilter(dir, value string) (packetFilter, error) {
+	from, to, isRange := strings.Cut(value, "-")
+	lo, err := strconv.Atoi(from)
+	hi := lo
+	if err == nil && isRange {
+		hi, err = strconv.Atoi(to)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q in filter", value)
+	}
+	return directional(dir, func(p *Packet, src bool) bool {
+		port := p.DstPort
+		if src {
+			port = p.SrcPort
+		}
+		return p.Transport != "" && port >= lo && port <= hi
+	}), nil
+}
+
+func directional(dir string, match func(p *Packet, src bool) bool) packetFilter {
+	switch dir {
+	case "src":
+		return func(p *Packet) bool { return match(p, true) }
+	case "dst":
+		return func(p *Packet) bool { return match(p, false) }
+	case "src and dst":
+		return func(p *Packet) bool { return match(p, true) && match(p, false) }
+	}
+	return func(p *Packet) bool { return match(p, true) || match(p, false) }
+}
+
+// MatchNet parses a CIDR or a single address, empty matches anything
+func MatchNet(s string) (*net.IPNet, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if !strings.Contains(s, "/") {
+		if ip := net.ParseIP(s); ip != nil {
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+		}
+	}
+	_, n, err := net.ParseCIDR(s)
+	return n, err
+}
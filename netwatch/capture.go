@@ -0,0 +1,126 @@
+package netwatch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// CaptureEngine is a source of parsed packets. The channel is closed when
+// the context ends or the source has nothing more to deliver.
+type CaptureEngine interface {
+	Start(ctx context.Context) (<-chan *Packet, error)
+}
+
+// TsharkEngine captures live traffic by running tshark
+type TsharkEngine struct {
+	CaptureOptions
+}
+
+// NewCaptureEngine chooses the live capture backend. auto prefers the native
+// one and falls back to tshark when raw capture isn't available here.
+func NewCaptureEngine(backend string, opts CaptureOptions) (CaptureEngine, error) {
+	switch backend {
+	case "tshark":
+		return &TsharkEngine{opts}, nil
+	case "native":
+		if err := nativeAvailable(); err != nil {
+			return nil, err
+		}
+	case "auto", "":
+		if err := nativeAvailable(); err != nil {
+			if _, lookErr := exec.LookPath("tshark"); lookErr != nil {
+				return nil, fmt.Errorf("%v, and tshark is not installed", err)
+			}
+			opts.logf("Native capture unavailable (%v), using tshark\n", err)
+			return &TsharkEngine{opts}, nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown capture backend %q, use auto, native or tshark", backend)
+	}
+	opts.logf("Note: TLS certificate, HTTP basic auth and DHCP/NetBIOS hostname checks need -capture tshark\n")
+	return &NativeEngine{opts}, nil
+}
+
+func (e *TsharkEngine) Start(ctx context.Context) (<-chan *Packet, error) {
+	args := []string{
+		"-i", e.Interface,
+		"-l",
+	}
+	// -P keeps the field output coming while tshark writes the capture
+	if e.PcapFile != "" {
+		args = append(args, "-w", e.PcapFile, "-P")
+	}
+	version := detectTsharkVersion()
+	layout := defaultLayout
+	if e.Payload {
+		layout = newFieldLayout(payloadFields...)
+	}
+	args = append(args, layout.args(version)...)
+	// RTP and BitTorrent's UDP protocols run on negotiated ports, tshark only looks for them when asked
+	if version.Major == 0 || version.Major >= 2 {
+		args = append(args, "--enable-heuristic", "rtp_udp", "--enable-heuristic", "bittorrent_dht_udp", "--enable-heuristic", "bt_utp_udp")
+	}
+
+	if e.Filter != "" {
+		args = append(args, "-f", e.Filter)
+	}
+
+	e.logf("Starting packet capture on interface %s (tshark %s)...\n", e.Interface, version)
+	if e.Filter != "" {
+		e.logf("Filter: %s\n", e.Filter)
+	}
+	e.logf("---\n")
+
+	cmd := exec.CommandContext(ctx, "tshark", args...)
+	if e.RunAs != nil {
+		runAs(cmd, e.RunAs)
+	}
+	// Interrupted rather than killed, so a pcap being written is finished
+	cmd.Cancel = func() error {
+		if err := cmd.Process.Signal(os.Interrupt); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+	cmd.WaitDelay = 5 * time.Second
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error setting up pipe: %v", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting tshark: %v", err)
+	}
+
+	packets := make(chan *Packet, 64)
+	go func() {
+		defer close(packets)
+		defer cmd.Wait()
+
+		scanner := newRecordScanner(stdout, layout)
+		defer func() {
+			if scanner.Truncated > 0 || scanner.Summary > 0 {
+				e.logf("tshark output: %d truncated records, %d summary-only lines\n", scanner.Truncated, scanner.Summary)
+			}
+		}()
+		for {
+			pkt, line, err := scanner.Next()
+			if err != nil {
+				return
+			}
+			if pkt == nil {
+				e.logf("%s\n", line)
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case packets <- pkt:
+			}
+		}
+	}()
+	return packets, nil
+}
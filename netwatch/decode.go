@@ -0,0 +1,432 @@
+package netwatch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Link layer types, numbered as in pcap
+const (
+	linkEthernet = 1
+	linkRaw      = 101 // bare IPv4 or IPv6, e.g. tun devices
+)
+
+// Application layers recognised by well known port when decoding natively,
+// named like tshark's frame.protocols so the trackers work unchanged
+var portLayers = map[int]string{
+	20:   "ftp-data",
+	21:   "ftp",
+	23:   "telnet",
+	53:   "dns",
+	69:   "tftp",
+	110:  "pop",
+	139:  "smb",
+	143:  "imap",
+	161:  "snmp",
+	162:  "snmp",
+	445:  "smb",
+	2049: "nfs",
+	5060: "sip",
+}
+
+// frameDecoder turns raw frames into Packets the way tshark -T fields would.
+// It only covers what the trackers need from layers 2 to 4 plus DNS answers
+// and TLS server names; TLS certificates, HTTP and DHCP hostnames still need
+// the tshark backend.
+type frameDecoder struct {
+	start   time.Time
+	payload bool // keep transport payloads for payload patterns
+}
+
+// Decoding one frame, nil when it is too short to make sense of
+func (d *frameDecoder) decode(frame []byte, link int, ts time.Time, length int) *Packet {
+	p := &Packet{
+		Time:     ts,
+		Relative: fmt.Sprintf("%.9f", ts.Sub(d.start).Seconds()),
+		Length:   length,
+	}
+
+	if link == linkRaw {
+		if len(frame) == 0 {
+			return nil
+		}
+		etherType := 0x0800
+		if frame[0]>>4 == 6 {
+			etherType = 0x86DD
+		}
+		p.Protocols = []string{"raw"}
+		d.decodeNetwork(p, etherType, frame)
+		return p
+	}
+
+	if len(frame) < 14 {
+		return nil
+	}
+	p.DstMAC = net.HardwareAddr(frame[0:6]).String()
+	p.SrcMAC = net.HardwareAddr(frame[6:12]).String()
+	p.Source, p.Destination = p.SrcMAC, p.DstMAC
+	p.Protocols = []string{"eth"}
+	etherType := int(binary.BigEndian.Uint16(frame[12:14]))
+	body := frame[14:]
+
+	// 802.3 frames carry a length instead of a type, spanning tree uses LLC
+	if etherType < 0x600 {
+		p.Protocols = append(p.Protocols, "llc")
+		if len(body) >= 3 && body[0] == 0x42 && body[1] == 0x42 {
+			decodeBPDU(p, body[3:])
+		} else {
+			p.Protocol = "LLC"
+		}
+		return p
+	}
+
+	p.Protocols = append(p.Protocols, "ethertype")
+	for (etherType == 0x8100 || etherType == 0x88A8) && len(body) >= 4 {
+		p.Protocols = append(p.Protocols, "vlan", "ethertype")
+		etherType = int(binary.BigEndian.Uint16(body[2:4]))
+		body = body[4:]
+	}
+	d.decodeNetwork(p, etherType, body)
+	return p
+}
+
+func (d *frameDecoder) decodeNetwork(p *Packet, etherType int, b []byte) {
+	var proto int
+	var l4 []byte
+	switch etherType {
+	case 0x0800:
+		if len(b) < 20 || b[0]>>4 != 4 {
+			p.Protocol = "IPv4"
+			return
+		}
+		ihl := int(b[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(b[2:4]))
+		if total > len(b) || total < ihl {
+			total = len(b)
+		}
+		p.Protocols = append(p.Protocols, "ip")
+		p.SrcIP = net.IP(b[12:16]).String()
+		p.DstIP = net.IP(b[16:20]).String()
+		p.TTL = int(b[8])
+		p.IPID = fmt.Sprintf("0x%04x", binary.BigEndian.Uint16(b[4:6]))
+		p.Protocol = "IPv4"
+		proto = int(b[9])
+		// Only the first fragment carries the transport header
+		if binary.BigEndian.Uint16(b[6:8])&0x1fff != 0 || ihl > total {
+			p.Source, p.Destination = p.SrcIP, p.DstIP
+			p.Info = "Fragmented IP protocol"
+			return
+		}
+		l4 = b[ihl:total]
+	case 0x86DD:
+		if len(b) < 40 {
+			p.Protocol = "IPv6"
+			return
+		}
+		p.Protocols = append(p.Protocols, "ipv6")
+		p.SrcIP = net.IP(b[8:24]).String()
+		p.DstIP = net.IP(b[24:40]).String()
+		p.TTL = int(b[7])
+		p.Protocol = "IPv6"
+		end := 40 + int(binary.BigEndian.Uint16(b[4:6]))
+		if end > len(b) {
+			end = len(b)
+		}
+		proto, l4 = int(b[6]), b[40:end]
+		// Skipping hop-by-hop, routing, fragment and destination option headers
+		for (proto == 0 || proto == 43 || proto == 44 || proto == 60) && len(l4) >= 8 {
+			size := (int(l4[1]) + 1) * 8
+			if proto == 44 {
+				if binary.BigEndian.Uint16(l4[2:4])&0xfff8 != 0 {
+					p.Source, p.Destination = p.SrcIP, p.DstIP
+					return
+				}
+				size = 8
+			}
+			if size > len(l4) {
+				return
+			}
+			proto, l4 = int(l4[0]), l4[size:]
+		}
+	case 0x0806:
+		decodeARP(p, b)
+		return
+	default:
+		p.Protocol = fmt.Sprintf("0x%04x", etherType)
+		return
+	}
+
+	p.Source, p.Destination = p.SrcIP, p.DstIP
+	d.decodeTransport(p, proto, l4)
+}
+
+func (d *frameDecoder) decodeTransport(p *Packet, proto int, b []byte) {
+	var payload []byte
+	switch proto {
+	case 6:
+		if len(b) < 20 {
+			return
+		}
+		p.Transport = "tcp"
+		p.Protocols = append(p.Protocols, "tcp")
+		p.SrcPort = int(binary.BigEndian.Uint16(b[0:2]))
+		p.DstPort = int(binary.BigEndian.Uint16(b[2:4]))
+		p.TCPSeq = strconv.FormatUint(uint64(binary.BigEndian.Uint32(b[4:8])), 10)
+		flags := b[13]
+		p.SYN = flags&0x02 != 0
+		p.ACK = flags&0x10 != 0
+		offset := int(b[12]>>4) * 4
+		if offset < 20 || offset > len(b) {
+			offset = len(b)
+		}
+		payload = b[offset:]
+		p.Protocol = "TCP"
+		p.Info = fmt.Sprintf("%d → %d [%s] Len=%d", p.SrcPort, p.DstPort, tcpFlagNames(flags), len(payload))
+	case 17:
+		if len(b) < 8 {
+			return
+		}
+		p.Transport = "udp"
+		p.Protocols = append(p.Protocols, "udp")
+		p.SrcPort = int(binary.BigEndian.Uint16(b[0:2]))
+		p.DstPort = int(binary.BigEndian.Uint16(b[2:4]))
+		payload = b[8:]
+		p.Protocol = "UDP"
+		p.Info = fmt.Sprintf("%d → %d Len=%d", p.SrcPort, p.DstPort, len(payload))
+	case 1:
+		p.Protocols = append(p.Protocols, "icmp")
+		p.Protocol = "ICMP"
+		if len(b) > 0 {
+			p.Info = map[byte]string{0: "Echo (ping) reply", 3: "Destination unreachable", 8: "Echo (ping) request", 11: "Time-to-live exceeded"}[b[0]]
+		}
+		return
+	case 58:
+		p.Protocols = append(p.Protocols, "icmpv6")
+		p.Protocol = "ICMPv6"
+		if len(b) > 0 && b[0] == 134 {
+			decodeRouterAdvert(p, b)
+		}
+		return
+	default:
+		return
+	}
+
+	if d.payload && len(payload) > 0 {
+		p.Payload = append([]byte(nil), payload...)
+	}
+	layer := appLayer(p, payload)
+	if layer == "" {
+		return
+	}
+	p.Protocols = append(p.Protocols, layer)
+	p.Protocol = strings.ToUpper(layer)
+	switch layer {
+	case "dns":
+		decodeDNS(p, payload)
+	case "tls":
+		p.SNI = clientHelloSNI(payload)
+	case "snmp":
+		p.SNMPVersion = snmpVersion(payload)
+	case "smb":
+		decodeSMB(p, payload)
+	case "nfs":
+		decodeRPC(p, payload)
+	case "rtp":
+		decodeRTP(p, payload)
+	case "sip":
+		decodeSIP(p, payload)
+	}
+}
+
+// Naming the application layer by payload signature first, then by port
+func appLayer(p *Packet, payload []byte) string {
+	// A TLS record starts with a content type and a 3.x version
+	if p.Transport == "tcp" && len(payload) >= 3 && payload[0] >= 0x14 && payload[0] <= 0x17 && payload[1] == 3 {
+		return "tls"
+	}
+	if p.Transport == "tcp" {
+		if layer := tcpP2PLayer(p, payload); layer != "" {
+			return layer
+		}
+	}
+	// With known ports on both sides the lower one is the server, e.g. a DNS
+	// reply to client port 5060
+	if len(payload) > 0 {
+		layer, server := "", 0
+		for _, port := range []int{p.DstPort, p.SrcPort} {
+			if l, ok := portLayers[port]; ok && (layer == "" || port < server) {
+				layer, server = l, port
+			}
+		}
+		if layer != "" {
+			return layer
+		}
+	}
+	if p.Transport == "udp" {
+		return udpHeuristicLayer(p, payload)
+	}
+	return ""
+}
+
+func tcpFlagNames(flags byte) string {
+	names := []string{"FIN", "SYN", "RST", "PSH", "ACK", "URG"}
+	var set []string
+	for i, n := range names {
+		if flags&(1<<i) != 0 {
+			set = append(set, n)
+		}
+	}
+	return strings.Join(set, ", ")
+}
+
+func decodeARP(p *Packet, b []byte) {
+	p.Protocols = append(p.Protocols, "arp")
+	p.Protocol = "ARP"
+	if len(b) < 28 || b[4] != 6 || b[5] != 4 {
+		return
+	}
+	sender, target := net.IP(b[14:18]).String(), net.IP(b[24:28]).String()
+	switch binary.BigEndian.Uint16(b[6:8]) {
+	case 1:
+		p.Info = fmt.Sprintf("Who has %s? Tell %s", target, sender)
+	case 2:
+		p.Info = fmt.Sprintf("%s is at %s", sender, net.HardwareAddr(b[8:14]))
+	}
+}
+
+// Configuration, RST and TCN BPDUs after the 3 byte LLC header
+func decodeBPDU(p *Packet, b []byte) {
+	p.Protocols = append(p.Protocols, "stp")
+	p.Protocol = "STP"
+	if len(b) < 4 {
+		return
+	}
+	p.BPDU = &BPDU{Type: fmt.Sprintf("0x%02x", b[3])}
+	if b[3] == 0x80 || len(b) < 35 {
+		p.Info = "Topology Change Notification"
+		return
+	}
+	p.BPDU.TopologyChange = b[4]&0x01 != 0
+	// The low 12 bits of the bridge priority are the VLAN system ID extension
+	p.BPDU.RootPriority = strconv.Itoa(int(binary.BigEndian.Uint16(b[5:7]) & 0xf000))
+	p.BPDU.RootMAC = net.HardwareAddr(b[7:13]).String()
+	p.BPDU.Bridge = net.HardwareAddr(b[19:25]).String()
+	p.Info = "Conf. Root = " + p.BPDU.Root()
+}
+
+func decodeRouterAdvert(p *Packet, b []byte) {
+	p.RouterAdvert = true
+	p.Info = "Router Advertisement"
+	// Options follow the 16 byte header, type 3 is a prefix
+	for o := 16; o+2 <= len(b); {
+		size := int(b[o+1]) * 8
+		if size == 0 || o+size > len(b) {
+			return
+		}
+		if b[o] == 3 && size >= 32 {
+			p.RAPrefixes = append(p.RAPrefixes, net.IP(b[o+16:o+32]).String())
+		}
+		o += size
+	}
+}
+
+// Reading the header and first question of a DNS message, and the A and
+// AAAA answers of a response
+func decodeDNS(p *Packet, b []byte) {
+	// Over TCP every message has a length prefix
+	if p.Transport == "tcp" && len(b) >= 2 {
+		b = b[2:]
+	}
+	if len(b) < 12 {
+		return
+	}
+	m := &DNSMessage{ID: binary.BigEndian.Uint16(b), Response: b[2]&0x80 != 0, Rcode: int(b[3] & 0x0f)}
+	questions := int(binary.BigEndian.Uint16(b[4:6]))
+	answers := int(binary.BigEndian.Uint16(b[6:8]))
+	m.Answers = answers
+	o := 12
+	for i := 0; i < questions; i++ {
+		name, next, ok := dnsName(b, o)
+		if !ok || next+4 > len(b) {
+			return
+		}
+		if i == 0 {
+			m.Name, m.Type = name, binary.BigEndian.Uint16(b[next:])
+		}
+		o = next + 4
+	}
+	p.DNS = m
+	if !m.Response {
+		p.Info = "Standard query " + m.Name
+		return
+	}
+	p.DNSName = m.Name
+	for i := 0; i < answers; i++ {
+		_, next, ok := dnsName(b, o)
+		if !ok || next+10 > len(b) {
+			return
+		}
+		typ := binary.BigEndian.Uint16(b[next : next+2])
+		size := int(binary.BigEndian.Uint16(b[next+8 : next+10]))
+		data := next + 10
+		if data+size > len(b) {
+			return
+		}
+		if (typ == 1 && size == 4) || (typ == 28 && size == 16) {
+			p.DNSAddrs = append(p.DNSAddrs, net.IP(b[data:data+size]).String())
+		}
+		o = data + size
+	}
+	p.Info = "Standard query response " + p.DNSName
+}
+
+// Reading a possibly compressed name, returning the offset after it
+func dnsName(b []byte, o int) (string, int, bool) {
+	var labels []string
+	end := -1
+	for jumps := 0; o < len(b); {
+		n := int(b[o])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = o + 1
+			}
+			return strings.Join(labels, "."), end, true
+		case n&0xc0 == 0xc0:
+			if o+1 >= len(b) || jumps > 16 {
+				return "", 0, false
+			}
+			if end < 0 {
+				end = o + 2
+			}
+			o = int(binary.BigEndian.Uint16(b[o:o+2]) & 0x3fff)
+			jumps++
+		default:
+			if o+1+n > len(b) {
+				return "", 0, false
+			}
+			labels = append(labels, string(b[o+1:o+1+n]))
+			o += 1 + n
+		}
+	}
+	return "", 0, false
+}
+
+// The version is the first integer in the message sequence, 0 means v1 and 1 means v2c
+func snmpVersion(b []byte) string {
+	if len(b) < 2 || b[0] != 0x30 {
+		return ""
+	}
+	o := 2
+	if b[1]&0x80 != 0 {
+		o += int(b[1] & 0x7f)
+	}
+	if o+3 > len(b) || b[o] != 0x02 || b[o+1] != 1 {
+		return ""
+	}
+	return strconv.Itoa(int(b[o+2]))
+}
@@ -0,0 +1,141 @@
+package netwatch
+
+import (
+	"encoding/binary"
+	"strconv"
+	"unicode/utf16"
+)
+
+// FileOp is the SMB2 or NFS operation a packet carries
+type FileOp struct {
+	Protocol string // "smb2" or "nfs"
+	Command  string // e.g. TREE_CONNECT, READ or WRITE, empty for NFS replies
+	Response bool
+	ID       uint64 // SMB2 message id or RPC xid, the same in a request and its response
+	Tree     uint32 // SMB2 tree id, 0 before the tree connect response
+	Share    string // \\server\share of a tree connect, tshark names it on every packet
+}
+
+var smb2Commands = []string{
+	"NEGOTIATE", "SESSION_SETUP", "LOGOFF", "TREE_CONNECT", "TREE_DISCONNECT", "CREATE", "CLOSE",
+	"FLUSH", "READ", "WRITE", "LOCK", "IOCTL", "CANCEL", "ECHO", "QUERY_DIRECTORY", "CHANGE_NOTIFY",
+	"QUERY_INFO", "SET_INFO", "OPLOCK_BREAK",
+}
+
+var nfs3Procedures = []string{
+	"NULL", "GETATTR", "SETATTR", "LOOKUP", "ACCESS", "READLINK", "READ", "WRITE", "CREATE",
+	"MKDIR", "SYMLINK", "MKNOD", "REMOVE", "RMDIR", "RENAME", "LINK", "READDIR", "READDIRPLUS",
+	"FSSTAT", "FSINFO", "PATHCONF", "COMMIT",
+}
+
+func smb2Command(n int) string {
+	if n >= 0 && n < len(smb2Commands) {
+		return smb2Commands[n]
+	}
+	return strconv.Itoa(n)
+}
+
+// NFS procedures by version, version 4 bundles its operations into COMPOUND
+func nfsProcedure(version, n int) string {
+	switch {
+	case version == 3 && n >= 0 && n < len(nfs3Procedures):
+		return nfs3Procedures[n]
+	case version == 4 && n == 0:
+		return "NULL"
+	case version == 4 && n == 1:
+		return "COMPOUND"
+	}
+	return strconv.Itoa(n)
+}
+
+// NetBIOS session messages, named like tshark: nbss, then smb or smb2 when
+// the message starts with an SMB header. Segments continuing a message, e.g.
+// the data of a large read, stay nbss.
+func decodeSMB(p *Packet, b []byte) {
+	p.Protocols[len(p.Protocols)-1] = "nbss"
+	p.Protocol = "NBSS"
+	if len(b) < 8 || b[0] != 0 {
+		return
+	}
+	h := b[4:]
+	switch string(h[:4]) {
+	case "\xffSMB":
+		p.Protocols = append(p.Protocols, "smb")
+		p.Protocol = "SMB"
+		return
+	case "\xfdSMB":
+		// Encrypted SMB 3, the command is hidden
+		p.Protocols = append(p.Protocols, "smb2")
+		p.Protocol = "SMB2"
+		return
+	case "\xfeSMB":
+	default:
+		return
+	}
+	p.Protocols = append(p.Protocols, "smb2")
+	p.Protocol = "SMB2"
+	if len(h) < 64 {
+		return
+	}
+	cmd := int(binary.LittleEndian.Uint16(h[12:]))
+	flags := binary.LittleEndian.Uint32(h[16:])
+	op := &FileOp{Protocol: "smb2", Command: smb2Command(cmd), Response: flags&1 != 0, ID: binary.LittleEndian.Uint64(h[24:])}
+	// Async headers carry an async id where the tree id would be
+	if flags&2 == 0 {
+		op.Tree = binary.LittleEndian.Uint32(h[36:])
+	}
+	// The tree connect request names the share, with an offset from the header
+	if cmd == 3 && !op.Response && len(h) >= 72 {
+		off, n := int(binary.LittleEndian.Uint16(h[68:])), int(binary.LittleEndian.Uint16(h[70:]))
+		if off >= 72 && off+n <= len(h) && n%2 == 0 {
+			path := make([]uint16, n/2)
+			for i := range path {
+				path[i] = binary.LittleEndian.Uint16(h[off+2*i:])
+			}
+			op.Share = string(utf16.Decode(path))
+		}
+	}
+	p.FileOp = op
+	p.Info = op.Command + " Request"
+	if op.Response {
+		p.Info = op.Command + " Response"
+	}
+}
+
+// ONC RPC, named rpc then nfs like tshark. Over TCP a record marker comes
+// first. Calls to the NFS program name their procedure; replies only carry
+// the xid of their call.
+func decodeRPC(p *Packet, b []byte) {
+	p.Protocols[len(p.Protocols)-1] = "rpc"
+	p.Protocol = "RPC"
+	if p.Transport == "tcp" {
+		if len(b) < 4 {
+			return
+		}
+		b = b[4:]
+	}
+	if len(b) < 12 {
+		return
+	}
+	op := &FileOp{Protocol: "nfs", ID: uint64(binary.BigEndian.Uint32(b[0:]))}
+	switch binary.BigEndian.Uint32(b[4:]) {
+	case 0:
+		if len(b) < 24 || binary.BigEndian.Uint32(b[8:]) != 2 || binary.BigEndian.Uint32(b[12:]) != 100003 {
+			return
+		}
+		op.Command = nfsProcedure(int(binary.BigEndian.Uint32(b[16:])), int(binary.BigEndian.Uint32(b[20:])))
+		p.Info = "V" + strconv.Itoa(int(binary.BigEndian.Uint32(b[16:]))) + " " + op.Command + " Call"
+	case 1:
+		// Accepted or denied
+		if binary.BigEndian.Uint32(b[8:]) > 1 {
+			return
+		}
+		op.Response = true
+		p.Info = "Reply"
+	default:
+		return
+	}
+	p.Protocols = append(p.Protocols, "nfs")
+	p.Protocol = "NFS"
+	p.FileOp = op
+}
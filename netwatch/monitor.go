@@ -0,0 +1,197 @@
+// Package netwatch is the capture and bucketing core of netwatchd, for Go
+// programs that want its packet counts without running the binary:
+//
+//	m, err := netwatch.New(netwatch.Config{CaptureOptions: netwatch.CaptureOptions{Interface: "eth0"}})
+//	if err != nil {
+//		return err
+//	}
+//	if err := m.Start(ctx); err != nil {
+//		return err
+//	}
+//	defer m.Stop()
+//	...
+//	r := m.Report()
+package netwatch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Config selects the capture backend and the bucket size of a Monitor
+type Config struct {
+	CaptureOptions
+	Backend string        // auto, native or tshark, empty means auto
+	Bucket  time.Duration // defaults to one minute
+	// OnPacket, when set, sees every packet after it was counted. It is called
+	// from the capture goroutine and must not block.
+	OnPacket func(p *Packet)
+}
+
+// Snapshot is the state of a running Monitor
+type Snapshot struct {
+	Start   time.Time `json:"start"`
+	Time    time.Time `json:"time"`
+	Packets int       `json:"packets"`
+	Bytes   int64     `json:"bytes"`
+	Current Bucket    `json:"current"` // the open bucket so far
+	Buckets []Bucket  `json:"buckets"` // closed buckets, oldest first
+}
+
+// Report summarizes a capture, the last bucket may be shorter than the others
+type Report struct {
+	Interface             string    `json:"interface"`
+	Start                 time.Time `json:"start"`
+	End                   time.Time `json:"end"`
+	BucketSeconds         float64   `json:"bucket_seconds"`
+	Buckets               []Bucket  `json:"buckets"`
+	TotalPackets          int       `json:"total_packets"`
+	TotalBytes            int64     `json:"total_bytes"`
+	AverageBytesPerPacket float64   `json:"average_bytes_per_packet"`
+}
+
+// Monitor counts packets and bytes per bucket on one interface
+type Monitor struct {
+	cfg    Config
+	engine CaptureEngine
+
+	mu      sync.Mutex
+	start   time.Time
+	end     time.Time
+	buckets *Buckets
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func New(cfg Config) (*Monitor, error) {
+	if cfg.Interface == "" {
+		return nil, errors.New("no interface given")
+	}
+	if cfg.Bucket == 0 {
+		cfg.Bucket = time.Minute
+	}
+	if cfg.Bucket < time.Second {
+		return nil, errors.New("bucket must be at least one second")
+	}
+	engine, err := NewCaptureEngine(cfg.Backend, cfg.CaptureOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &Monitor{cfg: cfg, engine: engine}, nil
+}
+
+// Start begins capturing. The capture ends with ctx or Stop.
+func (m *Monitor) Start(ctx context.Context) error {
+	m.mu.Lock()
+	if m.done != nil {
+		m.mu.Unlock()
+		return errors.New("monitor already started")
+	}
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.done = make(chan struct{})
+	m.mu.Unlock()
+
+	packets, err := m.engine.Start(ctx)
+	if err != nil {
+		m.cancel()
+		close(m.done)
+		return err
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	m.start = now
+	m.buckets = NewBuckets(now, m.cfg.Bucket)
+	m.mu.Unlock()
+
+	go func() {
+		defer close(m.done)
+		for p := range packets {
+			m.mu.Lock()
+			m.rotate(time.Now())
+			m.buckets.Add(1, int64(p.Length))
+			m.mu.Unlock()
+			if m.cfg.OnPacket != nil {
+				m.cfg.OnPacket(p)
+			}
+		}
+		m.mu.Lock()
+		m.end = time.Now()
+		m.rotate(m.end)
+		m.mu.Unlock()
+	}()
+	return nil
+}
+
+// Stop ends the capture and waits for the last packets to be counted
+func (m *Monitor) Stop() {
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	m.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Closing every bucket that ended before now, quiet ones included. Callers hold m.mu.
+func (m *Monitor) rotate(now time.Time) {
+	for m.buckets.Due(now) {
+		m.buckets.Rotate()
+	}
+}
+
+// Snapshot returns the counts so far without stopping the capture
+func (m *Monitor) Snapshot() Snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if m.buckets == nil {
+		return Snapshot{Time: now}
+	}
+	m.rotate(now)
+	current := m.buckets.Current
+	current.Seconds = now.Sub(current.Start).Seconds()
+	return Snapshot{
+		Start:   m.start,
+		Time:    now,
+		Packets: m.buckets.Packets,
+		Bytes:   m.buckets.Bytes,
+		Current: current,
+		Buckets: append([]Bucket(nil), m.buckets.Closed...),
+	}
+}
+
+// Report summarizes the capture up to now, or up to its end once stopped
+func (m *Monitor) Report() *Report {
+	s := m.Snapshot()
+	buckets := s.Buckets
+	if s.Current.Seconds > 0 || s.Current.Packets > 0 {
+		buckets = append(buckets, s.Current)
+	}
+	r := &Report{
+		Interface:     m.cfg.Interface,
+		Start:         s.Start,
+		End:           s.Time,
+		BucketSeconds: m.cfg.Bucket.Seconds(),
+		Buckets:       buckets,
+		TotalPackets:  s.Packets,
+		TotalBytes:    s.Bytes,
+	}
+	if s.Packets > 0 {
+		r.AverageBytesPerPacket = float64(s.Bytes) / float64(s.Packets)
+	}
+	return r
+}
+
+// The end of a finished capture, otherwise the current time. Callers hold m.mu.
+func (m *Monitor) now() time.Time {
+	if !m.end.IsZero() {
+		return m.end
+	}
+	return time.Now()
+}
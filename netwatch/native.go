@@ -0,0 +1,135 @@
+package netwatch
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CaptureOptions are what every live capture backend is configured with
+type CaptureOptions struct {
+	Interface string
+	Filter    string
+	PcapFile  string // also write the capture here when set
+	Payload   bool   // also keep transport payloads
+	// DiskCheck, when set, pauses PcapFile writing while it returns an error
+	DiskCheck func(path string) error
+	// Logf receives progress and warning messages, nil discards them
+	Logf func(format string, args ...any)
+	// RunAs, when set, is the user tshark runs as (Linux). A caller that
+	// drops root later can then still stop it.
+	RunAs *Credential
+}
+
+// Credential is a user and its groups by id
+type Credential struct {
+	UID, GID uint32
+	Groups   []uint32
+}
+
+func (o CaptureOptions) logf(format string, args ...any) {
+	if o.Logf != nil {
+		o.Logf(format, args...)
+	}
+}
+
+// frameSource reads raw frames from the operating system's capture API
+type frameSource interface {
+	// ReadFrame returns a nil frame when nothing arrived before the read timeout
+	ReadFrame() (frame []byte, link int, ts time.Time, length int, err error)
+	LinkType() int
+	// Filtered reports whether the capture filter is already applied by the OS
+	Filtered() bool
+	Close() error
+}
+
+// NativeEngine captures without tshark, through AF_PACKET on Linux or Npcap on Windows
+type NativeEngine struct {
+	CaptureOptions
+}
+
+func (e *NativeEngine) Start(ctx context.Context) (<-chan *Packet, error) {
+	src, err := openFrameSource(e.Interface, e.Filter, e.logf)
+	if err != nil {
+		return nil, err
+	}
+	var filter packetFilter
+	if e.Filter != "" && !src.Filtered() {
+		if filter, err = parsePacketFilter(e.Filter); err != nil {
+			src.Close()
+			return nil, err
+		}
+	}
+	var pcap *pcapngWriter
+	if e.PcapFile != "" {
+		if pcap, err = createPcapng(e.PcapFile, src.LinkType(), 65535); err != nil {
+			src.Close()
+			return nil, fmt.Errorf("failed to create %s: %v", e.PcapFile, err)
+		}
+		pcap.check, pcap.logf = e.DiskCheck, e.logf
+	}
+
+	e.logf("Starting packet capture on interface %s (native, %s)...\n", e.Interface, nativeBackendName)
+	if e.Filter != "" {
+		e.logf("Filter: %s\n", e.Filter)
+	}
+	e.logf("---\n")
+
+	decoder := &frameDecoder{start: time.Now(), payload: e.Payload}
+	packets := make(chan *Packet, 64)
+	count := 0
+	go func() {
+		defer close(packets)
+		defer src.Close()
+		if pcap != nil {
+			defer pcap.Close()
+		}
+
+		for ctx.Err() == nil {
+			frame, link, ts, length, err := src.ReadFrame()
+			if err != nil {
+				e.logf("Capture stopped: %v\n", err)
+				return
+			}
+			if frame == nil {
+				continue
+			}
+			pkt := decoder.decode(frame, link, ts, length)
+			if pkt == nil || (filter != nil && !filter(pkt)) {
+				continue
+			}
+			count++
+			pkt.Number = strconv.Itoa(count)
+			if pcap != nil {
+				if err := pcap.WritePacket(ts, frame, length); err != nil {
+					e.logf("Failed to write %s: %v\n", e.PcapFile, err)
+					pcap.Close()
+					pcap = nil
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case packets <- pkt:
+			}
+		}
+	}()
+	return packets, nil
+}
+
+// "3. eth0 (10.0.0.5/24, fe80::1/64)" in the style of tshark -D
+func describeInterface(ifi net.Interface) string {
+	line := fmt.Sprintf("%d. %s", ifi.Index, ifi.Name)
+	addrs, _ := ifi.Addrs()
+	var list []string
+	for _, a := range addrs {
+		list = append(list, a.String())
+	}
+	if len(list) > 0 {
+		line += " (" + strings.Join(list, ", ") + ")"
+	}
+	return line
+}
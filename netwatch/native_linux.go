@@ -0,0 +1,131 @@
+package netwatch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const nativeBackendName = "AF_PACKET"
+
+// Link types from linux/if_arp.h carrying Ethernet headers
+const (
+	arphrdEther    = 1
+	arphrdLoopback = 772
+)
+
+// afPacketSource reads every frame on an interface from a raw packet socket
+type afPacketSource struct {
+	fd   int
+	link int
+	buf  []byte
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// Checking up front that raw sockets are allowed, they need CAP_NET_RAW
+func nativeAvailable() error {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(syscall.ETH_P_ALL)))
+	if err != nil {
+		return fmt.Errorf("raw packet socket: %v (run as root or grant CAP_NET_RAW)", err)
+	}
+	syscall.Close(fd)
+	return nil
+}
+
+func openFrameSource(iface, filter string, logf func(format string, args ...any)) (frameSource, error) {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(syscall.ETH_P_ALL)))
+	if err != nil {
+		return nil, fmt.Errorf("raw packet socket: %v (run as root or grant CAP_NET_RAW)", err)
+	}
+	s := &afPacketSource{fd: fd, link: linkEthernet, buf: make([]byte, 65536)}
+
+	// "any" leaves the socket unbound so it sees every interface
+	if iface != "any" {
+		ifi, err := net.InterfaceByName(iface)
+		if err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+		if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ALL), Ifindex: ifi.Index}); err != nil {
+			syscall.Close(fd)
+			return nil, fmt.Errorf("bind to %s: %v", iface, err)
+		}
+		// struct packet_mreq: ifindex, type, address length, address
+		mreq := make([]byte, 16)
+		binary.NativeEndian.PutUint32(mreq[0:], uint32(ifi.Index))
+		binary.NativeEndian.PutUint16(mreq[4:], syscall.PACKET_MR_PROMISC)
+		if err := syscall.SetsockoptString(fd, syscall.SOL_PACKET, syscall.PACKET_ADD_MEMBERSHIP, string(mreq)); err != nil {
+			logf("Failed to enable promiscuous mode on %s: %v\n", iface, err)
+		}
+		if raw, err := os.ReadFile("/sys/class/net/" + iface + "/type"); err == nil {
+			if t, _ := strconv.Atoi(strings.TrimSpace(string(raw))); t != arphrdEther && t != arphrdLoopback {
+				s.link = linkRaw
+			}
+		}
+	}
+
+	// A read timeout lets the capture loop notice cancellation
+	tv := syscall.NsecToTimeval((200 * time.Millisecond).Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *afPacketSource) ReadFrame() ([]byte, int, time.Time, int, error) {
+	// MSG_TRUNC makes the kernel return the real length of oversized frames
+	n, from, err := syscall.Recvfrom(s.fd, s.buf, syscall.MSG_TRUNC)
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return nil, 0, time.Time{}, 0, nil
+	}
+	if err != nil {
+		return nil, 0, time.Time{}, 0, err
+	}
+	ts := time.Now()
+	link := s.link
+	if ll, ok := from.(*syscall.SockaddrLinklayer); ok {
+		// Loopback delivers every frame twice, once outgoing and once incoming
+		if ll.Hatype == arphrdLoopback && ll.Pkttype == syscall.PACKET_OUTGOING {
+			return nil, 0, time.Time{}, 0, nil
+		}
+		if ll.Hatype != arphrdEther && ll.Hatype != arphrdLoopback {
+			link = linkRaw
+		}
+	}
+	captured := n
+	if captured > len(s.buf) {
+		captured = len(s.buf)
+	}
+	return s.buf[:captured], link, ts, n, nil
+}
+
+func (s *afPacketSource) LinkType() int { return s.link }
+
+// BPF programs can't be compiled without libpcap, the filter is evaluated after decoding
+func (s *afPacketSource) Filtered() bool { return false }
+
+func (s *afPacketSource) Close() error {
+	return syscall.Close(s.fd)
+}
+
+// ListInterfaces lists interfaces by kernel index, in the style of tshark -D
+func ListInterfaces() ([]string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, ifi := range ifaces {
+		lines = append(lines, describeInterface(ifi))
+	}
+	return append(lines, "any (all interfaces)"), nil
+}
@@ -0,0 +1,34 @@
+//go:build !linux && !windows
+
+package netwatch
+
+import (
+	"errors"
+	"net"
+	"runtime"
+)
+
+const nativeBackendName = "unsupported"
+
+var errNoNative = errors.New("native capture is not supported on " + runtime.GOOS)
+
+func nativeAvailable() error {
+	return errNoNative
+}
+
+func openFrameSource(iface, filter string, logf func(format string, args ...any)) (frameSource, error) {
+	return nil, errNoNative
+}
+
+// ListInterfaces lists interfaces by index in the style of tshark -D
+func ListInterfaces() ([]string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, ifi := range ifaces {
+		lines = append(lines, describeInterface(ifi))
+	}
+	return lines, nil
+}
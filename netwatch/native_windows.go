@@ -0,0 +1,192 @@
+package netwatch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const nativeBackendName = "Npcap"
+
+// DLT_RAW as pcap_datalink reports it, files use LINKTYPE_RAW (linkRaw)
+const dltRaw = 12
+
+// Npcap installs wpcap.dll below System32\Npcap unless WinPcap compatible mode is on
+var (
+	wpcap = func() *syscall.LazyDLL {
+		dll := syscall.NewLazyDLL(filepath.Join(os.Getenv("SystemRoot"), "System32", "Npcap", "wpcap.dll"))
+		if dll.Load() != nil {
+			dll = syscall.NewLazyDLL("wpcap.dll")
+		}
+		return dll
+	}()
+	pcapOpenLive    = wpcap.NewProc("pcap_open_live")
+	pcapCompile     = wpcap.NewProc("pcap_compile")
+	pcapSetfilter   = wpcap.NewProc("pcap_setfilter")
+	pcapFreecode    = wpcap.NewProc("pcap_freecode")
+	pcapNextEx      = wpcap.NewProc("pcap_next_ex")
+	pcapDatalink    = wpcap.NewProc("pcap_datalink")
+	pcapClose       = wpcap.NewProc("pcap_close")
+	pcapFindalldevs = wpcap.NewProc("pcap_findalldevs")
+	pcapFreealldevs = wpcap.NewProc("pcap_freealldevs")
+)
+
+// struct pcap_pkthdr, timeval holds two 32 bit longs on Windows
+type pcapPkthdr struct {
+	Sec    int32
+	Usec   int32
+	Caplen uint32
+	Len    uint32
+}
+
+type bpfProgram struct {
+	Len   uint32
+	Insns uintptr
+}
+
+// struct pcap_if
+type pcapIf struct {
+	Next        *pcapIf
+	Name        *byte
+	Description *byte
+	Addresses   uintptr
+	Flags       uint32
+}
+
+// npcapSource reads frames through Npcap, which compiles and applies the BPF
+// filter itself. Loopback and raw IP frames are handed on as linkRaw.
+type npcapSource struct {
+	handle uintptr
+	dlt    int // link type of the adapter
+	link   int // link type of the frames handed on
+}
+
+func nativeAvailable() error {
+	if err := wpcap.Load(); err != nil {
+		return errors.New("Npcap is not installed (wpcap.dll not found)")
+	}
+	return nil
+}
+
+func cString(s string) *byte {
+	b, _ := syscall.BytePtrFromString(s)
+	return b
+}
+
+func goString(p *byte) string {
+	if p == nil {
+		return ""
+	}
+	var b []byte
+	for ; *p != 0; p = (*byte)(unsafe.Add(unsafe.Pointer(p), 1)) {
+		b = append(b, *p)
+	}
+	return string(b)
+}
+
+func openFrameSource(iface, filter string, logf func(format string, args ...any)) (frameSource, error) {
+	if err := nativeAvailable(); err != nil {
+		return nil, err
+	}
+	errbuf := make([]byte, 256)
+	handle, _, _ := pcapOpenLive.Call(uintptr(unsafe.Pointer(cString(iface))), 65535, 1, 200, uintptr(unsafe.Pointer(&errbuf[0])))
+	if handle == 0 {
+		return nil, fmt.Errorf("pcap_open_live %s: %s", iface, goString(&errbuf[0]))
+	}
+	s := &npcapSource{handle: handle}
+	dlt, _, _ := pcapDatalink.Call(handle)
+	s.dlt = int(int32(dlt))
+	switch s.dlt {
+	case linkEthernet:
+		s.link = linkEthernet
+	case linkNull, dltRaw, linkRaw:
+		s.link = linkRaw
+	default:
+		s.Close()
+		return nil, fmt.Errorf("unsupported link type %d on %s", s.dlt, iface)
+	}
+
+	if filter != "" {
+		var prog bpfProgram
+		// PCAP_NETMASK_UNKNOWN, only matters for broadcast primitives
+		if ret, _, _ := pcapCompile.Call(handle, uintptr(unsafe.Pointer(&prog)), uintptr(unsafe.Pointer(cString(filter))), 1, 0xffffffff); int32(ret) != 0 {
+			s.Close()
+			return nil, fmt.Errorf("invalid capture filter %q", filter)
+		}
+		ret, _, _ := pcapSetfilter.Call(handle, uintptr(unsafe.Pointer(&prog)))
+		pcapFreecode.Call(uintptr(unsafe.Pointer(&prog)))
+		if int32(ret) != 0 {
+			s.Close()
+			return nil, fmt.Errorf("failed to set capture filter %q", filter)
+		}
+	}
+	return s, nil
+}
+
+func (s *npcapSource) ReadFrame() ([]byte, int, time.Time, int, error) {
+	var hdr *pcapPkthdr
+	var data *byte
+	ret, _, _ := pcapNextEx.Call(s.handle, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data)))
+	switch int32(ret) {
+	case 1:
+	case 0:
+		return nil, 0, time.Time{}, 0, nil
+	default:
+		return nil, 0, time.Time{}, 0, fmt.Errorf("pcap_next_ex returned %d", int32(ret))
+	}
+	// The buffer belongs to Npcap and is reused on the next call
+	frame := append([]byte(nil), unsafe.Slice(data, hdr.Caplen)...)
+	ts := time.Unix(int64(hdr.Sec), int64(hdr.Usec)*1000)
+	length := int(hdr.Len)
+	if s.dlt == linkNull {
+		// The Npcap loopback adapter puts a 4 byte address family in host
+		// order before the packet, AF_INET 2 or AF_INET6 23
+		if len(frame) <= 4 {
+			return nil, 0, time.Time{}, 0, nil
+		}
+		if family := binary.LittleEndian.Uint32(frame); family != 2 && family != 23 {
+			return nil, 0, time.Time{}, 0, nil
+		}
+		frame, length = frame[4:], length-4
+	}
+	return frame, s.link, ts, length, nil
+}
+
+func (s *npcapSource) LinkType() int { return s.link }
+
+func (s *npcapSource) Filtered() bool { return true }
+
+func (s *npcapSource) Close() error {
+	pcapClose.Call(s.handle)
+	return nil
+}
+
+// ListInterfaces lists Npcap device names with their descriptions, in the style of tshark -D
+func ListInterfaces() ([]string, error) {
+	if err := nativeAvailable(); err != nil {
+		return nil, err
+	}
+	var first *pcapIf
+	errbuf := make([]byte, 256)
+	if ret, _, _ := pcapFindalldevs.Call(uintptr(unsafe.Pointer(&first)), uintptr(unsafe.Pointer(&errbuf[0]))); int32(ret) != 0 {
+		return nil, fmt.Errorf("pcap_findalldevs: %s", goString(&errbuf[0]))
+	}
+	defer pcapFreealldevs.Call(uintptr(unsafe.Pointer(first)))
+
+	var lines []string
+	n := 1
+	for d := first; d != nil; d = d.Next {
+		line := fmt.Sprintf("%d. %s", n, goString(d.Name))
+		if desc := goString(d.Description); desc != "" {
+			line += " (" + desc + ")"
+		}
+		lines = append(lines, line)
+		n++
+	}
+	return lines, nil
+}
@@ -0,0 +1,49 @@
+package netwatch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+)
+
+// Naming peer-to-peer TCP payloads at the start of a segment: the
+// BitTorrent and Gnutella handshakes, eDonkey's framed messages, and
+// HTTP tracker announces, which tshark names http with the request
+// line as Info.
+func tcpP2PLayer(p *Packet, b []byte) string {
+	switch {
+	case len(b) >= 20 && b[0] == 19 && string(b[1:20]) == "BitTorrent protocol":
+		p.Info = "Handshake"
+		return "bittorrent"
+	case bytes.HasPrefix(b, []byte("GNUTELLA CONNECT/")) || bytes.HasPrefix(b, []byte("GNUTELLA/")):
+		return "gnutella"
+	case len(b) >= 6 && (b[0] == 0xe3 || b[0] == 0xc5 || b[0] == 0xd4) && int(binary.LittleEndian.Uint32(b[1:5])) == len(b)-5:
+		return "edonkey"
+	case bytes.HasPrefix(b, []byte("GET /announce?")) || bytes.HasPrefix(b, []byte("GET /scrape?")):
+		line, _, _ := strings.Cut(string(b), "\r\n")
+		p.Info = line
+		return "http"
+	}
+	return ""
+}
+
+// Naming peer-to-peer UDP payloads: DHT by its bencoded KRPC dictionary,
+// UDP trackers by their connect request's magic, and uTP by its version 1
+// header between unprivileged ports
+func udpP2PLayer(p *Packet, b []byte) string {
+	switch {
+	case len(b) >= 12 && b[0] == 'd' && (bytes.HasPrefix(b, []byte("d1:ad2:id20:")) || bytes.HasPrefix(b, []byte("d1:rd2:id20:")) ||
+		bytes.HasPrefix(b, []byte("d1:eli"))) && b[len(b)-1] == 'e':
+		return "bt-dht"
+	case len(b) == 16 && binary.BigEndian.Uint64(b[:8]) == 0x41727101980 && binary.BigEndian.Uint32(b[8:12]) == 0:
+		return "bt-tracker"
+	case p.SrcPort >= 1024 && p.DstPort >= 1024 && isUTP(b):
+		return "bt-utp"
+	}
+	return ""
+}
+
+// A uTP header: type 0 to 4 with version 1, then a known extension
+func isUTP(b []byte) bool {
+	return len(b) >= 20 && b[0]&0x0f == 1 && b[0]>>4 <= 4 && b[1] <= 4
+}
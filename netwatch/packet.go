@@ -0,0 +1,403 @@
+package netwatch
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fields requested from tshark with -T fields, in column order.
+// The Info column is free text so it always goes last.
+var tsharkFields = []string{
+	"frame.number",
+	"frame.time_epoch",
+	"frame.time_relative",
+	"frame.len",
+	"frame.protocols",
+	"eth.src",
+	"eth.dst",
+	"eth.src_resolved",
+	"ip.src",
+	"ip.dst",
+	"ipv6.src",
+	"ipv6.dst",
+	"ip.ttl",
+	"ip.id",
+	"ipv6.hlim",
+	"tcp.srcport",
+	"tcp.dstport",
+	"tcp.flags.syn",
+	"tcp.flags.ack",
+	"tcp.seq_raw",
+	"udp.srcport",
+	"udp.dstport",
+	"icmpv6.type",
+	"icmpv6.opt.prefix",
+	"stp.type",
+	"stp.flags.tc",
+	"stp.root.prio",
+	"stp.root.hw",
+	"stp.bridge.hw",
+	"tls.handshake.extensions_server_name",
+	"tls.handshake.certificate",
+	"tls.handshake.type",
+	"tls.handshake.version",
+	"snmp.version",
+	"http.authbasic",
+	"dhcp.option.hostname",
+	"nbns.name",
+	"dns.flags.response",
+	"dns.id",
+	"dns.flags.rcode",
+	"dns.qry.type",
+	"dns.count.answers",
+	"dns.qry.name",
+	"dns.a",
+	"dns.aaaa",
+	"smb2.cmd",
+	"smb2.flags.response",
+	"smb2.msg_id",
+	"smb2.tid",
+	"smb2.tree",
+	"rpc.xid",
+	"rpc.msgtyp",
+	"nfs.procedure_v3",
+	"nfs.procedure_v4",
+	"rtp.p_type",
+	"rtp.marker",
+	"rtp.seq",
+	"rtp.timestamp",
+	"rtp.ssrc",
+	"sip.Method",
+	"sip.Status-Line",
+	"sip.Call-ID",
+	"sip.CSeq.method",
+	"sip.from.addr",
+	"sip.to.addr",
+	"sdp.connection_info.address",
+	"sdp.media.port",
+	"_ws.col.Source",
+	"_ws.col.Destination",
+	"_ws.col.Protocol",
+	"_ws.col.Info",
+}
+
+// Payload columns, only requested when payload patterns are configured
+var payloadFields = []string{"tcp.payload", "udp.payload"}
+
+// fieldLayout is the set and order of columns tshark was asked for
+type fieldLayout struct {
+	fields []string
+	index  map[string]int
+}
+
+// Building a layout from tsharkFields with extra columns placed before Info
+func newFieldLayout(extra ...string) *fieldLayout {
+	fields := append([]string(nil), tsharkFields[:len(tsharkFields)-1]...)
+	fields = append(fields, extra...)
+	fields = append(fields, tsharkFields[len(tsharkFields)-1])
+
+	l := &fieldLayout{fields: fields, index: make(map[string]int, len(fields))}
+	for i, f := range fields {
+		l.index[f] = i
+	}
+	return l
+}
+
+var defaultLayout = newFieldLayout()
+
+type Packet struct {
+	Number       string
+	Interface    string // capture interface, set when capturing on several
+	Time         time.Time
+	Relative     string
+	Length       int
+	Protocols    []string // frame.protocols layers, e.g. eth, ethertype, ip, tcp, tls
+	SrcMAC       string
+	DstMAC       string
+	SrcVendor    string
+	SrcIP        string
+	DstIP        string
+	TTL          int
+	IPID         string // IPv4 identification, empty for IPv6
+	Transport    string // "tcp", "udp" or empty
+	SrcPort      int
+	DstPort      int
+	SYN          bool
+	ACK          bool
+	TCPSeq       string // absolute sequence number
+	RouterAdvert bool
+	RAPrefixes   []string
+	BPDU         *BPDU
+	FileOp       *FileOp // SMB2 or NFS operation
+	RTP          *RTPHeader
+	SIP          *SIPMessage
+	SNI          string
+	TLSVersion   string // version negotiated in a ServerHello, e.g. 0x0303
+	SNMPVersion  string
+	BasicAuth    bool
+	TLSCerts     [][]byte // DER certificates, server first
+	Payload      []byte   // transport payload, only with payload patterns and never kept
+	Hostname     string   // DHCP option 12 or NetBIOS name announced by the sender
+	DNSName      string   // question of a DNS response
+	DNSAddrs     []string // A and AAAA answers of a DNS response
+	DNS          *DNSMessage
+	Source       string
+	Destination  string
+	Protocol     string
+	Info         string
+}
+
+// BPDU holds the spanning tree fields of a bridge protocol frame
+type BPDU struct {
+	Type           string
+	TopologyChange bool
+	RootPriority   string
+	RootMAC        string
+	Bridge         string
+}
+
+// DNSMessage is the header and first question of a DNS query or response
+type DNSMessage struct {
+	ID       uint16
+	Response bool
+	Name     string
+	Type     uint16 // of the question, e.g. 1 for A
+	Rcode    int    // response code, 3 is NXDOMAIN
+	Answers  int
+}
+
+// Root returns the root bridge identifier as priority/MAC
+func (b *BPDU) Root() string {
+	if b.RootMAC == "" {
+		return ""
+	}
+	return b.RootPriority + "/" + b.RootMAC
+}
+
+// Arguments telling tshark to print one tab separated record per packet
+func (l *fieldLayout) args(v tsharkVersion) []string {
+	args := []string{"-T", "fields", "-E", "separator=/t", "-E", "occurrence=a", "-E", "aggregator=,"}
+	for _, f := range l.fields {
+		args = append(args, "-e", v.fieldName(f))
+	}
+	return args
+}
+
+// ParsePacket parses a single line of tshark -T fields output in the default layout
+func ParsePacket(line string) (*Packet, error) {
+	return defaultLayout.parse(line)
+}
+
+func (l *fieldLayout) parse(line string) (*Packet, error) {
+	cols := strings.SplitN(line, "\t", len(l.fields))
+	if len(cols) < len(l.fields) {
+		return nil, fmt.Errorf("short record: %d of %d fields", len(cols), len(l.fields))
+	}
+	get := func(name string) string {
+		if i, ok := l.index[name]; ok {
+			return cols[i]
+		}
+		return ""
+	}
+
+	p := &Packet{
+		Number:      get("frame.number"),
+		Relative:    get("frame.time_relative"),
+		SrcMAC:      get("eth.src"),
+		DstMAC:      get("eth.dst"),
+		SrcIP:       firstValue(get("ip.src")),
+		DstIP:       firstValue(get("ip.dst")),
+		Hostname:    firstValue(get("dhcp.option.hostname")),
+		Source:      get("_ws.col.Source"),
+		Destination: get("_ws.col.Destination"),
+		Protocol:    get("_ws.col.Protocol"),
+		Info:        get("_ws.col.Info"),
+	}
+
+	// Some locales print the epoch with a decimal comma
+	if epoch, err := strconv.ParseFloat(strings.Replace(get("frame.time_epoch"), ",", ".", 1), 64); err == nil {
+		sec := int64(epoch)
+		p.Time = time.Unix(sec, int64((epoch-float64(sec))*1e9))
+	} else {
+		p.Time = time.Now()
+	}
+	p.Length, _ = strconv.Atoi(get("frame.len"))
+
+	if p.SrcIP == "" {
+		p.SrcIP = firstValue(get("ipv6.src"))
+		p.DstIP = firstValue(get("ipv6.dst"))
+		p.TTL, _ = strconv.Atoi(firstValue(get("ipv6.hlim")))
+	} else {
+		p.TTL, _ = strconv.Atoi(firstValue(get("ip.ttl")))
+		p.IPID = firstValue(get("ip.id"))
+	}
+
+	if port := get("tcp.srcport"); port != "" {
+		p.Transport = "tcp"
+		p.SrcPort, _ = strconv.Atoi(firstValue(port))
+		p.DstPort, _ = strconv.Atoi(firstValue(get("tcp.dstport")))
+		p.SYN = isTrue(get("tcp.flags.syn"))
+		p.ACK = isTrue(get("tcp.flags.ack"))
+		p.TCPSeq = firstValue(get("tcp.seq_raw"))
+	} else if port := get("udp.srcport"); port != "" {
+		p.Transport = "udp"
+		p.SrcPort, _ = strconv.Atoi(firstValue(port))
+		p.DstPort, _ = strconv.Atoi(firstValue(get("udp.dstport")))
+	}
+
+	// ICMPv6 type 134 is a Router Advertisement
+	if firstValue(get("icmpv6.type")) == "134" {
+		p.RouterAdvert = true
+		if v := get("icmpv6.opt.prefix"); v != "" {
+			p.RAPrefixes = strings.Split(v, ",")
+		}
+	}
+
+	if bpduType := get("stp.type"); bpduType != "" {
+		p.BPDU = &BPDU{
+			Type:           firstValue(bpduType),
+			TopologyChange: isTrue(get("stp.flags.tc")),
+			RootPriority:   firstValue(get("stp.root.prio")),
+			RootMAC:        firstValue(get("stp.root.hw")),
+			Bridge:         firstValue(get("stp.bridge.hw")),
+		}
+	}
+
+	if layers := get("frame.protocols"); layers != "" {
+		p.Protocols = strings.Split(layers, ":")
+	}
+
+	// Ids are printed in hex, tshark follows SMB2 trees itself and names the share on every packet
+	if cmd := firstValue(get("smb2.cmd")); cmd != "" {
+		n, _ := strconv.Atoi(cmd)
+		id, _ := strconv.ParseUint(firstValue(get("smb2.msg_id")), 0, 64)
+		tree, _ := strconv.ParseUint(firstValue(get("smb2.tid")), 0, 32)
+		p.FileOp = &FileOp{Protocol: "smb2", Command: smb2Command(n), Response: isTrue(get("smb2.flags.response")),
+			ID: id, Tree: uint32(tree), Share: firstValue(get("smb2.tree"))}
+	} else if xid := firstValue(get("rpc.xid")); xid != "" && p.HasLayer("nfs") {
+		id, _ := strconv.ParseUint(xid, 0, 32)
+		p.FileOp = &FileOp{Protocol: "nfs", ID: id, Response: firstValue(get("rpc.msgtyp")) == "1"}
+		for _, v := range []int{3, 4} {
+			if proc := firstValue(get(fmt.Sprintf("nfs.procedure_v%d", v))); proc != "" && !p.FileOp.Response {
+				n, _ := strconv.Atoi(proc)
+				p.FileOp.Command = nfsProcedure(v, n)
+			}
+		}
+	}
+
+	// With several RTP headers in a frame, e.g. RTP in a tunnel, the first is kept
+	if seq := firstValue(get("rtp.seq")); seq != "" {
+		pt, _ := strconv.Atoi(firstValue(get("rtp.p_type")))
+		n, _ := strconv.ParseUint(seq, 10, 16)
+		ts, _ := strconv.ParseUint(firstValue(get("rtp.timestamp")), 10, 32)
+		ssrc, _ := strconv.ParseUint(firstValue(get("rtp.ssrc")), 0, 32)
+		p.RTP = &RTPHeader{PayloadType: pt, Marker: isTrue(get("rtp.marker")), Seq: uint16(n), Timestamp: uint32(ts), SSRC: uint32(ssrc)}
+	}
+
+	if callID := firstValue(get("sip.Call-ID")); callID != "" {
+		p.SIP = &SIPMessage{Method: firstValue(get("sip.Method")), CallID: callID, CSeqMethod: firstValue(get("sip.CSeq.method")),
+			From: firstValue(get("sip.from.addr")), To: firstValue(get("sip.to.addr")), MediaAddr: firstValue(get("sdp.connection_info.address"))}
+		// SIP/2.0 486 Busy Here
+		if f := strings.Fields(get("sip.Status-Line")); len(f) >= 2 {
+			p.SIP.Status, _ = strconv.Atoi(f[1])
+			p.SIP.Reason = strings.Join(f[2:], " ")
+		}
+		if v := get("sdp.media.port"); v != "" {
+			for _, port := range strings.Split(v, ",") {
+				if n, err := strconv.Atoi(port); err == nil && n > 0 {
+					p.SIP.MediaPorts = append(p.SIP.MediaPorts, n)
+				}
+			}
+		}
+	}
+
+	p.SNI = firstValue(get("tls.handshake.extensions_server_name"))
+	for i, t := range strings.Split(get("tls.handshake.type"), ",") {
+		// Type 2 is the ServerHello, its version is the one in use
+		versions := strings.Split(get("tls.handshake.version"), ",")
+		if t == "2" && i < len(versions) {
+			p.TLSVersion = versions[i]
+		}
+	}
+	for _, f := range payloadFields {
+		if v := get(f); v != "" {
+			p.Payload, _ = hex.DecodeString(strings.ReplaceAll(firstValue(v), ":", ""))
+		}
+	}
+	p.SNMPVersion = firstValue(get("snmp.version"))
+	p.BasicAuth = get("http.authbasic") != ""
+	if certs := get("tls.handshake.certificate"); certs != "" {
+		for _, c := range strings.Split(certs, ",") {
+			// Older tshark versions print bytes colon separated
+			if der, err := hex.DecodeString(strings.ReplaceAll(c, ":", "")); err == nil {
+				p.TLSCerts = append(p.TLSCerts, der)
+			}
+		}
+	}
+
+	// eth.src_resolved looks like "IntelCor_12:34:56" when the OUI is known
+	if resolved := get("eth.src_resolved"); resolved != p.SrcMAC {
+		if i := strings.Index(resolved, "_"); i > 0 {
+			p.SrcVendor = resolved[:i]
+		}
+	}
+
+	if p.Hostname == "" {
+		// NBNS names carry a suffix like "DESKTOP-1<00>"
+		if name := firstValue(get("nbns.name")); name != "" {
+			if i := strings.Index(name, "<"); i > 0 {
+				name = name[:i]
+			}
+			p.Hostname = strings.TrimSpace(name)
+		}
+	}
+
+	if id := firstValue(get("dns.id")); id != "" {
+		n, _ := strconv.ParseUint(id, 0, 16)
+		p.DNS = &DNSMessage{ID: uint16(n), Response: isTrue(get("dns.flags.response")), Name: firstValue(get("dns.qry.name"))}
+		typ, _ := strconv.ParseUint(firstValue(get("dns.qry.type")), 0, 16)
+		p.DNS.Type = uint16(typ)
+		p.DNS.Rcode, _ = strconv.Atoi(firstValue(get("dns.flags.rcode")))
+		p.DNS.Answers, _ = strconv.Atoi(firstValue(get("dns.count.answers")))
+	}
+	if isTrue(get("dns.flags.response")) {
+		p.DNSName = firstValue(get("dns.qry.name"))
+		for _, f := range []string{"dns.a", "dns.aaaa"} {
+			if v := get(f); v != "" {
+				p.DNSAddrs = append(p.DNSAddrs, strings.Split(v, ",")...)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// HasLayer reports whether a protocol appears in frame.protocols
+func (p *Packet) HasLayer(name string) bool {
+	for _, l := range p.Protocols {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary line in the same shape as tshark's default output
+func (p *Packet) String() string {
+	return fmt.Sprintf("%5s %s %s → %s %s %d %s", p.Number, p.Relative, p.Source, p.Destination, p.Protocol, p.Length, p.Info)
+}
+
+func firstValue(v string) string {
+	if i := strings.Index(v, ","); i >= 0 {
+		return v[:i]
+	}
+	return v
+}
+
+func isTrue(v string) bool {
+	v = firstValue(v)
+	return v == "1" || v == "True" || v == "true"
+}
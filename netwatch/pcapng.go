@@ -0,0 +1,93 @@
+package netwatch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+	"time"
+)
+
+// pcapngWriter saves frames from the native backends in the same pcapng
+// format tshark -w produces, with a single interface and microsecond timestamps.
+// Writing pauses while check reports the disk is too full.
+type pcapngWriter struct {
+	f       *os.File
+	w       *bufio.Writer
+	path    string
+	check   func(path string) error
+	logf    func(format string, args ...any)
+	checked time.Time
+	paused  bool
+	skipped int
+}
+
+func createPcapng(path string, link int, snaplen int) (*pcapngWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	pw := &pcapngWriter{f: f, w: bufio.NewWriter(f), path: path}
+
+	// Section header: byte order magic, version 1.0, unknown section length
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:], 0x1A2B3C4D)
+	binary.LittleEndian.PutUint16(shb[4:], 1)
+	binary.LittleEndian.PutUint64(shb[8:], ^uint64(0))
+	pw.block(0x0A0D0D0A, shb)
+
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:], uint16(link))
+	binary.LittleEndian.PutUint32(idb[4:], uint32(snaplen))
+	pw.block(1, idb)
+	return pw, pw.w.Flush()
+}
+
+func (pw *pcapngWriter) block(kind uint32, body []byte) {
+	padded := (len(body) + 3) &^ 3
+	total := uint32(12 + padded)
+	var hdr [8]byte
+	binary.LittleEndian.PutUint32(hdr[0:], kind)
+	binary.LittleEndian.PutUint32(hdr[4:], total)
+	pw.w.Write(hdr[:])
+	pw.w.Write(body)
+	pw.w.Write(make([]byte, padded-len(body)))
+	binary.LittleEndian.PutUint32(hdr[0:], total)
+	pw.w.Write(hdr[:4])
+}
+
+// Writing one enhanced packet block
+func (pw *pcapngWriter) WritePacket(ts time.Time, frame []byte, length int) error {
+	if pw.check != nil && time.Since(pw.checked) >= time.Second {
+		pw.checked = time.Now()
+		low := pw.check(pw.path) != nil
+		if low && !pw.paused {
+			pw.logf("Pausing pcap writing to %s\n", pw.path)
+		} else if !low && pw.paused {
+			pw.logf("Resuming pcap writing to %s, %d packets were left out\n", pw.path, pw.skipped)
+		}
+		pw.paused = low
+	}
+	if pw.paused {
+		pw.skipped++
+		return nil
+	}
+	body := make([]byte, 20, 20+len(frame))
+	us := uint64(ts.UnixMicro())
+	binary.LittleEndian.PutUint32(body[4:], uint32(us>>32))
+	binary.LittleEndian.PutUint32(body[8:], uint32(us))
+	binary.LittleEndian.PutUint32(body[12:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(body[16:], uint32(length))
+	pw.block(6, append(body, frame...))
+	return pw.w.Flush()
+}
+
+func (pw *pcapngWriter) Close() error {
+	if pw.paused {
+		pw.logf("Pcap writing to %s was still paused at the end, %d packets were left out\n", pw.path, pw.skipped)
+	}
+	if err := pw.w.Flush(); err != nil {
+		pw.f.Close()
+		return err
+	}
+	return pw.f.Close()
+}
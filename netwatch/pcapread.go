@@ -0,0 +1,360 @@
+package netwatch
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"strconv"
+	"time"
+)
+
+// More link layer types found in capture files, turned into linkRaw frames
+const (
+	linkNull = 0   // BSD loopback, a 4 byte address family in host order
+	linkSLL  = 113 // Linux cooked capture, e.g. tshark -i any
+	linkIPv4 = 228
+	linkIPv6 = 229
+	linkSLL2 = 276
+)
+
+// FileEngine reads a pcap or pcapng file, decoding it natively like a live
+// capture. Packets keep the file's timestamps and come as fast as they are
+// taken; the channel closes at the end of the file.
+type FileEngine struct {
+	CaptureOptions
+	Path string
+}
+
+func (e *FileEngine) Start(ctx context.Context) (<-chan *Packet, error) {
+	f, err := os.Open(e.Path)
+	if err != nil {
+		return nil, err
+	}
+	src, err := newFileSource(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", e.Path, err)
+	}
+	var filter packetFilter
+	if e.Filter != "" {
+		if filter, err = parsePacketFilter(e.Filter); err != nil {
+			src.Close()
+			return nil, err
+		}
+	}
+
+	e.logf("Reading packets from %s...\n", e.Path)
+	if e.Filter != "" {
+		e.logf("Filter: %s\n", e.Filter)
+	}
+	e.logf("---\n")
+
+	decoder := &frameDecoder{payload: e.Payload}
+	packets := make(chan *Packet, 64)
+	count := 0
+	go func() {
+		defer close(packets)
+		defer src.Close()
+
+		for ctx.Err() == nil {
+			frame, link, ts, length, err := src.ReadFrame()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				e.logf("Stopped reading %s: %v\n", e.Path, err)
+				break
+			}
+			if frame == nil {
+				continue
+			}
+			// Relative times count from the first frame of the file
+			if decoder.start.IsZero() {
+				decoder.start = ts
+			}
+			pkt := decoder.decode(frame, link, ts, length)
+			if pkt == nil || (filter != nil && !filter(pkt)) {
+				continue
+			}
+			count++
+			pkt.Number = strconv.Itoa(count)
+			select {
+			case <-ctx.Done():
+				return
+			case packets <- pkt:
+			}
+		}
+		if src.skipped > 0 {
+			e.logf("Skipped %d frames of unsupported link types in %s\n", src.skipped, e.Path)
+		}
+	}()
+	return packets, nil
+}
+
+// pcapInterface is an interface of a pcapng section, classic pcap files have one
+type pcapInterface struct {
+	link   int
+	units  uint64 // timestamp units per second
+	offset int64  // seconds added to every timestamp
+}
+
+// fileSource reads frames from a classic pcap file, in either byte order with
+// micro or nanosecond timestamps, or from a pcapng file with any number of
+// sections and interfaces
+type fileSource struct {
+	f       *os.File
+	r       *bufio.Reader
+	order   binary.ByteOrder
+	ng      bool
+	ifaces  []pcapInterface
+	last    time.Time // for simple packet blocks, which carry no timestamp
+	skipped int
+}
+
+const (
+	pcapMagicMicro = 0xA1B2C3D4
+	pcapMagicNano  = 0xA1B23C4D
+	pcapngSection  = 0x0A0D0D0A
+	pcapngOrder    = 0x1A2B3C4D
+)
+
+func newFileSource(f *os.File) (*fileSource, error) {
+	s := &fileSource{f: f, r: bufio.NewReaderSize(f, 1<<16)}
+	head, err := s.r.Peek(4)
+	if err != nil {
+		return nil, errors.New("not a pcap or pcapng file")
+	}
+	if binary.LittleEndian.Uint32(head) == pcapngSection {
+		s.ng = true
+		// The section header read with the first frame sets the byte order
+		return s, nil
+	}
+
+	var hdr [24]byte
+	if _, err := io.ReadFull(s.r, hdr[:]); err != nil {
+		return nil, errors.New("not a pcap or pcapng file")
+	}
+	iface := pcapInterface{units: 1e6}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(hdr[0:]) {
+		case pcapMagicMicro:
+			s.order = order
+		case pcapMagicNano:
+			s.order, iface.units = order, 1e9
+		}
+		if s.order != nil {
+			break
+		}
+	}
+	if s.order == nil {
+		return nil, errors.New("not a pcap or pcapng file")
+	}
+	// The top bits of the link type flag frame check sequences
+	iface.link = int(s.order.Uint32(hdr[20:]) & 0xFFFF)
+	s.ifaces = []pcapInterface{iface}
+	return s, nil
+}
+
+func (s *fileSource) ReadFrame() ([]byte, int, time.Time, int, error) {
+	if !s.ng {
+		return s.readRecord()
+	}
+	return s.readBlock()
+}
+
+func (s *fileSource) readRecord() ([]byte, int, time.Time, int, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(s.r, hdr[:]); err != nil {
+		return nil, 0, time.Time{}, 0, eof(err)
+	}
+	captured := s.order.Uint32(hdr[8:])
+	if captured > 1<<18 {
+		return nil, 0, time.Time{}, 0, fmt.Errorf("invalid record length %d", captured)
+	}
+	frame := make([]byte, captured)
+	if _, err := io.ReadFull(s.r, frame); err != nil {
+		return nil, 0, time.Time{}, 0, eof(err)
+	}
+	iface := s.ifaces[0]
+	ts := uint64(s.order.Uint32(hdr[0:]))*iface.units + uint64(s.order.Uint32(hdr[4:]))
+	return s.frame(frame, iface, ts, int(s.order.Uint32(hdr[12:])))
+}
+
+// Reading blocks until the next packet, taking in section headers and
+// interface descriptions on the way
+func (s *fileSource) readBlock() ([]byte, int, time.Time, int, error) {
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(s.r, hdr[:]); err != nil {
+			return nil, 0, time.Time{}, 0, eof(err)
+		}
+		if binary.LittleEndian.Uint32(hdr[0:]) == pcapngSection {
+			magic, err := s.r.Peek(4)
+			if err != nil {
+				return nil, 0, time.Time{}, 0, eof(err)
+			}
+			switch {
+			case binary.LittleEndian.Uint32(magic) == pcapngOrder:
+				s.order = binary.LittleEndian
+			case binary.BigEndian.Uint32(magic) == pcapngOrder:
+				s.order = binary.BigEndian
+			default:
+				return nil, 0, time.Time{}, 0, errors.New("invalid pcapng section header")
+			}
+			s.ifaces = nil
+		}
+		kind, total := s.order.Uint32(hdr[0:]), s.order.Uint32(hdr[4:])
+		if total < 12 || total%4 != 0 || total > 1<<20 {
+			return nil, 0, time.Time{}, 0, fmt.Errorf("invalid pcapng block length %d", total)
+		}
+		body := make([]byte, total-8)
+		if _, err := io.ReadFull(s.r, body); err != nil {
+			return nil, 0, time.Time{}, 0, eof(err)
+		}
+		body = body[:len(body)-4]
+
+		switch {
+		case kind == 1 && len(body) >= 8:
+			s.ifaces = append(s.ifaces, s.describe(body))
+		case kind == 6 && len(body) >= 20:
+			// Enhanced packet block
+			iface, ok := s.iface(int(s.order.Uint32(body[0:])))
+			captured := int(s.order.Uint32(body[12:]))
+			if !ok || captured > len(body)-20 {
+				return nil, 0, time.Time{}, 0, errors.New("invalid pcapng packet block")
+			}
+			ts := uint64(s.order.Uint32(body[4:]))<<32 | uint64(s.order.Uint32(body[8:]))
+			return s.frame(body[20:20+captured], iface, ts, int(s.order.Uint32(body[16:])))
+		case kind == 2 && len(body) >= 20:
+			// Obsolete packet block, an enhanced one with a 16 bit interface
+			iface, ok := s.iface(int(s.order.Uint16(body[0:])))
+			captured := int(s.order.Uint32(body[12:]))
+			if !ok || captured > len(body)-20 {
+				return nil, 0, time.Time{}, 0, errors.New("invalid pcapng packet block")
+			}
+			ts := uint64(s.order.Uint32(body[4:]))<<32 | uint64(s.order.Uint32(body[8:]))
+			return s.frame(body[20:20+captured], iface, ts, int(s.order.Uint32(body[16:])))
+		case kind == 3 && len(body) >= 4:
+			// Simple packet block, always the first interface
+			iface, ok := s.iface(0)
+			if !ok {
+				return nil, 0, time.Time{}, 0, errors.New("pcapng packet block without an interface")
+			}
+			length := int(s.order.Uint32(body[0:]))
+			frame := body[4:]
+			if len(frame) > length {
+				frame = frame[:length]
+			}
+			return s.convert(frame, iface, s.last, length)
+		}
+	}
+}
+
+// An interface description block: link type, snap length and options, of
+// which the timestamp resolution and offset matter here
+func (s *fileSource) describe(body []byte) pcapInterface {
+	iface := pcapInterface{link: int(s.order.Uint16(body[0:])), units: 1e6}
+	for opts := body[8:]; len(opts) >= 4; {
+		code, n := s.order.Uint16(opts[0:]), int(s.order.Uint16(opts[2:]))
+		if code == 0 || 4+n > len(opts) {
+			break
+		}
+		value := opts[4 : 4+n]
+		switch {
+		case code == 9 && n == 1:
+			// if_tsresol: a negative power of 10, or of 2 with the top bit set
+			if value[0]&0x80 != 0 {
+				iface.units = 1 << (value[0] & 0x3F)
+			} else if value[0] <= 19 {
+				iface.units = 1
+				for range value[0] {
+					iface.units *= 10
+				}
+			}
+		case code == 14 && n == 8:
+			iface.offset = int64(s.order.Uint64(value))
+		}
+		opts = opts[4+(n+3)&^3:]
+	}
+	return iface
+}
+
+func (s *fileSource) iface(id int) (pcapInterface, bool) {
+	if id < 0 || id >= len(s.ifaces) {
+		return pcapInterface{}, false
+	}
+	return s.ifaces[id], true
+}
+
+// Turning a timestamp in the interface's units into a time
+func (s *fileSource) frame(frame []byte, iface pcapInterface, ts uint64, length int) ([]byte, int, time.Time, int, error) {
+	if iface.units == 0 {
+		iface.units = 1e6
+	}
+	sec, frac := ts/iface.units, ts%iface.units
+	hi, lo := bits.Mul64(frac, 1e9)
+	nsec, _ := bits.Div64(hi, lo, iface.units)
+	t := time.Unix(int64(sec)+iface.offset, int64(nsec))
+	s.last = t
+	return s.convert(frame, iface, t, length)
+}
+
+// Handing Ethernet and raw IP frames to the decoder as they are and the other
+// supported link types as raw IP. Frames of other types come back nil.
+func (s *fileSource) convert(frame []byte, iface pcapInterface, ts time.Time, length int) ([]byte, int, time.Time, int, error) {
+	raw := func(header int, ip bool) ([]byte, int, time.Time, int, error) {
+		if !ip || len(frame) <= header {
+			return nil, 0, time.Time{}, 0, nil
+		}
+		return frame[header:], linkRaw, ts, length - header, nil
+	}
+	ipType := func(t uint16) bool { return t == 0x0800 || t == 0x86DD }
+	switch iface.link {
+	case linkEthernet, linkRaw:
+		return frame, iface.link, ts, length, nil
+	case linkIPv4, linkIPv6:
+		return frame, linkRaw, ts, length, nil
+	case linkSLL:
+		return raw(16, len(frame) >= 16 && ipType(binary.BigEndian.Uint16(frame[14:])))
+	case linkSLL2:
+		return raw(20, len(frame) >= 20 && ipType(binary.BigEndian.Uint16(frame[0:])))
+	case linkNull:
+		if len(frame) < 4 {
+			break
+		}
+		// AF_INET is 2 everywhere, AF_INET6 differs between the BSDs
+		family := binary.LittleEndian.Uint32(frame)
+		if family > 0xFFFF {
+			family = binary.BigEndian.Uint32(frame)
+		}
+		return raw(4, family == 2 || family == 10 || family == 24 || family == 28 || family == 30)
+	}
+	s.skipped++
+	return nil, 0, time.Time{}, 0, nil
+}
+
+// A file ending within a record is cut short, not cleanly finished
+func eof(err error) error {
+	if err == io.ErrUnexpectedEOF {
+		return errors.New("file is truncated")
+	}
+	return err
+}
+
+func (s *fileSource) LinkType() int {
+	if len(s.ifaces) == 0 {
+		return linkEthernet
+	}
+	return s.ifaces[0].link
+}
+
+func (s *fileSource) Filtered() bool { return false }
+
+func (s *fileSource) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,61 @@
+package netwatch
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// RTPHeader is the fixed header of an RTP packet. SRTP encrypts only the
+// payload, so SRTP packets have one too.
+type RTPHeader struct {
+	PayloadType int
+	Marker      bool
+	Seq         uint16
+	Timestamp   uint32
+	SSRC        uint32
+}
+
+// Naming UDP payloads without a well known port the way tshark's heuristic
+// dissectors do: STUN by its magic cookie, DTLS by its record header, and
+// RTP by its version 2 header between unprivileged ports, then the
+// peer-to-peer protocols.
+func udpHeuristicLayer(p *Packet, b []byte) string {
+	switch {
+	case len(b) >= 20 && b[0]&0xc0 == 0 && binary.BigEndian.Uint32(b[4:8]) == 0x2112a442:
+		return "stun"
+	case len(b) >= 13 && b[0] >= 20 && b[0] <= 25 && b[1] == 0xfe && (b[2] == 0xff || b[2] == 0xfd):
+		return "dtls"
+	case p.SrcPort >= 1024 && p.DstPort >= 1024 && rtpHeader(b) != nil:
+		return "rtp"
+	}
+	return udpP2PLayer(p, b)
+}
+
+// Parsing an RTP header, nil when b doesn't look like one. RTCP shares the
+// version bits, its packet types 200 to 204 fall in payload types 72 to 76.
+func rtpHeader(b []byte) *RTPHeader {
+	if len(b) < 12 || b[0]>>6 != 2 {
+		return nil
+	}
+	pt := int(b[1] & 0x7f)
+	if pt > 34 && pt < 96 {
+		return nil
+	}
+	if 12+4*int(b[0]&0x0f) > len(b) {
+		return nil
+	}
+	return &RTPHeader{
+		PayloadType: pt,
+		Marker:      b[1]&0x80 != 0,
+		Seq:         binary.BigEndian.Uint16(b[2:4]),
+		Timestamp:   binary.BigEndian.Uint32(b[4:8]),
+		SSRC:        binary.BigEndian.Uint32(b[8:12]),
+	}
+}
+
+func decodeRTP(p *Packet, b []byte) {
+	p.RTP = rtpHeader(b)
+	if p.RTP != nil {
+		p.Info = fmt.Sprintf("PT=%d, SSRC=0x%X, Seq=%d, Time=%d", p.RTP.PayloadType, p.RTP.SSRC, p.RTP.Seq, p.RTP.Timestamp)
+	}
+}
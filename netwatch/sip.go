@@ -0,0 +1,111 @@
+package netwatch
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SIPMessage is the part of a SIP request or response that follows a call
+type SIPMessage struct {
+	Method     string // request method, empty in a response
+	Status     int    // response status code
+	Reason     string // response reason phrase
+	CallID     string
+	CSeqMethod string // the request a response answers
+	From       string // SIP URI
+	To         string
+	MediaAddr  string // SDP connection address
+	MediaPorts []int  // SDP media ports
+}
+
+// Compact header names, RFC 3261 section 7.3.3
+var sipCompactHeaders = map[string]string{"i": "call-id", "f": "from", "t": "to", "m": "contact", "l": "content-length", "c": "content-type"}
+
+// Parsing a SIP message with SDP, nil when b doesn't start like one. Over
+// TCP only messages starting a segment are seen.
+func parseSIP(b []byte) *SIPMessage {
+	head, body, _ := strings.Cut(string(b), "\r\n\r\n")
+	lines := strings.Split(head, "\r\n")
+	first := strings.Fields(lines[0])
+	if len(first) < 3 {
+		return nil
+	}
+	m := &SIPMessage{}
+	switch {
+	case strings.HasPrefix(first[0], "SIP/2.0"):
+		m.Status, _ = strconv.Atoi(first[1])
+		m.Reason = strings.Join(first[2:], " ")
+	case strings.HasPrefix(first[2], "SIP/2.0"):
+		m.Method = first[0]
+	default:
+		return nil
+	}
+
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if long, ok := sipCompactHeaders[name]; ok {
+			name = long
+		}
+		value = strings.TrimSpace(value)
+		switch name {
+		case "call-id":
+			m.CallID = value
+		case "cseq":
+			if f := strings.Fields(value); len(f) == 2 {
+				m.CSeqMethod = f[1]
+			}
+		case "from":
+			m.From = sipURI(value)
+		case "to":
+			m.To = sipURI(value)
+		}
+	}
+
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "c=IN "):
+			// c=IN IP4 192.0.2.10
+			if f := strings.Fields(line); len(f) == 3 {
+				m.MediaAddr = f[2]
+			}
+		case strings.HasPrefix(line, "m="):
+			// m=audio 49170 RTP/AVP 0 8
+			if f := strings.Fields(line); len(f) >= 2 {
+				if port, err := strconv.Atoi(f[1]); err == nil && port > 0 {
+					m.MediaPorts = append(m.MediaPorts, port)
+				}
+			}
+		}
+	}
+	return m
+}
+
+// The URI of a From or To header, without display name and tag
+func sipURI(v string) string {
+	if i := strings.Index(v, "<"); i >= 0 {
+		if j := strings.Index(v[i:], ">"); j > 0 {
+			return v[i+1 : i+j]
+		}
+	}
+	if i := strings.Index(v, ";"); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v)
+}
+
+func decodeSIP(p *Packet, b []byte) {
+	p.SIP = parseSIP(b)
+	if p.SIP == nil {
+		return
+	}
+	if p.SIP.Method != "" {
+		p.Info = "Request: " + p.SIP.Method + " " + p.SIP.To
+	} else {
+		p.Info = "Status: " + strconv.Itoa(p.SIP.Status) + " " + p.SIP.Reason
+	}
+}
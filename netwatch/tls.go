@@ -0,0 +1,56 @@
+package netwatch
+
+import "encoding/binary"
+
+// The server name of a TLS ClientHello, empty when b doesn't start with one
+// or the extension lies beyond this segment
+func clientHelloSNI(b []byte) string {
+	// Record header, then the handshake header of a ClientHello
+	if len(b) < 9 || b[0] != 0x16 || b[5] != 1 {
+		return ""
+	}
+	b = b[9:]
+	// Version and random, then session id, cipher suites and compression methods
+	if len(b) < 35 {
+		return ""
+	}
+	b = b[34:]
+	for _, width := range []int{1, 2, 1} {
+		if len(b) < width {
+			return ""
+		}
+		n := int(b[0])
+		if width == 2 {
+			n = int(binary.BigEndian.Uint16(b))
+		}
+		if len(b) < width+n {
+			return ""
+		}
+		b = b[width+n:]
+	}
+	if len(b) < 2 {
+		return ""
+	}
+	b = b[2:]
+	for len(b) >= 4 {
+		typ, n := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if len(b) < 4+n {
+			return ""
+		}
+		ext := b[4 : 4+n]
+		b = b[4+n:]
+		if typ != 0 {
+			continue
+		}
+		// server_name_list with one host_name entry
+		if len(ext) < 5 || ext[2] != 0 {
+			return ""
+		}
+		l := int(binary.BigEndian.Uint16(ext[3:]))
+		if len(ext) < 5+l {
+			return ""
+		}
+		return string(ext[5 : 5+l])
+	}
+	return ""
+}
@@ -0,0 +1,11 @@
+package netwatch
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Starting tshark as c, whose dumpcap then needs capture rights of its own
+func runAs(cmd *exec.Cmd, c *Credential) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: c.UID, Gid: c.GID, Groups: c.Groups}}
+}
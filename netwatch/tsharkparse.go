@@ -0,0 +1,167 @@
+package netwatch
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fields renamed or added in Wireshark 3.0, swapped for their older
+// equivalent when an older tshark is found
+var legacyFieldNames = map[string]string{
+	"tls.handshake.extensions_server_name": "ssl.handshake.extensions_server_name",
+	"tls.handshake.certificate":            "ssl.handshake.certificate",
+	"tls.handshake.type":                   "ssl.handshake.type",
+	"tls.handshake.version":                "ssl.handshake.version",
+	"dhcp.option.hostname":                 "bootp.option.hostname",
+	"tcp.seq_raw":                          "tcp.seq",
+}
+
+// tsharkVersion is the major/minor version of the installed tshark, zero if unknown
+type tsharkVersion struct {
+	Major, Minor int
+}
+
+var tsharkVersionRe = regexp.MustCompile(`TShark \(Wireshark\) (\d+)\.(\d+)`)
+
+func detectTsharkVersion() tsharkVersion {
+	out, err := exec.Command("tshark", "--version").Output()
+	if err != nil {
+		return tsharkVersion{}
+	}
+	return parseTsharkVersion(string(out))
+}
+
+func parseTsharkVersion(s string) tsharkVersion {
+	m := tsharkVersionRe.FindStringSubmatch(s)
+	if m == nil {
+		return tsharkVersion{}
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	return tsharkVersion{major, minor}
+}
+
+func (v tsharkVersion) String() string {
+	if v.Major == 0 {
+		return "unknown"
+	}
+	return strconv.Itoa(v.Major) + "." + strconv.Itoa(v.Minor)
+}
+
+// Field name to ask this tshark for, keeping the column order of the layout
+func (v tsharkVersion) fieldName(f string) string {
+	if v.Major > 0 && v.Major < 3 {
+		if legacy, ok := legacyFieldNames[f]; ok {
+			return legacy
+		}
+	}
+	return f
+}
+
+// recordScanner splits tshark -T fields output into packets. Field values
+// containing newlines are rejoined, records cut short are padded, and
+// tshark's default summary lines are understood too in case -T was ignored.
+type recordScanner struct {
+	layout    *fieldLayout
+	r         *bufio.Reader
+	ahead     *string
+	Truncated int
+	Summary   int
+}
+
+func newRecordScanner(r io.Reader, layout *fieldLayout) *recordScanner {
+	// bufio.Reader has no line length limit, certificate fields can be huge
+	return &recordScanner{layout: layout, r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+func (s *recordScanner) readLine() (string, error) {
+	if s.ahead != nil {
+		line := *s.ahead
+		s.ahead = nil
+		return line, nil
+	}
+	line, err := s.r.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Next returns the next packet, or the raw text of a line that is not one
+// (tshark status messages). It returns io.EOF when the output ends.
+func (s *recordScanner) Next() (*Packet, string, error) {
+	line, err := s.readLine()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !isRecordStart(line) {
+		if p := parseSummaryLine(line); p != nil {
+			s.Summary++
+			return p, "", nil
+		}
+		return nil, line, nil
+	}
+
+	want := len(s.layout.fields)
+	for strings.Count(line, "\t")+1 < want {
+		next, err := s.readLine()
+		if err != nil || isRecordStart(next) {
+			if err == nil {
+				s.ahead = &next
+			}
+			// Padding the missing columns keeps whatever did arrive
+			s.Truncated++
+			line += strings.Repeat("\t", want-strings.Count(line, "\t")-1)
+			break
+		}
+		line += " " + next
+	}
+
+	p, err := s.layout.parse(line)
+	if err != nil {
+		return nil, line, nil
+	}
+	return p, "", nil
+}
+
+// A record starts with frame.number and frame.time_epoch
+func isRecordStart(line string) bool {
+	num, rest, ok := strings.Cut(line, "\t")
+	if !ok || num == "" || strings.Trim(num, "0123456789") != "" {
+		return false
+	}
+	epoch, _, _ := strings.Cut(rest, "\t")
+	return epoch != "" && strings.Trim(epoch, "0123456789.,") == ""
+}
+
+// tshark's default one line summary, e.g. "3 0.001 10.0.0.1 → 10.0.0.2 TCP 66 443 → 51000 [ACK]"
+var summaryLineRe = regexp.MustCompile(`^\s*(\d+)\s+(\d+[.,]\d+)\s+(\S+)\s+(?:→|->)\s+(\S+)\s+(\S+)\s+(\d+)\s*(.*)$`)
+
+func parseSummaryLine(line string) *Packet {
+	m := summaryLineRe.FindStringSubmatch(line)
+	if m == nil {
+		return nil
+	}
+	p := &Packet{
+		Number:      m[1],
+		Relative:    m[2],
+		Source:      m[3],
+		Destination: m[4],
+		Protocol:    m[5],
+		Info:        m[7],
+		Time:        time.Now(),
+	}
+	p.Length, _ = strconv.Atoi(m[6])
+	// Only addresses are useful to the trackers, not resolved names
+	if net.ParseIP(p.Source) != nil && net.ParseIP(p.Destination) != nil {
+		p.SrcIP, p.DstIP = p.Source, p.Destination
+	}
+	return p
+}
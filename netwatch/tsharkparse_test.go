@@ -0,0 +1,129 @@
+package netwatch
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// A -T fields record in layout l with the given columns set
+func testRecord(l *fieldLayout, values map[string]string) string {
+	cols := make([]string, len(l.fields))
+	for f, v := range values {
+		if i, ok := l.index[f]; ok {
+			cols[i] = v
+		}
+	}
+	return strings.Join(cols, "\t")
+}
+
+// Records in the layouts tshark is asked for, old and new
+func seedRecords() []string {
+	tcp := map[string]string{
+		"frame.number": "1", "frame.time_epoch": "1700000000.123456", "frame.time_relative": "0.000000", "frame.len": "74",
+		"frame.protocols": "eth:ethertype:ip:tcp", "ip.src": "192.168.1.10", "ip.dst": "93.184.216.34", "ip.ttl": "64",
+		"tcp.srcport": "51000", "tcp.dstport": "443", "tcp.flags.syn": "1", "tcp.flags.ack": "0", "_ws.col.Protocol": "TCP",
+		"_ws.col.Info": "51000 → 443 [SYN] Seq=0 Win=64240",
+	}
+	dns := map[string]string{
+		"frame.number": "2", "frame.time_epoch": "1700000000,5", "frame.len": "120", "frame.protocols": "eth:ethertype:ipv6:udp:dns",
+		"ipv6.src": "fe80::1", "ipv6.dst": "fe80::2", "udp.srcport": "53", "udp.dstport": "5060", "dns.flags.response": "True",
+		"dns.id": "0x1a2b", "dns.flags.rcode": "3", "dns.qry.type": "1", "dns.count.answers": "0", "dns.qry.name": "example.com",
+		"dns.a": "10.0.0.1,10.0.0.2", "_ws.col.Info": "Standard query response 0x1a2b No such name A example.com",
+	}
+	sip := map[string]string{
+		"frame.number": "3", "frame.time_epoch": "1700000001.0", "udp.srcport": "5060", "udp.dstport": "5060",
+		"sip.Call-ID": "abc@host", "sip.Status-Line": "SIP/2.0 486 Busy Here", "sdp.media.port": "4000,x,-1",
+		"smb2.cmd": "5", "smb2.msg_id": "0xzz", "rtp.seq": "70000", "stp.type": "0x02", "icmpv6.type": "134,135",
+	}
+	payload := newFieldLayout(payloadFields...)
+	legacy := &fieldLayout{fields: tsharkFields[:len(tsharkFields)-8], index: make(map[string]int)}
+	for i, f := range legacy.fields {
+		legacy.index[f] = i
+	}
+	return []string{
+		testRecord(defaultLayout, tcp),
+		testRecord(defaultLayout, dns),
+		testRecord(defaultLayout, sip),
+		testRecord(payload, map[string]string{"frame.number": "4", "frame.time_epoch": "1", "udp.srcport": "1",
+			"udp.payload": "de:ad:be:ef:0"}),
+		testRecord(legacy, tcp),
+	}
+}
+
+func FuzzParsePacket(f *testing.F) {
+	for _, r := range seedRecords() {
+		f.Add(r)
+		f.Add(r[:len(r)/2])
+		f.Add(strings.ReplaceAll(r, "\t", "\t\t"))
+	}
+	f.Add("")
+	f.Add("\t")
+	f.Add("1\t1700000000.0" + strings.Repeat("\t", len(tsharkFields)))
+
+	f.Fuzz(func(t *testing.T, line string) {
+		p, err := ParsePacket(line)
+		if strings.Count(line, "\t")+1 < len(defaultLayout.fields) {
+			if err == nil {
+				t.Fatalf("short record parsed: %q", line)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("full record rejected: %v", err)
+		}
+		if num, _, _ := strings.Cut(line, "\t"); p.Number != num {
+			t.Fatalf("number %q, want %q", p.Number, num)
+		}
+	})
+}
+
+func FuzzRecordScanner(f *testing.F) {
+	records := seedRecords()
+	f.Add(strings.Join(records, "\n")+"\n", false)
+	// A certificate or Info column broken over lines
+	f.Add(strings.Replace(records[0], "\t", "\n", 20)+"\n"+records[1], false)
+	// Cut short by a capture ending mid record
+	f.Add(records[0][:40]+"\n"+records[1][:60], false)
+	f.Add(records[3]+"\r\n"+records[2]+"\r\n", true)
+	// -T ignored, the default summary lines instead
+	f.Add("    1 0.000000 10.0.0.1 → 10.0.0.2 TCP 66 443 → 51000 [ACK]\n  2 0,001 fe80::1 -> ff02::1 ICMPv6 86 Router Advertisement\n", false)
+	f.Add("Capturing on 'eth0'\n"+records[0]+"\n1 packet captured\n", false)
+	f.Add("1\t2\n3\t4\n\n\t\n", true)
+
+	f.Fuzz(func(t *testing.T, input string, payload bool) {
+		layout := defaultLayout
+		if payload {
+			layout = newFieldLayout(payloadFields...)
+		}
+		// Every line starting with a frame number and epoch is a packet
+		want := 0
+		for _, line := range strings.Split(input, "\n") {
+			if isRecordStart(strings.TrimRight(line, "\r\n")) {
+				want++
+			}
+		}
+
+		s := newRecordScanner(strings.NewReader(input), layout)
+		got := 0
+		for i := 0; ; i++ {
+			if i > len(input)+1 {
+				t.Fatal("scanner does not advance")
+			}
+			p, _, err := s.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p != nil {
+				got++
+			}
+		}
+		if got-s.Summary != want {
+			t.Fatalf("%d records, want %d", got-s.Summary, want)
+		}
+	})
+}
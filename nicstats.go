@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	linux "netwatchd/netstat"
+)
+
+// NICReport holds driver counter growth and offload settings for one interface
+type NICReport struct {
+	Interface string
+	Deltas    map[string]uint64
+	Offloads  map[string]string
+}
+
+// Counters whose growth usually means the NIC or driver is dropping traffic
+var nicAnomalyWords = []string{"drop", "miss", "err", "overflow", "fifo", "discard", "timeout", "no_buf", "nobuf"}
+
+// Offloads worth knowing about when reading a capture
+var reportedOffloads = []string{
+	"rx-checksumming",
+	"tx-checksumming",
+	"scatter-gather",
+	"tcp-segmentation-offload",
+	"generic-segmentation-offload",
+	"generic-receive-offload",
+	"large-receive-offload",
+	"rx-vlan-offload",
+}
+
+// Snapshotting driver stats at start and end of the capture window
+func collectNICStats(ctx context.Context, data *MonitoringData, iface string) {
+	before, err := linux.GetNICStats(iface)
+	if err != nil {
+		return
+	}
+	offloads, _ := linux.GetOffloads(iface)
+
+	<-ctx.Done()
+
+	after, err := linux.GetNICStats(iface)
+	if err != nil {
+		return
+	}
+
+	report := &NICReport{
+		Interface: iface,
+		Deltas:    make(map[string]uint64),
+		Offloads:  offloads,
+	}
+	for name, value := range after {
+		if old, ok := before[name]; ok && value > old {
+			report.Deltas[name] = value - old
+		}
+	}
+
+	data.mu.Lock()
+	data.nic = report
+	data.mu.Unlock()
+}
+
+func isNICAnomaly(name string) bool {
+	name = strings.ToLower(name)
+	for _, w := range nicAnomalyWords {
+		if strings.Contains(name, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func printNICReport(r *NICReport) {
+	if r == nil {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Printf("NIC STATISTICS (%s)\n", r.Interface)
+
+	var anomalies []string
+	for name := range r.Deltas {
+		if isNICAnomaly(name) {
+			anomalies = append(anomalies, name)
+		}
+	}
+	sort.Strings(anomalies)
+	if len(anomalies) == 0 {
+		fmt.Println("No driver drop or error counters grew")
+	}
+	for _, name := range anomalies {
+		fmt.Printf("WARNING: %s grew by %d\n", name, r.Deltas[name])
+	}
+
+	var enabled []string
+	for _, name := range reportedOffloads {
+		if r.Offloads[name] == "on" {
+			enabled = append(enabled, name)
+		}
+	}
+	if len(enabled) > 0 {
+		fmt.Printf("Offloads on: %s\n", strings.Join(enabled, ", "))
+		if r.Offloads["generic-receive-offload"] == "on" || r.Offloads["large-receive-offload"] == "on" {
+			fmt.Println("Note: receive offload merges segments, captured frames may exceed the MTU")
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"netwatchd/iphlpapi"
+	linux "netwatchd/netstat"
+)
+
+// HealthCounter is the growth of one OS network stack counter over the run
+type HealthCounter struct {
+	Name  string
+	Delta uint64
+}
+
+// Kernel counters sampled on Linux and the names they are reported under
+var linuxHealthCounters = [][2]string{
+	{"Tcp.OutSegs", "TCP segments sent"},
+	{"Tcp.RetransSegs", "TCP segments retransmitted"},
+	{"Tcp.InErrs", "TCP segments received with errors"},
+	{"Tcp.AttemptFails", "TCP failed connection attempts"},
+	{"Tcp.EstabResets", "TCP established connections reset"},
+	{"Tcp.OutRsts", "TCP resets sent"},
+	{"TcpExt.ListenOverflows", "Listen queue overflows"},
+	{"TcpExt.ListenDrops", "Listen queue drops"},
+	{"TcpExt.TCPBacklogDrop", "Socket backlog drops"},
+	{"TcpExt.TCPRcvQDrop", "Receive queue drops"},
+	{"Udp.RcvbufErrors", "UDP receive buffer errors"},
+	{"Udp.SndbufErrors", "UDP send buffer errors"},
+}
+
+// Counters that should stay at zero on a healthy server
+var socketDropCounters = []string{
+	"Listen queue overflows",
+	"Listen queue drops",
+	"Socket backlog drops",
+	"Receive queue drops",
+	"UDP receive buffer errors",
+}
+
+// Absolute counter values from one sample, in report order
+type healthSample struct {
+	names  []string
+	values map[string]uint64
+}
+
+func (s *healthSample) add(name string, value uint64) {
+	if s.values == nil {
+		s.values = make(map[string]uint64)
+	}
+	if _, ok := s.values[name]; !ok {
+		s.names = append(s.names, name)
+	}
+	s.values[name] += value
+}
+
+// Sampling the OS network stack counters for this platform
+func sampleOSHealth() (*healthSample, error) {
+	s := &healthSample{}
+	switch runtime.GOOS {
+	case "windows":
+		for _, family := range []uint32{iphlpapi.AF_INET, iphlpapi.AF_INET6} {
+			stats, err := iphlpapi.GetTcpStatistics(family)
+			if err != nil {
+				return nil, err
+			}
+			s.add("TCP segments sent", uint64(stats.OutSegs))
+			s.add("TCP segments retransmitted", uint64(stats.RetransSegs))
+			s.add("TCP segments received with errors", uint64(stats.InErrs))
+			s.add("TCP failed connection attempts", uint64(stats.AttemptFails))
+			s.add("TCP established connections reset", uint64(stats.EstabResets))
+			s.add("TCP resets sent", uint64(stats.OutRsts))
+		}
+	case "linux":
+		counters, err := linux.GetProtocolCounters()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range linuxHealthCounters {
+			s.add(c[1], counters[c[0]])
+		}
+	default:
+		return nil, fmt.Errorf("no OS health provider for %s", runtime.GOOS)
+	}
+	return s, nil
+}
+
+// Snapshotting OS counters at start and end of the capture window
+func collectOSHealth(ctx context.Context, data *MonitoringData) {
+	before, err := sampleOSHealth()
+	if err != nil {
+		return
+	}
+
+	<-ctx.Done()
+
+	after, err := sampleOSHealth()
+	if err != nil {
+		return
+	}
+
+	var counters []HealthCounter
+	for _, name := range after.names {
+		var delta uint64
+		if after.values[name] > before.values[name] {
+			delta = after.values[name] - before.values[name]
+		}
+		counters = append(counters, HealthCounter{Name: name, Delta: delta})
+	}
+
+	data.mu.Lock()
+	data.osHealth = counters
+	data.mu.Unlock()
+}
+
+func printOSHealthReport(counters []HealthCounter) {
+	if len(counters) == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("OS HEALTH")
+	values := make(map[string]uint64)
+	for _, c := range counters {
+		values[c.Name] = c.Delta
+		fmt.Printf("%s: %d\n", c.Name, c.Delta)
+	}
+	if sent := values["TCP segments sent"]; sent > 0 {
+		fmt.Printf("TCP retransmission rate: %.2f%%\n", float64(values["TCP segments retransmitted"])/float64(sent)*100)
+	}
+	for _, name := range socketDropCounters {
+		if values[name] > 0 {
+			fmt.Printf("WARNING: %s increased by %d, applications are not accepting or reading fast enough\n", name, values[name])
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Outage is a window with no captured traffic and failing connectivity probes
+type Outage struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Watching for sustained silence on the wire combined with failing probes
+func monitorOutages(ctx context.Context, data *MonitoringData, target string, quiet time.Duration) {
+	const interval = 5 * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastProbeOK := time.Now()
+	var current *Outage
+
+	for {
+		select {
+		case <-ctx.Done():
+			if current != nil {
+				data.mu.Lock()
+				current.End = time.Now()
+				data.outages = append(data.outages, *current)
+				data.mu.Unlock()
+			}
+			return
+		case now := <-ticker.C:
+			probeOK := probeTCP(target, 2*time.Second)
+			if probeOK {
+				lastProbeOK = now
+			}
+
+			data.mu.Lock()
+			if data.paused {
+				data.mu.Unlock()
+				continue
+			}
+			silent := now.Sub(data.lastPacketTime) >= quiet
+
+			switch {
+			case current == nil && silent && !probeOK:
+				// The link was last known good at the later of the two signals
+				start := data.lastPacketTime
+				if lastProbeOK.After(start) {
+					start = lastProbeOK
+				}
+				current = &Outage{Start: start}
+				data.raiseAlert("outage", "connectivity", fmt.Sprintf("connectivity lost: no traffic since %s and %s unreachable", data.lastPacketTime.Format("15:04:05"), target), now)
+			case current != nil && (probeOK || !silent):
+				current.End = now
+				data.outages = append(data.outages, *current)
+				data.resolveAlert("outage", "connectivity", fmt.Sprintf("connectivity restored after %s", current.End.Sub(current.Start).Round(time.Second)), now)
+				current = nil
+			}
+			data.mu.Unlock()
+		}
+	}
+}
+
+func probeTCP(target string, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("tcp", target, timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func printOutageReport(outages []Outage, start, end time.Time, target string) {
+	if target == "" {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("AVAILABILITY")
+
+	var downtime time.Duration
+	for _, o := range outages {
+		d := o.End.Sub(o.Start)
+		downtime += d
+		fmt.Printf("Outage %s - %s (%s)\n", o.Start.Format("15:04:05"), o.End.Format("15:04:05"), d.Round(time.Second))
+	}
+
+	total := end.Sub(start)
+	availability := 100.0
+	if total > 0 {
+		availability = float64(total-downtime) / float64(total) * 100
+	}
+	fmt.Printf("Downtime: %s | Availability: %.3f%% (probe %s)\n", downtime.Round(time.Second), availability, target)
+}
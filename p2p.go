@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"netwatchd/netwatch"
+)
+
+// Peer-to-peer protocols by the layer the decoder or tshark names
+var p2pLayers = []struct{ layer, name string }{
+	{"bittorrent", "BitTorrent"},
+	{"bt-utp", "uTP"},
+	{"bt-dht", "DHT"},
+	{"bt-tracker", "Tracker"},
+	{"edonkey", "eDonkey"},
+	{"gnutella", "Gnutella"},
+}
+
+// Bounding memory on busy swarms, later flows are not tracked
+const p2pMaxFlows = 20000
+
+func p2pProtocol(p *netwatch.Packet) string {
+	for _, l := range p2pLayers {
+		if p.HasLayer(l.layer) {
+			return l.name
+		}
+	}
+	// HTTP tracker announces
+	if p.HasLayer("http") && strings.Contains(p.Info, "info_hash=") {
+		return "Tracker"
+	}
+	return ""
+}
+
+// P2PHost is an internal host taking part in peer-to-peer traffic
+type P2PHost struct {
+	Host      string   `json:"host"`
+	Protocols []string `json:"protocols"`
+	Peers     int      `json:"peers"`
+	Flows     int      `json:"flows"`
+	Packets   int      `json:"packets"`
+	BytesIn   int64    `json:"bytes_in"`
+	BytesOut  int64    `json:"bytes_out"`
+}
+
+type p2pFlow struct {
+	host, peer string
+	protocol   string
+	packets    int
+	in, out    int64
+}
+
+// P2PTracker recognises BitTorrent, its DHT, uTP and trackers, eDonkey and
+// Gnutella, and counts every later packet of a recognised flow towards the
+// internal host taking part. Clients use one port for uTP, DHT and their
+// encrypted peer connections, so UDP flows from a port that spoke a P2P
+// protocol count too. It is guarded by the MonitoringData mutex.
+type P2PTracker struct {
+	flows     map[string]*p2pFlow
+	endpoints map[string]string // internal ip:port that spoke a P2P protocol, by protocol
+}
+
+func NewP2PTracker() *P2PTracker {
+	return &P2PTracker{flows: make(map[string]*p2pFlow), endpoints: make(map[string]string)}
+}
+
+func (t *P2PTracker) Observe(p *netwatch.Packet) {
+	if t == nil || p.SrcIP == "" || p.Transport == "" {
+		return
+	}
+	key := flowKey(p)
+	f := t.flows[key]
+	if f == nil {
+		src, dst := fmt.Sprintf("%s:%d", p.SrcIP, p.SrcPort), fmt.Sprintf("%s:%d", p.DstIP, p.DstPort)
+		protocol := p2pProtocol(p)
+		if protocol == "" && p.Transport == "udp" {
+			if protocol = t.endpoints[src]; protocol == "" {
+				protocol = t.endpoints[dst]
+			}
+		}
+		if protocol == "" || len(t.flows) >= p2pMaxFlows {
+			return
+		}
+		f = &p2pFlow{host: p.SrcIP, peer: p.DstIP, protocol: protocol}
+		if !isLocalIP(p.SrcIP) {
+			f.host, f.peer = p.DstIP, p.SrcIP
+		}
+		if !isLocalIP(f.host) {
+			return
+		}
+		t.flows[key] = f
+		// A tracker runs over HTTP or its own port, not the client's
+		if protocol != "Tracker" {
+			if f.host == p.SrcIP {
+				t.endpoints[src] = protocol
+			} else {
+				t.endpoints[dst] = protocol
+			}
+		}
+	}
+
+	f.packets++
+	if p.SrcIP == f.host {
+		f.out += int64(p.Length)
+	} else {
+		f.in += int64(p.Length)
+	}
+}
+
+// Hosts with peer-to-peer traffic, the busiest first
+func (t *P2PTracker) Hosts() []P2PHost {
+	if t == nil {
+		return nil
+	}
+	hosts := make(map[string]*P2PHost)
+	peers := make(map[string]map[string]bool)
+	protocols := make(map[string]map[string]bool)
+	for _, f := range t.flows {
+		if f.packets == 0 {
+			continue
+		}
+		h := hosts[f.host]
+		if h == nil {
+			h = &P2PHost{Host: f.host}
+			hosts[f.host] = h
+			peers[f.host] = make(map[string]bool)
+			protocols[f.host] = make(map[string]bool)
+		}
+		h.Flows++
+		h.Packets += f.packets
+		h.BytesIn += f.in
+		h.BytesOut += f.out
+		if f.protocol != "Tracker" {
+			peers[f.host][f.peer] = true
+		}
+		protocols[f.host][f.protocol] = true
+	}
+
+	var list []P2PHost
+	for host, h := range hosts {
+		h.Peers = len(peers[host])
+		for _, l := range p2pLayers {
+			if protocols[host][l.name] {
+				h.Protocols = append(h.Protocols, l.name)
+			}
+		}
+		list = append(list, *h)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if a, b := list[i].BytesIn+list[i].BytesOut, list[j].BytesIn+list[j].BytesOut; a != b {
+			return a > b
+		}
+		return list[i].Host < list[j].Host
+	})
+	return list
+}
+
+// Starting a new period, recognised flows and ports are kept with their counts reset
+func (t *P2PTracker) reset() {
+	if t == nil {
+		return
+	}
+	for _, f := range t.flows {
+		f.packets, f.in, f.out = 0, 0, 0
+	}
+}
+
+func printP2PReport(t *P2PTracker) {
+	hosts := t.Hosts()
+	if len(hosts) == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("P2P")
+	for i, h := range hosts {
+		if i == 20 {
+			fmt.Printf("... %d more hosts\n", len(hosts)-i)
+			break
+		}
+		fmt.Printf("%s: %s | %d peers | %d flows | %.2f MB in | %.2f MB out\n", h.Host, strings.Join(h.Protocols, ", "),
+			h.Peers, h.Flows, float64(h.BytesIn)/(1024*1024), float64(h.BytesOut)/(1024*1024))
+	}
+}
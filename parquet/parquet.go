@@ -0,0 +1,349 @@
+// Package parquet writes flat tables as Apache Parquet files without
+// dependencies. Each file is one row group with one uncompressed, plainly
+// encoded page per column, which every reader understands.
+package parquet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Type is the type of a column
+type Type int
+
+const (
+	Int64 Type = iota
+	Double
+	String
+	Bool
+	Timestamp // milliseconds since the epoch, UTC
+)
+
+// Physical types, encodings and converted types of the format
+const (
+	physBoolean   = 0
+	physInt64     = 2
+	physDouble    = 5
+	physByteArray = 6
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+
+	repetitionOptional = 1
+)
+
+// Column is a named column. Every column is optional, a nil value is null.
+// Values are int64, float64, string, bool or time.Time by the column type.
+type Column struct {
+	Name   string
+	Type   Type
+	Values []any
+}
+
+const magic = "PAR1"
+
+// Write writes the columns, all of the same length, as a Parquet file
+func Write(w io.Writer, columns []Column) error {
+	rows := 0
+	if len(columns) > 0 {
+		rows = len(columns[0].Values)
+	}
+	out := &countingWriter{w: w}
+	if _, err := io.WriteString(out, magic); err != nil {
+		return err
+	}
+
+	var chunks []chunkMeta
+	for _, c := range columns {
+		if len(c.Values) != rows {
+			return fmt.Errorf("column %s has %d values, not %d", c.Name, len(c.Values), rows)
+		}
+		page, err := encodePage(c)
+		if err != nil {
+			return fmt.Errorf("column %s: %v", c.Name, err)
+		}
+		header := pageHeader(len(c.Values), len(page))
+		chunks = append(chunks, chunkMeta{column: c, offset: out.n, size: int64(len(header) + len(page))})
+		if _, err := out.Write(header); err != nil {
+			return err
+		}
+		if _, err := out.Write(page); err != nil {
+			return err
+		}
+	}
+
+	meta := fileMetaData(columns, chunks, rows)
+	if _, err := out.Write(meta); err != nil {
+		return err
+	}
+	tail := binary.LittleEndian.AppendUint32(nil, uint32(len(meta)))
+	_, err := out.Write(append(tail, magic...))
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type chunkMeta struct {
+	column Column
+	offset int64
+	size   int64
+}
+
+func physicalType(t Type) int32 {
+	switch t {
+	case Double:
+		return physDouble
+	case String:
+		return physByteArray
+	case Bool:
+		return physBoolean
+	}
+	return physInt64
+}
+
+// A data page: the definition levels, 1 for a value and 0 for null, then the
+// plain encoded values
+func encodePage(c Column) ([]byte, error) {
+	levels := make([]bool, len(c.Values))
+	var values []byte
+	var bits []bool
+	for i, v := range c.Values {
+		if v == nil {
+			continue
+		}
+		levels[i] = true
+		switch c.Type {
+		case Int64:
+			n, ok := v.(int64)
+			if !ok {
+				return nil, fmt.Errorf("value %v is not an int64", v)
+			}
+			values = binary.LittleEndian.AppendUint64(values, uint64(n))
+		case Timestamp:
+			t, ok := v.(time.Time)
+			if !ok {
+				return nil, fmt.Errorf("value %v is not a time", v)
+			}
+			values = binary.LittleEndian.AppendUint64(values, uint64(t.UnixMilli()))
+		case Double:
+			f, ok := v.(float64)
+			if !ok {
+				return nil, fmt.Errorf("value %v is not a float64", v)
+			}
+			values = binary.LittleEndian.AppendUint64(values, math.Float64bits(f))
+		case String:
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("value %v is not a string", v)
+			}
+			values = binary.LittleEndian.AppendUint32(values, uint32(len(s)))
+			values = append(values, s...)
+		case Bool:
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("value %v is not a bool", v)
+			}
+			bits = append(bits, b)
+		}
+	}
+	if c.Type == Bool {
+		values = make([]byte, (len(bits)+7)/8)
+		for i, b := range bits {
+			if b {
+				values[i/8] |= 1 << (i % 8)
+			}
+		}
+	}
+
+	rle := runLengths(levels)
+	page := binary.LittleEndian.AppendUint32(nil, uint32(len(rle)))
+	page = append(page, rle...)
+	return append(page, values...), nil
+}
+
+// Levels of bit width 1 in the RLE hybrid encoding, as runs of equal values
+func runLengths(levels []bool) []byte {
+	var b []byte
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		b = binary.AppendUvarint(b, uint64(j-i)<<1)
+		if levels[i] {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+		i = j
+	}
+	return b
+}
+
+func pageHeader(values, size int) []byte {
+	var e encoder
+	e.i32(1, 0) // DATA_PAGE
+	e.i32(2, int32(size))
+	e.i32(3, int32(size))
+	e.begin(5)
+	e.i32(1, int32(values))
+	e.i32(2, encodingPlain)
+	e.i32(3, encodingRLE)
+	e.i32(4, encodingRLE)
+	e.end()
+	e.end()
+	return e.b
+}
+
+func fileMetaData(columns []Column, chunks []chunkMeta, rows int) []byte {
+	var e encoder
+	e.i32(1, 1)
+	e.list(2, typeStruct, len(columns)+1)
+	e.elem()
+	e.binary(4, "schema")
+	e.i32(5, int32(len(columns)))
+	e.end()
+	for _, c := range columns {
+		e.elem()
+		e.i32(1, physicalType(c.Type))
+		e.i32(3, repetitionOptional)
+		e.binary(4, c.Name)
+		switch c.Type {
+		case String:
+			e.i32(6, convertedUTF8)
+		case Timestamp:
+			e.i32(6, convertedTimestampMillis)
+		}
+		e.end()
+	}
+	e.i64(3, int64(rows))
+
+	var total int64
+	for _, c := range chunks {
+		total += c.size
+	}
+	e.list(4, typeStruct, 1)
+	e.elem()
+	e.list(1, typeStruct, len(chunks))
+	for _, c := range chunks {
+		e.elem()
+		e.i64(2, c.offset)
+		e.begin(3)
+		e.i32(1, physicalType(c.column.Type))
+		e.list(2, typeI32, 2)
+		e.zigzag(encodingPlain)
+		e.zigzag(encodingRLE)
+		e.list(3, typeBinary, 1)
+		e.str(c.column.Name)
+		e.i32(4, 0) // UNCOMPRESSED
+		e.i64(5, int64(len(c.column.Values)))
+		e.i64(6, c.size)
+		e.i64(7, c.size)
+		e.i64(9, c.offset)
+		e.end()
+		e.end()
+	}
+	e.i64(2, total)
+	e.i64(3, int64(rows))
+	e.end()
+	e.binary(6, "netwatchd")
+	e.end()
+	return e.b
+}
+
+// Thrift compact protocol types
+const (
+	typeI32    = 5
+	typeI64    = 6
+	typeBinary = 8
+	typeList   = 9
+	typeStruct = 12
+)
+
+// encoder writes Thrift compact protocol structs. Field ids are relative to
+// the previous field of the struct, so nested structs keep a stack of them.
+type encoder struct {
+	b    []byte
+	last []int16
+}
+
+func (e *encoder) field(id int16, kind byte) {
+	if len(e.last) == 0 {
+		e.last = []int16{0}
+	}
+	prev := &e.last[len(e.last)-1]
+	if delta := id - *prev; delta > 0 && delta <= 15 {
+		e.b = append(e.b, byte(delta)<<4|kind)
+	} else {
+		e.b = append(e.b, kind)
+		e.zigzag(int64(id))
+	}
+	*prev = id
+}
+
+func (e *encoder) zigzag(n int64) {
+	e.b = binary.AppendUvarint(e.b, uint64(n<<1^n>>63))
+}
+
+func (e *encoder) str(s string) {
+	e.b = binary.AppendUvarint(e.b, uint64(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *encoder) i32(id int16, n int32) {
+	e.field(id, typeI32)
+	e.zigzag(int64(n))
+}
+
+func (e *encoder) i64(id int16, n int64) {
+	e.field(id, typeI64)
+	e.zigzag(n)
+}
+
+func (e *encoder) binary(id int16, s string) {
+	e.field(id, typeBinary)
+	e.str(s)
+}
+
+func (e *encoder) list(id int16, elem byte, n int) {
+	e.field(id, typeList)
+	if n < 15 {
+		e.b = append(e.b, byte(n)<<4|elem)
+	} else {
+		e.b = append(e.b, 0xF0|elem)
+		e.b = binary.AppendUvarint(e.b, uint64(n))
+	}
+}
+
+// A struct field, or with elem a struct in a list, until end
+func (e *encoder) begin(id int16) {
+	e.field(id, typeStruct)
+	e.elem()
+}
+
+func (e *encoder) elem() {
+	if len(e.last) == 0 {
+		e.last = []int16{0}
+	}
+	e.last = append(e.last, 0)
+}
+
+func (e *encoder) end() {
+	e.b = append(e.b, 0)
+	e.last = e.last[:len(e.last)-1]
+}
@@ -0,0 +1,257 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// decoder reads Thrift compact protocol values: structs as maps by field id,
+// lists as slices, integers as int64 and binary as strings
+type decoder struct {
+	b []byte
+	i int
+}
+
+func (d *decoder) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.b[d.i:])
+	if n <= 0 {
+		return 0, fmt.Errorf("bad varint at %d", d.i)
+	}
+	d.i += n
+	return v, nil
+}
+
+func (d *decoder) byte() (byte, error) {
+	if d.i >= len(d.b) {
+		return 0, errors.New("unexpected end")
+	}
+	d.i++
+	return d.b[d.i-1], nil
+}
+
+func (d *decoder) value(kind byte) (any, error) {
+	switch kind {
+	case 1, 2:
+		return kind == 1, nil
+	case typeI32, typeI64:
+		u, err := d.uvarint()
+		return int64(u>>1) ^ -int64(u&1), err
+	case typeBinary:
+		n, err := d.uvarint()
+		if err != nil || d.i+int(n) > len(d.b) {
+			return nil, fmt.Errorf("bad binary at %d", d.i)
+		}
+		d.i += int(n)
+		return string(d.b[d.i-int(n) : d.i]), nil
+	case typeList:
+		h, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		n := uint64(h >> 4)
+		if n == 15 {
+			if n, err = d.uvarint(); err != nil {
+				return nil, err
+			}
+		}
+		list := []any{}
+		for range n {
+			v, err := d.value(h & 0x0F)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case typeStruct:
+		s := map[int16]any{}
+		var id int16
+		for {
+			h, err := d.byte()
+			if err != nil {
+				return nil, err
+			}
+			if h == 0 {
+				return s, nil
+			}
+			if delta := int16(h >> 4); delta != 0 {
+				id += delta
+			} else {
+				u, err := d.uvarint()
+				if err != nil {
+					return nil, err
+				}
+				id = int16(int64(u>>1) ^ -int64(u&1))
+			}
+			if s[id], err = d.value(h & 0x0F); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, fmt.Errorf("unknown type %d at %d", kind, d.i)
+}
+
+// Decoding one struct at the start of b, returning it and its length
+func decodeStruct(t *testing.T, b []byte) (map[int16]any, int) {
+	t.Helper()
+	d := &decoder{b: b}
+	v, err := d.value(typeStruct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v.(map[int16]any), d.i
+}
+
+func field[T any](t *testing.T, s map[int16]any, path ...int16) T {
+	t.Helper()
+	var v any = s
+	for _, id := range path {
+		m, ok := v.(map[int16]any)
+		if !ok {
+			t.Fatalf("field %v: %T is not a struct", path, v)
+		}
+		if v, ok = m[id]; !ok {
+			t.Fatalf("field %v missing", path)
+		}
+	}
+	x, ok := v.(T)
+	if !ok {
+		t.Fatalf("field %v is %T", path, v)
+	}
+	return x
+}
+
+func TestWrite(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 0, 0, 500e6, time.UTC)
+	columns := []Column{
+		{Name: "id", Type: Int64, Values: []any{int64(1), int64(-2), nil, int64(math.MaxInt64)}},
+		{Name: "seconds", Type: Double, Values: []any{60.0, nil, 0.5, -1.25}},
+		{Name: "host", Type: String, Values: []any{"a", "", "héllo", nil}},
+		{Name: "resolved", Type: Bool, Values: []any{true, false, nil, true}},
+		{Name: "start_time", Type: Timestamp, Values: []any{nil, at, at, at.Add(time.Minute)}},
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, columns); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	if len(b) < 12 || string(b[:4]) != magic || string(b[len(b)-4:]) != magic {
+		t.Fatalf("no PAR1 at both ends: %q ... %q", b[:4], b[len(b)-4:])
+	}
+	footer := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	metaStart := len(b) - 8 - footer
+	if metaStart < 4 {
+		t.Fatalf("footer length %d of a %d byte file", footer, len(b))
+	}
+	meta, n := decodeStruct(t, b[metaStart:len(b)-8])
+	if n != footer {
+		t.Fatalf("FileMetaData is %d bytes, the footer says %d", n, footer)
+	}
+
+	if v := field[int64](t, meta, 1); v != 1 {
+		t.Errorf("version %d", v)
+	}
+	if rows := field[int64](t, meta, 3); rows != 4 {
+		t.Errorf("num_rows %d, want 4", rows)
+	}
+	schema := field[[]any](t, meta, 2)
+	if len(schema) != len(columns)+1 || field[int64](t, schema[0].(map[int16]any), 5) != int64(len(columns)) {
+		t.Fatalf("schema %v", schema)
+	}
+	wantTypes := []int64{physInt64, physDouble, physByteArray, physBoolean, physInt64}
+	for i, c := range columns {
+		el := schema[i+1].(map[int16]any)
+		if name := field[string](t, el, 4); name != c.Name {
+			t.Errorf("schema column %d is %s, want %s", i, name, c.Name)
+		}
+		if typ := field[int64](t, el, 1); typ != wantTypes[i] {
+			t.Errorf("%s: physical type %d, want %d", c.Name, typ, wantTypes[i])
+		}
+	}
+
+	groups := field[[]any](t, meta, 4)
+	if len(groups) != 1 {
+		t.Fatalf("%d row groups", len(groups))
+	}
+	group := groups[0].(map[int16]any)
+	chunks := field[[]any](t, group, 1)
+	if len(chunks) != len(columns) || field[int64](t, group, 3) != 4 {
+		t.Fatalf("%d column chunks for %d rows", len(chunks), field[int64](t, group, 3))
+	}
+	// Chunks follow each other from the leading magic up to the footer
+	next, total := int64(4), int64(0)
+	for i, c := range chunks {
+		chunk := c.(map[int16]any)
+		name := columns[i].Name
+		offset := field[int64](t, chunk, 2)
+		if offset != next || field[int64](t, chunk, 3, 9) != offset {
+			t.Errorf("%s: file_offset %d and data_page_offset %d, want %d", name, offset, field[int64](t, chunk, 3, 9), next)
+		}
+		if path := field[[]any](t, chunk, 3, 3); !reflect.DeepEqual(path, []any{name}) {
+			t.Errorf("%s: path %v", name, path)
+		}
+		if values := field[int64](t, chunk, 3, 5); values != 4 {
+			t.Errorf("%s: num_values %d, want 4", name, values)
+		}
+		size := field[int64](t, chunk, 3, 6)
+		if field[int64](t, chunk, 3, 7) != size {
+			t.Errorf("%s: compressed and uncompressed sizes differ", name)
+		}
+
+		// The data page header at the offset covers the rest of the chunk
+		header, hn := decodeStruct(t, b[offset:])
+		pageSize := field[int64](t, header, 2)
+		if field[int64](t, header, 1) != 0 || field[int64](t, header, 5, 1) != 4 || int64(hn)+pageSize != size {
+			t.Errorf("%s: page header %v with %d header bytes, chunk is %d", name, header, hn, size)
+		}
+		next += size
+		total += size
+	}
+	if next != int64(metaStart) {
+		t.Errorf("chunks end at %d, the footer starts at %d", next, metaStart)
+	}
+	if got := field[int64](t, group, 2); got != total {
+		t.Errorf("total_byte_size %d, want %d", got, total)
+	}
+}
+
+func TestWritePage(t *testing.T) {
+	// Definition levels as RLE runs, then the values present
+	page, err := encodePage(Column{Name: "id", Type: Int64, Values: []any{int64(7), int64(8), nil, int64(-1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	levels := []byte{4, 1, 2, 0, 2, 1}
+	want := binary.LittleEndian.AppendUint32(nil, uint32(len(levels)))
+	want = append(want, levels...)
+	for _, v := range []int64{7, 8, -1} {
+		want = binary.LittleEndian.AppendUint64(want, uint64(v))
+	}
+	if !bytes.Equal(page, want) {
+		t.Errorf("page %x, want %x", page, want)
+	}
+
+	page, _ = encodePage(Column{Name: "b", Type: Bool, Values: []any{true, false, true, true, false, false, false, false, true}})
+	if bits := page[len(page)-2:]; !bytes.Equal(bits, []byte{0x0D, 0x01}) {
+		t.Errorf("bools packed as %x, want 0d01", bits)
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	for _, columns := range [][]Column{
+		{{Name: "a", Type: Int64, Values: []any{int64(1)}}, {Name: "b", Type: Int64, Values: []any{}}},
+		{{Name: "a", Type: Int64, Values: []any{1}}},
+		{{Name: "a", Type: Timestamp, Values: []any{"2024-03-01"}}},
+	} {
+		if err := Write(&bytes.Buffer{}, columns); err == nil {
+			t.Errorf("%v written", columns)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// PayloadPattern is something to count in packet payloads. Exactly one of
+// String, Regex or Hex is set.
+type PayloadPattern struct {
+	Name   string `json:"name,omitempty"`
+	String string `json:"string,omitempty"`
+	Regex  string `json:"regex,omitempty"`
+	Hex    string `json:"hex,omitempty"`
+}
+
+func (p PayloadPattern) label() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	switch {
+	case p.Regex != "":
+		return "re:" + p.Regex
+	case p.Hex != "":
+		return "hex:" + p.Hex
+	}
+	return "str:" + p.String
+}
+
+// Parsing -match values: [name=]str:<text>, [name=]re:<regex> or [name=]hex:<bytes>
+func parsePayloadPattern(v string) (PayloadPattern, error) {
+	var p PayloadPattern
+	if name, rest, ok := strings.Cut(v, "="); ok && !strings.Contains(name, ":") {
+		p.Name, v = name, rest
+	}
+	kind, value, ok := strings.Cut(v, ":")
+	if !ok || value == "" {
+		return p, fmt.Errorf("payload pattern %q must be str:, re: or hex: followed by a value", v)
+	}
+	switch kind {
+	case "str":
+		p.String = value
+	case "re":
+		p.Regex = value
+	case "hex":
+		p.Hex = value
+	default:
+		return p, fmt.Errorf("unknown payload pattern kind %q", kind)
+	}
+	_, err := p.compile()
+	return p, err
+}
+
+// Building a function counting non-overlapping matches in a payload
+func (p PayloadPattern) compile() (func([]byte) int, error) {
+	set := 0
+	for _, v := range []string{p.String, p.Regex, p.Hex} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, errors.New("payload pattern needs exactly one of string, regex or hex")
+	}
+
+	switch {
+	case p.Regex != "":
+		re, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("payload pattern %q: %v", p.Regex, err)
+		}
+		return func(b []byte) int { return len(re.FindAllIndex(b, -1)) }, nil
+	case p.Hex != "":
+		needle, err := hex.DecodeString(strings.NewReplacer(" ", "", ":", "").Replace(p.Hex))
+		if err != nil || len(needle) == 0 {
+			return nil, fmt.Errorf("payload pattern %q is not hex", p.Hex)
+		}
+		return func(b []byte) int { return bytes.Count(b, needle) }, nil
+	}
+	needle := []byte(p.String)
+	return func(b []byte) int { return bytes.Count(b, needle) }, nil
+}
+
+// patternList collects repeated -match flags
+type patternList []PayloadPattern
+
+func (l *patternList) String() string {
+	var parts []string
+	for _, p := range *l {
+		parts = append(parts, p.label())
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (l *patternList) Set(v string) error {
+	p, err := parsePayloadPattern(v)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, p)
+	return nil
+}
+
+// PatternCounter counts payload pattern matches per bucket. Payloads are
+// only looked at, never kept. It is guarded by the MonitoringData mutex.
+type PatternCounter struct {
+	patterns []PayloadPattern
+	match    []func([]byte) int
+	current  []int
+	buckets  [][]int
+}
+
+func NewPatternCounter(patterns []PayloadPattern) (*PatternCounter, error) {
+	c := &PatternCounter{patterns: patterns, current: make([]int, len(patterns))}
+	for _, p := range patterns {
+		m, err := p.compile()
+		if err != nil {
+			return nil, err
+		}
+		c.match = append(c.match, m)
+	}
+	return c, nil
+}
+
+func (c *PatternCounter) Observe(p *netwatch.Packet) {
+	if c == nil || len(p.Payload) == 0 {
+		return
+	}
+	for i, m := range c.match {
+		c.current[i] += m(p.Payload)
+	}
+	p.Payload = nil
+}
+
+// Closing the current bucket. Callers hold data.mu.
+func (c *PatternCounter) rotate() {
+	if c == nil {
+		return
+	}
+	c.buckets = append(c.buckets, c.current)
+	c.current = make([]int, len(c.patterns))
+}
+
+func printPatternReport(c *PatternCounter, bucket time.Duration) {
+	if c == nil {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("PAYLOAD MATCHES")
+	for i, p := range c.patterns {
+		total := 0
+		var series []string
+		for _, b := range c.buckets {
+			total += b[i]
+			series = append(series, fmt.Sprint(b[i]))
+		}
+		fmt.Printf("%s: %d matches (per %s: %s)\n", p.label(), total, bucketUnit(bucket), strings.Join(series, " "))
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Pausing or resuming counting. Time keeps running so buckets stay aligned,
+// the paused part of each bucket is recorded instead. Callers hold data.mu.
+func (data *MonitoringData) setPaused(paused bool, now time.Time) {
+	if paused == data.paused {
+		return
+	}
+	data.paused = paused
+	if paused {
+		data.pausedSince = now
+		fmt.Println("-- paused --")
+	} else {
+		data.currentPaused += now.Sub(data.pausedSince)
+		fmt.Println("-- resumed --")
+	}
+}
+
+// Closing out paused time for the bucket ending at boundary. Callers hold data.mu.
+func (data *MonitoringData) closePausedBucket(boundary time.Time) {
+	paused := data.currentPaused
+	if data.paused && boundary.After(data.pausedSince) {
+		paused += boundary.Sub(data.pausedSince)
+		data.pausedSince = boundary
+	}
+	data.pausedBuckets = append(data.pausedBuckets, paused)
+	data.currentPaused = 0
+}
+
+// A bucket paused for its whole length has no meaningful traffic numbers
+func isFullyPaused(paused, length time.Duration) bool {
+	return paused > 0 && paused >= length-time.Second
+}
+
+// Describing the paused share of a bucket for the report, empty when not paused
+func pausedNote(paused, length time.Duration) string {
+	if paused <= 0 {
+		return ""
+	}
+	if isFullyPaused(paused, length) {
+		return " (paused)"
+	}
+	return fmt.Sprintf(" (paused %ds)", int(paused.Seconds()))
+}
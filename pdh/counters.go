@@ -0,0 +1,17 @@
+package pdh
+
+// Per adapter counters by their English names, NewCounter finds them on any
+// display language. The byte and packet counters are rates; the error and
+// discard counters are raw counts since the adapter came up.
+const (
+	BytesSent                = "Bytes Sent/sec"
+	BytesReceived            = "Bytes Received/sec"
+	PacketsSent              = "Packets Sent/sec"
+	PacketsReceived          = "Packets Received/sec"
+	PacketsReceivedErrors    = "Packets Received Errors"
+	PacketsOutboundErrors    = "Packets Outbound Errors"
+	PacketsReceivedDiscarded = "Packets Received Discarded"
+	PacketsOutboundDiscarded = "Packets Outbound Discarded"
+	PacketsReceivedUnknown   = "Packets Received Unknown"
+	OutputQueueLength        = "Output Queue Length"
+)
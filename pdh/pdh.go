@@ -1,7 +1,10 @@
+//go:build windows
+
 package pdh
 
 import (
 	"fmt"
+	"sync"
 	"syscall"
 	"unsafe"
 )
@@ -14,6 +17,7 @@ var (
 	pdhCollectQueryData    = pdh.NewProc("PdhCollectQueryData")
 	pdhGetFormattedCounter = pdh.NewProc("PdhGetFormattedCounterValue")
 	pdhCloseQuery          = pdh.NewProc("PdhCloseQuery")
+	pdhRemoveCounter       = pdh.NewProc("PdhRemoveCounter")
 	pdhExpandWildCardPathW = pdh.NewProc("PdhExpandWildCardPathW")
 )
 
@@ -23,10 +27,25 @@ const (
 	PDH_NO_DATA      = 0x800007D5
 )
 
-var query uintptr
+// Query is a PDH query, its counters are sampled together by Collect.
+// Monitors with their own interval each open one. It is safe for
+// concurrent use.
+type Query struct {
+	mu     sync.Mutex
+	handle uintptr
+}
+
+var (
+	// The query behind the deprecated package functions. Initialize and
+	// Cleanup are reference counted so several monitors can share it.
+	shared     *Query
+	sharedMu   sync.Mutex
+	sharedRefs int
+)
 
 type Counter struct {
 	handle uintptr
+	query  *Query
 }
 
 type PDH_FMT_COUNTERVALUE struct {
@@ -34,28 +53,123 @@ type PDH_FMT_COUNTERVALUE struct {
 	DoubleValue float64
 }
 
-// Creating a PDH query
-func Initialize() error {
-	var q uintptr
-	ret, _, _ := pdhOpenQuery.Call(0, 0, uintptr(unsafe.Pointer(&q)))
+// Opening a PDH query
+func OpenQuery() (*Query, error) {
+	var h uintptr
+	ret, _, _ := pdhOpenQuery.Call(0, 0, uintptr(unsafe.Pointer(&h)))
 	if ret != 0 {
-		return fmt.Errorf("PdhOpenQuery failed with code 0x%X", ret)
+		return nil, fmt.Errorf("PdhOpenQuery failed with code 0x%X", ret)
+	}
+	return &Query{handle: h}, nil
+}
+
+// Closing the query, its counters can't be read afterwards
+func (q *Query) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.handle != 0 {
+		pdhCloseQuery.Call(q.handle)
+		q.handle = 0
 	}
-	query = q
+}
+
+// Initialize opens the shared query of the package functions.
+//
+// Deprecated: Use OpenQuery, which doesn't share its counters and interval.
+func Initialize() error {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if sharedRefs > 0 {
+		sharedRefs++
+		return nil
+	}
+
+	q, err := OpenQuery()
+	if err != nil {
+		return err
+	}
+	shared = q
+	sharedRefs = 1
 	return nil
 }
 
-// Closing the PDH query
+// Cleanup closes the shared query once each Initialize is matched.
+//
+// Deprecated: Use Query.Close.
 func Cleanup() {
-	if query != 0 {
-		pdhCloseQuery.Call(query)
-		query = 0
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if sharedRefs--; sharedRefs > 0 {
+		return
+	}
+	sharedRefs = 0
+	if shared != nil {
+		shared.Close()
+		shared = nil
+	}
+}
+
+func sharedQuery() (*Query, error) {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if shared == nil {
+		return nil, fmt.Errorf("PDH not initialized")
 	}
+	return shared, nil
+}
+
+// Performance objects exposing per adapter byte counters, in lookup order.
+// Hyper-V guests and vSwitch ports are missing from "Network Interface".
+var adapterObjects = []string{
+	"Network Interface",
+	"Network Adapter",
+	"Hyper-V Virtual Network Adapter",
+	"Hyper-V Virtual Switch",
 }
 
+var (
+	// Which object each adapter was found under
+	adapterObject   = make(map[string]string)
+	adapterObjectMu sync.Mutex
+)
+
 // Listing all available network adapter names
 func GetNetworkAdapters() ([]string, error) {
-	wildcardPath := "\\Network Interface(*)\\Bytes Received/sec"
+	var adapters []string
+	seen := make(map[string]bool)
+	var firstErr error
+
+	adapterObjectMu.Lock()
+	defer adapterObjectMu.Unlock()
+
+	for _, object := range adapterObjects {
+		paths, err := expandWildCardPath("\\" + object + "(*)\\Bytes Received/sec")
+		if err != nil {
+			// Hyper-V objects only exist when the role is installed
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		for _, path := range paths {
+			name := instanceName(path)
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			adapterObject[name] = object
+			adapters = append(adapters, name)
+		}
+	}
+
+	if len(adapters) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return adapters, nil
+}
+
+func expandWildCardPath(wildcardPath string) ([]string, error) {
 	pathPtr, _ := syscall.UTF16PtrFromString(wildcardPath)
 	
 	var bufSize uint32
@@ -71,6 +185,9 @@ func GetNetworkAdapters() ([]string, error) {
 	if ret != 0 && bufSize == 0 {
 		return nil, fmt.Errorf("PdhExpandWildCardPathW failed: 0x%X", ret)
 	}
+	if bufSize == 0 {
+		return nil, nil
+	}
 	
 	buf := make([]uint16, bufSize)
 	ret, _, _ = pdhExpandWildCardPathW.Call(
@@ -85,35 +202,68 @@ func GetNetworkAdapters() ([]string, error) {
 		return nil, fmt.Errorf("PdhExpandWildCardPathW second call failed: 0x%X", ret)
 	}
 	
-	paths := parseMultiString(buf)
-	var adapters []string
-	
-	for _, path := range paths {
-		start := -1
-		end := -1
-		for i, ch := range path {
-			if ch == '(' {
-				start = i + 1
-			} else if ch == ')' && start != -1 {
-				end = i
-				break
-			}
-		}
-		if start != -1 && end != -1 && end > start {
-			adapters = append(adapters, path[start:end])
+	return parseMultiString(buf), nil
+}
+
+// Extracting the instance name between the parentheses of a counter path
+func instanceName(path string) string {
+	start := -1
+	end := -1
+	for i, ch := range path {
+		if ch == '(' {
+			start = i + 1
+		} else if ch == ')' && start != -1 {
+			end = i
+			break
 		}
 	}
-	
-	return adapters, nil
+	if start != -1 && end != -1 && end > start {
+		return path[start:end]
+	}
+	return ""
 }
 
-// Creating a performance counter for a specific network adapter
+// Adding a performance counter for a specific network adapter
+func (q *Query) AddAdapterCounter(adapterName, counterName string) (*Counter, error) {
+	adapterObjectMu.Lock()
+	object, ok := adapterObject[adapterName]
+	adapterObjectMu.Unlock()
+	if !ok {
+		object = adapterObjects[0]
+	}
+	return q.AddCounter(fmt.Sprintf("\\%s(%s)\\%s", object, adapterName, counterName))
+}
+
+// NewCounter adds an adapter counter to the shared query.
+//
+// Deprecated: Use Query.AddAdapterCounter.
 func NewCounter(adapterName, counterName string) (*Counter, error) {
-	if query == 0 {
-		return nil, fmt.Errorf("PDH not initialized")
+	q, err := sharedQuery()
+	if err != nil {
+		return nil, err
+	}
+	return q.AddAdapterCounter(adapterName, counterName)
+}
+
+// NewCounterPath adds a counter to the shared query.
+//
+// Deprecated: Use Query.AddCounter.
+func NewCounterPath(path string) (*Counter, error) {
+	q, err := sharedQuery()
+	if err != nil {
+		return nil, err
+	}
+	return q.AddCounter(path)
+}
+
+// Adding a performance counter from a full counter path like \WFPv4\Packets Discarded/sec
+func (q *Query) AddCounter(path string) (*Counter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.handle == 0 {
+		return nil, fmt.Errorf("PDH query closed")
 	}
 
-	path := fmt.Sprintf("\\Network Interface(%s)\\%s", adapterName, counterName)
 	pathPtr, err := syscall.UTF16PtrFromString(path)
 	if err != nil {
 		return nil, err
@@ -122,7 +272,7 @@ func NewCounter(adapterName, counterName string) (*Counter, error) {
 	var counterHandle uintptr
 	
 	ret, _, _ := pdhAddEnglishCounterW.Call(
-		query,
+		q.handle,
 		uintptr(unsafe.Pointer(pathPtr)),
 		0,
 		uintptr(unsafe.Pointer(&counterHandle)),
@@ -130,7 +280,7 @@ func NewCounter(adapterName, counterName string) (*Counter, error) {
 	
 	if ret != 0 {
 		ret, _, _ = pdhAddCounterW.Call(
-			query,
+			q.handle,
 			uintptr(unsafe.Pointer(pathPtr)),
 			0,
 			uintptr(unsafe.Pointer(&counterHandle)),
@@ -140,23 +290,43 @@ func NewCounter(adapterName, counterName string) (*Counter, error) {
 		}
 	}
 
-	return &Counter{handle: counterHandle}, nil
+	return &Counter{handle: counterHandle, query: q}, nil
 }
 
-func CollectData() error {
-	if query == 0 {
-		return fmt.Errorf("PDH not initialized")
+// Sampling every counter of the query
+func (q *Query) Collect() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.handle == 0 {
+		return fmt.Errorf("PDH query closed")
 	}
 
-	ret, _, _ := pdhCollectQueryData.Call(query)
+	ret, _, _ := pdhCollectQueryData.Call(q.handle)
 	if ret != 0 && ret != PDH_INVALID_DATA {
 		return fmt.Errorf("PdhCollectQueryData failed with code 0x%X", ret)
 	}
 	return nil
 }
 
+// CollectData samples the counters of the shared query.
+//
+// Deprecated: Use Query.Collect.
+func CollectData() error {
+	q, err := sharedQuery()
+	if err != nil {
+		return err
+	}
+	return q.Collect()
+}
+
 // Retrieving the current counter value
 func (c *Counter) GetValue() (float64, error) {
+	c.query.mu.Lock()
+	defer c.query.mu.Unlock()
+	if c.query.handle == 0 || c.handle == 0 {
+		return 0, fmt.Errorf("PDH counter closed")
+	}
+
 	var value PDH_FMT_COUNTERVALUE
 	ret, _, _ := pdhGetFormattedCounter.Call(
 		c.handle,
@@ -170,8 +340,15 @@ func (c *Counter) GetValue() (float64, error) {
 	return value.DoubleValue, nil
 }
 
-// Counter cleanup
+// Removing the counter from its query. Closing the query frees its
+// counters too, so only counters dropped while it stays open need this.
 func (c *Counter) Close() {
+	c.query.mu.Lock()
+	defer c.query.mu.Unlock()
+	if c.handle != 0 && c.query.handle != 0 {
+		pdhRemoveCounter.Call(c.handle)
+	}
+	c.handle = 0
 }
 
 func parseMultiString(buf []uint16) []string {
@@ -0,0 +1,65 @@
+//go:build !windows
+
+package pdh
+
+import "errors"
+
+var errNotWindows = errors.New("PDH is only available on Windows")
+
+type Counter struct{}
+
+type Query struct{}
+
+func OpenQuery() (*Query, error) {
+	return nil, errNotWindows
+}
+
+func (q *Query) AddAdapterCounter(adapterName, counterName string) (*Counter, error) {
+	return nil, errNotWindows
+}
+
+func (q *Query) AddCounter(path string) (*Counter, error) {
+	return nil, errNotWindows
+}
+
+func (q *Query) Collect() error {
+	return errNotWindows
+}
+
+func (q *Query) Close() {
+}
+
+// Deprecated: Use OpenQuery.
+func Initialize() error {
+	return errNotWindows
+}
+
+// Deprecated: Use Query.Close.
+func Cleanup() {
+}
+
+func GetNetworkAdapters() ([]string, error) {
+	return nil, errNotWindows
+}
+
+// Deprecated: Use Query.AddAdapterCounter.
+func NewCounter(adapterName, counterName string) (*Counter, error) {
+	return nil, errNotWindows
+}
+
+// Deprecated: Use Query.AddCounter.
+func NewCounterPath(path string) (*Counter, error) {
+	return nil, errNotWindows
+}
+
+// Deprecated: Use Query.Collect.
+func CollectData() error {
+	return errNotWindows
+}
+
+func (c *Counter) GetValue() (float64, error) {
+	return 0, errNotWindows
+}
+
+func (c *Counter) Close() {
+}
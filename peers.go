@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	linux "netwatchd/netstat"
+)
+
+// VirtualPort maps a virtual interface to the workload behind it
+type VirtualPort struct {
+	Interface string
+	Peer      string
+}
+
+// Resolving the capture interface, or each port when it is a bridge
+func resolveVirtualPorts(iface string) []VirtualPort {
+	var ports []VirtualPort
+	if peer := linux.ResolvePeer(iface); peer != "" {
+		ports = append(ports, VirtualPort{iface, peer})
+	}
+
+	members, err := linux.GetBridgePorts(iface)
+	if err != nil {
+		return ports
+	}
+	for _, m := range members {
+		peer := linux.ResolvePeer(m)
+		if peer == "" {
+			peer = "unknown"
+		}
+		ports = append(ports, VirtualPort{m, peer})
+	}
+	return ports
+}
+
+func printVirtualPortReport(iface string, ports []VirtualPort) {
+	if len(ports) == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Printf("VIRTUAL INTERFACES (%s)\n", iface)
+	for _, p := range ports {
+		fmt.Printf("%s: %s\n", p.Interface, p.Peer)
+	}
+}
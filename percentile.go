@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Burstable billing samples the link every five minutes
+const billingSample = 5 * time.Minute
+
+// Averaging buckets into complete five minute samples, in bits per second.
+// A trailing partial sample is left out, as a transit provider would.
+func billingSamples(buckets []ReportBucket) []float64 {
+	if len(buckets) == 0 {
+		return nil
+	}
+	origin := buckets[0].Start
+	bytes := make(map[int]float64)
+	seconds := make(map[int]float64)
+	last := 0
+	for _, b := range buckets {
+		slot := int(b.Start.Sub(origin) / billingSample)
+		bytes[slot] += b.Bytes
+		seconds[slot] += b.Seconds
+		last = max(last, slot)
+	}
+
+	var samples []float64
+	for slot := 0; slot <= last; slot++ {
+		if seconds[slot] < billingSample.Seconds()-1 {
+			continue
+		}
+		samples = append(samples, bytes[slot]*8/seconds[slot])
+	}
+	return samples
+}
+
+// 95th percentile of the billing samples and how many samples it is based on
+func percentile95(buckets []ReportBucket) (float64, int) {
+	samples := billingSamples(buckets)
+	if len(samples) == 0 {
+		return 0, 0
+	}
+	sort.Float64s(samples)
+	// The top 5% of samples are discarded, the next one is billed
+	i := int(math.Ceil(0.95*float64(len(samples)))) - 1
+	return samples[max(i, 0)], len(samples)
+}
+
+func printPercentileReport(buckets []ReportBucket) {
+	p95, n := percentile95(buckets)
+	if n == 0 {
+		fmt.Println("95th percentile: needs at least 5 minutes of data")
+		return
+	}
+	fmt.Printf("95th percentile: %s over %d five-minute samples\n", formatBitrate(p95), n)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Manifest registering the netwatchd counter set with lodctr, ids match perfcounters_windows.go
+//
+//go:embed netwatchd-counters.man
+var perfManifest []byte
+
+// perfSample is one update of the published performance counters
+type perfSample struct {
+	PacketsPerSec uint32
+	BitsPerSec    uint64
+	Packets       uint64
+	Alerts        uint32
+}
+
+// perfPublisher exposes netwatchd's own metrics to the OS performance tooling
+type perfPublisher interface {
+	Update(s perfSample) error
+	Close()
+}
+
+// Publishing packet rate, captured bit rate and alert count once a second
+func publishPerfCounters(ctx context.Context, data *MonitoringData) {
+	pub, err := startPerfPublisher(data.captureInterface)
+	if err != nil {
+		logger.Error("failed to publish performance counters", "err", err)
+		return
+	}
+	defer pub.Close()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var lastPackets uint64
+	var lastBytes int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			data.mu.Lock()
+			packets := uint64(data.capturedPackets)
+			bytes := data.capturedBytes
+			alerts := len(data.alerts)
+			data.mu.Unlock()
+
+			s := perfSample{
+				PacketsPerSec: uint32(packets - lastPackets),
+				BitsPerSec:    uint64(bytes-lastBytes) * 8,
+				Packets:       packets,
+				Alerts:        uint32(alerts),
+			}
+			lastPackets, lastBytes = packets, bytes
+			if err := pub.Update(s); err != nil {
+				logger.Error("failed to update performance counters", "err", err)
+				return
+			}
+		}
+	}
+}
+
+// netwatchd perf install|uninstall|manifest
+func runPerfCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Println("Usage: netwatchd perf install | uninstall | manifest")
+		return 2
+	}
+	switch args[0] {
+	case "manifest":
+		os.Stdout.Write(perfManifest)
+		return 0
+	case "install", "uninstall":
+		if err := registerPerfCounters(args[0] == "install"); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		fmt.Printf("Performance counters %sed\n", args[0])
+		return 0
+	}
+	fmt.Printf("Unknown perf command %q\n", args[0])
+	return 2
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+var errPerfWindowsOnly = errors.New("performance counters are only available on Windows")
+
+func startPerfPublisher(instance string) (perfPublisher, error) {
+	return nil, errPerfWindowsOnly
+}
+
+func registerPerfCounters(install bool) error {
+	return errPerfWindowsOnly
+}
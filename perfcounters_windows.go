@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// Perflib V2 provider API, see netwatchd-counters.man for the matching definitions
+var (
+	advapi32                     = syscall.NewLazyDLL("advapi32.dll")
+	perfStartProvider            = advapi32.NewProc("PerfStartProvider")
+	perfStopProvider             = advapi32.NewProc("PerfStopProvider")
+	perfSetCounterSetInfo        = advapi32.NewProc("PerfSetCounterSetInfo")
+	perfCreateInstance           = advapi32.NewProc("PerfCreateInstance")
+	perfDeleteInstance           = advapi32.NewProc("PerfDeleteInstance")
+	perfSetULongCounterValue     = advapi32.NewProc("PerfSetULongCounterValue")
+	perfSetULongLongCounterValue = advapi32.NewProc("PerfSetULongLongCounterValue")
+)
+
+var (
+	perfProviderGUID   = syscall.GUID{Data1: 0x6b1f4c5e, Data2: 0x2d3a, Data3: 0x4f7b, Data4: [8]byte{0x9c, 0x1e, 0x8a, 0x5d, 0x7e, 0x3b, 0x2f, 0x90}}
+	perfCounterSetGUID = syscall.GUID{Data1: 0xa3c9e2d1, Data2: 0x7b4f, Data3: 0x4e8a, Data4: [8]byte{0xb6, 0xd2, 0x1f, 0x9c, 0x3e, 0x5a, 0x7b, 0x48}}
+)
+
+const (
+	perfCounterRawcount      = 0x00010000
+	perfCounterLargeRawcount = 0x00010100
+	perfCountersetMulti      = 2
+	perfDetailNovice         = 100
+)
+
+const (
+	perfIDPacketsPerSec = 1
+	perfIDBitsPerSec    = 2
+	perfIDPackets       = 3
+	perfIDAlerts        = 4
+)
+
+type windowsPerfPublisher struct {
+	provider uintptr
+	instance uintptr
+}
+
+func putGUID(b []byte, g syscall.GUID) {
+	binary.LittleEndian.PutUint32(b[0:], g.Data1)
+	binary.LittleEndian.PutUint16(b[4:], g.Data2)
+	binary.LittleEndian.PutUint16(b[6:], g.Data3)
+	copy(b[8:16], g.Data4[:])
+}
+
+// PERF_COUNTERSET_INFO followed by one PERF_COUNTER_INFO per counter
+func perfCounterSetTemplate() []byte {
+	counters := []struct {
+		id, typ, size, offset uint32
+	}{
+		{perfIDPacketsPerSec, perfCounterRawcount, 4, 0},
+		{perfIDBitsPerSec, perfCounterLargeRawcount, 8, 8},
+		{perfIDPackets, perfCounterLargeRawcount, 8, 16},
+		{perfIDAlerts, perfCounterRawcount, 4, 24},
+	}
+	b := make([]byte, 40+32*len(counters))
+	putGUID(b[0:], perfCounterSetGUID)
+	putGUID(b[16:], perfProviderGUID)
+	binary.LittleEndian.PutUint32(b[32:], uint32(len(counters)))
+	binary.LittleEndian.PutUint32(b[36:], perfCountersetMulti)
+	for i, c := range counters {
+		o := 40 + 32*i
+		binary.LittleEndian.PutUint32(b[o:], c.id)
+		binary.LittleEndian.PutUint32(b[o+4:], c.typ)
+		binary.LittleEndian.PutUint32(b[o+16:], c.size)
+		binary.LittleEndian.PutUint32(b[o+20:], perfDetailNovice)
+		binary.LittleEndian.PutUint32(b[o+28:], c.offset)
+	}
+	return b
+}
+
+func startPerfPublisher(instance string) (perfPublisher, error) {
+	if err := advapi32.Load(); err != nil {
+		return nil, err
+	}
+	p := &windowsPerfPublisher{}
+	if ret, _, _ := perfStartProvider.Call(uintptr(unsafe.Pointer(&perfProviderGUID)), 0, uintptr(unsafe.Pointer(&p.provider))); ret != 0 {
+		return nil, fmt.Errorf("PerfStartProvider failed with code %d", ret)
+	}
+	template := perfCounterSetTemplate()
+	if ret, _, _ := perfSetCounterSetInfo.Call(p.provider, uintptr(unsafe.Pointer(&template[0])), uintptr(len(template))); ret != 0 {
+		p.Close()
+		return nil, fmt.Errorf("PerfSetCounterSetInfo failed with code %d (run netwatchd perf install first)", ret)
+	}
+
+	if instance == "" {
+		instance = "default"
+	}
+	name, _ := syscall.UTF16PtrFromString(fmt.Sprintf("%s#%d", instance, os.Getpid()))
+	inst, _, err := perfCreateInstance.Call(p.provider, uintptr(unsafe.Pointer(&perfCounterSetGUID)), uintptr(unsafe.Pointer(name)), uintptr(os.Getpid()))
+	if inst == 0 {
+		p.Close()
+		return nil, fmt.Errorf("PerfCreateInstance failed: %v", err)
+	}
+	p.instance = inst
+	return p, nil
+}
+
+func (p *windowsPerfPublisher) setULong(id uint32, v uint32) error {
+	if ret, _, _ := perfSetULongCounterValue.Call(p.provider, p.instance, uintptr(id), uintptr(v)); ret != 0 {
+		return fmt.Errorf("PerfSetULongCounterValue failed with code %d", ret)
+	}
+	return nil
+}
+
+func (p *windowsPerfPublisher) setULongLong(id uint32, v uint64) error {
+	args := []uintptr{p.provider, p.instance, uintptr(id), uintptr(v)}
+	// On 32 bit Windows the 64 bit value takes two argument slots
+	if unsafe.Sizeof(uintptr(0)) == 4 {
+		args = append(args[:3], uintptr(uint32(v)), uintptr(v>>32))
+	}
+	if ret, _, _ := perfSetULongLongCounterValue.Call(args...); ret != 0 {
+		return fmt.Errorf("PerfSetULongLongCounterValue failed with code %d", ret)
+	}
+	return nil
+}
+
+func (p *windowsPerfPublisher) Update(s perfSample) error {
+	if err := p.setULong(perfIDPacketsPerSec, s.PacketsPerSec); err != nil {
+		return err
+	}
+	if err := p.setULongLong(perfIDBitsPerSec, s.BitsPerSec); err != nil {
+		return err
+	}
+	if err := p.setULongLong(perfIDPackets, s.Packets); err != nil {
+		return err
+	}
+	return p.setULong(perfIDAlerts, s.Alerts)
+}
+
+func (p *windowsPerfPublisher) Close() {
+	if p.instance != 0 {
+		perfDeleteInstance.Call(p.provider, p.instance)
+	}
+	perfStopProvider.Call(p.provider)
+}
+
+// Registering the manifest next to the executable with lodctr, which needs an elevated prompt
+func registerPerfCounters(install bool) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(exe)
+	manifest := filepath.Join(dir, "netwatchd-counters.man")
+	if err := os.WriteFile(manifest, perfManifest, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", manifest, err)
+	}
+
+	cmd := exec.Command("lodctr", "/m:"+manifest, dir)
+	if !install {
+		cmd = exec.Command("unlodctr", "/m:"+manifest)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %v\n%s", cmd.Args[0], err, out)
+	}
+	return nil
+}
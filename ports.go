@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// Names of the ports behind most traffic by transport/port, the services
+// file covers the rest
+var wellKnownPorts = map[string]string{
+	"tcp/20": "ftp-data", "tcp/21": "ftp", "tcp/22": "ssh", "tcp/23": "telnet", "tcp/25": "smtp", "udp/53": "dns", "tcp/53": "dns",
+	"udp/67": "dhcp", "udp/68": "dhcp", "udp/69": "tftp", "tcp/80": "http", "tcp/88": "kerberos", "udp/88": "kerberos",
+	"tcp/110": "pop3", "udp/123": "ntp", "tcp/135": "msrpc", "udp/137": "netbios-ns", "udp/138": "netbios-dgm", "tcp/139": "smb",
+	"tcp/143": "imap", "udp/161": "snmp", "udp/162": "snmptrap", "tcp/179": "bgp", "tcp/389": "ldap", "tcp/443": "https",
+	"udp/443": "quic", "tcp/445": "smb", "udp/500": "ike", "udp/514": "syslog", "tcp/587": "submission", "tcp/636": "ldaps",
+	"tcp/853": "dns-over-tls", "tcp/993": "imaps", "tcp/995": "pop3s", "udp/1194": "openvpn", "tcp/1433": "mssql",
+	"udp/1900": "ssdp", "tcp/2049": "nfs", "udp/3478": "stun", "tcp/3306": "mysql", "tcp/3389": "rdp", "udp/3389": "rdp",
+	"udp/4500": "ipsec-nat-t", "udp/5060": "sip", "tcp/5060": "sip", "tcp/5061": "sips", "udp/5353": "mdns", "tcp/5432": "postgresql",
+	"tcp/5900": "vnc", "tcp/6379": "redis", "tcp/7680": "delivery-optimization", "tcp/8080": "http-alt", "tcp/8443": "https-alt",
+	"tcp/9100": "jetdirect", "tcp/27017": "mongodb", "udp/51820": "wireguard",
+}
+
+var (
+	portNamesOnce sync.Once
+	portNames     map[portKey]string
+)
+
+// The system services file, e.g. /etc/services
+func servicesFilePath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("SystemRoot"), "System32", "drivers", "etc", "services")
+	}
+	return "/etc/services"
+}
+
+// Reading the services file under the well known names
+func loadPortNames() map[portKey]string {
+	names := make(map[portKey]string)
+	for k, name := range wellKnownPorts {
+		transport, port, _ := strings.Cut(k, "/")
+		n, _ := strconv.Atoi(port)
+		names[portKey{transport, n}] = name
+	}
+	f, err := os.Open(servicesFilePath())
+	if err != nil {
+		return names
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		port, proto, ok := strings.Cut(fields[1], "/")
+		n, err := strconv.Atoi(port)
+		if !ok || err != nil {
+			continue
+		}
+		// The first name listed for a port is the usual one
+		key := portKey{proto, n}
+		if _, seen := names[key]; !seen {
+			names[key] = fields[0]
+		}
+	}
+	return names
+}
+
+// The service name of transport/port, empty when unknown
+func portServiceName(transport string, port int) string {
+	portNamesOnce.Do(func() { portNames = loadPortNames() })
+	return portNames[portKey{transport, port}]
+}
+
+// PortUsage is the traffic of one server port
+type PortUsage struct {
+	Port      int     `json:"port"`
+	Transport string  `json:"transport"`
+	Service   string  `json:"service,omitempty"`
+	Packets   int     `json:"packets"`
+	Bytes     int64   `json:"bytes"`
+	Percent   float64 `json:"percent"` // of the TCP and UDP bytes
+}
+
+type portKey struct {
+	transport string
+	port      int
+}
+
+func (k portKey) String() string {
+	return fmt.Sprintf("%d/%s", k.port, k.transport)
+}
+
+type portCount struct {
+	packets int
+	bytes   int64
+}
+
+// PortTracker counts TCP and UDP traffic per destination port, and per
+// bucket so a spike can be put down to a port. Replies count towards the
+// port they come from, so a download over 443 shows as 443: a packet goes
+// to its destination port when that one has a service name or is the lower
+// one. It is guarded by the MonitoringData mutex.
+type PortTracker struct {
+	top     int // ports in the report, 0 for none
+	ports   map[portKey]*portCount
+	current map[portKey]int64
+	buckets []portBucket
+}
+
+type portBucket struct {
+	start time.Time
+	bytes map[portKey]int64
+}
+
+func NewPortTracker() *PortTracker {
+	return &PortTracker{top: 10, ports: make(map[portKey]*portCount), current: make(map[portKey]int64)}
+}
+
+// The server side port of a packet
+func serverPort(p *netwatch.Packet) int {
+	if portServiceName(p.Transport, p.DstPort) != "" {
+		return p.DstPort
+	}
+	if portServiceName(p.Transport, p.SrcPort) != "" || p.SrcPort < p.DstPort {
+		return p.SrcPort
+	}
+	return p.DstPort
+}
+
+func (t *PortTracker) Observe(p *netwatch.Packet) {
+	if t == nil || (p.Transport != "tcp" && p.Transport != "udp") || p.DstPort == 0 {
+		return
+	}
+	k := portKey{p.Transport, serverPort(p)}
+	c := t.ports[k]
+	if c == nil {
+		c = &portCount{}
+		t.ports[k] = c
+	}
+	c.packets++
+	c.bytes += int64(p.Length)
+	t.current[k] += int64(p.Length)
+}
+
+// Closing the bucket that started at start. Callers hold data.mu.
+func (t *PortTracker) rotate(start time.Time) {
+	if t == nil {
+		return
+	}
+	t.buckets = append(t.buckets, portBucket{start, t.current})
+	t.current = make(map[portKey]int64)
+}
+
+func (t *PortTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.ports = make(map[portKey]*portCount)
+	t.buckets = nil
+}
+
+// The busiest ports by bytes, at most the report's top
+func (t *PortTracker) Top() []PortUsage {
+	if t == nil || t.top <= 0 {
+		return nil
+	}
+	var total int64
+	var usage []PortUsage
+	for k, c := range t.ports {
+		total += c.bytes
+		usage = append(usage, PortUsage{Port: k.port, Transport: k.transport, Packets: c.packets, Bytes: c.bytes})
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Bytes != usage[j].Bytes {
+			return usage[i].Bytes > usage[j].Bytes
+		}
+		if usage[i].Port != usage[j].Port {
+			return usage[i].Port < usage[j].Port
+		}
+		return usage[i].Transport < usage[j].Transport
+	})
+	usage = usage[:min(len(usage), t.top)]
+	for i := range usage {
+		u := &usage[i]
+		u.Service = portServiceName(u.Transport, u.Port)
+		if total > 0 {
+			u.Percent = float64(u.Bytes) / float64(total) * 100
+		}
+	}
+	return usage
+}
+
+func printPortReport(t *PortTracker, bucket time.Duration) {
+	top := t.Top()
+	if len(top) == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("TOP PORTS")
+	fmt.Printf("%-10s %-22s %10s %10s %7s\n", "port", "service", "packets", "MB", "share")
+	for _, u := range top {
+		service := u.Service
+		if service == "" {
+			service = "-"
+		}
+		fmt.Printf("%-10s %-22s %10d %10.2f %6.1f%%\n", portKey{u.Transport, u.Port}, truncate(service, 22), u.Packets,
+			float64(u.Bytes)/(1024*1024), u.Percent)
+	}
+
+	// The port that carried most of the busiest bucket
+	var busiest *portBucket
+	var most int64
+	for i, b := range t.buckets {
+		var sum int64
+		for _, n := range b.bytes {
+			sum += n
+		}
+		if sum > most {
+			busiest, most = &t.buckets[i], sum
+		}
+	}
+	if busiest == nil {
+		return
+	}
+	var lead portKey
+	for k, n := range busiest.bytes {
+		if cur := busiest.bytes[lead]; n > cur || (n == cur && k.String() < lead.String()) {
+			lead = k
+		}
+	}
+	name := lead.String()
+	if s := portServiceName(lead.transport, lead.port); s != "" {
+		name += " " + s
+	}
+	fmt.Printf("Busiest %s %s: %.2f MB, %.0f%% of it %s\n", bucketUnit(bucket), busiest.start.Format("15:04:05"),
+		float64(most)/(1024*1024), float64(busiest.bytes[lead])/float64(most)*100, name)
+}
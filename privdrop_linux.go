@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"netwatchd/netwatch"
+)
+
+const prSetNoNewPrivs = 38
+
+// The ids of user name and its groups
+func lookupUser(name string) (uid, gid int, groups []int, err error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if uid, err = strconv.Atoi(u.Uid); err != nil {
+		return 0, 0, nil, fmt.Errorf("user %s: uid %v", name, err)
+	}
+	if gid, err = strconv.Atoi(u.Gid); err != nil {
+		return 0, 0, nil, fmt.Errorf("user %s: gid %v", name, err)
+	}
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if g, err := strconv.Atoi(id); err == nil {
+				groups = append(groups, g)
+			}
+		}
+	}
+	return uid, gid, groups, nil
+}
+
+// The user tshark is started as with -user. Signals from a process that has
+// dropped root only reach children of the same user, so tshark runs as it
+// from the start. Nil when no switch is needed.
+func tsharkCredential(name string) (*netwatch.Credential, error) {
+	uid, gid, groups, err := lookupUser(name)
+	if err != nil || os.Geteuid() == uid {
+		return nil, err
+	}
+	c := &netwatch.Credential{UID: uint32(uid), GID: uint32(gid)}
+	for _, g := range groups {
+		c.Groups = append(c.Groups, uint32(g))
+	}
+	return c, nil
+}
+
+// Switching the whole process to an unprivileged user once the capture socket
+// or tshark is running. Open descriptors stay usable and nothing new can be
+// opened with root rights. no_new_privs is set on every thread, since any of
+// them may exec, so setuid binaries can't regain them; builds with cgo can't
+// set it process wide and only warn.
+func dropPrivileges(name string) error {
+	uid, gid, groups, err := lookupUser(name)
+	if err != nil {
+		return err
+	}
+	if os.Geteuid() == uid {
+		return nil
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("switching to user %s needs root", name)
+	}
+
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups: %v", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid: %v", err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid: %v", err)
+	}
+	switch _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno {
+	case 0:
+	case syscall.ENOTSUP:
+		logger.Warn("no_new_privs is not set, build with CGO_ENABLED=0 for it")
+	default:
+		return fmt.Errorf("no_new_privs: %v", errno)
+	}
+	// Setuid(0) must fail now, otherwise the drop did not take
+	if syscall.Setuid(0) == nil {
+		return fmt.Errorf("still able to regain root after switching to %s", name)
+	}
+	fmt.Printf("Dropped privileges, running as %s (uid %d)\n", name, uid)
+	return nil
+}
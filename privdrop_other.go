@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"netwatchd/netwatch"
+)
+
+func tsharkCredential(name string) (*netwatch.Credential, error) {
+	return nil, nil
+}
+
+func dropPrivileges(name string) error {
+	return fmt.Errorf("-user is not supported on %s, start netwatchd as the unprivileged user instead", runtime.GOOS)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// Layers that only say how the next header was found, left out of the hierarchy
+var protocolGlue = map[string]bool{"ethertype": true}
+
+// Link layers, not counted in the per-bucket series
+var linkLayers = map[string]bool{"eth": true, "raw": true, "sll": true, "llc": true}
+
+// ProtocolBreakdown counts captured packets and bytes per protocol stack, like
+// tshark -z io,phs, and per bucket for every protocol seen. It is guarded by
+// the MonitoringData mutex.
+type ProtocolBreakdown struct {
+	tree    map[string]*protocolCount // keyed by the layer path, e.g. eth:ip:tcp:tls
+	current map[string]int
+	buckets []map[string]int
+}
+
+type protocolCount struct {
+	Packets int
+	Bytes   int64
+}
+
+// ReportProtocol is one row of the protocol hierarchy
+type ReportProtocol struct {
+	Path    string `json:"path"`
+	Packets int    `json:"packets"`
+	Bytes   int64  `json:"bytes"`
+}
+
+func NewProtocolBreakdown() *ProtocolBreakdown {
+	return &ProtocolBreakdown{tree: make(map[string]*protocolCount), current: make(map[string]int)}
+}
+
+func (b *ProtocolBreakdown) Observe(p *netwatch.Packet) {
+	if b == nil {
+		return
+	}
+	// tshark summary lines only have the protocol column
+	layers := p.Protocols
+	if len(layers) == 0 && p.Protocol != "" {
+		layers = []string{strings.ToLower(p.Protocol)}
+	}
+	path := ""
+	for _, layer := range layers {
+		if protocolGlue[layer] {
+			continue
+		}
+		if path != "" {
+			path += ":"
+		}
+		path += layer
+		c := b.tree[path]
+		if c == nil {
+			c = &protocolCount{}
+			b.tree[path] = c
+		}
+		c.Packets++
+		c.Bytes += int64(p.Length)
+		if !linkLayers[layer] {
+			b.current[layer]++
+		}
+	}
+}
+
+// Closing the current bucket. Callers hold data.mu.
+func (b *ProtocolBreakdown) rotate() {
+	if b == nil {
+		return
+	}
+	b.buckets = append(b.buckets, b.current)
+	b.current = make(map[string]int)
+}
+
+func (b *ProtocolBreakdown) reset() {
+	if b == nil {
+		return
+	}
+	b.tree = make(map[string]*protocolCount)
+	b.buckets = nil
+}
+
+// The hierarchy in display order: every parent before its children, busiest first
+func (b *ProtocolBreakdown) Rows() []ReportProtocol {
+	if b == nil {
+		return nil
+	}
+	children := make(map[string][]string)
+	for path := range b.tree {
+		parent := ""
+		if i := strings.LastIndex(path, ":"); i >= 0 {
+			parent = path[:i]
+		}
+		children[parent] = append(children[parent], path)
+	}
+	var rows []ReportProtocol
+	var walk func(parent string)
+	walk = func(parent string) {
+		kids := children[parent]
+		sort.Slice(kids, func(i, j int) bool {
+			if b.tree[kids[i]].Packets != b.tree[kids[j]].Packets {
+				return b.tree[kids[i]].Packets > b.tree[kids[j]].Packets
+			}
+			return kids[i] < kids[j]
+		})
+		for _, path := range kids {
+			c := b.tree[path]
+			rows = append(rows, ReportProtocol{Path: path, Packets: c.Packets, Bytes: c.Bytes})
+			walk(path)
+		}
+	}
+	walk("")
+	return rows
+}
+
+// Per-bucket packet counts of protocol name, for the report buckets
+func (b *ProtocolBreakdown) bucketCounts(i int) map[string]int {
+	if b == nil || i >= len(b.buckets) || len(b.buckets[i]) == 0 {
+		return nil
+	}
+	return b.buckets[i]
+}
+
+func printProtocolReport(b *ProtocolBreakdown, bucket time.Duration) {
+	rows := b.Rows()
+	if len(rows) == 0 {
+		return
+	}
+	total := 0
+	for _, r := range rows {
+		if !strings.Contains(r.Path, ":") {
+			total += r.Packets
+		}
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("PROTOCOL HIERARCHY")
+	fmt.Printf("%-28s %10s %7s %10s\n", "protocol", "packets", "share", "MB")
+	for _, r := range rows {
+		depth := strings.Count(r.Path, ":")
+		name := r.Path[strings.LastIndex(r.Path, ":")+1:]
+		fmt.Printf("%-28s %10d %6.1f%% %10.2f\n", strings.Repeat("  ", depth)+name, r.Packets,
+			float64(r.Packets)/float64(total)*100, float64(r.Bytes)/(1024*1024))
+	}
+
+	// The busiest protocols per bucket, link layers left out
+	totals := make(map[string]int)
+	for _, m := range b.buckets {
+		for name, n := range m {
+			totals[name] += n
+		}
+	}
+	var names []string
+	for name := range totals {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if totals[names[i]] != totals[names[j]] {
+			return totals[names[i]] > totals[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > 8 {
+		names = names[:8]
+	}
+	for _, name := range names {
+		var series []string
+		for _, m := range b.buckets {
+			series = append(series, fmt.Sprint(m[name]))
+		}
+		fmt.Printf("%s packets per %s: %s\n", name, bucketUnit(bucket), strings.Join(series, " "))
+	}
+}
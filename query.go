@@ -0,0 +1,572 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"netwatchd/sqlite"
+)
+
+// Tables a query without from is run on, the first with every column it names
+var queryTables = []string{"buckets", "alerts", "hosts", "sessions", "flows"}
+
+// The column time stands for in each table
+var queryTimeColumns = map[string]string{"buckets": "start_time", "alerts": "time", "hosts": "last_seen", "sessions": "start_time", "flows": "time"}
+
+const queryListLimit = 100
+
+// query is a parsed netwatchd query:
+//
+//	top <n> <column> [by <metric>] [from <table>] [where <cond> [and <cond>]...]
+//	count|sum|avg|min|max [<metric>] [from <table>] [where ...]
+//	list <column>[,<column>]... [from <table>] [where ...] [limit <n>]
+//
+// A condition compares a column with =, !=, <, <=, >, >= or ~ (contains). An
+// address matches a CIDR with =, and times take -1h or -7d relative to now.
+type query struct {
+	verb    string
+	n       int
+	group   string
+	metric  string // a column, or empty to count rows
+	columns []string
+	table   string
+	conds   []queryCond
+}
+
+type queryCond struct {
+	column, op, value string
+}
+
+var queryOps = []string{"!=", "<=", ">=", "=", "<", ">", "~"}
+
+// Splitting into words, quoted strings, operators and commas
+func queryTokens(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, errors.New("unterminated quote")
+			}
+			// Quoted values keep their quote so they are never read as keywords
+			tokens = append(tokens, s[i:i+end+2])
+			i += end + 2
+		case c == ',':
+			tokens = append(tokens, ",")
+			i++
+		case strings.IndexByte("!<>=~", c) >= 0:
+			op := ""
+			for _, o := range queryOps {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("invalid operator at %q", s[i:])
+			}
+			tokens = append(tokens, op)
+			i += len(op)
+		default:
+			j := i
+			for j < len(s) && !unicode.IsSpace(rune(s[j])) && strings.IndexByte("!<>=~,\"'", s[j]) < 0 {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func parseQuery(s string) (*query, error) {
+	tokens, err := queryTokens(s)
+	if err != nil {
+		return nil, err
+	}
+	pos := 0
+	next := func() string {
+		if pos == len(tokens) {
+			return ""
+		}
+		pos++
+		return tokens[pos-1]
+	}
+	peek := func() string {
+		if pos == len(tokens) {
+			return ""
+		}
+		return strings.ToLower(tokens[pos])
+	}
+	number := func(what string) (int, error) {
+		t := next()
+		n, err := strconv.Atoi(t)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("expected a positive number after %s, got %q", what, t)
+		}
+		return n, nil
+	}
+
+	q := &query{verb: strings.ToLower(next())}
+	switch q.verb {
+	case "top":
+		if q.n, err = number("top"); err != nil {
+			return nil, err
+		}
+		if q.group = next(); q.group == "" {
+			return nil, errors.New("top needs a column to group by")
+		}
+		if peek() == "by" {
+			next()
+			if q.metric = next(); q.metric == "" {
+				return nil, errors.New("by needs a column")
+			}
+		}
+	case "count":
+	case "sum", "avg", "min", "max":
+		if q.metric = next(); q.metric == "" {
+			return nil, fmt.Errorf("%s needs a column", q.verb)
+		}
+	case "list":
+		q.n = queryListLimit
+		for {
+			c := next()
+			if c == "" || c == "," {
+				return nil, errors.New("list needs columns")
+			}
+			q.columns = append(q.columns, c)
+			if peek() != "," {
+				break
+			}
+			next()
+		}
+	case "":
+		return nil, errors.New("empty query")
+	default:
+		return nil, fmt.Errorf("unknown query %q, start with top, count, sum, avg, min, max or list", q.verb)
+	}
+
+	for pos < len(tokens) {
+		switch kw := strings.ToLower(next()); kw {
+		case "from":
+			if q.table = next(); !slices.Contains(queryTables, q.table) {
+				return nil, fmt.Errorf("unknown table %q, use %s", q.table, strings.Join(queryTables, ", "))
+			}
+		case "where":
+			for {
+				c := queryCond{column: next(), op: next(), value: next()}
+				if !slices.Contains(queryOps, c.op) || c.value == "" {
+					return nil, fmt.Errorf("invalid condition %s %s %s, use e.g. kind=amplification or time>-1h", c.column, c.op, c.value)
+				}
+				c.value = strings.Trim(c.value, `"'`)
+				q.conds = append(q.conds, c)
+				if peek() != "and" {
+					break
+				}
+				next()
+			}
+		case "limit":
+			if q.verb != "list" {
+				return nil, errors.New("limit only applies to list, top takes its own count")
+			}
+			if q.n, err = number("limit"); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %q, expected from, where or limit", kw)
+		}
+	}
+	return q, nil
+}
+
+// The columns a query refers to, time standing for itself
+func (q *query) referenced() []string {
+	cols := append([]string(nil), q.columns...)
+	for _, c := range []string{q.group, q.metric} {
+		if c != "" {
+			cols = append(cols, c)
+		}
+	}
+	for _, c := range q.conds {
+		cols = append(cols, c.column)
+	}
+	return cols
+}
+
+// Picking the table, the explicit one or the first with every column
+func (q *query) pickTable() (string, error) {
+	tables := queryTables
+	if q.table != "" {
+		tables = []string{q.table}
+	}
+	for _, t := range tables {
+		// The columns of the table as exportRows joins them
+		empty, err := exportRows(&sqlite.Database{}, t, time.Time{}, time.Time{})
+		if err != nil {
+			return "", err
+		}
+		names := []string{"time"}
+		for _, c := range empty.Columns {
+			names = append(names, c.name)
+		}
+		missing := ""
+		for _, c := range q.referenced() {
+			if !slices.Contains(names, c) {
+				missing = c
+				break
+			}
+		}
+		if missing == "" {
+			return t, nil
+		}
+		if q.table != "" {
+			return "", fmt.Errorf("%s has no column %q, it has %s", t, missing, strings.Join(names[1:], ", "))
+		}
+	}
+	return "", errors.New("no table has every column of the query, name one with from")
+}
+
+// queryResult is a table of strings and numbers, as printed
+type queryResult struct {
+	Columns []string `json:"columns"`
+	Rows    [][]any  `json:"rows"`
+}
+
+func queryNumber(v any) (float64, bool) {
+	switch x := v.(type) {
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
+
+// Reading a condition value as a time, -1h and -7d counting back from now
+func queryTime(s string, now time.Time) (time.Time, error) {
+	if rest, ok := strings.CutPrefix(s, "-"); ok {
+		if days, ok := strings.CutSuffix(rest, "d"); ok {
+			if n, err := strconv.Atoi(days); err == nil {
+				return now.AddDate(0, 0, -n), nil
+			}
+		}
+		if d, err := time.ParseDuration(rest); err == nil {
+			return now.Add(-d), nil
+		}
+	}
+	return parseExportTime(s)
+}
+
+// Whether a row value passes the condition of column c
+func (c queryCond) match(col exportColumn, v any, now time.Time) (bool, error) {
+	if v == nil {
+		return c.op == "!=", nil
+	}
+	var cmp int
+	switch {
+	case col.time():
+		want, err := queryTime(c.value, now)
+		if err != nil {
+			return false, err
+		}
+		s, _ := v.(string)
+		got, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return false, nil
+		}
+		cmp = got.Compare(want)
+	default:
+		text := fmt.Sprint(v)
+		if c.op == "~" {
+			return strings.Contains(strings.ToLower(text), strings.ToLower(c.value)), nil
+		}
+		// An address against a CIDR
+		if _, n, err := net.ParseCIDR(c.value); err == nil && (c.op == "=" || c.op == "!=") {
+			if ip := net.ParseIP(text); ip != nil {
+				return n.Contains(ip) == (c.op == "="), nil
+			}
+		}
+		if got, ok := queryNumber(v); ok {
+			want, err := strconv.ParseFloat(c.value, 64)
+			if err != nil {
+				return false, fmt.Errorf("%s is a number, not %q", c.column, c.value)
+			}
+			cmp = compareFloat(got, want)
+		} else if c.op == "=" || c.op == "!=" {
+			cmp = 1
+			if strings.EqualFold(text, c.value) {
+				cmp = 0
+			}
+		} else {
+			cmp = strings.Compare(text, c.value)
+		}
+	}
+	switch c.op {
+	case "=":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+	return strings.Contains(fmt.Sprint(v), c.value), nil
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Running the query on the database
+func (q *query) run(db *sqlite.Database, now time.Time) (*queryResult, error) {
+	table, err := q.pickTable()
+	if err != nil {
+		return nil, err
+	}
+	slice, err := exportRows(db, table, time.Time{}, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	index := func(name string) int {
+		if name == "time" {
+			name = queryTimeColumns[table]
+		}
+		return slices.IndexFunc(slice.Columns, func(c exportColumn) bool { return c.name == name })
+	}
+
+	var rows [][]any
+	for _, row := range slice.Rows {
+		keep := true
+		for _, c := range q.conds {
+			i := index(c.column)
+			ok, err := c.match(slice.Columns[i], row[i], now)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			rows = append(rows, row)
+		}
+	}
+
+	metric := func(row []any) (float64, error) {
+		if q.metric == "" {
+			return 1, nil
+		}
+		v := row[index(q.metric)]
+		if v == nil {
+			return 0, nil
+		}
+		n, ok := queryNumber(v)
+		if !ok {
+			return 0, fmt.Errorf("%s is not a number", q.metric)
+		}
+		return n, nil
+	}
+	metricName := q.metric
+	if metricName == "" {
+		metricName = "count"
+	}
+
+	res := &queryResult{}
+	switch q.verb {
+	case "top":
+		sums := make(map[string]float64)
+		for _, row := range rows {
+			n, err := metric(row)
+			if err != nil {
+				return nil, err
+			}
+			key := ""
+			if v := row[index(q.group)]; v != nil {
+				key = fmt.Sprint(v)
+			}
+			sums[key] += n
+		}
+		keys := make([]string, 0, len(sums))
+		for k := range sums {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if sums[keys[i]] != sums[keys[j]] {
+				return sums[keys[i]] > sums[keys[j]]
+			}
+			return keys[i] < keys[j]
+		})
+		res.Columns = []string{q.group, metricName}
+		for _, k := range keys[:min(q.n, len(keys))] {
+			res.Rows = append(res.Rows, []any{k, sums[k]})
+		}
+	case "list":
+		res.Columns = q.columns
+		for _, row := range rows[:min(q.n, len(rows))] {
+			out := make([]any, len(q.columns))
+			for i, c := range q.columns {
+				out[i] = row[index(c)]
+			}
+			res.Rows = append(res.Rows, out)
+		}
+	default:
+		value := 0.0
+		switch q.verb {
+		case "min":
+			value = math.Inf(1)
+		case "max":
+			value = math.Inf(-1)
+		}
+		for _, row := range rows {
+			n, err := metric(row)
+			if err != nil {
+				return nil, err
+			}
+			switch q.verb {
+			case "min":
+				value = math.Min(value, n)
+			case "max":
+				value = math.Max(value, n)
+			default:
+				value += n
+			}
+		}
+		if q.verb == "avg" && len(rows) > 0 {
+			value /= float64(len(rows))
+		}
+		name := q.verb
+		if q.metric != "" {
+			name += "(" + q.metric + ")"
+		}
+		res.Columns = []string{name}
+		if math.IsInf(value, 0) || (q.verb == "avg" && len(rows) == 0) {
+			res.Rows = [][]any{{nil}}
+		} else {
+			res.Rows = [][]any{{value}}
+		}
+	}
+	return res, nil
+}
+
+func queryCell(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func (r *queryResult) printText() {
+	widths := make([]int, len(r.Columns))
+	for i, c := range r.Columns {
+		widths[i] = len(c)
+	}
+	for _, row := range r.Rows {
+		for i, v := range row {
+			widths[i] = max(widths[i], len(queryCell(v)))
+		}
+	}
+	line := func(cells []string) {
+		for i, c := range cells {
+			if i < len(cells)-1 {
+				fmt.Printf("%-*s  ", widths[i], c)
+			} else {
+				fmt.Println(c)
+			}
+		}
+	}
+	line(r.Columns)
+	rule := make([]string, len(r.Columns))
+	for i := range rule {
+		rule[i] = strings.Repeat("-", widths[i])
+	}
+	line(rule)
+	for _, row := range r.Rows {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			cells[i] = queryCell(v)
+		}
+		line(cells)
+	}
+}
+
+// Handling "netwatchd query": ad-hoc questions over the -db database
+func runQueryCommand(args []string) int {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	dbFlag := fs.String("db", "netwatch.db", "SQLite database written with -db")
+	outputFlag := fs.String("o", "text", "Result format: text, json or csv")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Println("Usage: netwatchd query [-db netwatch.db] [-o text|json|csv] '<query>'")
+		fmt.Println("  e.g. 'top 10 ip by bytes where time>-1h', 'sum bytes where host=gw and time>-7d',")
+		fmt.Println("       'top 10 dst_ip by bytes where proto=tcp and time>-1h',")
+		fmt.Println("       'list time,kind,message from alerts where severity=critical limit 20'")
+		return 2
+	}
+
+	q, err := parseQuery(strings.Join(fs.Args(), " "))
+	if err != nil {
+		fmt.Printf("Invalid query: %v\n", err)
+		return 2
+	}
+	db, err := sqlite.ReadFile(*dbFlag)
+	if err != nil {
+		fmt.Printf("Failed to open %s: %v\n", *dbFlag, err)
+		return 1
+	}
+	res, err := q.run(db, time.Now())
+	if err != nil {
+		fmt.Printf("Query failed: %v\n", err)
+		return 1
+	}
+
+	switch *outputFlag {
+	case "json":
+		b, _ := json.MarshalIndent(res, "", "  ")
+		fmt.Println(string(b))
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write(res.Columns)
+		for _, row := range res.Rows {
+			cells := make([]string, len(row))
+			for i, v := range row {
+				cells[i] = queryCell(v)
+			}
+			w.Write(cells)
+		}
+		w.Flush()
+	default:
+		res.printText()
+	}
+	return 0
+}
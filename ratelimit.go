@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+
+	"netwatchd/netwatch"
+)
+
+const (
+	// Talkers and ports below both rates don't get a rule
+	rateLimitMinBps = 1e6
+	rateLimitMinPps = 1000
+	rateLimitTop    = 5
+	// Bounding memory on scans and floods, later keys are not tracked
+	rateLimitMaxKeys = 10000
+)
+
+// RateTracker keeps the peak per-bucket rate of every source address and
+// destination port, to suggest rate limits after the run. It is guarded by
+// the MonitoringData mutex.
+type RateTracker struct {
+	sources map[string]*rateCounter
+	ports   map[string]*rateCounter // keyed by transport/port, e.g. udp/53
+}
+
+type rateCounter struct {
+	bytes, packets   int64 // current bucket
+	totalBytes       int64
+	totalPackets     int64
+	seconds          float64 // buckets with traffic
+	peakBps, peakPps float64
+}
+
+// RateLimitAdvice is a suggested limit for one source or destination port
+type RateLimitAdvice struct {
+	Kind       string   `json:"kind"` // source or port
+	Target     string   `json:"target"`
+	PeakBps    float64  `json:"peak_bps"`
+	PeakPps    float64  `json:"peak_pps"`
+	AverageBps float64  `json:"average_bps"`
+	LimitBps   float64  `json:"limit_bps"`
+	Rules      []string `json:"rules"`
+}
+
+func NewRateTracker() *RateTracker {
+	return &RateTracker{sources: make(map[string]*rateCounter), ports: make(map[string]*rateCounter)}
+}
+
+func (t *RateTracker) Observe(p *netwatch.Packet) {
+	if t == nil {
+		return
+	}
+	if p.SrcIP != "" {
+		t.count(t.sources, p.SrcIP, p.Length)
+	}
+	if p.Transport != "" && p.DstPort != 0 {
+		t.count(t.ports, fmt.Sprintf("%s/%d", p.Transport, p.DstPort), p.Length)
+	}
+}
+
+func (t *RateTracker) count(m map[string]*rateCounter, key string, length int) {
+	c := m[key]
+	if c == nil {
+		if len(m) >= rateLimitMaxKeys {
+			return
+		}
+		c = &rateCounter{}
+		m[key] = c
+	}
+	c.bytes += int64(length)
+	c.packets++
+}
+
+// Closing a bucket of the given length. Callers hold data.mu.
+func (t *RateTracker) rotate(seconds float64) {
+	if t == nil || seconds <= 0 {
+		return
+	}
+	for _, m := range []map[string]*rateCounter{t.sources, t.ports} {
+		for _, c := range m {
+			if c.packets == 0 {
+				continue
+			}
+			c.peakBps = max(c.peakBps, float64(c.bytes)*8/seconds)
+			c.peakPps = max(c.peakPps, float64(c.packets)/seconds)
+			c.totalBytes += c.bytes
+			c.totalPackets += c.packets
+			c.seconds += seconds
+			c.bytes, c.packets = 0, 0
+		}
+	}
+}
+
+func (t *RateTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.sources = make(map[string]*rateCounter)
+	t.ports = make(map[string]*rateCounter)
+}
+
+// The busiest sources and ports with a suggested limit and rules for goos
+func (t *RateTracker) Advice(goos, iface string) []RateLimitAdvice {
+	if t == nil {
+		return nil
+	}
+	var advice []RateLimitAdvice
+	for _, kind := range []string{"source", "port"} {
+		m := t.sources
+		if kind == "port" {
+			m = t.ports
+		}
+		var keys []string
+		for k, c := range m {
+			if c.peakBps >= rateLimitMinBps || c.peakPps >= rateLimitMinPps {
+				keys = append(keys, k)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if m[keys[i]].peakBps != m[keys[j]].peakBps {
+				return m[keys[i]].peakBps > m[keys[j]].peakBps
+			}
+			return keys[i] < keys[j]
+		})
+		for _, k := range keys[:min(rateLimitTop, len(keys))] {
+			c := m[k]
+			a := RateLimitAdvice{Kind: kind, Target: k, PeakBps: c.peakBps, PeakPps: c.peakPps,
+				AverageBps: float64(c.totalBytes) * 8 / c.seconds}
+			// Twice the usual rate or half the peak, so steady traffic keeps headroom and bursts get trimmed
+			a.LimitBps = roundRate(max(2*a.AverageBps, a.PeakBps/2))
+			a.Rules = rateLimitRules(goos, iface, kind, k, a.LimitBps)
+			advice = append(advice, a)
+		}
+	}
+	return advice
+}
+
+// Rounding up to 1, 2 or 5 times a power of ten
+func roundRate(bps float64) float64 {
+	if bps <= 0 {
+		return 0
+	}
+	p := math.Pow(10, math.Floor(math.Log10(bps)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if bps <= m*p {
+			return m * p
+		}
+	}
+	return 10 * p
+}
+
+// Example rules limiting one source address or destination port
+func rateLimitRules(goos, iface, kind, target string, bps float64) []string {
+	kbit := int64(bps / 1000)
+	kbytes := int64(bps / 8 / 1000)
+	name := "netwatchd-" + strings.NewReplacer("/", "-", ":", "-").Replace(target)
+
+	var nftMatch, tcMatch, qosMatch, netshMatch string
+	if kind == "source" {
+		family, prefix, ethertype := "ip", "/32", "ip"
+		if ip := net.ParseIP(target); ip != nil && ip.To4() == nil {
+			family, prefix, ethertype = "ip6", "/128", "ipv6"
+		}
+		nftMatch = fmt.Sprintf("%s saddr %s", family, target)
+		tcMatch = fmt.Sprintf("protocol %s u32 match %s src %s%s", ethertype, family, target, prefix)
+		qosMatch = fmt.Sprintf("-IPSrcPrefixMatchCondition %s%s", target, prefix)
+		netshMatch = fmt.Sprintf("remoteip=%s", target)
+	} else {
+		proto, port, _ := strings.Cut(target, "/")
+		nftMatch = fmt.Sprintf("%s dport %s", proto, port)
+		protoNum := 6
+		if proto == "udp" {
+			protoNum = 17
+		}
+		tcMatch = fmt.Sprintf("protocol ip u32 match ip protocol %d 0xff match ip dport %s 0xffff", protoNum, port)
+		qosMatch = fmt.Sprintf("-IPProtocolMatchCondition %s -IPDstPortMatchCondition %s", strings.ToUpper(proto), port)
+		netshMatch = fmt.Sprintf("protocol=%s localport=%s", proto, port)
+	}
+
+	switch goos {
+	case "windows":
+		return []string{
+			// QoS policies throttle what this host sends
+			fmt.Sprintf("New-NetQosPolicy -Name %s %s -ThrottleRateActionBitsPerSecond %d", name, qosMatch, int64(bps)),
+			fmt.Sprintf("netsh advfirewall firewall add rule name=%s dir=in action=block %s", name, netshMatch),
+		}
+	default:
+		return []string{
+			fmt.Sprintf("nft add rule inet filter input %s limit rate over %d kbytes/second drop", nftMatch, kbytes),
+			fmt.Sprintf("tc filter add dev %s parent ffff: %s police rate %dkbit burst %dk drop", iface, tcMatch, kbit, max(kbytes/10, 10)),
+		}
+	}
+}
+
+func printRateLimitReport(advice []RateLimitAdvice, goos, iface string) {
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("RATE LIMIT RECOMMENDATIONS")
+	if len(advice) == 0 {
+		fmt.Printf("No source or port reached %s or %d packets/s in a bucket\n", formatBitrate(rateLimitMinBps), rateLimitMinPps)
+		return
+	}
+	if goos == "windows" {
+		fmt.Println("QoS policies shape outbound traffic, the firewall rule blocks inbound outright")
+	} else {
+		fmt.Printf("tc police needs an ingress qdisc first: tc qdisc add dev %s ingress\n", iface)
+	}
+	for _, a := range advice {
+		fmt.Printf("%s %s: peak %s (%.0f packets/s), average %s, suggested limit %s\n", a.Kind, a.Target,
+			formatBitrate(a.PeakBps), a.PeakPps, formatBitrate(a.AverageBps), formatBitrate(a.LimitBps))
+		for _, r := range a.Rules {
+			fmt.Printf("  %s\n", r)
+		}
+	}
+}
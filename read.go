@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// Opening a -read capture file and taking its first packet, whose time
+// starts the buckets
+func openCaptureFile(path, filter string, payload bool) (*netwatch.Packet, <-chan *netwatch.Packet, error) {
+	engine := &netwatch.FileEngine{Path: path, CaptureOptions: netwatch.CaptureOptions{Filter: filter, Payload: payload,
+		Logf: func(format string, args ...any) { fmt.Printf(format, args...) }}}
+	packets, err := engine.Start(context.Background())
+	if err != nil {
+		return nil, nil, err
+	}
+	first, ok := <-packets
+	if !ok {
+		return nil, nil, fmt.Errorf("no packets in %s", path)
+	}
+	return first, packets, nil
+}
+
+// Feeding a capture file through the buckets by packet time rather than the
+// clock, rotating whenever a packet falls past the current bucket. Returns
+// the time of the last packet.
+func readCapture(ctx context.Context, data *MonitoringData, first *netwatch.Packet, packets <-chan *netwatch.Packet) time.Time {
+	end := first.Time
+	for pkt := first; pkt != nil; {
+		data.mu.Lock()
+		for data.buckets.Due(pkt.Time) {
+			data.rotateBucket()
+		}
+		data.handlePacket(pkt, pkt.Time)
+		data.mu.Unlock()
+		if pkt.Time.After(end) {
+			end = pkt.Time
+		}
+
+		select {
+		case <-ctx.Done():
+			return end
+		case pkt = <-packets:
+		}
+	}
+	return end
+}
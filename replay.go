@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// MockEngine delivers a fixed list of packets, for replaying scripted traffic
+type MockEngine struct {
+	Packets []*netwatch.Packet
+}
+
+func (e *MockEngine) Start(ctx context.Context) (<-chan *netwatch.Packet, error) {
+	packets := make(chan *netwatch.Packet)
+	go func() {
+		defer close(packets)
+		for _, p := range e.Packets {
+			select {
+			case <-ctx.Done():
+				return
+			case packets <- p:
+			}
+		}
+	}()
+	return packets, nil
+}
+
+// MockCounter returns scripted values one read at a time, then fails
+type MockCounter struct {
+	Values []float64
+	next   int
+}
+
+var errCounterExhausted = errors.New("no more scripted values")
+
+func (c *MockCounter) GetValue() (float64, error) {
+	if c.next >= len(c.Values) {
+		return 0, errCounterExhausted
+	}
+	v := c.Values[c.next]
+	c.next++
+	return v, nil
+}
+
+func (c *MockCounter) Close() {}
+
+// ReplayScript is a scripted capture, one entry per one-minute bucket
+type ReplayScript struct {
+	Start      time.Time      `json:"start"`
+	Interfaces []string       `json:"interfaces,omitempty"` // replayed as if captured on several at once
+	Buckets    []ReplayBucket `json:"buckets"`
+}
+
+// ReplayBucket holds the traffic and per-second counter samples of one bucket.
+// Records are raw tshark -T fields lines, run through the same parser as a live capture.
+type ReplayBucket struct {
+	Packets []ReplayPacket `json:"packets,omitempty"`
+	Records []string       `json:"records,omitempty"`
+	Sent    []float64      `json:"sent,omitempty"`
+	Recv    []float64      `json:"recv,omitempty"`
+}
+
+// ReplayPacket describes a packet, or Count identical ones, at Offset seconds into its bucket
+type ReplayPacket struct {
+	Offset    float64  `json:"offset"`
+	Count     int      `json:"count,omitempty"`
+	Src       string   `json:"src"`
+	Dst       string   `json:"dst"`
+	Transport string   `json:"transport,omitempty"`
+	SrcPort   int      `json:"src_port,omitempty"`
+	DstPort   int      `json:"dst_port,omitempty"`
+	SYN       bool     `json:"syn,omitempty"`
+	ACK       bool     `json:"ack,omitempty"`
+	Length    int      `json:"length"`
+	Protocols []string `json:"protocols,omitempty"`
+	Info      string   `json:"info,omitempty"`
+	Payload   string   `json:"payload,omitempty"`
+	Interface string   `json:"interface,omitempty"` // one of the script's interfaces
+	MAC       string   `json:"mac,omitempty"`       // of the source
+	// An IPv6 router advertisement announcing these prefixes
+	RouterAdvert bool     `json:"router_advert,omitempty"`
+	RAPrefixes   []string `json:"ra_prefixes,omitempty"`
+	TLSCerts     [][]byte `json:"tls_certs,omitempty"` // base64 DER, server first
+}
+
+func loadReplayScript(path string) (*ReplayScript, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	var s ReplayScript
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if s.Start.IsZero() {
+		s.Start = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return &s, nil
+}
+
+// Packets for one bucket, in script order
+func (b *ReplayBucket) packets(bucketStart time.Time) ([]*netwatch.Packet, error) {
+	var out []*netwatch.Packet
+	n := 0
+	for _, rp := range b.Packets {
+		count := max(rp.Count, 1)
+		for range count {
+			n++
+			at := bucketStart.Add(time.Duration(rp.Offset * float64(time.Second)))
+			proto := strings.ToUpper(rp.Transport)
+			if len(rp.Protocols) > 0 {
+				proto = strings.ToUpper(rp.Protocols[len(rp.Protocols)-1])
+			}
+			out = append(out, &netwatch.Packet{
+				Number:       fmt.Sprint(n),
+				Interface:    rp.Interface,
+				Time:         at,
+				Relative:     fmt.Sprintf("%.6f", rp.Offset),
+				Length:       rp.Length,
+				Protocols:    rp.Protocols,
+				SrcIP:        rp.Src,
+				DstIP:        rp.Dst,
+				Transport:    rp.Transport,
+				SrcPort:      rp.SrcPort,
+				DstPort:      rp.DstPort,
+				SYN:          rp.SYN,
+				ACK:          rp.ACK,
+				Source:       rp.Src,
+				Destination:  rp.Dst,
+				Protocol:     proto,
+				Info:         rp.Info,
+				Payload:      []byte(rp.Payload),
+				SrcMAC:       rp.MAC,
+				RouterAdvert: rp.RouterAdvert,
+				RAPrefixes:   rp.RAPrefixes,
+				TLSCerts:     rp.TLSCerts,
+			})
+		}
+	}
+	for i, line := range b.Records {
+		p, err := netwatch.ParsePacket(line)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %v", i+1, err)
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// Feeding the script through the bucketing and alerting logic without
+// touching the wall clock. Returns the end of the replayed window.
+func (s *ReplayScript) Run(data *MonitoringData) (time.Time, error) {
+	for i, b := range s.Buckets {
+		bucketStart := s.Start.Add(time.Duration(i) * time.Minute)
+		pkts, err := b.packets(bucketStart)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("bucket %d: %v", i+1, err)
+		}
+
+		packets, _ := (&MockEngine{Packets: pkts}).Start(context.Background())
+		sent := &MockCounter{Values: b.Sent}
+		recv := &MockCounter{Values: b.Recv}
+
+		data.mu.Lock()
+		for pkt := range packets {
+			data.handlePacket(pkt, pkt.Time)
+		}
+		// Same accounting as monitorBandwidth, one sample per second
+		for {
+			sentBytes, err1 := sent.GetValue()
+			recvBytes, err2 := recv.GetValue()
+			if err1 != nil && err2 != nil {
+				break
+			}
+			data.currentBandwidth += sentBytes + recvBytes
+			data.currentSent += sentBytes
+			data.currentRecv += recvBytes
+		}
+		if i < len(s.Buckets)-1 {
+			data.rotateBucket()
+		}
+		data.mu.Unlock()
+	}
+	return s.Start.Add(time.Duration(len(s.Buckets)) * time.Minute), nil
+}
+
+// Handling "netwatchd replay": running a script and printing the usual report
+func runReplayCommand(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	configFlag := fs.String("config", "", "Config file for router allowlist, certificate warnings and segmentation policy")
+	verboseFlag := fs.Bool("v", false, "Print every replayed packet")
+	reportFileFlag := fs.String("report-file", "", "Also write the report as JSON to this file")
+	outputFlag := fs.String("o", "text", "Report format: text, json or csv")
+	notifyFlag := fs.Bool("notify", false, "Deliver alerts to the sinks and routes in the config")
+	dbFlag := fs.String("db", "", "Also add the session to this SQLite database")
+	historyFlag := fs.String("history", "", "Comma separated reports, directories or globs to compare with a week before the script")
+	fs.Parse(args)
+	var reportOut *os.File
+	if *outputFlag == "json" || *outputFlag == "csv" {
+		reportOut, os.Stdout = os.Stdout, os.Stderr
+	}
+	if fs.NArg() != 1 {
+		fmt.Println("Usage: netwatchd replay [-config file] [-v] [-o text|json|csv] [-report-file file] [-db file] [-history dir] <script.json>")
+		return 2
+	}
+
+	script, err := loadReplayScript(fs.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	cfg := &Config{}
+	if *configFlag != "" {
+		if cfg, err = loadConfig(*configFlag); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		if errs := cfg.Validate(); len(errs) > 0 {
+			fmt.Printf("Invalid config: %v\n", errs[0])
+			return 1
+		}
+	}
+	certWarnDays := 30
+	if cfg.CertWarnDays != nil {
+		certWarnDays = *cfg.CertWarnDays
+	}
+
+	data := newMonitoringData(script.Start, cfg.RARouters, certWarnDays)
+	data.captureInterface = "replay"
+	if len(script.Interfaces) > 0 {
+		data.setInterfaces(script.Interfaces)
+	}
+	data.quietPackets = !*verboseFlag
+	data.dedup = NewDeduper(50 * time.Millisecond)
+	data.matrix = NewTrafficMatrix(24, 64)
+	if *historyFlag != "" {
+		data.history = &weekHistory{sources: strings.Split(*historyFlag, ",")}
+	}
+	if len(cfg.PayloadPatterns) > 0 {
+		if data.patterns, err = NewPatternCounter(cfg.PayloadPatterns); err != nil {
+			fmt.Printf("Invalid payload pattern: %v\n", err)
+			return 1
+		}
+	}
+	if *notifyFlag && len(cfg.Sinks) > 0 {
+		if data.router, err = NewAlertRouter(cfg.Sinks, cfg.Routes, cfg.Labels); err != nil {
+			fmt.Printf("Invalid alert routing: %v\n", err)
+			return 1
+		}
+	}
+	if len(cfg.Maintenance) > 0 {
+		if data.silencer, err = NewSilencer(cfg.Maintenance, ""); err != nil {
+			fmt.Printf("Invalid maintenance window: %v\n", err)
+			return 1
+		}
+	}
+	if len(cfg.Expected) > 0 {
+		if data.watchdog, err = NewWatchdog(cfg.Expected, data.startTime); err != nil {
+			fmt.Printf("Invalid expected traffic: %v\n", err)
+			return 1
+		}
+	}
+	data.pricing = &Pricing{Currency: "$"}
+	if cfg.PricePerGB != nil {
+		data.pricing.PerGB = *cfg.PricePerGB
+	}
+	if cfg.PricePerMbps != nil {
+		data.pricing.PerMbps95 = *cfg.PricePerMbps
+	}
+	if cfg.Currency != "" {
+		data.pricing.Currency = cfg.Currency
+	}
+	if cfg.CostAlert != nil {
+		data.pricing.MonthlyAlert = *cfg.CostAlert
+	}
+	if cfg.Segmentation != nil {
+		if data.segmentation, err = NewSegmentationAuditor(cfg.Segmentation); err != nil {
+			fmt.Printf("Invalid segmentation policy: %v\n", err)
+			return 1
+		}
+	}
+
+	end, err := script.Run(data)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	data.requestedDuration = captureDuration(end.Sub(script.Start))
+	if reportOut != nil {
+		if err := printReportAs(*outputFlag, reportOut, data, end); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	} else {
+		generateReport(data, end)
+	}
+	data.router.Close(15 * time.Second)
+
+	if *reportFileFlag != "" {
+		if err := writeReport(*reportFileFlag, buildReport(data, end)); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+	if *dbFlag != "" {
+		if _, err := saveSession(*dbFlag, buildReport(data, end)); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var replayStart = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// A self-signed certificate for name that expired a day before the replay
+func expiredCert(t *testing.T, name string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    replayStart.AddDate(-1, 0, 0),
+		NotAfter:     replayStart.AddDate(0, 0, -1),
+		// CheckSignatureFrom only takes CA certificates as signers
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestReplayScript(t *testing.T) {
+	policy := &SegmentationPolicy{
+		Zones: []string{"10.0.0.0/8"},
+		Allow: []SegmentRule{{Name: "web", From: "10.1.0.0/16", To: "10.2.0.0/16", Ports: []string{"443/tcp"}}},
+	}
+	tests := []struct {
+		name      string
+		buckets   []ReplayBucket
+		routers   []string
+		policy    *SegmentationPolicy
+		packets   []int
+		bytes     []float64
+		total     int
+		alerts    []string
+		offenders []string
+	}{
+		{
+			name: "buckets",
+			buckets: []ReplayBucket{
+				{Packets: []ReplayPacket{{Offset: 1, Count: 10, Src: "10.0.0.1", Dst: "10.0.0.2", Transport: "udp", SrcPort: 5000, DstPort: 53, Length: 100}},
+					Sent: []float64{100, 100}, Recv: []float64{50}},
+				{},
+				{Packets: []ReplayPacket{{Offset: 59.5, Count: 5, Src: "10.0.0.2", Dst: "10.0.0.1", Transport: "tcp", SrcPort: 443, DstPort: 51000, ACK: true, Length: 1500}},
+					Recv: []float64{7500}},
+			},
+			packets: []int{10, 0, 5},
+			bytes:   []float64{250, 0, 7500},
+			total:   15,
+		},
+		{
+			name: "rogue ra",
+			buckets: []ReplayBucket{{Packets: []ReplayPacket{
+				{Offset: 1, Count: 3, Src: "fe80::1", Dst: "ff02::1", MAC: "00:11:22:33:44:55", Length: 120, RouterAdvert: true, RAPrefixes: []string{"2001:db8:1::"}},
+				{Offset: 2, Count: 2, Src: "fe80::bad", Dst: "ff02::1", MAC: "66:77:88:99:aa:bb", Length: 120, RouterAdvert: true, RAPrefixes: []string{"2001:db8:bad::"}},
+			}}},
+			routers:   []string{"fe80::1"},
+			packets:   []int{5},
+			bytes:     []float64{0},
+			total:     5,
+			alerts:    []string{"rogue-ra"},
+			offenders: []string{"fe80::bad"},
+		},
+		{
+			name: "segmentation",
+			buckets: []ReplayBucket{
+				{Packets: []ReplayPacket{
+					{Offset: 1, Src: "10.1.0.5", Dst: "10.2.0.9", Transport: "tcp", SrcPort: 40000, DstPort: 443, SYN: true, Length: 60},
+					{Offset: 2, Src: "10.1.0.5", Dst: "10.2.0.9", Transport: "tcp", SrcPort: 40001, DstPort: 22, SYN: true, Length: 60},
+					{Offset: 2, Src: "10.2.0.9", Dst: "10.1.0.5", Transport: "tcp", SrcPort: 22, DstPort: 40001, SYN: true, ACK: true, Length: 60},
+				}},
+				// The same path again is counted, not alerted
+				{Packets: []ReplayPacket{{Offset: 1, Src: "10.1.0.5", Dst: "10.2.0.9", Transport: "tcp", SrcPort: 40002, DstPort: 22, SYN: true, Length: 60}}},
+			},
+			policy:    policy,
+			packets:   []int{3, 1},
+			bytes:     []float64{0, 0},
+			total:     4,
+			alerts:    []string{"segmentation"},
+			offenders: []string{"10.1.0.5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := runReplay(t, &ReplayScript{Start: replayStart, Buckets: tt.buckets}, tt.routers, tt.policy)
+			checkReplayBuckets(t, r, tt.packets, tt.bytes)
+			if r.TotalPackets != tt.total {
+				t.Errorf("total packets %d, want %d", r.TotalPackets, tt.total)
+			}
+			var wantBytes float64
+			for _, b := range tt.bytes {
+				wantBytes += b
+			}
+			if r.TotalBytes != wantBytes {
+				t.Errorf("total bytes %v, want %v", r.TotalBytes, wantBytes)
+			}
+			var kinds, offenders []string
+			for _, a := range r.Alerts {
+				kinds = append(kinds, a.Kind)
+				offenders = append(offenders, a.Offenders...)
+			}
+			if !reflect.DeepEqual(kinds, tt.alerts) {
+				t.Errorf("alerts %v, want %v", kinds, tt.alerts)
+			}
+			if !reflect.DeepEqual(offenders, tt.offenders) {
+				t.Errorf("offenders %v, want %v", offenders, tt.offenders)
+			}
+		})
+	}
+}
+
+func TestReplayTLSCert(t *testing.T) {
+	cert := expiredCert(t, "old.example.com")
+	script := &ReplayScript{Start: replayStart, Buckets: []ReplayBucket{{Packets: []ReplayPacket{
+		{Offset: 1, Src: "192.168.1.10", Dst: "203.0.113.5", Transport: "tcp", SrcPort: 51000, DstPort: 443, SYN: true, Length: 60},
+		{Offset: 1.1, Src: "203.0.113.5", Dst: "192.168.1.10", Transport: "tcp", SrcPort: 443, DstPort: 51000, ACK: true, Length: 1400, TLSCerts: [][]byte{cert}},
+		// The same certificate from a second server isn't alerted again
+		{Offset: 5, Src: "203.0.113.6", Dst: "192.168.1.10", Transport: "tcp", SrcPort: 443, DstPort: 51001, ACK: true, Length: 1400, TLSCerts: [][]byte{cert}},
+	}}}}
+	r := runReplay(t, script, nil, nil)
+	checkReplayBuckets(t, r, []int{3}, []float64{0})
+	if len(r.Alerts) != 1 || r.Alerts[0].Kind != "tls-cert" {
+		t.Fatalf("alerts %+v, want one tls-cert", r.Alerts)
+	}
+	if want := "TLS certificate for old.example.com on 203.0.113.5:443: expired 2024-02-29, self-signed"; r.Alerts[0].Message != want {
+		t.Errorf("message %q, want %q", r.Alerts[0].Message, want)
+	}
+}
+
+func TestReplayRecords(t *testing.T) {
+	script := &ReplayScript{Start: replayStart, Buckets: []ReplayBucket{{Records: []string{"1\t1709294400.5"}}}}
+	if _, err := script.Run(newMonitoringData(replayStart, nil, 30)); err == nil {
+		t.Fatal("short record replayed")
+	}
+}
+
+func TestCloseBucketsThresholds(t *testing.T) {
+	data := newMonitoringData(replayStart, nil, 30)
+	rule, err := parseThresholdRule("packets > 100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.thresholds, err = NewThresholdEvaluator([]ThresholdRule{rule}, data.bucket); err != nil {
+		t.Fatal(err)
+	}
+	// 60 packets in the last half minute are 120 for the minute
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	data.buckets.Add(60, 6000)
+	end := replayStart.Add(30 * time.Second)
+	data.closeBuckets(end)
+	r := buildReport(data, end)
+	if len(r.Alerts) != 1 || r.Alerts[0].Kind != "threshold" {
+		t.Fatalf("alerts %+v, want one threshold", r.Alerts)
+	}
+}
+
+// Replaying script and building the report as runReplayCommand does
+func runReplay(t *testing.T, script *ReplayScript, routers []string, policy *SegmentationPolicy) *Report {
+	t.Helper()
+	data := newMonitoringData(script.Start, routers, 30)
+	data.quietPackets = true
+	if policy != nil {
+		var err error
+		if data.segmentation, err = NewSegmentationAuditor(policy); err != nil {
+			t.Fatal(err)
+		}
+	}
+	end, err := script.Run(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := script.Start.Add(time.Duration(len(script.Buckets)) * time.Minute); !end.Equal(want) {
+		t.Errorf("end %v, want %v", end, want)
+	}
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	data.closeBuckets(end)
+	return buildReport(data, end)
+}
+
+func checkReplayBuckets(t *testing.T, r *Report, packets []int, bytes []float64) {
+	t.Helper()
+	if len(r.Buckets) != len(packets) {
+		t.Fatalf("%d buckets, want %d", len(r.Buckets), len(packets))
+	}
+	for i, b := range r.Buckets {
+		if start := replayStart.Add(time.Duration(i) * time.Minute); !b.Start.Equal(start) || b.Seconds != 60 {
+			t.Errorf("bucket %d: %v for %vs, want %v for 60s", i, b.Start, b.Seconds, start)
+		}
+		if b.Packets != packets[i] || b.Bytes != bytes[i] {
+			t.Errorf("bucket %d: %d packets %v bytes, want %d and %v", i, b.Packets, b.Bytes, packets[i], bytes[i])
+		}
+	}
+}
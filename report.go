@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report format version. Schema 1 reports, from before versions and features
+// were recorded, have neither field.
+const reportSchema = 2
+
+// Optional parts of the report this version writes. Readers check for a
+// feature before relying on it, so older and newer hosts can be mixed.
+var reportFeatures = []string{"per_second", "bytes_sent_received", "paused_seconds", "bucket_interval", "alerts", "protocols", "hosts", "interfaces", "encrypted_dns", "file_services", "link_health", "call_quality", "sip_calls", "gaming", "p2p", "cloud_destinations", "services", "bytes_source", "updates", "shaping", "ports", "dns"}
+
+// Report is the machine readable form of the monitoring report
+type Report struct {
+	Schema           int                    `json:"schema"`
+	Version          string                 `json:"version,omitempty"`
+	Features         []string               `json:"features,omitempty"`
+	Host             string                 `json:"host"`
+	Interface        string                 `json:"interface"`
+	Filter           string                 `json:"filter,omitempty"`
+	Labels           Labels                 `json:"labels,omitempty"`
+	Start            time.Time              `json:"start"`
+	End              time.Time              `json:"end"`
+	DurationSeconds  float64                `json:"duration_seconds"`
+	RequestedSeconds float64                `json:"requested_seconds,omitempty"`
+	BucketSeconds    int                    `json:"bucket_seconds"`
+	Buckets          []ReportBucket         `json:"buckets"`
+	PacketsPerSecond []int                  `json:"packets_per_second,omitempty"`
+	TotalPackets     int                    `json:"total_packets"`
+	TotalBytes       float64                `json:"total_bytes"`
+	BytesSource      string                 `json:"bytes_source,omitempty"` // capture when estimated from frame lengths
+	Percentile95     float64                `json:"percentile_95_bps,omitempty"`
+	Alerts           []Alert                `json:"alerts,omitempty"`
+	Protocols        []ReportProtocol       `json:"protocols,omitempty"`
+	Ports            []PortUsage            `json:"ports,omitempty"`
+	Twamp            *ReportTwamp           `json:"twamp,omitempty"`
+	Amplification    []AmplificationFinding `json:"amplification,omitempty"`
+	RateLimits       []RateLimitAdvice      `json:"rate_limits,omitempty"`
+	Blocks           []Block                `json:"blocks,omitempty"`
+	Shaping          []ShapePeriod          `json:"shaping,omitempty"`
+	LastWeek         *ReportLastWeek        `json:"last_week,omitempty"`
+	Hosts            []Host                 `json:"hosts,omitempty"`      // seen in the report period, with its packets and bytes
+	Interfaces       []ReportInterface      `json:"interfaces,omitempty"` // with several -i, the aggregate is Buckets
+	EncryptedDNS     []EncryptedDNSUsage    `json:"encrypted_dns,omitempty"`
+	FileServices     []FileServiceUsage     `json:"file_services,omitempty"`
+	LinkHealth       *LinkHealth            `json:"link_health,omitempty"`
+	Calls            []CallQuality          `json:"calls,omitempty"`
+	SIPCalls         []SIPCall              `json:"sip_calls,omitempty"`
+	Games            []GameFlow             `json:"games,omitempty"`
+	GameBuckets      []GameBucket           `json:"game_buckets,omitempty"`
+	P2P              []P2PHost              `json:"p2p,omitempty"`
+	Cloud            []CloudDestination     `json:"cloud_destinations,omitempty"`
+	Services         []ServiceUsage         `json:"services,omitempty"`
+	Updates          []UpdateSource         `json:"updates,omitempty"`
+	DNS              *DNSSummary            `json:"dns,omitempty"`
+	UpdateBuckets    []UpdateBucket         `json:"update_buckets,omitempty"`
+	Flows            []FlowRecord           `json:"-"` // only stored with -db, too many for the report
+}
+
+// ReportBucket is one bucket of the packet and bandwidth series
+type ReportBucket struct {
+	Start         time.Time      `json:"start"`
+	Seconds       float64        `json:"seconds"`
+	Packets       int            `json:"packets"`
+	Bytes         float64        `json:"bytes"`
+	BytesSent     float64        `json:"bytes_sent"`
+	BytesReceived float64        `json:"bytes_received"`
+	PausedSeconds float64        `json:"paused_seconds,omitempty"`
+	Protocols     map[string]int `json:"protocols,omitempty"`
+}
+
+// Counting a packet in the per-second timeline used to line up hosts. Callers hold data.mu.
+func (data *MonitoringData) countSecond(at time.Time) {
+	sec := int(at.Sub(data.startTime) / time.Second)
+	if sec < 0 || sec > 7*24*3600 {
+		return
+	}
+	for len(data.perSecond) <= sec {
+		data.perSecond = append(data.perSecond, 0)
+	}
+	data.perSecond[sec]++
+}
+
+// Building the report from closed buckets. Call after generateReport or closeBuckets, with data.mu held.
+func buildReport(data *MonitoringData, end time.Time) *Report {
+	r := &Report{
+		Schema:           reportSchema,
+		Version:          version,
+		Features:         reportFeatures,
+		Interface:        data.captureInterface,
+		Filter:           data.captureFilter,
+		Labels:           data.labels,
+		Start:            data.startTime,
+		End:              end,
+		DurationSeconds:  end.Sub(data.startTime).Seconds(),
+		RequestedSeconds: time.Duration(data.requestedDuration).Seconds(),
+		BucketSeconds:    int(data.bucket.Seconds()),
+		PacketsPerSecond: data.perSecond,
+		Alerts:           data.alerts,
+		Protocols:        data.protocols.Rows(),
+		Ports:            data.ports.Top(),
+		Twamp:            data.twamp.summary(),
+		Amplification:    data.amplification.findings(),
+		RateLimits:       data.rates.Advice(runtime.GOOS, data.primaryInterface()),
+		Blocks:           data.blocker.blocks(),
+		Shaping:          data.shaper.periods(),
+		Hosts:            data.inventory.PeriodHosts(),
+		Interfaces:       reportInterfaces(data),
+		EncryptedDNS:     data.encryptedDNS.Usage(),
+		FileServices:     data.fileServices.Usage(),
+		LinkHealth:       data.linkHealth.Health(),
+		Calls:            data.callQuality.Calls(),
+		Games:            data.games.Flows(),
+		GameBuckets:      data.games.Buckets(),
+		P2P:              data.p2p.Hosts(),
+		Cloud:            data.cloudRanges.Destinations(),
+		Services:         data.services.Usage(),
+		Updates:          data.updates.Sources(),
+		DNS:              data.dns.Summary(10),
+		Flows:            data.flows.Records(),
+		UpdateBuckets:    data.updates.Buckets(),
+	}
+	r.SIPCalls = data.sipCalls.Calls(r.Calls)
+	r.Host, _ = os.Hostname()
+	if data.captureBandwidth {
+		r.BytesSource = "capture"
+	}
+
+	r.Buckets = reportBuckets(data, end)
+	r.LastWeek = data.history.compare(r.Buckets)
+	for _, b := range r.Buckets {
+		r.TotalPackets += b.Packets
+		r.TotalBytes += b.Bytes
+	}
+	if p95, n := percentile95(r.Buckets); n > 0 {
+		r.Percentile95 = p95
+	}
+	return r
+}
+
+// Closed buckets with their start and length. Callers hold data.mu.
+func reportBuckets(data *MonitoringData, end time.Time) []ReportBucket {
+	var buckets []ReportBucket
+	for i, closed := range data.buckets.Closed {
+		b := ReportBucket{Start: closed.Start, Seconds: closed.Seconds, Packets: closed.Packets}
+		if i < len(data.bandwidthBuckets) {
+			b.Bytes = data.bandwidthBuckets[i]
+		}
+		if i < len(data.sentBuckets) && i < len(data.recvBuckets) {
+			b.BytesSent, b.BytesReceived = data.sentBuckets[i], data.recvBuckets[i]
+		}
+		if i < len(data.pausedBuckets) {
+			b.PausedSeconds = data.pausedBuckets[i].Seconds()
+		}
+		b.Protocols = data.protocols.bucketCounts(i)
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+// Printing the report as JSON or CSV instead of the text tables, for -o json and -o csv
+func printReportAs(format string, w io.Writer, data *MonitoringData, end time.Time) error {
+	data.mu.Lock()
+	data.closeBuckets(end)
+	r := buildReport(data, end)
+	data.mu.Unlock()
+	return writeReportAs(format, w, r)
+}
+
+func writeReportAs(format string, w io.Writer, r *Report) error {
+	if format == "csv" {
+		return writeBucketCSV(w, r.Buckets)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// One row per bucket, sent and received stay 0 without -b
+func writeBucketCSV(out io.Writer, buckets []ReportBucket) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"timestamp", "seconds", "packets", "bytes_sent", "bytes_received", "bytes", "paused_seconds"})
+	for _, b := range buckets {
+		w.Write([]string{
+			b.Start.Format(time.RFC3339),
+			strconv.FormatFloat(b.Seconds, 'f', -1, 64),
+			strconv.Itoa(b.Packets),
+			strconv.FormatFloat(b.BytesSent, 'f', 0, 64),
+			strconv.FormatFloat(b.BytesReceived, 'f', 0, 64),
+			strconv.FormatFloat(b.Bytes, 'f', 0, 64),
+			strconv.FormatFloat(b.PausedSeconds, 'f', -1, 64),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func exportBucketCSV(path string, buckets []ReportBucket) error {
+	if err := diskGuardrail.Check(path); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	defer f.Close()
+	return writeBucketCSV(f, buckets)
+}
+
+func writeReport(path string, r *Report) error {
+	if err := writeJSON(path, r); err != nil {
+		return fmt.Errorf("failed to write report: %v", err)
+	}
+	return nil
+}
+
+func loadReport(path string) (*Report, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	var r Report
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if r.BucketSeconds <= 0 {
+		return nil, fmt.Errorf("%s: missing bucket_seconds", path)
+	}
+	if r.Schema == 0 {
+		// Written before features were listed: one minute buckets and a per-second series
+		r.Schema = 1
+		r.Features = []string{"per_second", "paused_seconds", "alerts"}
+	}
+	return &r, nil
+}
+
+func (r *Report) hasFeature(name string) bool {
+	for _, f := range r.Features {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Describing what a reader of this version should know about a report from
+// another version, empty when there is nothing to warn about
+func (r *Report) compatibility() []string {
+	var notes []string
+	if r.Schema > reportSchema {
+		notes = append(notes, fmt.Sprintf("written by a newer netwatchd (%s, schema %d), parts it added are ignored", r.Version, r.Schema))
+	}
+	for _, f := range r.Features {
+		if !slices.Contains(reportFeatures, f) {
+			notes = append(notes, "unknown feature "+f+" ignored")
+		}
+	}
+	if olderVersion(r.Version, version) {
+		notes = append(notes, fmt.Sprintf("written by netwatchd %s, this is %s, consider updating that host", r.Version, version))
+	} else if r.Schema < reportSchema && r.Version == "" {
+		notes = append(notes, "written by an outdated netwatchd, consider updating that host")
+	}
+	return notes
+}
+
+// Comparing dotted release versions, false when either is not one (e.g. dev builds)
+func olderVersion(a, b string) bool {
+	pa, ok1 := parseVersion(a)
+	pb, ok2 := parseVersion(b)
+	if !ok1 || !ok2 {
+		return false
+	}
+	for i := 0; i < 3; i++ {
+		if pa[i] != pb[i] {
+			return pa[i] < pb[i]
+		}
+	}
+	return false
+}
+
+func parseVersion(v string) ([3]int, bool) {
+	var out [3]int
+	v, _, _ = strings.Cut(strings.TrimPrefix(v, "v"), "-")
+	parts := strings.Split(v, ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return out, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return out, false
+		}
+		out[i] = n
+	}
+	return out, true
+}
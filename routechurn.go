@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	linux "netwatchd/netstat"
+)
+
+// Counting route adds and deletes into the current bucket
+func monitorRouteChurn(ctx context.Context, data *MonitoringData) {
+	err := linux.WatchRoutes(ctx, func(e linux.RouteEvent) {
+		data.mu.Lock()
+		defer data.mu.Unlock()
+		data.currentRouteChurn++
+		if e.Added {
+			data.routeAdds++
+		} else {
+			data.routeDels++
+		}
+	})
+	if err != nil {
+		logger.Warn("route monitoring unavailable", "err", err)
+	}
+}
+
+// Listing buckets with unusual route churn next to how traffic moved in them
+func printRouteChurnReport(adds, dels int, churn []int, packets []int, bandwidth []float64, bucket time.Duration) {
+	if adds+dels == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("ROUTE CHURN")
+	fmt.Printf("Routes added: %d | removed: %d\n", adds, dels)
+
+	// A spike is well above the typical bucket, and not just a handful of updates
+	sorted := append([]int(nil), churn...)
+	sort.Ints(sorted)
+	median := sorted[len(sorted)/2]
+	threshold := median * 3
+	if threshold < 10 {
+		threshold = 10
+	}
+
+	for i, c := range churn {
+		if c < threshold {
+			continue
+		}
+		line := fmt.Sprintf("%s: %d route updates", bucketLabel(i, bucket), c)
+		if i > 0 && i < len(packets) && packets[i-1] > 0 {
+			change := float64(packets[i]-packets[i-1]) / float64(packets[i-1]) * 100
+			line += fmt.Sprintf(", packets %+.0f%%", change)
+		}
+		if i > 0 && i < len(bandwidth) && bandwidth[i-1] > 0 {
+			change := (bandwidth[i] - bandwidth[i-1]) / bandwidth[i-1] * 100
+			line += fmt.Sprintf(", bandwidth %+.0f%%", change)
+		}
+		fmt.Println(line)
+	}
+}
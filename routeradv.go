@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// RouterAdvertiser is a host that sent ICMPv6 Router Advertisements
+type RouterAdvertiser struct {
+	IP        string
+	MAC       string
+	Prefixes  []string
+	Count     int
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Expected  bool
+}
+
+// RouterAdvertTracker watches for Router Advertisements from unexpected sources.
+// With an empty allowlist the first advertiser seen is taken as the real router.
+// It is guarded by the MonitoringData mutex.
+type RouterAdvertTracker struct {
+	allowed map[string]bool
+	routers map[string]*RouterAdvertiser
+}
+
+func NewRouterAdvertTracker(allowed []string) *RouterAdvertTracker {
+	t := &RouterAdvertTracker{
+		allowed: make(map[string]bool),
+		routers: make(map[string]*RouterAdvertiser),
+	}
+	for _, a := range allowed {
+		if a = strings.ToLower(strings.TrimSpace(a)); a != "" {
+			t.allowed[a] = true
+		}
+	}
+	return t
+}
+
+// Observe returns an alert message the first time an unexpected router is seen
+func (t *RouterAdvertTracker) Observe(p *netwatch.Packet) string {
+	if !p.RouterAdvert {
+		return ""
+	}
+
+	r, ok := t.routers[p.SrcIP]
+	if ok {
+		r.Count++
+		r.LastSeen = p.Time
+		return ""
+	}
+
+	r = &RouterAdvertiser{
+		IP:        p.SrcIP,
+		MAC:       p.SrcMAC,
+		Prefixes:  p.RAPrefixes,
+		Count:     1,
+		FirstSeen: p.Time,
+		LastSeen:  p.Time,
+	}
+	if len(t.allowed) > 0 {
+		r.Expected = t.allowed[strings.ToLower(p.SrcIP)] || t.allowed[strings.ToLower(p.SrcMAC)]
+	} else {
+		r.Expected = len(t.routers) == 0
+	}
+	t.routers[p.SrcIP] = r
+
+	if r.Expected {
+		return ""
+	}
+	msg := fmt.Sprintf("unexpected IPv6 router advertisement from %s (%s)", r.IP, r.MAC)
+	if len(r.Prefixes) > 0 {
+		msg += " announcing " + strings.Join(r.Prefixes, ", ")
+	}
+	return msg
+}
+
+func (t *RouterAdvertTracker) Routers() []*RouterAdvertiser {
+	routers := make([]*RouterAdvertiser, 0, len(t.routers))
+	for _, r := range t.routers {
+		routers = append(routers, r)
+	}
+	sort.Slice(routers, func(i, j int) bool {
+		return routers[i].FirstSeen.Before(routers[j].FirstSeen)
+	})
+	return routers
+}
+
+func printRouterAdvertReport(t *RouterAdvertTracker) {
+	routers := t.Routers()
+	if len(routers) == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("IPv6 ROUTER ADVERTISEMENTS")
+	for _, r := range routers {
+		status := "expected"
+		if !r.Expected {
+			status = "UNEXPECTED"
+		}
+		fmt.Printf("%s (%s): %d advertisements, %s", r.IP, r.MAC, r.Count, status)
+		if len(r.Prefixes) > 0 {
+			fmt.Printf(", prefixes %s", strings.Join(r.Prefixes, ", "))
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Alert severities, lowest first
+var severities = []string{"info", "warning", "critical"}
+
+// Severity of the built in alert kinds, anything else is a warning
+var kindSeverity = map[string]string{
+	"outage":       "critical",
+	"wan-failover": "critical",
+	"segmentation": "critical",
+	"rogue-ra":     "critical",
+	"stp-root":     "critical",
+	"cost":         "info",
+	"update-storm": "info",
+}
+
+func severityFor(kind string) string {
+	if s, ok := kindSeverity[kind]; ok {
+		return s
+	}
+	return "warning"
+}
+
+func severityRank(s string) int {
+	for i, v := range severities {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+const defaultAlertTemplate = "[{{.Severity}}] {{.Host}} {{.Kind}}: {{.Message}}"
+
+// SinkConfig is a destination alerts can be routed to
+type SinkConfig struct {
+	Name         string            `json:"name"`
+	Type         string            `json:"type"` // slack, webhook, email, syslog, pagerduty, opsgenie or snmptrap
+	URL          string            `json:"url,omitempty"`
+	Key          string            `json:"key,omitempty"`     // PagerDuty routing key or Opsgenie API key
+	Address      string            `json:"address,omitempty"` // SMTP server, syslog or trap receiver host:port
+	From         string            `json:"from,omitempty"`
+	To           []string          `json:"to,omitempty"`
+	Username     string            `json:"username,omitempty"`
+	Password     string            `json:"password,omitempty"`
+	Template     string            `json:"template,omitempty"`
+	SeverityMap  map[string]string `json:"severity_map,omitempty"` // netwatchd severity to PagerDuty severity or Opsgenie priority
+	SNMPVersion  string            `json:"snmp_version,omitempty"` // 2c (default) or 3, Username and Password are the v3 user
+	Community    string            `json:"community,omitempty"`
+	AuthProtocol string            `json:"auth_protocol,omitempty"` // MD5, SHA or SHA256
+	PrivProtocol string            `json:"priv_protocol,omitempty"` // AES
+	PrivPassword string            `json:"priv_password,omitempty"`
+	EngineID     string            `json:"engine_id,omitempty"`
+	BootsFile    string            `json:"boots_file,omitempty"` // keeps SNMPv3 engine boots across restarts
+	OID          string            `json:"oid,omitempty"`        // trap MIB root, see NETWATCHD-MIB.txt
+}
+
+// Route sends the alerts it matches to a set of sinks. Empty match fields match everything.
+type Route struct {
+	Name     string   `json:"name,omitempty"`
+	Kinds    []string `json:"kinds,omitempty"`
+	Severity string   `json:"severity,omitempty"` // minimum severity
+	Labels   Labels   `json:"labels,omitempty"`
+	Sinks    []string `json:"sinks"`
+	Template string   `json:"template,omitempty"`
+	Stop     bool     `json:"stop,omitempty"` // skip the routes after this one when it matches
+}
+
+// AlertEvent is what templates and sinks see for one alert
+type AlertEvent struct {
+	Alert
+	Host   string `json:"host"`
+	Labels Labels `json:"labels,omitempty"`
+}
+
+// AlertSink delivers a rendered alert
+type AlertSink interface {
+	Send(ev AlertEvent, text string) error
+}
+
+var sinkClient = &http.Client{Timeout: 10 * time.Second}
+
+func (c SinkConfig) validate() error {
+	if c.Name == "" {
+		return errors.New("name is required")
+	}
+	switch c.Type {
+	case "slack", "webhook":
+		if c.URL == "" {
+			return errors.New("url is required")
+		}
+	case "email":
+		if c.Address == "" || c.From == "" || len(c.To) == 0 {
+			return errors.New("address, from and to are required")
+		}
+	case "pagerduty", "opsgenie":
+		if c.Key == "" {
+			return errors.New("key is required")
+		}
+	case "snmptrap":
+		if err := c.validateTrap(); err != nil {
+			return err
+		}
+	case "syslog":
+	default:
+		return fmt.Errorf("unknown sink type %q", c.Type)
+	}
+	if c.Template != "" {
+		if _, err := template.New(c.Name).Parse(c.Template); err != nil {
+			return fmt.Errorf("template: %v", err)
+		}
+	}
+	return nil
+}
+
+func (r Route) validate(sinks []SinkConfig) error {
+	if len(r.Sinks) == 0 {
+		return errors.New("at least one sink is required")
+	}
+	for _, name := range r.Sinks {
+		found := false
+		for _, s := range sinks {
+			found = found || s.Name == name
+		}
+		if !found {
+			return fmt.Errorf("unknown sink %q", name)
+		}
+	}
+	if r.Severity != "" && severityRank(r.Severity) < 0 {
+		return fmt.Errorf("severity must be one of %s", strings.Join(severities, ", "))
+	}
+	if r.Template != "" {
+		if _, err := template.New(r.Name).Parse(r.Template); err != nil {
+			return fmt.Errorf("template: %v", err)
+		}
+	}
+	return nil
+}
+
+func (r Route) matches(ev AlertEvent) bool {
+	if r.Severity != "" && severityRank(ev.Severity) < severityRank(r.Severity) {
+		return false
+	}
+	if len(r.Kinds) > 0 && !matchesAlert(r.Kinds, "", ev.Alert) {
+		return false
+	}
+	for k, v := range r.Labels {
+		if ev.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func newSink(c SinkConfig) AlertSink {
+	switch c.Type {
+	case "slack":
+		return slackSink{url: c.URL}
+	case "webhook":
+		return webhookSink{url: c.URL}
+	case "email":
+		return emailSink{c}
+	case "pagerduty":
+		return pagerDutySink{c}
+	case "opsgenie":
+		return opsgenieSink{c}
+	case "snmptrap":
+		return newSNMPTrapSink(c)
+	default:
+		addr := c.Address
+		if addr == "" {
+			addr = "localhost:514"
+		}
+		return syslogSink{addr: addr}
+	}
+}
+
+type delivery struct {
+	sink string
+	ev   AlertEvent
+	text string
+}
+
+// AlertRouter renders alerts and hands them to a background sender,
+// so a slow sink never blocks packet handling
+type AlertRouter struct {
+	host      string
+	labels    Labels
+	sinks     map[string]AlertSink
+	templates map[string]*template.Template
+	routes    []Route
+	queue     chan delivery
+	done      chan struct{}
+}
+
+// Without routes every alert goes to every sink
+func NewAlertRouter(sinks []SinkConfig, routes []Route, labels Labels) (*AlertRouter, error) {
+	r := &AlertRouter{
+		labels:    labels,
+		sinks:     make(map[string]AlertSink),
+		templates: make(map[string]*template.Template),
+		routes:    routes,
+		queue:     make(chan delivery, 256),
+		done:      make(chan struct{}),
+	}
+	r.host, _ = os.Hostname()
+
+	var all []string
+	for _, s := range sinks {
+		if err := s.validate(); err != nil {
+			return nil, fmt.Errorf("sink %s: %v", s.Name, err)
+		}
+		r.sinks[s.Name] = newSink(s)
+		text := s.Template
+		if text == "" {
+			text = defaultAlertTemplate
+		}
+		r.templates["sink:"+s.Name] = template.Must(template.New(s.Name).Parse(text))
+		all = append(all, s.Name)
+	}
+	for i, rt := range routes {
+		if err := rt.validate(sinks); err != nil {
+			return nil, fmt.Errorf("route %d: %v", i, err)
+		}
+		if rt.Template != "" {
+			r.templates[fmt.Sprintf("route:%d", i)] = template.Must(template.New(rt.Name).Parse(rt.Template))
+		}
+	}
+	if len(routes) == 0 {
+		r.routes = []Route{{Name: "default", Sinks: all}}
+	}
+
+	go r.send()
+	return r, nil
+}
+
+// Routing an alert to every matching sink once. Resolutions are sent even
+// when silenced so incidents opened before a maintenance window still close.
+// Safe to call with data.mu held.
+func (r *AlertRouter) Notify(a Alert) {
+	if r == nil || (a.Silenced != "" && !a.Resolved) {
+		return
+	}
+	ev := AlertEvent{Alert: a, Host: r.host, Labels: r.labels}
+	sent := make(map[string]bool)
+	for i, rt := range r.routes {
+		if !rt.matches(ev) {
+			continue
+		}
+		for _, name := range rt.Sinks {
+			if sent[name] {
+				continue
+			}
+			sent[name] = true
+			// A route template wins over the sink's own
+			tmpl := r.templates[fmt.Sprintf("route:%d", i)]
+			if tmpl == nil {
+				tmpl = r.templates["sink:"+name]
+			}
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, ev); err != nil {
+				logger.Warn("alert template failed", "sink", name, "err", err)
+				continue
+			}
+			select {
+			case r.queue <- delivery{sink: name, ev: ev, text: buf.String()}:
+			default:
+				fmt.Printf("Alert queue full, dropping alert for %s\n", name)
+			}
+		}
+		if rt.Stop {
+			break
+		}
+	}
+}
+
+func (r *AlertRouter) send() {
+	defer close(r.done)
+	for d := range r.queue {
+		if err := r.sinks[d.sink].Send(d.ev, d.text); err != nil {
+			logger.Error("failed to send alert", "sink", d.sink, "err", err)
+		}
+	}
+}
+
+// Close waits up to timeout for queued alerts to be delivered
+func (r *AlertRouter) Close(timeout time.Duration) {
+	if r == nil {
+		return
+	}
+	close(r.queue)
+	select {
+	case <-r.done:
+	case <-time.After(timeout):
+		fmt.Println("Timed out delivering queued alerts")
+	}
+}
+
+func postJSON(url string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := sinkClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+type slackSink struct{ url string }
+
+func (s slackSink) Send(ev AlertEvent, text string) error {
+	return postJSON(s.url, map[string]string{"text": text})
+}
+
+type webhookSink struct{ url string }
+
+func (s webhookSink) Send(ev AlertEvent, text string) error {
+	return postJSON(s.url, struct {
+		AlertEvent
+		Text string `json:"text"`
+	}{ev, text})
+}
+
+type emailSink struct{ c SinkConfig }
+
+func (s emailSink) Send(ev AlertEvent, text string) error {
+	subject := fmt.Sprintf("netwatchd %s alert on %s: %s", ev.Severity, ev.Host, ev.Kind)
+	return sendMail(s.c, subject, "", text, ev.Time)
+}
+
+// Mailing body through the SMTP server of an email sink, contentType is empty
+// for plain text
+func sendMail(c SinkConfig, subject, contentType, body string, date time.Time) error {
+	headers := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n",
+		c.From, strings.Join(c.To, ", "), subject, date.Format(time.RFC1123Z))
+	if contentType != "" {
+		headers += "MIME-Version: 1.0\r\nContent-Type: " + contentType + "\r\n"
+	}
+	msg := headers + "\r\n" + body + "\r\n"
+	var auth smtp.Auth
+	if c.Username != "" {
+		host, _, _ := net.SplitHostPort(c.Address)
+		auth = smtp.PlainAuth("", c.Username, c.Password, host)
+	}
+	return smtp.SendMail(c.Address, auth, c.From, c.To, []byte(msg))
+}
+
+// syslogSink sends RFC 5424 messages over UDP with the daemon facility
+type syslogSink struct{ addr string }
+
+func (s syslogSink) Send(ev AlertEvent, text string) error {
+	level := map[string]int{"critical": 2, "warning": 4, "info": 6}[ev.Severity]
+	if level == 0 {
+		level = 4
+	}
+	conn, err := net.DialTimeout("udp", s.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "<%d>1 %s %s netwatchd - %s - %s", 3*8+level, ev.Time.Format(time.RFC3339), ev.Host, ev.Kind, text)
+	return err
+}
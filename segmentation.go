@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// SegmentationPolicy declares which zone to zone flows are allowed
+type SegmentationPolicy struct {
+	Zones []string      `json:"zones,omitempty"`
+	Allow []SegmentRule `json:"allow"`
+}
+
+// SegmentRule allows flows opened from one CIDR to another, optionally
+// limited to ports like "443/tcp" or "53" (any transport)
+type SegmentRule struct {
+	Name  string   `json:"name,omitempty"`
+	From  string   `json:"from"`
+	To    string   `json:"to"`
+	Ports []string `json:"ports,omitempty"`
+}
+
+type compiledRule struct {
+	from, to *net.IPNet
+	ports    map[string]bool
+}
+
+// Violation is a flow between audited zones that no rule allows
+type Violation struct {
+	Src       string
+	Dst       string
+	Port      int
+	Transport string
+	Flows     int
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// SegmentationAuditor checks newly opened flows against the policy.
+// It is guarded by the MonitoringData mutex.
+type SegmentationAuditor struct {
+	zones      []*net.IPNet
+	rules      []compiledRule
+	flows      map[string]bool
+	checked    int
+	violations map[string]*Violation
+}
+
+func (p *SegmentationPolicy) validate() error {
+	for i, z := range p.Zones {
+		if _, _, err := net.ParseCIDR(z); err != nil {
+			return fmt.Errorf("zones[%d]: %v", i, err)
+		}
+	}
+	for i, r := range p.Allow {
+		if _, _, err := net.ParseCIDR(r.From); err != nil {
+			return fmt.Errorf("allow[%d].from: %v", i, err)
+		}
+		if _, _, err := net.ParseCIDR(r.To); err != nil {
+			return fmt.Errorf("allow[%d].to: %v", i, err)
+		}
+		for _, port := range r.Ports {
+			num, proto, _ := strings.Cut(port, "/")
+			if n, err := strconv.Atoi(num); err != nil || n < 1 || n > 65535 {
+				return fmt.Errorf("allow[%d].ports: %q is not a port", i, port)
+			}
+			if proto != "" && proto != "tcp" && proto != "udp" {
+				return fmt.Errorf("allow[%d].ports: %q must be tcp or udp", i, port)
+			}
+		}
+	}
+	return nil
+}
+
+func NewSegmentationAuditor(p *SegmentationPolicy) (*SegmentationAuditor, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+
+	a := &SegmentationAuditor{
+		flows:      make(map[string]bool),
+		violations: make(map[string]*Violation),
+	}
+	zones := p.Zones
+	for _, r := range p.Allow {
+		_, from, _ := net.ParseCIDR(r.From)
+		_, to, _ := net.ParseCIDR(r.To)
+		rule := compiledRule{from: from, to: to, ports: make(map[string]bool)}
+		for _, port := range r.Ports {
+			rule.ports[port] = true
+		}
+		a.rules = append(a.rules, rule)
+		// Without explicit zones, every CIDR named in a rule is audited
+		if len(p.Zones) == 0 {
+			zones = append(zones, r.From, r.To)
+		}
+	}
+	for _, z := range zones {
+		_, n, _ := net.ParseCIDR(z)
+		a.zones = append(a.zones, n)
+	}
+	return a, nil
+}
+
+func (a *SegmentationAuditor) inZone(ip net.IP) bool {
+	for _, z := range a.zones {
+		if z.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r compiledRule) allows(src, dst net.IP, port int, transport string) bool {
+	if !r.from.Contains(src) || !r.to.Contains(dst) {
+		return false
+	}
+	if len(r.ports) == 0 {
+		return true
+	}
+	return r.ports[strconv.Itoa(port)] || r.ports[fmt.Sprintf("%d/%s", port, transport)]
+}
+
+// Observe returns an alert message the first time a violating path is seen
+func (a *SegmentationAuditor) Observe(p *netwatch.Packet) string {
+	if a == nil || p.Transport == "" {
+		return ""
+	}
+
+	// Only the packet opening a flow tells which side initiated it
+	if p.Transport == "tcp" && (!p.SYN || p.ACK) {
+		return ""
+	}
+	if p.Transport == "udp" {
+		key := flowKey(p)
+		if a.flows[key] {
+			return ""
+		}
+		a.flows[key] = true
+	}
+
+	src, dst := net.ParseIP(p.SrcIP), net.ParseIP(p.DstIP)
+	if src == nil || dst == nil || !a.inZone(src) || !a.inZone(dst) {
+		return ""
+	}
+	a.checked++
+	for _, r := range a.rules {
+		if r.allows(src, dst, p.DstPort, p.Transport) {
+			return ""
+		}
+	}
+
+	key := fmt.Sprintf("%s %s %d/%s", p.SrcIP, p.DstIP, p.DstPort, p.Transport)
+	v, ok := a.violations[key]
+	if ok {
+		v.Flows++
+		v.LastSeen = p.Time
+		return ""
+	}
+	a.violations[key] = &Violation{p.SrcIP, p.DstIP, p.DstPort, p.Transport, 1, p.Time, p.Time}
+	return fmt.Sprintf("segmentation violation: %s -> %s:%d/%s not allowed by policy", p.SrcIP, p.DstIP, p.DstPort, p.Transport)
+}
+
+// Starting a new period, violating paths are alerted again in it
+func (a *SegmentationAuditor) reset() {
+	if a == nil {
+		return
+	}
+	a.flows = make(map[string]bool)
+	a.violations = make(map[string]*Violation)
+	a.checked = 0
+}
+
+func (a *SegmentationAuditor) Violations() []*Violation {
+	var vs []*Violation
+	for _, v := range a.violations {
+		vs = append(vs, v)
+	}
+	sort.Slice(vs, func(i, j int) bool {
+		return vs[i].FirstSeen.Before(vs[j].FirstSeen)
+	})
+	return vs
+}
+
+func printSegmentationReport(a *SegmentationAuditor) {
+	if a == nil {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("SEGMENTATION AUDIT")
+	vs := a.Violations()
+	fmt.Printf("Rules: %d | flows checked: %d | violating paths: %d\n", len(a.rules), a.checked, len(vs))
+	for _, v := range vs {
+		fmt.Printf("VIOLATION %s -> %s:%d/%s: %d flows (first %s, last %s)\n",
+			v.Src, v.Dst, v.Port, v.Transport, v.Flows, v.FirstSeen.Format("15:04:05"), v.LastSeen.Format("15:04:05"))
+	}
+}
+
+// Writing violations as CSV evidence
+func exportViolations(path string, vs []*Violation) error {
+	if err := diskGuardrail.Check(path); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"src", "dst", "port", "transport", "flows", "first_seen", "last_seen"})
+	for _, v := range vs {
+		w.Write([]string{v.Src, v.Dst, strconv.Itoa(v.Port), v.Transport, strconv.Itoa(v.Flows),
+			v.FirstSeen.Format(time.RFC3339), v.LastSeen.Format(time.RFC3339)})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Set at release build time:
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.updatePublicKey=<base64 ed25519 key>"
+var (
+	version         = "dev"
+	updatePublicKey = ""
+)
+
+const (
+	defaultUpdateRepo = "PrabeshMarasini/netwatchd"
+	defaultUpdateAPI  = "https://api.github.com"
+	// Release assets besides the binaries: the manifest and its ed25519
+	// signature. The manifest is a "netwatchd <version>" line followed by
+	// sha256sum output for the binaries, so the signature also vouches for
+	// the version and an older release can't be passed off as the latest.
+	manifestAsset  = "MANIFEST"
+	signatureAsset = "MANIFEST.sig"
+)
+
+var updateClient = &http.Client{Timeout: 5 * time.Minute}
+
+type release struct {
+	Tag    string `json:"tag_name"`
+	Assets []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (r *release) asset(name string) string {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL
+		}
+	}
+	return ""
+}
+
+// Binary asset name for this platform, e.g. netwatchd_linux_arm64
+func releaseAssetName() string {
+	name := "netwatchd_" + runtime.GOOS + "_" + runtime.GOARCH
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+func fetch(url string) ([]byte, error) {
+	resp, err := updateClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func fetchRelease(api, repo, tag string) (*release, error) {
+	url := api + "/repos/" + repo + "/releases/latest"
+	if tag != "" {
+		url = api + "/repos/" + repo + "/releases/tags/" + tag
+	}
+	b, err := fetch(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check releases: %v", err)
+	}
+	var r release
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse release: %v", err)
+	}
+	return &r, nil
+}
+
+// Accepting the signature raw or base64 encoded
+func decodeSignature(b []byte) []byte {
+	if len(b) == ed25519.SignatureSize {
+		return b
+	}
+	if sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b))); err == nil {
+		return sig
+	}
+	return b
+}
+
+// The version a manifest is for and its checksum lines
+func parseManifest(manifest []byte) (string, []byte, error) {
+	first, sums, _ := bytes.Cut(manifest, []byte("\n"))
+	fields := strings.Fields(string(first))
+	if len(fields) != 2 || fields[0] != "netwatchd" {
+		return "", nil, fmt.Errorf("%s does not start with the netwatchd version", manifestAsset)
+	}
+	return strings.TrimPrefix(fields[1], "v"), sums, nil
+}
+
+// Whether release v is newer than the running version. Builds without a
+// release version, e.g. dev, take any release.
+func newerRelease(v string) bool {
+	if _, ok := parseVersion(version); !ok {
+		return true
+	}
+	return olderVersion(version, v)
+}
+
+// Looking up a file in sha256sum output
+func checksumFor(sums []byte, name string) ([]byte, error) {
+	sc := bufio.NewScanner(bytes.NewReader(sums))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return hex.DecodeString(fields[0])
+		}
+	}
+	return nil, fmt.Errorf("%s is not listed in %s", name, manifestAsset)
+}
+
+// Downloading next to the executable so the final rename stays on one file system
+func downloadVerified(url, dir string, want []byte) (string, error) {
+	resp, err := updateClient.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	f, err := os.CreateTemp(dir, ".netwatchd-update-*")
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !bytes.Equal(h.Sum(nil), want) {
+		err = errors.New("checksum mismatch")
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0755)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// Replacing the executable. Windows can't overwrite a running binary, but can rename it.
+func swapExecutable(exe, next string) error {
+	if runtime.GOOS != "windows" {
+		return os.Rename(next, exe)
+	}
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(next, exe); err != nil {
+		os.Rename(old, exe)
+		return err
+	}
+	return nil
+}
+
+// netwatchd self-update [-check] [-version tag] [-force] [-repo owner/name] [-api url] [-key base64]
+func runSelfUpdateCommand(args []string) int {
+	fs := flag.NewFlagSet("self-update", flag.ExitOnError)
+	checkFlag := fs.Bool("check", false, "Only report whether a newer release exists")
+	tagFlag := fs.String("version", "", "Install this release tag instead of the latest")
+	forceFlag := fs.Bool("force", false, "Install even when the release is not newer than the running version")
+	repoFlag := fs.String("repo", defaultUpdateRepo, "GitHub repository releases are published in")
+	apiFlag := fs.String("api", defaultUpdateAPI, "GitHub API base URL, for GitHub Enterprise or a mirror")
+	keyFlag := fs.String("key", updatePublicKey, "Base64 ed25519 public key the release manifest is signed with")
+	fs.Parse(args)
+
+	key, err := base64.StdEncoding.DecodeString(*keyFlag)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		fmt.Println("No valid release signing key, this build can't verify updates (pass -key)")
+		return 1
+	}
+
+	rel, err := fetchRelease(strings.TrimSuffix(*apiFlag, "/"), *repoFlag, *tagFlag)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	latest := strings.TrimPrefix(rel.Tag, "v")
+	if !newerRelease(latest) && !*forceFlag {
+		if latest == strings.TrimPrefix(version, "v") || *tagFlag == "" {
+			fmt.Printf("netwatchd %s is up to date\n", version)
+			return 0
+		}
+		fmt.Printf("Release %s is not newer than the running %s, not installing (pass -force)\n", rel.Tag, version)
+		return 1
+	}
+	if *checkFlag {
+		fmt.Printf("netwatchd %s is available (running %s)\n", latest, version)
+		return 0
+	}
+
+	name := releaseAssetName()
+	binURL, manifestURL, sigURL := rel.asset(name), rel.asset(manifestAsset), rel.asset(signatureAsset)
+	if binURL == "" || manifestURL == "" || sigURL == "" {
+		fmt.Printf("Release %s has no %s with a signed manifest\n", rel.Tag, name)
+		return 1
+	}
+	manifest, err := fetch(manifestURL)
+	if err != nil {
+		fmt.Printf("Failed to download the manifest: %v\n", err)
+		return 1
+	}
+	sig, err := fetch(sigURL)
+	if err != nil {
+		fmt.Printf("Failed to download signature: %v\n", err)
+		return 1
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), manifest, decodeSignature(sig)) {
+		fmt.Printf("Signature of %s in release %s does not verify, not updating\n", manifestAsset, rel.Tag)
+		return 1
+	}
+	// The tag comes unsigned from the API and was only checked against the
+	// running version, the signed manifest has to agree with it
+	signed, sums, err := parseManifest(manifest)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if signed != latest {
+		fmt.Printf("Release %s carries the manifest of %s, not updating\n", rel.Tag, signed)
+		return 1
+	}
+	want, err := checksumFor(sums, name)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		fmt.Printf("Failed to locate the running binary: %v\n", err)
+		return 1
+	}
+	next, err := downloadVerified(binURL, filepath.Dir(exe), want)
+	if err != nil {
+		fmt.Printf("Failed to download %s: %v\n", name, err)
+		return 1
+	}
+	if err := swapExecutable(exe, next); err != nil {
+		os.Remove(next)
+		fmt.Printf("Failed to replace %s: %v\n", exe, err)
+		return 1
+	}
+	fmt.Printf("Updated %s from %s to %s\n", exe, version, latest)
+	return 0
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"netwatchd/netwatch"
+)
+
+// Well known CDNs and SaaS by the domains in their TLS server names and DNS
+// answers, and by the address ranges they publish or announce. The most
+// specific domain wins, so YouTube's googlevideo.com isn't counted as Google.
+var knownServices = []struct {
+	name    string
+	domains []string
+	ranges  []string
+}{
+	{"Netflix", []string{"netflix.com", "netflix.net", "nflxvideo.net", "nflximg.net", "nflximg.com", "nflxext.com", "nflxso.net"},
+		[]string{"23.246.0.0/18", "37.77.184.0/21", "45.57.0.0/17", "64.120.128.0/17", "66.197.128.0/17", "108.175.32.0/20",
+			"192.173.64.0/18", "198.38.96.0/19", "198.45.48.0/20", "208.75.76.0/22"}},
+	{"YouTube", []string{"youtube.com", "googlevideo.com", "ytimg.com", "youtu.be", "youtube-nocookie.com", "youtubei.googleapis.com"}, nil},
+	{"Microsoft 365", []string{"office.com", "office.net", "office365.com", "outlook.com", "sharepoint.com", "onedrive.com", "onenote.com",
+		"microsoftonline.com", "outlook.office365.com"},
+		[]string{"13.107.6.152/31", "13.107.18.10/31", "13.107.128.0/22", "23.103.160.0/20", "40.96.0.0/13", "40.104.0.0/15",
+			"52.96.0.0/14", "132.245.0.0/16", "150.171.32.0/22", "204.79.197.215/32", "13.107.136.0/22", "40.108.128.0/17", "52.104.0.0/14"}},
+	{"Microsoft Teams", []string{"teams.microsoft.com", "teams.live.com", "lync.com", "skype.com"}, []string{"52.112.0.0/14", "52.122.0.0/15"}},
+	{"Windows Update", []string{"windowsupdate.com", "update.microsoft.com", "delivery.mp.microsoft.com"}, nil},
+	{"Zoom", []string{"zoom.us", "zoom.com", "zoomgov.com"},
+		[]string{"69.174.57.0/24", "69.174.108.0/22", "134.224.0.0/16", "144.195.0.0/16", "147.124.96.0/19", "149.137.0.0/17",
+			"162.255.36.0/22", "170.114.0.0/16", "173.231.80.0/20", "198.251.128.0/17", "204.80.104.0/21", "206.247.0.0/16"}},
+	{"Steam", []string{"steampowered.com", "steamcommunity.com", "steamcontent.com", "steamstatic.com", "steamserver.net", "valvesoftware.com"},
+		[]string{"103.10.124.0/23", "103.28.54.0/23", "146.66.152.0/21", "155.133.224.0/19", "162.254.192.0/21", "185.25.180.0/22",
+			"192.69.96.0/22", "205.196.6.0/24", "208.64.200.0/22", "208.78.164.0/22"}},
+	{"Apple", []string{"apple.com", "icloud.com", "mzstatic.com", "aaplimg.com", "icloud-content.com", "cdn-apple.com"}, []string{"17.0.0.0/8"}},
+	{"Meta", []string{"facebook.com", "fbcdn.net", "instagram.com", "cdninstagram.com", "whatsapp.net", "whatsapp.com"},
+		[]string{"31.13.24.0/21", "31.13.64.0/18", "66.220.144.0/20", "69.63.176.0/20", "69.171.224.0/19", "129.134.0.0/16",
+			"157.240.0.0/16", "173.252.64.0/18", "179.60.192.0/22", "185.60.216.0/22"}},
+	{"TikTok", []string{"tiktok.com", "tiktokcdn.com", "tiktokv.com", "byteoversea.com", "ibytedtos.com"}, nil},
+	{"Spotify", []string{"spotify.com", "scdn.co", "spotifycdn.com"}, nil},
+	{"Twitch", []string{"twitch.tv", "ttvnw.net", "jtvnw.net"}, nil},
+	{"Disney+", []string{"disneyplus.com", "dssott.com", "bamgrid.com"}, nil},
+	{"Dropbox", []string{"dropbox.com", "dropboxusercontent.com"}, nil},
+	{"Google", []string{"google.com", "gstatic.com", "googleapis.com", "googleusercontent.com", "gmail.com", "gvt1.com"}, nil},
+	{"Akamai", []string{"akamaiedge.net", "akamaized.net", "akamaihd.net", "akamai.net", "edgekey.net", "edgesuite.net"}, nil},
+	{"Amazon CloudFront", []string{"cloudfront.net"}, nil},
+	{"Cloudflare", nil, []string{"103.21.244.0/22", "103.22.200.0/22", "103.31.4.0/22", "104.16.0.0/13", "104.24.0.0/14",
+		"108.162.192.0/18", "131.0.72.0/22", "141.101.64.0/18", "162.158.0.0/15", "172.64.0.0/13", "173.245.48.0/20",
+		"188.114.96.0/20", "190.93.240.0/20", "197.234.240.0/22", "198.41.128.0/17"}},
+	{"Fastly", []string{"fastly.net", "fastlylb.net"}, []string{"151.101.0.0/16", "199.232.0.0/16", "23.235.32.0/20",
+		"146.75.0.0/17", "157.52.64.0/18", "167.82.0.0/17", "172.111.64.0/18", "185.31.16.0/22", "199.27.72.0/21"}},
+}
+
+type serviceRange struct {
+	prefix netip.Prefix
+	name   string
+}
+
+// Bundled ranges, the longest prefix first
+var serviceRanges = func() []serviceRange {
+	var ranges []serviceRange
+	for _, s := range knownServices {
+		for _, r := range s.ranges {
+			ranges = append(ranges, serviceRange{netip.MustParsePrefix(r), s.name})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].prefix.Bits() > ranges[j].prefix.Bits() })
+	return ranges
+}()
+
+// The service whose most specific domain name ends in, empty for none
+func serviceByName(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	best, length := "", 0
+	for _, s := range knownServices {
+		for _, d := range s.domains {
+			if len(d) > length && hasDomain(name, d) {
+				best, length = s.name, len(d)
+			}
+		}
+	}
+	return best
+}
+
+// Whether name is domain d or one of its subdomains
+func hasDomain(name, d string) bool {
+	return name == d || strings.HasSuffix(name, "."+d)
+}
+
+func serviceByAddr(addr string) string {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return ""
+	}
+	ip = ip.Unmap()
+	for _, r := range serviceRanges {
+		if r.prefix.Contains(ip) {
+			return r.name
+		}
+	}
+	return ""
+}
+
+// Remote addresses remembered with their service, each map starts over past this
+const serviceCacheMax = 50000
+
+// ServiceUsage is the traffic between internal hosts and one CDN or SaaS
+type ServiceUsage struct {
+	Service  string `json:"service"`
+	Packets  int    `json:"packets"`
+	BytesIn  int64  `json:"bytes_in"`
+	BytesOut int64  `json:"bytes_out"`
+	Hosts    int    `json:"hosts"` // internal hosts using it
+}
+
+type serviceUsage struct {
+	ServiceUsage
+	hosts map[string]bool
+}
+
+// ServiceTracker attributes traffic with public addresses to well known
+// CDNs and SaaS. Addresses are learned from the server names of TLS
+// ClientHellos and from DNS answers, other addresses are looked up in the
+// bundled ranges. It is guarded by the MonitoringData mutex.
+type ServiceTracker struct {
+	learned map[string]string // remote address by name
+	cache   map[string]string // remote address by range, empty when none
+	usage   map[string]*serviceUsage
+}
+
+func NewServiceTracker() *ServiceTracker {
+	return &ServiceTracker{learned: make(map[string]string), cache: make(map[string]string), usage: make(map[string]*serviceUsage)}
+}
+
+func (t *ServiceTracker) learn(addr, service string) {
+	if len(t.learned) >= serviceCacheMax {
+		t.learned = make(map[string]string)
+	}
+	t.learned[addr] = service
+}
+
+func (t *ServiceTracker) service(remote string) string {
+	if s := t.learned[remote]; s != "" {
+		return s
+	}
+	s, ok := t.cache[remote]
+	if !ok {
+		s = serviceByAddr(remote)
+		if len(t.cache) >= serviceCacheMax {
+			t.cache = make(map[string]string)
+		}
+		t.cache[remote] = s
+	}
+	return s
+}
+
+func (t *ServiceTracker) Observe(p *netwatch.Packet) {
+	if t == nil || p.SrcIP == "" {
+		return
+	}
+	if p.DNSName != "" {
+		if s := serviceByName(p.DNSName); s != "" {
+			for _, addr := range p.DNSAddrs {
+				t.learn(addr, s)
+			}
+		}
+	}
+	if p.SNI != "" {
+		if s := serviceByName(p.SNI); s != "" {
+			t.learn(p.DstIP, s)
+		}
+	}
+
+	host, remote, out := p.SrcIP, p.DstIP, true
+	switch {
+	case isLocalIP(p.SrcIP) && isPublicIP(p.DstIP):
+	case isPublicIP(p.SrcIP) && isLocalIP(p.DstIP):
+		host, remote, out = p.DstIP, p.SrcIP, false
+	default:
+		return
+	}
+	s := t.service(remote)
+	if s == "" {
+		return
+	}
+	u := t.usage[s]
+	if u == nil {
+		u = &serviceUsage{ServiceUsage: ServiceUsage{Service: s}, hosts: make(map[string]bool)}
+		t.usage[s] = u
+	}
+	u.Packets++
+	if out {
+		u.BytesOut += int64(p.Length)
+	} else {
+		u.BytesIn += int64(p.Length)
+	}
+	u.hosts[host] = true
+}
+
+// Services by volume, the busiest first
+func (t *ServiceTracker) Usage() []ServiceUsage {
+	if t == nil {
+		return nil
+	}
+	var usage []ServiceUsage
+	for _, u := range t.usage {
+		s := u.ServiceUsage
+		s.Hosts = len(u.hosts)
+		usage = append(usage, s)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if a, b := usage[i].BytesIn+usage[i].BytesOut, usage[j].BytesIn+usage[j].BytesOut; a != b {
+			return a > b
+		}
+		return usage[i].Service < usage[j].Service
+	})
+	return usage
+}
+
+// Starting a new period, learned addresses are kept
+func (t *ServiceTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.usage = make(map[string]*serviceUsage)
+}
+
+func printServiceReport(t *ServiceTracker) {
+	usage := t.Usage()
+	if len(usage) == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("SERVICES")
+	for _, u := range usage {
+		fmt.Printf("%s: %.2f MB down | %.2f MB up | %d hosts\n", u.Service,
+			float64(u.BytesIn)/(1024*1024), float64(u.BytesOut)/(1024*1024), u.Hosts)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"netwatchd/sqlite"
+)
+
+// Tables of the -db database. Columns are only ever added at the end, so
+// rows of older versions stay readable.
+var sessionTables = []struct{ name, sql string }{
+	{"sessions", `CREATE TABLE sessions (id INTEGER PRIMARY KEY, host TEXT, interface TEXT, filter TEXT, labels TEXT, start_time TEXT, end_time TEXT, duration_seconds REAL, bucket_seconds INTEGER, total_packets INTEGER, total_bytes REAL, percentile_95_bps REAL, version TEXT)`},
+	{"buckets", `CREATE TABLE buckets (session_id INTEGER REFERENCES sessions(id), start_time TEXT, seconds REAL, packets INTEGER, bytes REAL, bytes_sent REAL, bytes_received REAL, paused_seconds REAL)`},
+	{"alerts", `CREATE TABLE alerts (session_id INTEGER REFERENCES sessions(id), time TEXT, kind TEXT, severity TEXT, message TEXT, resolved INTEGER)`},
+	{"hosts", `CREATE TABLE hosts (session_id INTEGER REFERENCES sessions(id), ip TEXT, mac TEXT, vendor TEXT, os_guess TEXT, hostnames TEXT, first_seen TEXT, last_seen TEXT, packets INTEGER, bytes INTEGER)`},
+	{"flows", `CREATE TABLE flows (session_id INTEGER REFERENCES sessions(id), time TEXT, seconds REAL, src_ip TEXT, dst_ip TEXT, src_port INTEGER, dst_port INTEGER, proto TEXT, packets INTEGER, bytes INTEGER)`},
+}
+
+// Times as SQLite's date functions read them
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// Empty strings are stored as NULL
+func sqliteText(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// Adding the report as a new session to the database at path, created when
+// missing. Returns the session id.
+func saveSession(path string, r *Report) (int64, error) {
+	db, err := sqlite.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		db, err = &sqlite.Database{}, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	for _, t := range sessionTables {
+		db.CreateTable(t.name, t.sql)
+	}
+
+	var labels any
+	if len(r.Labels) > 0 {
+		raw, _ := json.Marshal(r.Labels)
+		labels = string(raw)
+	}
+	id := db.Table("sessions").Append(nil, r.Host, r.Interface, sqliteText(r.Filter), labels, sqliteTime(r.Start), sqliteTime(r.End),
+		r.DurationSeconds, r.BucketSeconds, r.TotalPackets, r.TotalBytes, r.Percentile95, r.Version)
+	buckets := db.Table("buckets")
+	for _, b := range r.Buckets {
+		buckets.Append(id, sqliteTime(b.Start), b.Seconds, b.Packets, b.Bytes, b.BytesSent, b.BytesReceived, b.PausedSeconds)
+	}
+	alerts := db.Table("alerts")
+	for _, a := range r.Alerts {
+		alerts.Append(id, sqliteTime(a.Time), a.Kind, a.Severity, a.Message, a.Resolved)
+	}
+	// Hosts seen in the session, with the traffic of the session only
+	hosts := db.Table("hosts")
+	for _, h := range r.Hosts {
+		hosts.Append(id, h.IP, sqliteText(h.MAC), sqliteText(h.Vendor), sqliteText(h.OSGuess), sqliteText(strings.Join(h.Hostnames, ",")),
+			sqliteTime(h.FirstSeen), sqliteTime(h.LastSeen), h.Packets, h.Bytes)
+	}
+	flows := db.Table("flows")
+	for _, f := range r.Flows {
+		flows.Append(id, sqliteTime(f.Start), f.Seconds, f.SrcIP, f.DstIP, f.SrcPort, f.DstPort, sqliteText(f.Proto), f.Packets, f.Bytes)
+	}
+
+	if err := diskGuardrail.Check(path); err != nil {
+		return 0, err
+	}
+	if err := sqlite.WriteFile(path, db); err != nil {
+		return 0, fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return id, nil
+}
+
+// Saving with the outcome printed, as the report file is
+func storeSession(path string, r *Report) {
+	if id, err := saveSession(path, r); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Printf("Session %d saved to %s\n", id, path)
+	}
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Filters installed per category, the rest of a busy category goes unshaped
+	shapeMaxTargets = 500
+	// Where the shared tc police actions and filter priorities start
+	shapeActionIndex = 4700
+	shapePrio        = 100
+)
+
+// ShapeRule throttles one traffic category to a rate
+type ShapeRule struct {
+	Category string  `json:"category"` // updates, p2p, an update source or a service
+	Bps      float64 `json:"bps"`
+}
+
+// Parsing category=rate, e.g. updates=20Mbps or Steam=50M
+func parseShapeRule(s string) (ShapeRule, error) {
+	category, rate, ok := strings.Cut(strings.TrimSpace(s), "=")
+	if !ok {
+		return ShapeRule{}, fmt.Errorf("shaping rule %q must be category=rate, e.g. updates=20Mbps", s)
+	}
+	name := shapeCategory(category)
+	if name == "" {
+		return ShapeRule{}, fmt.Errorf("unknown category %q, use updates, p2p, an update source or a service like Steam", category)
+	}
+	bps, err := parseBitrate(rate)
+	if err != nil {
+		return ShapeRule{}, err
+	}
+	return ShapeRule{Category: name, Bps: bps}, nil
+}
+
+// The name of a category as the trackers report it, empty when unknown
+func shapeCategory(s string) string {
+	s = strings.TrimSpace(s)
+	names := []string{"updates", "p2p"}
+	for _, u := range updateSources {
+		names = append(names, u.name)
+	}
+	for _, u := range updatePorts {
+		names = append(names, u.name)
+	}
+	for _, k := range knownServices {
+		names = append(names, k.name)
+	}
+	for _, n := range names {
+		if strings.EqualFold(s, n) {
+			return n
+		}
+	}
+	return ""
+}
+
+// shapeTarget is inbound traffic of a category, either from a remote prefix
+// or source port, or to an internal ip:port
+type shapeTarget struct {
+	prefix netip.Prefix // invalid for any address
+	port   int
+	local  bool // prefix and port are the receiver's
+}
+
+func (t shapeTarget) String() string {
+	switch {
+	case t.local:
+		return netip.AddrPortFrom(t.prefix.Addr(), uint16(t.port)).String()
+	case !t.prefix.IsValid():
+		return "port " + strconv.Itoa(t.port)
+	}
+	return t.prefix.String()
+}
+
+// Addresses and ports currently classified as category, at most
+// shapeMaxTargets. Callers hold data.mu.
+func (data *MonitoringData) shapeTargets(category string) []shapeTarget {
+	seen := make(map[shapeTarget]bool)
+	addAddr := func(addr string) {
+		if ip, err := netip.ParseAddr(addr); err == nil {
+			ip = ip.Unmap()
+			seen[shapeTarget{prefix: netip.PrefixFrom(ip, ip.BitLen())}] = true
+		}
+	}
+	switch category {
+	case "p2p":
+		for endpoint := range data.p2p.endpoints {
+			if ap, err := netip.ParseAddrPort(endpoint); err == nil {
+				ip := ap.Addr().Unmap()
+				seen[shapeTarget{prefix: netip.PrefixFrom(ip, ip.BitLen()), port: int(ap.Port()), local: true}] = true
+			}
+		}
+	default:
+		for addr, source := range data.updates.learned {
+			if category == "updates" || source == category {
+				addAddr(addr)
+			}
+		}
+		for _, u := range updatePorts {
+			if category == "updates" || u.name == category {
+				seen[shapeTarget{port: u.port}] = true
+			}
+		}
+		for addr, service := range data.services.learned {
+			if service == category {
+				addAddr(addr)
+			}
+		}
+		for _, r := range serviceRanges {
+			if r.name == category {
+				seen[shapeTarget{prefix: r.prefix}] = true
+			}
+		}
+	}
+
+	var targets []shapeTarget
+	for t := range seen {
+		targets = append(targets, t)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].String() < targets[j].String() })
+	return targets[:min(len(targets), shapeMaxTargets)]
+}
+
+// shapeBackend builds the commands that install and remove the shaping of
+// rule id, n being its number of targets once t is added. Failures of the
+// setup commands are ignored.
+type shapeBackend interface {
+	setup() [][]string
+	start(id int, r ShapeRule) [][]string
+	add(id int, r ShapeRule, t shapeTarget, n int) [][]string
+	// Bringing the targets already shaped in line with a new count
+	resize(id int, r ShapeRule, n int) [][]string
+	stop(id int, r ShapeRule) [][]string
+}
+
+// tcShaper polices inbound traffic on the ingress qdisc. All filters of a
+// rule share one police action, so the category as a whole gets the rate.
+// The ingress qdisc is left in place afterwards, it may hold other filters.
+type tcShaper struct {
+	iface string
+}
+
+// Adding the ingress qdisc fails when it already exists
+func (s tcShaper) setup() [][]string {
+	return [][]string{{"tc", "qdisc", "add", "dev", s.iface, "handle", "ffff:", "ingress"}}
+}
+
+func (s tcShaper) start(id int, r ShapeRule) [][]string {
+	kbit := int64(r.Bps / 1000)
+	return [][]string{{"tc", "actions", "add", "action", "police", "rate", fmt.Sprintf("%dkbit", kbit),
+		"burst", fmt.Sprintf("%dk", max(kbit/80, 10)), "drop", "index", strconv.Itoa(shapeActionIndex + id)}}
+}
+
+func (s tcShaper) add(id int, r ShapeRule, t shapeTarget, n int) [][]string {
+	// Filters of one priority must share a protocol, IPv6 gets the next one
+	protocol, family, prio := "ip", "ip", shapePrio+2*id
+	if t.prefix.IsValid() && t.prefix.Addr().Is6() {
+		protocol, family, prio = "ipv6", "ip6", prio+1
+	}
+	cmd := []string{"tc", "filter", "add", "dev", s.iface, "parent", "ffff:", "protocol", protocol, "prio", strconv.Itoa(prio), "u32"}
+	dir := "src"
+	if t.local {
+		dir = "dst"
+	}
+	if t.prefix.IsValid() {
+		cmd = append(cmd, "match", family, dir, t.prefix.String())
+	}
+	if t.port != 0 {
+		port := "sport"
+		if t.local {
+			port = "dport"
+		}
+		cmd = append(cmd, "match", family, port, strconv.Itoa(t.port), "0xffff")
+	}
+	cmd = append(cmd, "action", "police", "index", strconv.Itoa(shapeActionIndex+id))
+	return [][]string{cmd}
+}
+
+// The police action is shared, its rate holds for any number of filters
+func (s tcShaper) resize(id int, r ShapeRule, n int) [][]string { return nil }
+
+func (s tcShaper) stop(id int, r ShapeRule) [][]string {
+	prio := shapePrio + 2*id
+	return [][]string{
+		{"tc", "filter", "del", "dev", s.iface, "parent", "ffff:", "prio", strconv.Itoa(prio)},
+		{"tc", "filter", "del", "dev", s.iface, "parent", "ffff:", "prio", strconv.Itoa(prio + 1)},
+		{"tc", "actions", "del", "action", "police", "index", strconv.Itoa(shapeActionIndex + id)},
+	}
+}
+
+// qosShaper adds a QoS policy per target, as a policy matches only one
+// prefix or port. Policies throttle what this host sends, each on its own,
+// so the rate of the category is split evenly between its targets and the
+// policies re-rated as targets are added; a quiet target's share is not
+// passed on to the others. They go to the ActiveStore so a reboot clears
+// them even if netwatchd never removes them.
+type qosShaper struct{}
+
+// The share of one of n targets, at least 8 kbit/s
+func qosRate(r ShapeRule, n int) int64 {
+	return max(int64(r.Bps)/int64(max(n, 1)), 8000)
+}
+
+func qosPolicyPrefix(r ShapeRule) string {
+	return "netwatchd-shape-" + strings.NewReplacer(" ", "-", "/", "-", "+", "").Replace(r.Category) + "-"
+}
+
+func (qosShaper) setup() [][]string { return nil }
+
+func (qosShaper) start(id int, r ShapeRule) [][]string { return nil }
+
+func (qosShaper) add(id int, r ShapeRule, t shapeTarget, n int) [][]string {
+	var match string
+	switch {
+	case t.local:
+		match = fmt.Sprintf("-IPSrcPortMatchCondition %d", t.port)
+	case !t.prefix.IsValid():
+		match = fmt.Sprintf("-IPDstPortMatchCondition %d", t.port)
+	default:
+		match = "-IPDstPrefixMatchCondition " + t.prefix.String()
+	}
+	name := qosPolicyPrefix(r) + strings.NewReplacer("/", "-", ":", "-", " ", "-", "[", "", "]", "").Replace(t.String())
+	return [][]string{{"powershell", "-NoProfile", "-Command",
+		fmt.Sprintf("New-NetQosPolicy -Name '%s' -PolicyStore ActiveStore %s -ThrottleRateActionBitsPerSecond %d", name, match, qosRate(r, n))}}
+}
+
+func (qosShaper) resize(id int, r ShapeRule, n int) [][]string {
+	return [][]string{{"powershell", "-NoProfile", "-Command",
+		fmt.Sprintf("Get-NetQosPolicy -PolicyStore ActiveStore | Where-Object Name -like '%s*' | Set-NetQosPolicy -ThrottleRateActionBitsPerSecond %d",
+			qosPolicyPrefix(r), qosRate(r, n))}}
+}
+
+func (qosShaper) stop(id int, r ShapeRule) [][]string {
+	return [][]string{{"powershell", "-NoProfile", "-Command",
+		fmt.Sprintf("Get-NetQosPolicy -PolicyStore ActiveStore | Where-Object Name -like '%s*' | Remove-NetQosPolicy -Confirm:$false", qosPolicyPrefix(r))}}
+}
+
+// ShapePeriod is one stretch a category was shaped, or would have been
+type ShapePeriod struct {
+	Category string    `json:"category"`
+	LimitBps float64   `json:"limit_bps"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end,omitempty"`
+	Targets  int       `json:"targets"`
+	Advisory bool      `json:"advisory,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type shapeState struct {
+	period  *ShapePeriod
+	targets map[shapeTarget]bool
+}
+
+// Shaper throttles the traffic categories of its rules during the shaping
+// hours, and lifts the shaping when they end or netwatchd stops. In advisory
+// mode it only prints the commands. Commands run on its own goroutine, the
+// targets are read from the trackers under data.mu first.
+type Shaper struct {
+	rules   []ShapeRule
+	windows []dailyWindow
+	apply   bool
+	backend shapeBackend
+
+	mu      sync.Mutex
+	active  []*shapeState // by rule, nil outside the hours
+	History []*ShapePeriod
+}
+
+func NewShaper(rules, hours []string, iface string, apply bool) (*Shaper, error) {
+	s := &Shaper{apply: apply}
+	for _, r := range rules {
+		if r = strings.TrimSpace(r); r == "" {
+			continue
+		}
+		rule, err := parseShapeRule(r)
+		if err != nil {
+			return nil, err
+		}
+		s.rules = append(s.rules, rule)
+	}
+	for _, h := range hours {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		w, err := parseDailyWindow(h)
+		if err != nil {
+			return nil, err
+		}
+		s.windows = append(s.windows, w)
+	}
+	s.active = make([]*shapeState, len(s.rules))
+
+	switch runtime.GOOS {
+	case "windows":
+		s.backend = qosShaper{}
+	case "linux":
+		if apply && iface == "" {
+			return nil, fmt.Errorf("shaping needs the capture interface, set -i")
+		}
+		s.backend = tcShaper{iface: iface}
+	default:
+		if apply {
+			return nil, fmt.Errorf("shaping is not supported on %s, leave out -shape-apply for advice", runtime.GOOS)
+		}
+		s.backend = tcShaper{iface: iface}
+	}
+	return s, nil
+}
+
+// Whether now is inside the shaping hours and when they end, zero without hours
+func (s *Shaper) inHours(now time.Time) (bool, time.Time) {
+	if len(s.windows) == 0 {
+		return true, time.Time{}
+	}
+	for _, w := range s.windows {
+		if _, end, ok := w.occurrence(now); ok {
+			return true, end
+		}
+	}
+	return false, time.Time{}
+}
+
+// Running or printing commands, the first error is returned
+func (s *Shaper) run(cmds [][]string) error {
+	var first error
+	for _, cmd := range cmds {
+		if !s.apply {
+			fmt.Printf("Shaping advice: %s\n", strings.Join(cmd, " "))
+			continue
+		}
+		if err := runFirewallCommand(cmd[0], cmd[1:]...); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Run shapes during the hours until ctx ends, then lifts the shaping
+func (s *Shaper) Run(ctx context.Context, targets func(category string) []shapeTarget) {
+	if s.apply {
+		// Shaping left behind by an earlier run is removed
+		for i, r := range s.rules {
+			for _, cmd := range s.backend.stop(i, r) {
+				runFirewallCommand(cmd[0], cmd[1:]...)
+			}
+		}
+	}
+	s.run(s.backend.setup())
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for now := time.Now(); ; {
+		s.update(now, targets)
+		select {
+		case <-ctx.Done():
+			s.liftAll(time.Now())
+			return
+		case now = <-ticker.C:
+		}
+	}
+}
+
+func (s *Shaper) update(now time.Time, targets func(category string) []shapeTarget) {
+	in, until := s.inHours(now)
+	current := make([][]shapeTarget, len(s.rules))
+	if in {
+		for i, r := range s.rules {
+			current[i] = targets(r.Category)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, r := range s.rules {
+		st := s.active[i]
+		if !in {
+			if st != nil {
+				s.lift(i, now)
+				fmt.Printf("Shaping of %s lifted\n", r.Category)
+			}
+			continue
+		}
+		if st == nil {
+			st = &shapeState{period: &ShapePeriod{Category: r.Category, LimitBps: r.Bps, Start: now, Advisory: !s.apply},
+				targets: make(map[shapeTarget]bool)}
+			s.active[i] = st
+			s.History = append(s.History, st.period)
+			if err := s.run(s.backend.start(i, r)); err != nil {
+				logger.Error("failed to start shaping", "category", r.Category, "err", err)
+				st.period.Error = err.Error()
+			}
+			if until.IsZero() {
+				fmt.Printf("Shaping %s to %s\n", r.Category, formatBitrate(r.Bps))
+			} else {
+				fmt.Printf("Shaping %s to %s until %s\n", r.Category, formatBitrate(r.Bps), until.Format("15:04"))
+			}
+		}
+		if st.period.Error != "" {
+			continue
+		}
+		var added []shapeTarget
+		for _, t := range current[i] {
+			if !st.targets[t] {
+				added = append(added, t)
+			}
+		}
+		if len(added) == 0 {
+			continue
+		}
+		n := st.period.Targets + len(added)
+		if st.period.Targets > 0 {
+			if err := s.run(s.backend.resize(i, r, n)); err != nil {
+				logger.Warn("failed to adjust shaping", "category", r.Category, "err", err)
+			}
+		}
+		for _, t := range added {
+			st.targets[t] = true
+			if err := s.run(s.backend.add(i, r, t, n)); err != nil {
+				logger.Warn("failed to shape", "category", r.Category, "target", t.String(), "err", err)
+				continue
+			}
+			st.period.Targets++
+		}
+	}
+}
+
+func (s *Shaper) liftAll(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.rules {
+		if s.active[i] != nil {
+			s.lift(i, now)
+		}
+	}
+}
+
+// Callers hold s.mu
+func (s *Shaper) lift(i int, now time.Time) {
+	if err := s.run(s.backend.stop(i, s.rules[i])); err != nil {
+		logger.Warn("failed to remove shaping", "category", s.rules[i].Category, "err", err)
+	}
+	s.active[i].period.End = now
+	s.active[i] = nil
+}
+
+func (s *Shaper) periods() []ShapePeriod {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var periods []ShapePeriod
+	for _, p := range s.History {
+		periods = append(periods, *p)
+	}
+	return periods
+}
+
+func printShapeReport(s *Shaper) {
+	if s == nil {
+		return
+	}
+	periods := s.periods()
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("SHAPING")
+	if !s.apply {
+		fmt.Println("Advisory only, add -shape-apply to install the commands above")
+	}
+	if len(periods) == 0 {
+		fmt.Println("Outside the shaping hours the whole run")
+		return
+	}
+	for _, p := range periods {
+		end := "still active"
+		if !p.End.IsZero() {
+			end = "until " + p.End.Format("15:04:05")
+		}
+		note := ""
+		if p.Error != "" {
+			note = " (failed: " + p.Error + ")"
+		}
+		fmt.Printf("%s %s to %s %s, %d targets%s\n", p.Start.Format("15:04:05"), p.Category, formatBitrate(p.LimitBps), end, p.Targets, note)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// SIGUSR2 toggles pause/resume
+func notifyPause(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
+
+// SIGUSR1 prints an interim report
+func notifyReport(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
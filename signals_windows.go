@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// Windows has no user signals, use the keyboard controls instead
+func notifyPause(c chan<- os.Signal) {}
+
+// Interim reports come from -report-every only
+func notifyReport(c chan<- os.Signal) {}
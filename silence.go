@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Default file shared by `netwatchd silence` and a running capture
+const defaultSilenceFile = "netwatchd-silences.json"
+
+// MaintenanceWindow silences alerts during planned work, either daily
+// ("22:00-23:30") or between two absolute times
+type MaintenanceWindow struct {
+	Name   string    `json:"name"`
+	Window string    `json:"window,omitempty"`
+	Start  time.Time `json:"start,omitempty"`
+	End    time.Time `json:"end,omitempty"`
+	Kinds  []string  `json:"kinds,omitempty"`
+	Match  string    `json:"match,omitempty"`
+}
+
+// Silence is an ad hoc mute created with `netwatchd silence`
+type Silence struct {
+	ID      string    `json:"id"`
+	Kinds   []string  `json:"kinds,omitempty"`
+	Match   string    `json:"match,omitempty"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Reason  string    `json:"reason,omitempty"`
+	Created string    `json:"created_by,omitempty"`
+}
+
+func (m MaintenanceWindow) validate() error {
+	if m.Name == "" {
+		return errors.New("name is required")
+	}
+	switch {
+	case m.Window != "" && !m.Start.IsZero():
+		return errors.New("use either window or start/end")
+	case m.Window != "":
+		_, err := parseDailyWindow(m.Window)
+		return err
+	case m.Start.IsZero() || m.End.IsZero():
+		return errors.New("window or start and end are required")
+	case !m.End.After(m.Start):
+		return errors.New("end must be after start")
+	}
+	return nil
+}
+
+// An empty kind list matches every alert kind
+func matchesAlert(kinds []string, match string, a Alert) bool {
+	if len(kinds) > 0 {
+		found := false
+		for _, k := range kinds {
+			if strings.EqualFold(k, a.Kind) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return match == "" || strings.Contains(strings.ToLower(a.Message), strings.ToLower(match))
+}
+
+// Silencer decides whether an alert should be recorded without notifying anyone.
+// The silence file is re-read whenever it changes, so silences added from
+// another shell apply to a capture that is already running.
+type Silencer struct {
+	windows  []MaintenanceWindow
+	daily    []dailyWindow
+	path     string
+	modTime  time.Time
+	silences []Silence
+}
+
+func NewSilencer(windows []MaintenanceWindow, path string) (*Silencer, error) {
+	s := &Silencer{windows: windows, path: path}
+	for _, w := range windows {
+		if err := w.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", w.Name, err)
+		}
+		var d dailyWindow
+		if w.Window != "" {
+			d, _ = parseDailyWindow(w.Window)
+		}
+		s.daily = append(s.daily, d)
+	}
+	return s, nil
+}
+
+func (s *Silencer) reload() {
+	if s.path == "" {
+		return
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		s.silences = nil
+		return
+	}
+	if info.ModTime().Equal(s.modTime) {
+		return
+	}
+	silences, err := loadSilences(s.path)
+	if err != nil {
+		logger.Warn("failed to read silences", "path", s.path, "err", err)
+		return
+	}
+	s.modTime = info.ModTime()
+	s.silences = silences
+}
+
+// Silenced returns what muted the alert, or an empty string
+func (s *Silencer) Silenced(a Alert) string {
+	if s == nil {
+		return ""
+	}
+	s.reload()
+	for _, si := range s.silences {
+		if !a.Time.Before(si.Start) && a.Time.Before(si.End) && matchesAlert(si.Kinds, si.Match, a) {
+			return "silence " + si.ID
+		}
+	}
+	for i, w := range s.windows {
+		active := !a.Time.Before(w.Start) && a.Time.Before(w.End)
+		if w.Window != "" {
+			_, _, active = s.daily[i].occurrence(a.Time)
+		}
+		if active && matchesAlert(w.Kinds, w.Match, a) {
+			return "maintenance " + w.Name
+		}
+	}
+	return ""
+}
+
+func loadSilences(path string) ([]Silence, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var silences []Silence
+	if err := json.Unmarshal(b, &silences); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return silences, nil
+}
+
+// Writing through a temporary file so a running capture never reads half a file
+func saveSilences(path string, silences []Silence) error {
+	b, err := json.MarshalIndent(silences, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// netwatchd silence [-file f] [-kind k,...] [-match text] [-for 2h] [-reason r]
+// netwatchd silence -list | -expire <id>
+func runSilenceCommand(args []string) int {
+	fs := flag.NewFlagSet("silence", flag.ExitOnError)
+	fileFlag := fs.String("file", defaultSilenceFile, "Silence file read by running captures")
+	kindFlag := fs.String("kind", "", "Comma separated alert kinds to silence (empty for all)")
+	matchFlag := fs.String("match", "", "Only silence alerts whose message contains this text")
+	forFlag := fs.Duration("for", time.Hour, "How long the silence lasts")
+	reasonFlag := fs.String("reason", "", "Why alerts are silenced, shown in the report")
+	listFlag := fs.Bool("list", false, "List active silences")
+	expireFlag := fs.String("expire", "", "End the silence with this ID now")
+	fs.Parse(args)
+
+	silences, err := loadSilences(*fileFlag)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("Failed to read silences: %v\n", err)
+		return 1
+	}
+
+	now := time.Now()
+	// Expired silences are dropped whenever the file is rewritten
+	active := silences[:0]
+	for _, s := range silences {
+		if s.End.After(now) {
+			active = append(active, s)
+		}
+	}
+
+	switch {
+	case *listFlag:
+		if len(active) == 0 {
+			fmt.Println("No active silences")
+		}
+		for _, s := range active {
+			kinds := "all alerts"
+			if len(s.Kinds) > 0 {
+				kinds = strings.Join(s.Kinds, ",")
+			}
+			if s.Match != "" {
+				kinds += fmt.Sprintf(" matching %q", s.Match)
+			}
+			fmt.Printf("%s  %s until %s  %s\n", s.ID, kinds, s.End.Format("2006-01-02 15:04"), s.Reason)
+		}
+		return 0
+	case *expireFlag != "":
+		found := false
+		for i := range active {
+			if active[i].ID == *expireFlag {
+				active[i].End = now
+				found = true
+			}
+		}
+		if !found {
+			fmt.Printf("No active silence %s\n", *expireFlag)
+			return 1
+		}
+	default:
+		if *forFlag <= 0 {
+			fmt.Println("-for must be positive")
+			return 2
+		}
+		id := make([]byte, 4)
+		rand.Read(id)
+		s := Silence{ID: hex.EncodeToString(id), Match: *matchFlag, Start: now, End: now.Add(*forFlag), Reason: *reasonFlag}
+		if *kindFlag != "" {
+			s.Kinds = strings.Split(*kindFlag, ",")
+		}
+		s.Created, _ = os.Hostname()
+		if user := os.Getenv("USER"); user != "" {
+			s.Created = user + "@" + s.Created
+		}
+		active = append(active, s)
+		fmt.Printf("Silence %s active until %s\n", s.ID, s.End.Format("2006-01-02 15:04"))
+	}
+
+	if err := saveSilences(*fileFlag, active); err != nil {
+		fmt.Printf("Failed to save silences: %v\n", err)
+		return 1
+	}
+	return 0
+}
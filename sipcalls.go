@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// SIPCall is one call set up with SIP and the media its SDP announced
+type SIPCall struct {
+	CallID       string     `json:"call_id"`
+	From         string     `json:"from"`
+	To           string     `json:"to"`
+	Caller       string     `json:"caller"` // address that sent the INVITE
+	Invite       time.Time  `json:"invite"`
+	Answer       *time.Time `json:"answer,omitempty"`
+	End          *time.Time `json:"end,omitempty"`
+	State        string     `json:"state"`            // ringing, answered, ended, failed or cancelled
+	Status       string     `json:"status,omitempty"` // final response of a failed call, e.g. 486 Busy Here
+	Media        []string   `json:"media,omitempty"`  // address:port endpoints from the SDP
+	MediaPackets int        `json:"media_packets"`
+	MediaBytes   int64      `json:"media_bytes"`
+	Seconds      float64    `json:"seconds"` // answered until the BYE or the last media
+	Kbps         float64    `json:"kbps,omitempty"`
+	MOS          float64    `json:"mos,omitempty"` // worst of its RTP streams
+}
+
+type sipCall struct {
+	SIPCall
+	lastMedia time.Time
+	counted   time.Time // media counts start here after a period reset
+}
+
+// SIPCallTracker follows SIP dialogs by Call-ID from INVITE to BYE, and
+// counts the UDP traffic to and from the media endpoints their SDP offers
+// and answers name, so each call gets a bandwidth and, through the call
+// quality tracker, a MOS. SIP over TLS on 5061 can't be followed. It is
+// guarded by the MonitoringData mutex.
+type SIPCallTracker struct {
+	calls map[string]*sipCall
+	media map[string]*sipCall // by SDP address:port
+}
+
+func NewSIPCallTracker() *SIPCallTracker {
+	return &SIPCallTracker{calls: make(map[string]*sipCall), media: make(map[string]*sipCall)}
+}
+
+func (t *SIPCallTracker) Observe(p *netwatch.Packet) {
+	if m := p.SIP; m != nil && m.CallID != "" {
+		t.signal(p, m)
+		return
+	}
+	if p.Transport != "udp" || len(t.media) == 0 {
+		return
+	}
+	c := t.media[fmt.Sprintf("%s:%d", p.SrcIP, p.SrcPort)]
+	if c == nil {
+		c = t.media[fmt.Sprintf("%s:%d", p.DstIP, p.DstPort)]
+	}
+	if c == nil {
+		return
+	}
+	c.MediaPackets++
+	c.MediaBytes += int64(p.Length)
+	c.lastMedia = p.Time
+}
+
+func (t *SIPCallTracker) signal(p *netwatch.Packet, m *netwatch.SIPMessage) {
+	c := t.calls[m.CallID]
+	if c == nil {
+		if m.Method != "INVITE" {
+			return
+		}
+		c = &sipCall{SIPCall: SIPCall{CallID: m.CallID, From: m.From, To: m.To, Caller: p.SrcIP, Invite: p.Time, State: "ringing"}}
+		t.calls[m.CallID] = c
+	}
+
+	if m.MediaAddr != "" {
+		for _, port := range m.MediaPorts {
+			ep := fmt.Sprintf("%s:%d", m.MediaAddr, port)
+			if t.media[ep] != c {
+				t.media[ep] = c
+				c.Media = append(c.Media, ep)
+			}
+		}
+	}
+
+	at := p.Time
+	switch {
+	case m.Method == "BYE" && c.End == nil:
+		c.End = &at
+		c.State = "ended"
+	case m.Method == "CANCEL" && c.Answer == nil:
+		c.End = &at
+		c.State = "cancelled"
+	case m.Method != "" || m.CSeqMethod != "INVITE":
+		// Other requests, and the responses to them
+	case m.Status >= 200 && m.Status < 300:
+		if c.Answer == nil {
+			c.Answer = &at
+			c.State = "answered"
+		}
+	// 401 and 407 ask for credentials, the INVITE is sent again; 487 follows a CANCEL
+	case m.Status >= 300 && m.Status != 401 && m.Status != 407 && c.Answer == nil && c.State != "cancelled":
+		c.End = &at
+		c.State = "failed"
+		c.Status = strings.TrimSpace(fmt.Sprintf("%d %s", m.Status, m.Reason))
+	}
+}
+
+// When an answered call stopped, the BYE or else its last media
+func (c *sipCall) stopped() time.Time {
+	if c.End != nil {
+		return *c.End
+	}
+	if c.lastMedia.After(*c.Answer) {
+		return c.lastMedia
+	}
+	return *c.Answer
+}
+
+// Calls in INVITE order, with the worst MOS of the RTP streams on their media
+func (t *SIPCallTracker) Calls(streams []CallQuality) []SIPCall {
+	if t == nil || len(t.calls) == 0 {
+		return nil
+	}
+	var calls []SIPCall
+	for _, c := range t.calls {
+		call := c.SIPCall
+		if c.Answer != nil {
+			call.Seconds = c.stopped().Sub(*c.Answer).Seconds()
+			from := *c.Answer
+			if c.counted.After(from) {
+				from = c.counted
+			}
+			if s := c.stopped().Sub(from).Seconds(); s > 0 {
+				call.Kbps = float64(c.MediaBytes) * 8 / 1000 / s
+			}
+		}
+		for _, s := range streams {
+			for _, ep := range c.Media {
+				if (s.Source == ep || s.Destination == ep) && (call.MOS == 0 || s.MOS < call.MOS) {
+					call.MOS = s.MOS
+				}
+			}
+		}
+		calls = append(calls, call)
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		if !calls[i].Invite.Equal(calls[j].Invite) {
+			return calls[i].Invite.Before(calls[j].Invite)
+		}
+		return calls[i].CallID < calls[j].CallID
+	})
+	return calls
+}
+
+// Most answered calls up at once, and when that was first reached
+func peakConcurrentCalls(calls []SIPCall) (int, time.Time) {
+	type edge struct {
+		at    time.Time
+		delta int
+	}
+	var edges []edge
+	for _, c := range calls {
+		if c.Answer == nil {
+			continue
+		}
+		edges = append(edges, edge{*c.Answer, 1}, edge{c.Answer.Add(time.Duration(c.Seconds * float64(time.Second))), -1})
+	}
+	// Hang ups before answers at the same instant
+	sort.Slice(edges, func(i, j int) bool {
+		if !edges[i].at.Equal(edges[j].at) {
+			return edges[i].at.Before(edges[j].at)
+		}
+		return edges[i].delta < edges[j].delta
+	})
+	peak, current, at := 0, 0, time.Time{}
+	for _, e := range edges {
+		current += e.delta
+		if current > peak {
+			peak, at = current, e.at
+		}
+	}
+	return peak, at
+}
+
+// Starting a new period, calls still up are kept with their media counts reset
+func (t *SIPCallTracker) reset() {
+	if t == nil {
+		return
+	}
+	for id, c := range t.calls {
+		if c.End == nil {
+			c.MediaPackets, c.MediaBytes = 0, 0
+			c.counted = c.lastMedia
+			continue
+		}
+		delete(t.calls, id)
+		for _, ep := range c.Media {
+			if t.media[ep] == c {
+				delete(t.media, ep)
+			}
+		}
+	}
+}
+
+func printSIPCallReport(t *SIPCallTracker, streams []CallQuality) {
+	calls := t.Calls(streams)
+	if len(calls) == 0 {
+		return
+	}
+	states := make(map[string]int)
+	for _, c := range calls {
+		states[c.State]++
+	}
+	peak, at := peakConcurrentCalls(calls)
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("SIP CALLS")
+	fmt.Printf("Calls: %d | %d answered | %d failed | %d cancelled | %d ringing\n", len(calls),
+		states["answered"]+states["ended"], states["failed"], states["cancelled"], states["ringing"])
+	if peak > 0 {
+		fmt.Printf("Peak concurrent calls: %d at %s\n", peak, at.Format("15:04:05"))
+	}
+	for i, c := range calls {
+		if i == 50 {
+			fmt.Printf("... %d more calls\n", len(calls)-i)
+			break
+		}
+		line := fmt.Sprintf("%s %s -> %s: %s", c.Invite.Format("15:04:05"), c.From, c.To, c.State)
+		switch {
+		case c.Status != "":
+			line += " (" + c.Status + ")"
+		case c.Answer != nil:
+			line += fmt.Sprintf(" | %s | %.1f kbps", time.Duration(math.Round(c.Seconds))*time.Second, c.Kbps)
+			if c.MOS > 0 {
+				line += fmt.Sprintf(" | MOS %.1f, %s", c.MOS, mosRating(c.MOS))
+			}
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default root of NETWATCHD-MIB.txt, replace it with an assigned enterprise
+// number through the sink's oid setting when publishing the MIB internally
+const defaultTrapOID = "1.3.6.1.3.7875"
+
+// OIDs below the MIB root, see NETWATCHD-MIB.txt
+const (
+	trapAlert         = ".0.1"
+	trapAlertResolved = ".0.2"
+	objAlertKind      = ".1.1.0"
+	objAlertSeverity  = ".1.2.0"
+	objAlertMessage   = ".1.3.0"
+	objAlertKey       = ".1.4.0"
+	objAlertHost      = ".1.5.0"
+	objAlertLabels    = ".1.6.0"
+)
+
+const (
+	oidSysUpTime   = "1.3.6.1.2.1.1.3.0"
+	oidSnmpTrapOID = "1.3.6.1.6.3.1.1.4.1.0"
+)
+
+// BER tags used in traps
+const (
+	berInteger   = 0x02
+	berOctets    = 0x04
+	berOID       = 0x06
+	berSequence  = 0x30
+	berTimeTicks = 0x43
+	berTrapV2    = 0xa7
+)
+
+// trapSeverity follows the nwAlertSeverity enumeration in the MIB
+var trapSeverity = map[string]int{"info": 1, "warning": 2, "critical": 3}
+
+var processStart = time.Now()
+
+// snmpEngineBoots already taken by this process, by boots file, so sinks
+// sharing an engine count one start
+var (
+	engineBootsMu sync.Mutex
+	engineBoots   = make(map[string]int)
+)
+
+// The snmpEngineBoots of this start: one more than the count saved in path,
+// which is updated. Receivers drop traps whose boots and time fall behind
+// what they saw last (notInTimeWindow), and engine time restarts at 0.
+func nextEngineBoots(path string) int {
+	engineBootsMu.Lock()
+	defer engineBootsMu.Unlock()
+	if boots, ok := engineBoots[path]; ok {
+		return boots
+	}
+	boots := 1
+	if raw, err := os.ReadFile(path); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(raw))); err == nil && n > 0 {
+			// RFC 3414 2.2.2, it stays at its maximum
+			boots = min(n+1, 2147483647)
+		}
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err == nil {
+		err = os.WriteFile(path, []byte(strconv.Itoa(boots)+"\n"), 0o644)
+	}
+	if err != nil {
+		logger.Warn("failed to save the SNMP engine boots, receivers may reject traps after a restart", "file", path, "err", err)
+	}
+	engineBoots[path] = boots
+	return boots
+}
+
+// The default boots file of engineID, in the user's config directory
+func engineBootsPath(engineID []byte) string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "netwatchd", fmt.Sprintf("snmp-engine-boots-%x", engineID))
+}
+
+// snmpTrapSink sends SNMPv2c or SNMPv3 (USM) traps. For v3 netwatchd is the
+// authoritative engine, receivers need its engine ID to accept the traps.
+type snmpTrapSink struct {
+	c        SinkConfig
+	root     string
+	engineID []byte
+	boots    int
+	authKey  []byte
+	privKey  []byte
+	newHash  func() hash.Hash
+	macLen   int
+	mu       sync.Mutex
+	requests int32
+}
+
+func (c SinkConfig) validateTrap() error {
+	switch c.SNMPVersion {
+	case "", "2c":
+	case "3":
+		if c.Username == "" {
+			return errors.New("username is required for SNMPv3")
+		}
+		if c.AuthProtocol != "" && len(c.Password) < 8 {
+			return errors.New("password must be at least 8 characters")
+		}
+		if c.PrivProtocol != "" && (c.AuthProtocol == "" || len(c.PrivPassword) < 8) {
+			return errors.New("privacy needs auth_protocol and a priv_password of at least 8 characters")
+		}
+		switch strings.ToUpper(c.AuthProtocol) {
+		case "", "MD5", "SHA", "SHA256":
+		default:
+			return fmt.Errorf("auth_protocol must be MD5, SHA or SHA256")
+		}
+		switch strings.ToUpper(c.PrivProtocol) {
+		case "", "AES":
+		default:
+			return fmt.Errorf("priv_protocol must be AES")
+		}
+		if c.EngineID != "" {
+			if id, err := hex.DecodeString(strings.TrimPrefix(c.EngineID, "0x")); err != nil || len(id) < 5 || len(id) > 32 {
+				return errors.New("engine_id must be 5 to 32 bytes of hex")
+			}
+		}
+	default:
+		return fmt.Errorf("snmp_version must be 2c or 3")
+	}
+	if c.OID != "" {
+		if _, err := encodeOID(c.OID); err != nil {
+			return fmt.Errorf("oid: %v", err)
+		}
+	}
+	return nil
+}
+
+func newSNMPTrapSink(c SinkConfig) *snmpTrapSink {
+	s := &snmpTrapSink{c: c, root: strings.TrimPrefix(c.OID, ".")}
+	if s.root == "" {
+		s.root = defaultTrapOID
+	}
+	if s.c.Address == "" {
+		s.c.Address = "localhost:162"
+	}
+	if c.SNMPVersion != "3" {
+		return s
+	}
+
+	if c.EngineID != "" {
+		s.engineID, _ = hex.DecodeString(strings.TrimPrefix(c.EngineID, "0x"))
+	} else {
+		// RFC 3411 format 5 (administratively assigned octets) derived from the hostname
+		host, _ := os.Hostname()
+		sum := sha1.Sum([]byte(host))
+		s.engineID = append([]byte{0x80, 0, 0, 0, 5}, sum[:8]...)
+	}
+	bootsFile := c.BootsFile
+	if bootsFile == "" {
+		bootsFile = engineBootsPath(s.engineID)
+	}
+	s.boots = nextEngineBoots(bootsFile)
+	switch strings.ToUpper(c.AuthProtocol) {
+	case "MD5":
+		s.newHash, s.macLen = md5.New, 12
+	case "SHA":
+		s.newHash, s.macLen = sha1.New, 12
+	case "SHA256":
+		s.newHash, s.macLen = sha256.New, 24
+	}
+	if s.newHash != nil {
+		s.authKey = localizeKey(s.newHash, c.Password, s.engineID)
+		if c.PrivProtocol != "" {
+			s.privKey = localizeKey(s.newHash, c.PrivPassword, s.engineID)[:16]
+		}
+	}
+	fmt.Printf("SNMPv3 traps to %s use engine ID 0x%x, boot %d\n", s.c.Address, s.engineID, s.boots)
+	return s
+}
+
+// RFC 3414 A.2: hashing a megabyte of the repeated password, then localizing it to the engine
+func localizeKey(newHash func() hash.Hash, password string, engineID []byte) []byte {
+	h := newHash()
+	block := make([]byte, 64)
+	for n := 0; n < 1048576; n += 64 {
+		for i := range block {
+			block[i] = password[(n+i)%len(password)]
+		}
+		h.Write(block)
+	}
+	ku := h.Sum(nil)
+	h.Reset()
+	h.Write(ku)
+	h.Write(engineID)
+	h.Write(ku)
+	return h.Sum(nil)
+}
+
+func (s *snmpTrapSink) Send(ev AlertEvent, text string) error {
+	trap := trapAlert
+	if ev.Resolved {
+		trap = trapAlertResolved
+	}
+	var labels []string
+	for k, v := range ev.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	uptime := uint32(time.Since(processStart) / (10 * time.Millisecond))
+	binds := [][]byte{
+		varbind(oidSysUpTime, tlv(berTimeTicks, berUint(uint64(uptime)))),
+		varbind(oidSnmpTrapOID, mustOID(s.root+trap)),
+		varbind(s.root+objAlertKind, tlv(berOctets, []byte(ev.Kind))),
+		varbind(s.root+objAlertSeverity, tlv(berInteger, berUint(uint64(trapSeverity[ev.Severity])))),
+		varbind(s.root+objAlertMessage, tlv(berOctets, []byte(truncate(text, 255)))),
+		varbind(s.root+objAlertKey, tlv(berOctets, []byte(ev.Key))),
+		varbind(s.root+objAlertHost, tlv(berOctets, []byte(ev.Host))),
+		varbind(s.root+objAlertLabels, tlv(berOctets, []byte(strings.Join(labels, ",")))),
+	}
+
+	s.mu.Lock()
+	s.requests++
+	id := s.requests
+	s.mu.Unlock()
+	pdu := tlv(berTrapV2, concat(
+		tlv(berInteger, berUint(uint64(id))),
+		tlv(berInteger, []byte{0}),
+		tlv(berInteger, []byte{0}),
+		tlv(berSequence, concat(binds...)),
+	))
+
+	var msg []byte
+	if s.c.SNMPVersion == "3" {
+		var err error
+		if msg, err = s.v3Message(id, pdu); err != nil {
+			return err
+		}
+	} else {
+		community := s.c.Community
+		if community == "" {
+			community = "public"
+		}
+		msg = tlv(berSequence, concat(tlv(berInteger, []byte{1}), tlv(berOctets, []byte(community)), pdu))
+	}
+
+	conn, err := net.DialTimeout("udp", s.c.Address, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(msg)
+	return err
+}
+
+// Building an SNMPv3 message with USM authentication and optional AES privacy
+func (s *snmpTrapSink) v3Message(id int32, pdu []byte) ([]byte, error) {
+	boots := s.boots
+	engineTime := int(time.Since(processStart).Seconds())
+	flags := byte(0)
+	if s.authKey != nil {
+		flags |= 0x01
+	}
+
+	scoped := tlv(berSequence, concat(tlv(berOctets, s.engineID), tlv(berOctets, nil), pdu))
+	var salt []byte
+	data := scoped
+	if s.privKey != nil {
+		flags |= 0x02
+		salt = make([]byte, 8)
+		rand.Read(salt)
+		// RFC 3826: IV is engine boots, engine time and the salt
+		iv := make([]byte, 16)
+		binary.BigEndian.PutUint32(iv[0:], uint32(boots))
+		binary.BigEndian.PutUint32(iv[4:], uint32(engineTime))
+		copy(iv[8:], salt)
+		block, err := aes.NewCipher(s.privKey)
+		if err != nil {
+			return nil, err
+		}
+		encrypted := make([]byte, len(scoped))
+		cipher.NewCFBEncrypter(block, iv).XORKeyStream(encrypted, scoped)
+		data = tlv(berOctets, encrypted)
+	}
+
+	// The MAC is computed with zeros in its place, then written over them
+	placeholder := bytes.Repeat([]byte{0}, s.macLen)
+	usm := tlv(berSequence, concat(
+		tlv(berOctets, s.engineID),
+		tlv(berInteger, berUint(uint64(boots))),
+		tlv(berInteger, berUint(uint64(engineTime))),
+		tlv(berOctets, []byte(s.c.Username)),
+		tlv(berOctets, placeholder),
+		tlv(berOctets, salt),
+	))
+	header := tlv(berSequence, concat(
+		tlv(berInteger, berUint(uint64(id))),
+		tlv(berInteger, berUint(65507)),
+		tlv(berOctets, []byte{flags}),
+		tlv(berInteger, []byte{3}),
+	))
+	msg := tlv(berSequence, concat(tlv(berInteger, []byte{3}), header, tlv(berOctets, usm), data))
+
+	if s.authKey != nil {
+		mac := hmac.New(s.newHash, s.authKey)
+		mac.Write(msg)
+		sum := mac.Sum(nil)[:s.macLen]
+		i := bytes.Index(msg, append([]byte{berOctets, byte(s.macLen)}, placeholder...))
+		if i < 0 {
+			return nil, errors.New("authentication parameters not found")
+		}
+		copy(msg[i+2:], sum)
+	}
+	return msg, nil
+}
+
+func varbind(oid string, value []byte) []byte {
+	return tlv(berSequence, concat(mustOID(oid), value))
+}
+
+func mustOID(oid string) []byte {
+	b, err := encodeOID(oid)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func encodeOID(oid string) ([]byte, error) {
+	parts := strings.Split(strings.TrimPrefix(oid, "."), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("%q is not an OID", oid)
+	}
+	var nums []uint64
+	for _, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an OID", oid)
+		}
+		nums = append(nums, n)
+	}
+	if nums[0] > 2 || (nums[0] < 2 && nums[1] >= 40) {
+		return nil, fmt.Errorf("%q is not an OID", oid)
+	}
+	out := base128(nums[0]*40 + nums[1])
+	for _, n := range nums[2:] {
+		out = append(out, base128(n)...)
+	}
+	return tlv(berOID, out), nil
+}
+
+func base128(n uint64) []byte {
+	out := []byte{byte(n & 0x7f)}
+	for n >>= 7; n > 0; n >>= 7 {
+		out = append([]byte{byte(n&0x7f) | 0x80}, out...)
+	}
+	return out
+}
+
+// Minimal two's complement encoding of a non-negative integer
+func berUint(v uint64) []byte {
+	var out []byte
+	for {
+		out = append([]byte{byte(v)}, out...)
+		v >>= 8
+		if v == 0 {
+			break
+		}
+	}
+	if out[0]&0x80 != 0 {
+		out = append([]byte{0}, out...)
+	}
+	return out
+}
+
+func tlv(tag byte, content []byte) []byte {
+	n := len(content)
+	var length []byte
+	if n < 0x80 {
+		length = []byte{byte(n)}
+	} else {
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		length = append([]byte{0x80 | byte(len(length))}, length...)
+	}
+	return concat([]byte{tag}, length, content)
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
@@ -0,0 +1,492 @@
+// Package sqlite reads and writes SQLite 3 database files without cgo or a
+// driver. A database is read whole and written whole, so it suits small files
+// rewritten once in a while, not a busy database. Only rowid tables, views and
+// triggers are kept, a file with indexes or WITHOUT ROWID tables is refused.
+package sqlite
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const pageSize = 4096
+
+// Row is one table row. Values are nil, int64, float64, string or []byte.
+type Row struct {
+	ID     int64
+	Values []any
+}
+
+// Table is a rowid table with its CREATE TABLE statement
+type Table struct {
+	Name string
+	SQL  string
+	Rows []Row
+}
+
+// Append adds a row after the last one and returns its rowid
+func (t *Table) Append(values ...any) int64 {
+	id := t.NextID()
+	t.Rows = append(t.Rows, Row{ID: id, Values: values})
+	return id
+}
+
+// NextID is the rowid Append gives the next row
+func (t *Table) NextID() int64 {
+	if len(t.Rows) == 0 {
+		return 1
+	}
+	return t.Rows[len(t.Rows)-1].ID + 1
+}
+
+// Object is a schema entry without rows of its own, a view or a trigger
+type Object struct {
+	Type    string
+	Name    string
+	TblName string
+	SQL     string
+}
+
+// Database is the content of a database file
+type Database struct {
+	Tables  []*Table
+	Objects []Object
+
+	changes     uint32 // file change counter
+	cookie      uint32 // schema cookie
+	userVersion uint32
+	appID       uint32
+}
+
+// Table returns the table called name, nil without one
+func (db *Database) Table(name string) *Table {
+	for _, t := range db.Tables {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// CreateTable returns the table called name, adding it when missing
+func (db *Database) CreateTable(name, sql string) *Table {
+	if t := db.Table(name); t != nil {
+		return t
+	}
+	t := &Table{Name: name, SQL: sql}
+	db.Tables = append(db.Tables, t)
+	return t
+}
+
+type reader struct {
+	data   []byte
+	size   int // page size
+	usable int
+}
+
+// ReadFile loads every table of the database at path
+func ReadFile(path string) (*Database, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 100 || string(data[:16]) != "SQLite format 3\x00" {
+		return nil, fmt.Errorf("%s is not an SQLite database", path)
+	}
+	// A journal left by a crashed writer or changes still in the write-ahead log
+	for _, suffix := range []string{"-journal", "-wal"} {
+		if info, err := os.Stat(path + suffix); err == nil && info.Size() > 0 {
+			return nil, fmt.Errorf("%s has pending changes in %s%s, open it once with sqlite3 first", path, filepath.Base(path), suffix)
+		}
+	}
+
+	r := &reader{data: data, size: int(binary.BigEndian.Uint16(data[16:]))}
+	if r.size == 1 {
+		r.size = 65536
+	}
+	r.usable = r.size - int(data[20])
+	if r.size < 512 || r.size&(r.size-1) != 0 || len(data)%r.size != 0 || r.usable < 480 {
+		return nil, fmt.Errorf("%s: invalid page size %d", path, r.size)
+	}
+	if enc := binary.BigEndian.Uint32(data[56:]); enc > 1 {
+		return nil, fmt.Errorf("%s: only UTF-8 databases are supported", path)
+	}
+
+	db := &Database{
+		changes:     binary.BigEndian.Uint32(data[24:]),
+		cookie:      binary.BigEndian.Uint32(data[40:]),
+		userVersion: binary.BigEndian.Uint32(data[60:]),
+		appID:       binary.BigEndian.Uint32(data[68:]),
+	}
+	var schema []Row
+	if err := r.walk(1, 0, func(row Row) { schema = append(schema, row) }); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, e := range schema {
+		if len(e.Values) < 5 {
+			return nil, fmt.Errorf("%s: invalid schema entry", path)
+		}
+		kind, _ := e.Values[0].(string)
+		name, _ := e.Values[1].(string)
+		tblName, _ := e.Values[2].(string)
+		root, _ := e.Values[3].(int64)
+		sql, _ := e.Values[4].(string)
+		switch {
+		case kind == "index":
+			return nil, fmt.Errorf("%s: index %s is not supported, drop it or index a copy", path, name)
+		case kind == "table" && root > 0:
+			t := &Table{Name: name, SQL: sql}
+			real := realColumns(sql)
+			if err := r.walk(int(root), 0, func(row Row) {
+				// SQLite stores whole REAL values as integers, they read back as floats
+				for i, v := range row.Values {
+					if x, ok := v.(int64); ok && i < len(real) && real[i] {
+						row.Values[i] = float64(x)
+					}
+				}
+				t.Rows = append(t.Rows, row)
+			}); err != nil {
+				return nil, fmt.Errorf("%s: table %s: %v", path, name, err)
+			}
+			db.Tables = append(db.Tables, t)
+		default:
+			db.Objects = append(db.Objects, Object{Type: kind, Name: name, TblName: tblName, SQL: sql})
+		}
+	}
+	return db, nil
+}
+
+// Which columns of a CREATE TABLE statement have REAL affinity, by the rules
+// of section 3.1 of https://sqlite.org/datatype3.html
+func realColumns(sql string) []bool {
+	open, end := strings.IndexByte(sql, '('), strings.LastIndexByte(sql, ')')
+	if open < 0 || end < open {
+		return nil
+	}
+	var defs []string
+	depth, start := 0, open+1
+	for i := open + 1; i < end; i++ {
+		switch sql[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				defs, start = append(defs, sql[start:i]), i+1
+			}
+		}
+	}
+	defs = append(defs, sql[start:end])
+
+	var real []bool
+	for _, def := range defs {
+		words := strings.Fields(strings.ToUpper(def))
+		if len(words) == 0 {
+			continue
+		}
+		switch words[0] {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			continue // table constraints, not columns
+		}
+		var typ []string
+	words:
+		for _, w := range words[1:] {
+			switch w {
+			case "CONSTRAINT", "PRIMARY", "NOT", "NULL", "UNIQUE", "CHECK", "DEFAULT", "COLLATE", "REFERENCES", "GENERATED", "AS":
+				break words
+			}
+			typ = append(typ, w)
+		}
+		t := strings.Join(typ, " ")
+		r := !strings.Contains(t, "INT") && !strings.Contains(t, "CHAR") && !strings.Contains(t, "CLOB") &&
+			!strings.Contains(t, "TEXT") && !strings.Contains(t, "BLOB") &&
+			(strings.Contains(t, "REAL") || strings.Contains(t, "FLOA") || strings.Contains(t, "DOUB"))
+		real = append(real, r)
+	}
+	return real
+}
+
+func (r *reader) page(n int) ([]byte, error) {
+	if n < 1 || n*r.size > len(r.data) {
+		return nil, fmt.Errorf("page %d out of range", n)
+	}
+	return r.data[(n-1)*r.size : n*r.size], nil
+}
+
+// Visiting the rows of the table b-tree rooted at page n in rowid order
+func (r *reader) walk(n, depth int, fn func(Row)) error {
+	if depth > 20 {
+		return errors.New("b-tree too deep")
+	}
+	p, err := r.page(n)
+	if err != nil {
+		return err
+	}
+	off := 0
+	if n == 1 {
+		off = 100
+	}
+	kind := p[off]
+	cells := int(binary.BigEndian.Uint16(p[off+3:]))
+	hdr := 8
+	switch kind {
+	case 0x0D:
+	case 0x05:
+		hdr = 12
+	case 0x02, 0x0A:
+		return errors.New("WITHOUT ROWID tables are not supported")
+	default:
+		return fmt.Errorf("page %d has unknown type %d", n, kind)
+	}
+	if off+hdr+2*cells > r.usable {
+		return fmt.Errorf("page %d has too many cells", n)
+	}
+
+	for i := 0; i < cells; i++ {
+		at := int(binary.BigEndian.Uint16(p[off+hdr+2*i:]))
+		if at >= r.usable {
+			return fmt.Errorf("page %d: cell %d out of range", n, i)
+		}
+		cell := p[at:r.usable]
+		if kind == 0x05 {
+			if len(cell) < 4 {
+				return fmt.Errorf("page %d: truncated cell", n)
+			}
+			if err := r.walk(int(binary.BigEndian.Uint32(cell)), depth+1, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		row, err := r.leafCell(cell)
+		if err != nil {
+			return fmt.Errorf("page %d: %v", n, err)
+		}
+		fn(row)
+	}
+	if kind == 0x05 {
+		return r.walk(int(binary.BigEndian.Uint32(p[off+8:])), depth+1, fn)
+	}
+	return nil
+}
+
+func (r *reader) leafCell(cell []byte) (Row, error) {
+	size, n1 := getVarint(cell)
+	rowid, n2 := getVarint(cell[n1:])
+	if n1 == 0 || n2 == 0 || size > math.MaxInt32 {
+		return Row{}, errors.New("invalid cell")
+	}
+	cell = cell[n1+n2:]
+	local := localPayload(int(size), r.usable)
+	if local > len(cell) {
+		return Row{}, errors.New("truncated cell")
+	}
+	payload := append([]byte(nil), cell[:local]...)
+	if local < int(size) {
+		if len(cell) < local+4 {
+			return Row{}, errors.New("truncated cell")
+		}
+		next := int(binary.BigEndian.Uint32(cell[local:]))
+		for len(payload) < int(size) {
+			if next == 0 {
+				return Row{}, errors.New("overflow chain ends early")
+			}
+			p, err := r.page(next)
+			if err != nil {
+				return Row{}, err
+			}
+			chunk := p[4:r.usable]
+			if rest := int(size) - len(payload); len(chunk) > rest {
+				chunk = chunk[:rest]
+			}
+			payload = append(payload, chunk...)
+			next = int(binary.BigEndian.Uint32(p))
+		}
+	}
+	values, err := decodeRecord(payload)
+	if err != nil {
+		return Row{}, err
+	}
+	return Row{ID: int64(rowid), Values: values}, nil
+}
+
+// How much of a payload of size bytes a table leaf cell holds, the rest goes
+// to overflow pages
+func localPayload(size, usable int) int {
+	maxLocal := usable - 35
+	if size <= maxLocal {
+		return size
+	}
+	minLocal := (usable-12)*32/255 - 23
+	k := minLocal + (size-minLocal)%(usable-4)
+	if k <= maxLocal {
+		return k
+	}
+	return minLocal
+}
+
+func getVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 8; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	if len(b) < 9 {
+		return 0, 0
+	}
+	return v<<8 | uint64(b[8]), 9
+}
+
+func putVarint(v uint64) []byte {
+	if v > 1<<56-1 {
+		b := make([]byte, 9)
+		b[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			b[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return b
+	}
+	var tmp [8]byte
+	n := 0
+	for {
+		tmp[n] = byte(v & 0x7f)
+		n++
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = tmp[n-1-i]
+		if i < n-1 {
+			b[i] |= 0x80
+		}
+	}
+	return b
+}
+
+func decodeRecord(p []byte) ([]any, error) {
+	hdrLen, n := getVarint(p)
+	if n == 0 || hdrLen > uint64(len(p)) || int(hdrLen) < n {
+		return nil, errors.New("invalid record header")
+	}
+	header, body := p[n:hdrLen], p[hdrLen:]
+	var values []any
+	for len(header) > 0 {
+		t, m := getVarint(header)
+		if m == 0 {
+			return nil, errors.New("invalid record header")
+		}
+		header = header[m:]
+
+		size := 0
+		switch {
+		case t >= 1 && t <= 4:
+			size = int(t)
+		case t == 5:
+			size = 6
+		case t == 6 || t == 7:
+			size = 8
+		case t == 10 || t == 11:
+			return nil, fmt.Errorf("reserved serial type %d", t)
+		case t >= 12:
+			size = int((t - 12) / 2)
+		}
+		if size > len(body) {
+			return nil, errors.New("truncated record")
+		}
+		v := body[:size]
+		body = body[size:]
+
+		switch {
+		case t == 0:
+			values = append(values, nil)
+		case t <= 6:
+			// Big-endian two's complement of 1 to 8 bytes
+			x := int64(int8(v[0]))
+			for _, c := range v[1:] {
+				x = x<<8 | int64(c)
+			}
+			values = append(values, x)
+		case t == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(v)))
+		case t == 8, t == 9:
+			values = append(values, int64(t-8))
+		case t%2 == 0:
+			values = append(values, append([]byte{}, v...))
+		default:
+			values = append(values, string(v))
+		}
+	}
+	return values, nil
+}
+
+func encodeRecord(values []any) ([]byte, error) {
+	var types, body []byte
+	for _, v := range values {
+		switch x := v.(type) {
+		case nil:
+			types = append(types, 0)
+		case bool:
+			if x {
+				types = append(types, 9)
+			} else {
+				types = append(types, 8)
+			}
+		case int:
+			types, body = appendInt(types, body, int64(x))
+		case int64:
+			types, body = appendInt(types, body, x)
+		case float64:
+			types = append(types, 7)
+			body = binary.BigEndian.AppendUint64(body, math.Float64bits(x))
+		case string:
+			types = append(types, putVarint(uint64(13+2*len(x)))...)
+			body = append(body, x...)
+		case []byte:
+			types = append(types, putVarint(uint64(12+2*len(x)))...)
+			body = append(body, x...)
+		default:
+			return nil, fmt.Errorf("unsupported value type %T", v)
+		}
+	}
+	// The header length counts its own varint
+	hdrLen := len(types) + 1
+	for len(putVarint(uint64(hdrLen))) != hdrLen-len(types) {
+		hdrLen = len(types) + len(putVarint(uint64(hdrLen)))
+	}
+	rec := append(putVarint(uint64(hdrLen)), types...)
+	return append(rec, body...), nil
+}
+
+// Integers in the smallest serial type that holds them
+func appendInt(types, body []byte, x int64) ([]byte, []byte) {
+	if x == 0 || x == 1 {
+		return append(types, byte(8+x)), body
+	}
+	for _, s := range []struct {
+		t    byte
+		size int
+	}{{1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 6}} {
+		limit := int64(1) << (8*s.size - 1)
+		if x >= -limit && x < limit {
+			var b [8]byte
+			binary.BigEndian.PutUint64(b[:], uint64(x))
+			return append(types, s.t), append(body, b[8-s.size:]...)
+		}
+	}
+	return append(types, 6), binary.BigEndian.AppendUint64(body, uint64(x))
+}
@@ -0,0 +1,228 @@
+package sqlite
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Rows of the items table in testdata/fixture.db, as sqlite3 made them:
+//
+//	CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT, score REAL, big INTEGER, note TEXT, data BLOB);
+//	WITH RECURSIVE c(i) AS (SELECT 1 UNION ALL SELECT i+1 FROM c WHERE i < 1500)
+//	INSERT INTO items SELECT i, 'item-' || i, i * 0.25 - 100,
+//	  CASE i % 3 WHEN 0 THEN 9223372036854775807 WHEN 1 THEN -9223372036854775808 ELSE -i * 1000003 END,
+//	  CASE WHEN i % 500 = 0 THEN hex(zeroblob(6000)) ELSE NULL END,
+//	  CASE WHEN i % 10 = 0 THEN x'00ff10' ELSE NULL END
+//	FROM c;
+//	DELETE FROM items WHERE id % 7 = 0;
+//	CREATE TABLE meta (key TEXT, value);
+//	INSERT INTO meta VALUES ('schema', 3), ('pi', 3.5), ('empty', ''), ('none', NULL);
+//	CREATE VIEW failing AS SELECT name FROM items WHERE score < 0;
+func fixtureItems() []Row {
+	var rows []Row
+	for i := int64(1); i <= 1500; i++ {
+		if i%7 == 0 {
+			continue
+		}
+		big := []int64{math.MaxInt64, math.MinInt64, -i * 1000003}[i%3]
+		var note, data any
+		if i%500 == 0 {
+			note = strings.Repeat("0", 12000)
+		}
+		if i%10 == 0 {
+			data = []byte{0x00, 0xff, 0x10}
+		}
+		// The INTEGER PRIMARY KEY is the rowid, stored as NULL in the record
+		rows = append(rows, Row{ID: i, Values: []any{nil, "item-" + strconv.FormatInt(i, 10), float64(i)*0.25 - 100, big, note, data}})
+	}
+	return rows
+}
+
+// Comparing rows value by value, so a long payload doesn't flood the failure
+func checkRows(t *testing.T, table string, got, want []Row) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: %d rows, want %d", table, len(got), len(want))
+	}
+	for i := range got {
+		if got[i].ID != want[i].ID || len(got[i].Values) != len(want[i].Values) {
+			t.Fatalf("%s row %d: rowid %d with %d values, want %d with %d", table, i, got[i].ID, len(got[i].Values), want[i].ID, len(want[i].Values))
+		}
+		for j, v := range got[i].Values {
+			if w := want[i].Values[j]; !reflect.DeepEqual(v, w) {
+				t.Fatalf("%s rowid %d column %d: got %T %.40v, want %T %.40v", table, got[i].ID, j, v, v, w, w)
+			}
+		}
+	}
+}
+
+func checkFixture(t *testing.T, db *Database) {
+	t.Helper()
+	items := db.Table("items")
+	if items == nil {
+		t.Fatal("no items table")
+	}
+	checkRows(t, "items", items.Rows, fixtureItems())
+	meta := db.Table("meta")
+	if meta == nil {
+		t.Fatal("no meta table")
+	}
+	checkRows(t, "meta", meta.Rows, []Row{
+		{1, []any{"schema", int64(3)}},
+		{2, []any{"pi", 3.5}},
+		{3, []any{"empty", ""}},
+		{4, []any{"none", nil}},
+	})
+	if len(db.Objects) != 1 || db.Objects[0].Type != "view" || db.Objects[0].Name != "failing" || db.Objects[0].TblName != "failing" {
+		t.Errorf("objects %+v, want the failing view", db.Objects)
+	}
+}
+
+func TestReadSQLite3File(t *testing.T) {
+	db, err := ReadFile(filepath.Join("testdata", "fixture.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFixture(t, db)
+}
+
+// Rewriting a file sqlite3 made keeps every row, view and header field
+func TestRewriteSQLite3File(t *testing.T) {
+	db, err := ReadFile(filepath.Join("testdata", "fixture.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "rewritten.db")
+	if err := WriteFile(path, db); err != nil {
+		t.Fatal(err)
+	}
+	again, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFixture(t, again)
+	if again.changes != db.changes || again.cookie != db.cookie {
+		t.Errorf("change counter %d and schema cookie %d, want %d and %d", again.changes, again.cookie, db.changes, db.cookie)
+	}
+	sqlite3Check(t, path, "SELECT count(*), sum(length(note)), sum(big = 9223372036854775807) FROM items", "1286|36000|429")
+}
+
+func TestRoundTrip(t *testing.T) {
+	values := [][]any{
+		{nil, nil},
+		{int64(0), int64(1)},
+		{int64(-1), int64(127)},
+		{int64(-128), int64(128)},
+		{int64(-32769), int64(1 << 23)},
+		{int64(-1 << 31), int64(1<<31 - 1)},
+		{int64(-1 << 40), int64(1 << 47)},
+		{int64(math.MinInt64), int64(math.MaxInt64)},
+		{int64(-1 << 48), int64(1 << 55)},
+		{0.0, -1.5},
+		{math.Inf(1), math.SmallestNonzeroFloat64},
+		{"", []byte{}},
+		{"naïve ✓", []byte{0, 1, 2}},
+	}
+	for _, size := range []int{3000, 4061, 4062, 5000, 9000, 40000} {
+		// Payloads around the local limit and over several overflow pages
+		values = append(values, []any{strings.Repeat("x", size), bytes.Repeat([]byte{0xab}, size)})
+	}
+	db := &Database{userVersion: 7, appID: 0x6e770001}
+	table := db.CreateTable("t", "CREATE TABLE t (a, b)")
+	// Enough rows for interior pages two levels deep
+	for i := 0; i < 20000; i++ {
+		table.Append(values[i%len(values)]...)
+	}
+	// Rowids don't have to be dense or positive
+	table.Rows = append(table.Rows, Row{ID: 1 << 40, Values: []any{int64(1), nil}})
+	table.Rows = append([]Row{{ID: -5, Values: []any{"negative rowid", nil}}}, table.Rows...)
+	db.CreateTable("empty", "CREATE TABLE empty (x)")
+	db.Objects = append(db.Objects, Object{Type: "view", Name: "v", TblName: "v", SQL: "CREATE VIEW v AS SELECT a FROM t"})
+
+	path := filepath.Join(t.TempDir(), "round.db")
+	if err := WriteFile(path, db); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.userVersion != 7 || got.appID != 0x6e770001 {
+		t.Errorf("user version %d and application id %#x", got.userVersion, got.appID)
+	}
+	if len(got.Tables) != 2 || got.Tables[1].Name != "empty" || len(got.Tables[1].Rows) != 0 {
+		t.Fatalf("tables %v", got.Tables)
+	}
+	checkRows(t, "t", got.Table("t").Rows, table.Rows)
+	if !reflect.DeepEqual(got.Objects, db.Objects) {
+		t.Errorf("objects %+v, want %+v", got.Objects, db.Objects)
+	}
+	sqlite3Check(t, path, "SELECT count(*), min(rowid), max(rowid), sum(typeof(a) = 'real') FROM t", "20002|-5|1099511627776|2106")
+}
+
+func TestRealColumns(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want []bool
+	}{
+		{"CREATE TABLE t (a, b)", []bool{false, false}},
+		{"CREATE TABLE t (id INTEGER PRIMARY KEY, bytes REAL, ratio DOUBLE PRECISION NOT NULL, f FLOAT DEFAULT 0.5)", []bool{false, true, true, true}},
+		{"CREATE TABLE t (p POINT, n NUMERIC(10, 2), x FLOATING POINT, c CHARFLOAT, UNIQUE (p, n))", []bool{false, false, false, false}},
+		{"CREATE TABLE t (d DECIMAL(10,5) CHECK (d > 0.5), r real)", []bool{false, true}},
+	}
+	for _, tt := range tests {
+		if got := realColumns(tt.sql); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestVarint(t *testing.T) {
+	for _, v := range []uint64{0, 127, 128, 16383, 16384, 1<<56 - 1, 1 << 56, math.MaxUint64} {
+		b := putVarint(v)
+		got, n := getVarint(b)
+		if got != v || n != len(b) || n > 9 {
+			t.Errorf("%d: encoded as %x, read back %d from %d bytes", v, b, got, n)
+		}
+	}
+}
+
+func TestReadRefused(t *testing.T) {
+	dir := t.TempDir()
+	junk := filepath.Join(dir, "junk.db")
+	os.WriteFile(junk, bytes.Repeat([]byte("x"), 4096), 0o644)
+	if _, err := ReadFile(junk); err == nil {
+		t.Error("read a file that isn't a database")
+	}
+
+	fixture, _ := os.ReadFile(filepath.Join("testdata", "fixture.db"))
+	pending := filepath.Join(dir, "pending.db")
+	os.WriteFile(pending, fixture, 0o644)
+	os.WriteFile(pending+"-journal", []byte("x"), 0o644)
+	if _, err := ReadFile(pending); err == nil || !strings.Contains(err.Error(), "pending changes") {
+		t.Errorf("got %v, want pending changes", err)
+	}
+}
+
+// Running query against path with sqlite3, when installed, and checking its
+// integrity and result
+func sqlite3Check(t *testing.T, path, query, want string) {
+	t.Helper()
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return
+	}
+	out, err := exec.Command("sqlite3", path, "PRAGMA integrity_check", query).CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3: %v: %s", err, out)
+	}
+	if got := strings.TrimSpace(string(out)); got != "ok\n"+want {
+		t.Errorf("sqlite3 printed %q, want %q", got, "ok\n"+want)
+	}
+}
@@ -0,0 +1,230 @@
+package sqlite
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Page types of table b-trees
+const (
+	leafPage     = 0x0D
+	interiorPage = 0x05
+)
+
+// Interior cells are a page number and a rowid varint, at most 13 bytes plus
+// their 2 byte pointer
+const interiorCellMax = 4 + 9 + 2
+
+type cell struct {
+	b     []byte
+	rowid int64
+}
+
+// A child page with the largest rowid under it
+type child struct {
+	page  int
+	rowid int64
+}
+
+type writer struct {
+	pages  [][]byte
+	usable int
+}
+
+func (w *writer) alloc() int {
+	w.pages = append(w.pages, make([]byte, pageSize))
+	return len(w.pages)
+}
+
+// WriteFile replaces the database at path with db. The new file is written
+// next to it and renamed over it, so readers never see half of it.
+func WriteFile(path string, db *Database) error {
+	w := &writer{usable: pageSize}
+	// Page 1 holds the header and the root of the schema table
+	w.alloc()
+
+	var schema []Row
+	for _, t := range db.Tables {
+		sort.SliceStable(t.Rows, func(i, j int) bool { return t.Rows[i].ID < t.Rows[j].ID })
+		for i := 1; i < len(t.Rows); i++ {
+			if t.Rows[i].ID == t.Rows[i-1].ID {
+				return fmt.Errorf("table %s: duplicate rowid %d", t.Name, t.Rows[i].ID)
+			}
+		}
+		root, err := w.tree(t.Rows, 0)
+		if err != nil {
+			return fmt.Errorf("table %s: %v", t.Name, err)
+		}
+		schema = append(schema, Row{ID: int64(len(schema) + 1), Values: []any{"table", t.Name, t.Name, int64(root), t.SQL}})
+	}
+	for _, o := range db.Objects {
+		schema = append(schema, Row{ID: int64(len(schema) + 1), Values: []any{o.Type, o.Name, o.TblName, int64(0), o.SQL}})
+	}
+	if _, err := w.tree(schema, 1); err != nil {
+		return fmt.Errorf("schema: %v", err)
+	}
+
+	db.changes++
+	db.cookie++
+	h := w.pages[0]
+	copy(h, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(h[16:], pageSize)
+	h[18], h[19] = 1, 1 // rollback journal
+	h[21], h[22], h[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(h[24:], db.changes)
+	binary.BigEndian.PutUint32(h[28:], uint32(len(w.pages)))
+	binary.BigEndian.PutUint32(h[40:], db.cookie)
+	binary.BigEndian.PutUint32(h[44:], 4) // schema format
+	binary.BigEndian.PutUint32(h[56:], 1) // UTF-8
+	binary.BigEndian.PutUint32(h[60:], db.userVersion)
+	binary.BigEndian.PutUint32(h[68:], db.appID)
+	binary.BigEndian.PutUint32(h[92:], db.changes)
+	binary.BigEndian.PutUint32(h[96:], 3045000)
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	for _, p := range w.pages {
+		if _, err := tmp.Write(p); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Building the b-tree of rows sorted by rowid and returning its root page.
+// A root of 1 puts the root on the first page, after the file header.
+func (w *writer) tree(rows []Row, root int) (int, error) {
+	cells := make([]cell, len(rows))
+	for i, row := range rows {
+		c, err := w.leafCell(row)
+		if err != nil {
+			return 0, err
+		}
+		cells[i] = c
+	}
+	off := 0
+	if root == 1 {
+		off = 100
+	}
+
+	// The whole table in its root page
+	used := off + 8
+	for _, c := range cells {
+		used += len(c.b) + 2
+	}
+	if used <= w.usable {
+		if root == 0 {
+			root = w.alloc()
+		}
+		w.writePage(root, off, leafPage, cells, 0)
+		return root, nil
+	}
+
+	// Filling leaves in order, then interior levels until one fits the root
+	var level []child
+	for i := 0; i < len(cells); {
+		used, j := 8, i
+		for j < len(cells) && used+len(cells[j].b)+2 <= w.usable {
+			used += len(cells[j].b) + 2
+			j++
+		}
+		page := w.alloc()
+		w.writePage(page, 0, leafPage, cells[i:j], 0)
+		level = append(level, child{page, cells[j-1].rowid})
+		i = j
+	}
+	for {
+		if (len(level)-1)*interiorCellMax <= w.usable-off-12 {
+			if root == 0 {
+				root = w.alloc()
+			}
+			w.writePage(root, off, interiorPage, interiorCells(level[:len(level)-1]), level[len(level)-1].page)
+			return root, nil
+		}
+		// Spreading children evenly, so no page is left with a single one
+		perPage := (w.usable-12)/interiorCellMax + 1
+		pages := (len(level) + perPage - 1) / perPage
+		var next []child
+		for p := 0; p < pages; p++ {
+			group := level[p*len(level)/pages : (p+1)*len(level)/pages]
+			page := w.alloc()
+			last := group[len(group)-1]
+			w.writePage(page, 0, interiorPage, interiorCells(group[:len(group)-1]), last.page)
+			next = append(next, last)
+			next[len(next)-1].page = page
+		}
+		level = next
+	}
+}
+
+// Interior cells point left of the largest rowid under each child
+func interiorCells(children []child) []cell {
+	cells := make([]cell, len(children))
+	for i, c := range children {
+		b := binary.BigEndian.AppendUint32(nil, uint32(c.page))
+		cells[i] = cell{b: append(b, putVarint(uint64(c.rowid))...), rowid: c.rowid}
+	}
+	return cells
+}
+
+// A leaf cell with as much of the record as fits, the rest goes on a chain of
+// overflow pages
+func (w *writer) leafCell(row Row) (cell, error) {
+	rec, err := encodeRecord(row.Values)
+	if err != nil {
+		return cell{}, err
+	}
+	b := append(putVarint(uint64(len(rec))), putVarint(uint64(row.ID))...)
+	local := localPayload(len(rec), w.usable)
+	b = append(b, rec[:local]...)
+	if local < len(rec) {
+		rest := rec[local:]
+		first := w.alloc()
+		b = binary.BigEndian.AppendUint32(b, uint32(first))
+		for page := first; len(rest) > 0; {
+			p := w.pages[page-1]
+			n := copy(p[4:w.usable], rest)
+			rest = rest[n:]
+			if len(rest) > 0 {
+				next := w.alloc()
+				binary.BigEndian.PutUint32(p, uint32(next))
+				page = next
+			}
+		}
+	}
+	return cell{b: b, rowid: row.ID}, nil
+}
+
+// Writing a b-tree page header at off with cells packed from the end of the page
+func (w *writer) writePage(n, off int, kind byte, cells []cell, right int) {
+	p := w.pages[n-1]
+	hdr := 8
+	p[off] = kind
+	if kind == interiorPage {
+		hdr = 12
+		binary.BigEndian.PutUint32(p[off+8:], uint32(right))
+	}
+	binary.BigEndian.PutUint16(p[off+3:], uint16(len(cells)))
+	end := w.usable
+	for i, c := range cells {
+		end -= len(c.b)
+		copy(p[end:], c.b)
+		binary.BigEndian.PutUint16(p[off+hdr+2*i:], uint16(end))
+	}
+	// The content area starts at the last cell, 0 stands for 65536
+	binary.BigEndian.PutUint16(p[off+5:], uint16(end))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	linux "netwatchd/netstat"
+)
+
+// SSIDUsage is the traffic of one wireless network during the window
+type SSIDUsage struct {
+	Interface string
+	SSID      string
+	Bridge    string
+	RxBytes   uint64 // received from clients
+	TxBytes   uint64 // sent to clients
+	Clients   []ClientUsage
+}
+
+// ClientUsage is one associated client's traffic during the window
+type ClientUsage struct {
+	MAC     string
+	Name    string
+	RxBytes uint64
+	TxBytes uint64
+	Signal  string
+}
+
+// Snapshotting wireless interface and station counters at start and end of the window
+func collectSSIDUsage(ctx context.Context, data *MonitoringData) {
+	ifaces, err := linux.GetWirelessInterfaces()
+	if err != nil || len(ifaces) == 0 {
+		return
+	}
+
+	type snapshot struct {
+		rx, tx   uint64
+		stations map[string]linux.Station
+	}
+	take := func() map[string]snapshot {
+		snaps := make(map[string]snapshot)
+		for _, iface := range ifaces {
+			rx, tx, err := linux.InterfaceBytes(iface.Name)
+			if err != nil {
+				continue
+			}
+			stations, _ := linux.GetStations(iface.Name)
+			snaps[iface.Name] = snapshot{rx, tx, stations}
+		}
+		return snaps
+	}
+
+	before := take()
+	<-ctx.Done()
+	after := take()
+
+	var usage []SSIDUsage
+	for _, iface := range ifaces {
+		b, ok1 := before[iface.Name]
+		a, ok2 := after[iface.Name]
+		if !ok1 || !ok2 {
+			continue
+		}
+		u := SSIDUsage{
+			Interface: iface.Name,
+			SSID:      iface.SSID,
+			Bridge:    iface.Bridge,
+			RxBytes:   counterDelta(b.rx, a.rx),
+			TxBytes:   counterDelta(b.tx, a.tx),
+		}
+		for mac, st := range a.stations {
+			// Clients that joined during the window count from zero
+			old := b.stations[mac]
+			u.Clients = append(u.Clients, ClientUsage{
+				MAC:     mac,
+				RxBytes: counterDelta(old.RxBytes, st.RxBytes),
+				TxBytes: counterDelta(old.TxBytes, st.TxBytes),
+				Signal:  st.Signal,
+			})
+		}
+		sort.Slice(u.Clients, func(i, j int) bool {
+			return u.Clients[i].RxBytes+u.Clients[i].TxBytes > u.Clients[j].RxBytes+u.Clients[j].TxBytes
+		})
+		usage = append(usage, u)
+	}
+
+	data.mu.Lock()
+	for i := range usage {
+		for j := range usage[i].Clients {
+			usage[i].Clients[j].Name = data.inventory.DescribeMAC(usage[i].Clients[j].MAC)
+		}
+	}
+	data.ssids = usage
+	data.mu.Unlock()
+}
+
+// Growth of a counter, zero if it was reset
+func counterDelta(before, after uint64) uint64 {
+	if after < before {
+		return 0
+	}
+	return after - before
+}
+
+func printSSIDReport(usage []SSIDUsage) {
+	if len(usage) == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("WIRELESS NETWORKS")
+	for _, u := range usage {
+		name := u.SSID
+		if name == "" {
+			name = "(unknown SSID)"
+		}
+		where := u.Interface
+		if u.Bridge != "" {
+			where += " on " + u.Bridge
+		}
+		fmt.Printf("%s [%s]: %.2f MB from clients | %.2f MB to clients | %d clients\n",
+			name, where, float64(u.RxBytes)/(1024*1024), float64(u.TxBytes)/(1024*1024), len(u.Clients))
+		for i, c := range u.Clients {
+			if i == 10 {
+				fmt.Printf("  ... %d more clients\n", len(u.Clients)-i)
+				break
+			}
+			label := c.MAC
+			if c.Name != "" {
+				label += " " + c.Name
+			}
+			fmt.Printf("  %-40s up %.2f MB | down %.2f MB | signal %s\n",
+				label, float64(c.RxBytes)/(1024*1024), float64(c.TxBytes)/(1024*1024), orNone(c.Signal))
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// Parsing -start-at as a clock time today (or tomorrow once passed) or a full RFC 3339 timestamp
+func parseStartAt(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		t, err := time.ParseInLocation(layout, s, now.Location())
+		if err != nil {
+			continue
+		}
+		at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location())
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		return at, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid start time %q, use HH:MM:SS or RFC 3339", s)
+}
+
+// Working out when the capture should begin, zero when it starts right away
+func scheduledStart(startAt string, delay time.Duration, now time.Time) (time.Time, error) {
+	if startAt != "" && delay > 0 {
+		return time.Time{}, errors.New("use either -start-at or -start-delay, not both")
+	}
+	if delay < 0 {
+		return time.Time{}, errors.New("start delay must not be negative")
+	}
+	if startAt != "" {
+		return parseStartAt(startAt, now)
+	}
+	if delay > 0 {
+		return now.Add(delay), nil
+	}
+	return time.Time{}, nil
+}
+
+// Sleeping until the scheduled start. Returns false if interrupted with Ctrl-C.
+func waitForStart(at time.Time) bool {
+	fmt.Printf("Waiting until %s to start (%s)...\n", at.Format("2006-01-02 15:04:05 MST"), time.Until(at).Round(time.Second))
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-interrupt:
+		fmt.Println("Cancelled before the capture started")
+		return false
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// SpanningTreeEvent is a topology change or root bridge change seen in a BPDU
+type SpanningTreeEvent struct {
+	Time    time.Time
+	Bridge  string
+	Message string
+}
+
+// SpanningTreeTracker follows the root bridge and topology changes announced in BPDUs.
+// It is guarded by the MonitoringData mutex.
+type SpanningTreeTracker struct {
+	root    string
+	bpdus   int
+	changes int
+	lastTC  map[string]bool
+	events  []SpanningTreeEvent
+}
+
+func NewSpanningTreeTracker() *SpanningTreeTracker {
+	return &SpanningTreeTracker{lastTC: make(map[string]bool)}
+}
+
+// Observe returns an alert message when the root bridge changes
+func (t *SpanningTreeTracker) Observe(p *netwatch.Packet) string {
+	if p.BPDU == nil {
+		return ""
+	}
+	b := p.BPDU
+	t.bpdus++
+
+	// TCN BPDUs have no root information, they only signal a change
+	if b.Type == "0x80" {
+		t.changes++
+		t.events = append(t.events, SpanningTreeEvent{p.Time, p.SrcMAC, "topology change notification"})
+		return ""
+	}
+
+	// The TC flag stays set for a while, only count the rising edge per bridge
+	if b.TopologyChange && !t.lastTC[b.Bridge] {
+		t.changes++
+		t.events = append(t.events, SpanningTreeEvent{p.Time, b.Bridge, "topology change flag set"})
+	}
+	t.lastTC[b.Bridge] = b.TopologyChange
+
+	root := b.Root()
+	if root == "" || root == t.root {
+		return ""
+	}
+	previous := t.root
+	t.root = root
+	if previous == "" {
+		t.events = append(t.events, SpanningTreeEvent{p.Time, b.Bridge, "root bridge is " + root})
+		return ""
+	}
+	msg := fmt.Sprintf("spanning tree root bridge changed from %s to %s", previous, root)
+	t.events = append(t.events, SpanningTreeEvent{p.Time, b.Bridge, msg})
+	return msg
+}
+
+func printSpanningTreeReport(t *SpanningTreeTracker) {
+	if t.bpdus == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("SPANNING TREE")
+	fmt.Printf("BPDUs: %d | topology changes: %d | root bridge: %s\n", t.bpdus, t.changes, t.root)
+	for _, e := range t.events {
+		fmt.Printf("%s %s: %s\n", e.Time.Format("15:04:05"), e.Bridge, e.Message)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CheckSpec configures one synthetic transaction
+type CheckSpec struct {
+	Name         string `json:"name,omitempty"`
+	Type         string `json:"type"`
+	Target       string `json:"target"`
+	Server       string `json:"server,omitempty"`
+	Interval     int    `json:"interval,omitempty"`
+	Timeout      int    `json:"timeout,omitempty"`
+	MaxLatencyMs int    `json:"max_latency_ms,omitempty"`
+}
+
+// Parsing -check values: http:<url>, tcp:<host:port> or dns:<name>[@server]
+func parseCheckSpec(s string) (CheckSpec, error) {
+	kind, target, ok := strings.Cut(s, ":")
+	if !ok || target == "" {
+		return CheckSpec{}, fmt.Errorf("check %q must look like type:target", s)
+	}
+	spec := CheckSpec{Type: kind, Target: target}
+	if kind == "dns" {
+		spec.Target, spec.Server, _ = strings.Cut(target, "@")
+	}
+	return spec, spec.validate()
+}
+
+func (c CheckSpec) validate() error {
+	switch c.Type {
+	case "http", "tcp", "dns":
+	default:
+		return fmt.Errorf("check type %q must be http, tcp or dns", c.Type)
+	}
+	if c.Target == "" {
+		return fmt.Errorf("%s check needs a target", c.Type)
+	}
+	if c.Type == "http" && !strings.HasPrefix(c.Target, "http://") && !strings.HasPrefix(c.Target, "https://") {
+		return fmt.Errorf("http check target %q must be a URL", c.Target)
+	}
+	if c.Interval < 0 || c.Timeout < 0 || c.MaxLatencyMs < 0 {
+		return fmt.Errorf("%s check interval, timeout and max_latency_ms must not be negative", c.Type)
+	}
+	return nil
+}
+
+func (c CheckSpec) label() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	if c.Server != "" {
+		return fmt.Sprintf("%s %s@%s", c.Type, c.Target, c.Server)
+	}
+	return c.Type + " " + c.Target
+}
+
+// checkList collects repeated -check flags
+type checkList []CheckSpec
+
+func (l *checkList) String() string {
+	var parts []string
+	for _, c := range *l {
+		parts = append(parts, c.label())
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (l *checkList) Set(v string) error {
+	spec, err := parseCheckSpec(v)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, spec)
+	return nil
+}
+
+// checkBucket holds the results of one check within one report bucket
+type checkBucket struct {
+	OK      int
+	Failed  int
+	Latency time.Duration
+}
+
+// SyntheticCheck is a running check with its results per bucket
+type SyntheticCheck struct {
+	Spec    CheckSpec
+	Buckets []checkBucket
+	current checkBucket
+	failRun int
+}
+
+// Closing the current result bucket. Callers hold data.mu.
+func (data *MonitoringData) rotateSyntheticChecks() {
+	for _, c := range data.syntheticChecks {
+		c.Buckets = append(c.Buckets, c.current)
+		c.current = checkBucket{}
+	}
+}
+
+func runCheck(ctx context.Context, spec CheckSpec, timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+	switch spec.Type {
+	case "http":
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, spec.Target, nil)
+		if err != nil {
+			return 0, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return time.Since(start), fmt.Errorf("HTTP %s", resp.Status)
+		}
+	case "tcp":
+		if !probeTCP(spec.Target, timeout) {
+			return 0, fmt.Errorf("connect failed")
+		}
+	case "dns":
+		if _, ok := resolveOnce(ctx, spec.Server, spec.Target); !ok {
+			return 0, fmt.Errorf("lookup failed")
+		}
+	}
+	return time.Since(start), nil
+}
+
+// Running one synthetic check on its interval until the capture ends
+func monitorSyntheticCheck(ctx context.Context, data *MonitoringData, c *SyntheticCheck, defaultInterval time.Duration) {
+	interval := defaultInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	if c.Spec.Interval > 0 {
+		interval = time.Duration(c.Spec.Interval) * time.Second
+	}
+	timeout := 5 * time.Second
+	if c.Spec.Timeout > 0 {
+		timeout = time.Duration(c.Spec.Timeout) * time.Second
+	}
+	maxLatency := time.Duration(c.Spec.MaxLatencyMs) * time.Millisecond
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			latency, err := runCheck(ctx, c.Spec, timeout)
+			if ctx.Err() != nil {
+				return
+			}
+
+			data.mu.Lock()
+			if err != nil {
+				c.current.Failed++
+				c.failRun++
+				if c.failRun == 2 {
+					data.raiseAlert("synthetic", c.Spec.label(), fmt.Sprintf("%s failing: %v", c.Spec.label(), err), now)
+				}
+			} else {
+				if c.failRun >= 2 {
+					data.resolveAlert("synthetic", c.Spec.label(), c.Spec.label()+" recovered", now)
+				}
+				c.failRun = 0
+				c.current.OK++
+				c.current.Latency += latency
+				if maxLatency > 0 && latency > maxLatency {
+					data.addAlert("synthetic", fmt.Sprintf("%s took %s (limit %s)", c.Spec.label(), latency.Round(time.Millisecond), maxLatency), now)
+				}
+			}
+			data.mu.Unlock()
+		}
+	}
+}
+
+func printSyntheticReport(checks []*SyntheticCheck, bucket time.Duration) {
+	if len(checks) == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("SYNTHETIC CHECKS")
+	for _, c := range checks {
+		var total checkBucket
+		fmt.Println(c.Spec.label())
+		for i, b := range c.Buckets {
+			total.OK += b.OK
+			total.Failed += b.Failed
+			total.Latency += b.Latency
+			if b.OK+b.Failed == 0 {
+				continue
+			}
+			fmt.Printf("  %s: %d/%d ok%s\n", bucketLabel(i, bucket), b.OK, b.OK+b.Failed, avgLatency(b))
+		}
+		if runs := total.OK + total.Failed; runs > 0 {
+			fmt.Printf("  total: %d/%d ok (%.1f%%)%s\n", total.OK, runs, float64(total.OK)/float64(runs)*100, avgLatency(total))
+		}
+	}
+}
+
+func avgLatency(b checkBucket) string {
+	if b.OK == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", avg %s", (b.Latency / time.Duration(b.OK)).Round(time.Millisecond))
+}
@@ -0,0 +1,50 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32           = syscall.NewLazyDLL("kernel32.dll")
+	procGetConsoleMode = kernel32.NewProc("GetConsoleMode")
+	procSetConsoleMode = kernel32.NewProc("SetConsoleMode")
+)
+
+const (
+	enableEchoInput = 0x0004
+	enableLineInput = 0x0002
+
+	enableVirtualTerminalProcessing = 0x0004
+)
+
+// Switching the console to unbuffered, no-echo input. Returns a restore func.
+func makeRaw() (func(), error) {
+	handle := os.Stdin.Fd()
+	var mode uint32
+	ret, _, _ := procGetConsoleMode.Call(handle, uintptr(unsafe.Pointer(&mode)))
+	if ret == 0 {
+		return nil, fmt.Errorf("GetConsoleMode failed")
+	}
+	raw := mode &^ (enableEchoInput | enableLineInput)
+	ret, _, _ = procSetConsoleMode.Call(handle, uintptr(raw))
+	if ret == 0 {
+		return nil, fmt.Errorf("SetConsoleMode failed")
+	}
+	return func() {
+		procSetConsoleMode.Call(handle, uintptr(mode))
+	}, nil
+}
+
+// Letting the console interpret the ANSI sequences of the dashboard
+func enableANSI() {
+	handle := os.Stdout.Fd()
+	var mode uint32
+	if ret, _, _ := procGetConsoleMode.Call(handle, uintptr(unsafe.Pointer(&mode))); ret != 0 {
+		procSetConsoleMode.Call(handle, uintptr(mode|enableVirtualTerminalProcessing))
+	}
+}
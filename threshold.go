@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// ThresholdRule alerts when a bucket metric crosses a value for a number of
+// consecutive buckets, and resolves once it's back
+type ThresholdRule struct {
+	Name   string `json:"name,omitempty"`
+	Metric string `json:"metric"` // bandwidth, captured, packets or pps
+	Op     string `json:"op"`     // > or <
+	Value  string `json:"value"`  // e.g. 50MB/min, 10000 or 500/s, per bucket without a unit
+	For    int    `json:"for,omitempty"`
+}
+
+// Metrics a rule can watch, with whether values are byte sizes
+var thresholdMetrics = map[string]bool{
+	"bandwidth": true,  // bytes on the adapter
+	"captured":  true,  // bytes captured
+	"packets":   false, // packets captured
+	"pps":       false, // average packets per second
+}
+
+func (r ThresholdRule) label() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	s := fmt.Sprintf("%s %s %s", r.Metric, r.Op, r.Value)
+	if r.For > 1 {
+		s += fmt.Sprintf(" for %d", r.For)
+	}
+	return s
+}
+
+// Parsing -threshold values: [name=]<metric> <op> <value>[ for <n>],
+// e.g. "bandwidth > 50MB/min" or "pps > 10000 for 3"
+func parseThresholdRule(v string) (ThresholdRule, error) {
+	var r ThresholdRule
+	if name, rest, ok := strings.Cut(v, "="); ok {
+		r.Name, v = strings.TrimSpace(name), rest
+	}
+	fields := strings.Fields(v)
+	if len(fields) == 5 && fields[3] == "for" {
+		n, err := strconv.Atoi(fields[4])
+		if err != nil {
+			return r, fmt.Errorf("threshold %q: invalid bucket count %q", v, fields[4])
+		}
+		r.For, fields = n, fields[:3]
+	}
+	if len(fields) != 3 {
+		return r, fmt.Errorf("threshold %q must look like \"bandwidth > 50MB/min\" or \"pps > 10000 for 3\"", v)
+	}
+	r.Metric, r.Op, r.Value = fields[0], fields[1], fields[2]
+	_, err := r.compile(time.Minute)
+	return r, err
+}
+
+// A rule with its value as a per bucket number
+type compiledThreshold struct {
+	ThresholdRule
+	limit  float64
+	streak int
+	firing bool
+}
+
+func (r ThresholdRule) compile(bucket time.Duration) (*compiledThreshold, error) {
+	bytes, ok := thresholdMetrics[r.Metric]
+	if !ok {
+		return nil, fmt.Errorf("unknown metric %q, use bandwidth, captured, packets or pps", r.Metric)
+	}
+	if r.Op != ">" && r.Op != "<" {
+		return nil, fmt.Errorf("operator must be > or <, not %q", r.Op)
+	}
+	if r.For < 0 {
+		return nil, errors.New("for must not be negative")
+	}
+
+	value, per, _ := strings.Cut(r.Value, "/")
+	var n float64
+	if bytes {
+		b, err := parseBytes(value)
+		if err != nil {
+			return nil, err
+		}
+		n = float64(b)
+	} else {
+		var err error
+		if n, err = strconv.ParseFloat(value, 64); err != nil {
+			return nil, fmt.Errorf("invalid value %q", value)
+		}
+	}
+	switch {
+	case per == "":
+	case r.Metric == "pps":
+		return nil, errors.New("pps is already per second")
+	default:
+		unit, ok := map[string]time.Duration{"s": time.Second, "min": time.Minute, "h": time.Hour}[per]
+		if !ok {
+			return nil, fmt.Errorf("unknown rate unit /%s, use /s, /min or /h", per)
+		}
+		n *= bucket.Seconds() / unit.Seconds()
+	}
+	c := &compiledThreshold{ThresholdRule: r, limit: n}
+	if c.For == 0 {
+		c.For = 1
+	}
+	return c, nil
+}
+
+func (c *compiledThreshold) crossed(v float64) bool {
+	if c.Op == "<" {
+		return v < c.limit
+	}
+	return v > c.limit
+}
+
+func (c *compiledThreshold) format(v float64) string {
+	if thresholdMetrics[c.Metric] {
+		return fmt.Sprintf("%.2f MB", v/(1024*1024))
+	}
+	return fmt.Sprintf("%.0f", v)
+}
+
+type thresholdList []ThresholdRule
+
+func (l *thresholdList) String() string {
+	var parts []string
+	for _, r := range *l {
+		parts = append(parts, r.label())
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (l *thresholdList) Set(v string) error {
+	r, err := parseThresholdRule(v)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, r)
+	return nil
+}
+
+// ThresholdEvaluator checks the rules at the end of every bucket. It is
+// guarded by the MonitoringData mutex.
+type ThresholdEvaluator struct {
+	rules    []*compiledThreshold
+	captured int64 // captured bytes at the start of the bucket
+}
+
+func NewThresholdEvaluator(rules []ThresholdRule, bucket time.Duration) (*ThresholdEvaluator, error) {
+	e := &ThresholdEvaluator{}
+	for _, r := range rules {
+		c, err := r.compile(bucket)
+		if err != nil {
+			return nil, fmt.Errorf("threshold %s: %v", r.label(), err)
+		}
+		e.rules = append(e.rules, c)
+	}
+	return e, nil
+}
+
+// Evaluating the bucket b that just closed with bytes of traffic. The shorter
+// last one of a run is scaled up to a full bucket. Callers hold data.mu.
+func (data *MonitoringData) checkThresholds(b netwatch.Bucket, bytes float64, at time.Time) {
+	e := data.thresholds
+	if e == nil || b.Seconds <= 0 {
+		return
+	}
+	captured := data.capturedBytes - e.captured
+	e.captured = data.capturedBytes
+	scale := data.bucket.Seconds() / b.Seconds
+	values := map[string]float64{
+		"bandwidth": bytes * scale,
+		"captured":  float64(captured) * scale,
+		"packets":   float64(b.Packets) * scale,
+		"pps":       float64(b.Packets) / b.Seconds,
+	}
+	for _, c := range e.rules {
+		v := values[c.Metric]
+		if !c.crossed(v) {
+			c.streak = 0
+			if c.firing {
+				c.firing = false
+				data.resolveAlert("threshold", c.label(), fmt.Sprintf("%s is back at %s", c.label(), c.format(v)), at)
+			}
+			continue
+		}
+		c.streak++
+		if c.streak >= c.For && !c.firing {
+			c.firing = true
+			data.raiseAlert("threshold", c.label(), fmt.Sprintf("%s: %s was %s (limit %s %s) for %d %s(s)",
+				c.label(), c.Metric, c.format(v), c.Op, c.format(c.limit), c.streak, bucketUnit(data.bucket)), at)
+		}
+	}
+}
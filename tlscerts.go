@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// ObservedCert is a server certificate seen in a TLS handshake
+type ObservedCert struct {
+	Subject    string
+	Issuer     string
+	DNSNames   []string
+	NotAfter   time.Time
+	SelfSigned bool
+	Servers    map[string]bool
+	SNI        string
+	Problem    string
+}
+
+// CertTracker collects server certificates from cleartext handshakes.
+// TLS 1.3 encrypts the certificate, so only TLS 1.2 and older are visible.
+// It is guarded by the MonitoringData mutex.
+type CertTracker struct {
+	warnWithin time.Duration
+	certs      map[[32]byte]*ObservedCert
+	sni        map[string]string
+}
+
+func NewCertTracker(warnDays int) *CertTracker {
+	return &CertTracker{
+		warnWithin: time.Duration(warnDays) * 24 * time.Hour,
+		certs:      make(map[[32]byte]*ObservedCert),
+		sni:        make(map[string]string),
+	}
+}
+
+// Observe returns an alert message for a newly seen problem certificate
+func (t *CertTracker) Observe(p *netwatch.Packet) string {
+	if p.SNI != "" && p.Transport == "tcp" {
+		t.sni[flowKey(p)] = p.SNI
+	}
+	if len(p.TLSCerts) == 0 {
+		return ""
+	}
+
+	// Only the leaf certificate is checked, it is what clients see expire
+	der := p.TLSCerts[0]
+	server := fmt.Sprintf("%s:%d", p.SrcIP, p.SrcPort)
+	sum := sha256.Sum256(der)
+	if c, ok := t.certs[sum]; ok {
+		c.Servers[server] = true
+		return ""
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return ""
+	}
+	c := &ObservedCert{
+		Subject:  cert.Subject.String(),
+		Issuer:   cert.Issuer.String(),
+		DNSNames: cert.DNSNames,
+		NotAfter: cert.NotAfter,
+		Servers:  map[string]bool{server: true},
+		SNI:      t.sni[flowKey(p)],
+	}
+	c.SelfSigned = cert.Subject.String() == cert.Issuer.String() && cert.CheckSignatureFrom(cert) == nil
+
+	now := p.Time
+	switch {
+	case now.After(cert.NotAfter):
+		c.Problem = "expired " + cert.NotAfter.Format("2006-01-02")
+	case now.Before(cert.NotBefore):
+		c.Problem = "not valid before " + cert.NotBefore.Format("2006-01-02")
+	case cert.NotAfter.Sub(now) < t.warnWithin:
+		c.Problem = fmt.Sprintf("expires in %d days", int(cert.NotAfter.Sub(now).Hours()/24))
+	}
+	if c.SelfSigned {
+		if c.Problem != "" {
+			c.Problem += ", "
+		}
+		c.Problem += "self-signed"
+	}
+	t.certs[sum] = c
+
+	if c.Problem == "" {
+		return ""
+	}
+	return fmt.Sprintf("TLS certificate for %s on %s: %s", c.name(), server, c.Problem)
+}
+
+// Starting a new period, certificates with a problem are alerted again in it
+func (t *CertTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.certs = make(map[[32]byte]*ObservedCert)
+	t.sni = make(map[string]string)
+}
+
+func (c *ObservedCert) name() string {
+	if c.SNI != "" {
+		return c.SNI
+	}
+	if len(c.DNSNames) > 0 {
+		return c.DNSNames[0]
+	}
+	return c.Subject
+}
+
+func printCertReport(t *CertTracker) {
+	if len(t.certs) == 0 {
+		return
+	}
+
+	var problems []*ObservedCert
+	for _, c := range t.certs {
+		if c.Problem != "" {
+			problems = append(problems, c)
+		}
+	}
+	sort.Slice(problems, func(i, j int) bool {
+		return problems[i].NotAfter.Before(problems[j].NotAfter)
+	})
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("TLS CERTIFICATES")
+	fmt.Printf("Certificates observed: %d | with problems: %d\n", len(t.certs), len(problems))
+	for _, c := range problems {
+		var servers []string
+		for s := range c.Servers {
+			servers = append(servers, s)
+		}
+		sort.Strings(servers)
+		fmt.Printf("%s: %s (expires %s, issuer %s) on %s\n", c.name(), c.Problem, c.NotAfter.Format("2006-01-02"), c.Issuer, strings.Join(servers, ", "))
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// tomlParser reads TOML config files into maps, dates and times are kept as
+// strings since no setting takes them
+type tomlParser struct {
+	s    string
+	i    int
+	root map[string]any
+	// Tables given a [header], which may not be given another
+	defined map[string]bool
+}
+
+func parseTOML(raw []byte) (map[string]any, error) {
+	p := &tomlParser{s: strings.ReplaceAll(string(raw), "\r\n", "\n"), root: make(map[string]any), defined: make(map[string]bool)}
+	if err := p.parse(); err != nil {
+		return nil, fmt.Errorf("line %d: %v", strings.Count(p.s[:p.i], "\n")+1, err)
+	}
+	return p.root, nil
+}
+
+func (p *tomlParser) parse() error {
+	current := p.root
+	for {
+		p.skipSpace(true)
+		if p.i == len(p.s) {
+			return nil
+		}
+		switch {
+		case strings.HasPrefix(p.s[p.i:], "[["):
+			p.i += 2
+			path, err := p.keyPath()
+			if err != nil {
+				return err
+			}
+			if !p.consume("]]") {
+				return errors.New("expected ]] after table name")
+			}
+			parent, err := p.table(p.root, path[:len(path)-1])
+			if err != nil {
+				return err
+			}
+			last := path[len(path)-1]
+			list, ok := parent[last].([]map[string]any)
+			if _, exists := parent[last]; exists && !ok {
+				return fmt.Errorf("%s is not an array of tables", strings.Join(path, "."))
+			}
+			current = make(map[string]any)
+			parent[last] = append(list, current)
+		case p.s[p.i] == '[':
+			p.i++
+			path, err := p.keyPath()
+			if err != nil {
+				return err
+			}
+			if !p.consume("]") {
+				return errors.New("expected ] after table name")
+			}
+			name := strings.Join(path, "\x00")
+			if p.defined[name] {
+				return fmt.Errorf("table %s defined twice", strings.Join(path, "."))
+			}
+			p.defined[name] = true
+			if current, err = p.table(p.root, path); err != nil {
+				return err
+			}
+		default:
+			if err := p.keyValue(current); err != nil {
+				return err
+			}
+		}
+		// Only a comment may follow on the line
+		p.skipSpace(false)
+		if p.i < len(p.s) && p.s[p.i] != '\n' {
+			return fmt.Errorf("unexpected %q", tomlLine(p.s[p.i:]))
+		}
+	}
+}
+
+func tomlLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// Skipping spaces and comments, and newlines when lines is set
+func (p *tomlParser) skipSpace(lines bool) {
+	for p.i < len(p.s) {
+		switch c := p.s[p.i]; {
+		case c == ' ' || c == '\t' || (lines && c == '\n'):
+			p.i++
+		case c == '#':
+			for p.i < len(p.s) && p.s[p.i] != '\n' {
+				p.i++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) consume(s string) bool {
+	p.skipSpace(false)
+	if strings.HasPrefix(p.s[p.i:], s) {
+		p.i += len(s)
+		return true
+	}
+	return false
+}
+
+// The table at path below t, created when missing. In arrays of tables the
+// last one is meant.
+func (p *tomlParser) table(t map[string]any, path []string) (map[string]any, error) {
+	for i, k := range path {
+		switch v := t[k].(type) {
+		case nil:
+			next := make(map[string]any)
+			t[k] = next
+			t = next
+		case map[string]any:
+			t = v
+		case []map[string]any:
+			t = v[len(v)-1]
+		default:
+			return nil, fmt.Errorf("%s is not a table", strings.Join(path[:i+1], "."))
+		}
+	}
+	return t, nil
+}
+
+func (p *tomlParser) keyValue(t map[string]any) error {
+	path, err := p.keyPath()
+	if err != nil {
+		return err
+	}
+	if !p.consume("=") {
+		return fmt.Errorf("expected = after %s", strings.Join(path, "."))
+	}
+	p.skipSpace(false)
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+	parent, err := p.table(t, path[:len(path)-1])
+	if err != nil {
+		return err
+	}
+	last := path[len(path)-1]
+	if _, dup := parent[last]; dup {
+		return fmt.Errorf("%s defined twice", strings.Join(path, "."))
+	}
+	parent[last] = v
+	return nil
+}
+
+var tomlBareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+`)
+
+// A dotted key: bare, "basic" or 'literal' parts joined by dots
+func (p *tomlParser) keyPath() ([]string, error) {
+	var path []string
+	for {
+		p.skipSpace(false)
+		if p.i == len(p.s) {
+			return nil, errors.New("expected a key")
+		}
+		switch p.s[p.i] {
+		case '"', '\'':
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, v.(string))
+		default:
+			k := tomlBareKey.FindString(p.s[p.i:])
+			if k == "" {
+				return nil, fmt.Errorf("invalid key at %q", tomlLine(p.s[p.i:]))
+			}
+			p.i += len(k)
+			path = append(path, k)
+		}
+		if !p.consume(".") {
+			return path, nil
+		}
+	}
+}
+
+var (
+	tomlDateTime = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}([Tt ]\d{2}:\d{2}(:\d{2}(\.\d+)?)?([Zz]|[+-]\d{2}:\d{2})?)?|^\d{2}:\d{2}(:\d{2}(\.\d+)?)?`)
+	tomlNumber   = regexp.MustCompile(`^[-+]?[0-9A-Za-z_.+-]+`)
+	tomlFloat    = regexp.MustCompile(`^[-+]?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+	tomlLineEnd  = regexp.MustCompile(`\\[ \t]*\n[ \t\n]*`)
+)
+
+func (p *tomlParser) value() (any, error) {
+	rest := p.s[p.i:]
+	switch {
+	case rest == "":
+		return nil, errors.New("expected a value")
+	case strings.HasPrefix(rest, `"""`):
+		return p.multiline(`"""`, true)
+	case strings.HasPrefix(rest, "'''"):
+		return p.multiline("'''", false)
+	case rest[0] == '"':
+		end := 1
+		for ; end < len(rest) && rest[end] != '"' && rest[end] != '\n'; end++ {
+			if rest[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(rest) || rest[end] != '"' {
+			return nil, errors.New("unterminated string")
+		}
+		p.i += end + 1
+		return tomlUnescape(rest[1:end])
+	case rest[0] == '\'':
+		end := strings.IndexAny(rest[1:], "'\n")
+		if end < 0 || rest[1+end] != '\'' {
+			return nil, errors.New("unterminated string")
+		}
+		p.i += end + 2
+		return rest[1 : 1+end], nil
+	case strings.HasPrefix(rest, "true"):
+		p.i += 4
+		return true, nil
+	case strings.HasPrefix(rest, "false"):
+		p.i += 5
+		return false, nil
+	case rest[0] == '[':
+		p.i++
+		list := []any{}
+		for {
+			p.skipSpace(true)
+			if p.consume("]") {
+				return list, nil
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			p.skipSpace(true)
+			if !p.consume(",") {
+				p.skipSpace(true)
+				if !p.consume("]") {
+					return nil, errors.New("expected , or ] in array")
+				}
+				return list, nil
+			}
+		}
+	case rest[0] == '{':
+		p.i++
+		t := make(map[string]any)
+		if p.consume("}") {
+			return t, nil
+		}
+		for {
+			if err := p.keyValue(t); err != nil {
+				return nil, err
+			}
+			if p.consume("}") {
+				return t, nil
+			}
+			if !p.consume(",") {
+				return nil, errors.New("expected , or } in inline table")
+			}
+		}
+	}
+
+	if dt := tomlDateTime.FindString(rest); dt != "" {
+		p.i += len(dt)
+		return dt, nil
+	}
+	token := tomlNumber.FindString(rest)
+	if token == "" {
+		return nil, fmt.Errorf("invalid value %q", tomlLine(rest))
+	}
+	p.i += len(token)
+	clean := strings.TrimPrefix(strings.ReplaceAll(token, "_", ""), "+")
+	digits := strings.TrimPrefix(clean, "-")
+	if len(digits) > 1 && digits[0] == '0' && digits[1] >= '0' && digits[1] <= '9' {
+		return nil, fmt.Errorf("invalid value %q, decimals have no leading zeros", token)
+	}
+	if n, err := strconv.ParseInt(clean, 0, 64); err == nil {
+		return n, nil
+	}
+	if tomlFloat.MatchString(clean) {
+		if f, err := strconv.ParseFloat(clean, 64); err == nil {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid value %q", token)
+}
+
+// Multi-line basic (""") and literal strings, a newline right
+// after the opening quotes is dropped
+func (p *tomlParser) multiline(quote string, basic bool) (any, error) {
+	p.i += 3
+	body := p.s[p.i:]
+	end := strings.Index(body, quote)
+	if basic {
+		for end > 0 && strings.HasSuffix(body[:end], `\`) && !strings.HasSuffix(body[:end], `\\`) {
+			next := strings.Index(body[end+1:], quote)
+			if next < 0 {
+				end = -1
+				break
+			}
+			end += 1 + next
+		}
+	}
+	if end < 0 {
+		return nil, errors.New("unterminated multi-line string")
+	}
+	// Up to two more quotes belong to the string
+	for extra := 0; extra < 2 && end+3 < len(body) && body[end+3] == quote[0]; extra++ {
+		end++
+	}
+	p.i += end + 3
+	s := strings.TrimPrefix(body[:end], "\n")
+	if !basic {
+		return s, nil
+	}
+	// A backslash at the end of a line trims the line break and the
+	// whitespace after it
+	return tomlUnescape(tomlLineEnd.ReplaceAllString(s, ""))
+}
+
+func tomlUnescape(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", errors.New("trailing backslash")
+		}
+		if r, ok := map[byte]string{'b': "\b", 't': "\t", 'n': "\n", 'f': "\f", 'r': "\r", 'e': "\x1b", '"': "\"", '\\': "\\"}[s[i]]; ok {
+			b.WriteString(r)
+			continue
+		}
+		size := map[byte]int{'u': 4, 'U': 8}[s[i]]
+		if size == 0 || i+1+size > len(s) {
+			return "", fmt.Errorf("invalid escape \\%c", s[i])
+		}
+		n, err := strconv.ParseUint(s[i+1:i+1+size], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(n)) {
+			return "", fmt.Errorf("invalid escape \\%s", s[i:i+1+size])
+		}
+		b.WriteRune(rune(n))
+		i += size
+	}
+	return b.String(), nil
+}
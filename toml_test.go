@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want map[string]any
+	}{
+		{"empty", "# nothing\n", map[string]any{}},
+		{"scalars", "a = 7\nb = 1.5\nc = true\nd = \"eth0\"\ne = 1_000\n", map[string]any{"a": int64(7), "b": 1.5, "c": true, "d": "eth0", "e": int64(1000)}},
+		{"quoting", "a = 'C:\\path # not a comment'\nb = \"tab\\there\"\nc = \"7\"\n\"d.e\" = 1\n", map[string]any{
+			"a": "C:\\path # not a comment", "b": "tab\there", "c": "7", "d.e": int64(1)}},
+		{"comments", "# top\na = 1 # trailing\n\n   # indented\n", map[string]any{"a": int64(1)}},
+		{"tables", "a = 1\n[b]\nc = 2\n[b.d]\ne = \"x\"\n", map[string]any{"a": int64(1), "b": map[string]any{"c": int64(2), "d": map[string]any{"e": "x"}}}},
+		{"dotted keys", "a.b = 1\na.c = 2\n", map[string]any{"a": map[string]any{"b": int64(1), "c": int64(2)}}},
+		{"inline table", "a = { b = 1, c = [\"d\"] }\n", map[string]any{"a": map[string]any{"b": int64(1), "c": []any{"d"}}}},
+		{"lists", "a = [1, \"two\"]\nb = [\n  \"x\", # comment\n  \"y\",\n]\n", map[string]any{"a": []any{int64(1), "two"}, "b": []any{"x", "y"}}},
+		{"array of tables", "[[a]]\nb = 1\n[[a]]\nb = 2\n", map[string]any{"a": []map[string]any{{"b": int64(1)}, {"b": int64(2)}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTOML([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	for _, in := range []string{
+		"a = 1\na = 2\n",
+		"[a]\n[a]\n",
+		"a = 01\n",
+		"a = \"unterminated\n",
+		"a = [1, 2\n",
+		"a\n",
+	} {
+		if v, err := parseTOML([]byte(in)); err == nil {
+			t.Errorf("%q parsed as %#v", in, v)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ANSI sequences for the dashboard: alternate screen, cursor and clearing
+const (
+	ansiAltScreen  = "\x1b[?1049h"
+	ansiMainScreen = "\x1b[?1049l"
+	ansiHideCursor = "\x1b[?25l"
+	ansiShowCursor = "\x1b[?25h"
+	ansiHome       = "\x1b[H"
+	ansiClearDown  = "\x1b[J"
+	ansiClearLine  = "\x1b[K"
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// Scaling values to block characters, the highest value gets a full block
+func sparkline(values []float64, width int) string {
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+	peak := 0.0
+	for _, v := range values {
+		peak = max(peak, v)
+	}
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if peak > 0 {
+			i = int(v / peak * float64(len(sparkBlocks)-1))
+		}
+		b.WriteRune(sparkBlocks[i])
+	}
+	return b.String()
+}
+
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 40 {
+		return n
+	}
+	return 80
+}
+
+// Redrawing the dashboard every second on the alternate screen until the capture ends
+func runTUI(ctx context.Context, data *MonitoringData) {
+	enableANSI()
+	fmt.Print(ansiAltScreen + ansiHideCursor)
+	defer fmt.Print(ansiShowCursor + ansiMainScreen)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		data.mu.Lock()
+		frame := renderTUI(data, time.Now(), terminalWidth())
+		data.mu.Unlock()
+		// Clearing each line as it's overwritten avoids flicker
+		fmt.Print(ansiHome + strings.ReplaceAll(frame, "\n", ansiClearLine+"\n") + ansiClearDown)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Building one dashboard frame. Callers hold data.mu.
+func renderTUI(data *MonitoringData, now time.Time, width int) string {
+	var b strings.Builder
+	elapsed := now.Sub(data.startTime).Round(time.Second)
+	status := ""
+	if data.paused {
+		status = "  [PAUSED]"
+	}
+	of := ""
+	if data.requestedDuration > 0 {
+		of = " of " + data.requestedDuration.String()
+	}
+	fmt.Fprintf(&b, "netwatchd on %s  %s%s%s\n", data.captureInterface, elapsed, of, status)
+	if data.captureFilter != "" {
+		fmt.Fprintf(&b, "filter: %s\n", data.captureFilter)
+	}
+	fmt.Fprintln(&b, strings.Repeat("-", min(width, 60)))
+
+	graph := max(width-24, 10)
+	var perSecond []float64
+	// The current second is still filling up
+	for _, n := range data.perSecond[:max(len(data.perSecond)-1, 0)] {
+		perSecond = append(perSecond, float64(n))
+	}
+	last, peak := 0.0, 0.0
+	for _, v := range perSecond {
+		peak = max(peak, v)
+	}
+	if len(perSecond) > 0 {
+		last = perSecond[len(perSecond)-1]
+	}
+	fmt.Fprintf(&b, "%-12s %s\n", "packets/s", sparkline(perSecond, graph))
+	fmt.Fprintf(&b, "%-12s now %.0f, peak %.0f\n", "", last, peak)
+
+	unit := bucketUnit(data.bucket)
+	packets := make([]float64, 0, len(data.buckets.Closed)+1)
+	for _, c := range data.buckets.Closed {
+		packets = append(packets, float64(c.Packets))
+	}
+	packets = append(packets, float64(data.buckets.Current.Packets))
+	mb := append([]float64(nil), data.bandwidthBuckets...)
+	mb = append(mb, data.currentBandwidth)
+	fmt.Fprintf(&b, "%-12s %s\n", "pkts/"+unit, sparkline(packets, graph))
+	fmt.Fprintf(&b, "%-12s %s\n", "MB/"+unit, sparkline(mb, graph))
+	fmt.Fprintln(&b, strings.Repeat("-", min(width, 60)))
+
+	totalBandwidth := data.currentBandwidth
+	for _, v := range data.bandwidthBuckets {
+		totalBandwidth += v
+	}
+	fmt.Fprintf(&b, "total: %d packets | %.2f MB captured", data.capturedPackets, float64(data.capturedBytes)/(1024*1024))
+	// Adapter counters stay at zero with -b=false
+	if totalBandwidth > 0 {
+		fmt.Fprintf(&b, " | %.2f MB on the adapter", totalBandwidth/(1024*1024))
+	}
+	fmt.Fprintln(&b)
+	fmt.Fprintf(&b, "%s: %d packets | %.2f MB\n", bucketLabel(len(data.buckets.Closed), data.bucket), data.buckets.Current.Packets, data.currentBandwidth/(1024*1024))
+	fmt.Fprintf(&b, "hosts: %d | alerts: %d\n", data.inventory.Len(), len(data.alerts))
+	if top := topProtocols(data.protocols, 6); top != "" {
+		fmt.Fprintf(&b, "protocols: %s\n", top)
+	}
+
+	if n := len(data.alerts); n > 0 {
+		fmt.Fprintln(&b, strings.Repeat("-", min(width, 60)))
+		for _, a := range data.alerts[max(n-5, 0):] {
+			fmt.Fprintln(&b, truncate(fmt.Sprintf("%s %-8s %s", a.Time.Format("15:04:05"), a.Kind, a.Message), width-1))
+		}
+	}
+	fmt.Fprintln(&b, strings.Repeat("-", min(width, 60)))
+	fmt.Fprintln(&b, "p pause/resume | q quit and print the report")
+	return b.String()
+}
+
+// The busiest protocols below the link layer with their share of packets
+func topProtocols(p *ProtocolBreakdown, n int) string {
+	if p == nil {
+		return ""
+	}
+	counts := make(map[string]int)
+	total := 0
+	for path, c := range p.tree {
+		layers := strings.Split(path, ":")
+		if len(layers) == 1 {
+			total += c.Packets
+		}
+		if name := layers[len(layers)-1]; len(layers) > 1 && !linkLayers[name] {
+			counts[name] += c.Packets
+		}
+	}
+	if total == 0 {
+		return ""
+	}
+	var names []string
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	var parts []string
+	for _, name := range names[:min(n, len(names))] {
+		parts = append(parts, fmt.Sprintf("%s %.0f%%", name, float64(counts[name])/float64(total)*100))
+	}
+	return strings.Join(parts, "  ")
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TWAMP-light (RFC 5357 section 4.2.1 unauthenticated mode) sessions between
+// two netwatchd instances, or against any TWAMP-light reflector
+const (
+	twampPort          = "862"
+	twampSenderSize    = 48 // padded, so the reflector's 41 byte reply fits in the same size
+	twampReflectedSize = 41
+	twampTimeout       = 2 * time.Second
+	// Error estimate: clock not synchronized, scale 0, multiplier 1
+	twampErrorEstimate = 0x0001
+)
+
+// Seconds between the NTP epoch (1900) and the Unix epoch
+const ntpEpochOffset = 2208988800
+
+func ntpTimestamp(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return secs<<32 | frac
+}
+
+func fromNTP(ts uint64) time.Time {
+	secs := int64(ts>>32) - ntpEpochOffset
+	nanos := int64((ts & 0xffffffff) * 1e9 >> 32)
+	return time.Unix(secs, nanos)
+}
+
+// twampBucket is one report bucket of a sender session
+type twampBucket struct {
+	Sent     int
+	Received int
+	Lost     int
+	RTT      time.Duration // sum over Received
+	MinRTT   time.Duration
+	MaxRTT   time.Duration
+	Forward  time.Duration // sums of the one-way delays, only meaningful with synchronized clocks
+	Backward time.Duration
+}
+
+// TwampSession measures round trip time, jitter and loss to a reflector
+type TwampSession struct {
+	Peer    string
+	Buckets []twampBucket
+	current twampBucket
+	pending map[uint32]time.Time
+	// RFC 3550 interarrival jitter of the round trip and of each direction,
+	// the one-way variations don't depend on the clocks being in sync
+	jitter, fwdJitter, backJitter float64
+	last                          *twampSample
+}
+
+type twampSample struct {
+	rtt, fwd, back time.Duration
+}
+
+func NewTwampSession(peer string) *TwampSession {
+	if _, _, err := net.SplitHostPort(peer); err != nil {
+		peer = net.JoinHostPort(peer, twampPort)
+	}
+	return &TwampSession{Peer: peer, pending: make(map[uint32]time.Time)}
+}
+
+// Closing the current bucket, replies overdue by then count as lost. Callers hold data.mu.
+func (s *TwampSession) rotate(now time.Time) {
+	if s == nil {
+		return
+	}
+	for seq, sent := range s.pending {
+		if now.Sub(sent) > twampTimeout {
+			s.current.Lost++
+			delete(s.pending, seq)
+		}
+	}
+	s.Buckets = append(s.Buckets, s.current)
+	s.current = twampBucket{}
+}
+
+func (s *TwampSession) reply(sample twampSample) {
+	b := &s.current
+	b.Received++
+	b.RTT += sample.rtt
+	b.Forward += sample.fwd
+	b.Backward += sample.back
+	if b.MinRTT == 0 || sample.rtt < b.MinRTT {
+		b.MinRTT = sample.rtt
+	}
+	b.MaxRTT = max(b.MaxRTT, sample.rtt)
+	if s.last != nil {
+		s.jitter += (math.Abs(float64(sample.rtt-s.last.rtt)) - s.jitter) / 16
+		s.fwdJitter += (math.Abs(float64(sample.fwd-s.last.fwd)) - s.fwdJitter) / 16
+		s.backJitter += (math.Abs(float64(sample.back-s.last.back)) - s.backJitter) / 16
+	}
+	s.last = &sample
+}
+
+// Sending test packets every interval and matching the reflected ones until the capture ends
+func runTwampSender(ctx context.Context, data *MonitoringData, s *TwampSession, interval time.Duration) {
+	conn, err := net.Dial("udp", s.Peer)
+	if err != nil {
+		logger.Error("failed to start TWAMP session", "peer", s.Peer, "err", err)
+		return
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				// ICMP port unreachable while the reflector is down, keep listening
+				continue
+			}
+			arrived := time.Now()
+			if n < twampReflectedSize {
+				continue
+			}
+			reflectorSent := fromNTP(binary.BigEndian.Uint64(buf[4:]))
+			reflectorRecv := fromNTP(binary.BigEndian.Uint64(buf[16:]))
+			seq := binary.BigEndian.Uint32(buf[24:])
+
+			data.mu.Lock()
+			if sent, ok := s.pending[seq]; ok {
+				delete(s.pending, seq)
+				// The reflector's processing time is not part of the round trip
+				rtt := arrived.Sub(sent) - reflectorSent.Sub(reflectorRecv)
+				s.reply(twampSample{rtt: rtt, fwd: reflectorRecv.Sub(sent), back: arrived.Sub(reflectorSent)})
+			}
+			data.mu.Unlock()
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	pkt := make([]byte, twampSenderSize)
+	var seq uint32
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			binary.BigEndian.PutUint32(pkt[0:], seq)
+			binary.BigEndian.PutUint64(pkt[4:], ntpTimestamp(now))
+			binary.BigEndian.PutUint16(pkt[12:], twampErrorEstimate)
+			data.mu.Lock()
+			s.pending[seq] = now
+			s.current.Sent++
+			data.mu.Unlock()
+			conn.Write(pkt)
+			seq++
+		}
+	}
+}
+
+// twampPeer is what a reflector saw from one sender
+type twampPeer struct {
+	Addr     string
+	Received int
+	first    uint32
+	last     uint32
+	lastFwd  time.Duration
+	jitter   float64
+	seen     bool
+}
+
+// Lost sequence numbers between the first and the latest test packet
+func (p *twampPeer) Lost() int {
+	return max(int(p.last-p.first)+1-p.Received, 0)
+}
+
+// TwampReflector answers TWAMP-light test packets and tracks each sender
+type TwampReflector struct {
+	Addr  string
+	Peers map[string]*twampPeer
+	conn  net.PacketConn
+}
+
+func NewTwampReflector(addr string) *TwampReflector {
+	if !strings.Contains(addr, ":") {
+		addr = ":" + addr
+	}
+	return &TwampReflector{Addr: addr, Peers: make(map[string]*twampPeer)}
+}
+
+// Binding the reflector's socket, ahead of -user for ports below 1024
+func (r *TwampReflector) listen() error {
+	conn, err := net.ListenPacket("udp", r.Addr)
+	if err != nil {
+		return err
+	}
+	r.conn = conn
+	return nil
+}
+
+func runTwampReflector(ctx context.Context, data *MonitoringData, r *TwampReflector) {
+	if r.conn == nil {
+		if err := r.listen(); err != nil {
+			logger.Error("failed to start TWAMP reflector", "addr", r.Addr, "err", err)
+			return
+		}
+	}
+	conn := r.conn
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	fmt.Printf("Reflecting TWAMP-light test packets on %s\n", conn.LocalAddr())
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		received := time.Now()
+		if n < 14 {
+			continue
+		}
+		seq := binary.BigEndian.Uint32(buf[0:])
+		senderTS := binary.BigEndian.Uint64(buf[4:])
+
+		data.mu.Lock()
+		p := r.Peers[from.String()]
+		if p == nil {
+			p = &twampPeer{Addr: from.String()}
+			r.Peers[from.String()] = p
+		}
+		fwd := received.Sub(fromNTP(senderTS))
+		if !p.seen {
+			p.first, p.last, p.seen = seq, seq, true
+		} else {
+			p.jitter += (math.Abs(float64(fwd-p.lastFwd)) - p.jitter) / 16
+			p.last = max(p.last, seq)
+		}
+		p.lastFwd = fwd
+		p.Received++
+		data.mu.Unlock()
+
+		// Replies are as long as the request, at least the 41 bytes of the reflected fields
+		out := make([]byte, max(n, twampReflectedSize))
+		binary.BigEndian.PutUint32(out[0:], seq)
+		binary.BigEndian.PutUint16(out[12:], twampErrorEstimate)
+		binary.BigEndian.PutUint64(out[16:], ntpTimestamp(received))
+		copy(out[24:], buf[0:14])
+		binary.BigEndian.PutUint64(out[4:], ntpTimestamp(time.Now()))
+		conn.WriteTo(out, from)
+	}
+}
+
+func formatMs(d time.Duration) string {
+	return fmt.Sprintf("%.2fms", float64(d)/float64(time.Millisecond))
+}
+
+// Summing the closed buckets
+func (s *TwampSession) total() twampBucket {
+	var total twampBucket
+	for _, b := range s.Buckets {
+		total.Sent += b.Sent
+		total.Received += b.Received
+		total.Lost += b.Lost
+		total.RTT += b.RTT
+		total.Forward += b.Forward
+		total.Backward += b.Backward
+		if b.MinRTT > 0 && (total.MinRTT == 0 || b.MinRTT < total.MinRTT) {
+			total.MinRTT = b.MinRTT
+		}
+		total.MaxRTT = max(total.MaxRTT, b.MaxRTT)
+	}
+	return total
+}
+
+// ReportTwamp is the session summary in the JSON report
+type ReportTwamp struct {
+	Peer             string  `json:"peer"`
+	Sent             int     `json:"sent"`
+	Lost             int     `json:"lost"`
+	RTTAvgMs         float64 `json:"rtt_avg_ms,omitempty"`
+	RTTMinMs         float64 `json:"rtt_min_ms,omitempty"`
+	RTTMaxMs         float64 `json:"rtt_max_ms,omitempty"`
+	JitterMs         float64 `json:"jitter_ms"`
+	ForwardJitterMs  float64 `json:"forward_jitter_ms"`
+	BackwardJitterMs float64 `json:"backward_jitter_ms"`
+}
+
+func (s *TwampSession) summary() *ReportTwamp {
+	if s == nil {
+		return nil
+	}
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	total := s.total()
+	r := &ReportTwamp{Peer: s.Peer, Sent: total.Sent, Lost: total.Lost,
+		JitterMs: ms(time.Duration(s.jitter)), ForwardJitterMs: ms(time.Duration(s.fwdJitter)), BackwardJitterMs: ms(time.Duration(s.backJitter))}
+	if total.Received > 0 {
+		r.RTTAvgMs = ms(total.RTT / time.Duration(total.Received))
+		r.RTTMinMs, r.RTTMaxMs = ms(total.MinRTT), ms(total.MaxRTT)
+	}
+	return r
+}
+
+func printTwampReport(s *TwampSession, r *TwampReflector, bucket time.Duration) {
+	if s == nil && (r == nil || len(r.Peers) == 0) {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("TWAMP-LIGHT")
+	if s != nil {
+		fmt.Printf("Session to %s\n", s.Peer)
+		for i, b := range s.Buckets {
+			if b.Received == 0 {
+				fmt.Printf("  %s: %d sent, %d lost, no replies\n", bucketLabel(i, bucket), b.Sent, b.Lost)
+				continue
+			}
+			fmt.Printf("  %s: %d sent, %d lost, rtt avg %s (%s-%s)\n", bucketLabel(i, bucket), b.Sent, b.Lost,
+				formatMs(b.RTT/time.Duration(b.Received)), formatMs(b.MinRTT), formatMs(b.MaxRTT))
+		}
+		total := s.total()
+		if total.Sent > 0 {
+			fmt.Printf("  total: %d sent, %d lost (%.1f%%)\n", total.Sent, total.Lost, float64(total.Lost)/float64(total.Sent)*100)
+		}
+		if total.Received > 0 {
+			n := time.Duration(total.Received)
+			fmt.Printf("  rtt avg %s, min %s, max %s, jitter %s\n", formatMs(total.RTT/n), formatMs(total.MinRTT), formatMs(total.MaxRTT), formatMs(time.Duration(s.jitter)))
+			fmt.Printf("  one-way jitter: forward %s, backward %s\n", formatMs(time.Duration(s.fwdJitter)), formatMs(time.Duration(s.backJitter)))
+			fmt.Printf("  one-way delay (needs synchronized clocks): forward %s, backward %s\n", formatMs(total.Forward/n), formatMs(total.Backward/n))
+		}
+	}
+	if r != nil {
+		var addrs []string
+		for addr := range r.Peers {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+		for _, addr := range addrs {
+			p := r.Peers[addr]
+			fmt.Printf("Reflected for %s: %d packets, %d lost, forward jitter %s\n", p.Addr, p.Received, p.Lost(), formatMs(time.Duration(p.jitter)))
+		}
+	}
+}
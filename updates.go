@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// OS and application update sources by the domains of their download
+// servers, learned from TLS server names and DNS answers like services
+var updateSources = []struct {
+	name    string
+	domains []string
+}{
+	{"Windows Update", []string{"windowsupdate.com", "update.microsoft.com", "delivery.mp.microsoft.com", "officecdn.microsoft.com",
+		"tlu.dl.delivery.mp.microsoft.com"}},
+	{"apt", []string{"archive.ubuntu.com", "security.ubuntu.com", "ports.ubuntu.com", "deb.debian.org", "security.debian.org",
+		"ftp.debian.org", "ppa.launchpadcontent.net", "ppa.launchpad.net", "packages.microsoft.com", "archive.raspberrypi.org"}},
+	{"yum/dnf", []string{"mirrors.fedoraproject.org", "download.fedoraproject.org", "dl.fedoraproject.org", "mirror.centos.org",
+		"mirrorlist.centos.org", "mirror.stream.centos.org", "cdn.redhat.com", "dl.rockylinux.org", "mirrors.rockylinux.org",
+		"repo.almalinux.org", "mirrors.almalinux.org"}},
+	{"Linux packages", []string{"dl-cdn.alpinelinux.org", "geo.mirror.pkgbuild.com", "download.opensuse.org",
+		"snapcraftcontent.com", "api.snapcraft.io", "dl.flathub.org"}},
+	{"Apple updates", []string{"swcdn.apple.com", "swdist.apple.com", "swscan.apple.com", "updates.cdn-apple.com", "mesu.apple.com",
+		"appldnld.apple.com", "oscdn.apple.com", "osrecovery.apple.com", "iosapps.itunes.apple.com"}},
+	{"Google updates", []string{"update.googleapis.com", "dl.google.com", "gvt1.com", "play.googleapis.com", "android.clients.google.com"}},
+	{"Steam", []string{"steamcontent.com", "steampipe.akamaized.net", "steamcdn-a.akamaihd.net"}},
+	{"Console and launcher updates", []string{"download.epicgames.com", "epicgames-download1.akamaized.net", "assets1.xboxlive.com",
+		"xvcf1.xboxlive.com", "xvcf2.xboxlive.com", "dl.playstation.net", "cdn.blizzard.com"}},
+}
+
+// Update sources on fixed ports, whichever side is internal
+var updatePorts = []struct {
+	name      string
+	transport string
+	port      int
+}{
+	{"Windows Delivery Optimization", "tcp", 7680},
+	{"WSUS", "tcp", 8530},
+	{"WSUS", "tcp", 8531},
+}
+
+// A bucket is an update storm when updates take at least this much and
+// either most of the bucket or several hosts at once
+const (
+	updateStormBytes = 50 * 1024 * 1024
+	updateStormShare = 50
+	updateStormHosts = 3
+)
+
+// The update source whose most specific domain name ends in, empty for none
+func updateSourceByName(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	best, length := "", 0
+	for _, s := range updateSources {
+		for _, d := range s.domains {
+			if len(d) > length && hasDomain(name, d) {
+				best, length = s.name, len(d)
+			}
+		}
+	}
+	return best
+}
+
+func updateSourceByPort(p *netwatch.Packet) string {
+	for _, u := range updatePorts {
+		if p.Transport == u.transport && (p.SrcPort == u.port || p.DstPort == u.port) {
+			return u.name
+		}
+	}
+	return ""
+}
+
+// Package downloads from mirrors of any name, by the request line tshark
+// shows for plain HTTP
+func updateSourceByRequest(p *netwatch.Packet) string {
+	if !p.HasLayer("http") || !strings.HasPrefix(p.Info, "GET ") {
+		return ""
+	}
+	path, _, _ := strings.Cut(strings.TrimPrefix(p.Info, "GET "), " ")
+	switch {
+	case strings.HasSuffix(path, ".deb") || strings.Contains(path, "/dists/"):
+		return "apt"
+	case strings.HasSuffix(path, ".rpm") || strings.Contains(path, "/repodata/"):
+		return "yum/dnf"
+	}
+	return ""
+}
+
+// UpdateSource is the update traffic of internal hosts from one source
+type UpdateSource struct {
+	Source   string `json:"source"`
+	Packets  int    `json:"packets"`
+	BytesIn  int64  `json:"bytes_in"`
+	BytesOut int64  `json:"bytes_out"`
+	Hosts    int    `json:"hosts"` // internal hosts updating
+}
+
+type updateUsage struct {
+	UpdateSource
+	hosts map[string]bool
+}
+
+// UpdateBucket is the update traffic of one bucket
+type UpdateBucket struct {
+	Start   time.Time `json:"start"`
+	Bytes   int64     `json:"bytes"`
+	Percent float64   `json:"percent"` // of the bytes captured in the bucket
+	Hosts   int       `json:"hosts"`
+	Storm   bool      `json:"storm,omitempty"`
+}
+
+// UpdateTracker classifies OS and application update downloads by the
+// names of their servers, their fixed ports and, for mirrors, the package
+// paths requested. Each bucket's update volume is kept so update storms
+// can be told apart from other spikes. It is guarded by the MonitoringData
+// mutex.
+type UpdateTracker struct {
+	learned map[string]string // remote address by source
+	usage   map[string]*updateUsage
+	buckets []UpdateBucket
+
+	bytes, total int64 // update and captured bytes of the current bucket
+	hosts        map[string]bool
+}
+
+func NewUpdateTracker() *UpdateTracker {
+	return &UpdateTracker{learned: make(map[string]string), usage: make(map[string]*updateUsage), hosts: make(map[string]bool)}
+}
+
+func (t *UpdateTracker) learn(addr, source string) {
+	if len(t.learned) >= serviceCacheMax {
+		t.learned = make(map[string]string)
+	}
+	t.learned[addr] = source
+}
+
+func (t *UpdateTracker) Observe(p *netwatch.Packet) {
+	if t == nil || p.SrcIP == "" {
+		return
+	}
+	t.total += int64(p.Length)
+	if p.DNSName != "" {
+		if s := updateSourceByName(p.DNSName); s != "" {
+			for _, addr := range p.DNSAddrs {
+				t.learn(addr, s)
+			}
+		}
+	}
+	if p.SNI != "" {
+		if s := updateSourceByName(p.SNI); s != "" {
+			t.learn(p.DstIP, s)
+		}
+	}
+	if s := updateSourceByRequest(p); s != "" {
+		t.learn(p.DstIP, s)
+	}
+
+	host, remote, out := p.SrcIP, p.DstIP, true
+	source := updateSourceByPort(p)
+	switch {
+	case isLocalIP(p.SrcIP) && isPublicIP(p.DstIP):
+	case isPublicIP(p.SrcIP) && isLocalIP(p.DstIP):
+		host, remote, out = p.DstIP, p.SrcIP, false
+	case source != "" && isLocalIP(p.SrcIP) && isLocalIP(p.DstIP):
+		// A WSUS server or Delivery Optimization peer on the LAN, the
+		// host is the side downloading from the update port
+		for _, u := range updatePorts {
+			if p.Transport == u.transport && p.SrcPort == u.port {
+				host, remote, out = p.DstIP, p.SrcIP, false
+				break
+			}
+		}
+	default:
+		return
+	}
+	if source == "" {
+		if source = t.learned[remote]; source == "" {
+			return
+		}
+	}
+	u := t.usage[source]
+	if u == nil {
+		u = &updateUsage{UpdateSource: UpdateSource{Source: source}, hosts: make(map[string]bool)}
+		t.usage[source] = u
+	}
+	u.Packets++
+	if out {
+		u.BytesOut += int64(p.Length)
+	} else {
+		u.BytesIn += int64(p.Length)
+	}
+	u.hosts[host] = true
+	t.bytes += int64(p.Length)
+	t.hosts[host] = true
+}
+
+// Closing the bucket that started at start, returning it when it held
+// update traffic
+func (t *UpdateTracker) rotate(start time.Time) *UpdateBucket {
+	if t == nil {
+		return nil
+	}
+	defer func() {
+		t.bytes, t.total = 0, 0
+		t.hosts = make(map[string]bool)
+	}()
+	if t.bytes == 0 {
+		return nil
+	}
+	b := UpdateBucket{Start: start, Bytes: t.bytes, Hosts: len(t.hosts)}
+	if t.total > 0 {
+		b.Percent = float64(t.bytes) / float64(t.total) * 100
+	}
+	b.Storm = b.Bytes >= updateStormBytes && (b.Percent >= updateStormShare || b.Hosts >= updateStormHosts)
+	t.buckets = append(t.buckets, b)
+	return &t.buckets[len(t.buckets)-1]
+}
+
+// Update sources by volume, the biggest download first
+func (t *UpdateTracker) Sources() []UpdateSource {
+	if t == nil {
+		return nil
+	}
+	var sources []UpdateSource
+	for _, u := range t.usage {
+		s := u.UpdateSource
+		s.Hosts = len(u.hosts)
+		sources = append(sources, s)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].BytesIn != sources[j].BytesIn {
+			return sources[i].BytesIn > sources[j].BytesIn
+		}
+		return sources[i].Source < sources[j].Source
+	})
+	return sources
+}
+
+// Buckets with update traffic
+func (t *UpdateTracker) Buckets() []UpdateBucket {
+	if t == nil {
+		return nil
+	}
+	return t.buckets
+}
+
+// Starting a new period, learned addresses are kept
+func (t *UpdateTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.usage = make(map[string]*updateUsage)
+	t.buckets = nil
+}
+
+func printUpdateReport(t *UpdateTracker, bucket time.Duration) {
+	sources := t.Sources()
+	if len(sources) == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("SOFTWARE UPDATES")
+	for _, s := range sources {
+		fmt.Printf("%s: %.2f MB down | %.2f MB up | %d hosts\n", s.Source,
+			float64(s.BytesIn)/(1024*1024), float64(s.BytesOut)/(1024*1024), s.Hosts)
+	}
+
+	var peak *UpdateBucket
+	var storms []string
+	for i, b := range t.Buckets() {
+		if peak == nil || b.Bytes > peak.Bytes {
+			peak = &t.buckets[i]
+		}
+		if b.Storm {
+			storms = append(storms, fmt.Sprintf("%s: %.1f MB of updates, %.0f%% of traffic, %d hosts",
+				b.Start.Format("15:04:05"), float64(b.Bytes)/(1024*1024), b.Percent, b.Hosts))
+		}
+	}
+	if peak != nil {
+		fmt.Printf("Peak %s: %s with %.1f MB of updates (%.0f%% of traffic)\n", bucketUnit(bucket),
+			peak.Start.Format("15:04:05"), float64(peak.Bytes)/(1024*1024), peak.Percent)
+	}
+	if len(storms) > 0 {
+		fmt.Printf("Update storms, %ss where updates drove the traffic:\n", bucketUnit(bucket))
+		for _, s := range storms {
+			fmt.Println("  " + s)
+		}
+	}
+}
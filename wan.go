@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"netwatchd/iphlpapi"
+	linux "netwatchd/netstat"
+)
+
+// byteCounter is satisfied by the pdh, Linux netstat and macOS counters
+type byteCounter interface {
+	GetValue() (float64, error)
+	Close()
+}
+
+// WANLink is traffic and routing state of one uplink
+type WANLink struct {
+	Name     string
+	RxBytes  float64
+	TxBytes  float64
+	Active   time.Duration
+	Metric   int
+	HasRoute bool
+	sent     byteCounter
+	recv     byteCounter
+}
+
+// WANEvent is a default route change seen between two samples
+type WANEvent struct {
+	Time    time.Time
+	Message string
+}
+
+type defaultRoute struct {
+	Iface   string
+	Gateway string
+	Metric  int
+}
+
+func defaultRoutes() []defaultRoute {
+	var routes []defaultRoute
+	switch runtime.GOOS {
+	case "linux":
+		rs, _ := linux.GetDefaultRoutes()
+		for _, r := range rs {
+			routes = append(routes, defaultRoute{r.Iface, r.Gateway, r.Metric})
+		}
+	case "windows":
+		rs, _ := iphlpapi.GetDefaultRoutes()
+		for _, r := range rs {
+			routes = append(routes, defaultRoute{r.Iface, r.Gateway, r.Metric})
+		}
+	}
+	return routes
+}
+
+func newByteCounters(stats statsProvider, iface string) (sent, recv byteCounter, err error) {
+	if stats == nil {
+		return nil, nil, fmt.Errorf("no byte counters on %s", runtime.GOOS)
+	}
+	s, err := stats.NewCounter(iface, "Bytes Sent/sec")
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := stats.NewCounter(iface, "Bytes Received/sec")
+	if err != nil {
+		s.Close()
+		return nil, nil, err
+	}
+	return s, r, nil
+}
+
+// The preferred default route among the WAN links, empty when none has one
+func activeWAN(routes []defaultRoute, links []*WANLink) (string, int) {
+	best, metric := "", 0
+	for _, r := range routes {
+		for _, l := range links {
+			if l.Name == r.Iface && (best == "" || r.Metric < metric) {
+				best, metric = r.Iface, r.Metric
+			}
+		}
+	}
+	return best, metric
+}
+
+// Tracking per uplink usage and which one carries the default route
+func monitorWANs(ctx context.Context, data *MonitoringData, names []string) {
+	// On Windows the uplinks share one PDH query, sampled on this interval
+	stats := newStatsProvider()
+	pdhStats, _ := stats.(*pdhProvider)
+	if pdhStats != nil {
+		if err := pdhStats.Initialize(); err != nil {
+			logger.Error("failed to initialize PDH", "err", err)
+			return
+		}
+		defer pdhStats.Cleanup()
+	}
+
+	var links []*WANLink
+	for _, name := range names {
+		link := &WANLink{Name: name}
+		sent, recv, err := newByteCounters(stats, name)
+		if err != nil {
+			logger.Warn("no byte counters for WAN", "wan", name, "err", err)
+		} else {
+			link.sent, link.recv = sent, recv
+			defer sent.Close()
+			defer recv.Close()
+		}
+		links = append(links, link)
+	}
+
+	routes := defaultRoutes()
+	active, _ := activeWAN(routes, links)
+	for _, l := range links {
+		for _, r := range routes {
+			if r.Iface == l.Name {
+				l.HasRoute, l.Metric = true, r.Metric
+			}
+		}
+	}
+	data.mu.Lock()
+	data.wanLinks = links
+	if active != "" {
+		data.wanEvents = append(data.wanEvents, WANEvent{time.Now(), "active uplink is " + active})
+	}
+	data.mu.Unlock()
+
+	if pdhStats != nil {
+		pdhStats.CollectData()
+	}
+	const interval = 2 * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if pdhStats != nil {
+				if err := pdhStats.CollectData(); err != nil {
+					logger.Debug("WAN sample failed", "err", err)
+				}
+			}
+			newRoutes := defaultRoutes()
+			newActive, metric := activeWAN(newRoutes, links)
+
+			data.mu.Lock()
+			for _, l := range links {
+				if l.sent != nil {
+					// pdh reports a rate, the Linux and macOS counters the bytes since the last read
+					tx, err1 := l.sent.GetValue()
+					rx, err2 := l.recv.GetValue()
+					if err := errors.Join(err1, err2); err != nil {
+						logger.Debug("WAN counter read failed", "wan", l.Name, "err", err)
+					}
+					if runtime.GOOS == "windows" {
+						tx *= interval.Seconds()
+						rx *= interval.Seconds()
+					}
+					l.TxBytes += tx
+					l.RxBytes += rx
+				}
+				if l.Name == active {
+					l.Active += interval
+				}
+				hadRoute, oldMetric := l.HasRoute, l.Metric
+				l.HasRoute = false
+				for _, r := range newRoutes {
+					if r.Iface == l.Name {
+						l.HasRoute, l.Metric = true, r.Metric
+					}
+				}
+				switch {
+				case hadRoute && !l.HasRoute:
+					data.wanEvents = append(data.wanEvents, WANEvent{now, "default route via " + l.Name + " removed"})
+				case !hadRoute && l.HasRoute:
+					data.wanEvents = append(data.wanEvents, WANEvent{now, "default route via " + l.Name + " added"})
+				case hadRoute && oldMetric != l.Metric:
+					data.wanEvents = append(data.wanEvents, WANEvent{now, fmt.Sprintf("%s metric %d -> %d", l.Name, oldMetric, l.Metric)})
+				}
+			}
+			if newActive != active {
+				msg := fmt.Sprintf("failover: active uplink %s -> %s (metric %d)", orNone(active), orNone(newActive), metric)
+				data.wanEvents = append(data.wanEvents, WANEvent{now, msg})
+				data.addAlert("wan-failover", msg, now)
+				active = newActive
+			}
+			data.mu.Unlock()
+		}
+	}
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+func printWANReport(links []*WANLink, events []WANEvent) {
+	if len(links) == 0 {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("WAN LINKS")
+	for _, l := range links {
+		fmt.Printf("%s: ↓ %.2f MB / ↑ %.2f MB | active %s\n", l.Name, l.RxBytes/(1024*1024), l.TxBytes/(1024*1024), l.Active.Round(time.Second))
+	}
+	for _, e := range events {
+		fmt.Printf("%s %s\n", e.Time.Format("15:04:05"), e.Message)
+	}
+}
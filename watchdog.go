@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"netwatchd/netwatch"
+)
+
+// ExpectedFlow is traffic that must show up, either inside a daily window
+// ("02:00-04:00") or at least once every period ("5m")
+type ExpectedFlow struct {
+	Name       string `json:"name"`
+	Src        string `json:"src,omitempty"`
+	Dst        string `json:"dst,omitempty"`
+	Port       int    `json:"port,omitempty"`
+	Window     string `json:"window,omitempty"`
+	Every      string `json:"every,omitempty"`
+	MinBytes   string `json:"min_bytes,omitempty"`
+	MinPackets int    `json:"min_packets,omitempty"`
+}
+
+// Parsing sizes like 1GB, 500MB, 64k or plain bytes
+func parseBytes(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(s), "B"))
+	suffixes := []struct {
+		unit string
+		mult int64
+	}{{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40}}
+	mult := int64(1)
+	for _, u := range suffixes {
+		if strings.HasSuffix(s, u.unit) {
+			mult, s = u.mult, strings.TrimSuffix(s, u.unit)
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q, use e.g. 500MB or 1GB", s)
+	}
+	return int64(v * float64(mult)), nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, use HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// dailyWindow is a local time of day range like 02:00-04:00, it may cross midnight
+type dailyWindow struct {
+	from, to time.Duration
+}
+
+func parseDailyWindow(s string) (dailyWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return dailyWindow{}, fmt.Errorf("window %q must be HH:MM-HH:MM", s)
+	}
+	var w dailyWindow
+	var err error
+	if w.from, err = parseClock(from); err != nil {
+		return dailyWindow{}, err
+	}
+	if w.to, err = parseClock(to); err != nil {
+		return dailyWindow{}, err
+	}
+	return w, nil
+}
+
+// The occurrence of the window containing t, ok is false outside it
+func (w dailyWindow) occurrence(t time.Time) (start, end time.Time, ok bool) {
+	y, m, d := t.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	length := w.to - w.from
+	if length <= 0 {
+		length += 24 * time.Hour
+	}
+	// A window crossing midnight may have started yesterday
+	for _, day := range []time.Time{midnight, midnight.AddDate(0, 0, -1)} {
+		start = day.Add(w.from)
+		end = start.Add(length)
+		if !t.Before(start) && t.Before(end) {
+			return start, end, true
+		}
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// watchOccurrence is one window or period an expected flow was checked in
+type watchOccurrence struct {
+	Start, End time.Time
+	Bytes      int64
+	Packets    int
+	Partial    bool // capture started after the occurrence did
+	Missing    bool
+}
+
+// flowWatch is the running state of one ExpectedFlow
+type flowWatch struct {
+	spec      ExpectedFlow
+	src, dst  *net.IPNet
+	minBytes  int64
+	window    dailyWindow
+	every     time.Duration
+	current   *watchOccurrence
+	evaluated []watchOccurrence
+}
+
+// Watchdog alerts when expected traffic does not happen. It is guarded by the MonitoringData mutex.
+type Watchdog struct {
+	start   time.Time
+	watches []*flowWatch
+}
+
+func (e ExpectedFlow) validate() error {
+	_, err := e.compile()
+	return err
+}
+
+func (e ExpectedFlow) compile() (*flowWatch, error) {
+	if e.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	w := &flowWatch{spec: e, minBytes: 1}
+	var err error
+	if w.src, err = netwatch.MatchNet(e.Src); err != nil {
+		return nil, fmt.Errorf("src: %v", err)
+	}
+	if w.dst, err = netwatch.MatchNet(e.Dst); err != nil {
+		return nil, fmt.Errorf("dst: %v", err)
+	}
+	if e.MinBytes != "" {
+		if w.minBytes, err = parseBytes(e.MinBytes); err != nil {
+			return nil, fmt.Errorf("min_bytes: %v", err)
+		}
+	}
+	if e.MinPackets > 0 && e.MinBytes == "" {
+		w.minBytes = 0
+	}
+
+	switch {
+	case e.Window != "" && e.Every != "":
+		return nil, errors.New("use either window or every")
+	case e.Window != "":
+		if w.window, err = parseDailyWindow(e.Window); err != nil {
+			return nil, err
+		}
+	case e.Every != "":
+		if w.every, err = time.ParseDuration(e.Every); err != nil || w.every <= 0 {
+			return nil, fmt.Errorf("every %q is not a duration", e.Every)
+		}
+	default:
+		return nil, errors.New("window or every is required")
+	}
+	return w, nil
+}
+
+func NewWatchdog(specs []ExpectedFlow, start time.Time) (*Watchdog, error) {
+	d := &Watchdog{start: start}
+	for _, s := range specs {
+		w, err := s.compile()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", s.Name, err)
+		}
+		d.watches = append(d.watches, w)
+	}
+	return d, nil
+}
+
+// The window or period containing t, ok is false outside any daily window
+func (w *flowWatch) occurrence(t, captureStart time.Time) (start, end time.Time, ok bool) {
+	if w.every > 0 {
+		k := t.Sub(captureStart) / w.every
+		start = captureStart.Add(k * w.every)
+		return start, start.Add(w.every), true
+	}
+	return w.window.occurrence(t)
+}
+
+func (w *flowWatch) matches(p *netwatch.Packet) bool {
+	if w.spec.Port != 0 && p.DstPort != w.spec.Port && p.SrcPort != w.spec.Port {
+		return false
+	}
+	if w.src != nil && !w.src.Contains(net.ParseIP(p.SrcIP)) {
+		return false
+	}
+	if w.dst != nil && !w.dst.Contains(net.ParseIP(p.DstIP)) {
+		return false
+	}
+	return true
+}
+
+func (d *Watchdog) Observe(p *netwatch.Packet) {
+	if d == nil || p.SrcIP == "" {
+		return
+	}
+	for _, w := range d.watches {
+		if !w.matches(p) {
+			continue
+		}
+		start, end, ok := w.occurrence(p.Time, d.start)
+		if !ok {
+			continue
+		}
+		if w.current == nil || !w.current.Start.Equal(start) {
+			w.current = &watchOccurrence{Start: start, End: end, Partial: start.Before(d.start)}
+		}
+		w.current.Bytes += int64(p.Length)
+		w.current.Packets++
+	}
+}
+
+// Evaluating every occurrence that ended by now, returning alert messages
+func (d *Watchdog) Check(now time.Time) []string {
+	if d == nil {
+		return nil
+	}
+	var alerts []string
+	for _, w := range d.watches {
+		// Walk occurrences from the last evaluated one (or capture start) up to now
+		from := d.start
+		if n := len(w.evaluated); n > 0 {
+			from = w.evaluated[n-1].End
+		}
+		for t := from; t.Before(now); {
+			start, end, ok := w.occurrence(t, d.start)
+			if !ok {
+				t = t.Add(time.Minute)
+				continue
+			}
+			if end.After(now) {
+				break
+			}
+			occ := watchOccurrence{Start: start, End: end, Partial: start.Before(d.start)}
+			if w.current != nil && w.current.Start.Equal(start) {
+				occ = *w.current
+			}
+			occ.Missing = occ.Bytes < w.minBytes || occ.Packets < w.spec.MinPackets
+			w.evaluated = append(w.evaluated, occ)
+			// Traffic may have happened before the capture started
+			if occ.Missing && !occ.Partial {
+				alerts = append(alerts, fmt.Sprintf("expected traffic %q did not occur between %s and %s (%.2f MB, %d packets)",
+					w.spec.Name, start.Format("15:04"), end.Format("15:04"), float64(occ.Bytes)/(1024*1024), occ.Packets))
+			}
+			t = end
+		}
+	}
+	return alerts
+}
+
+func printWatchdogReport(d *Watchdog, end time.Time) {
+	if d == nil {
+		return
+	}
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("EXPECTED TRAFFIC")
+	for _, w := range d.watches {
+		when := "every " + w.spec.Every
+		if w.spec.Window != "" {
+			when = "daily " + w.spec.Window
+		}
+		missing := 0
+		for _, o := range w.evaluated {
+			if o.Missing && !o.Partial {
+				missing++
+			}
+		}
+		fmt.Printf("%s (%s): %d checked, %d missing\n", w.spec.Name, when, len(w.evaluated), missing)
+		for _, o := range w.evaluated {
+			status := "OK"
+			switch {
+			case o.Missing && o.Partial:
+				status = "PARTIAL (capture started inside the window)"
+			case o.Missing:
+				status = "MISSING"
+			}
+			fmt.Printf("  %s-%s: %.2f MB, %d packets %s\n", o.Start.Format("15:04"), o.End.Format("15:04"),
+				float64(o.Bytes)/(1024*1024), o.Packets, status)
+		}
+		if c := w.current; c != nil && c.End.After(end) {
+			fmt.Printf("  %s-%s: %.2f MB, %d packets so far (in progress)\n", c.Start.Format("15:04"), c.End.Format("15:04"),
+				float64(c.Bytes)/(1024*1024), c.Packets)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"netwatchd/netwatch"
+)
+
+// WeakUsage is traffic of one legacy protocol between a client and a server
+type WeakUsage struct {
+	Protocol string
+	Client   string
+	Server   string
+	Port     int
+	Packets  int
+	Bytes    int64
+}
+
+// WeakProtocolTracker records legacy and cleartext protocol usage.
+// It is guarded by the MonitoringData mutex.
+type WeakProtocolTracker struct {
+	usage map[string]*WeakUsage
+}
+
+func NewWeakProtocolTracker() *WeakProtocolTracker {
+	return &WeakProtocolTracker{usage: make(map[string]*WeakUsage)}
+}
+
+var weakTLSVersions = map[string]string{
+	"0x0300": "SSLv3",
+	"0x0301": "TLS 1.0",
+	"0x0302": "TLS 1.1",
+}
+
+// Classifying a packet as a weak protocol, empty when it isn't one
+func weakProtocol(p *netwatch.Packet) string {
+	switch {
+	case p.HasLayer("smb"):
+		return "SMBv1"
+	case p.HasLayer("telnet"):
+		return "Telnet"
+	case p.HasLayer("ftp"), p.HasLayer("ftp-data"):
+		return "FTP"
+	case p.HasLayer("tftp"):
+		return "TFTP"
+	case weakTLSVersions[p.TLSVersion] != "":
+		return weakTLSVersions[p.TLSVersion]
+	// SNMP version 0 is v1 and 1 is v2c, both send the community in clear
+	case p.HasLayer("snmp") && (p.SNMPVersion == "0" || p.SNMPVersion == "1"):
+		return "SNMPv1/v2c"
+	case p.BasicAuth:
+		return "HTTP Basic auth"
+	case (p.HasLayer("pop") || p.HasLayer("imap")) && !p.HasLayer("tls"):
+		return "Cleartext mail"
+	}
+	return ""
+}
+
+func (t *WeakProtocolTracker) Observe(p *netwatch.Packet) {
+	proto := weakProtocol(p)
+	if proto == "" || p.SrcIP == "" {
+		return
+	}
+
+	// The server side is the well known, lower port. A ServerHello comes from the server.
+	client, server, port := p.SrcIP, p.DstIP, p.DstPort
+	if p.TLSVersion != "" || (p.SrcPort != 0 && p.SrcPort < p.DstPort) {
+		client, server, port = p.DstIP, p.SrcIP, p.SrcPort
+	}
+
+	key := proto + " " + client + " " + server + " " + fmt.Sprint(port)
+	u, ok := t.usage[key]
+	if !ok {
+		u = &WeakUsage{Protocol: proto, Client: client, Server: server, Port: port}
+		t.usage[key] = u
+	}
+	u.Packets++
+	u.Bytes += int64(p.Length)
+}
+
+func (t *WeakProtocolTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.usage = make(map[string]*WeakUsage)
+}
+
+func printWeakProtocolReport(t *WeakProtocolTracker) {
+	if len(t.usage) == 0 {
+		return
+	}
+
+	var usage []*WeakUsage
+	for _, u := range t.usage {
+		usage = append(usage, u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Protocol != usage[j].Protocol {
+			return usage[i].Protocol < usage[j].Protocol
+		}
+		return usage[i].Bytes > usage[j].Bytes
+	})
+
+	fmt.Println(strings.Repeat("-", 60))
+	fmt.Println("WEAK PROTOCOLS")
+	last := ""
+	for _, u := range usage {
+		if u.Protocol != last {
+			fmt.Printf("%s:\n", u.Protocol)
+			last = u.Protocol
+		}
+		fmt.Printf("  %s -> %s:%d: %d packets | %.2f MB\n", u.Client, u.Server, u.Port, u.Packets, float64(u.Bytes)/(1024*1024))
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+//go:embed netwatchd-dashboard.html
+var dashboardPage []byte
+
+// webSnapshot is what the dashboard receives every second
+type webSnapshot struct {
+	Interface     string         `json:"interface"`
+	Filter        string         `json:"filter,omitempty"`
+	Start         time.Time      `json:"start"`
+	Time          time.Time      `json:"time"`
+	BucketSeconds int            `json:"bucket_seconds"`
+	Paused        bool           `json:"paused"`
+	Packets       int64          `json:"captured_packets"`
+	Bytes         int64          `json:"captured_bytes"`
+	Hosts         int            `json:"hosts"`
+	Buckets       []ReportBucket `json:"buckets"`
+	Current       ReportBucket   `json:"current"`
+	PerSecond     []int          `json:"packets_per_second"` // the last five minutes, complete seconds only
+	Alerts        []Alert        `json:"alerts,omitempty"`   // the last ten
+}
+
+// Taking a snapshot of the running capture. Callers hold data.mu.
+func newWebSnapshot(data *MonitoringData, now time.Time) webSnapshot {
+	currentStart := data.buckets.Current.Start
+	s := webSnapshot{
+		Interface:     data.captureInterface,
+		Filter:        data.captureFilter,
+		Start:         data.startTime,
+		Time:          now,
+		BucketSeconds: int(data.bucket.Seconds()),
+		Paused:        data.paused,
+		Packets:       data.capturedPackets,
+		Bytes:         data.capturedBytes,
+		Hosts:         data.inventory.Len(),
+		Buckets:       reportBuckets(data, currentStart),
+		Current: ReportBucket{
+			Start:   currentStart,
+			Seconds: now.Sub(currentStart).Seconds(),
+			Packets: data.buckets.Current.Packets,
+			Bytes:   data.currentBandwidth,
+		},
+	}
+	if n := len(data.perSecond) - 1; n > 0 {
+		s.PerSecond = append([]int(nil), data.perSecond[max(n-300, 0):n]...)
+	}
+	if n := len(data.alerts); n > 0 {
+		s.Alerts = append([]Alert(nil), data.alerts[max(n-10, 0):]...)
+	}
+	return s
+}
+
+// Serving the dashboard page, a JSON snapshot and a server-sent event stream
+// of snapshots until the capture ends, the devices, alerts and flows API and
+// with a -db database the export API
+func runWebDashboard(ctx context.Context, data *MonitoringData, addr, dbPath string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardPage)
+	})
+	mux.HandleFunc("/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		data.mu.Lock()
+		s := newWebSnapshot(data, time.Now())
+		data.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			data.mu.Lock()
+			s := newWebSnapshot(data, time.Now())
+			data.mu.Unlock()
+			b, err := json.Marshal(s)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+				return
+			}
+			flusher.Flush()
+
+			select {
+			case <-ctx.Done():
+				// Telling the page the capture is over so it stops reconnecting
+				fmt.Fprint(w, "event: end\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+
+	mux.Handle("/api/v1/devices", apiHandler(data, data.apiDevices))
+	mux.Handle("/api/v1/alerts", apiHandler(data, data.apiAlerts))
+	mux.Handle("/api/v1/flows", apiHandler(data, data.apiFlows))
+	if dbPath != "" {
+		mux.Handle("/api/v1/export", exportHandler(dbPath))
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Error("failed to start the web dashboard", "addr", addr, "err", err)
+		return
+	}
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	fmt.Printf("Web dashboard on http://%s/\n", dashboardURLHost(ln.Addr()))
+	if dbPath != "" {
+		fmt.Printf("Export API on http://%s/api/v1/export\n", dashboardURLHost(ln.Addr()))
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("web dashboard stopped", "err", err)
+	}
+}
+
+// ":8080" listens everywhere, the link points at localhost then
+func dashboardURLHost(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// yamlParser reads the part of YAML config files need: block mappings and
+// sequences, flow collections, quoted and block scalars and comments.
+// Anchors, tags and multiple documents are refused.
+type yamlParser struct {
+	lines []string
+	pos   int
+}
+
+func parseYAML(raw []byte) (any, error) {
+	p := &yamlParser{lines: strings.Split(strings.ReplaceAll(string(raw), "\r\n", "\n"), "\n")}
+	ind, text, ok := p.peek()
+	if ok && text == "---" {
+		p.pos++
+		ind, _, ok = p.peek()
+	}
+	if !ok {
+		return nil, nil
+	}
+	v, err := p.node(ind)
+	if err != nil {
+		return nil, err
+	}
+	if _, text, ok := p.peek(); ok && text != "..." {
+		return nil, p.errorf("unexpected %q", text)
+	}
+	return v, nil
+}
+
+func (p *yamlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+// The indentation and text without comment of the next line with content
+func (p *yamlParser) peek() (int, string, bool) {
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		text := strings.TrimLeft(line, " ")
+		if text = yamlStripComment(text); text != "" {
+			return len(line) - len(strings.TrimLeft(line, " ")), text, true
+		}
+	}
+	return 0, "", false
+}
+
+func yamlSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// A block mapping, sequence or a scalar on its own at indent
+func (p *yamlParser) node(indent int) (any, error) {
+	_, text, _ := p.peek()
+	if strings.HasPrefix(text, "\t") {
+		return nil, p.errorf("tabs are not allowed for indentation")
+	}
+	if yamlSeqItem(text) {
+		return p.sequence(indent)
+	}
+	if _, _, ok := yamlSplitKey(text); ok {
+		return p.mapping(indent)
+	}
+	p.pos++
+	return p.value(text, indent)
+}
+
+func (p *yamlParser) mapping(indent int) (any, error) {
+	m := make(map[string]any)
+	for {
+		ind, text, ok := p.peek()
+		if !ok || ind < indent {
+			return m, nil
+		}
+		if ind > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		key, rest, ok := yamlSplitKey(text)
+		if !ok {
+			if yamlSeqItem(text) {
+				return m, nil
+			}
+			return nil, p.errorf("expected key: value, not %q", text)
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		p.pos++
+		v, err := p.value(rest, indent)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+}
+
+func (p *yamlParser) sequence(indent int) (any, error) {
+	list := []any{}
+	for {
+		ind, text, ok := p.peek()
+		if !ok || ind < indent || (ind == indent && !yamlSeqItem(text)) {
+			return list, nil
+		}
+		if ind > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		item := strings.TrimLeft(text[1:], " ")
+		if item == "" {
+			// A bare dash with the item on the lines below
+			p.pos++
+			var v any
+			if next, _, ok := p.peek(); ok && next > indent {
+				var err error
+				if v, err = p.node(next); err != nil {
+					return nil, err
+				}
+			}
+			list = append(list, v)
+			continue
+		}
+		// "- key: value" starts a mapping indented by where its key is, the
+		// line is reread as if the dash were a space
+		offset := ind + len(text) - len(item)
+		if _, _, isKey := yamlSplitKey(item); isKey || yamlSeqItem(item) {
+			p.lines[p.pos] = strings.Repeat(" ", offset) + item
+			v, err := p.node(offset)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			continue
+		}
+		p.pos++
+		v, err := p.value(item, indent)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+}
+
+// The value after a key or dash, rest is what followed on the same line
+func (p *yamlParser) value(rest string, indent int) (any, error) {
+	switch {
+	case rest == "":
+		ind, text, ok := p.peek()
+		switch {
+		case ok && ind > indent:
+			return p.node(ind)
+		case ok && ind == indent && yamlSeqItem(text):
+			// Sequences may sit at the indentation of their key
+			return p.sequence(ind)
+		}
+		return nil, nil
+	case rest[0] == '|' || rest[0] == '>':
+		return p.blockScalar(rest, indent)
+	case rest[0] == '[' || rest[0] == '{':
+		// Flow collections may go on over several lines
+		for yamlFlowDepth(rest) > 0 && p.pos < len(p.lines) {
+			rest += " " + yamlStripComment(strings.TrimSpace(p.lines[p.pos]))
+			p.pos++
+		}
+		f := &yamlFlow{s: rest}
+		v, err := f.value()
+		if err == nil && strings.TrimSpace(f.s[f.i:]) != "" {
+			err = fmt.Errorf("unexpected %q after %c", f.s[f.i:], rest[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", p.pos, err)
+		}
+		return v, nil
+	case rest[0] == '&' || rest[0] == '*' || rest[0] == '!':
+		return nil, fmt.Errorf("line %d: anchors, aliases and tags are not supported", p.pos)
+	}
+	v, tail, err := yamlScalar(rest, false)
+	if err == nil && tail != "" {
+		err = fmt.Errorf("unexpected %q", tail)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %v", p.pos, err)
+	}
+	return v, nil
+}
+
+// Literal (|) and folded (>) scalars with their chomping and indentation indicators
+func (p *yamlParser) blockScalar(header string, indent int) (any, error) {
+	style, chomp, explicit := header[0], byte(0), 0
+	for _, c := range header[1:] {
+		switch {
+		case c == '-' || c == '+':
+			chomp = byte(c)
+		case c >= '1' && c <= '9':
+			explicit = int(c - '0')
+		default:
+			return nil, fmt.Errorf("line %d: invalid block scalar header %q", p.pos, header)
+		}
+	}
+	contentIndent := 0
+	if explicit > 0 {
+		contentIndent = indent + explicit
+	}
+	var lines []string
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		trimmed := strings.TrimLeft(line, " ")
+		ind := len(line) - len(trimmed)
+		if trimmed == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if contentIndent == 0 {
+			if ind <= indent {
+				break
+			}
+			contentIndent = ind
+		}
+		if ind < contentIndent {
+			break
+		}
+		lines = append(lines, line[contentIndent:])
+	}
+
+	// Trailing empty lines only matter for keeping
+	end := len(lines)
+	for end > 0 && lines[end-1] == "" {
+		end--
+	}
+	trailing := len(lines) - end
+	lines = lines[:end]
+
+	var b strings.Builder
+	for i, l := range lines {
+		if i > 0 {
+			// Folding joins lines with a space and drops the break before
+			// empty lines, more indented lines stay as they are
+			prev := lines[i-1]
+			plain := prev != "" && prev[0] != ' ' && (l == "" || l[0] != ' ')
+			switch {
+			case style == '>' && plain && l != "":
+				b.WriteByte(' ')
+			case style == '>' && plain:
+			default:
+				b.WriteByte('\n')
+			}
+		}
+		b.WriteString(l)
+	}
+	s := b.String()
+	switch {
+	case chomp == '-' || s == "":
+	case chomp == '+':
+		s += strings.Repeat("\n", trailing+1)
+	default:
+		s += "\n"
+	}
+	return s, nil
+}
+
+// Cutting a comment: # at the start or after a space, outside quotes
+func yamlStripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote == '\'' && c == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && yamlTokenStart(s, i):
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return strings.TrimRight(s[:i], " \t")
+		}
+	}
+	return strings.TrimRight(s, " \t")
+}
+
+// Quotes only open a quoted scalar at the start of one
+func yamlTokenStart(s string, i int) bool {
+	prev := strings.TrimRight(s[:i], " \t")
+	return prev == "" || strings.ContainsAny(prev[len(prev)-1:], ":-[{,?")
+}
+
+// Splitting "key: rest", keys are plain or quoted
+func yamlSplitKey(text string) (string, string, bool) {
+	if text == "" || yamlSeqItem(text) || strings.ContainsRune("[{|>&*!%@`", rune(text[0])) {
+		return "", "", false
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		v, tail, err := yamlScalar(text, true)
+		s, isString := v.(string)
+		if err != nil || !isString || !strings.HasPrefix(tail, ":") || (len(tail) > 1 && tail[1] != ' ') {
+			return "", "", false
+		}
+		return s, strings.TrimSpace(tail[1:]), true
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+var (
+	yamlInt   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlFloat = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+)
+
+// A scalar at the start of s with what follows it. Plain scalars run to the
+// end, or to a flow indicator inside a flow collection.
+func yamlScalar(s string, flow bool) (any, string, error) {
+	switch s[0] {
+	case '"':
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				v, err := yamlUnescape(s[1:i])
+				return v, strings.TrimSpace(s[i+1:]), err
+			}
+		}
+		return nil, "", errors.New("unterminated double quoted string")
+	case '\'':
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				b.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			return b.String(), strings.TrimSpace(s[i+1:]), nil
+		}
+		return nil, "", errors.New("unterminated single quoted string")
+	}
+
+	plain, tail := s, ""
+	if flow {
+		if i := strings.IndexAny(s, ",]}"); i >= 0 {
+			plain, tail = s[:i], s[i:]
+		}
+	}
+	plain = strings.TrimSpace(plain)
+	switch plain {
+	case "", "~", "null", "Null", "NULL":
+		return nil, tail, nil
+	case "true", "True", "TRUE":
+		return true, tail, nil
+	case "false", "False", "FALSE":
+		return false, tail, nil
+	}
+	switch {
+	case yamlInt.MatchString(plain):
+		if n, err := strconv.ParseInt(plain, 10, 64); err == nil {
+			return n, tail, nil
+		}
+	case strings.HasPrefix(plain, "0x") || strings.HasPrefix(plain, "0o"):
+		if n, err := strconv.ParseInt(plain, 0, 64); err == nil {
+			return n, tail, nil
+		}
+	case yamlFloat.MatchString(plain):
+		if f, err := strconv.ParseFloat(plain, 64); err == nil {
+			return f, tail, nil
+		}
+	}
+	return plain, tail, nil
+}
+
+func yamlUnescape(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", errors.New("trailing backslash")
+		}
+		if r, ok := map[byte]string{'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", '\t': "\t", 'n': "\n", 'v': "\v", 'f': "\f",
+			'r': "\r", 'e': "\x1b", ' ': " ", '"': "\"", '/': "/", '\\': "\\", 'N': "\u0085", '_': " ", 'L': " ", 'P': " "}[s[i]]; ok {
+			b.WriteString(r)
+			continue
+		}
+		size := map[byte]int{'x': 2, 'u': 4, 'U': 8}[s[i]]
+		if size == 0 || i+1+size > len(s) {
+			return "", fmt.Errorf("invalid escape \\%c", s[i])
+		}
+		n, err := strconv.ParseUint(s[i+1:i+1+size], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(n)) {
+			return "", fmt.Errorf("invalid escape \\%s", s[i:i+1+size])
+		}
+		b.WriteRune(rune(n))
+		i += size
+	}
+	return b.String(), nil
+}
+
+// How many flow collections are still open at the end of s
+func yamlFlowDepth(s string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+	}
+	return depth
+}
+
+// yamlFlow parses [a, b] and {k: v} collections
+type yamlFlow struct {
+	s string
+	i int
+}
+
+func (f *yamlFlow) skip() {
+	for f.i < len(f.s) && f.s[f.i] == ' ' {
+		f.i++
+	}
+}
+
+func (f *yamlFlow) value() (any, error) {
+	f.skip()
+	if f.i == len(f.s) {
+		return nil, errors.New("unexpected end of flow collection")
+	}
+	switch f.s[f.i] {
+	case '[':
+		f.i++
+		list := []any{}
+		for {
+			f.skip()
+			if f.i < len(f.s) && f.s[f.i] == ']' {
+				f.i++
+				return list, nil
+			}
+			v, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			if err := f.separator(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		f.i++
+		m := make(map[string]any)
+		for {
+			f.skip()
+			if f.i < len(f.s) && f.s[f.i] == '}' {
+				f.i++
+				return m, nil
+			}
+			key, err := f.key()
+			if err != nil {
+				return nil, err
+			}
+			v, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+			if err := f.separator('}'); err != nil {
+				return nil, err
+			}
+		}
+	}
+	v, tail, err := yamlScalar(f.s[f.i:], true)
+	if err != nil {
+		return nil, err
+	}
+	f.i = len(f.s) - len(tail)
+	return v, nil
+}
+
+// A key of a flow mapping up to its colon
+func (f *yamlFlow) key() (string, error) {
+	rest := f.s[f.i:]
+	if rest != "" && (rest[0] == '"' || rest[0] == '\'') {
+		v, tail, err := yamlScalar(rest, true)
+		if err != nil {
+			return "", err
+		}
+		f.i = len(f.s) - len(tail)
+		f.skip()
+		if f.i == len(f.s) || f.s[f.i] != ':' {
+			return "", errors.New("expected : after key")
+		}
+		f.i++
+		return v.(string), nil
+	}
+	end := strings.Index(rest, ":")
+	if end < 0 || strings.ContainsAny(rest[:end], ",[]{}") {
+		return "", errors.New("expected key: value")
+	}
+	f.i += end + 1
+	return strings.TrimSpace(rest[:end]), nil
+}
+
+// After an item a comma or the closing bracket
+func (f *yamlFlow) separator(closing byte) error {
+	f.skip()
+	if f.i == len(f.s) {
+		return fmt.Errorf("missing %c", closing)
+	}
+	switch f.s[f.i] {
+	case ',':
+		f.i++
+		return nil
+	case closing:
+		return nil
+	}
+	return fmt.Errorf("expected , or %c", closing)
+}
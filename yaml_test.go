@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want any
+	}{
+		{"empty", "# nothing\n", nil},
+		{"scalars", "a: 7\nb: 1.5\nc: true\nd: eth0\ne: 0x10\n", map[string]any{"a": int64(7), "b": 1.5, "c": true, "d": "eth0", "e": int64(16)}},
+		{"quoting", "a: 'it''s # not a comment'\nb: \"tab\\there\"\nc: \"7\"\n", map[string]any{"a": "it's # not a comment", "b": "tab\there", "c": "7"}},
+		{"comments", "---\n# top\na: 1 # trailing\n\n  # indented\nb: x#y\n", map[string]any{"a": int64(1), "b": "x#y"}},
+		{"nested maps", "a:\n  b:\n    c: 1\n  d: two\n", map[string]any{"a": map[string]any{"b": map[string]any{"c": int64(1)}, "d": "two"}}},
+		{"lists", "a:\n  - 1\n  - two\nb: [x, 'y', 3]\nc:\n  - name: n\n    port: 80\n", map[string]any{
+			"a": []any{int64(1), "two"}, "b": []any{"x", "y", int64(3)}, "c": []any{map[string]any{"name": "n", "port": int64(80)}}}},
+		{"flow map", "a: {b: 1, c: [d]}\n", map[string]any{"a": map[string]any{"b": int64(1), "c": []any{"d"}}}},
+		{"block scalars", "a: |\n  l1\n  l2\nb: >\n  w1\n  w2\n", map[string]any{"a": "l1\nl2\n", "b": "w1 w2\n"}},
+		{"crlf", "a: 1\r\nb: 2\r\n", map[string]any{"a": int64(1), "b": int64(2)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYAML([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	for _, in := range []string{
+		"a: &anchor 1\n",
+		"a: !!str 1\n",
+		"a: 1\n---\nb: 2\n",
+		"a: 'unterminated\n",
+		"a: [1, 2\n",
+		"a:\n  b: 1\n c: 2\n",
+	} {
+		if v, err := parseYAML([]byte(in)); err == nil {
+			t.Errorf("%q parsed as %#v", in, v)
+		}
+	}
+}